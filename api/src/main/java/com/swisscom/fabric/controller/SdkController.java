@@ -736,7 +736,7 @@ public class SdkController extends AbstractRestController {
 
   private static final String TEST_USER = "test_user1";
   private static final String TEST_ROLE = "garage";
-  private static final String TEST_VIN = "WVW ZZZ 6RZ HY26 0780";
+  private static final String TEST_VIN = "WVWZZZ6R6HY260780";
 
   @RequestMapping(value = "/createCar", method = RequestMethod.GET)
   public ErrorInfo createCar() throws ProposalException, InvalidArgumentException {
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Insurer accreditation registry.
+ *
+ * The regulator accredits insurers with 'accreditInsurer'
+ * and suspends or reinstates them. Accreditations are
+ * public and kept under 'accreditedInsurer~<insurer>'.
+ *
+ * Owners only propose insurance to accredited insurers,
+ * and cover only starts with an accredited insurer that
+ * is not suspended: accepting proposals and quotes,
+ * setting policies, issuing cover notes and receiving a
+ * portfolio. Cars insured by a suspended insurer stay
+ * insured until their policy ends.
+ */
+
+// object type of insurer accreditation keys
+const accreditationObjectType string = "accreditedInsurer"
+
+// accreditation states
+const accreditationActive string = "active"
+const accreditationSuspended string = "suspended"
+
+/*
+ * Returns the ledger key of the accreditation of 'insurer'
+ */
+func getAccreditationKey(stub shim.ChaincodeStubInterface, insurer string) (string, error) {
+	key, err := stub.CreateCompositeKey(accreditationObjectType, []string{insurer})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating accreditation key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the accreditation of 'insurer'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getAccreditation(stub shim.ChaincodeStubInterface, insurer string) (*Accreditation, error) {
+	key, err := getAccreditationKey(stub, insurer)
+	if err != nil {
+		return nil, err
+	}
+
+	accreditationAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading accreditation")
+	} else if accreditationAsBytes == nil {
+		return nil, nil
+	}
+
+	accreditation := Accreditation{}
+	err = ledgerjson.Unmarshal(accreditationAsBytes, &accreditation)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing accreditation")
+	}
+
+	return &accreditation, nil
+}
+
+/*
+ * Writes an accreditation to ledger
+ */
+func saveAccreditation(stub shim.ChaincodeStubInterface, accreditation *Accreditation) error {
+	key, err := getAccreditationKey(stub, accreditation.Insurer)
+	if err != nil {
+		return err
+	}
+
+	accreditationAsBytes, _ := ledgerjson.Marshal(accreditation)
+	err = stub.PutState(key, accreditationAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing accreditation")
+	}
+
+	return nil
+}
+
+/*
+ * Fails unless 'insurer' is accredited and not suspended
+ */
+func requireAccreditedInsurer(stub shim.ChaincodeStubInterface, insurer string) error {
+	accreditation, err := getAccreditation(stub, insurer)
+	if err != nil {
+		return err
+	} else if accreditation == nil {
+		return newErrorWithDetails(ErrForbidden, fmt.Sprintf("Forbidden: insurer '%s' is not accredited", insurer), map[string]string{"insurer": insurer})
+	} else if accreditation.Status != accreditationActive {
+		return newErrorWithDetails(ErrForbidden, fmt.Sprintf("Forbidden: insurer '%s' is suspended", insurer), map[string]string{"insurer": insurer})
+	}
+
+	return nil
+}
+
+/*
+ * Accredits 'insurer' under licence 'licence'.
+ *
+ * On success,
+ * returns the accreditation.
+ */
+func (t *CarChaincode) accreditInsurer(stub shim.ChaincodeStubInterface, regulator string, insurer string, licence string) pb.Response {
+	if insurer == "" || licence == "" {
+		return errorResponse(ErrInvalidArgument, "'accreditInsurer' expects a non-empty insurer and licence")
+	}
+
+	accreditation, err := getAccreditation(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if accreditation != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Insurer '%s' is accredited already", insurer))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	accreditation = &Accreditation{
+		Insurer:      insurer,
+		Licence:      licence,
+		Status:       accreditationActive,
+		AccreditedBy: regulator,
+		AccreditedTs: now}
+
+	err = saveAccreditation(stub, accreditation)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Insurer '%s' is accredited under licence '%s'\n", insurer, licence)
+
+	accreditationAsBytes, _ := ledgerjson.Marshal(accreditation)
+	return shim.Success(accreditationAsBytes)
+}
+
+/*
+ * Suspends or reinstates the accreditation of 'insurer'.
+ * Suspending needs a reason.
+ *
+ * On success,
+ * returns the accreditation.
+ */
+func (t *CarChaincode) setAccreditationStatus(stub shim.ChaincodeStubInterface, regulator string, insurer string, status string, reason string) pb.Response {
+	if status == accreditationSuspended && reason == "" {
+		return errorResponse(ErrInvalidArgument, "'suspendInsurer' expects a non-empty reason")
+	}
+
+	accreditation, err := getAccreditation(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if accreditation == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("Insurer '%s' is not accredited", insurer))
+	} else if accreditation.Status == status {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Insurer '%s' is %s already", insurer, status))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	accreditation.Status = status
+	accreditation.Changes = append(accreditation.Changes, AccreditationChange{Status: status, Reason: reason, By: regulator, Ts: now})
+
+	err = saveAccreditation(stub, accreditation)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Accreditation of insurer '%s' is %s\n", insurer, status)
+
+	accreditationAsBytes, _ := ledgerjson.Marshal(accreditation)
+	return shim.Success(accreditationAsBytes)
+}
+
+/*
+ * Lists all accredited insurers, including
+ * suspended ones.
+ *
+ * On success,
+ * returns the accreditations by insurer.
+ */
+func (t *CarChaincode) getAccreditedInsurers(stub shim.ChaincodeStubInterface) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(accreditationObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading accreditations")
+	}
+	defer iterator.Close()
+
+	accreditations := []Accreditation{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading accreditations")
+		}
+
+		accreditation := Accreditation{}
+		err = ledgerjson.Unmarshal(kv.Value, &accreditation)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing accreditation")
+		}
+
+		accreditations = append(accreditations, accreditation)
+	}
+
+	accreditationsAsBytes, _ := ledgerjson.Marshal(accreditations)
+	return shim.Success(accreditationsAsBytes)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// accredits insurers not accredited yet
+func accredit(stub *shim.MockStub, insurers ...string) {
+	for _, insurer := range insurers {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("accreditInsurer", "finma", "regulator", insurer, "L-"+insurer))
+	}
+}
+
+func TestInsurerAccreditation(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	// owners only propose insurance to accredited insurers
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("insureProposal", owner, "user", vin, "axa"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("accreditInsurer", "axa", "insurer", "axa", "L-1"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("accreditInsurer", "finma", "regulator", "axa", "L-1"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("accreditInsurer", "finma", "regulator", "axa", "L-2"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("insureProposal", owner, "user", vin, "axa"))
+	if response.Status != shim.OK {
+		t.Fatal("Accredited insurers should get proposals: " + response.Message)
+	}
+
+	// a suspended insurer does not start cover
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("suspendInsurer", "finma", "regulator", "axa", ""))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("suspendInsurer", "finma", "regulator", "axa", "solvency review"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("insuranceAccept", owner, "insurer", vin, "axa"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getAccreditedInsurers", "bobby", "user"))
+	accreditations := []Accreditation{}
+	json.Unmarshal(response.Payload, &accreditations)
+	if len(accreditations) != 1 || accreditations[0].Status != accreditationSuspended || accreditations[0].Changes[0].Reason != "solvency review" {
+		t.Fatalf("Expected the suspended accreditation of 'axa', got %v", accreditations)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reinstateInsurer", "finma", "regulator", "allianz"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("reinstateInsurer", "finma", "regulator", "axa"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reinstateInsurer", "finma", "regulator", "axa"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("insuranceAccept", owner, "insurer", vin, "axa"))
+	if response.Status != shim.OK {
+		t.Fatal("Reinstated insurers should start cover: " + response.Message)
+	}
+}
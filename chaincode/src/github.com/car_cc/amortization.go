@@ -0,0 +1,184 @@
+package main
+
+import (
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Loan amortization.
+ *
+ * The bank sets the terms of a loan when funding it: the
+ * yearly interest rate in basis points, the number of
+ * installments and the days between them. The schedule is
+ * computed from the terms with integer math only, so all
+ * peers agree on it. Every installment repays an equal part
+ * of the principal, the last one the rest, plus the interest
+ * accrued on the remaining principal over the period, rounded
+ * down. Installments fall due every period from the transfer
+ * of the car.
+ *
+ * Payments cover the installments in order, interest first.
+ * A loan with installments due but not paid is in arrears,
+ * paying while in arrears counts as late payment. Both show
+ * as flags in 'getLoanStatus', for the owner, the bank and
+ * the DOT.
+ */
+
+// days between installments by default
+const defaultLoanPeriodDays int = 30
+
+// interest rates are given in basis points per year
+const basisPoints int64 = 10000
+const daysPerYear int64 = 365
+
+// loan status flags
+const loanFlagInArrears string = "in_arrears"
+const loanFlagLatePayment string = "late_payment"
+
+/*
+ * Returns the terms of a loan with defaults
+ * for loans funded without terms
+ */
+func loanTerms(loan *Loan) LoanTerms {
+	terms := loan.Terms
+	if terms.Installments <= 0 {
+		terms.Installments = 1
+	}
+	if terms.PeriodDays <= 0 {
+		terms.PeriodDays = defaultLoanPeriodDays
+	}
+
+	return terms
+}
+
+/*
+ * Computes the amortization schedule of a loan
+ */
+func loanSchedule(loan *Loan) []LoanInstallment {
+	terms := loanTerms(loan)
+	period := int64(terms.PeriodDays) * secondsPerDay
+	share := loan.Amount / terms.Installments
+
+	schedule := []LoanInstallment{}
+	remaining := loan.Amount
+	for i := 1; i <= terms.Installments; i++ {
+		principal := share
+		if i == terms.Installments {
+			principal = remaining
+		}
+
+		interest := int64(remaining) * int64(terms.Rate) * int64(terms.PeriodDays) / (basisPoints * daysPerYear)
+		schedule = append(schedule, LoanInstallment{
+			Number:    i,
+			DueTs:     loan.SecuredTs + int64(i)*period,
+			Principal: principal,
+			Interest:  int(interest)})
+
+		remaining -= principal
+	}
+
+	return schedule
+}
+
+/*
+ * Returns the principal and interest
+ * of all installments of a loan
+ */
+func loanTotal(schedule []LoanInstallment) int {
+	total := 0
+	for _, installment := range schedule {
+		total += installment.Principal + installment.Interest
+	}
+
+	return total
+}
+
+/*
+ * Returns the repayment status of a loan at 'now'
+ */
+func loanStatusAt(loan *Loan, now int64) LoanStatus {
+	schedule := loanSchedule(loan)
+	status := LoanStatus{
+		Loan:        loan.Id,
+		Vin:         loan.Vin,
+		Bank:        loan.Bank,
+		Terms:       loanTerms(loan),
+		Principal:   loan.Amount,
+		Outstanding: loanTotal(schedule) - loan.Repaid,
+		Flags:       []string{},
+		Schedule:    schedule}
+
+	// payments cover the installments in order, interest first
+	paid := loan.Repaid
+	for _, installment := range schedule {
+		due := installment.Principal + installment.Interest
+		covered := due
+		if paid < due {
+			covered = paid
+		}
+		paid -= covered
+
+		if covered > installment.Interest {
+			status.Principal -= covered - installment.Interest
+		}
+
+		if covered < due {
+			if status.NextDueTs == 0 {
+				status.NextDueTs = installment.DueTs
+				status.NextDueAmount = due - covered
+			}
+			if installment.DueTs <= now {
+				status.Arrears += due - covered
+			}
+		}
+	}
+
+	if status.Arrears > 0 {
+		status.Flags = append(status.Flags, loanFlagInArrears)
+	}
+	if loan.LatePayments > 0 {
+		status.Flags = append(status.Flags, loanFlagLatePayment)
+	}
+
+	return status
+}
+
+/*
+ * Returns the repayment status of the loan car 'vin'
+ * is pledged for, to the owner, the bank and the DOT.
+ *
+ * On success,
+ * returns the loan status.
+ */
+func (t *CarChaincode) getLoanStatus(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Lien == nil {
+		return errorResponse(ErrNotFound, "The car is not pledged to a bank")
+	} else if role != "dot" && username != owner && username != car.Lien.Bank {
+		return errorResponse(ErrForbidden, "Forbidden: only the owner, the bank and the DOT can read the loan status")
+	}
+
+	loan, err := getLoan(stub, car.Lien.Loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if loan == nil {
+		return errorResponse(ErrLedger, "Loan of the lien is missing")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	statusAsBytes, _ := ledgerjson.Marshal(loanStatusAt(loan, now))
+	return shim.Success(statusAsBytes)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestLoanAmortization(t *testing.T) {
+	// 10% interest per period of 10 days
+	terms := LoanTerms{Rate: 36500, Installments: 2, PeriodDays: 10}
+	loan := Loan{Id: "1", Amount: 40, SecuredTs: 1000, Terms: terms, Repaid: 10}
+
+	schedule := loanSchedule(&loan)
+	if len(schedule) != 2 || schedule[0].Interest != 4 || schedule[1].Interest != 2 || loanTotal(schedule) != 46 {
+		t.Fatalf("Expected installments of 20 with interest 4 and 2, got %v", schedule)
+	}
+
+	// the payment covers the interest first
+	status := loanStatusAt(&loan, 1000+10*secondsPerDay)
+	if status.Principal != 34 || status.Outstanding != 36 || status.Arrears != 14 || status.NextDueAmount != 14 {
+		t.Errorf("Expected 14 in arrears of 36 outstanding, got %v", status)
+	} else if len(status.Flags) != 1 || status.Flags[0] != loanFlagInArrears {
+		t.Errorf("Expected the loan flagged in arrears, got %v", status.Flags)
+	}
+
+	// on the ledger
+	seller := "amag"
+	buyer := "bobby"
+	bank := "ubs"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", bank, "bank"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	stub.MockInvoke("1", util.ToChaincodeArgs("requestLoan", buyer, "user", vin, bank, "40"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", bank, "bank", "1", "36500", "41", "10"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", bank, "bank", "1", "36500", "2", "10"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("repayLoan", buyer, "user", vin, "24"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getLoanStatus", "mallory", "user", vin))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getLoanStatus", "inspector", "dot", vin))
+	status = LoanStatus{}
+	json.Unmarshal(response.Payload, &status)
+	if status.Principal != 20 || status.Outstanding != 22 || status.NextDueAmount != 22 || status.Arrears != 0 || len(status.Flags) != 0 {
+		t.Errorf("Expected 22 outstanding and nothing in arrears, got %v: %s", status, response.Message)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Appraisals.
+ *
+ * Appraisers certified by the DOT, i.e. registered as oracles
+ * of kind 'appraisal', record the value of a car with the date
+ * and method of the appraisal. Like mileage attestations, each
+ * record carries the hash and MSP of the client identity that
+ * signed the transaction, and is kept under
+ * 'appraisal~<vin>~<appraisal date>~<txid>'. The latest
+ * appraisal is shown in public lookups and dealer inventories.
+ *
+ * Sales financed above the configured appraisal threshold
+ * need a current appraisal covering the financed amount.
+ */
+
+// oracle kind allowed to record appraisals
+const oracleAppraisal string = "appraisal"
+
+// object type of appraisal keys
+const appraisalObjectType string = "appraisal"
+
+// days an appraisal stays current by default
+const defaultAppraisalValidityDays int = 180
+
+/*
+ * Returns the latest appraisal of a car,
+ * or 'nil' if there is none
+ */
+func latestAppraisal(car *Car) *Appraisal {
+	if car.Appraisal.RecordedTs == 0 {
+		return nil
+	}
+
+	appraisal := car.Appraisal
+	return &appraisal
+}
+
+/*
+ * Checks that financing 'amount' on 'car' is backed by a
+ * current appraisal, if the amount is above the threshold
+ */
+func checkAppraisal(config Config, car *Car, amount int, now int64) error {
+	if config.AppraisalThreshold <= 0 || amount <= config.AppraisalThreshold {
+		return nil
+	}
+
+	days := config.AppraisalValidityDays
+	if days <= 0 {
+		days = defaultAppraisalValidityDays
+	}
+
+	appraisal := latestAppraisal(car)
+	if appraisal == nil || appraisal.AppraisedTs+int64(days)*secondsPerDay < now {
+		return newError(ErrInvalidState, fmt.Sprintf("Financing more than %d needs an appraisal of the last %d days", config.AppraisalThreshold, days))
+	} else if appraisal.Value < amount {
+		return newError(ErrInvalidState, fmt.Sprintf("The car is appraised at %d, less than the financed %d", appraisal.Value, amount))
+	}
+
+	return nil
+}
+
+/*
+ * Records the appraisal of a certified appraiser.
+ * An appraisal replaces the latest one of the car
+ * unless it was made before.
+ *
+ * On success,
+ * returns the appraisal.
+ */
+func (t *CarChaincode) recordAppraisal(stub shim.ChaincodeStubInterface, username string, vin string, appraisal Appraisal) pb.Response {
+	if appraisal.Value <= 0 {
+		return errorResponse(ErrInvalidArgument, "'recordAppraisal' expects a positive value")
+	} else if appraisal.Methodology == "" {
+		return errorResponse(ErrInvalidArgument, "'recordAppraisal' expects a non-empty methodology")
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	appraiser, err := t.getOracle(stub, caller)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if appraiser == nil || !appraiser.Active || appraiser.Kind != oracleAppraisal {
+		return errorResponse(ErrForbidden, "Forbidden: the invoker is no certified appraiser")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if appraisal.AppraisedTs <= 0 || appraisal.AppraisedTs > now {
+		return errorResponse(ErrInvalidArgument, "'recordAppraisal' expects a past appraisal date")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	appraisal.Vin = vin
+	appraisal.Appraiser = appraiser.Name
+	appraisal.Username = username
+	appraisal.Identity = caller
+	appraisal.Msp = appraiser.Msp
+	appraisal.RecordedTs = now
+	appraisal.TxId = stub.GetTxID()
+
+	key, err := stub.CreateCompositeKey(appraisalObjectType, []string{vin, fmt.Sprintf("%020d", appraisal.AppraisedTs), appraisal.TxId})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating appraisal key")
+	}
+
+	appraisalAsBytes, _ := ledgerjson.Marshal(appraisal)
+	err = stub.PutState(key, appraisalAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing appraisal")
+	}
+
+	if appraisal.AppraisedTs >= car.Appraisal.AppraisedTs {
+		car.Appraisal = appraisal
+
+		carAsBytes, _ := ledgerjson.Marshal(car)
+		err = stub.PutState(vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+	}
+
+	return shim.Success(appraisalAsBytes)
+}
+
+/*
+ * Returns all appraisals of a car, oldest appraisal
+ * first. Open to the DOT and the readers of the car,
+ * like 'getPriceHistory'.
+ *
+ * On success,
+ * returns the appraisals.
+ */
+func (t *CarChaincode) getAppraisals(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	if role != "dot" {
+		allowed, err := t.canRead(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if !allowed {
+			return errorResponse(ErrNotOwner, "Forbidden: the owner did not allow you to read this car")
+		}
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(appraisalObjectType, []string{vin})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading appraisals")
+	}
+	defer iterator.Close()
+
+	appraisals := []Appraisal{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading appraisals")
+		}
+
+		appraisal := Appraisal{}
+		err = ledgerjson.Unmarshal(kv.Value, &appraisal)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing appraisal")
+		}
+
+		appraisals = append(appraisals, appraisal)
+	}
+
+	appraisalsAsBytes, _ := ledgerjson.Marshal(appraisals)
+	return shim.Success(appraisalsAsBytes)
+}
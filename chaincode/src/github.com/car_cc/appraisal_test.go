@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAppraisalForFinancing(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	appraiser := "Org1MSP valuer"
+	appraiserHash := sha256.Sum256([]byte(appraiser))
+	lastWeek := strconv.FormatInt(time.Now().Add(-7*24*time.Hour).Unix(), 10)
+	yesterday := strconv.FormatInt(time.Now().Add(-24*time.Hour).Unix(), 10)
+	tomorrow := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "appraisal_threshold": 40 }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+
+	// financing above the threshold needs an appraisal
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "50", "2", "30"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = invokeAs(stub, appraiser, "recordAppraisal", "valuer", "appraiser", vin, "55", "market comparison", yesterday)
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "Valuer AG", hex.EncodeToString(appraiserHash[:]), "Org1MSP", oracleAppraisal, "true"))
+
+	response = invokeAs(stub, appraiser, "recordAppraisal", "valuer", "appraiser", vin, "55", "market comparison", tomorrow)
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	invokeAs(stub, appraiser, "recordAppraisal", "valuer", "appraiser", vin, "45", "market comparison", lastWeek)
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "50", "2", "30"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = invokeAs(stub, appraiser, "recordAppraisal", "valuer", "appraiser", vin, "55", "inspection", yesterday)
+	appraisal := Appraisal{}
+	json.Unmarshal(response.Payload, &appraisal)
+	if appraisal.Appraiser != "Valuer AG" || appraisal.Identity != hex.EncodeToString(appraiserHash[:]) {
+		t.Fatalf("Expected an appraisal signed by the appraiser, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "50", "2", "30"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// the latest appraisal is public
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("lookupCar", buyer, "user", vin))
+	car := PublicCar{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Appraisal == nil || car.Appraisal.Value != 55 {
+		t.Errorf("Lookup should show the latest appraisal: %v", car.Appraisal)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getAppraisals", "inspector", "dot", vin))
+	appraisals := []Appraisal{}
+	json.Unmarshal(response.Payload, &appraisals)
+	if len(appraisals) != 2 || appraisals[0].Value != 45 {
+		t.Errorf("Expected both appraisals, oldest first, got %v", appraisals)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Car archive.
+ *
+ * Scrapped and exported cars are never deleted. The car
+ * moves to 'archive~<vin>' together with its last owner,
+ * and a tombstone stays at the VIN, so the key history
+ * ends with the archival instead of a deletion and the
+ * VIN cannot be handed out again.
+ * Archived cars leave the car index and the ownership
+ * keys, so all active queries skip them.
+ */
+
+// object type of archived cars
+const archiveObjectType string = "archive"
+
+// reasons for archiving a car
+const archiveScrapped string = "scrapped"
+const archiveExported string = "exported"
+
+/*
+ * Checks if 'car' is the tombstone of an archived car
+ */
+func IsArchived(car *Car) bool {
+	return car.Archived != nil
+}
+
+/*
+ * Returns the ledger key of archived car 'vin'
+ */
+func getArchiveKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(archiveObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating archive key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the tombstone at 'vin'.
+ *
+ * Returns 'nil' if the car is not archived.
+ */
+func getArchival(stub shim.ChaincodeStubInterface, vin string) (*Archival, error) {
+	carAsBytes, err := stub.GetState(vin)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading car")
+	} else if carAsBytes == nil {
+		return nil, nil
+	}
+
+	car := Car{}
+	err = ledgerjson.Unmarshal(carAsBytes, &car)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing car")
+	}
+
+	return car.Archived, nil
+}
+
+/*
+ * Moves 'car' to the archive and leaves a tombstone
+ * at its VIN. The ownership link of 'owner' is removed,
+ * the indexes are left to the caller.
+ */
+func (t *CarChaincode) writeArchive(stub shim.ChaincodeStubInterface, owner string, car Car, reason string) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	archival := Archival{Reason: reason, Ts: now, TxId: stub.GetTxID()}
+
+	key, err := getArchiveKey(stub, car.Vin)
+	if err != nil {
+		return err
+	}
+
+	archivedAsBytes, _ := ledgerjson.Marshal(ArchivedCar{Car: car, Owner: owner, Archival: archival})
+	err = stub.PutState(key, archivedAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing archived car")
+	}
+
+	tombstoneAsBytes, _ := ledgerjson.Marshal(Tombstone{Vin: car.Vin, Archived: archival})
+	err = stub.PutState(car.Vin, tombstoneAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing car tombstone")
+	}
+
+	if owner != "" {
+		err = removeOwnership(stub, owner, car.Vin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Archives 'car' of 'owner' for 'reason' and takes it
+ * out of the car index, the garage stock and the read grants.
+ */
+func (t *CarChaincode) archiveCar(stub shim.ChaincodeStubInterface, owner string, car Car, reason string) error {
+	err := t.writeArchive(stub, owner, car, reason)
+	if err != nil {
+		return err
+	}
+
+	err = unindexCar(stub, car.Vin)
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		err = t.removeFromInventory(stub, owner, car.Vin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.clearReadGrants(stub, car.Vin)
+}
+
+/*
+ * Reads an archived car.
+ *
+ * VINs are not validated, cars created before
+ * the VIN check can be archived as well.
+ *
+ * On success,
+ * returns the archived car with its last owner.
+ */
+func (t *CarChaincode) getArchivedCar(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	key, err := getArchiveKey(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	archivedAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading archived car")
+	} else if archivedAsBytes == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("Car with vin '%s' is not archived", vin))
+	}
+
+	return shim.Success(archivedAsBytes)
+}
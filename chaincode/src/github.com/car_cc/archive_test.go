@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestScrapCarArchivesIt(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+otherVin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("delete", "inspector", "dot", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// a tombstone is left at the VIN
+	carAsBytes, _ := stub.GetState(vin)
+	tombstone := Car{}
+	json.Unmarshal(carAsBytes, &tombstone)
+	if !IsArchived(&tombstone) || tombstone.Archived.Reason != archiveScrapped {
+		t.Errorf("Expected a tombstone at the VIN, got %s", carAsBytes)
+	}
+
+	// active queries skip the car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "garage", vin))
+	expectErrorCode(t, response, ErrCarNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", garage, "garage"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if len(user.Cars) != 1 || user.Cars[0] != otherVin {
+		t.Errorf("Expected only car '%s' left, got %v", otherVin, user.Cars)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("countCarsByStatus", "inspector", "dot"))
+	counts := StatusCounts{}
+	json.Unmarshal(response.Payload, &counts)
+	if counts.Counts["unregistered"] != 1 {
+		t.Errorf("Archived cars should not be counted: %v", counts.Counts)
+	}
+
+	// auditors find the car in the archive
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getArchivedCar", garage, "garage", vin))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getArchivedCar", "finma", "regulator", vin))
+	archived := ArchivedCar{}
+	err := json.Unmarshal(response.Payload, &archived)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if archived.Car.Vin != vin || archived.Owner != garage || archived.Archival.Reason != archiveScrapped {
+		t.Errorf("Unexpected archived car: %v", archived)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getArchivedCar", "finma", "regulator", otherVin))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// the VIN is not handed out again
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	expectErrorCode(t, response, ErrCarExists)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Document attachments.
+ *
+ * Owners, or garages with a 'service' mandate, attach
+ * photos, invoices and reports to a car with
+ * 'attachDocument'. The files stay off-chain: the ledger
+ * keeps their sha256 and where to find them, an https or
+ * IPFS URI, under 'attachment~<vin>~<ts>~<hash>', so anybody
+ * holding a file can prove it is the attached one.
+ *
+ * Attachments move with the car and are read like the
+ * car, by the owner and users with a read grant.
+ */
+
+// object type of attachment keys
+const attachmentObjectType string = "attachment"
+
+// kinds of attached documents
+var attachmentTypes = []string{"photo", "invoice", "service_record", "inspection_report", "other"}
+
+/*
+ * Returns the key of an attachment
+ */
+func attachmentKey(stub shim.ChaincodeStubInterface, attachment *Attachment) (string, error) {
+	key, err := stub.CreateCompositeKey(attachmentObjectType, []string{attachment.Vin, fmt.Sprintf("%012d", attachment.AttachedTs), attachment.Hash})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating attachment key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the attachments of a car, oldest first
+ */
+func getAttachments(stub shim.ChaincodeStubInterface, vin string) ([]Attachment, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(attachmentObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading attachments")
+	}
+	defer iterator.Close()
+
+	attachments := []Attachment{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading attachments")
+		}
+
+		attachment := Attachment{}
+		err = ledgerjson.Unmarshal(kv.Value, &attachment)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing attachment")
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+/*
+ * Attaches a document to the car of 'owner'.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  document type                            string
+ *  sha256 of the file, hex encoded          string
+ *  (optional) https or ipfs URI             string
+ *
+ * On success,
+ * returns the attachment.
+ */
+func (t *CarChaincode) attachDocument(stub shim.ChaincodeStubInterface, owner string, username string, args []string) pb.Response {
+	vin := args[0]
+	docType := args[1]
+	hash := args[2]
+
+	uri := ""
+	if len(args) > 3 {
+		uri = args[3]
+	}
+
+	// this already checks for ownership
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsActive(&car) {
+		return errorResponse(ErrNotActive, "The car is handed off to another channel")
+	}
+
+	attachments, err := getAttachments(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	for _, attachment := range attachments {
+		if attachment.Hash == hash {
+			return errorResponse(ErrInvalidState, fmt.Sprintf("The document '%s' is attached to the car already", hash))
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	attachment := Attachment{
+		Vin:        vin,
+		Type:       docType,
+		Hash:       hash,
+		Uri:        uri,
+		AttachedBy: username,
+		AttachedTs: now,
+		TxId:       stub.GetTxID(),
+	}
+
+	key, err := attachmentKey(stub, &attachment)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	attachmentAsBytes, _ := ledgerjson.Marshal(attachment)
+	err = stub.PutState(key, attachmentAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing attachment")
+	}
+
+	fmt.Printf("Document '%s' attached to car '%s' by '%s'\n", hash, vin, username)
+	return shim.Success(attachmentAsBytes)
+}
+
+/*
+ * Lists the documents attached to a car, oldest first.
+ *
+ * On success,
+ * returns the attachments.
+ */
+func (t *CarChaincode) getDocuments(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	allowed, err := t.canRead(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !allowed {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
+	}
+
+	attachments, err := getAttachments(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	attachmentsAsBytes, _ := ledgerjson.Marshal(attachments)
+	return shim.Success(attachmentsAsBytes)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAttachDocument(t *testing.T) {
+	owner := "amag"
+	garage := "garage"
+	vin := "WVWZZZ6R6HY260780"
+	photo := strings.Repeat("ab", 32)
+	invoice := strings.Repeat("cd", 32)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", owner, "user", vin, "photo", "front.jpg"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", owner, "user", vin, "photo", photo, "ftp://photos/front.jpg"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", "bobby", "user", vin, "photo", photo))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke("1", util.ToChaincodeArgs("attachDocument", owner, "user", vin, "photo", photo, "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", owner, "user", vin, "invoice", photo))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// garages need a service mandate
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", garage, "garage", vin, "invoice", invoice))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("grantMandate", owner, "user", vin, garage, "service", "4102444800"))
+	response = stub.MockInvoke("2", util.ToChaincodeArgs("attachDocument", garage, "garage", vin, "invoice", invoice, "https://garage.ch/invoices/17.pdf"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getDocuments", "bobby", "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getDocuments", owner, "user", vin))
+	attachments := []Attachment{}
+	json.Unmarshal(response.Payload, &attachments)
+	if len(attachments) != 2 || attachments[0].Hash != photo || attachments[1].Hash != invoice {
+		t.Fatalf("Expected the photo and the invoice, got %s", response.Payload)
+	} else if attachments[1].AttachedBy != garage || attachments[1].Uri != "https://garage.ch/invoices/17.pdf" {
+		t.Errorf("Expected the invoice attached by the garage, got %+v", attachments[1])
+	}
+}
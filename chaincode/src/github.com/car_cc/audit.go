@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Audit log.
+ *
+ * Every successful call that changes the ledger with a
+ * privileged role is appended to 'audit~<ts>~<txid>',
+ * with the invoker, a hash of its client identity and
+ * a hash of the arguments. Entries are never changed or
+ * removed, auditors read them with 'getAuditLog'.
+ * Timestamps are zero padded, so the keys sort in time.
+ */
+
+// object type of audit log entries
+const auditObjectType string = "audit"
+
+// roles whose changes are audited
+var auditedRoles = []string{"dot", "admin", "insurer", "regulator", "police", "municipality", "court", "government"}
+
+/*
+ * Checks if changes made with 'role' are audited
+ */
+func isAuditedRole(role string) bool {
+	for _, audited := range auditedRoles {
+		if role == audited {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Returns the ledger key of the audit log entry of
+ * transaction 'txId' at 'ts'
+ */
+func getAuditKey(stub shim.ChaincodeStubInterface, ts int64, txId string) (string, error) {
+	key, err := stub.CreateCompositeKey(auditObjectType, []string{fmt.Sprintf("%020d", ts), txId})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating audit log key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Appends 'call' to the audit log
+ */
+func (t *CarChaincode) recordAudit(stub shim.ChaincodeStubInterface, call invocation) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	identity, err := getCallerIdentity(stub)
+	if err != nil {
+		return err
+	}
+
+	entry := AuditEntry{
+		TxId:     stub.GetTxID(),
+		Ts:       now,
+		Username: call.username,
+		Role:     call.role,
+		Identity: identity,
+		Function: call.function,
+		ArgsHash: hashCall(call.function, call.args)}
+
+	key, err := getAuditKey(stub, entry.Ts, entry.TxId)
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, _ := ledgerjson.Marshal(entry)
+	err = stub.PutState(key, entryAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing audit log")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the audit log from 'from' until before 'to'.
+ *
+ * Arguments required:
+ * [0] From                        (int, unix timestamp)
+ * [1] To                          (int, unix timestamp)
+ *
+ * On success,
+ * returns the audit log entries, oldest first.
+ */
+func (t *CarChaincode) getAuditLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	from, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'getAuditLog' expects the start as unix timestamp")
+	}
+
+	to, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || to < from {
+		return errorResponse(ErrInvalidArgument, "'getAuditLog' expects the end as unix timestamp after the start")
+	}
+
+	// composite keys cannot be range queried,
+	// so walk the log until the end of the period
+	iterator, err := stub.GetStateByPartialCompositeKey(auditObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading audit log")
+	}
+	defer iterator.Close()
+
+	entries := []AuditEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading audit log")
+		}
+
+		entry := AuditEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing audit log entry")
+		}
+
+		if entry.Ts >= to {
+			break
+		} else if entry.Ts >= from {
+			entries = append(entries, entry)
+		}
+	}
+
+	entriesAsBytes, _ := ledgerjson.Marshal(entries)
+	return shim.Success(entriesAsBytes)
+}
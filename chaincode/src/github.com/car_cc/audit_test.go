@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAuditLog(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke("2", util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke("3", util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke("4", util.ToChaincodeArgs("register", garage, "dot", vin))
+	stub.MockInvoke("5", util.ToChaincodeArgs("readCar", garage, "dot", vin))
+	stub.MockInvoke("6", util.ToChaincodeArgs("revoke", garage, "dot", vin))
+
+	from := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	to := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	response := stub.MockInvoke("7", util.ToChaincodeArgs("getAuditLog", garage, "dot", from, to))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke("8", util.ToChaincodeArgs("getAuditLog", "finma", "regulator", from, to))
+	entries := []AuditEntry{}
+	err := json.Unmarshal(response.Payload, &entries)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// only the changes of the DOT are audited,
+	// including the pseudonym secret of the setup
+	if len(entries) != 3 || entries[0].Function != "setPseudonymSecret" || entries[1].Function != "register" || entries[2].Function != "revoke" {
+		t.Fatalf("Expected 'setPseudonymSecret', 'register' and 'revoke' in the audit log, got %v", entries)
+	}
+
+	if entries[2].TxId != "6" || entries[2].Username != garage || entries[2].Role != "dot" {
+		t.Errorf("Unexpected audit log entry: %v", entries[2])
+	}
+
+	if entries[2].ArgsHash != hashCall("revoke", []string{vin}) {
+		t.Error("Audit log entry should hash the arguments")
+	}
+
+	// nothing happened before the period
+	response = stub.MockInvoke("9", util.ToChaincodeArgs("getAuditLog", "finma", "regulator", "0", from))
+	json.Unmarshal(response.Payload, &entries)
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty audit log, got %v", entries)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// maximum number of cars per batch, keeps
+// the transaction within the block size
+const maxBatchSize int = 500
+
+/*
+ * Creates many cars in one transaction.
+ *
+ * Unlike 'bulkImportCars', a bad car does not fail the
+ * batch. Every car gets its own result and all cars that
+ * succeed are written. Only ledger errors abort the batch.
+ *
+ * Arguments required:
+ * [0] List of cars with VIN       (json, []Car)
+ *
+ * On success,
+ * returns a result per car in input order.
+ */
+func (t *CarChaincode) createBatch(stub shim.ChaincodeStubInterface, username string, carsData string) pb.Response {
+	items := []json.RawMessage{}
+	err := ledgerjson.Unmarshal([]byte(carsData), &items)
+	if err != nil || len(items) == 0 {
+		return errorResponse(ErrInvalidArgument, "'createBatch' expects a non-empty list of cars as json")
+	} else if len(items) > maxBatchSize {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'createBatch' accepts at most %d cars, got %d", maxBatchSize, len(items)))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	batch, err := t.newCarBatch(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	results := []BatchResult{}
+	created := 0
+	for _, item := range items {
+		car := Car{}
+		err = ledgerjson.Unmarshal(item, &car)
+		if err != nil {
+			results = append(results, BatchResult{Error: newError(ErrInvalidArgument, "Error parsing car data. Expecting Car with VIN as json.")})
+			continue
+		}
+
+		car.CreatedTs = now
+		err = batch.addCar(stub, &car, RegistrationProposal{}, now)
+		if ccErr, ok := err.(*ChaincodeError); ok && ccErr.Code == ErrLedger {
+			return errorResponseFrom(err)
+		} else if err != nil {
+			results = append(results, BatchResult{Vin: car.Vin, Error: ccErr})
+			continue
+		}
+
+		results = append(results, BatchResult{Vin: car.Vin, Ok: true})
+		created++
+	}
+
+	if created > 0 {
+		err = t.saveCarBatch(stub, batch)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	fmt.Printf("Created %d of %d cars for garage '%s'\n", created, len(items), username)
+
+	resultsAsBytes, _ := ledgerjson.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCreateBatch(t *testing.T) {
+	garage := "amag"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6RXHY260782" }`))
+
+	carsData := `[
+		{ "vin": "WVWZZZ6R6HY260780" },
+		{ "vin": "WVWZZZ6R7HY260780" },
+		{ "vin": "WVWZZZ6R8HY260781" },
+		{ "vin": "WVWZZZ6RXHY260782" },
+		{ "vin": "WVWZZZ6R6HY260780" },
+		"no car"
+	]`
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("createBatch", garage, "garage", carsData))
+	results := []BatchResult{}
+	err := json.Unmarshal(response.Payload, &results)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	expected := []string{"", ErrVinCheckDigit, "", ErrCarExists, ErrCarExists, ErrInvalidArgument}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+
+	for i, code := range expected {
+		if code == "" && !results[i].Ok {
+			t.Errorf("Car %d should have been created: %v", i, results[i].Error)
+		} else if code != "" && (results[i].Ok || results[i].Error == nil || results[i].Error.Code != code) {
+			t.Errorf("Car %d should fail with '%s'", i, code)
+		}
+	}
+
+	// the successful cars are all written
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", garage, "garage"))
+	user := User{}
+	err = json.Unmarshal(response.Payload, &user)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(user.Cars) != 3 {
+		t.Errorf("Garage should own 3 cars, but owns %d", len(user.Cars))
+	}
+}
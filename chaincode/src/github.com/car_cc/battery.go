@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Battery health of electric cars.
+ *
+ * Garages authorized by the DOT, i.e. registered as oracles
+ * of kind 'battery', measure the traction battery and record
+ * a report on the car. The latest report is shown in public
+ * lookups and dealer inventories, since the battery decides
+ * most of the resale value of an electric car.
+ */
+
+// oracle kind allowed to record battery health
+const oracleBattery string = "battery"
+
+/*
+ * Checks if a car is electric, i.e. tested
+ * as electric or with a traction battery
+ */
+func IsElectric(car *Car) bool {
+	return car.Emission.Class == "electric" || car.Parts[partBattery] != ""
+}
+
+/*
+ * Returns the latest battery report of a car,
+ * or 'nil' if there is none
+ */
+func batteryReport(car *Car) *BatteryHealth {
+	if car.Battery.MeasuredTs == 0 {
+		return nil
+	}
+
+	battery := car.Battery
+	return &battery
+}
+
+/*
+ * Records the battery health report of an authorized
+ * garage, replacing the previous report.
+ *
+ * On success,
+ * returns the report.
+ */
+func (t *CarChaincode) recordBatteryHealth(stub shim.ChaincodeStubInterface, username string, vin string, report BatteryHealth) pb.Response {
+	if report.StateOfHealth < 0 || report.StateOfHealth > 100 {
+		return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects a state of health between 0 and 100%")
+	} else if report.Cycles < 0 {
+		return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects a cycle count of 0 or more")
+	} else if report.CapacityKwh <= 0 {
+		return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects a positive capacity in kWh")
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	garage, err := t.getOracle(stub, caller)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if garage == nil || !garage.Active || garage.Kind != oracleBattery {
+		return errorResponse(ErrForbidden, "Forbidden: the invoker is no authorized battery garage")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsElectric(&car) {
+		return errorResponse(ErrInvalidState, "Battery health can only be recorded for electric cars")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	report.MeasuredBy = garage.Name
+	report.Garage = username
+	report.MeasuredTs = now
+	car.Battery = report
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
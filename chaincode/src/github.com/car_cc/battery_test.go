@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestBatteryHealth(t *testing.T) {
+	dealer := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	petrolVin := "WVWZZZ6R8HY260781"
+	garage := "Org1MSP evservice"
+	garageHash := sha256.Sum256([]byte(garage))
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", dealer, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "evservice", "garage"))
+
+	// high-voltage work needs a certification
+	expiry := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+	for _, name := range []string{dealer, "evservice"} {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", name, scopeEvHighVoltage, expiry, "HV-"+name))
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", dealer, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", dealer, "garage", `{ "vin": "`+petrolVin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", dealer, "garage", vin, partBattery, "B-1"))
+
+	response := invokeAs(stub, garage, "recordBatteryHealth", "evservice", "garage", vin, "91.5", "420", "71.2")
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "EV Service", hex.EncodeToString(garageHash[:]), "Org1MSP", oracleBattery, "true"))
+
+	response = invokeAs(stub, garage, "recordBatteryHealth", "evservice", "garage", vin, "101", "420", "71.2")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = invokeAs(stub, garage, "recordBatteryHealth", "evservice", "garage", petrolVin, "91.5", "420", "71.2")
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = invokeAs(stub, garage, "recordBatteryHealth", "evservice", "garage", vin, "91.5", "420", "71.2")
+	if response.Status != shim.OK {
+		t.Fatal("Authorized garage should be able to record battery health: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("lookupCar", "bobby", "user", vin))
+	car := PublicCar{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Battery == nil || car.Battery.StateOfHealth != 91.5 || car.Battery.MeasuredBy != "EV Service" {
+		t.Errorf("Lookup should show the battery report: %v", car.Battery)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", dealer, "garage"))
+	items := []InventoryItem{}
+	json.Unmarshal(response.Payload, &items)
+	if len(items) != 2 {
+		t.Fatalf("Unexpected inventory: %v", items)
+	}
+	for _, item := range items {
+		if (item.Vin == vin) != (item.Battery != nil) {
+			t.Errorf("Only the electric car should list a battery report: %v", item)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Birth certificates.
+ *
+ * Manufacturers issue the birth certificate of a VIN at the
+ * factory with 'manufacture', passing the technical data
+ * sheet. The certificate keeps the sha256 of the sheet as
+ * submitted and the hash of the client identity that signed
+ * the transaction, under 'birth~<vin>'.
+ *
+ * A garage creating a car with that VIN later links the car
+ * to the certificate instead of copying the sheet, brand and
+ * model are taken from the sheet.
+ */
+
+// object type of birth certificate keys
+const birthObjectType string = "birth"
+
+/*
+ * Returns the ledger key of the birth certificate of a VIN
+ */
+func getBirthKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(birthObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating birth certificate key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the birth certificate of a VIN.
+ *
+ * Returns 'nil' if the manufacturer did not issue one.
+ */
+func getBirthCertificate(stub shim.ChaincodeStubInterface, vin string) (*BirthCertificate, error) {
+	key, err := getBirthKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	birthAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading birth certificate")
+	} else if birthAsBytes == nil {
+		return nil, nil
+	}
+
+	birth := BirthCertificate{}
+	err = ledgerjson.Unmarshal(birthAsBytes, &birth)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing birth certificate")
+	}
+
+	return &birth, nil
+}
+
+/*
+ * Writes a birth certificate to the ledger
+ */
+func saveBirthCertificate(stub shim.ChaincodeStubInterface, birth *BirthCertificate) ([]byte, error) {
+	key, err := getBirthKey(stub, birth.Vin)
+	if err != nil {
+		return nil, err
+	}
+
+	birthAsBytes, _ := ledgerjson.Marshal(birth)
+	err = stub.PutState(key, birthAsBytes)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error writing birth certificate")
+	}
+
+	return birthAsBytes, nil
+}
+
+/*
+ * Links a new car to the birth certificate of its VIN,
+ * if there is one. Brand and model given with the car
+ * must match the data sheet.
+ */
+func linkBirthCertificate(stub shim.ChaincodeStubInterface, car *Car, garage string) error {
+	// links are never taken from the car data
+	car.Birth = BirthLink{}
+
+	birth, err := getBirthCertificate(stub, car.Vin)
+	if err != nil || birth == nil {
+		return err
+	}
+
+	if car.Certificate.Brand != "" && car.Certificate.Brand != birth.Data.Brand ||
+		car.Certificate.Model != "" && car.Certificate.Model != birth.Data.Model {
+		return newError(ErrInvalidArgument, fmt.Sprintf("The car does not match the birth certificate of '%s', a %s %s", car.Vin, birth.Data.Brand, birth.Data.Model))
+	}
+
+	car.Certificate.Brand = birth.Data.Brand
+	car.Certificate.Model = birth.Data.Model
+	car.Birth = BirthLink{
+		Manufacturer: birth.Manufacturer,
+		DataHash:     birth.DataHash,
+		IssuedTs:     birth.IssuedTs,
+	}
+
+	birth.Garage = garage
+	birth.LinkedTs = car.CreatedTs
+	_, err = saveBirthCertificate(stub, birth)
+	return err
+}
+
+/*
+ * Issues the birth certificate of a VIN
+ * with the technical data sheet of the car.
+ *
+ * On success,
+ * returns the birth certificate.
+ */
+func (t *CarChaincode) manufacture(stub shim.ChaincodeStubInterface, username string, vin string, dataAsJson string) pb.Response {
+	vinErr := ValidateVin(vin)
+	if vinErr != nil {
+		return errorResponseFrom(vinErr)
+	}
+
+	data := TechnicalData{}
+	err := ledgerjson.Unmarshal([]byte(dataAsJson), &data)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'manufacture' expects the technical data sheet as json")
+	} else if data.Brand == "" || data.Model == "" || data.EngineNumber == "" {
+		return errorResponse(ErrInvalidArgument, "'manufacture' expects a data sheet with brand, model and engine number")
+	} else if data.Weight <= 0 {
+		return errorResponse(ErrInvalidArgument, "'manufacture' expects a positive weight")
+	}
+
+	existing, err := getBirthCertificate(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if existing != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Car with vin '%s' was manufactured by '%s' already", vin, existing.Manufacturer))
+	}
+
+	// cars on the road are born already
+	carAsBytes, err := stub.GetState(vin)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading car")
+	} else if carAsBytes != nil {
+		return errorResponse(ErrCarExists, fmt.Sprintf("Car with vin '%s' already exists", vin))
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	hash := sha256.Sum256([]byte(dataAsJson))
+	birth := BirthCertificate{
+		Vin:          vin,
+		Manufacturer: username,
+		Identity:     caller,
+		Data:         data,
+		DataHash:     hex.EncodeToString(hash[:]),
+		IssuedTs:     now,
+		TxId:         stub.GetTxID(),
+	}
+
+	birthAsBytes, err := saveBirthCertificate(stub, &birth)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Birth certificate of '%s' issued by '%s'\n", vin, username)
+	return shim.Success(birthAsBytes)
+}
+
+/*
+ * Returns the birth certificate of a VIN.
+ *
+ * On success,
+ * returns the birth certificate.
+ */
+func (t *CarChaincode) readBirthCertificate(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	birth, err := getBirthCertificate(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if birth == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no birth certificate of '%s'", vin))
+	}
+
+	birthAsBytes, _ := ledgerjson.Marshal(birth)
+	return shim.Success(birthAsBytes)
+}
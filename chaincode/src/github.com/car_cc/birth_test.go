@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestBirthCertificate(t *testing.T) {
+	manufacturer := "volkswagen"
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	sheet := `{ "brand": "VW", "model": "Polo", "weight": 1160, "engine_number": "DKL042311", "color_codes": ["LC9X"] }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", garage, "garage", vin, sheet))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", manufacturer, "manufacturer", vin, `{ "brand": "VW", "model": "Polo" }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", manufacturer, "manufacturer", vin, sheet))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", manufacturer, "manufacturer", vin, sheet))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	// a garage cannot pass the car off as another model
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "`+vin+`", "certificate": { "brand": "VW", "model": "Golf" } }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Model != "Polo" || car.Birth.Manufacturer != manufacturer || car.Birth.DataHash == "" {
+		t.Fatalf("Expected the car to link to its birth certificate, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readBirthCertificate", "bobby", "user", vin))
+	birth := BirthCertificate{}
+	json.Unmarshal(response.Payload, &birth)
+	if birth.Garage != garage || birth.Data.EngineNumber != "DKL042311" || birth.DataHash != car.Birth.DataHash {
+		t.Errorf("Unexpected birth certificate: %s", response.Payload)
+	}
+
+	// cars on the road cannot be born again
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "WVWZZZ6R8HY260781", "birth": { "manufacturer": "`+manufacturer+`" } }`))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Birth.Manufacturer != "" {
+		t.Errorf("Expected no birth link without birth certificate, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", manufacturer, "manufacturer", "WVWZZZ6R8HY260781", sheet))
+	expectErrorCode(t, response, ErrCarExists)
+}
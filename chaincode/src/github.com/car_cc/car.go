@@ -1,41 +1,34 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
-	"time"
 
+	"github.com/car_cc/ledgerjson"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
-/*
- * Returns the car index
- */
-func (t *CarChaincode) getCarIndex(stub shim.ChaincodeStubInterface) (map[string]string, error) {
-	response := t.read(stub, carIndexStr)
-	carIndex := make(map[string]string)
-	err := json.Unmarshal(response.Payload, &carIndex)
-	if err != nil {
-		return nil, errors.New("Error parsing car index")
-	}
-
-	return carIndex, nil
-}
-
 /*
  * Reads the car index at key 'vin'
  *
+ * Every look up of a car goes through here, so
+ * malformed VINs are rejected with a VIN error.
+ * The index holds owner pseudonyms, see 'resolveOwner'.
+ *
  * Returns username of car owner with VIN 'vin'.
  */
 func (t *CarChaincode) getOwner(stub shim.ChaincodeStubInterface, vin string) (string, error) {
-	carIndex, err := t.getCarIndex(stub)
+	vinErr := ValidateVin(vin)
+	if vinErr != nil {
+		return "", vinErr
+	}
+
+	pseudonym, err := getIndexedOwner(stub, vin)
 	if err != nil {
 		return "", err
 	}
-	return carIndex[vin], nil
+	return resolveOwner(stub, pseudonym)
 }
 
 /*
@@ -56,7 +49,7 @@ func (t *CarChaincode) getOwner(stub shim.ChaincodeStubInterface, vin string) (s
  */
 func (t *CarChaincode) createCar(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
 	if len(args) < 1 {
-		return shim.Error("'create' expects Car with VIN as json")
+		return errorResponse(ErrInvalidArgument, "'create' expects Car with VIN as json")
 	}
 
 	// create new registration proposal for the DOT
@@ -65,7 +58,7 @@ func (t *CarChaincode) createCar(stub shim.ChaincodeStubInterface, username stri
 	// if provided, read additional registration data
 	if len(args) > 1 {
 		fmt.Printf("Received registration data: %s\n", args[1])
-		err := json.Unmarshal([]byte(args[1]), &regProposal)
+		err := ledgerjson.Unmarshal([]byte(args[1]), &regProposal)
 		if err != nil {
 			fmt.Println("Unable to parse your registration data")
 		}
@@ -76,81 +69,247 @@ func (t *CarChaincode) createCar(stub shim.ChaincodeStubInterface, username stri
 
 	// create car from arguments
 	car := Car{}
-	err := json.Unmarshal([]byte(args[0]), &car)
+	err := ledgerjson.Unmarshal([]byte(args[0]), &car)
 	if err != nil {
-		return shim.Error("Error parsing car data. Expecting Car with VIN as json.")
+		return errorResponse(ErrInvalidArgument, "Error parsing car data. Expecting Car with VIN as json.")
 	}
 
 	// add car birth date
-	car.CreatedTs = time.Now().Unix()
+	car.CreatedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// load the garage user and the indexes
+	// the new car is added to
+	batch, err := t.newCarBatch(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = batch.addCar(stub, &car, regProposal, car.CreatedTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.saveCarBatch(stub, batch)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// car creation successfull,
+	// return the car
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * New cars of a garage, which are written in one transaction.
+ *
+ * Fabric does not return writes of the running transaction
+ * on a read, so all indexes are loaded once, updated in
+ * memory and written back at the end.
+ */
+type carBatch struct {
+	user        User
+	owner       string          // pseudonym of the garage in the car index
+	vins        map[string]bool // cars of the batch so far
+	proposals   []RegistrationProposal
+	inventory   map[string]map[string]InventoryEntry
+	proposalTtl int64
+
+	// vehicle catalog entries read so far, by id
+	catalog         map[string]*CatalogEntry
+	catalogRequired bool
+}
 
-	// check for existing garage user with that name
+/*
+ * Loads the garage user 'username' and the indexes for new cars
+ */
+func (t *CarChaincode) newCarBatch(stub shim.ChaincodeStubInterface, username string) (*carBatch, error) {
+	// the garage needs a user, see 'createUser'
 	user, err := t.getUser(stub, username)
 	if err != nil {
-		user = User{Name: username, Cars: []string{}, Balance: 100}
+		return nil, newError(ErrUserNotFound, fmt.Sprintf("User '%s' does not exist. Create it with 'createUser' first.", username))
+	}
+
+	owner, err := registerPseudonym(stub, username)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &carBatch{
+		user:        user,
+		owner:       owner,
+		vins:        make(map[string]bool),
+		proposals:   []RegistrationProposal{},
+		inventory:   inventory,
+		proposalTtl: proposalTtl(config),
+
+		catalog:         make(map[string]*CatalogEntry),
+		catalogRequired: config.CatalogRequired}, nil
+}
+
+/*
+ * Checks the catalog entry of a new car and takes brand,
+ * model and variant from it, see 'applyCatalogEntry'
+ */
+func (b *carBatch) checkCatalog(stub shim.ChaincodeStubInterface, car *Car) error {
+	id := car.Certificate.CatalogId
+	if id == "" {
+		if b.catalogRequired {
+			return newError(ErrInvalidArgument, "New cars need a vehicle catalog entry, see 'searchCatalog'")
+		}
+		return nil
+	}
+
+	entry, ok := b.catalog[id]
+	if !ok {
+		var err error
+		entry, err = getCatalogEntry(stub, id)
+		if err != nil {
+			return err
+		}
+		b.catalog[id] = entry
+	}
+
+	if entry == nil {
+		return newError(ErrNotFound, fmt.Sprintf("There is no catalog entry '%s'", id))
+	}
+
+	return applyCatalogEntry(car, entry)
+}
+
+/*
+ * Writes a new car to ledger and adds it to the garage
+ * stock at 'stockedTs'. Returns an error if the VIN is
+ * malformed or a car with that VIN already exists.
+ */
+func (b *carBatch) addCar(stub shim.ChaincodeStubInterface, car *Car, regProposal RegistrationProposal, stockedTs int64) error {
+	// reject malformed VINs before they end up in the car index
+	vinErr := ValidateVin(car.Vin)
+	if vinErr != nil {
+		return vinErr
 	}
 
 	// check for an existing car with that vin in the car index
-	owner, err := t.getOwner(stub, car.Vin)
+	indexed, err := getIndexedOwner(stub, car.Vin)
 	if err != nil {
-		return shim.Error(err.Error())
-	} else if owner != "" {
-		return shim.Error(fmt.Sprintf("Car with vin '%s' already exists. Choose another vin.", car.Vin))
+		return err
+	} else if indexed != "" || b.vins[car.Vin] {
+		return newError(ErrCarExists, fmt.Sprintf("Car with vin '%s' already exists. Choose another vin.", car.Vin))
 	}
 
-	// save car to ledger, the car vin serves
-	// as the index to find the car again
-	carAsBytes, _ := json.Marshal(car)
-	err = stub.PutState(car.Vin, carAsBytes)
+	// the VIN of an archived car is not handed out again
+	archived, err := getArchival(stub, car.Vin)
 	if err != nil {
-		return shim.Error("Error writing car to ledger")
+		return err
+	} else if archived != nil {
+		return newError(ErrCarExists, fmt.Sprintf("Car with vin '%s' was %s and cannot be created again.", car.Vin, archived.Reason))
+	} else if IsArchived(car) {
+		return newError(ErrInvalidArgument, "New cars cannot be archived")
 	}
 
-	// map the car to the users name
-	carIndex, err := t.getCarIndex(stub)
+	// inspection data comes from certified garages only
+	err = requireProposalCertification(stub, b.user.Name, &regProposal)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	carIndex[car.Vin] = user.Name
-	fmt.Printf("Added car with VIN '%s' created at '%d' in garage '%s' to car index.\n",
-		car.Vin, car.CreatedTs, user.Name)
 
-	// write udpated car index back to ledger
-	indexAsBytes, _ := json.Marshal(carIndex)
-	err = stub.PutState(carIndexStr, indexAsBytes)
+	// normalize brand and model by the vehicle catalog
+	err = b.checkCatalog(stub, car)
 	if err != nil {
-		return shim.Error("Error writing car index")
+		return err
 	}
 
-	// hand over the car and write user to ledger
-	user.Cars = append(user.Cars, car.Vin)
-	err = t.saveUser(stub, user)
+	// link the car to its birth certificate
+	err = linkBirthCertificate(stub, car, b.user.Name)
+	if err != nil {
+		return err
+	}
+
+	// save car to ledger, the car vin serves
+	// as the index to find the car again
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
 	if err != nil {
-		return shim.Error("Error saving user")
+		return newError(ErrLedger, "Error writing car to ledger")
 	}
 
-	// load all proposals
-	proposalIndex, err := t.getRegistrationProposals(stub)
+	// the batch maps the car to the users pseudonym
+	b.vins[car.Vin] = true
+	fmt.Printf("Added car with VIN '%s' created at '%d' in garage '%s' to car index.\n",
+		car.Vin, car.CreatedTs, b.user.Name)
+
+	// hand over the car
+	err = addOwnership(stub, b.user.Name, car.Vin)
 	if err != nil {
-		return shim.Error("Error loading registration proposal index")
+		return err
 	}
 
 	// update the car vin in the registration proposal
-	// and save the proposal for the DOT
+	// and queue the proposal for the DOT
 	regProposal.Car = car.Vin
-	proposalIndex[car.Vin] = regProposal
+	regProposal.Owner = b.user.Name
+	regProposal.Status = proposalPending
+	regProposal.CreatedTs = car.CreatedTs
+	regProposal.Reviewer = ""
+	regProposal.ReviewedTs = 0
+	regProposal.Reason = ""
+	regProposal.ExpiresTs = car.CreatedTs + b.proposalTtl
+	b.proposals = append(b.proposals, regProposal)
+
+	err = updateExpiry(stub, expiryProposal, car.Vin, 0, regProposal.ExpiresTs)
+	if err != nil {
+		return err
+	}
+
+	// put the car into the garage stock
+	stock, ok := b.inventory[b.user.Name]
+	if !ok {
+		stock = make(map[string]InventoryEntry)
+		b.inventory[b.user.Name] = stock
+	}
+	stock[car.Vin] = InventoryEntry{StockedTs: stockedTs}
 
-	// write udpated proposal index back to ledger
-	// for the DOT to read and register the car
-	indexAsBytes, _ = json.Marshal(proposalIndex)
-	err = stub.PutState(registrationProposalIndexStr, indexAsBytes)
+	return nil
+}
+
+/*
+ * Writes the updated indexes back to ledger
+ */
+func (t *CarChaincode) saveCarBatch(stub shim.ChaincodeStubInterface, b *carBatch) error {
+	// index the cars and write the proposals
+	// for the DOT to review and register them
+	for _, proposal := range b.proposals {
+		err := indexCar(stub, proposal.Car, b.owner)
+		if err != nil {
+			return err
+		}
+
+		err = t.saveProposal(stub, proposal)
+		if err != nil {
+			return err
+		}
+	}
+
+	indexAsBytes, _ := ledgerjson.Marshal(b.inventory)
+	err := stub.PutState(inventoryIndexStr, indexAsBytes)
 	if err != nil {
-		return shim.Error("Error writing registration proposal index")
+		return newError(ErrLedger, "Error writing inventory index")
 	}
 
-	// car creation successfull,
-	// return the car
-	return shim.Success(carAsBytes)
+	return nil
 }
 
 /*
@@ -163,23 +322,23 @@ func (t *CarChaincode) createCar(stub shim.ChaincodeStubInterface, username stri
  */
 func (t *CarChaincode) getCar(stub shim.ChaincodeStubInterface, username string, vin string) (Car, error) {
 	if vin == "" {
-		return Car{}, errors.New("'readCar' expects a non-empty VIN to do the look up")
+		return Car{}, newError(ErrInvalidArgument, "'readCar' expects a non-empty VIN to do the look up")
 	}
 
 	// fetch the car from the ledger
 	carResponse := t.read(stub, vin)
 	car := Car{}
-	err := json.Unmarshal(carResponse.Payload, &car)
-	if err != nil {
-		return Car{}, errors.New("Failed to fetch car with vin '" + vin + "' from ledger")
+	err := ledgerjson.Unmarshal(carResponse.Payload, &car)
+	if err != nil || IsArchived(&car) {
+		return Car{}, newError(ErrCarNotFound, "Failed to fetch car with vin '" + vin + "' from ledger")
 	}
 
 	// fetch the car index to check if the user owns the car
 	owner, err := t.getOwner(stub, vin)
 	if err != nil {
-		return Car{}, errors.New(err.Error())
+		return Car{}, err
 	} else if owner != username {
-		return Car{}, errors.New("Forbidden: this is not your car")
+		return Car{}, newError(ErrNotOwner, "Forbidden: this is not your car")
 	}
 
 	return car, nil
@@ -188,48 +347,79 @@ func (t *CarChaincode) getCar(stub shim.ChaincodeStubInterface, username string,
 /*
  * Reads a car.
  *
- * Only the car owner can read the car.
+ * Only the car owner and users with a read grant
+ * of the owner can read the car.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  (optional) fields to return              JSON array
  *
  * On success,
- * returns the car.
+ * returns the car, or the requested fields of it.
  */
-func (t *CarChaincode) readCar(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+func (t *CarChaincode) readCar(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
 	if vin == "" {
-		return shim.Error("'readCar' expects a non-empty VIN to do the look up")
+		return errorResponse(ErrInvalidArgument, "'readCar' expects a non-empty VIN to do the look up")
+	}
+
+	vinErr := ValidateVin(vin)
+	if vinErr != nil {
+		return errorResponseFrom(vinErr)
 	}
 
 	// fetch the car from the ledger
 	carResponse := t.read(stub, vin)
 	car := Car{}
-	err := json.Unmarshal(carResponse.Payload, &car)
+	err := ledgerjson.Unmarshal(carResponse.Payload, &car)
+	if err != nil || IsArchived(&car) {
+		return errorResponse(ErrCarNotFound, "Failed to fetch car with vin '" + vin + "' from ledger")
+	}
+
+	// check if the user owns the car or was granted access
+	allowed, err := t.canRead(stub, username, vin)
 	if err != nil {
-		return shim.Error("Failed to fetch car with vin '" + vin + "' from ledger")
+		return errorResponseFrom(err)
+	} else if !allowed {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
 	}
 
-	// fetch the car index to check if the user owns the car
-	owner, err := t.getOwner(stub, vin)
+	if len(args) < 2 {
+		return shim.Success(carResponse.Payload)
+	}
+
+	fields := []string{}
+	err = ledgerjson.Unmarshal([]byte(args[1]), &fields)
 	if err != nil {
-		return shim.Error(err.Error())
-	} else if owner != username {
-		return shim.Error("Forbidden: this is not your car")
+		return errorResponse(ErrInvalidArgument, "'readCar' expects a JSON array of field names")
 	}
 
-	return shim.Success(carResponse.Payload)
+	projectionAsBytes, err := projectCar(carResponse.Payload, fields)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	return shim.Success(projectionAsBytes)
 }
 
 /*
  * Sell a car to a new owner (receiver).
  *
  * The car can only be sold if the buyer/receiver
- * has enough credits (balance sufficiently high)
+ * has enough credits (balance sufficiently high).
+ * Sales far below the reference value of the model
+ * are held back for the DOT, see 'valuation.go'.
  *
  * Arguments required:
  * [0] Price                       (int)
  * [1] VIN of the car to transfer  (string)
  * [2] Buyer username              (string)
  *
+ * Emits 'carSold' with the sale, or 'saleFlagged'
+ * with the sale review.
+ *
  * On success,
- * returns the car.
+ * returns the car, or the sale review.
  */
 func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, args []string) pb.Response {
 	price := args[0]
@@ -239,9 +429,73 @@ func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, arg
 
 	// price input sanitation
 	if price == "" || priceAsInt < 0 {
-		return shim.Error("'sell' expects a non-empty, positive price")
+		return errorResponse(ErrInvalidArgument, "'sell' expects a non-empty, positive price")
+	}
+
+	// this already checks for ownership
+	car, err := t.getCar(stub, seller, vin)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// a flagged sale has to be reviewed first
+	review, err := getSaleReview(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if review != nil && review.Status == saleReviewPending {
+		return errorResponse(ErrInvalidState, "A sale of the car is waiting for review by the DOT")
+	}
+
+	// compare the declared price with the reference value
+	if car.Certificate.Brand != "" && car.Certificate.Model != "" {
+		reference, err := getReferenceValue(stub, car.Certificate.Brand, car.Certificate.Model)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if reference != nil && isUndervalued(config, priceAsInt, reference.Value) {
+			return t.flagSale(stub, seller, buyer, vin, priceAsInt, reference.Value)
+		}
+	}
+
+	return t.completeSale(stub, config, seller, buyer, vin, priceAsInt, priceAsInt)
+}
+
+/*
+ * Completes the sale of car 'vin' for 'price'. The buyer
+ * pays the transfer tax on 'taxBase' on top of the price,
+ * a deposit of the buyer on the car counts towards it.
+ *
+ * Emits 'carSold' with the sale.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) completeSale(stub shim.ChaincodeStubInterface, config Config, seller string, buyer string, vin string, priceAsInt int, taxBase int) pb.Response {
+	car, err := t.checkTransfer(stub, seller, vin, buyer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the deposit converts into the purchase,
+	// a lapsed deposit of someone else goes back
+	deposit := 0
+	if hold := car.Listing.Hold; hold != nil && hold.Buyer == buyer {
+		deposit = hold.Amount
+	} else if hold != nil {
+		_, err = t.updateBalance(stub, depositPayer(hold), hold.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	// the buyer pays the transfer tax on top of the price
+	tax := scheduledFee(config, transferTaxName, taxBase, 0)
+	cost := priceAsInt + tax - deposit
+
 	//////////////////////////////////////////////////////////
 	//                     BUYER                            //
 	//////////////////////////////////////////////////////////
@@ -253,21 +507,21 @@ func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, arg
 		// create and give her some credits to buy cars
 		userResponse := t.createUser(stub, buyer)
 		buyerAsUser = User{}
-		err = json.Unmarshal(userResponse.Payload, &buyerAsUser)
+		err = ledgerjson.Unmarshal(userResponse.Payload, &buyerAsUser)
 		if err != nil {
-			return shim.Error("Error creating new buyer")
+			return errorResponse(ErrLedger, "Error creating new buyer")
 		}
 	}
 
 	// check buyer balance
-	if buyerAsUser.Balance < priceAsInt {
-		return shim.Error("Buyer has not enough credits")
+	if buyerAsUser.Balance < cost {
+		return errorResponse(ErrInsufficientFunds, "Buyer has not enough credits")
 	}
 
 	// update buyer balance
-	buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance-priceAsInt)
+	buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance-cost)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	fmt.Printf("Balance of user %s (buyer) updated, is now: %n\n", buyer, buyerAsUser.Balance)
@@ -282,23 +536,24 @@ func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, arg
 		// Temporary fix for tests (ToDo: Fix User creation in tests)
 		fmt.Printf("Error fetching old car owner. Creating new one.")
 		userAsBytes := t.createUser(stub, seller)
-		err := json.Unmarshal(userAsBytes.Payload, &sellerAsUser)
+		err := ledgerjson.Unmarshal(userAsBytes.Payload, &sellerAsUser)
 		if err != nil {
-			return shim.Error("Error unmarshaling user payload.")
+			return errorResponse(ErrInvalidArgument, "Error unmarshaling user payload.")
 		}
 		// return shim.Error("Error fetching seller")
 	}
 
-	// update sellers balance
-	sellerAsUser, err = t.setBalance(stub, seller, sellerAsUser.Balance+priceAsInt)
+	// update sellers balance, the listing stake goes back
+	stake := releaseListingStake(&car)
+	sellerAsUser, err = t.setBalance(stub, seller, sellerAsUser.Balance+priceAsInt+stake)
 	if err != nil {
 		// undo successful 'buyer' transaction
-		buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance+priceAsInt)
+		buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance+cost)
 		if err != nil {
-			return shim.Error("State corrupted")
+			return errorResponse(ErrLedger, "State corrupted")
 		}
 
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	fmt.Printf("Balance of user %s (seller) updated, is now: %n\n", seller, sellerAsUser.Balance)
@@ -310,40 +565,63 @@ func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, arg
 	// Writing updated buyer back to ledger
 	err = t.saveUser(stub, buyerAsUser)
 	if err != nil {
-		return shim.Error("Error saving updated buyer!")
+		return errorResponse(ErrLedger, "Error saving updated buyer!")
 	}
 
 	// writing updated seller back to ledger
 	err = t.saveUser(stub, sellerAsUser)
 	if err != nil {
-		return shim.Error("Error saving updated seller!")
+		return errorResponse(ErrLedger, "Error saving updated seller!")
 	}
 
 	//////////////////////////////////////////////////////////
 	//                       CAR                            //
 	//////////////////////////////////////////////////////////
 
-	// remove price from args
-	args = args[1:]
+	err = recordPrice(stub, &car, priceKindSale, priceAsInt)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordCompletedSale(stub, seller, buyer, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// a deposit funded by a loan pledges the car to the bank
+	if hold := car.Listing.Hold; hold != nil && hold.Buyer == buyer {
+		err = pledgeCar(stub, &car, hold)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
 
 	// transfer car
-	response := t.transfer(stub, seller, args)
-	car := Car{}
-	err = json.Unmarshal(response.Payload, &car)
+	response := t.changeOwner(stub, car, seller, buyer)
+	// decode into an empty car, fields left out of the
+	// payload, like the deposit hold, must not survive
+	car = Car{}
+	err = ledgerjson.Unmarshal(response.Payload, &car)
 	if err != nil {
 		// undo SELLER and BUYER balance updates if unsucessfull
 		// is there a 'hfc transaction' for automation of this scenario?
-		buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance+priceAsInt)
+		buyerAsUser, err = t.setBalance(stub, buyer, buyerAsUser.Balance+cost)
 		if err != nil {
-			return shim.Error("State corrupted")
+			return errorResponse(ErrLedger, "State corrupted")
 		}
 
-		sellerAsUser, err = t.setBalance(stub, seller, sellerAsUser.Balance-priceAsInt)
+		sellerAsUser, err = t.setBalance(stub, seller, sellerAsUser.Balance-priceAsInt-stake)
 		if err != nil {
-			return shim.Error("State corrupted")
+			return errorResponse(ErrLedger, "State corrupted")
 		}
 
-		return shim.Error("Error transferring car, transaction not successfull")
+		return errorResponse(ErrLedger, "Error transferring car, transaction not successfull")
+	}
+
+	// the transfer tax goes to the treasury
+	err = t.collectFee(stub, transferTaxName, tax)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
 	//////////////////////////////////////////////////////////
@@ -351,10 +629,23 @@ func (t *CarChaincode) sell(stub shim.ChaincodeStubInterface, seller string, arg
 	//////////////////////////////////////////////////////////
 
 	// write updated car back to ledger
-	carAsBytes, _ := json.Marshal(car)
+	carAsBytes, _ := ledgerjson.Marshal(car)
 	err = stub.PutState(vin, carAsBytes)
 	if err != nil {
-		return shim.Error("Error writing updated car to ledger")
+		return errorResponse(ErrLedger, "Error writing updated car to ledger")
+	}
+
+	// let off-chain subscribers know about the sale
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	sale := CarSale{Vin: vin, Seller: seller, Buyer: buyer, Price: priceAsInt, Ts: now, Warnings: transferWarnings(&car)}
+	saleAsBytes, _ := ledgerjson.Marshal(sale)
+	err = stub.SetEvent("carSold", saleAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting 'carSold' event")
 	}
 
 	return shim.Success(response.Payload)
@@ -375,103 +666,232 @@ func (t *CarChaincode) transfer(stub shim.ChaincodeStubInterface, username strin
 	newCarOwnerUsername := args[1]
 
 	if vin == "" {
-		return shim.Error("'transfer' expects a non-empty VIN to do the transfer")
+		return errorResponse(ErrInvalidArgument, "'transfer' expects a non-empty VIN to do the transfer")
 	}
 
 	if newCarOwnerUsername == "" {
-		return shim.Error("'transfer' expects a non-empty car receiver username to do the transfer")
+		return errorResponse(ErrInvalidArgument, "'transfer' expects a non-empty car receiver username to do the transfer")
+	}
+
+	car, err := t.checkTransfer(stub, username, vin, newCarOwnerUsername)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// deposits only convert into a sale
+	if car.Listing.Hold != nil {
+		return errorResponse(ErrInvalidState, "A deposit is placed on the car. Sell it to the buyer or cancel the deposit first")
+	}
+
+	err = recordPrice(stub, &car, priceKindTransfer, 0)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
+	return t.changeOwner(stub, car, username, newCarOwnerUsername)
+}
+
+/*
+ * Reads car 'vin' of 'username' and checks
+ * that it may go to 'newCarOwnerUsername'
+ */
+func (t *CarChaincode) checkTransfer(stub shim.ChaincodeStubInterface, username string, vin string, newCarOwnerUsername string) (Car, error) {
 	// fetch the car from the ledger
 	// this already checks for ownership
 	car, err := t.getCar(stub, username, vin)
 	if err != nil {
-		return shim.Error("Failed to fetch car with vin '" + vin + "' from ledger")
+		return Car{}, err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return Car{}, err
+	}
+
+	err = t.checkMovable(stub, &car)
+	if err != nil {
+		return Car{}, err
 	}
 
 	// check if car is not confirmed anymore
-	if IsConfirmed(&car) {
-		return shim.Error("The car is still confirmed. It has to be revoked first in order to do the transfer")
+	if IsConfirmed(&car, now) {
+		return Car{}, newError(ErrInvalidState, "The car is still confirmed. It has to be revoked first in order to do the transfer")
 	}
 
-	// transfer:
-	// change of ownership in the car certificate
-	car.Certificate.Username = newCarOwnerUsername
+	// cars held by a deposit go to the depositor
+	err = checkHold(&car, newCarOwnerUsername, now)
+	if err != nil {
+		return Car{}, err
+	}
 
-	// write car with udpated certificate back to ledger
-	carAsBytes, _ := json.Marshal(car)
-	err = stub.PutState(vin, carAsBytes)
+	// and queued cars to the first buyer in line
+	err = checkQueue(&car, newCarOwnerUsername)
 	if err != nil {
-		return shim.Error("Error writing car")
+		return Car{}, err
 	}
 
-	// get the old car owner
-	oldOwner, err := t.getUser(stub, username)
+	// co-owners have to consent
+	err = checkTransferConsent(&car, username, newCarOwnerUsername)
+	if err != nil {
+		return Car{}, err
+	}
 
-	// go through all his cars
-	// and remove the car we just transferred
-	cars := oldOwner.Cars
-	var newCarList []string
-	for i, carVin := range cars {
-		newCarList = append(newCarList, carVin)
-		if carVin == car.Vin {
-			// remove car from new list
-			newCarList = newCarList[:i]
-		}
+	return car, nil
+}
+
+/*
+ * Checks that 'car' may leave its owner,
+ * whoever it goes to
+ */
+func (t *CarChaincode) checkMovable(stub shim.ChaincodeStubInterface, car *Car) error {
+	// cars moving to another channel cannot be transferred
+	if !IsActive(car) {
+		return newError(ErrNotActive, "The car is handed off to another channel and cannot be transferred")
 	}
 
-	// save the new car list without the transferred
-	// car to the old owner
-	oldOwner.Cars = newCarList
+	// nor can cars under VIN investigation
+	if IsFrozen(car) {
+		return newError(ErrInvalidState, "The car is frozen and cannot be transferred")
+	}
+
+	// rented cars stay with the owner
+	if IsRented(car) {
+		return newError(ErrInvalidState, "The car is rented out. The rental has to end first in order to do the transfer")
+	}
+
+	// cars sold in installments go to the buyer
+	if IsSoldInInstallments(car) {
+		return newError(ErrInvalidState, "The car is sold in installments. The plan has to end first in order to do the transfer")
+	}
+
+	// cars pledged to a bank stay with the owner
+	err := checkLien(car)
+	if err != nil {
+		return err
+	}
 
-	// write the old owner back to state
-	err = t.saveUser(stub, oldOwner)
+	// cars with unpaid toll above the threshold too
+	config, err := t.getConfig(stub)
 	if err != nil {
-		return shim.Error("Error writing old owner")
+		return err
 	}
 
+	return checkTollDebt(car, config)
+}
+
+/*
+ * Moves 'car' from 'username' to 'newCarOwnerUsername',
+ * after the caller checked that it may be transferred.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) changeOwner(stub shim.ChaincodeStubInterface, car Car, username string, newCarOwnerUsername string) pb.Response {
 	// get the receiver of the car
 	// (new car owner)
-	newOwner, err := t.getUser(stub, newCarOwnerUsername)
+	_, err := t.getUser(stub, newCarOwnerUsername)
 
 	if err != nil {
 		fmt.Println("New car owner (receiver) does not exist. Creating this user.")
 		userResponse := t.createUser(stub, newCarOwnerUsername)
-		newOwner = User{}
-		err = json.Unmarshal(userResponse.Payload, &newOwner)
+		newOwner := User{}
+		err = ledgerjson.Unmarshal(userResponse.Payload, &newOwner)
 		if err != nil {
-			return shim.Error("Error creating new car owner")
+			return errorResponse(ErrLedger, "Error creating new car owner")
 		}
 	}
 
-	// attach the car to the receiver (new car owner)
-	newOwner.Cars = append(newOwner.Cars, car.Vin)
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	_, stocked := inventory[username][car.Vin]
 
+	stake, err := t.handOver(stub, &car, username, newCarOwnerUsername, inventory)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
-	// write back the new owner (reveiver) to state
-	err = t.saveUser(stub, newOwner)
+	// the old owner gets the listing stake back
+	if stake > 0 {
+		_, err = t.updateBalance(stub, username, stake)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	// the car leaves the stock of a garage
+	if stocked {
+		err = t.saveInventoryIndex(stub, inventory)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	// car transfer successfull,
+	// return the car
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Writes 'car' with 'newCarOwnerUsername' as owner and
+ * its car index entry, and moves it in 'inventory', which
+ * the caller writes back. Several cars can change hands in
+ * one transaction this way, as it does not see its own
+ * writes.
+ *
+ * Returns the listing stake going back to 'username'.
+ */
+func (t *CarChaincode) handOver(stub shim.ChaincodeStubInterface, car *Car, username string, newCarOwnerUsername string, inventory map[string]map[string]InventoryEntry) (int, error) {
+	// transfer:
+	// change of ownership in the car certificate
+	// the receiver becomes the single owner
+	car.Certificate.Username = newCarOwnerUsername
+	car.CoOwnership = CoOwnership{}
+	car.Drivers = nil
+	car.Toll.Account = ""
+	car.Toll.LinkedTs = 0
+	stake := releaseListingStake(car)
+	car.Listing = Listing{}
+
+	// write car with udpated certificate back to ledger
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return 0, newError(ErrLedger, "Error writing car")
+	}
+
+	// the car leaves the old owner
+	err = removeOwnership(stub, username, car.Vin)
 	if err != nil {
-		return shim.Error("Error writing new car owner (receiver)")
+		return 0, err
 	}
 
-	// get the car index
-	carIndex, err := t.getCarIndex(stub)
+	// attach the car to the receiver (new car owner)
+	err = addOwnership(stub, newCarOwnerUsername, car.Vin)
 	if err != nil {
-		return shim.Error("Error fetching car index")
+		return 0, err
 	}
 
 	// update the car index to represent
 	// the new ownership rights
-	carIndex[car.Vin] = newOwner.Name
+	pseudonym, err := registerPseudonym(stub, newCarOwnerUsername)
+	if err != nil {
+		return 0, err
+	}
 
-	// write the car index back to ledger
-	indexAsBytes, _ := json.Marshal(carIndex)
-	err = stub.PutState(carIndexStr, indexAsBytes)
+	err = indexCar(stub, car.Vin, pseudonym)
 	if err != nil {
-		return shim.Error("Error writing car index")
+		return 0, err
 	}
 
-	// car transfer successfull,
-	// return the car
-	return shim.Success(carAsBytes)
+	// read grants of the old owner do not carry over
+	err = t.clearReadGrants(stub, car.Vin)
+	if err != nil {
+		return 0, err
+	}
+
+	delete(inventory[username], car.Vin)
+	return stake, nil
 }
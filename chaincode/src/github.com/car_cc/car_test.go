@@ -3,12 +3,17 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
+// pseudonym secret for test mocks
+const testPseudonymSecret string = "0123456789abcdef0123456789abcdef"
+
 func ccSetup(t *testing.T, stub *shim.MockStub) {
 	// a successfull init should not return any errors
 	response := stub.MockInit(uuid, util.ToChaincodeArgs("init", "999"))
@@ -29,10 +34,16 @@ func ccSetup(t *testing.T, stub *shim.MockStub) {
 		t.Error("Aval for testing should be '999', but is '%d'", aval)
 	}
 
+	// car owners are pseudonymous, the DOT sets the secret
+	stub.TransientMap = map[string][]byte{pseudonymSecretTransient: []byte(testPseudonymSecret)}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setPseudonymSecret", "dot", "dot"))
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
 	// check out the empty car index
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", carIndexStr))
-	carIndex := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &carIndex)
+	carIndex, err := (&CarChaincode{}).getCarIndex(stub)
 
 	if err != nil {
 		t.Error(err.Error())
@@ -57,7 +68,7 @@ func TestInit(t *testing.T) {
 func TestTransferCar(t *testing.T) {
 	var username string = "amag"
 	var receiver string = "bobby"
-	var vin string = "WVW ZZZ 6RZ HY26 0780"
+	var vin string = "WVWZZZ6R6HY260780"
 
 	// create and name a new chaincode mock
 	carChaincode := &CarChaincode{}
@@ -66,8 +77,8 @@ func TestTransferCar(t *testing.T) {
 	ccSetup(t, stub)
 
 	// creat new users (amag, bobby)
-	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username))
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", receiver))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "user"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", receiver, "user"))
 
 	// create a new car
 	carData := `{ "vin": "` + vin + `" }`
@@ -118,7 +129,7 @@ func TestTransferCar(t *testing.T) {
 	}
 
 	// checkout bobbys user record
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_" + receiver))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", receiver, "user"))
 	receiverAsUser := User {}
 	err = json.Unmarshal(response.Payload, &receiverAsUser)
 	if err != nil {
@@ -132,7 +143,7 @@ func TestTransferCar(t *testing.T) {
 	}
 
 	// checkout the old owners user record
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_" + username))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "garage"))
 	oldOwnerAsUser := User {}
 	err = json.Unmarshal(response.Payload, &oldOwnerAsUser)
 	if err != nil {
@@ -148,13 +159,11 @@ func TestTransferCar(t *testing.T) {
 
 	// check out the new car index and see
 	// that ownership righs are registered properly
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", carIndexStr))
-	carIndex := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &carIndex)
+	carIndex, err := (&CarChaincode{}).getCarIndex(stub)
 
 	fmt.Printf("Car index after transfer: %v\n", carIndex)
 
-	if carIndex[vin] != receiver {
+	if pseudonym, _ := pseudonymOf(stub, receiver); carIndex[vin] != pseudonym {
 		t.Error("Car transfer unsuccessfull")
 	}
 }
@@ -162,7 +171,7 @@ func TestTransferCar(t *testing.T) {
 func TestSellCar(t *testing.T) {
 	var username string = "amag"
 	var receiver string = "bobby"
-	var vin string = "WVW ZZZ 6RZ HY26 0780"
+	var vin string = "WVWZZZ6R6HY260780"
 
 	// create and name a new chaincode mock
 	carChaincode := &CarChaincode{}
@@ -172,6 +181,7 @@ func TestSellCar(t *testing.T) {
 
 	// create a new car
 	carData := `{ "vin": "` + vin + `" }`
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
 	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
 	// payload should contain the car
@@ -203,6 +213,18 @@ func TestSellCar(t *testing.T) {
 		t.Error("Error selling car")
 	}
 
+	// the sale is announced to off-chain subscribers
+	sale := CarSale{}
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		if event.EventName == "carSold" {
+			json.Unmarshal(event.Payload, &sale)
+		}
+	}
+	if sale.Vin != vin || sale.Seller != username || sale.Buyer != receiver || sale.Price != 99 {
+		t.Errorf("Expected a 'carSold' event for the sale, got %v", sale)
+	}
+
 	// check that the old owner has no longer access to the car
 	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", car.Vin))
 	err = json.Unmarshal(response.Payload, &car)
@@ -219,7 +241,7 @@ func TestSellCar(t *testing.T) {
 	}
 
 	// checkout bobbys user record
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_" + receiver))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", receiver, "user"))
 	receiverAsUser := User {}
 	err = json.Unmarshal(response.Payload, &receiverAsUser)
 	if err != nil {
@@ -233,7 +255,7 @@ func TestSellCar(t *testing.T) {
 	}
 
 	// checkout the old owners user record
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_" + username))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "garage"))
 	oldOwnerAsUser := User {}
 	err = json.Unmarshal(response.Payload, &oldOwnerAsUser)
 	if err != nil {
@@ -249,13 +271,11 @@ func TestSellCar(t *testing.T) {
 
 	// check out the new car index and see
 	// that ownership righs are registered properly
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", carIndexStr))
-	carIndex := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &carIndex)
+	carIndex, err := (&CarChaincode{}).getCarIndex(stub)
 
 	fmt.Printf("Car index after transfer: %v\n", carIndex)
 
-	if carIndex[vin] != receiver {
+	if pseudonym, _ := pseudonymOf(stub, receiver); carIndex[vin] != pseudonym {
 		t.Error("Car transfer unsuccessfull")
 	}
 
@@ -272,7 +292,7 @@ func TestSellCar(t *testing.T) {
 
 func TestCreateAndReadCar(t *testing.T) {
 	username := "amag"
-	vin := "WVW ZZZ 6RZ HY26 0780"
+	vin := "WVWZZZ6R6HY260780"
 
 	// create and name a new chaincode mock
 	carChaincode := &CarChaincode{}
@@ -287,6 +307,8 @@ func TestCreateAndReadCar(t *testing.T) {
                            "number_of_cylinders":  4,
                            "number_of_axis":       2,
                            "max_speed":            200 }`
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+	certify(stub, username, scopeInspection)
 	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData, registrationData))
 
 	// payload should contain the car
@@ -299,15 +321,13 @@ func TestCreateAndReadCar(t *testing.T) {
 	fmt.Printf("Successfully created car with ts '%d'\n", carCreated.CreatedTs)
 
 	// check out the car index, should contain one car
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", carIndexStr))
-	carIndex := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &carIndex)
+	carIndex, err := (&CarChaincode{}).getCarIndex(stub)
 
 	if err != nil {
 		t.Error("Failed to fetch car index")
 	} else if len(carIndex) > 1 {
 		t.Error("The car index should only contain one car by now")
-	} else if carIndex[carCreated.Vin] != username {
+	} else if pseudonym, _ := pseudonymOf(stub, username); carIndex[carCreated.Vin] != pseudonym {
 		t.Error("This is not the car '" + username + "' created")
 	}
 
@@ -323,7 +343,7 @@ func TestCreateAndReadCar(t *testing.T) {
 		t.Error("This is not the car you created before")
 	}
 
-	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_" + username))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "garage"))
 	user := User {}
 	err = json.Unmarshal(response.Payload, &user)
 	if err != nil {
@@ -348,3 +368,82 @@ func TestCreateAndReadCar(t *testing.T) {
 
 	fmt.Println(carFetched)
 }
+
+func TestGrantAndRevokeReadAccess(t *testing.T) {
+	username := "amag"
+	reader := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	// create and name a new chaincode mock
+	carChaincode := &CarChaincode{}
+	stub := shim.NewMockStub("car", carChaincode)
+
+	ccSetup(t, stub)
+
+	carData := `{ "vin": "` + vin + `" }`
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+
+	// bobby cannot read the car yet
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", reader, "user", vin))
+	if response.Status == shim.OK {
+		t.Error("Only the owner should be able to read the car")
+	}
+
+	// bobby cannot grant himself access
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantReadAccess", reader, "user", vin, reader+"2", expiry))
+	if response.Status == shim.OK {
+		t.Error("Only the owner should be able to grant read access")
+	}
+
+	// expired grants are rejected
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantReadAccess", username, "garage", vin, reader, "1"))
+	if response.Status == shim.OK {
+		t.Error("Expired read grants should be rejected")
+	}
+
+	// the owner grants access
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantReadAccess", username, "garage", vin, reader, expiry))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", reader, "user", vin))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil || car.Vin != vin {
+		t.Error("Bobby should be able to read the car")
+	}
+
+	// the owner revokes access again
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revokeReadAccess", username, "garage", vin, reader))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", reader, "user", vin))
+	if response.Status == shim.OK {
+		t.Error("Bobby should no longer be able to read the car")
+	}
+}
+
+func TestCreatedTsFromTransaction(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	carData := `{ "vin": "WVWZZZ6R6HY260780" }`
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "garage"))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "amag", "garage", carData))
+
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// all peers must record the same creation time
+	if car.CreatedTs != stub.TxTimestamp.Seconds {
+		t.Error("Car creation time should be the transaction timestamp")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Car index.
+ *
+ * The owner of every car is indexed under 'car~<vin>',
+ * holding the owner pseudonym, see 'pseudonymOf'. One key
+ * per car lets cars change hands in parallel transactions
+ * without rewriting a shared index, and a transaction
+ * moving several cars writes each key once. Archived cars
+ * have no index entry.
+ */
+
+// object type of car index keys
+const carIndexObjectType string = "car"
+
+/*
+ * Returns the car index key of car 'vin'
+ */
+func getCarIndexKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(carIndexObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating car index key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the owner pseudonym of car 'vin' from
+ * the car index, "" if the car is not indexed
+ */
+func getIndexedOwner(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := getCarIndexKey(stub, vin)
+	if err != nil {
+		return "", err
+	}
+
+	pseudonymAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return "", newError(ErrLedger, "Error reading car index")
+	}
+
+	return string(pseudonymAsBytes), nil
+}
+
+/*
+ * Indexes car 'vin' with the owner 'pseudonym'
+ */
+func indexCar(stub shim.ChaincodeStubInterface, vin string, pseudonym string) error {
+	key, err := getCarIndexKey(stub, vin)
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutState(key, []byte(pseudonym))
+	if err != nil {
+		return newError(ErrLedger, fmt.Sprintf("Error indexing car '%s'", vin))
+	}
+
+	return nil
+}
+
+/*
+ * Removes car 'vin' from the car index
+ */
+func unindexCar(stub shim.ChaincodeStubInterface, vin string) error {
+	key, err := getCarIndexKey(stub, vin)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return newError(ErrLedger, fmt.Sprintf("Error removing car '%s' from the car index", vin))
+	}
+
+	return nil
+}
+
+/*
+ * Returns the whole car index, VIN to owner pseudonym.
+ * Only for queries going over all cars, single cars are
+ * looked up with 'getIndexedOwner'.
+ */
+func (t *CarChaincode) getCarIndex(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(carIndexObjectType, []string{})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading car index")
+	}
+	defer iterator.Close()
+
+	carIndex := make(map[string]string)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading car index")
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 1 {
+			return nil, newError(ErrLedger, "Error parsing car index key")
+		}
+		carIndex[attributes[0]] = string(kv.Value)
+	}
+
+	return carIndex, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Vehicle catalog.
+ *
+ * Admins keep the makes, models and variants cars are built
+ * as under 'catalog~<id>'. A car created with the id of an
+ * entry in 'certificate.catalog_id' takes brand, model and
+ * variant from the entry, so the same model is spelled the
+ * same on every car. With 'catalog_required' in the config,
+ * 'create' rejects cars without a catalog entry.
+ */
+
+// object type of catalog keys
+const catalogObjectType string = "catalog"
+
+/*
+ * Returns the ledger key of a catalog entry
+ */
+func getCatalogKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(catalogObjectType, []string{id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating catalog key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads a catalog entry.
+ *
+ * Returns 'nil' if there is no entry with that id.
+ */
+func getCatalogEntry(stub shim.ChaincodeStubInterface, id string) (*CatalogEntry, error) {
+	key, err := getCatalogKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entryAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading catalog entry")
+	} else if entryAsBytes == nil {
+		return nil, nil
+	}
+
+	entry := CatalogEntry{}
+	err = ledgerjson.Unmarshal(entryAsBytes, &entry)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing catalog entry")
+	}
+
+	return &entry, nil
+}
+
+/*
+ * Fills brand, model and variant of a new car from its
+ * catalog entry. Values given with the car must match
+ * the entry.
+ */
+func applyCatalogEntry(car *Car, entry *CatalogEntry) error {
+	fields := []struct {
+		name  string
+		value *string
+		want  string
+	}{
+		{"brand", &car.Certificate.Brand, entry.Brand},
+		{"model", &car.Certificate.Model, entry.Model},
+		{"variant", &car.Certificate.Variant, entry.Variant},
+	}
+
+	for _, field := range fields {
+		if *field.value != "" && *field.value != field.want {
+			return newError(ErrInvalidArgument, fmt.Sprintf("The %s '%s' does not match catalog entry '%s', which has '%s'", field.name, *field.value, entry.Id, field.want))
+		}
+		*field.value = field.want
+	}
+
+	return nil
+}
+
+/*
+ * Adds an entry to the vehicle catalog.
+ * Entries are never changed, add a new one instead.
+ *
+ * On success,
+ * returns the catalog entry.
+ */
+func (t *CarChaincode) addCatalogEntry(stub shim.ChaincodeStubInterface, role string, entryAsJson string) pb.Response {
+	entry := CatalogEntry{}
+	err := ledgerjson.Unmarshal([]byte(entryAsJson), &entry)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'addCatalogEntry' expects a catalog entry as json")
+	}
+
+	if entry.Id == "" || entry.Brand == "" || entry.Model == "" {
+		return errorResponse(ErrInvalidArgument, "'addCatalogEntry' expects a non-empty id, brand and model")
+	} else if _, ok := emissionBadges[entry.EmissionClass]; entry.EmissionClass != "" && !ok {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Unknown emission class '%s'", entry.EmissionClass))
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAdmin(stub, config, role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	existing, err := getCatalogEntry(stub, entry.Id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if existing != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Catalog entry '%s' already exists", entry.Id))
+	}
+
+	entry.AddedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := getCatalogKey(stub, entry.Id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	entryAsBytes, _ := ledgerjson.Marshal(entry)
+	err = stub.PutState(key, entryAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing catalog entry")
+	}
+
+	fmt.Printf("Catalog entry '%s' added for %s %s %s\n", entry.Id, entry.Brand, entry.Model, entry.Variant)
+	return shim.Success(entryAsBytes)
+}
+
+/*
+ * Searches the vehicle catalog. Brand and model,
+ * if given, match the start of the entry values,
+ * ignoring case.
+ *
+ * On success,
+ * returns the matching entries, ordered by id.
+ */
+func (t *CarChaincode) searchCatalog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	brand := ""
+	model := ""
+	if len(args) > 0 {
+		brand = strings.ToLower(args[0])
+	}
+	if len(args) > 1 {
+		model = strings.ToLower(args[1])
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(catalogObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading catalog")
+	}
+	defer iterator.Close()
+
+	entries := []CatalogEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading catalog")
+		}
+
+		entry := CatalogEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing catalog entry")
+		}
+
+		if strings.HasPrefix(strings.ToLower(entry.Brand), brand) && strings.HasPrefix(strings.ToLower(entry.Model), model) {
+			entries = append(entries, entry)
+		}
+	}
+
+	entriesAsBytes, _ := ledgerjson.Marshal(entries)
+	return shim.Success(entriesAsBytes)
+}
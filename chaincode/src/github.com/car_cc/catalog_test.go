@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCatalog(t *testing.T) {
+	garage := "amag"
+	polo := `{ "id": "vw-polo-tsi", "brand": "VW", "model": "Polo", "variant": "1.0 TSI", "engine": "DKLA", "emission_class": "euro6" }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("addCatalogEntry", garage, "garage", polo))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addCatalogEntry", "admin", "admin",
+		`{ "id": "vw-golf", "brand": "VW", "model": "Golf", "emission_class": "euro7" }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addCatalogEntry", "admin", "admin", polo))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addCatalogEntry", "admin", "admin", polo))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addCatalogEntry", "admin", "admin",
+		`{ "id": "bmw-i3", "brand": "BMW", "model": "i3", "emission_class": "electric" }`))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("searchCatalog", "bobby", "user", "vw"))
+	entries := []CatalogEntry{}
+	json.Unmarshal(response.Payload, &entries)
+	if len(entries) != 1 || entries[0].Id != "vw-polo-tsi" {
+		t.Errorf("Expected the Polo only, got %s", response.Payload)
+	}
+
+	// the catalog entry fills in brand, model and variant
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "WVWZZZ6R6HY260780", "certificate": { "catalog_id": "vw-polo-tsi" } }`))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Brand != "VW" || car.Certificate.Model != "Polo" || car.Certificate.Variant != "1.0 TSI" {
+		t.Errorf("Expected the car to take the catalog values, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "WVWZZZ6R8HY260781", "certificate": { "catalog_id": "vw-polo-tsi", "model": "Pollo" } }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "WVWZZZ6R8HY260781", "certificate": { "catalog_id": "vw-up" } }`))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// once required, cars without catalog entry are rejected
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "catalog_required": true }`))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6R8HY260781" }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Certificate revisions.
+ *
+ * Every confirmation issues a new revision of the car
+ * certificate. The revision records who issued it, when,
+ * and a hash of the car state at that moment, and is kept
+ * under 'certificate~<vin>~<version>', so documents printed
+ * from an older revision can still be verified off-chain.
+ */
+
+// object type of certificate revision keys
+const certificateObjectType string = "certificate"
+
+/*
+ * Returns the ledger key of certificate revision 'version' of car 'vin'.
+ *
+ * The version is zero padded, so revisions sort in order.
+ */
+func getCertificateKey(stub shim.ChaincodeStubInterface, vin string, version int) (string, error) {
+	key, err := stub.CreateCompositeKey(certificateObjectType, []string{vin, fmt.Sprintf("%08d", version)})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating certificate key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Returns the hash of the car state, without
+ * the revision fields of the certificate.
+ */
+func carStateHash(car Car) string {
+	car.Certificate.Version = 0
+	car.Certificate.IssuedBy = ""
+	car.Certificate.IssuedTs = 0
+	car.Certificate.StateHash = ""
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	hash := sha256.Sum256(carAsBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Issues a new revision of the certificate of 'car'
+ * and stores it on the ledger.
+ *
+ * The caller writes the car itself.
+ */
+func (t *CarChaincode) issueCertificate(stub shim.ChaincodeStubInterface, car *Car) error {
+	issuer, err := getCallerIdentity(stub)
+	if err != nil {
+		return err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	car.Certificate.Version++
+	car.Certificate.IssuedBy = issuer
+	car.Certificate.IssuedTs = now
+	car.Certificate.StateHash = carStateHash(*car)
+
+	key, err := getCertificateKey(stub, car.Vin, car.Certificate.Version)
+	if err != nil {
+		return err
+	}
+
+	revision := CertificateRevision{
+		Vin:         car.Vin,
+		Version:     car.Certificate.Version,
+		Certificate: car.Certificate}
+
+	revisionAsBytes, _ := ledgerjson.Marshal(revision)
+	err = stub.PutState(key, revisionAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing certificate")
+	}
+
+	return nil
+}
+
+/*
+ * Reads an issued certificate revision.
+ *
+ * Certificates are public documents, so anyone
+ * holding the VIN can verify them.
+ *
+ * Arguments required:
+ * [0] VIN                         (string)
+ *
+ * Arguments optional:
+ * [1] Version                     (int)
+ *     defaults to the current revision
+ *
+ * On success,
+ * returns the certificate revision.
+ */
+func (t *CarChaincode) getCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	vin := args[0]
+
+	version := 0
+	if len(args) > 1 && args[1] != "" {
+		var err error
+		version, err = strconv.Atoi(args[1])
+		if err != nil || version < 1 {
+			return errorResponse(ErrInvalidArgument, "'getCertificate' expects a positive certificate version")
+		}
+	} else {
+		owner, err := t.getOwner(stub, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		car, err := t.getCar(stub, owner, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		version = car.Certificate.Version
+		if version == 0 {
+			return errorResponse(ErrNotConfirmed, fmt.Sprintf("No certificate was issued for car with VIN '%s' yet", vin))
+		}
+	}
+
+	key, err := getCertificateKey(stub, vin, version)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	revisionAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading certificate")
+	} else if revisionAsBytes == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no certificate version %d for car with VIN '%s'", version, vin))
+	}
+
+	return shim.Success(revisionAsBytes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCertificateRevisions(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, username, vin, "axa")
+
+	// no certificate before the confirmation
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getCertificate", username, "user", vin))
+	expectErrorCode(t, response, ErrNotConfirmed)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 7878"))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Certificate.Version != 1 || car.Certificate.IssuedTs == 0 {
+		t.Fatalf("Confirmation should issue certificate version 1, got: %v", car.Certificate)
+	}
+
+	// the hash matches the car state at issuance
+	if car.Certificate.StateHash != carStateHash(car) {
+		t.Error("Certificate hash does not match the car state")
+	}
+
+	// a second confirmation issues a new revision
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 1"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getCertificate", username, "user", vin))
+	revision := CertificateRevision{}
+	err = json.Unmarshal(response.Payload, &revision)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if revision.Version != 2 || revision.Certificate.Numberplate != "ZH 1" {
+		t.Errorf("Current certificate should be version 2, got: %v", revision)
+	}
+
+	// the first revision stays readable
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getCertificate", username, "user", vin, "1"))
+	revision = CertificateRevision{}
+	json.Unmarshal(response.Payload, &revision)
+	if revision.Version != 1 || revision.Certificate.Numberplate != "ZH 7878" || revision.Certificate.StateHash != car.Certificate.StateHash {
+		t.Errorf("Unexpected first certificate revision: %v", revision)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getCertificate", username, "user", vin, "3"))
+	expectErrorCode(t, response, ErrNotFound)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"reflect"
 	"strconv"
 	"strings"
 
@@ -17,11 +16,16 @@ type CarChaincode struct {
 const uuid string = "1"
 
 // indexes
-const carIndexStr string = "_cars"
 const userIndexStr string = "_users"
 const insurerIndexStr string = "_insurers"
-const registrationProposalIndexStr string = "_registrationProposals"
 const revocationProposalIndexStr string = "_revocationProposals"
+const exportIndexStr string = "_exports"
+const portfolioTransferIndexStr string = "_portfolioTransfers"
+const readGrantIndexStr string = "_readGrants"
+const inventoryIndexStr string = "_inventory"
+
+// configuration
+const configStr string = "_config"
 
 func (t *CarChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("Car demo Init")
@@ -30,51 +34,89 @@ func (t *CarChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	var err error
 
 	_, args := stub.GetFunctionAndParameters()
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1 integer to test chain.")
+	if len(args) < 1 || len(args) > 2 {
+		return errorResponse(ErrInvalidArgument, "Incorrect number of arguments. Expecting 1 integer to test chain and optionally the configuration.")
 	}
 
 	// initialize the chaincode
 	aval, err = strconv.Atoi(args[0])
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return errorResponse(ErrInvalidArgument, "Expecting integer value for asset holding")
 	}
 
 	// write the state to the ledger
 	// make a test var "abc" in order to able to query it and see if it worked
 	err = stub.PutState("abc", []byte(strconv.Itoa(aval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
+	}
+
+	// an upgrade runs Init again, keep the existing
+	// state and let 'migrate' convert it
+	deployed, err := t.hasState(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if deployed {
+		fmt.Println("Init found existing state, call 'migrate' if the schema changed")
+		return shim.Success(nil)
 	}
 
-	// clear the car index
-	err = clearStringIndex(carIndexStr, stub)
+	// fresh state is written in the current schema
+	err = t.setSchemaVersion(stub, currentSchemaVersion())
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	// clear the user index
 	err = clearStringIndex(userIndexStr, stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	// clear the revocation proposal index
 	err = clearStringIndex(revocationProposalIndexStr, stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	// clear the insurer index
 	err = clearInsurerIndex(insurerIndexStr, stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
+	}
+
+	// clear the export index
+	err = clearExportIndex(exportIndexStr, stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// clear the portfolio transfer index
+	err = clearPortfolioTransferIndex(portfolioTransferIndexStr, stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// clear the read grant index
+	err = clearReadGrantIndex(readGrantIndexStr, stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// clear the inventory index
+	err = clearInventoryIndex(inventoryIndexStr, stub)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
-	// clear the registration proposal index
-	err = clearRegistrationProposalIndex(registrationProposalIndexStr, stub)
+	// seed the configuration
+	configArg := ""
+	if len(args) > 1 {
+		configArg = args[1]
+	}
+	err = t.seedConfig(stub, configArg)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	fmt.Println("Init terminated")
@@ -85,13 +127,28 @@ func (t *CarChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
  * Invokes an action on the ledger.
  *
  * Expects 'username' and 'role' as first two parameters.
+ * The function is looked up in 'routes', which declares
+ * its arguments and the roles allowed to call it.
  * Unrestricted queries can only be done from test files.
  */
-func (t *CarChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+func (t *CarChaincode) Invoke(stub shim.ChaincodeStubInterface) (response pb.Response) {
 	function, args := stub.GetFunctionAndParameters()
 
+	// record the changed keys for incremental exports
+	ledger := stub
+	journal := newJournalStub(stub)
+	stub = journal
+	defer func() {
+		if response.Status == shim.OK {
+			err := journal.flush()
+			if err != nil {
+				response = errorResponseFrom(err)
+			}
+		}
+	}()
+
 	if len(args) < 2 {
-		return shim.Error("Invoke expects 'username' and 'role' as first two args.")
+		return errorResponse(ErrInvalidArgument, "Invoke expects 'username' and 'role' as first two args.")
 	}
 
 	username := args[0]
@@ -101,184 +158,86 @@ func (t *CarChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Printf("Invoke is running as user '%s' with role '%s'\n", username, role)
 	fmt.Printf("Invoke is running function '%s' with args: %s\n", function, strings.Join(args, ", "))
 
-	switch function {
-
-	// GENERAL FUNCTIONS
-	case "read":
-		if len(args) != 1 {
-			return shim.Error("'read' expects a key to do the look up")
-		} else if reflect.TypeOf(stub).String() != "*shim.MockStub" {
-			// only allow unrestricted queries from the test files
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to do unrestricted queries on the ledger.", role))
-		} else {
-			return t.read(stub, args[0])
-		}
-
-	case "readCar":
-		if len(args) != 1 {
-			return shim.Error("'readCar' expects a car vin to do the look up")
-		}
-		return t.readCar(stub, username, args[0])
-
-	// USER FUNCTIONS
-	case "createUser":
-		if len(args) != 0 {
-			return shim.Error("'creatUser' expects a username to create a new user")
-		}
-		return t.createUser(stub, args[0])
-
-	case "deleteUser":
-		if len(args) != 2 {
-			return shim.Error("'deleteUser' expects a username and a remainingBalanceRecipient username")
-		}
-		return t.deleteUser(stub, args[0], args[1])
-
-	case "transfer":
-		if len(args) != 2 {
-			return shim.Error("'transfer' expects a car vin and name of the new owner to transfer a car")
-		} else if role == "user" || role == "garage" {
-			// only allow users and garage users to transer cars
-			return t.transfer(stub, username, args)
-		} else {
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to transfer cars.", role))
-		}
-
-	case "revocationProposal":
-		if len(args) != 1 {
-			return shim.Error("'revocationProposal' expects a car vin to revoke a car")
-		} else if role != "user" {
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to create a revocation proposal.", role))
-		} else {
-			return t.revocationProposal(stub, username, args[0])
-		}
-
-	case "insureProposal":
-		if len(args) != 2 {
-			return shim.Error("'insureProposal' expects a car vin and an insurance company")
-		} else if role != "user" {
-			// only normal users are allowed to do insurance proposals
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to create an insurance proposal.", role))
-		} else {
-			return t.insureProposal(stub, username, args[0], args[1])
-		}
-
-	case "sell":
-		if len(args) != 3 {
-			return shim.Error("'sell' expects a price, car vin and buyer name to transfer a car")
-		} else if role == "user" || role == "garage" {
-			// only allow users and garage users to transer cars
-			return t.sell(stub, username, args)
-		} else {
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to sell cars.", role))
-		}
+	// the invoker must own the username
+	err := t.checkIdentity(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
-	case "updateBalance":
-		if len(args) != 1 {
-			return shim.Error("'updateBalance' expects only one argument")
-		} else if role != "user" {
-			// only a user is allowed to update balance
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to update the balance of a user.", role))
-		} else {
-			/* TODO
-			newBalance64, err := strconv.ParseInt(args[0], 10, 64)
-			var newBalance int
-			newBalance = int(newBalance64)
-			if err != nil {
-				return shim.Error("Error converting string to int.")
-			}
-			return t.updateBalance(shim, username, newBalance)
-			*/
-		}
+	route, ok := routes[function]
+	if !ok {
+		return errorResponseFrom(newErrorWithDetails(ErrUnknownFunction, "Invoke did not find function: "+function, map[string]string{"functions": strings.Join(functionNames(), ",")}))
+	}
 
-	// GARAGE FUNCTIONS
-	case "create":
-		if role != "garage" {
-			return shim.Error("'create' expects you to be a garage user")
-		}
-		return t.createCar(stub, username, args)
-
-	// DOT FUNCTIONS
-	case "revoke":
-		if len(args) != 1 {
-			return shim.Error("'revoke' expects a car vin to revoke a car")
-		} else if role != "dot" {
-			// only the DOT is allowed to revoke cars
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to revoke cars.", role))
-		} else {
-			return t.revoke(stub, username, args[0])
-		}
+	// reject malformed arguments before dispatching
+	err = validateArgs(function, route.args, args)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
-	case "delete":
-		if len(args) != 1 {
-			return shim.Error("'delete' expects a car vin to delete a car")
-		} else if role != "dot" {
-			// only the DOT is allowed to delete cars
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to delete cars.", role))
-		} else {
-			return t.delete(stub, args[0])
-		}
+	err = route.checkRole(role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
-	case "readRegistrationProposals":
-		if role != "dot" {
-			// only the DOT is allowed to read registration proposals
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to read reigistration proposals.", role))
-		} else {
-			return t.readRegistrationProposals(stub)
+	// state of an older schema has to be migrated first
+	if !route.anySchema {
+		err = t.checkSchemaVersion(stub)
+		if err != nil {
+			return errorResponseFrom(err)
 		}
+	}
 
-	case "register":
-		if len(args) != 1 {
-			return shim.Error("'register' expects a car vin to register")
-		} else if role != "dot" {
-			// only the DOT is allowed to register new cars
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to register cars.", role))
-		} else {
-			return t.register(stub, username, args[0])
+	// queries may return their result compressed
+	encoding := ""
+	if route.readOnly {
+		encoding, err = getResponseEncoding(stub)
+		if err != nil {
+			return errorResponseFrom(err)
 		}
+	}
 
-	case "confirm":
-		if len(args) != 2 {
-			return shim.Error(fmt.Sprintf("'confirm' expects a car vin and numberplate to confirm a car.\n You can choose your numberplate yourself."))
-		} else if role != "dot" {
-			// only the DOT is allowed to confirm cars
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to confirm cars.", role))
-		} else {
-			return t.confirm(stub, username, args)
-		}
+	// simulated invocations run all checks, but write nothing
+	simulate, err := isSimulation(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if simulate && !route.simulatable {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'%s' cannot be simulated", function))
+	} else if simulate {
+		simulation := newSimulationStub(stub)
+		stub = simulation
+		defer func() {
+			fmt.Printf("Simulated '%s', dropped %d writes\n", function, simulation.writes)
+		}()
+	}
 
-	case "getRevocationProposals":
-		if role != "dot" {
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to query revocation proposals.", role))
-		} else {
-			return t.getRevocationProposals(stub)
-		}
+	call := invocation{function: function, username: username, role: role, args: args, ledger: ledger}
+	if route.idempotent {
+		// retries with the same key return the first response
+		response = t.invokeOnce(stub, call, route.handler)
+	} else {
+		response = route.handler(t, stub, call)
+	}
+	if route.readOnly && response.Status == shim.OK && len(journal.changed) > 0 {
+		return errorResponse(ErrInternal, fmt.Sprintf("'%s' is read-only but changed the ledger", function))
+	} else if encoding != "" && response.Status == shim.OK {
+		return compressResponse(response)
+	}
 
-	// INSURANCE FUNCTIONS
-	case "insuranceAccept":
-		if len(args) != 2 {
-			return shim.Error("'insuranceAccept' expects a car vin and an insurance company")
-		} else if role != "insurer" {
-			// only insurers are allowed to create insurance contracts
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to create an insurance proposal.", role))
-		} else {
-			return t.insuranceAccept(stub, username, args[0], args[1])
-		}
+	if simulate && response.Status == shim.OK {
+		response.Message = simulatedMessage
+		return response
+	}
 
-	case "getInsurer":
-		if len(args) != 1 {
-			return shim.Error("'getInsurer' expects an insurance company name")
-		} else if role != "insurer" {
-			// only insurers are allowed to read their insurance proposals
-			return shim.Error(fmt.Sprintf("Sorry, role '%s' is not allowed to create an insurance proposal.", role))
-		} else {
-			return t.getInsurer(stub, args[0])
+	// privileged changes are kept in the audit log
+	if !route.readOnly && response.Status == shim.OK && isAuditedRole(role) {
+		err = t.recordAudit(stub, call)
+		if err != nil {
+			return errorResponseFrom(err)
 		}
-
-	default:
-
 	}
 
-	return shim.Error("Invoke did not find function: " + function)
+	return response
 }
 
 /*
@@ -287,19 +246,19 @@ func (t *CarChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
  * Can be any of:
  *  - Car   (expects car timestamp as key)
  *  - User  (expects user name as key)
- *  - or an index like '_cars'
+ *  - or an index like '_users'
  *
  * On success,
  * returns ledger state in bytes at position 'key'.
  */
 func (t *CarChaincode) read(stub shim.ChaincodeStubInterface, key string) pb.Response {
 	if key == "" {
-		return shim.Error("'read' expects a non-empty key to do the look up")
+		return errorResponse(ErrInvalidArgument, "'read' expects a non-empty key to do the look up")
 	}
 
 	valAsBytes, err := stub.GetState(key)
 	if err != nil {
-		return shim.Error("Failed to fetch value at key '" + key + "' from ledger")
+		return errorResponse(ErrNotFound, "Failed to fetch value at key '"+key+"' from ledger")
 	}
 
 	return shim.Success(valAsBytes)
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Insurance claims.
+ *
+ * Every claim lives under its own composite key
+ * 'claim~<id>', the id being the filing transaction,
+ * so filing and processing claims of different cars
+ * does not rewrite a shared index.
+ */
+
+// object type of claim keys
+const claimObjectType string = "claim"
+
+// claim states
+const claimFiled string = "filed"
+const claimApproved string = "approved"
+const claimRejected string = "rejected"
+const claimSettled string = "settled"
+
+/*
+ * Returns the ledger key of claim 'id'
+ */
+func getClaimKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(claimObjectType, []string{id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating claim key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Writes a claim to ledger
+ */
+func (t *CarChaincode) saveClaim(stub shim.ChaincodeStubInterface, claim Claim) error {
+	key, err := getClaimKey(stub, claim.Id)
+	if err != nil {
+		return err
+	}
+
+	claimAsBytes, _ := ledgerjson.Marshal(claim)
+	err = stub.PutState(key, claimAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing claim")
+	}
+
+	return nil
+}
+
+/*
+ * Reads a claim and checks that it was filed
+ * with the insurance company 'insurer'.
+ */
+func (t *CarChaincode) getClaim(stub shim.ChaincodeStubInterface, insurer string, id string) (Claim, error) {
+	key, err := getClaimKey(stub, id)
+	if err != nil {
+		return Claim{}, err
+	}
+
+	claimAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return Claim{}, newError(ErrLedger, "Error reading claim")
+	} else if claimAsBytes == nil {
+		return Claim{}, newError(ErrNotFound, "There exists no claim with id '"+id+"'")
+	}
+
+	claim := Claim{}
+	err = ledgerjson.Unmarshal(claimAsBytes, &claim)
+	if err != nil {
+		return Claim{}, newError(ErrLedger, "Error parsing claim")
+	}
+
+	if claim.Insurer != insurer {
+		return Claim{}, newError(ErrForbidden, "Forbidden: this claim was not filed with your company")
+	}
+
+	return claim, nil
+}
+
+/*
+ * Returns the claims 'keep' selects, oldest
+ * first, see 'claimsByCreatedTs'
+ */
+func (t *CarChaincode) findClaims(stub shim.ChaincodeStubInterface, keep func(*Claim) bool) ([]Claim, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(claimObjectType, []string{})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading claims")
+	}
+	defer iterator.Close()
+
+	claims := []Claim{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading claims")
+		}
+
+		claim := Claim{}
+		err = ledgerjson.Unmarshal(kv.Value, &claim)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing claim")
+		}
+
+		if keep(&claim) {
+			claims = append(claims, claim)
+		}
+	}
+
+	sort.Sort(claimsByCreatedTs(claims))
+	return claims, nil
+}
+
+/*
+ * Files an insurance claim for an accident.
+ *
+ * Only the owner of an insured car can file a claim.
+ * The claim is filed with the insurer in the car certificate.
+ *
+ * Arguments required:
+ * [0] VIN of the damaged car      (string)
+ * [1] Accident report             (string)
+ * [2] Claimed amount              (int)
+ *
+ * On success,
+ * returns the claim.
+ */
+func (t *CarChaincode) fileClaim(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+	accident := args[1]
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 {
+		return errorResponse(ErrInvalidArgument, "'fileClaim' expects a positive amount")
+	}
+
+	if accident == "" {
+		return errorResponse(ErrInvalidArgument, "'fileClaim' expects a non-empty accident report")
+	}
+
+	// fetch the car from the ledger
+	// this already checks for ownership
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// only insured cars are covered
+	if !IsInsured(&car, now) {
+		return errorResponse(ErrNotInsured, "Car is not insured. Cannot file a claim without insurance contract")
+	}
+
+	claim := Claim{
+		Id:        stub.GetTxID(),
+		Car:       car.Vin,
+		User:      username,
+		Insurer:   car.Certificate.Insurer,
+		Accident:  accident,
+		Amount:    amount,
+		Status:    claimFiled,
+		CreatedTs: now}
+
+	// accidents during a test drive may be on the prospect
+	err = attributeTestDriveLiability(stub, &claim)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.saveClaim(stub, claim)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	liable := username
+	if claim.Liable != "" {
+		liable = claim.Liable
+	}
+
+	err = t.countFiledClaim(stub, liable)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Filed claim '%s' for car with VIN '%s' with insurer '%s'\n", claim.Id, claim.Car, claim.Insurer)
+
+	claimAsBytes, _ := ledgerjson.Marshal(claim)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * Approves a filed claim.
+ *
+ * On success,
+ * returns the claim.
+ */
+func (t *CarChaincode) approveClaim(stub shim.ChaincodeStubInterface, insurer string, id string) pb.Response {
+	claim, err := t.getClaim(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if claim.Status != claimFiled {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot approve claim '%s' with status '%s'", id, claim.Status))
+	}
+
+	claim.Status = claimApproved
+	err = t.saveClaim(stub, claim)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claimAsBytes, _ := ledgerjson.Marshal(claim)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * Rejects a filed claim with a reason.
+ *
+ * On success,
+ * returns the claim.
+ */
+func (t *CarChaincode) rejectClaim(stub shim.ChaincodeStubInterface, insurer string, id string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'rejectClaim' expects a non-empty reason")
+	}
+
+	claim, err := t.getClaim(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if claim.Status != claimFiled {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot reject claim '%s' with status '%s'", id, claim.Status))
+	}
+
+	claim.Status = claimRejected
+	claim.Reason = reason
+	err = t.saveClaim(stub, claim)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claimAsBytes, _ := ledgerjson.Marshal(claim)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * Settles an approved claim.
+ *
+ * By default the payout happens off the ledger and the claim
+ * is only marked as settled. If the insurer passes 'true',
+ * the claimed amount is moved from the insurers user balance
+ * to the claimant.
+ *
+ * Arguments required:
+ * [0] Claim id                    (string)
+ * [1] (optional) Pay on ledger    (bool)
+ *
+ * On success,
+ * returns the claim.
+ */
+func (t *CarChaincode) settleClaim(stub shim.ChaincodeStubInterface, insurer string, args []string) pb.Response {
+	id := args[0]
+	onLedger := false
+	if len(args) > 1 {
+		var err error
+		onLedger, err = strconv.ParseBool(args[1])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "'settleClaim' expects 'true' or 'false' as second argument")
+		}
+	}
+
+	claim, err := t.getClaim(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if claim.Status != claimApproved {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot settle claim '%s' with status '%s'", id, claim.Status))
+	}
+
+	if onLedger {
+		// take the payout from the insurer first,
+		// this fails if the insurer cannot afford it
+		_, err = t.updateBalance(stub, insurer, -claim.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	// counts the claim and pays
+	// the claimant, if on ledger
+	err = t.countSettledClaim(stub, &claim, onLedger)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claim.Status = claimSettled
+	claim.OnLedger = onLedger
+	err = t.saveClaim(stub, claim)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claimAsBytes, _ := ledgerjson.Marshal(claim)
+	return shim.Success(claimAsBytes)
+}
+
+/*
+ * Returns all claims filed with the insurance company
+ * 'insurer', oldest first.
+ */
+func (t *CarChaincode) getClaims(stub shim.ChaincodeStubInterface, insurer string) pb.Response {
+	claims, err := t.findClaims(stub, func(claim *Claim) bool {
+		return claim.Insurer == insurer
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claimsAsBytes, _ := ledgerjson.Marshal(claims)
+	return shim.Success(claimsAsBytes)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Creates, registers and insures a car for 'username'.
+ */
+func insureCar(t *testing.T, stub *shim.MockStub, username string, vin string, insuranceCompany string) Car {
+	carData := `{ "vin": "` + vin + `" }`
+	accredit(stub, insuranceCompany)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", username, "dot", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("insureProposal", username, "user", vin, insuranceCompany))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("insuranceAccept", username, "insurer", vin, insuranceCompany))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal("Failed to fetch car")
+	}
+
+	if !IsInsured(&car, time.Now().Unix()) {
+		t.Fatal("Car should be insured by now")
+	}
+
+	return car
+}
+
+func TestFileAndSettleClaim(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	insuranceCompany := "axa"
+
+	// create and name a new chaincode mock
+	carChaincode := &CarChaincode{}
+	stub := shim.NewMockStub("car", carChaincode)
+
+	ccSetup(t, stub)
+
+	// filing a claim for an uninsured car should fail
+	carData := `{ "vin": "WVWZZZ6R8HY260781" }`
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", "WVWZZZ6R8HY260781", "rear-ended", "10"))
+	if response.Status == shim.OK {
+		t.Error("Claims for uninsured cars should be rejected")
+	}
+
+	insureCar(t, stub, username, vin, insuranceCompany)
+
+	// the insurance company needs an account to pay out on the ledger
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", insuranceCompany, "insurer"))
+
+	// claims are for a positive amount
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "rear-ended at a traffic light", "0"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// file a claim for the insured car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "rear-ended at a traffic light", "30"))
+	claim := Claim{}
+	err := json.Unmarshal(response.Payload, &claim)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if claim.Insurer != insuranceCompany || claim.Status != claimFiled {
+		t.Error("Claim should be filed with the car insurer")
+	}
+
+	// another insurer must not touch the claim
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", "allianz", "insurer", claim.Id))
+	if response.Status == shim.OK {
+		t.Error("Only the car insurer should be able to approve the claim")
+	}
+
+	// settling before approval is not possible
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("settleClaim", insuranceCompany, "insurer", claim.Id, "true"))
+	if response.Status == shim.OK {
+		t.Error("A claim has to be approved before it can be settled")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", insuranceCompany, "insurer", claim.Id))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// settle the claim on the ledger
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("settleClaim", insuranceCompany, "insurer", claim.Id, "true"))
+	err = json.Unmarshal(response.Payload, &claim)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if claim.Status != claimSettled {
+		t.Error("Claim should be settled by now")
+	}
+
+	// the claimant got paid out
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_"+username))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 130 {
+		t.Error(fmt.Sprintf("Claimant balance should be 130, but is %d", user.Balance))
+	}
+
+	// and the insurer paid
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_"+insuranceCompany))
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 70 {
+		t.Error(fmt.Sprintf("Insurer balance should be 70, but is %d", user.Balance))
+	}
+}
+
+func TestRejectClaim(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	insuranceCompany := "axa"
+
+	// create and name a new chaincode mock
+	carChaincode := &CarChaincode{}
+	stub := shim.NewMockStub("car", carChaincode)
+
+	ccSetup(t, stub)
+
+	insureCar(t, stub, username, vin, insuranceCompany)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "hail damage", "50"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+
+	// reject the claim
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectClaim", insuranceCompany, "insurer", claim.Id, "not covered"))
+	err := json.Unmarshal(response.Payload, &claim)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if claim.Status != claimRejected || claim.Reason != "not covered" {
+		t.Error("Claim should be rejected with a reason")
+	}
+
+	// a rejected claim cannot be approved anymore
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", insuranceCompany, "insurer", claim.Id))
+	if response.Status == shim.OK {
+		t.Error("Rejected claims cannot be approved")
+	}
+
+	// the insurer lists the claim
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getClaims", insuranceCompany, "insurer"))
+	claims := []Claim{}
+	json.Unmarshal(response.Payload, &claims)
+	if len(claims) != 1 {
+		t.Error("Insurer should have exactly one claim")
+	}
+}
+
+func TestMarkSalvageAndApproveRebuild(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	insuranceCompany := "axa"
+
+	// create and name a new chaincode mock
+	carChaincode := &CarChaincode{}
+	stub := shim.NewMockStub("car", carChaincode)
+
+	ccSetup(t, stub)
+
+	insureCar(t, stub, username, vin, insuranceCompany)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "crashed into a tree", "90"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+
+	// the claim has to be approved before the car is written off
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("markSalvage", insuranceCompany, "insurer", vin, claim.Id, classSalvage))
+	if response.Status == shim.OK {
+		t.Error("Cars can only be written off after an approved claim")
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", insuranceCompany, "insurer", claim.Id))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("markSalvage", insuranceCompany, "insurer", vin, claim.Id, classSalvage))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// the classification shows up in car reads
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+	json.Unmarshal(response.Payload, &car)
+	if car.Classification != classSalvage {
+		t.Error("Car should be classified as salvage")
+	}
+
+	// salvage cars cannot be confirmed
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 7878"))
+	if response.Status == shim.OK {
+		t.Error("Salvage cars should not get a numberplate")
+	}
+
+	// the DOT approves the rebuild
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveRebuild", username, "dot", vin, "inspection report #42"))
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Classification != classRebuilt {
+		t.Error("Car should be classified as rebuilt")
+	}
+
+	// now the car can be confirmed again
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 7878"))
+	json.Unmarshal(response.Payload, &car)
+	if !IsConfirmed(&car, time.Now().Unix()) {
+		t.Error("Rebuilt car should be confirmable")
+	}
+}
+
+func TestClaimKeys(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+	insuranceCompany := "axa"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	insureCar(t, stub, username, vin, insuranceCompany)
+	insureCar(t, stub, username, otherVin, insuranceCompany)
+
+	// every claim is named by the transaction filing it
+	response := stub.MockInvoke("tx-hail", util.ToChaincodeArgs("fileClaim", username, "user", vin, "hail damage", "50"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+	if claim.Id != "tx-hail" {
+		t.Errorf("Expected the claim named 'tx-hail', got '%s'", claim.Id)
+	}
+
+	stub.MockInvoke("tx-tree", util.ToChaincodeArgs("fileClaim", username, "user", otherVin, "crashed into a tree", "90"))
+
+	// and kept under its own key
+	for _, id := range []string{"tx-hail", "tx-tree"} {
+		key, _ := getClaimKey(stub, id)
+		claimAsBytes, _ := stub.GetState(key)
+		if claimAsBytes == nil {
+			t.Errorf("Expected claim '%s' under its own key", id)
+		}
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getClaims", insuranceCompany, "insurer"))
+	claims := []Claim{}
+	json.Unmarshal(response.Payload, &claims)
+	if len(claims) != 2 || claims[0].Car == claims[1].Car {
+		t.Errorf("Expected a claim for each car, got %v", claims)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", insuranceCompany, "insurer", "tx-unknown"))
+	expectErrorCode(t, response, ErrNotFound)
+}
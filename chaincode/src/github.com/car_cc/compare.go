@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Car comparison.
+ *
+ * Buyers choosing between two cars want the facts side
+ * by side, not two full histories. 'compareCars' sums up
+ * each car, specs, mileage, accidents, the last service
+ * and the price history, and lists the facts that differ.
+ *
+ * Accidents are the insurance claims filed for the car
+ * and not rejected. The last service is the latest part
+ * replacement, emission test or attached service record
+ * or inspection report.
+ */
+
+/*
+ * Sums up car 'vin' for a comparison
+ */
+func (t *CarChaincode) summarizeCar(stub shim.ChaincodeStubInterface, vin string, claims []Claim) (CarSummary, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return CarSummary{}, err
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return CarSummary{}, err
+	}
+
+	summary := CarSummary{
+		Vin:            vin,
+		Brand:          car.Certificate.Brand,
+		Model:          car.Certificate.Model,
+		Variant:        car.Certificate.Variant,
+		Type:           car.Certificate.Type,
+		Color:          car.Certificate.Color,
+		CatalogId:      car.Certificate.CatalogId,
+		Classification: car.Classification,
+		CreatedTs:      car.CreatedTs,
+		Mileage:        carMileage(&car),
+		EmissionClass:  car.Emission.Class,
+		LastServiceTs:  car.Emission.TestedTs,
+	}
+
+	for _, claim := range claims {
+		if claim.Car == vin && claim.Status != claimRejected {
+			summary.Accidents++
+		}
+	}
+
+	replacements, err := readPartReplacements(stub, vin)
+	if err != nil {
+		return CarSummary{}, err
+	}
+	for _, replacement := range replacements {
+		if replacement.Ts > summary.LastServiceTs {
+			summary.LastServiceTs = replacement.Ts
+		}
+	}
+
+	attachments, err := getAttachments(stub, vin)
+	if err != nil {
+		return CarSummary{}, err
+	}
+	for _, attachment := range attachments {
+		isService := attachment.Type == "service_record" || attachment.Type == "inspection_report"
+		if isService && attachment.AttachedTs > summary.LastServiceTs {
+			summary.LastServiceTs = attachment.AttachedTs
+		}
+	}
+
+	summary.Prices, err = readPriceHistory(stub, vin)
+	if err != nil {
+		return CarSummary{}, err
+	}
+
+	// private prices only have a hash
+	for _, record := range summary.Prices {
+		if record.Price > 0 {
+			summary.LastPrice = record.Price
+		}
+	}
+
+	return summary, nil
+}
+
+/*
+ * Lists the facts in which two car summaries differ
+ */
+func diffCars(a *CarSummary, b *CarSummary) []CarDifference {
+	facts := []struct {
+		field string
+		a     string
+		b     string
+	}{
+		{"brand", a.Brand, b.Brand},
+		{"model", a.Model, b.Model},
+		{"variant", a.Variant, b.Variant},
+		{"type", a.Type, b.Type},
+		{"color", a.Color, b.Color},
+		{"catalog_id", a.CatalogId, b.CatalogId},
+		{"classification", a.Classification, b.Classification},
+		{"created_ts", strconv.FormatInt(a.CreatedTs, 10), strconv.FormatInt(b.CreatedTs, 10)},
+		{"mileage", strconv.Itoa(a.Mileage), strconv.Itoa(b.Mileage)},
+		{"emission_class", a.EmissionClass, b.EmissionClass},
+		{"accidents", strconv.Itoa(a.Accidents), strconv.Itoa(b.Accidents)},
+		{"last_service_ts", strconv.FormatInt(a.LastServiceTs, 10), strconv.FormatInt(b.LastServiceTs, 10)},
+		{"last_price", strconv.Itoa(a.LastPrice), strconv.Itoa(b.LastPrice)},
+	}
+
+	differences := []CarDifference{}
+	for _, fact := range facts {
+		if fact.a != fact.b {
+			differences = append(differences, CarDifference{Field: fact.field, A: fact.a, B: fact.b})
+		}
+	}
+
+	return differences
+}
+
+/*
+ * Compares two cars the caller may read.
+ *
+ * On success,
+ * returns the comparison.
+ */
+func (t *CarChaincode) compareCars(stub shim.ChaincodeStubInterface, username string, role string, vinA string, vinB string) pb.Response {
+	if vinA == vinB {
+		return errorResponse(ErrInvalidArgument, "'compareCars' expects two different cars")
+	}
+
+	// the DOT reads every car, see 'getPriceHistory'
+	if role != "dot" {
+		for _, vin := range []string{vinA, vinB} {
+			allowed, err := t.canRead(stub, username, vin)
+			if err != nil {
+				return errorResponseFrom(err)
+			} else if !allowed {
+				return errorResponse(ErrNotOwner, fmt.Sprintf("Forbidden: the owner did not allow you to read car '%s'", vin))
+			}
+		}
+	}
+
+	claims, err := t.findClaims(stub, func(claim *Claim) bool {
+		return claim.Car == vinA || claim.Car == vinB
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	comparison := CarComparison{}
+	comparison.A, err = t.summarizeCar(stub, vinA, claims)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	comparison.B, err = t.summarizeCar(stub, vinB, claims)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	comparison.Differences = diffCars(&comparison.A, &comparison.B)
+
+	comparisonAsBytes, _ := ledgerjson.Marshal(comparison)
+	return shim.Success(comparisonAsBytes)
+}
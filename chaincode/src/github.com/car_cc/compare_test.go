@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCompareCars(t *testing.T) {
+	owner := "amag"
+	vinA := "WVWZZZ6R6HY260780"
+	vinB := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vinA, "axa")
+	insureCar(t, stub, owner, vinB, "axa")
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", owner, "user", vinA, "rear-ended at a traffic light", "30"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", owner, "user", vinB, "service_record", strings.Repeat("ab", 32)))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("compareCars", owner, "user", vinA, vinA))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("compareCars", "bobby", "user", vinA, vinB))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("compareCars", owner, "user", vinA, vinB))
+	comparison := CarComparison{}
+	json.Unmarshal(response.Payload, &comparison)
+	if response.Status != shim.OK || comparison.A.Vin != vinA || comparison.B.Vin != vinB {
+		t.Fatalf("Expected the comparison of both cars, got %s", response.Message)
+	} else if comparison.A.Accidents != 1 || comparison.B.Accidents != 0 {
+		t.Errorf("Expected one accident of the first car, got %d and %d", comparison.A.Accidents, comparison.B.Accidents)
+	} else if comparison.A.LastServiceTs != 0 || comparison.B.LastServiceTs == 0 {
+		t.Errorf("Expected only the second car serviced, got %d and %d", comparison.A.LastServiceTs, comparison.B.LastServiceTs)
+	}
+
+	fields := []string{}
+	for _, difference := range comparison.Differences {
+		fields = append(fields, difference.Field)
+	}
+	if strings.Join(fields, ",") != "accidents,last_service_ts" {
+		t.Errorf("Expected the cars to differ in accidents and service, got %v", fields)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("compareCars", "inspector", "dot", vinA, vinB))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Compressed responses.
+ *
+ * Queries over large garage inventories or long histories
+ * can exceed the gRPC message limit. Clients set the
+ * transient field 'compress' to 'gzip' on a read-only
+ * function, which then returns a 'CompressedPayload':
+ * the gzip compressed result plus its size and, for
+ * arrays, the number of elements, so clients can tell
+ * what they get before unpacking it.
+ *
+ * The gzip header carries no name or time, so every peer
+ * endorses the same bytes.
+ */
+
+// transient field asking for a compressed response
+const compressTransient string = "compress"
+
+// supported response encodings
+const encodingGzip string = "gzip"
+
+/*
+ * Reads the requested response encoding from the
+ * transient data, '' for an uncompressed response
+ */
+func getResponseEncoding(stub shim.ChaincodeStubInterface) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", newError(ErrLedger, "Error reading transient data")
+	}
+
+	encoding := string(transient[compressTransient])
+	if encoding != "" && encoding != encodingGzip {
+		return "", newError(ErrInvalidArgument, fmt.Sprintf("Unsupported response encoding '%s', only '%s' is supported", encoding, encodingGzip))
+	}
+
+	return encoding, nil
+}
+
+/*
+ * Replaces the payload of a successful response
+ * by the compressed payload
+ */
+func compressResponse(response pb.Response) pb.Response {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(response.Payload)
+	writer.Close()
+
+	compressed := CompressedPayload{
+		Encoding:       encodingGzip,
+		Size:           len(response.Payload),
+		CompressedSize: buffer.Len(),
+		Payload:        buffer.Bytes(),
+	}
+
+	elements := []json.RawMessage{}
+	if ledgerjson.Unmarshal(response.Payload, &elements) == nil {
+		compressed.Count = len(elements)
+	}
+
+	compressedAsBytes, _ := ledgerjson.Marshal(compressed)
+	return shim.Success(compressedAsBytes)
+}
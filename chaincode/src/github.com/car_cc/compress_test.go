@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCompressedResponse(t *testing.T) {
+	garage := "amag"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6R6HY260780" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6R8HY260781" }`))
+
+	stub.TransientMap = map[string][]byte{compressTransient: []byte("br")}
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", garage, "garage"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.TransientMap = map[string][]byte{compressTransient: []byte(encodingGzip)}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", garage, "garage"))
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	compressed := CompressedPayload{}
+	json.Unmarshal(response.Payload, &compressed)
+	if compressed.Encoding != encodingGzip || compressed.Count != 2 || compressed.CompressedSize != len(compressed.Payload) {
+		t.Fatalf("Expected the compressed inventory of 2 cars, got %s", response.Payload)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed.Payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventoryAsBytes, _ := io.ReadAll(reader)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", garage, "garage"))
+	if !bytes.Equal(inventoryAsBytes, response.Payload) || compressed.Size != len(response.Payload) {
+		t.Errorf("Expected the uncompressed inventory %s, got %s", response.Payload, inventoryAsBytes)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Returns the chaincode configuration.
+ *
+ * A missing configuration is returned as empty configuration.
+ */
+func (t *CarChaincode) getConfig(stub shim.ChaincodeStubInterface) (Config, error) {
+	response := t.read(stub, configStr)
+	config := Config{}
+	if len(response.Payload) == 0 {
+		return config, nil
+	}
+
+	err := ledgerjson.Unmarshal(response.Payload, &config)
+	if err != nil {
+		return Config{}, newError(ErrLedger, "Error parsing chaincode configuration")
+	}
+
+	return config, nil
+}
+
+/*
+ * Writes the chaincode configuration back to ledger
+ */
+func (t *CarChaincode) saveConfig(stub shim.ChaincodeStubInterface, config Config) error {
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	err := stub.PutState(configStr, configAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing chaincode configuration")
+	}
+
+	return nil
+}
+
+/*
+ * Checks a configuration before it is written to ledger
+ */
+func validateConfig(config Config) error {
+	if config.StickerKey != "" {
+		key, err := hex.DecodeString(config.StickerKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return newError(ErrInvalidArgument, "Sticker key must be a hex encoded ed25519 public key")
+		}
+	}
+
+	if config.ProposalTtlDays < 0 {
+		return newError(ErrInvalidArgument, "Proposal TTL must not be negative")
+	}
+
+	for jurisdiction, format := range config.PlateFormats {
+		_, err := regexp.Compile("^(?:" + format + ")$")
+		if err != nil {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Invalid numberplate format for jurisdiction '%s': %s", jurisdiction, err.Error()))
+		}
+	}
+
+	for name, fee := range config.Fees {
+		if fee < 0 {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Fee '%s' must not be negative", name))
+		}
+	}
+
+	for name, percent := range config.FeePercentages {
+		if percent < 0 || percent > 100 {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Percentage of fee '%s' must be between 0 and 100", name))
+		}
+	}
+
+	if config.ValuationThreshold < 0 || config.ValuationThreshold > 100 {
+		return newError(ErrInvalidArgument, "Valuation threshold must be between 0 and 100")
+	}
+
+	if config.InstallmentGraceDays < 0 {
+		return newError(ErrInvalidArgument, "Installment grace period must not be negative")
+	}
+
+	if config.DepositHoldDays < 0 {
+		return newError(ErrInvalidArgument, "Deposit hold period must not be negative")
+	}
+
+	if config.ReportOwnerShare < 0 || config.ReportOwnerShare > 100 {
+		return newError(ErrInvalidArgument, "Report owner share must be between 0 and 100")
+	}
+
+	if config.TollDebtThreshold < 0 {
+		return newError(ErrInvalidArgument, "Toll debt threshold must not be negative")
+	}
+
+	if config.AppraisalThreshold < 0 || config.AppraisalValidityDays < 0 {
+		return newError(ErrInvalidArgument, "Appraisal threshold and validity must not be negative")
+	}
+
+	if config.HistoricAgeYears < 0 {
+		return newError(ErrInvalidArgument, "Historic vehicle age must not be negative")
+	}
+
+	for jurisdiction, rules := range config.RegistrationRules {
+		err := validateRules(jurisdiction, rules)
+		if err != nil {
+			return err
+		}
+	}
+
+	for jurisdiction, rule := range config.RoadTax {
+		err := validateRoadTaxRule(jurisdiction, rule)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the fee 'name' from the fee schedule,
+ * or 'fallback' if the schedule does not set it
+ */
+func configFee(config Config, name string, fallback int) int {
+	fee, ok := config.Fees[name]
+	if !ok {
+		return fallback
+	}
+
+	return fee
+}
+
+/*
+ * Checks that the invoker may change the configuration.
+ *
+ * Until admins are configured, the 'admin' role is enough,
+ * so a fresh network can be bootstrapped.
+ */
+func checkAdmin(stub shim.ChaincodeStubInterface, config Config, role string) error {
+	if role != "admin" {
+		return newError(ErrForbiddenRole, fmt.Sprintf("Sorry, role '%s' is not allowed to change the configuration.", role))
+	} else if len(config.Admins) == 0 {
+		return nil
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range config.Admins {
+		if admin == caller {
+			return nil
+		}
+	}
+
+	return newError(ErrForbidden, "Only configured admins can change the configuration")
+}
+
+/*
+ * Seeds the configuration at chaincode instantiation.
+ *
+ * Expects the configuration as JSON, or an empty
+ * string for the default configuration.
+ */
+func (t *CarChaincode) seedConfig(stub shim.ChaincodeStubInterface, configArg string) error {
+	config := Config{}
+	if configArg != "" {
+		err := ledgerjson.Unmarshal([]byte(configArg), &config)
+		if err != nil {
+			return newError(ErrInvalidArgument, "Invalid configuration JSON: "+err.Error())
+		}
+	}
+
+	err := validateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	return t.saveConfig(stub, config)
+}
+
+/*
+ * Updates the chaincode configuration.
+ *
+ * Expects the changed fields as JSON. Fields missing
+ * from the update keep their value, entries of the plate
+ * formats and the fee schedule are merged.
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) updateConfig(stub shim.ChaincodeStubInterface, role string, update string) pb.Response {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAdmin(stub, config, role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = ledgerjson.Unmarshal([]byte(update), &config)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'updateConfig' expects the configuration changes as JSON")
+	}
+
+	err = validateConfig(config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
+
+/*
+ * Returns how long registration proposals stay open, in seconds
+ */
+func proposalTtl(config Config) int64 {
+	days := config.ProposalTtlDays
+	if days <= 0 {
+		days = defaultProposalTtlDays
+	}
+
+	return int64(days) * secondsPerDay
+}
+
+/*
+ * Sets the public key the DOT signs registration stickers with.
+ *
+ * Expects a hex encoded ed25519 public key.
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setStickerKey(stub shim.ChaincodeStubInterface, key string) pb.Response {
+	keyAsBytes, err := hex.DecodeString(key)
+	if err != nil || len(keyAsBytes) != ed25519.PublicKeySize {
+		return errorResponse(ErrInvalidArgument, "'setStickerKey' expects a hex encoded ed25519 public key")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config.StickerKey = key
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
+
+/*
+ * Sets the number of days new registration
+ * proposals stay open.
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setProposalTtl(stub shim.ChaincodeStubInterface, daysArg string) pb.Response {
+	days, err := strconv.Atoi(daysArg)
+	if err != nil || days < 1 {
+		return errorResponse(ErrInvalidArgument, "'setProposalTtl' expects a positive number of days")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config.ProposalTtlDays = days
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
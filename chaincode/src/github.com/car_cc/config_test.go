@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSeedAndUpdateConfig(t *testing.T) {
+	admin := "Org1MSP admin certificate"
+	mallory := "Org1MSP mallory certificate"
+	adminHash := sha256.Sum256([]byte(admin))
+
+	seed := `{ "admins": ["` + hex.EncodeToString(adminHash[:]) + `"], "plate_formats": { "ZH": "ZH [0-9]+" }, "fees": { "plate_reservation": 5 } }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	response := stub.MockInit(uuid, util.ToChaincodeArgs("init", "999", seed))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// invalid configurations are refused at init
+	response = shim.NewMockStub("car", &CarChaincode{}).MockInit(uuid, util.ToChaincodeArgs("init", "999", `{ "plate_formats": { "ZH": "ZH [" } }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// the seeded fee applies
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "user"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", "amag", "user", "ZH 42"))
+	reservation := PlateReservation{}
+	json.Unmarshal(response.Payload, &reservation)
+	if reservation.Fee != 5 {
+		t.Errorf("Reservation fee should be 5, but is %d", reservation.Fee)
+	}
+
+	// only configured admins can update the configuration
+	response = invokeAs(stub, admin, "updateConfig", "admin", "dot", `{ "proposal_ttl_days": 10 }`)
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = invokeAs(stub, mallory, "updateConfig", "mallory", "admin", `{ "proposal_ttl_days": 10 }`)
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = invokeAs(stub, admin, "updateConfig", "admin", "admin", `{ "proposal_ttl_days": -1 }`)
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = invokeAs(stub, admin, "updateConfig", "admin", "admin", `{ "proposal_ttl_days": 10, "fees": { "plate_reservation": 8 } }`)
+	config := Config{}
+	err := json.Unmarshal(response.Payload, &config)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// unchanged fields keep their value
+	if config.ProposalTtlDays != 10 || config.Fees["plate_reservation"] != 8 || config.PlateFormats["ZH"] != "ZH [0-9]+" || len(config.Admins) != 1 {
+		t.Errorf("Unexpected configuration after update: %v", config)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Returns the read grant index.
+ *
+ * Maps a car VIN to the users allowed to read the car
+ * and the timestamp until when they are allowed to.
+ */
+func (t *CarChaincode) getReadGrantIndex(stub shim.ChaincodeStubInterface) (map[string]map[string]int64, error) {
+	response := t.read(stub, readGrantIndexStr)
+	grantIndex := make(map[string]map[string]int64)
+	err := ledgerjson.Unmarshal(response.Payload, &grantIndex)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing read grant index")
+	}
+
+	return grantIndex, nil
+}
+
+/*
+ * Writes the read grant index back to ledger
+ */
+func (t *CarChaincode) saveReadGrantIndex(stub shim.ChaincodeStubInterface, grantIndex map[string]map[string]int64) error {
+	indexAsBytes, _ := ledgerjson.Marshal(grantIndex)
+	err := stub.PutState(readGrantIndexStr, indexAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing read grant index")
+	}
+
+	return nil
+}
+
+/*
+ * Checks if 'username' may read the car with VIN 'vin'.
+ *
+ * The car owner can always read the car, everybody else
+ * needs an unexpired read grant of the owner.
+ */
+func (t *CarChaincode) canRead(stub shim.ChaincodeStubInterface, username string, vin string) (bool, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return false, err
+	} else if owner == username {
+		return true, nil
+	}
+
+	grantIndex, err := t.getReadGrantIndex(stub)
+	if err != nil {
+		return false, err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return false, err
+	}
+
+	expiry, granted := grantIndex[vin][username]
+	if granted && expiry > now {
+		return true, nil
+	}
+
+	// fleet admins, assigned drivers and prospects on a test drive
+	fleet, err := t.adminFleetOf(stub, username, vin)
+	if err != nil {
+		return false, err
+	} else if fleet != nil || t.isAssignedDriver(stub, username, vin) || isTestDriving(stub, username, vin, now) {
+		return true, nil
+	}
+
+	// agents with a read mandate of the owner
+	mandate, err := t.getMandate(stub, vin, username)
+	if err != nil {
+		return false, err
+	}
+
+	return mandateAllows(mandate, owner, mandateRead, now), nil
+}
+
+/*
+ * Lets the car owner grant read access to the full car
+ * record to another user until 'expiryTs'.
+ * Granting again to the same user replaces the expiry.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Username of the reader      (string)
+ * [2] Expiry timestamp            (int, unix timestamp)
+ *
+ * On success,
+ * returns the read grants of the car.
+ */
+func (t *CarChaincode) grantReadAccess(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+	reader := args[1]
+	expiry, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'grantReadAccess' expects the expiry as unix timestamp")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if reader == "" || reader == username {
+		return errorResponse(ErrInvalidArgument, "'grantReadAccess' expects the username of another user")
+	} else if expiry <= now {
+		return errorResponse(ErrInvalidArgument, "Cannot grant read access that expired already")
+	}
+
+	// only the car owner can grant access
+	_, err = t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	grantIndex, err := t.getReadGrantIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if grantIndex[vin] == nil {
+		grantIndex[vin] = make(map[string]int64)
+	}
+	grantIndex[vin][reader] = expiry
+
+	err = t.saveReadGrantIndex(stub, grantIndex)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("User '%s' may read car with VIN '%s' until '%d'\n", reader, vin, expiry)
+
+	grantsAsBytes, _ := ledgerjson.Marshal(grantIndex[vin])
+	return shim.Success(grantsAsBytes)
+}
+
+/*
+ * Lets the car owner revoke a read grant before it expires.
+ *
+ * On success,
+ * returns the read grants of the car.
+ */
+func (t *CarChaincode) revokeReadAccess(stub shim.ChaincodeStubInterface, username string, vin string, reader string) pb.Response {
+	// only the car owner can revoke access
+	_, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	grantIndex, err := t.getReadGrantIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if _, granted := grantIndex[vin][reader]; !granted {
+		return errorResponse(ErrNotFound, fmt.Sprintf("User '%s' has no read access to car with VIN '%s'", reader, vin))
+	}
+
+	delete(grantIndex[vin], reader)
+	if len(grantIndex[vin]) == 0 {
+		delete(grantIndex, vin)
+	}
+
+	err = t.saveReadGrantIndex(stub, grantIndex)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	grantsAsBytes, _ := ledgerjson.Marshal(grantIndex[vin])
+	return shim.Success(grantsAsBytes)
+}
+
+/*
+ * Removes all read grants of a car,
+ * e.g. when the car gets a new owner.
+ */
+func (t *CarChaincode) clearReadGrants(stub shim.ChaincodeStubInterface, vin string) error {
+	grantIndex, err := t.getReadGrantIndex(stub)
+	if err != nil {
+		return err
+	} else if _, ok := grantIndex[vin]; !ok {
+		return nil
+	}
+
+	delete(grantIndex, vin)
+	return t.saveReadGrantIndex(stub, grantIndex)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Co-ownership.
+ *
+ * A car can have co-owners next to its owner in the
+ * certificate, each holding a share in percent. The owner
+ * in the certificate keeps the car in its car list and
+ * holds the remaining share.
+ *
+ * Changes to the owners and the quorum are proposed by one
+ * owner and applied once every current owner approved them.
+ * Transfers need the consent of the owners holding at least
+ * the quorum of all shares.
+ */
+
+// co-ownership changes
+const coOwnerAdd string = "add"
+const coOwnerRemove string = "remove"
+const coOwnerQuorum string = "quorum"
+
+// share needed to transfer a co-owned car, unless set
+const defaultCoOwnerQuorum int = 100
+
+/*
+ * Checks if a car has co-owners
+ */
+func IsCoOwned(car *Car) bool {
+	return len(car.CoOwnership.Shares) > 1
+}
+
+/*
+ * Returns the owners of a car with their shares
+ */
+func ownerShares(car *Car) map[string]int {
+	if !IsCoOwned(car) {
+		return map[string]int{car.Certificate.Username: 100}
+	}
+
+	return car.CoOwnership.Shares
+}
+
+/*
+ * Checks that the owners holding the quorum consented
+ * to transferring 'car' to 'receiver'.
+ *
+ * The owner who transfers the car consents implicitly.
+ */
+func checkTransferConsent(car *Car, username string, receiver string) error {
+	if !IsCoOwned(car) {
+		return nil
+	}
+
+	quorum := car.CoOwnership.Quorum
+	if quorum == 0 {
+		quorum = defaultCoOwnerQuorum
+	}
+
+	consent := 0
+	for owner, share := range car.CoOwnership.Shares {
+		if owner == username || car.CoOwnership.TransferApprovals[owner] == receiver {
+			consent += share
+		}
+	}
+
+	if consent < quorum {
+		return newErrorWithDetails(ErrForbidden, fmt.Sprintf("Owners holding %d%% consented to the transfer, %d%% are required", consent, quorum),
+			map[string]string{"consent": strconv.Itoa(consent), "quorum": strconv.Itoa(quorum)})
+	}
+
+	return nil
+}
+
+/*
+ * Reads a car for one of its owners
+ */
+func (t *CarChaincode) getCoOwnedCar(stub shim.ChaincodeStubInterface, username string, vin string) (Car, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return Car{}, err
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return Car{}, err
+	}
+
+	if _, ok := ownerShares(&car)[username]; !ok {
+		return Car{}, newError(ErrNotOwner, "Forbidden: you do not own a share of this car")
+	}
+
+	return car, nil
+}
+
+/*
+ * Applies a co-ownership change every owner approved
+ */
+func applyCoOwnerChange(car *Car) {
+	change := car.CoOwnership.Pending
+	shares := ownerShares(car)
+	owner := car.Certificate.Username
+
+	switch change.Action {
+	case coOwnerAdd:
+		// the new share comes from the owner in the certificate
+		shares[owner] -= change.Share
+		shares[change.User] = change.Share
+	case coOwnerRemove:
+		shares[owner] += shares[change.User]
+		delete(shares, change.User)
+	case coOwnerQuorum:
+		car.CoOwnership.Quorum = change.Quorum
+	}
+
+	car.CoOwnership.Shares = shares
+	if !IsCoOwned(car) {
+		car.CoOwnership.Shares = nil
+	}
+	car.CoOwnership.Pending = nil
+	car.CoOwnership.Approvals = nil
+}
+
+/*
+ * Proposes or approves a co-ownership change.
+ *
+ * The first owner proposes the change, the others approve
+ * it by proposing the same change. Once all owners approved,
+ * the change is applied. A different pending change has to
+ * be approved first.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) changeCoOwnership(stub shim.ChaincodeStubInterface, username string, vin string, change CoOwnerChange) pb.Response {
+	car, err := t.getCoOwnedCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	shares := ownerShares(&car)
+	owner := car.Certificate.Username
+
+	switch change.Action {
+	case coOwnerAdd:
+		if _, ok := shares[change.User]; ok {
+			return errorResponse(ErrAlreadyExists, fmt.Sprintf("User '%s' already owns a share of this car", change.User))
+		} else if change.Share < 1 || change.Share >= shares[owner] {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("The share must be between 1 and %d%%, the share of the owner '%s'", shares[owner]-1, owner))
+		}
+		_, err = t.getUser(stub, change.User)
+		if err != nil {
+			return errorResponse(ErrUserNotFound, "User does not exist. Username: '"+change.User+"'")
+		}
+	case coOwnerRemove:
+		if _, ok := shares[change.User]; !ok || change.User == owner {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("User '%s' is not a co-owner of this car", change.User))
+		}
+	case coOwnerQuorum:
+		if change.Quorum < 1 || change.Quorum > 100 {
+			return errorResponse(ErrInvalidArgument, "The quorum must be between 1 and 100%")
+		}
+	}
+
+	pending := car.CoOwnership.Pending
+	if pending == nil {
+		car.CoOwnership.Pending = &change
+		car.CoOwnership.Approvals = []string{}
+	} else if *pending != change {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Another co-ownership change ('%s') is pending approval", pending.Action))
+	}
+
+	for _, approver := range car.CoOwnership.Approvals {
+		if approver == username {
+			return errorResponse(ErrAlreadyExists, "You already approved this change")
+		}
+	}
+	car.CoOwnership.Approvals = append(car.CoOwnership.Approvals, username)
+
+	if len(car.CoOwnership.Approvals) == len(shares) {
+		applyCoOwnerChange(&car)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Consents to transferring a co-owned car to 'receiver'.
+ * The transfer itself is done by the owner in the certificate.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) approveTransfer(stub shim.ChaincodeStubInterface, username string, vin string, receiver string) pb.Response {
+	car, err := t.getCoOwnedCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsCoOwned(&car) {
+		return errorResponse(ErrInvalidState, "The car has no co-owners, no consent needed")
+	}
+
+	if car.CoOwnership.TransferApprovals == nil {
+		car.CoOwnership.TransferApprovals = make(map[string]string)
+	}
+	car.CoOwnership.TransferApprovals[username] = receiver
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCoOwnership(t *testing.T) {
+	owner := "amag"
+	bobby := "bobby"
+	carl := "carl"
+	receiver := "dave"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	for _, name := range []string{owner, bobby, carl, receiver} {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", name, "user"))
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	// a single owner adds a co-owner right away
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("addCoOwner", owner, "user", vin, bobby, "30"))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.CoOwnership.Shares[owner] != 70 || car.CoOwnership.Shares[bobby] != 30 {
+		t.Fatalf("Unexpected shares: %v", car.CoOwnership.Shares)
+	}
+
+	// now every owner has to approve
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addCoOwner", owner, "user", vin, carl, "20"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("removeCoOwner", bobby, "user", vin, bobby))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addCoOwner", carl, "user", vin, carl, "20"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addCoOwner", bobby, "user", vin, carl, "20"))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.CoOwnership.Shares[owner] != 50 || car.CoOwnership.Shares[carl] != 20 || car.CoOwnership.Pending != nil {
+		t.Fatalf("Unexpected shares after approval: %v", car.CoOwnership)
+	}
+
+	// transfers are unanimous by default
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, receiver))
+	expectErrorCode(t, response, ErrForbidden)
+
+	// with a quorum of 80%, bobby's consent is enough
+	for _, name := range []string{owner, bobby, carl} {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("setCoOwnerQuorum", name, "user", vin, "80"))
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveTransfer", bobby, "user", vin, receiver))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, receiver))
+	car = Car{}
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Certificate.Username != receiver || IsCoOwned(&car) {
+		t.Errorf("Receiver should be the single owner: %v", car)
+	}
+}
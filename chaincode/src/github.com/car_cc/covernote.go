@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Cover notes.
+ *
+ * Buyers have to drive a just purchased car before the
+ * insurer has recorded the full policy. An insurer issues a
+ * temporary cover note for the owner with 'issueCoverNote',
+ * which insures the car for a few days, see 'IsInsured'.
+ * The note does not pass to the next owner.
+ *
+ * A car confirmed on a cover note only is scheduled for
+ * revocation at the end of the note. Without a full policy
+ * by then, the car is no longer confirmed, and anybody can
+ * free the numberplate with 'revokeLapsedCoverNote'.
+ */
+
+// days a cover note is valid by default
+const defaultCoverNoteDays int = 14
+
+// longest a cover note is valid
+const maxCoverNoteDays int = 30
+
+/*
+ * Checks if the owner of 'car' holds a cover note at 'now'
+ */
+func HasCurrentCoverNote(car *Car, now int64) bool {
+	note := car.CoverNote
+	return note != nil && note.Owner == car.Certificate.Username && note.IssuedTs <= now && now < note.ExpiresTs
+}
+
+/*
+ * Issues a cover note of 'insurer' for the owner of a
+ * registered car, replacing an earlier note.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  (optional) valid days                    int
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) issueCoverNote(stub shim.ChaincodeStubInterface, insurer string, args []string) pb.Response {
+	vin := args[0]
+	days := defaultCoverNoteDays
+	if len(args) > 1 && args[1] != "" {
+		var err error
+		days, err = strconv.Atoi(args[1])
+		if err != nil || days < 1 || days > maxCoverNoteDays {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("'issueCoverNote' expects between 1 and %d valid days", maxCoverNoteDays))
+		}
+	}
+
+	err := requireAccreditedInsurer(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Only registered cars get a cover note")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	previousTs := int64(0)
+	if car.CoverNote != nil {
+		previousTs = car.CoverNote.ExpiresTs
+	}
+
+	car.CoverNote = &CoverNote{
+		Insurer:   insurer,
+		Owner:     owner,
+		IssuedTs:  now,
+		ExpiresTs: now + int64(days)*secondsPerDay}
+
+	err = updateExpiry(stub, expiryCoverNote, vin, previousTs, car.CoverNote.ExpiresTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Insurer '%s' issued a cover note for car '%s' of '%s'\n", insurer, vin, owner)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Revokes a car confirmed on a cover note once the note
+ * ended without a full policy. If the insurer recorded the
+ * policy in time, only drops the scheduled revocation.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) revokeLapsedCoverNote(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.RevocationDueTs == 0 {
+		return errorResponse(ErrInvalidState, "There is no revocation scheduled for the car")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if now < car.RevocationDueTs && !IsInsuredByPolicy(&car, now) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("The cover note of the car is valid until '%d'", car.RevocationDueTs))
+	}
+
+	if !IsInsuredByPolicy(&car, now) {
+		car.Certificate.Numberplate = ""
+		car.CoverNote = nil
+		fmt.Printf("Car '%s' revoked after its cover note lapsed\n", vin)
+	}
+	car.RevocationDueTs = 0
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCoverNote(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	accredit(stub, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", seller, "dot", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", seller, "garage", vin, buyer))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", buyer, "dot", vin, "ZH 7878"))
+	expectErrorCode(t, response, ErrNotInsured)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueCoverNote", "axa", "insurer", vin, "60"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueCoverNote", "axa", "insurer", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.CoverNote == nil || car.CoverNote.Owner != buyer || car.CoverNote.ExpiresTs-car.CoverNote.IssuedTs != int64(defaultCoverNoteDays)*secondsPerDay {
+		t.Fatalf("Expected a cover note for the buyer, got %s", response.Message)
+	}
+
+	// the cover note is enough to confirm the car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", buyer, "dot", vin, "ZH 7878"))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "ZH 7878" || car.RevocationDueTs != car.CoverNote.ExpiresTs {
+		t.Fatalf("Expected a confirmed car with scheduled revocation, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revokeLapsedCoverNote", "mallory", "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// let the cover note lapse without a full policy
+	stub.MockTransactionStart(uuid)
+	car.CoverNote.IssuedTs -= 15 * secondsPerDay
+	car.CoverNote.ExpiresTs -= 15 * secondsPerDay
+	car.RevocationDueTs = car.CoverNote.ExpiresTs
+	carAsBytes, _ := json.Marshal(car)
+	stub.PutState(vin, carAsBytes)
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "officer", "police", vin))
+	lookup := PoliceLookup{}
+	json.Unmarshal(response.Payload, &lookup)
+	if lookup.Confirmed {
+		t.Error("Car should no longer be confirmed after the cover note lapsed")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revokeLapsedCoverNote", "mallory", "user", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "" || car.RevocationDueTs != 0 || car.CoverNote != nil {
+		t.Errorf("Expected the car revoked, got %s", response.Message)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Package deals.
+ *
+ * Fleet sales and dealer-to-dealer swaps move several
+ * cars for one price. 'sellDeal' sells all cars of a deal
+ * to one buyer in a single transaction: every car is
+ * checked before anything is written, and a car failing
+ * while selling fails the transaction, so either all
+ * cars change owner or none.
+ *
+ * The seller allocates the price to the cars. Each car is
+ * sold, taxed and recorded in the price history at its
+ * allocation, which is checked against the reference
+ * value like a single sale. A deal cannot wait for the
+ * review of one car, so an undervalued allocation fails
+ * the deal instead of flagging the sale.
+ *
+ * A transaction does not see its own writes, so the
+ * cars are written one by one, but the balances, the
+ * treasury, the price statistics and the inventory are
+ * added up over all cars and written once.
+ *
+ * The deal is stored under 'deal~<tx id>'. Fabric only
+ * keeps one event per transaction, so 'dealClosed'
+ * replaces the 'carSold' events of the cars.
+ */
+
+// object type of deal keys
+const dealObjectType string = "deal"
+
+// maximum number of cars per deal, see 'maxBatchSize'
+const maxDealSize int = 100
+
+/*
+ * Reads deal 'id'
+ */
+func getDeal(stub shim.ChaincodeStubInterface, id string) (*Deal, error) {
+	key, err := stub.CreateCompositeKey(dealObjectType, []string{id})
+	if err != nil {
+		return nil, newError(ErrInternal, "Error creating deal key")
+	}
+
+	dealAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading deal")
+	} else if dealAsBytes == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no deal '%s'", id))
+	}
+
+	deal := Deal{}
+	err = ledgerjson.Unmarshal(dealAsBytes, &deal)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing deal")
+	}
+
+	return &deal, nil
+}
+
+/*
+ * Checks that car 'vin' can be sold to 'buyer' for
+ * 'price' as part of a deal.
+ *
+ * Returns the car and what the buyer pays
+ * for it, transfer tax included.
+ */
+func (t *CarChaincode) checkDealItem(stub shim.ChaincodeStubInterface, config Config, seller string, buyer string, vin string, price int) (Car, int, error) {
+	car, err := t.checkTransfer(stub, seller, vin, buyer)
+	if err != nil {
+		return Car{}, 0, err
+	}
+
+	review, err := getSaleReview(stub, vin)
+	if err != nil {
+		return Car{}, 0, err
+	} else if review != nil && review.Status == saleReviewPending {
+		return Car{}, 0, newError(ErrInvalidState, fmt.Sprintf("A sale of car '%s' is waiting for review by the DOT", vin))
+	}
+
+	if car.Certificate.Brand != "" && car.Certificate.Model != "" {
+		reference, err := getReferenceValue(stub, car.Certificate.Brand, car.Certificate.Model)
+		if err != nil {
+			return Car{}, 0, err
+		} else if reference != nil && isUndervalued(config, price, reference.Value) {
+			return Car{}, 0, newError(ErrInvalidArgument, fmt.Sprintf("The price allocated to car '%s' is far below its reference value of %d", vin, reference.Value))
+		}
+	}
+
+	// a deposit of the buyer counts towards the price
+	cost := price + scheduledFee(config, transferTaxName, price, 0)
+	if hold := car.Listing.Hold; hold != nil && hold.Buyer == buyer {
+		cost -= hold.Amount
+	}
+
+	return car, cost, nil
+}
+
+/*
+ * Sells checked 'car' of a deal for 'price'. Writes
+ * the car, its price record, its reputation events
+ * and the lien of a loan funding a deposit, and
+ * adds the rest to what the caller writes once:
+ * - 'balances', the change per user
+ * - 'prices', the public prices per model
+ * - 'inventory', see 'handOver'
+ *
+ * Returns the transfer tax.
+ */
+func (t *CarChaincode) sellDealItem(stub shim.ChaincodeStubInterface, config Config, car Car, seller string, buyer string, price int, balances map[string]int, prices map[[2]string][]int, inventory map[string]map[string]InventoryEntry) (int, error) {
+	// the deposit converts into the purchase,
+	// a lapsed deposit of someone else goes back
+	deposit := 0
+	if hold := car.Listing.Hold; hold != nil && hold.Buyer == buyer {
+		deposit = hold.Amount
+		err := pledgeCar(stub, &car, hold)
+		if err != nil {
+			return 0, err
+		}
+	} else if hold != nil {
+		balances[depositPayer(hold)] += hold.Amount
+	}
+
+	tax := scheduledFee(config, transferTaxName, price, 0)
+	balances[buyer] -= price + tax - deposit
+
+	record, err := writePriceRecord(stub, &car, priceKindSale, price)
+	if err != nil {
+		return 0, err
+	} else if isPublicPrice(record) {
+		model := [2]string{record.Brand, record.Model}
+		prices[model] = append(prices[model], price)
+	}
+
+	err = recordCompletedSale(stub, seller, buyer, car.Vin)
+	if err != nil {
+		return 0, err
+	}
+
+	// the listing stake goes back with the price
+	stake, err := t.handOver(stub, &car, seller, buyer, inventory)
+	if err != nil {
+		return 0, err
+	}
+	balances[seller] += price + stake
+
+	return tax, nil
+}
+
+/*
+ * Sells the cars of a deal to 'buyer', all or none.
+ *
+ * Expects the deal as json with the total price and
+ * the price allocated to each car, the allocations
+ * adding up to the total.
+ *
+ * Emits 'dealClosed' with the deal.
+ *
+ * On success,
+ * returns the deal.
+ */
+func (t *CarChaincode) sellDeal(stub shim.ChaincodeStubInterface, username string, buyer string, dealData string) pb.Response {
+	deal := Deal{}
+	err := ledgerjson.Unmarshal([]byte(dealData), &deal)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing deal. Expecting Deal as json.")
+	}
+
+	if buyer == "" {
+		return errorResponse(ErrInvalidArgument, "'sellDeal' expects a non-empty buyer")
+	} else if len(deal.Cars) < 2 {
+		return errorResponse(ErrInvalidArgument, "'sellDeal' expects at least two cars, use 'sell' for a single car")
+	} else if len(deal.Cars) > maxDealSize {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'sellDeal' accepts at most %d cars, got %d", maxDealSize, len(deal.Cars)))
+	}
+
+	allocated := 0
+	vins := make(map[string]bool)
+	for _, item := range deal.Cars {
+		if item.Price < 0 {
+			return errorResponse(ErrInvalidArgument, "'sellDeal' expects positive prices")
+		} else if vins[item.Vin] {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("Car '%s' is part of the deal twice", item.Vin))
+		}
+		vins[item.Vin] = true
+		allocated += item.Price
+	}
+	if deal.Price < 0 || allocated != deal.Price {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("The allocated prices add up to %d, not to the deal price of %d", allocated, deal.Price))
+	}
+
+	// agents need a mandate of the same owner for every car
+	seller, err := t.principal(stub, username, deal.Cars[0].Vin, mandateSell)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	for _, item := range deal.Cars[1:] {
+		principal, err := t.principal(stub, username, item.Vin, mandateSell)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if principal != seller {
+			return errorResponse(ErrInvalidArgument, "All cars of a deal have to belong to the same seller")
+		}
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// check every car before the first one is sold
+	cost := 0
+	cars := []Car{}
+	for _, item := range deal.Cars {
+		car, itemCost, err := t.checkDealItem(stub, config, seller, buyer, item.Vin, item.Price)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		cars = append(cars, car)
+		cost += itemCost
+	}
+
+	buyerAsUser, err := t.getUser(stub, buyer)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if buyerAsUser.Balance < cost {
+		return errorResponse(ErrInsufficientFunds, fmt.Sprintf("Buyer has not enough credits for the deal, it costs %d", cost))
+	}
+
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	stocked := len(inventory[seller]) > 0
+
+	tax := 0
+	balances := make(map[string]int)
+	prices := make(map[[2]string][]int)
+	for i, item := range deal.Cars {
+		deal.Cars[i].Tax, err = t.sellDealItem(stub, config, cars[i], seller, buyer, item.Price, balances, prices, inventory)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		tax += deal.Cars[i].Tax
+	}
+
+	// everything the cars share is written once
+	users := []string{}
+	for username := range balances {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+
+	for _, username := range users {
+		_, err = t.updateBalance(stub, username, balances[username])
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	err = t.collectFee(stub, transferTaxName, tax)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	models := [][2]string{}
+	for model := range prices {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i][0]+"~"+models[i][1] < models[j][0]+"~"+models[j][1]
+	})
+
+	for _, model := range models {
+		err = addToPriceStats(stub, model[0], model[1], prices[model]...)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	if stocked {
+		err = t.saveInventoryIndex(stub, inventory)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	deal.Id = stub.GetTxID()
+	deal.Seller = seller
+	deal.Buyer = buyer
+	deal.SoldBy = username
+	deal.Ts, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := stub.CreateCompositeKey(dealObjectType, []string{deal.Id})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating deal key")
+	}
+
+	dealAsBytes, _ := ledgerjson.Marshal(deal)
+	err = stub.PutState(key, dealAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing deal")
+	}
+
+	err = stub.SetEvent("dealClosed", dealAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting 'dealClosed' event")
+	}
+
+	fmt.Printf("Deal '%s' closed: %d cars sold by '%s' to '%s' for %d\n", deal.Id, len(deal.Cars), seller, buyer, deal.Price)
+	return shim.Success(dealAsBytes)
+}
+
+/*
+ * Reads a deal, for its seller and buyer and the DOT.
+ *
+ * On success,
+ * returns the deal.
+ */
+func (t *CarChaincode) readDeal(stub shim.ChaincodeStubInterface, username string, role string, id string) pb.Response {
+	deal, err := getDeal(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if role != "dot" && username != deal.Seller && username != deal.Buyer && username != deal.SoldBy {
+		return errorResponse(ErrForbidden, "Forbidden: you are not a party of the deal")
+	}
+
+	dealAsBytes, _ := ledgerjson.Marshal(deal)
+	return shim.Success(dealAsBytes)
+}
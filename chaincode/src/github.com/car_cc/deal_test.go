@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Like a peer, fails reads of keys the
+// transaction wrote, instead of returning them
+type staleReadStub struct {
+	shim.ChaincodeStubInterface
+	written map[string]bool
+}
+
+func (s *staleReadStub) GetState(key string) ([]byte, error) {
+	if s.written[key] {
+		return nil, fmt.Errorf("'%s' read after it was written", key)
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *staleReadStub) PutState(key string, value []byte) error {
+	s.written[key] = true
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (s *staleReadStub) DelState(key string) error {
+	s.written[key] = true
+	return s.ChaincodeStubInterface.DelState(key)
+}
+
+// Invokes through a 'staleReadStub' while strict
+type staleReadChaincode struct {
+	CarChaincode
+	strict bool
+}
+
+func (c *staleReadChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if c.strict {
+		stub = &staleReadStub{ChaincodeStubInterface: stub, written: make(map[string]bool)}
+	}
+	return c.CarChaincode.Invoke(stub)
+}
+
+func TestSellDeal(t *testing.T) {
+	garage := "amag"
+	buyer := "bobby"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781", "WVWZZZ6RXHY260782"}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "emil", "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[0]+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[1]+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "emil", "garage", `{ "vin": "`+vins[2]+`" }`))
+
+	// the allocations have to add up to the price
+	deal := `{ "price": 90, "cars": [ { "vin": "` + vins[0] + `", "price": 50 }, { "vin": "` + vins[1] + `", "price": 30 } ] }`
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// a car of another garage fails the whole deal
+	deal = `{ "price": 90, "cars": [ { "vin": "` + vins[0] + `", "price": 50 }, { "vin": "` + vins[2] + `", "price": 40 } ] }`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	deal = `{ "price": 200, "cars": [ { "vin": "` + vins[0] + `", "price": 120 }, { "vin": "` + vins[1] + `", "price": 80 } ] }`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+	expectErrorCode(t, response, ErrInsufficientFunds)
+
+	// nothing was sold
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "garage", vins[0]))
+	if response.Status != shim.OK {
+		t.Fatalf("Expected the car to stay with the garage, got %s", response.Message)
+	}
+
+	deal = `{ "price": 90, "cars": [ { "vin": "` + vins[0] + `", "price": 60 }, { "vin": "` + vins[1] + `", "price": 30 } ] }`
+	response = stub.MockInvoke("1", util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+	closed := Deal{}
+	json.Unmarshal(response.Payload, &closed)
+	if response.Status != shim.OK || closed.Id != "1" || closed.Seller != garage || closed.Buyer != buyer || len(closed.Cars) != 2 {
+		t.Fatalf("Expected the closed deal, got %s", response.Message)
+	}
+
+	for _, vin := range vins[:2] {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+		if response.Status != shim.OK {
+			t.Errorf("Expected car '%s' to belong to the buyer, got %s", vin, response.Message)
+		}
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", buyer, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 10 {
+		t.Errorf("Expected the buyer to pay the deal price, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getDeal", "emil", "garage", "1"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getDeal", "inspector", "dot", "1"))
+	closed = Deal{}
+	json.Unmarshal(response.Payload, &closed)
+	if closed.Cars[0].Vin != vins[0] || closed.Cars[0].Price != 60 || closed.Price != 90 {
+		t.Errorf("Expected the per-car allocation, got %s", response.Payload)
+	}
+}
+
+func TestSellDealWritesOnce(t *testing.T) {
+	garage := "amag"
+	buyer := "bobby"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+	cc := &staleReadChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", transferTaxName, "0", "10"))
+	for _, vin := range vins {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+			`{ "vin": "`+vin+`", "certificate": { "brand": "VW", "model": "Polo" } }`))
+	}
+
+	// buyer, seller, treasury and statistics are shared by the cars
+	cc.strict = true
+	deal := `{ "price": 90, "cars": [ { "vin": "` + vins[0] + `", "price": 60 }, { "vin": "` + vins[1] + `", "price": 30 } ] }`
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+	cc.strict = false
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	for username, balance := range map[string]int{buyer: 1, garage: 190} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected a balance of %d for '%s', got %d", balance, username, user.Balance)
+		}
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getTreasuryBalance", "dot", "dot"))
+	treasury := Treasury{}
+	json.Unmarshal(response.Payload, &treasury)
+	if treasury.Collected[transferTaxName] != 9 {
+		t.Errorf("Expected the transfer tax of both cars, got %v", treasury)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("averagePriceByModel", buyer, "user", "VW", "Polo"))
+	stats := ModelPriceStats{}
+	json.Unmarshal(response.Payload, &stats)
+	if stats.Sales != 2 || stats.Average != 45 {
+		t.Errorf("Expected both prices in the statistics, got %v", stats)
+	}
+
+	carIndex, _ := (&CarChaincode{}).getCarIndex(stub)
+	if carIndex[vins[0]] == "" || carIndex[vins[0]] != carIndex[vins[1]] {
+		t.Errorf("Expected both cars in the index with the buyer, got %v", carIndex)
+	}
+}
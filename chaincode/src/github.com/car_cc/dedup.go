@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Idempotency keys.
+ *
+ * Clients can pass a key in the transient field
+ * 'idempotencyKey' to functions which create cars or move
+ * balances. The response of the first successful call is
+ * kept under 'txdedup~<username>~<key>', so a retry after
+ * a timeout returns it again instead of creating a second
+ * car or paying twice. Keys are scoped to the invoker, and
+ * reusing a key with other arguments fails.
+ */
+
+// object type of idempotency keys
+const txDedupObjectType string = "txdedup"
+
+// transient field holding the idempotency key
+const idempotencyKeyTransient string = "idempotencyKey"
+
+// longest accepted idempotency key
+const maxIdempotencyKeyLength = 128
+
+/*
+ * Returns the ledger key of idempotency key 'key' of 'username'
+ */
+func getTxDedupKey(stub shim.ChaincodeStubInterface, username string, key string) (string, error) {
+	dedupKey, err := stub.CreateCompositeKey(txDedupObjectType, []string{username, key})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating idempotency key")
+	}
+
+	return dedupKey, nil
+}
+
+/*
+ * Reads the idempotency key from the transient data.
+ *
+ * Returns an empty key if the client passed none.
+ */
+func getIdempotencyKey(stub shim.ChaincodeStubInterface) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", newError(ErrLedger, "Error reading transient data")
+	}
+
+	key := string(transient[idempotencyKeyTransient])
+	if len(key) > maxIdempotencyKeyLength {
+		return "", newError(ErrInvalidArgument, fmt.Sprintf("Idempotency keys can have at most %d characters", maxIdempotencyKeyLength))
+	}
+
+	return key, nil
+}
+
+/*
+ * Hashes a call, so a reused key can be told apart
+ */
+func hashCall(function string, args []string) string {
+	callAsBytes, _ := ledgerjson.Marshal(append([]string{function}, args...))
+	hash := sha256.Sum256(callAsBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Reads the outcome of an earlier call under 'dedupKey'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func (t *CarChaincode) getTxDedup(stub shim.ChaincodeStubInterface, dedupKey string) (*TxDedup, error) {
+	dedupAsBytes, err := stub.GetState(dedupKey)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading idempotency key")
+	} else if dedupAsBytes == nil {
+		return nil, nil
+	}
+
+	dedup := TxDedup{}
+	err = ledgerjson.Unmarshal(dedupAsBytes, &dedup)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing idempotency key")
+	}
+
+	return &dedup, nil
+}
+
+/*
+ * Calls 'handler' at most once per idempotency key.
+ *
+ * Without a key in the transient data, the handler
+ * is simply called. Failed calls are not kept, so
+ * they can be retried with the same key.
+ */
+func (t *CarChaincode) invokeOnce(stub shim.ChaincodeStubInterface, call invocation, handler handler) pb.Response {
+	key, err := getIdempotencyKey(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if key == "" {
+		return handler(t, stub, call)
+	}
+
+	dedupKey, err := getTxDedupKey(stub, call.username, key)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	callHash := hashCall(call.function, call.args)
+
+	dedup, err := t.getTxDedup(stub, dedupKey)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if dedup != nil {
+		if dedup.CallHash != callHash {
+			return errorResponseFrom(newErrorWithDetails(ErrInvalidArgument, "Idempotency key was already used for another call", map[string]string{"idempotency_key": key, "tx_id": dedup.TxId}))
+		}
+		fmt.Printf("Call with idempotency key '%s' was done by transaction '%s'\n", key, dedup.TxId)
+		return shim.Success(dedup.Payload)
+	}
+
+	response := handler(t, stub, call)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	dedupAsBytes, _ := ledgerjson.Marshal(TxDedup{TxId: stub.GetTxID(), Ts: now, Function: call.function, CallHash: callHash, Payload: response.Payload})
+	err = stub.PutState(dedupKey, dedupAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing idempotency key")
+	}
+
+	return response
+}
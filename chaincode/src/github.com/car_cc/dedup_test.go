@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	// the client retries after a timeout
+	stub.TransientMap = map[string][]byte{idempotencyKeyTransient: []byte("create-1")}
+	first := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	if first.Status != shim.OK {
+		t.Fatal(first.Message)
+	}
+
+	retry := stub.MockInvoke("2", util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	if retry.Status != shim.OK {
+		t.Fatalf("Expected the retry to succeed, got %s", retry.Message)
+	} else if !bytes.Equal(retry.Payload, first.Payload) {
+		t.Error("Expected the retry to return the first response")
+	}
+
+	// the key cannot be reused for another car
+	response := stub.MockInvoke("3", util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6RZHY260780" }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// without a key, the car exists already
+	stub.TransientMap = nil
+	response = stub.MockInvoke("4", util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	expectErrorCode(t, response, ErrCarExists)
+}
+
+func TestIdempotentSell(t *testing.T) {
+	garage := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", garage, "dot", vin))
+
+	stub.TransientMap = map[string][]byte{idempotencyKeyTransient: []byte("sale-1")}
+	for i := 0; i < 2; i++ {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", garage, "garage", "40", vin, buyer))
+		if response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+	}
+	stub.TransientMap = nil
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", buyer, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 60 {
+		t.Errorf("Expected the buyer to pay once and keep 60 credits, got %d", user.Balance)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Telematics devices.
+ *
+ * The owner enrolls one telematics device per car by the
+ * hash of its client identity. The device signs 'logTrip'
+ * transactions with that identity, so only trips it reports
+ * add to the car's mile age. Trips are kept under
+ * 'trip~<vin>~<start>'.
+ */
+
+// object type of trip keys
+const tripObjectType string = "trip"
+
+// fastest plausible average speed of a trip
+const maxTripSpeedKmh int64 = 250
+
+/*
+ * Checks if a car has an enrolled device
+ */
+func HasDevice(car *Car) bool {
+	return car.Device.Identity != "" && !car.Device.Revoked
+}
+
+/*
+ * Enrolls the telematics device with identity hash
+ * 'identity' for the car, replacing an earlier device.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) enrollDevice(stub shim.ChaincodeStubInterface, username string, vin string, identity string) pb.Response {
+	if len(identity) != 64 {
+		return errorResponse(ErrInvalidArgument, "'enrollDevice' expects the hex encoded sha256 hash of the device identity")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// trips of the new device start after the old ones
+	car.Device = TelematicsDevice{
+		Identity:      identity,
+		EnrolledTs:    now,
+		LastTripEndTs: car.Device.LastTripEndTs}
+
+	return t.saveDeviceCar(stub, &car)
+}
+
+/*
+ * Revokes the enrolled device, e.g. after it was
+ * removed from the car or compromised.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) revokeDevice(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !HasDevice(&car) {
+		return errorResponse(ErrNotFound, "The car has no enrolled device")
+	}
+
+	car.Device.Revoked = true
+
+	return t.saveDeviceCar(stub, &car)
+}
+
+/*
+ * Logs a trip reported by the enrolled device
+ * and adds its distance to the mile age.
+ *
+ * Trips must not overlap and must not be faster
+ * than 'maxTripSpeedKmh' on average.
+ *
+ * On success,
+ * returns the trip.
+ */
+func (t *CarChaincode) logTrip(stub shim.ChaincodeStubInterface, vin string, trip Trip) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !HasDevice(&car) {
+		return errorResponse(ErrNotFound, "The car has no enrolled device")
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if caller != car.Device.Identity {
+		return errorResponse(ErrIdentityMismatch, "Forbidden: trips can only be logged by the enrolled device")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if trip.EndTs <= trip.StartTs || trip.EndTs > now || trip.Km < 0 {
+		return errorResponse(ErrInvalidArgument, "'logTrip' expects a past trip with its end after its start and a distance of 0 or more")
+	} else if trip.StartTs < car.Device.LastTripEndTs {
+		return errorResponse(ErrInvalidArgument, "Trip overlaps with an earlier trip")
+	} else if int64(trip.Km)*3600 > maxTripSpeedKmh*(trip.EndTs-trip.StartTs) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Trip is faster than %d km/h on average", maxTripSpeedKmh))
+	}
+
+	trip.Vin = vin
+	trip.Device = caller
+
+	key, err := stub.CreateCompositeKey(tripObjectType, []string{vin, fmt.Sprintf("%012d", trip.StartTs)})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating trip key")
+	}
+
+	tripAsBytes, _ := ledgerjson.Marshal(trip)
+	err = stub.PutState(key, tripAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing trip")
+	}
+
+	car.UsageData.MileAge += trip.Km
+	car.Device.LastTripEndTs = trip.EndTs
+
+	response := t.saveDeviceCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	return shim.Success(tripAsBytes)
+}
+
+/*
+ * Writes a car with a changed device or mile age
+ */
+func (t *CarChaincode) saveDeviceCar(stub shim.ChaincodeStubInterface, car *Car) pb.Response {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDeviceTrips(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	device := "Org1MSP telematics box 4711"
+	rogue := "Org1MSP telematics box 666"
+	deviceHash := sha256.Sum256([]byte(device))
+
+	now := time.Now().Unix()
+	ts := func(offset int64) string {
+		return strconv.FormatInt(now+offset, 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("enrollDevice", owner, "user", vin, hex.EncodeToString(deviceHash[:])))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// only the enrolled device logs trips
+	response = invokeAs(stub, rogue, "logTrip", "device", "device", vin, ts(-7200), ts(-3600), "80")
+	expectErrorCode(t, response, ErrIdentityMismatch)
+
+	response = invokeAs(stub, device, "logTrip", "device", "device", vin, ts(-7200), ts(-3600), "80")
+	trip := Trip{}
+	err := json.Unmarshal(response.Payload, &trip)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// overlapping and implausible trips are refused
+	response = invokeAs(stub, device, "logTrip", "device", "device", vin, ts(-5400), ts(-1800), "10")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = invokeAs(stub, device, "logTrip", "device", "device", vin, ts(-3000), ts(-2400), "500")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	invokeAs(stub, device, "logTrip", "device", "device", vin, ts(-3000), ts(-2400), "30")
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.UsageData.MileAge != 110 {
+		t.Errorf("Mile age should be 110 km after two trips, but is %d", car.UsageData.MileAge)
+	}
+
+	// revoked devices cannot log trips
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("revokeDevice", owner, "user", vin))
+	response = invokeAs(stub, device, "logTrip", "device", "device", vin, ts(-600), ts(-60), "5")
+	expectErrorCode(t, response, ErrNotFound)
+}
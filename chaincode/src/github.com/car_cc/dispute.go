@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Sale disputes.
+ *
+ * The buyer disputes a sale or deal with 'openDispute'
+ * within the dispute window, naming it by the transaction
+ * that closed it: the deal id, or the 'tx_id' in the price
+ * history of a single sale. The buyer may claim an amount
+ * back, which is taken from the seller's balance into
+ * escrow as far as it covers it, and pays the dispute
+ * deposit of the fee schedule.
+ *
+ * Both parties and arbiters add document hashes as
+ * evidence with 'addDisputeEvidence'. An arbiter settles
+ * the dispute with 'resolveDispute':
+ * - 'refund': the escrow and the deposit go to the buyer
+ * - 'reversal': the cars go back to the seller, the escrow
+ *   and the deposit to the buyer
+ * - 'penalty': the escrow goes back and the deposit goes
+ *   to the seller
+ * - 'dismissed': the escrow goes back to the seller, the
+ *   deposit to the buyer
+ *
+ * Disputes are kept under 'dispute~<id>' with their evidence
+ * and resolution and are never deleted. Every outcome counts
+ * in the reputation of the party losing it, see
+ * 'getReputation'.
+ */
+
+// object type of dispute keys
+const disputeObjectType string = "dispute"
+
+// days after a sale the buyer can dispute it
+const disputeWindowDays int64 = 30
+
+// fee name of the dispute deposit and its default
+const disputeDepositName string = "dispute_deposit"
+const defaultDisputeDeposit int = 10
+
+// dispute states, every state but 'open' is an outcome
+const disputeOpen string = "open"
+const disputeRefund string = "refund"
+const disputeReversal string = "reversal"
+const disputePenalty string = "penalty"
+const disputeDismissed string = "dismissed"
+
+var disputeOutcomes = []string{disputeRefund, disputeReversal, disputePenalty, disputeDismissed}
+
+/*
+ * Returns the ledger key of dispute 'id'
+ */
+func getDisputeKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(disputeObjectType, []string{id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating dispute key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads dispute 'id'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getDispute(stub shim.ChaincodeStubInterface, id string) (*Dispute, error) {
+	key, err := getDisputeKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+
+	disputeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading dispute")
+	} else if disputeAsBytes == nil {
+		return nil, nil
+	}
+
+	dispute := Dispute{}
+	err = ledgerjson.Unmarshal(disputeAsBytes, &dispute)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing dispute")
+	}
+
+	return &dispute, nil
+}
+
+/*
+ * Reads open dispute 'id'
+ */
+func getOpenDispute(stub shim.ChaincodeStubInterface, id string) (*Dispute, error) {
+	dispute, err := getDispute(stub, id)
+	if err != nil {
+		return nil, err
+	} else if dispute == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no dispute '%s'", id))
+	} else if dispute.Status != disputeOpen {
+		return nil, newError(ErrInvalidState, fmt.Sprintf("Dispute '%s' is resolved already", id))
+	}
+
+	return dispute, nil
+}
+
+/*
+ * Writes a dispute to ledger
+ */
+func saveDispute(stub shim.ChaincodeStubInterface, dispute *Dispute) error {
+	key, err := getDisputeKey(stub, dispute.Id)
+	if err != nil {
+		return err
+	}
+
+	disputeAsBytes, _ := ledgerjson.Marshal(dispute)
+	err = stub.PutState(key, disputeAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing dispute")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the purchases of 'buyer' closed by transaction
+ * 'id', one per car of the sale or deal
+ */
+func getPurchases(stub shim.ChaincodeStubInterface, buyer string, id string) ([]ReputationEntry, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(reputationObjectType, []string{buyer, reputationCompletedSale})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading reputation")
+	}
+	defer iterator.Close()
+
+	purchases := []ReputationEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading reputation")
+		}
+
+		entry := ReputationEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing reputation")
+		}
+
+		if entry.TxId == id && entry.Side == sideBuyer {
+			purchases = append(purchases, entry)
+		}
+	}
+
+	return purchases, nil
+}
+
+/*
+ * Disputes the sale or deal 'id' bought by 'username',
+ * claiming 'claim' back from the seller.
+ *
+ * On success,
+ * returns the dispute.
+ */
+func (t *CarChaincode) openDispute(stub shim.ChaincodeStubInterface, username string, id string, reason string, claim int) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'openDispute' expects a non-empty reason")
+	} else if claim < 0 {
+		return errorResponse(ErrInvalidArgument, "'openDispute' expects a claim of at least 0")
+	}
+
+	purchases, err := getPurchases(stub, username, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if len(purchases) == 0 {
+		return errorResponse(ErrNotFound, fmt.Sprintf("You did not buy anything in transaction '%s'", id))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if now-purchases[0].Ts > disputeWindowDays*secondsPerDay {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Sales can only be disputed within %d days", disputeWindowDays))
+	}
+
+	dispute, err := getDispute(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if dispute != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Transaction '%s' is disputed already", id))
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	dispute = &Dispute{
+		Id:       id,
+		Buyer:    username,
+		Seller:   purchases[0].Counterparty,
+		Reason:   reason,
+		Claim:    claim,
+		Deposit:  scheduledFee(config, disputeDepositName, claim, defaultDisputeDeposit),
+		Evidence: []DisputeEvidence{},
+		Status:   disputeOpen,
+		OpenedTs: now}
+	for _, purchase := range purchases {
+		dispute.Vins = append(dispute.Vins, purchase.Vin)
+	}
+
+	// the claim goes into escrow as far as the seller covers it
+	seller, err := t.getUser(stub, dispute.Seller)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	dispute.Escrow = claim
+	if seller.Balance < claim {
+		dispute.Escrow = seller.Balance
+	}
+
+	if dispute.Escrow > 0 {
+		_, err = t.updateBalance(stub, dispute.Seller, -dispute.Escrow)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	_, err = t.updateBalance(stub, username, -dispute.Deposit)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = saveDispute(stub, dispute)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordReputation(stub, username, reputationDisputeOpened, dispute.Vins[0], dispute.Seller, sideBuyer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Transaction '%s' of '%s' disputed by '%s', %d held in escrow\n", id, dispute.Seller, username, dispute.Escrow)
+
+	disputeAsBytes, _ := ledgerjson.Marshal(dispute)
+	return shim.Success(disputeAsBytes)
+}
+
+/*
+ * Adds the hash of a document to open dispute 'id',
+ * by its parties or an arbiter.
+ *
+ * On success,
+ * returns the dispute.
+ */
+func (t *CarChaincode) addDisputeEvidence(stub shim.ChaincodeStubInterface, username string, role string, id string, hash string, description string) pb.Response {
+	dispute, err := getOpenDispute(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if role != "arbiter" && username != dispute.Buyer && username != dispute.Seller {
+		return errorResponse(ErrForbidden, "Forbidden: only the parties and arbiters can add evidence")
+	}
+
+	for _, evidence := range dispute.Evidence {
+		if evidence.Hash == hash {
+			return errorResponse(ErrInvalidState, fmt.Sprintf("The document '%s' is evidence already", hash))
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	dispute.Evidence = append(dispute.Evidence, DisputeEvidence{Hash: hash, Description: description, By: username, Ts: now})
+
+	err = saveDispute(stub, dispute)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	disputeAsBytes, _ := ledgerjson.Marshal(dispute)
+	return shim.Success(disputeAsBytes)
+}
+
+/*
+ * Moves the cars of a reversed dispute back from
+ * the buyer to the seller. Writes the inventory once
+ * and adds the deposits and listing stakes going back
+ * to 'balances', which the caller writes.
+ */
+func (t *CarChaincode) reverseDispute(stub shim.ChaincodeStubInterface, dispute *Dispute, balances map[string]int) error {
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return err
+	}
+	stocked := len(inventory[dispute.Buyer]) > 0
+
+	for _, vin := range dispute.Vins {
+		car, err := t.getCar(stub, dispute.Buyer, vin)
+		if err != nil {
+			return newError(ErrInvalidState, fmt.Sprintf("Car '%s' is no longer owned by the buyer and cannot go back", vin))
+		} else if IsRented(&car) || IsSoldInInstallments(&car) {
+			return newError(ErrInvalidState, fmt.Sprintf("Car '%s' is rented out or sold in installments", vin))
+		}
+
+		// the deposit of a new buyer goes back
+		if hold := car.Listing.Hold; hold != nil {
+			balances[depositPayer(hold)] += hold.Amount
+		}
+
+		_, err = writePriceRecord(stub, &car, priceKindReversal, 0)
+		if err != nil {
+			return err
+		}
+
+		stake, err := t.handOver(stub, &car, dispute.Buyer, dispute.Seller, inventory)
+		if err != nil {
+			return err
+		}
+		balances[dispute.Buyer] += stake
+	}
+
+	if stocked {
+		return t.saveInventoryIndex(stub, inventory)
+	}
+
+	return nil
+}
+
+/*
+ * Resolves open dispute 'id' with 'outcome'.
+ *
+ * Emits 'disputeResolved' with the dispute.
+ *
+ * On success,
+ * returns the resolved dispute.
+ */
+func (t *CarChaincode) resolveDispute(stub shim.ChaincodeStubInterface, arbiter string, id string, outcome string, note string) pb.Response {
+	if !containsString(disputeOutcomes, outcome) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'resolveDispute' expects the outcome '%s', '%s', '%s' or '%s'", disputeRefund, disputeReversal, disputePenalty, disputeDismissed))
+	}
+
+	dispute, err := getOpenDispute(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// every balance changes once, reads do not see earlier writes
+	balances := make(map[string]int)
+	balances[dispute.Buyer] = dispute.Deposit + dispute.Escrow
+	loser, winner, side := dispute.Seller, dispute.Buyer, sideSeller
+	switch outcome {
+	case disputeReversal:
+		err = t.reverseDispute(stub, dispute, balances)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	case disputePenalty:
+		balances[dispute.Buyer], balances[dispute.Seller] = 0, dispute.Deposit+dispute.Escrow
+		loser, winner, side = dispute.Buyer, dispute.Seller, sideBuyer
+	case disputeDismissed:
+		balances[dispute.Buyer], balances[dispute.Seller] = dispute.Deposit, dispute.Escrow
+		loser, winner, side = dispute.Buyer, dispute.Seller, sideBuyer
+	}
+
+	users := []string{}
+	for username, amount := range balances {
+		if amount > 0 {
+			users = append(users, username)
+		}
+	}
+	sort.Strings(users)
+
+	for _, username := range users {
+		_, err = t.updateBalance(stub, username, balances[username])
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	dispute.Status = outcome
+	dispute.ResolvedBy = arbiter
+	dispute.Note = note
+	dispute.ResolvedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = saveDispute(stub, dispute)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordReputation(stub, loser, reputationDisputeLost, dispute.Vins[0], winner, side)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	disputeAsBytes, _ := ledgerjson.Marshal(dispute)
+	err = stub.SetEvent("disputeResolved", disputeAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting 'disputeResolved' event")
+	}
+
+	fmt.Printf("Dispute '%s' resolved by '%s': %s\n", id, arbiter, outcome)
+	return shim.Success(disputeAsBytes)
+}
+
+/*
+ * Reads dispute 'id', for its parties and arbiters.
+ *
+ * On success,
+ * returns the dispute.
+ */
+func (t *CarChaincode) readDispute(stub shim.ChaincodeStubInterface, username string, role string, id string) pb.Response {
+	dispute, err := getDispute(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if dispute == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no dispute '%s'", id))
+	} else if role != "arbiter" && username != dispute.Buyer && username != dispute.Seller {
+		return errorResponse(ErrForbidden, "Forbidden: only the parties and arbiters can read the dispute")
+	}
+
+	disputeAsBytes, _ := ledgerjson.Marshal(dispute)
+	return shim.Success(disputeAsBytes)
+}
+
+/*
+ * Lists the open disputes.
+ *
+ * On success,
+ * returns the disputes by id.
+ */
+func (t *CarChaincode) getOpenDisputes(stub shim.ChaincodeStubInterface) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(disputeObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading disputes")
+	}
+	defer iterator.Close()
+
+	disputes := []Dispute{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading disputes")
+		}
+
+		dispute := Dispute{}
+		err = ledgerjson.Unmarshal(kv.Value, &dispute)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing dispute")
+		}
+
+		if dispute.Status == disputeOpen {
+			disputes = append(disputes, dispute)
+		}
+	}
+
+	disputesAsBytes, _ := ledgerjson.Marshal(disputes)
+	return shim.Success(disputesAsBytes)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDisputeReversal(t *testing.T) {
+	garage := "amag"
+	buyer := "bobby"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+	invoice := strings.Repeat("ab", 32)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[0]+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[1]+`" }`))
+
+	deal := `{ "price": 90, "cars": [ { "vin": "` + vins[0] + `", "price": 60 }, { "vin": "` + vins[1] + `", "price": 30 } ] }`
+	stub.MockInvoke("1", util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+
+	balance := func(username string) int {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		return user.Balance
+	}
+	buyerBalance, garageBalance := balance(buyer), balance(garage)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("openDispute", garage, "garage", "1", "never paid"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("openDispute", buyer, "user", "1", "odometers tampered", "90"))
+	dispute := Dispute{}
+	err := json.Unmarshal(response.Payload, &dispute)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if dispute.Seller != garage || len(dispute.Vins) != 2 || dispute.Escrow != 90 || dispute.Deposit != defaultDisputeDeposit {
+		t.Errorf("Unexpected dispute: %v", dispute)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("openDispute", buyer, "user", "1", "odometers tampered"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addDisputeEvidence", "mallory", "user", "1", invoice))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addDisputeEvidence", garage, "garage", "1", invoice, "service invoice"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOpenDisputes", "judy", "arbiter"))
+	disputes := []Dispute{}
+	json.Unmarshal(response.Payload, &disputes)
+	if len(disputes) != 1 || len(disputes[0].Evidence) != 1 || disputes[0].Evidence[0].By != garage {
+		t.Fatalf("Expected the dispute with the evidence of the seller, got %v", disputes)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveDispute", "inspector", "dot", "1", disputeReversal))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveDispute", "judy", "arbiter", "1", disputeReversal, "odometers rolled back"))
+	dispute = Dispute{}
+	json.Unmarshal(response.Payload, &dispute)
+	if dispute.Status != disputeReversal || dispute.ResolvedBy != "judy" {
+		t.Fatalf("Expected the reversed dispute, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveDispute", "judy", "arbiter", "1", disputeRefund))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	for _, vin := range vins {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "garage", vin))
+		if response.Status != shim.OK {
+			t.Errorf("Expected car '%s' to be back with the garage, got %s", vin, response.Message)
+		}
+	}
+
+	// the escrow refunds the buyer, the deposit goes back
+	if balance(buyer) != buyerBalance+90 || balance(garage) != garageBalance-90 {
+		t.Errorf("Expected 90 to go back to the buyer, balances are %d and %d", balance(buyer), balance(garage))
+	}
+}
+
+func TestDisputeReversalWritesOnce(t *testing.T) {
+	garage := "amag"
+	buyer := "bobby"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+	cc := &staleReadChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[0]+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vins[1]+`" }`))
+
+	deal := `{ "price": 40, "cars": [ { "vin": "` + vins[0] + `", "price": 20 }, { "vin": "` + vins[1] + `", "price": 20 } ] }`
+	stub.MockInvoke("1", util.ToChaincodeArgs("sellDeal", garage, "garage", buyer, deal))
+
+	// the buyer puts both cars back on the market
+	for _, vin := range vins {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", buyer, "user", vin, "50"))
+		if response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("openDispute", buyer, "user", "1", "odometers tampered", "40"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	balance := func(username string) int {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		return user.Balance
+	}
+	buyerBalance, garageBalance := balance(buyer), balance(garage)
+
+	// the buyer gets the escrow, the deposit and both stakes back
+	cc.strict = true
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveDispute", "judy", "arbiter", "1", disputeReversal))
+	cc.strict = false
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	refund := 40 + defaultDisputeDeposit + 2*defaultListingDeposit
+	if balance(buyer) != buyerBalance+refund || balance(garage) != garageBalance {
+		t.Errorf("Expected %d to go back to the buyer, balances are %d and %d", refund, balance(buyer), balance(garage))
+	}
+
+	for _, vin := range vins {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "garage", vin))
+		if response.Status != shim.OK {
+			t.Errorf("Expected car '%s' to be back with the garage, got %s", vin, response.Message)
+		}
+	}
+
+	carIndex, _ := (&CarChaincode{}).getCarIndex(stub)
+	if carIndex[vins[0]] == "" || carIndex[vins[0]] != carIndex[vins[1]] {
+		t.Errorf("Expected both cars in the index with the garage, got %v", carIndex)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+/*
+ * Stored documents.
+ *
+ * Every struct written to the ledger on its own key is a
+ * 'ledgerjson.Document', so it carries its 'docType' and
+ * 'schemaVersion'. Changing the fields of a document means
+ * raising its version here and registering an upgrade from
+ * the old version with 'ledgerjson.RegisterUpgrade' in an
+ * 'init' of this file, which 'ledgerjson.Unmarshal' then
+ * applies to every older document it reads. State is
+ * only rewritten in the new version when saved again, so
+ * no migration run is needed.
+ *
+ * Changes to keys and indexes still need a migration,
+ * see 'migrate.go'.
+ */
+
+func (Car) DocType() string    { return "car" }
+func (Car) SchemaVersion() int { return 1 }
+
+func (User) DocType() string    { return "user" }
+func (User) SchemaVersion() int { return 1 }
+
+func (Config) DocType() string    { return "config" }
+func (Config) SchemaVersion() int { return 1 }
+
+func (Treasury) DocType() string    { return "treasury" }
+func (Treasury) SchemaVersion() int { return 1 }
+
+func (TxDedup) DocType() string    { return "tx_dedup" }
+func (TxDedup) SchemaVersion() int { return 1 }
+
+func (ArchivedCar) DocType() string    { return "archived_car" }
+func (ArchivedCar) SchemaVersion() int { return 1 }
+
+func (Appraisal) DocType() string    { return "appraisal" }
+func (Appraisal) SchemaVersion() int { return 1 }
+
+func (Attachment) DocType() string    { return "attachment" }
+func (Attachment) SchemaVersion() int { return 1 }
+
+func (AuditEntry) DocType() string    { return "audit_entry" }
+func (AuditEntry) SchemaVersion() int { return 1 }
+
+func (BirthCertificate) DocType() string    { return "birth_certificate" }
+func (BirthCertificate) SchemaVersion() int { return 1 }
+
+func (CatalogEntry) DocType() string    { return "catalog_entry" }
+func (CatalogEntry) SchemaVersion() int { return 1 }
+
+func (CertificateRevision) DocType() string    { return "certificate_revision" }
+func (CertificateRevision) SchemaVersion() int { return 1 }
+
+func (Trip) DocType() string    { return "trip" }
+func (Trip) SchemaVersion() int { return 1 }
+
+func (Deal) DocType() string    { return "deal" }
+func (Deal) SchemaVersion() int { return 1 }
+
+func (Inheritance) DocType() string    { return "inheritance" }
+func (Inheritance) SchemaVersion() int { return 1 }
+
+func (Mandate) DocType() string    { return "mandate" }
+func (Mandate) SchemaVersion() int { return 1 }
+
+func (ModificationRequest) DocType() string    { return "modification_request" }
+func (ModificationRequest) SchemaVersion() int { return 1 }
+
+func (OrganizationCertificate) DocType() string    { return "organization_certificate" }
+func (OrganizationCertificate) SchemaVersion() int { return 1 }
+
+func (Oracle) DocType() string    { return "oracle" }
+func (Oracle) SchemaVersion() int { return 1 }
+
+func (MileageAttestation) DocType() string    { return "mileage_attestation" }
+func (MileageAttestation) SchemaVersion() int { return 1 }
+
+func (Part) DocType() string    { return "part" }
+func (Part) SchemaVersion() int { return 1 }
+
+func (PartReplacement) DocType() string    { return "part_replacement" }
+func (PartReplacement) SchemaVersion() int { return 1 }
+
+func (PlateReservation) DocType() string    { return "plate_reservation" }
+func (PlateReservation) SchemaVersion() int { return 1 }
+
+func (ModelPriceStats) DocType() string    { return "model_price_stats" }
+func (ModelPriceStats) SchemaVersion() int { return 1 }
+
+func (PriceRecord) DocType() string    { return "price_record" }
+func (PriceRecord) SchemaVersion() int { return 1 }
+
+func (RegistrationProposal) DocType() string    { return "registration_proposal" }
+func (RegistrationProposal) SchemaVersion() int { return 1 }
+
+func (QuoteRequest) DocType() string    { return "quote_request" }
+func (QuoteRequest) SchemaVersion() int { return 1 }
+
+func (Quote) DocType() string    { return "quote" }
+func (Quote) SchemaVersion() int { return 1 }
+
+func (InsuranceProduct) DocType() string    { return "insurance_product" }
+func (InsuranceProduct) SchemaVersion() int { return 1 }
+
+func (GarageCertification) DocType() string    { return "garage_certification" }
+func (GarageCertification) SchemaVersion() int { return 1 }
+
+func (Accreditation) DocType() string    { return "accreditation" }
+func (Accreditation) SchemaVersion() int { return 1 }
+
+func (ReputationEntry) DocType() string    { return "reputation_entry" }
+func (ReputationEntry) SchemaVersion() int { return 1 }
+
+func (Dispute) DocType() string    { return "dispute" }
+func (Dispute) SchemaVersion() int { return 1 }
+
+func (TestDrive) DocType() string    { return "test_drive" }
+func (TestDrive) SchemaVersion() int { return 1 }
+
+func (QueueSkip) DocType() string    { return "queue_skip" }
+func (QueueSkip) SchemaVersion() int { return 1 }
+
+func (Loan) DocType() string    { return "loan" }
+func (Loan) SchemaVersion() int { return 1 }
+
+func (SubsidyProgram) DocType() string    { return "subsidy_program" }
+func (SubsidyProgram) SchemaVersion() int { return 1 }
+
+func (SubsidyGrant) DocType() string    { return "subsidy_grant" }
+func (SubsidyGrant) SchemaVersion() int { return 1 }
+
+func (TaxAssessment) DocType() string    { return "tax_assessment" }
+func (TaxAssessment) SchemaVersion() int { return 1 }
+
+func (TollPosting) DocType() string    { return "toll_posting" }
+func (TollPosting) SchemaVersion() int { return 1 }
+
+func (Fine) DocType() string    { return "fine" }
+func (Fine) SchemaVersion() int { return 1 }
+
+func (ReportToken) DocType() string    { return "report_token" }
+func (ReportToken) SchemaVersion() int { return 1 }
+
+func (JournalEntry) DocType() string    { return "journal_entry" }
+func (JournalEntry) SchemaVersion() int { return 1 }
+
+func (TransitPermit) DocType() string    { return "transit_permit" }
+func (TransitPermit) SchemaVersion() int { return 1 }
+
+func (SaleReview) DocType() string    { return "sale_review" }
+func (SaleReview) SchemaVersion() int { return 1 }
+
+func (ReferenceValue) DocType() string    { return "reference_value" }
+func (ReferenceValue) SchemaVersion() int { return 1 }
+
+func (VinConflict) DocType() string    { return "vin_conflict" }
+func (VinConflict) SchemaVersion() int { return 1 }
+
+func (QuarantinedCar) DocType() string    { return "quarantined_car" }
+func (QuarantinedCar) SchemaVersion() int { return 1 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestStoredDocumentStamp(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	stamp := struct {
+		DocType       string `json:"docType"`
+		SchemaVersion int    `json:"schemaVersion"`
+	}{}
+	json.Unmarshal(stub.State[vin], &stamp)
+	if stamp.DocType != "car" || stamp.SchemaVersion != (Car{}).SchemaVersion() {
+		t.Errorf("Expected a stamped car document, got %s", stub.State[vin])
+	}
+
+	// cars written before the stamp are read as they are
+	stub.MockTransactionStart(uuid)
+	stub.PutState(vin, []byte(`{ "vin": "`+vin+`", "certificate": { "username": "`+owner+`" }, "created_ts": 1 }`))
+	stub.MockTransactionEnd(uuid)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "garage", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if response.Status != shim.OK || car.Vin != vin {
+		t.Errorf("Expected the legacy car to be readable, got %s", response.Message)
+	}
+}
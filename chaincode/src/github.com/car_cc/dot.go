@@ -1,10 +1,10 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
+	"sort"
 
+	"github.com/car_cc/ledgerjson"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -14,14 +14,15 @@ import (
  *
  * The numberplate is handed out by the DOT.
  */
-func IsConfirmed(car *Car) bool {
+func IsConfirmed(car *Car, now int64) bool {
 	// cannot have a numberplate without car papers
 	if !IsRegistered(car) {
 		return false
 	}
 
-	// cannot give you a numberplate without insurance contract
-	if !IsInsured(car) {
+	// cannot give you a numberplate without insurance contract,
+	// unless the jurisdiction of the numberplate does not ask for one
+	if !IsInsured(car, now) && !car.InsuranceExempt {
 		return false
 	}
 
@@ -65,49 +66,6 @@ func IsRegistered(car *Car) bool {
 	return registered
 }
 
-/*
- * Returns the registration proposal index with all
- * registration proposals.
- */
-func (t *CarChaincode) getRegistrationProposals(stub shim.ChaincodeStubInterface) (map[string]RegistrationProposal, error) {
-	response := t.read(stub, registrationProposalIndexStr)
-	proposalIndex := make(map[string]RegistrationProposal)
-	err := json.Unmarshal(response.Payload, &proposalIndex)
-	if err != nil {
-		return nil, errors.New("Error parsing registration proposal index")
-	}
-
-	return proposalIndex, nil
-}
-
-/*
- * Reads all registration proposals.
- */
-func (t *CarChaincode) readRegistrationProposals(stub shim.ChaincodeStubInterface) pb.Response {
-	proposalIndex, err := t.getRegistrationProposals(stub)
-	if err != nil {
-		return shim.Error("Error reading registration proposal index")
-	}
-
-	indexAsBytes, _ := json.Marshal(proposalIndex)
-	return shim.Success(indexAsBytes)
-}
-
-/*
- * Returns a registration proposal for a car.
- */
-func (t *CarChaincode) getRegistrationProposal(stub shim.ChaincodeStubInterface, car string) pb.Response {
-	// load all proposals
-	proposalIndex, err := t.getRegistrationProposals(stub)
-	if err != nil {
-		return shim.Error("Error reading registration proposal index")
-	}
-
-	ret := proposalIndex[car]
-	retAsBytes, _ := json.Marshal(ret)
-	return shim.Success(retAsBytes)
-}
-
 /*
  * Registers a car.
  *
@@ -115,56 +73,70 @@ func (t *CarChaincode) getRegistrationProposal(stub shim.ChaincodeStubInterface,
  * and a car VIN are equal and that a certificate
  * was issued by the DOT at least once.
  *
- * To register a car, a RegistrationProposal needs to be present.
- * This proposal is removed/deleted after successfull registration.
- * This is not consistent with reality, but serves the purpose
- * for now, because the Form 13.20 A (RegistrationProposal)
- * is not used anywhere else right now. Like this, the RegistrationProposal
- * only serves the purpose to signal the DOT, that there is a new
- * car waiting for registration.
+ * To register a car, a pending RegistrationProposal needs to be
+ * present. The proposal is kept as approved after successfull
+ * registration, see 'approveProposal'.
  *
  * On success,
  * returns the car with certificate.
  */
 func (t *CarChaincode) register(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	return t.registerCar(stub, username, vin, "dot")
+}
+
+/*
+ * Registers the car of 'username' and approves its
+ * registration proposal on behalf of 'reviewer'.
+ */
+func (t *CarChaincode) registerCar(stub shim.ChaincodeStubInterface, username string, vin string, reviewer string) pb.Response {
 	// reading the car already checks that the user
 	// is the actual owner of the car
 	car, err := t.getCar(stub, username, vin)
 	if vin != car.Vin {
-		return shim.Error(fmt.Sprintf("Cannot register, invalid VIN.\nCar VIN is '%s' and you want to register VIN '%s'", car.Vin, vin))
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Cannot register, invalid VIN.\nCar VIN is '%s' and you want to register VIN '%s'", car.Vin, vin))
 	}
 
-	// get all registration proposals
-	proposals, err := t.getRegistrationProposals(stub)
-	if err != nil {
-		return shim.Error("Error reading registration proposal index")
+	// check if there exists a pending registration proposal for that car
+	proposal, err := t.getProposal(stub, vin)
+	if err != nil || proposal.Status != proposalPending {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no registration proposal for car with VIN: %s", vin))
 	}
 
-	// check if there exists a registration proposal for that car
-	if proposals[car.Vin].Car != vin {
-		return shim.Error(fmt.Sprintf("There exists no registration proposal for car with VIN: %s", vin))
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsProposalExpired(&proposal, now) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Registration proposal for car with VIN '%s' expired", vin))
 	}
 
 	// create a certificate, approve vin
 	// and update the car in the ledger
+	// keep the car description and count
+	// revisions of earlier registrations
 	cert := Certificate{Username: username,
-		Vin: vin}
+		Vin:     vin,
+		Color:   car.Certificate.Color,
+		Type:    car.Certificate.Type,
+		Brand:   car.Certificate.Brand,
+		Model:   car.Certificate.Model,
+		Version: car.Certificate.Version,
+
+		RegisteredTs: now}
 	car.Certificate = cert
-	carAsBytes, _ := json.Marshal(car)
+
+	// the car made it to the inspection
+	consumePermit(&car)
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
 	err = stub.PutState(car.Vin, carAsBytes)
 	if err != nil {
-		return shim.Error("Error writing car")
+		return errorResponse(ErrLedger, "Error writing car")
 	}
 
-	// remove the proposal we just registered
-	delete(proposals, car.Vin)
-
-	// save the new proposal index
-	// without the car we just registered
-	proposalsAsBytes, _ := json.Marshal(proposals)
-	err = stub.PutState(registrationProposalIndexStr, proposalsAsBytes)
+	// close the proposal we just registered
+	err = t.reviewProposal(stub, &proposal, proposalApproved, reviewer, "")
 	if err != nil {
-		return shim.Error("Error writing proposal index")
+		return errorResponseFrom(err)
 	}
 
 	fmt.Printf("Successfully registered car created at ts '%d' with VIN '%s'\n", car.CreatedTs, vin)
@@ -192,49 +164,107 @@ func (t *CarChaincode) confirm(stub shim.ChaincodeStubInterface, username string
 	numberplate := args[1]
 
 	if vin == "" {
-		return shim.Error("'confirm' expects a non-empty VIN to assign a numberplate")
+		return errorResponse(ErrInvalidArgument, "'confirm' expects a non-empty VIN to assign a numberplate")
 	}
 
 	// check numberplate argument
 	if numberplate == "" {
-		return shim.Error("Car numberplate is empty. Please provide a numberplate to confirm your car")
+		return errorResponse(ErrInvalidArgument, "Car numberplate is empty. Please provide a numberplate to confirm your car")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = validatePlate(config, numberplate)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
 	// fetch the car from the ledger
 	// this already checks for ownership
 	car, err := t.getCar(stub, username, vin)
 	if err != nil {
-		return shim.Error("Failed to fetch car with vin '" + vin + "' from ledger")
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// check the registration rules of the jurisdiction,
+	// by default insurance and emission tests of older cars
+	rules := registrationRules(config, numberplate)
+	err = evaluateRules(rules, &car, now)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
-	// check if car is insured
-	if !IsInsured(&car) {
-		return shim.Error("Car is not insured. Please insure car first before trying to confirm it")
+	// cars moving to another channel cannot be confirmed
+	if !IsActive(&car) {
+		return errorResponse(ErrNotActive, "Car is handed off to another channel and cannot be confirmed")
+	}
+
+	// nor can cars under VIN investigation
+	if IsFrozen(&car) {
+		return errorResponse(ErrInvalidState, "Car is frozen and cannot be confirmed")
+	}
+
+	// so are modified cars waiting for an inspection
+	if car.NeedsInspection {
+		return errorResponse(ErrInvalidState, "Car has an unapproved structural modification and needs an inspection to be confirmed")
+	}
+
+	// written off cars need an approved rebuild first
+	if IsWrittenOff(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is classified as '%s' and cannot be confirmed for road use", car.Classification))
 	}
 
 	// check if numberplate is already in use
-	carIndex, err := t.getCarIndex(stub)
-	carToCheck := Car{}
-	for carVin, user := range carIndex {
-		// get the full car object with certificate
-		carToCheck, err = t.getCar(stub, user, carVin)
+	// or reserved by somebody else
+	reservation, err := t.checkPlateAvailable(stub, username, numberplate)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// assign the numberplate to the car
+	car.Certificate.Numberplate = numberplate
+	car.InsuranceExempt = !requiresInsurance(rules)
+
+	// confirmed on a cover note, the car is revoked
+	// if there is no full policy when the note ends
+	car.RevocationDueTs = 0
+	if !car.InsuranceExempt && !IsInsuredByPolicy(&car, now) {
+		car.RevocationDueTs = car.CoverNote.ExpiresTs
+	}
+
+	// the owner used their reservation
+	if reservation != nil {
+		err = t.releasePlate(stub, numberplate)
 		if err != nil {
-			return shim.Error("Failed to fetch car with vin '" + carVin + "' from ledger")
+			return errorResponseFrom(err)
 		}
+	}
 
-		if carToCheck.Certificate.Numberplate == numberplate {
-			return shim.Error("Car numberplate already in use. Please use another one!")
-		}
+	// the owner pays the registration fee
+	err = t.chargeFee(stub, username, registrationFeeName, scheduledFee(config, registrationFeeName, 0, 0))
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
-	// assign the numberplate to the car
-	car.Certificate.Numberplate = numberplate
+	// every confirmation issues a new certificate revision
+	err = t.issueCertificate(stub, &car)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
 	// write udpated car back to ledger
-	carAsBytes, _ := json.Marshal(car)
+	carAsBytes, _ := ledgerjson.Marshal(car)
 	err = stub.PutState(vin, carAsBytes)
 	if err != nil {
-		return shim.Error("Error writing car")
+		return errorResponse(ErrLedger, "Error writing car")
 	}
 
 	// car confirmation successfull,
@@ -255,55 +285,66 @@ func (t *CarChaincode) confirm(stub shim.ChaincodeStubInterface, username string
  */
 func (t *CarChaincode) revoke(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
 	if vin == "" {
-		return shim.Error("'revoke' expects a non-empty VIN to do the revocation")
+		return errorResponse(ErrInvalidArgument, "'revoke' expects a non-empty VIN to do the revocation")
 	}
 
 	// fetch the car from the ledger
 	// this already checks for ownership
 	car, err := t.getCar(stub, username, vin)
 	if err != nil {
-		return shim.Error("Failed to fetch car with vin '" + vin + "' from ledger")
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
 	// remove car insurance
+	err = updateInsuredIndex(stub, vin, car.Certificate.Insurer, "")
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
 	car.Certificate.Insurer = ""
+	car.Policy = InsurancePolicy{}
+	car.CoverNote = nil
+	car.RevocationDueTs = 0
 
 	// check if car is not anymore insured
-	if IsInsured(&car) {
-		return shim.Error("Whoops... Something went wrong while revoking car. Car is still insured.")
+	if IsInsured(&car, now) {
+		return errorResponse(ErrInvalidState, "Whoops... Something went wrong while revoking car. Car is still insured.")
 	}
 
 	// remove numberplate
 	car.Certificate.Numberplate = ""
+	car.InsuranceExempt = false
 
 	// check if not confirmed anymore
-	if IsConfirmed(&car) {
-		return shim.Error("Whoops... Something went wrong while revoking car. Car is still confirmed.")
+	if IsConfirmed(&car, now) {
+		return errorResponse(ErrInvalidState, "Whoops... Something went wrong while revoking car. Car is still confirmed.")
 	}
 
 	// write udpated car back to ledger
-	carAsBytes, _ := json.Marshal(car)
+	carAsBytes, _ := ledgerjson.Marshal(car)
 	err = stub.PutState(vin, carAsBytes)
 	if err != nil {
-		return shim.Error("Error writing car")
+		return errorResponse(ErrLedger, "Error writing car")
 	}
 
 	// fetch all revocation proposals
-	response := t.getRevocationProposals(stub)
-	index := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &index)
+	index, err := getRevocationProposalIndex(stub)
 	if err != nil {
-		return shim.Error("Failed to fetch revocation proposals")
+		return errorResponseFrom(err)
 	}
 
 	// remove the revocation proposal if any
 	delete(index, car.Vin)
 
 	// save proposals back to ledger
-	indexAsBytes, _ := json.Marshal(index)
-	err = stub.PutState(revocationProposalIndexStr, indexAsBytes)
+	err = saveRevocationProposalIndex(stub, index)
 	if err != nil {
-		return shim.Error("Error writing revocation proposals")
+		return errorResponseFrom(err)
 	}
 
 	// car revokation successfull,
@@ -312,17 +353,58 @@ func (t *CarChaincode) revoke(stub shim.ChaincodeStubInterface, username string,
 }
 
 /*
- * Returns all revocation proposals.
+ * Reads the revocation proposal index, VIN to proposal
+ */
+func getRevocationProposalIndex(stub shim.ChaincodeStubInterface) (map[string]RevocationProposal, error) {
+	indexAsBytes, err := stub.GetState(revocationProposalIndexStr)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading revocation proposal index")
+	}
+
+	index := make(map[string]RevocationProposal)
+	if indexAsBytes == nil {
+		return index, nil
+	}
+
+	err = ledgerjson.Unmarshal(indexAsBytes, &index)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing revocation proposal index")
+	}
+
+	return index, nil
+}
+
+/*
+ * Writes the revocation proposal index to ledger
+ */
+func saveRevocationProposalIndex(stub shim.ChaincodeStubInterface, index map[string]RevocationProposal) error {
+	indexAsBytes, _ := ledgerjson.Marshal(index)
+	err := stub.PutState(revocationProposalIndexStr, indexAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing revocation proposal index")
+	}
+
+	return nil
+}
+
+/*
+ * Returns all revocation proposals, oldest first,
+ * ties broken by VIN, see 'revocationProposalsByCreatedTs'.
  */
 func (t *CarChaincode) getRevocationProposals(stub shim.ChaincodeStubInterface) pb.Response {
-	response := t.read(stub, revocationProposalIndexStr)
-	index := make(map[string]string)
-	err := json.Unmarshal(response.Payload, &index)
+	index, err := getRevocationProposalIndex(stub)
 	if err != nil {
-		return shim.Error("Error reading revocation proposal index")
+		return errorResponseFrom(err)
 	}
 
-	return shim.Success(response.Payload)
+	proposals := make([]RevocationProposal, 0, len(index))
+	for _, proposal := range index {
+		proposals = append(proposals, proposal)
+	}
+	sort.Sort(revocationProposalsByCreatedTs(proposals))
+
+	proposalsAsBytes, _ := ledgerjson.Marshal(proposals)
+	return shim.Success(proposalsAsBytes)
 }
 
 /*
@@ -338,60 +420,101 @@ func (t *CarChaincode) getRevocationProposals(stub shim.ChaincodeStubInterface)
  */
 func (t *CarChaincode) revocationProposal(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
 	if vin == "" {
-		return shim.Error("'revocationProposal' expects a non-empty VIN to do the revocation")
+		return errorResponse(ErrInvalidArgument, "'revocationProposal' expects a non-empty VIN to do the revocation")
 	}
 
 	// fetch the car from the ledger
 	// this already checks for ownership
 	car, err := t.getCar(stub, username, vin)
 	if err != nil {
-		return shim.Error("Failed to fetch car with vin '" + vin + "' from ledger")
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
 	// check if the car can be revoked
-	if !IsConfirmed(&car) {
-		return shim.Error("You cannot create a revocation proposal for an unconfirmed car.")
+	if !IsConfirmed(&car, now) {
+		return errorResponse(ErrNotConfirmed, "You cannot create a revocation proposal for an unconfirmed car.")
 	}
 
 	// fetch all the revocation proposals
-	response := t.read(stub, revocationProposalIndexStr)
-	index := make(map[string]string)
-	err = json.Unmarshal(response.Payload, &index)
+	index, err := getRevocationProposalIndex(stub)
 	if err != nil {
-		return shim.Error("Error parsing revocation proposal index")
+		return errorResponseFrom(err)
 	}
 
 	// check if a proposal to revoke this car already exists
-	if index[vin] == username {
-		return shim.Error("A revocation proposal for that car VIN and user already exists.")
+	if index[vin].User == username {
+		return errorResponse(ErrAlreadyExists, "A revocation proposal for that car VIN and user already exists.")
 	}
 
 	// save the users request to revok his car
 	// in the revocation proposal index
-	index[vin] = username
+	index[vin] = RevocationProposal{Car: vin, User: username, CreatedTs: now}
 
 	// save index back to ledger
-	indexAsBytes, _ := json.Marshal(index)
-	err = stub.PutState(revocationProposalIndexStr, indexAsBytes)
+	err = saveRevocationProposalIndex(stub, index)
 	if err != nil {
-		return shim.Error("Error writing revocation proposal index")
+		return errorResponseFrom(err)
 	}
 
 	return shim.Success(nil)
 }
 
 /*
- * Deletes a car from the ledger.
+ * Scraps a car.
+ *
+ * The car is archived rather than deleted, see
+ * 'archiveCar'. Index entries without a car are
+ * simply removed.
+ *
+ * The VIN is deliberately not validated, so the DOT
+ * can clean up cars created before VIN validation.
  *
  * Returns 'nil' on success.
  */
 func (t *CarChaincode) delete(stub shim.ChaincodeStubInterface, vin string) pb.Response {
-	// Delete the key from the state in ledger
-	err := stub.DelState(vin)
+	pseudonym, err := getIndexedOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, err := stub.GetState(vin)
 	if err != nil {
-		return shim.Error("Failed to delete car state")
+		return errorResponse(ErrLedger, "Failed to read car state")
+	}
+
+	car := Car{}
+	if carAsBytes != nil {
+		err = ledgerjson.Unmarshal(carAsBytes, &car)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing car")
+		}
+		// the car is archived under the key it was stored at
+		car.Vin = vin
+	}
+
+	if carAsBytes == nil || IsArchived(&car) {
+		// nothing to archive, only fix the car index
+		err = unindexCar(stub, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	} else {
+		owner, err := resolveOwner(stub, pseudonym)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		err = t.archiveCar(stub, owner, car, archiveScrapped)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
 	}
 
-	fmt.Printf("Successfully deleted car with VIN: '%s'\n", vin)
+	fmt.Printf("Successfully scrapped car with VIN: '%s'\n", vin)
 	return shim.Success(nil)
 }
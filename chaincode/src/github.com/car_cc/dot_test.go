@@ -2,8 +2,13 @@ package main
 
 import (
     "fmt"
+    "crypto/ed25519"
+    "encoding/base64"
+    "encoding/hex"
     "encoding/json"
+    "strings"
     "testing"
+    "time"
 
     "github.com/hyperledger/fabric/core/chaincode/shim"
     "github.com/hyperledger/fabric/common/util"
@@ -13,7 +18,7 @@ func TestIsConfirmed(t *testing.T) {
     // create a new car without numberplate
     car := &Car{}
 
-    if (IsConfirmed(car)) {
+    if (IsConfirmed(car, time.Now().Unix())) {
         t.Error("Car should not be confirmed initially")
     }
 }
@@ -29,7 +34,7 @@ func TestIsRegistered(t *testing.T) {
 
 func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
     var username string = "amag"
-    var vin string      = "WVW ZZZ 6RZ HY26 0780"
+    var vin string      = "WVWZZZ6R6HY260780"
     var carData string  = `{ "vin": "` + vin + `" }`
 
     // create and name a new chaincode mock
@@ -39,6 +44,7 @@ func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
     ccSetup(t, stub)
 
     // create a new car
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
     response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
     // payload should contain the car...
@@ -57,7 +63,7 @@ func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
 
     // read all registration proposals as DOT user
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readRegistrationProposals", "TESTING", "dot"))
-    proposals := make(map[string]RegistrationProposal)
+    proposals := []RegistrationProposal{}
     err = json.Unmarshal(response.Payload, &proposals)
     if err != nil {
         t.Error("Error reading proposal index")
@@ -69,7 +75,9 @@ func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
     }
 
     // check if the registration proposal got saved for the right car
-    fmt.Printf("The registration proposal: %v\n", proposals[car.Vin])
+    if len(proposals) == 1 && proposals[0].Car != car.Vin {
+        t.Errorf("The registration proposal was saved for car '%s'", proposals[0].Car)
+    }
 
     // registering a car as garage user should be forbidden
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("register", username, "garage", vin))
@@ -101,7 +109,7 @@ func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
     // that the just registered car is removed
     // from the list of open registration proposals
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readRegistrationProposals", "TESTING", "dot"))
-    proposals = make(map[string]RegistrationProposal)
+    proposals = []RegistrationProposal{}
     err = json.Unmarshal(response.Payload, &proposals)
     if err != nil {
         t.Error("Error reading proposal index")
@@ -124,7 +132,7 @@ func TestReadRegistrationProposalsAndRegisterCar(t *testing.T) {
 
 func TestRevocationIndex(t *testing.T) {
     var username string         = "amag"
-    var vin string              = "WVW ZZZ 6RZ HY26 0780"
+    var vin string              = "WVWZZZ6R6HY260780"
     var carData string          = `{ "vin": "` + vin + `" }`
     var numberplate string      = "ZH 7878"
     var insuranceCompany string = "axa"
@@ -134,8 +142,10 @@ func TestRevocationIndex(t *testing.T) {
     stub := shim.NewMockStub("car", carChaincode)
 
     ccSetup(t, stub)
+    accredit(stub, insuranceCompany)
 
     // create a new car
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
     response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
     // payload should contain the car...
@@ -176,16 +186,16 @@ func TestRevocationIndex(t *testing.T) {
         t.Error("Error assigning numberplate")
     }
 
-    if !IsConfirmed(&car) {
+    if !IsConfirmed(&car, time.Now().Unix()) {
         t.Error("Car should be confirmed by now")
     }
 
     // checkout revocation proposals, should have none
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", username, "dot"))
-    index := make(map[string]string)
-    err = json.Unmarshal(response.Payload, &index)
+    revocations := []RevocationProposal{}
+    err = json.Unmarshal(response.Payload, &revocations)
 
-    if len(index) != 0 {
+    if len(revocations) != 0 {
         t.Error("There should not be any revocation proposals")
     }
 
@@ -197,21 +207,21 @@ func TestRevocationIndex(t *testing.T) {
 
     // read proposals again
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", username, "dot"))
-    err = json.Unmarshal(response.Payload, &index)
+    err = json.Unmarshal(response.Payload, &revocations)
     if err != nil {
         t.Error("Error reading revocation proposals")
     }
 
-    if len(index) != 1 {
-        t.Error("There should be a revocation proposal now")
+    if len(revocations) != 1 {
+        t.Fatal("There should be a revocation proposal now")
     }
 
-    if index[car.Vin] != username {
+    if revocations[0].Car != car.Vin || revocations[0].User != username || revocations[0].CreatedTs == 0 {
         t.Error("The revocation proposal was intended for another car/username")
     }
 
     fmt.Println("Current revocation proposals:")
-    fmt.Println(index)
+    fmt.Println(revocations)
 
     // revoke numberplate
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revoke", username, "dot", vin))
@@ -220,26 +230,26 @@ func TestRevocationIndex(t *testing.T) {
         t.Error("Error revoking numberplate")
     }
 
-    if IsConfirmed(&car) {
+    if IsConfirmed(&car, time.Now().Unix()) {
         t.Error("Car should be revoked by now")
     }
 
     // read proposals again
     response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", username, "dot"))
-    index = make(map[string]string)
-    err = json.Unmarshal(response.Payload, &index)
+    revocations = []RevocationProposal{}
+    err = json.Unmarshal(response.Payload, &revocations)
 
-    fmt.Println("Revocation proposal index after revocation:")
-    fmt.Println(index)
+    fmt.Println("Revocation proposals after revocation:")
+    fmt.Println(revocations)
 
-    if len(index) != 0 {
+    if len(revocations) != 0 {
         t.Error("The revocation proposal should get deleted after revocation")
     }
 }
 
 func TestConfirmRevokeAndDelete(t *testing.T) {
     var username string         = "amag"
-    var vin string              = "WVW ZZZ 6RZ HY26 0780"
+    var vin string              = "WVWZZZ6R6HY260780"
     var carData string          = `{ "vin": "` + vin + `" }`
     var numberplate string      = "ZH 7878"
     var insuranceCompany string = "axa"
@@ -249,8 +259,10 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
     stub := shim.NewMockStub("car", carChaincode)
 
     ccSetup(t, stub)
+    accredit(stub, insuranceCompany)
 
     // create a new car
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
     response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
     // payload should contain the car...
@@ -261,7 +273,7 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
     }
 
     // ...but not yet confirmed
-    if IsConfirmed(&car) {
+    if IsConfirmed(&car, time.Now().Unix()) {
         t.Error("Car should not be confirmed yet!")
     }
 
@@ -316,7 +328,7 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
 
     fmt.Println(car.Certificate.Insurer)
 
-    if !IsInsured(&car) {
+    if !IsInsured(&car, time.Now().Unix()) {
         t.Error("Error insuring car")
     }
 
@@ -328,7 +340,7 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
         t.Error("Error assigning numberplate")
     }
 
-    if !IsConfirmed(&car) {
+    if !IsConfirmed(&car, time.Now().Unix()) {
         t.Error("Car should be confirmed by now")
     }
 
@@ -345,7 +357,7 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
         t.Error("Error revoking numberplate")
     }
 
-    if IsConfirmed(&car) {
+    if IsConfirmed(&car, time.Now().Unix()) {
         t.Error("Car should be revoked by now")
     } else if car.Certificate.Insurer != "" {
         t.Error("Revocation includes cancelation of the insurance contract")
@@ -368,4 +380,171 @@ func TestConfirmRevokeAndDelete(t *testing.T) {
     if err == nil {
         t.Error("Failed to delete car")
     }
-}
\ No newline at end of file
+}
+func TestIssuePermitAndPoliceLookup(t *testing.T) {
+    var username string = "amag"
+    var vin string      = "WVWZZZ6R6HY260780"
+    var carData string  = `{ "vin": "` + vin + `" }`
+    var today string    = time.Now().UTC().Format(permitDateLayout)
+
+    // create and name a new chaincode mock
+    carChaincode := &CarChaincode{}
+    stub := shim.NewMockStub("car", carChaincode)
+
+    ccSetup(t, stub)
+
+    // create a new car
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+
+    // garages without an inspection certification cannot issue permits
+    response := stub.MockInvoke(uuid, util.ToChaincodeArgs("issuePermit", username, "garage", vin, today, "AMAG -> DOT"))
+    expectErrorCode(t, response, ErrForbidden)
+
+    certify(stub, username, scopeInspection)
+
+    // permits for a day in the past are not allowed
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issuePermit", username, "garage", vin, "2001-01-01", "AMAG -> DOT"))
+    if response.Status == shim.OK {
+        t.Error("Trip permits cannot be issued for past days")
+    }
+
+    // normal users cannot issue permits
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issuePermit", username, "user", vin, today, "AMAG -> DOT"))
+    if response.Status == shim.OK {
+        t.Error("Users should not be allowed to issue trip permits")
+    }
+
+    // the garage issues a permit for today
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issuePermit", username, "garage", vin, today, "AMAG -> DOT"))
+    if response.Status != shim.OK {
+        t.Error(response.Message)
+    }
+
+    // the police sees the permit
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "TESTING", "police", vin))
+    lookup := PoliceLookup {}
+    err := json.Unmarshal(response.Payload, &lookup)
+    if err != nil {
+        t.Error(response.Message)
+    }
+
+    if !lookup.PermitValid || lookup.Permit.Route != "AMAG -> DOT" {
+        t.Error("Police should see a valid trip permit")
+    }
+
+    // the DOT registers the car, which consumes the permit
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("register", username, "dot", vin))
+
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "TESTING", "police", vin))
+    err = json.Unmarshal(response.Payload, &lookup)
+    if err != nil {
+        t.Error(response.Message)
+    }
+
+    if lookup.PermitValid || !lookup.Permit.Consumed {
+        t.Error("Trip permit should be consumed after the inspection")
+    }
+
+    if !lookup.Registered {
+        t.Error("Car should be registered by now")
+    }
+}
+
+func TestGenerateAndVerifySticker(t *testing.T) {
+    var username string = "amag"
+    var vin string      = "WVWZZZ6R6HY260780"
+
+    // the DOT signing key
+    seed := make([]byte, ed25519.SeedSize)
+    copy(seed, []byte("dot signing key for testing only"))
+    privateKey := ed25519.NewKeyFromSeed(seed)
+    publicKey := hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+
+    // create and name a new chaincode mock
+    carChaincode := &CarChaincode{}
+    stub := shim.NewMockStub("car", carChaincode)
+
+    ccSetup(t, stub)
+
+    insureCar(t, stub, username, vin, "axa")
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 7878"))
+
+    // no stickers without configured key
+    stub.TransientMap = map[string][]byte{stickerKeyTransient: seed}
+    response := stub.MockInvoke(uuid, util.ToChaincodeArgs("generateSticker", "TESTING", "dot", vin))
+    if response.Status == shim.OK {
+        t.Error("Stickers need a configured DOT key")
+    }
+
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setStickerKey", "TESTING", "dot", publicKey))
+    if response.Status != shim.OK {
+        t.Fatal(response.Message)
+    }
+
+    // generate the sticker
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("generateSticker", "TESTING", "dot", vin))
+    if response.Status != shim.OK {
+        t.Fatal(response.Message)
+    }
+
+    payload := string(response.Payload)
+    fmt.Printf("Sticker payload: %s\n", payload)
+
+    // anyone can verify the sticker
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifySticker", "TESTING", "police", payload))
+    check := StickerCheck {}
+    err := json.Unmarshal(response.Payload, &check)
+    if err != nil {
+        t.Fatal(response.Message)
+    }
+
+    if !check.Valid || check.Expired {
+        t.Error("Sticker should be valid")
+    } else if check.Sticker.Numberplate != "ZH 7878" || check.Sticker.Flags & stickerConfirmed == 0 {
+        t.Error("Sticker should carry the numberplate and the confirmed flag")
+    }
+
+    // a forged sticker does not verify
+    forged := Sticker {Vin: vin, Numberplate: "ZH 1", ValidUntil: check.Sticker.ValidUntil, Flags: check.Sticker.Flags}
+    forgedAsBytes, _ := json.Marshal(forged)
+    forgedPayload := base64.RawURLEncoding.EncodeToString(forgedAsBytes) + payload[strings.Index(payload, "."):]
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifySticker", "TESTING", "police", forgedPayload))
+    json.Unmarshal(response.Payload, &check)
+    if check.Valid {
+        t.Error("Forged stickers should not verify")
+    }
+}
+
+func TestRevocationProposalOrder(t *testing.T) {
+    vins := []string{"WVWZZZ6RXHY260782", "WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+    stub := shim.NewMockStub("car", &CarChaincode{})
+    ccSetup(t, stub)
+
+    // the newest proposal has the lowest VIN,
+    // the two older ones were made together
+    stub.MockTransactionStart(uuid)
+    index := make(map[string]RevocationProposal)
+    for i, createdTs := range []int64{1500000300, 1500000100, 1500000100} {
+        index[vins[i]] = RevocationProposal{Car: vins[i], User: "amag", CreatedTs: createdTs}
+    }
+    saveRevocationProposalIndex(stub, index)
+    stub.MockTransactionEnd(uuid)
+
+    first := stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", "inspector", "dot"))
+    second := stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", "inspector", "dot"))
+    if string(first.Payload) != string(second.Payload) {
+        t.Error("Reading the revocation proposals twice should return the same result")
+    }
+
+    revocations := []RevocationProposal{}
+    err := json.Unmarshal(first.Payload, &revocations)
+    if err != nil {
+        t.Fatal(first.Message)
+    }
+
+    if len(revocations) != 3 || revocations[0].Car != vins[1] || revocations[1].Car != vins[2] || revocations[2].Car != vins[0] {
+        t.Errorf("Expected the revocation proposals oldest first, then by VIN, got %v", revocations)
+    }
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Emission tests.
+ *
+ * Certified stations, i.e. oracles of kind 'emission' or
+ * 'inspection', record emission test results. The low
+ * emission zone badge of a car follows from the emission
+ * class of a current test. Cars older than
+ * 'emissionTestAgeYears' need a current test to be confirmed.
+ */
+
+// oracle kinds allowed to record emission tests
+const oracleEmission string = "emission"
+const oracleInspection string = "inspection"
+
+// cars this old need a current emission test for confirmation
+const emissionTestAgeYears int64 = 4
+
+// low emission zone badges
+const badgeZero string = "zero"
+const badgeGreen string = "green"
+const badgeYellow string = "yellow"
+const badgeRed string = "red"
+const badgeNone string = "none"
+
+// badge by emission class
+var emissionBadges = map[string]string{
+	"electric": badgeZero,
+	"euro6":    badgeGreen,
+	"euro5":    badgeGreen,
+	"euro4":    badgeGreen,
+	"euro3":    badgeYellow,
+	"euro2":    badgeRed,
+	"euro1":    badgeNone,
+}
+
+/*
+ * Checks if a car has an emission test that is not expired
+ */
+func hasCurrentEmissionTest(car *Car, now int64) bool {
+	return car.Emission.TestedTs != 0 && car.Emission.ExpiresTs > now
+}
+
+/*
+ * Checks if a car is old enough to need an emission test.
+ * Historic cars do not need one.
+ */
+func needsEmissionTest(car *Car, now int64) bool {
+	return !IsHistoric(car) && now-car.CreatedTs >= emissionTestAgeYears*365*secondsPerDay
+}
+
+/*
+ * Returns the emission status of a car at 'now'
+ */
+func emissionStatus(car *Car, now int64) EmissionStatus {
+	status := EmissionStatus{Vin: car.Vin, Test: car.Emission, Badge: badgeNone}
+	status.Current = hasCurrentEmissionTest(car, now)
+	status.Required = needsEmissionTest(car, now)
+
+	if status.Current {
+		badge, ok := emissionBadges[car.Emission.Class]
+		if ok {
+			status.Badge = badge
+		}
+	}
+
+	return status
+}
+
+/*
+ * Records the emission test of a certified station.
+ *
+ * On success,
+ * returns the emission status.
+ */
+func (t *CarChaincode) recordEmissionTest(stub shim.ChaincodeStubInterface, vin string, test EmissionTest) pb.Response {
+	if test.Co2 < 0 {
+		return errorResponse(ErrInvalidArgument, "'recordEmissionTest' expects CO2 emissions of 0 g/km or more")
+	} else if _, ok := emissionBadges[test.Class]; !ok {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Unknown emission class '%s'", test.Class))
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	station, err := t.getOracle(stub, caller)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if station == nil || !station.Active || (station.Kind != oracleEmission && station.Kind != oracleInspection) {
+		return errorResponse(ErrForbidden, "Forbidden: the invoker is no certified emission test station")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if test.ExpiresTs <= now {
+		return errorResponse(ErrInvalidArgument, "'recordEmissionTest' expects an expiry in the future")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = updateExpiry(stub, expiryEmission, vin, car.Emission.ExpiresTs, test.ExpiresTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	test.Station = station.Name
+	test.TestedTs = now
+	car.Emission = test
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	statusAsBytes, _ := ledgerjson.Marshal(emissionStatus(&car, now))
+	return shim.Success(statusAsBytes)
+}
+
+/*
+ * Reads the emission status of a car.
+ * Open to every user, like 'lookupCar'.
+ *
+ * On success,
+ * returns the emission status.
+ */
+func (t *CarChaincode) getEmissionStatus(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	statusAsBytes, _ := ledgerjson.Marshal(emissionStatus(&car, now))
+	return shim.Success(statusAsBytes)
+}
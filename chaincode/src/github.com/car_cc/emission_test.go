@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestEmissionTest(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	station := "InspectMSP station Regensdorf"
+	stationHash := sha256.Sum256([]byte(station))
+	expiry := strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	car := insureCar(t, stub, owner, vin, "axa")
+
+	// make the car five years old
+	stub.MockTransactionStart(uuid)
+	car.CreatedTs -= 5 * 365 * secondsPerDay
+	carAsBytes, _ := json.Marshal(car)
+	stub.PutState(vin, carAsBytes)
+	stub.MockTransactionEnd(uuid)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "Regensdorf", hex.EncodeToString(stationHash[:]), "InspectMSP", "emission", "true"))
+
+	response = invokeAs(stub, "Org1MSP mallory", "recordEmissionTest", "mallory", "station", vin, "120", "euro5", expiry)
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = invokeAs(stub, station, "recordEmissionTest", "station", "station", vin, "120", "euro7", expiry)
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	invokeAs(stub, station, "recordEmissionTest", "station", "station", vin, "120", "euro5", expiry)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getEmissionStatus", "bobby", "user", vin))
+	status := EmissionStatus{}
+	err := json.Unmarshal(response.Payload, &status)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if !status.Current || !status.Required || status.Badge != badgeGreen || status.Test.Station != "Regensdorf" {
+		t.Errorf("Unexpected emission status: %v", status)
+	}
+
+	// with a current test, the old car can be confirmed
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	if response.Status != shim.OK {
+		t.Error("Tested car should be confirmable: " + response.Message)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Owner equity.
+ *
+ * 'getOwnerEquity' sums up the net position of an owner
+ * over their cars in one query: the value of each car is its
+ * latest appraisal, 0 without one, plus the payouts of its
+ * approved but unsettled claims, less the remaining
+ * principal of the loan it is pledged for and the fees
+ * outstanding on it, i.e. unpaid fines, toll debt and
+ * unpaid road tax. Banks financing one of the cars, the
+ * owner and the DOT can read it.
+ */
+
+/*
+ * Returns the equity of the owner in 'car' at 'now',
+ * 'claims' being the payouts the owner still waits for
+ */
+func carEquity(stub shim.ChaincodeStubInterface, car *Car, claims int, now int64) (CarEquity, error) {
+	equity := CarEquity{
+		Vin:    car.Vin,
+		Claims: claims,
+		Fines:  car.UnpaidFines,
+		Tolls:  car.Toll.Debt}
+
+	appraisal := latestAppraisal(car)
+	if appraisal != nil {
+		equity.Value = appraisal.Value
+		equity.AppraisedTs = appraisal.AppraisedTs
+	}
+
+	if car.Lien != nil {
+		loan, err := getLoan(stub, car.Lien.Loan)
+		if err != nil {
+			return CarEquity{}, err
+		} else if loan == nil {
+			return CarEquity{}, newError(ErrLedger, "Loan of the lien is missing")
+		}
+		equity.Lien = loanStatusAt(loan, now).Principal
+	}
+
+	assessments, err := getTaxAssessments(stub, car.Vin)
+	if err != nil {
+		return CarEquity{}, err
+	}
+	for _, assessment := range assessments {
+		if assessment.PaidTs == 0 {
+			equity.RoadTax += assessment.Amount
+		}
+	}
+
+	equity.Fees = equity.Fines + equity.Tolls + equity.RoadTax
+	equity.Equity = equity.Value + equity.Claims - equity.Lien - equity.Fees
+
+	return equity, nil
+}
+
+/*
+ * Returns the equity of 'owner' over all their cars.
+ *
+ * On success,
+ * returns the owner equity, cars sorted by VIN.
+ */
+func (t *CarChaincode) getOwnerEquity(stub shim.ChaincodeStubInterface, username string, role string, owner string) pb.Response {
+	user, err := t.getUser(stub, owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// payouts the owner is still waiting for, per car
+	claims, err := t.findClaims(stub, func(claim *Claim) bool {
+		return claim.User == owner && claim.Status == claimApproved
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	payouts := make(map[string]int)
+	for _, claim := range claims {
+		payouts[claim.Car] += claim.Amount
+	}
+
+	vins, err := getUserCars(stub, owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// banks see the equity of the owners they finance
+	financed := false
+
+	equity := OwnerEquity{Owner: owner, Balance: user.Balance, Cars: []CarEquity{}}
+	for _, vin := range vins {
+		car, err := t.getCar(stub, owner, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		if car.Lien != nil && car.Lien.Bank == username {
+			financed = true
+		}
+
+		item, err := carEquity(stub, &car, payouts[vin], now)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		equity.Cars = append(equity.Cars, item)
+		equity.Value += item.Value
+		equity.Claims += item.Claims
+		equity.Liens += item.Lien
+		equity.Fees += item.Fees
+		equity.Equity += item.Equity
+	}
+
+	if role != "dot" && username != owner && !(role == "bank" && financed) {
+		return errorResponse(ErrForbidden, "Forbidden: only the owner, their banks and the DOT can read the equity")
+	}
+
+	equityAsBytes, _ := ledgerjson.Marshal(equity)
+	return shim.Success(equityAsBytes)
+}
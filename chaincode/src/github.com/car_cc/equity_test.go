@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestOwnerEquity(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	bank := "ubs"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+	appraiser := "Org1MSP valuer"
+	appraiserHash := sha256.Sum256([]byte(appraiser))
+	yesterday := strconv.FormatInt(time.Now().Add(-24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+otherVin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", bank, "bank"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "emil", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+
+	// the buyer finances 40 of the price with the bank
+	stub.MockInvoke("1", util.ToChaincodeArgs("requestLoan", buyer, "user", vin, bank, "40"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", bank, "bank", "1"))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", seller, "garage", otherVin, buyer))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "Valuer AG", hex.EncodeToString(appraiserHash[:]), "Org1MSP", oracleAppraisal, "true"))
+	response = invokeAs(stub, appraiser, "recordAppraisal", "valuer", "appraiser", vin, "90", "market comparison", yesterday)
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	stub.MockInvoke("2", util.ToChaincodeArgs("issueFine", "officer", "police", vin, "15", "parking"))
+	stub.MockInvoke("3", util.ToChaincodeArgs("issueFine", "officer", "police", otherVin, "5", "parking"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", "emil", "user", buyer))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", "emil", "bank", buyer))
+	expectErrorCode(t, response, ErrForbidden)
+
+	for _, reader := range [][2]string{{buyer, "user"}, {bank, "bank"}, {"inspector", "dot"}} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", reader[0], reader[1], buyer))
+		if response.Status != shim.OK {
+			t.Fatalf("Expected '%s' to read the equity: %s", reader[0], response.Message)
+		}
+	}
+
+	equity := OwnerEquity{}
+	json.Unmarshal(response.Payload, &equity)
+	if len(equity.Cars) != 2 {
+		t.Fatalf("Expected the equity in 2 cars, got %v", equity)
+	}
+
+	financed := equity.Cars[0]
+	if financed.Vin != vin || financed.Value != 90 || financed.Lien != 40 || financed.Fees != 15 || financed.Equity != 35 {
+		t.Errorf("Expected 90 less a lien of 40 and fees of 15 for the financed car, got %v", financed)
+	}
+
+	unappraised := equity.Cars[1]
+	if unappraised.Value != 0 || unappraised.Lien != 0 || unappraised.Fees != 5 || unappraised.Equity != -5 {
+		t.Errorf("Expected fees of 5 on the unappraised car, got %v", unappraised)
+	}
+
+	if equity.Value != 90 || equity.Liens != 40 || equity.Fees != 20 || equity.Equity != 30 {
+		t.Errorf("Expected an equity of 30, got %v", equity)
+	}
+
+	// repaying the loan takes the lien off the equity
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("repayLoan", buyer, "user", vin, "40"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", buyer, "user", buyer))
+	json.Unmarshal(response.Payload, &equity)
+	if equity.Liens != 0 || equity.Equity != 70 {
+		t.Errorf("Expected an equity of 70 without the lien, got %v", equity)
+	}
+}
+
+func TestOwnerEquityClaims(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+	insuranceCompany := "axa"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	insureCar(t, stub, username, vin, insuranceCompany)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", `{ "vin": "`+otherVin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "rear-ended at a traffic light", "30"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+
+	// a filed claim is no payout yet
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", username, "user", username))
+	equity := OwnerEquity{}
+	json.Unmarshal(response.Payload, &equity)
+	if equity.Claims != 0 || equity.Equity != 0 {
+		t.Errorf("Expected no equity before the approval, got %v", equity)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", insuranceCompany, "insurer", claim.Id))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", "bobby", "user", username))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getOwnerEquity", "inspector", "dot", username))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	json.Unmarshal(response.Payload, &equity)
+	if len(equity.Cars) != 2 {
+		t.Fatalf("Expected the equity in 2 cars, got %v", equity)
+	}
+
+	if equity.Cars[0].Vin != vin || equity.Cars[0].Claims != 30 || equity.Cars[1].Claims != 0 {
+		t.Errorf("Expected the approved claim on the insured car, got %v", equity.Cars)
+	}
+
+	if equity.Balance != 100 || equity.Claims != 30 || equity.Equity != 30 {
+		t.Errorf("Expected an equity of 30, got %v", equity)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Error code catalog.
+ *
+ * Every failed invocation returns an error envelope
+ * '{code, message, details}' as message, so clients can
+ * branch on the code instead of parsing the message.
+ */
+const (
+	// the request itself is wrong
+	ErrInvalidArgument string = "INVALID_ARGUMENT"
+	ErrUnknownFunction string = "UNKNOWN_FUNCTION"
+
+	// the invoker may not do this
+	ErrForbiddenRole string = "FORBIDDEN_ROLE"
+	ErrNotOwner      string = "NOT_OWNER"
+	ErrForbidden     string = "FORBIDDEN"
+
+	// the invoker does not own the username
+	ErrIdentityMismatch string = "IDENTITY_MISMATCH"
+
+	// something exists already
+	ErrCarExists        string = "CAR_EXISTS"
+	ErrUserExists       string = "USER_EXISTS"
+	ErrAlreadyExists    string = "ALREADY_EXISTS"
+	ErrNumberplateInUse string = "NUMBERPLATE_IN_USE"
+
+	// something does not exist
+	ErrCarNotFound  string = "CAR_NOT_FOUND"
+	ErrUserNotFound string = "USER_NOT_FOUND"
+	ErrNotFound     string = "NOT_FOUND"
+
+	// the car is not in the required state
+	ErrNotRegistered string = "NOT_REGISTERED"
+	ErrNotInsured    string = "NOT_INSURED"
+	ErrNotConfirmed  string = "NOT_CONFIRMED"
+	ErrNotActive     string = "NOT_ACTIVE"
+	ErrInvalidState  string = "INVALID_STATE"
+
+	// the invoker cannot pay
+	ErrInsufficientFunds string = "INSUFFICIENT_FUNDS"
+
+	// reading or writing the ledger failed
+	ErrLedger   string = "LEDGER_ERROR"
+	ErrInternal string = "INTERNAL"
+
+	// malformed VIN, see 'ValidateVin'
+	ErrVinLength     string = "VIN_LENGTH"
+	ErrVinCharacter  string = "VIN_CHARACTER"
+	ErrVinCheckDigit string = "VIN_CHECK_DIGIT"
+)
+
+/*
+ * Error with a code from the catalog, which is
+ * returned to clients as error envelope
+ */
+type ChaincodeError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+/*
+ * Creates a new error with code 'code'
+ */
+func newError(code string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: message}
+}
+
+/*
+ * Creates a new error with code 'code' and
+ * additional details for the client
+ */
+func newErrorWithDetails(code string, message string, details map[string]string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: message, Details: details}
+}
+
+/*
+ * Returns the error of an error response.
+ *
+ * Messages without an error envelope are
+ * reported as 'INTERNAL'.
+ */
+func errorFromResponse(response pb.Response) *ChaincodeError {
+	ccErr := &ChaincodeError{}
+	err := json.Unmarshal([]byte(response.Message), ccErr)
+	if err != nil || ccErr.Code == "" {
+		return newError(ErrInternal, response.Message)
+	}
+
+	return ccErr
+}
+
+/*
+ * Returns an error response with the error envelope as message
+ */
+func errorResponse(code string, message string) pb.Response {
+	return errorResponseFrom(newError(code, message))
+}
+
+/*
+ * Returns an error response for 'err'.
+ *
+ * Errors without a code are reported as 'INTERNAL'.
+ */
+func errorResponseFrom(err error) pb.Response {
+	ccErr, ok := err.(*ChaincodeError)
+	if !ok {
+		ccErr = newError(ErrInternal, err.Error())
+	}
+
+	envelopeAsBytes, _ := json.Marshal(ccErr)
+	return shim.Error(string(envelopeAsBytes))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func expectErrorCode(t *testing.T, response pb.Response, code string) {
+	if response.Status == shim.OK {
+		t.Errorf("Expected error '%s', but invocation succeeded", code)
+		return
+	}
+
+	envelope := ChaincodeError{}
+	err := json.Unmarshal([]byte(response.Message), &envelope)
+	if err != nil {
+		t.Errorf("Error message is no error envelope: %s", response.Message)
+	} else if envelope.Code != code {
+		t.Errorf("Expected error '%s', but got '%s': %s", code, envelope.Code, envelope.Message)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	carData := `{ "vin": "` + vin + `" }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+	expectErrorCode(t, response, ErrCarExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", "bobby", "user", vin, username))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("register", username, "user", vin))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "user", "WVWZZZ6R7HY260780"))
+	expectErrorCode(t, response, ErrVinCheckDigit)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fly", username, "user"))
+	expectErrorCode(t, response, ErrUnknownFunction)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Expiry index.
+ *
+ * Every module setting an expiry date also writes the range
+ * key 'exp~<type>~<RFC3339 ts>~<vin>'. The keys are plain
+ * strings, not composite keys, and timestamps are in UTC,
+ * so the keys of a type sort by expiry date and a time
+ * window is a single 'GetStateByRange', see 'getExpiring'.
+ * Replacing a credential moves its key; index entries of
+ * credentials dropped otherwise stay until they are due.
+ *
+ * The DOT, or a client run by cron, calls 'processExpirations'
+ * to walk the index up to now in batches: expired permits
+ * and cover notes are dropped from the car, and confirmed
+ * cars no longer insured, lacking a current emission test
+ * or with overdue road tax lose their numberplate. Due entries of all other types
+ * are removed. Index entries are hints only, every car is
+ * checked against its current state.
+ */
+
+// prefix of expiry index keys
+const expiryPrefix string = "exp~"
+
+// value of expiry index keys, the key holds all data
+var expiryValue = []byte{0x00}
+
+// types of expiring credentials
+const (
+	expiryInsurance string = "insurance"
+	expiryEmission  string = "emission"
+	expiryPermit    string = "permit"
+	expiryCoverNote string = "cover_note"
+	expiryTransit   string = "transit"
+	expiryDeposit   string = "deposit"
+	expiryRental    string = "rental"
+	expiryWarranty  string = "warranty"
+	expiryMandate   string = "mandate"
+	expiryProposal  string = "proposal"
+	expiryRoadTax   string = "road_tax"
+)
+
+var expiryTypes = []string{expiryInsurance, expiryEmission, expiryPermit, expiryCoverNote, expiryTransit, expiryDeposit, expiryRental, expiryWarranty, expiryMandate, expiryProposal, expiryRoadTax}
+
+// types whose expiry can revoke a confirmation or change the car
+var carExpiryTypes = []string{expiryInsurance, expiryEmission, expiryPermit, expiryCoverNote, expiryRoadTax}
+
+// index entries processed by one call by default, and at most
+const defaultExpirationBatch int = 100
+const maxExpirationBatch int = 1000
+
+/*
+ * Returns the start of the range of 'expiryType' keys at 'ts'
+ */
+func expiryRangeKey(expiryType string, ts int64) string {
+	return expiryPrefix + expiryType + "~" + time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+/*
+ * Returns the expiry index key of a credential of car 'vin'
+ */
+func getExpiryKey(expiryType string, ts int64, vin string) string {
+	return expiryRangeKey(expiryType, ts) + "~" + vin
+}
+
+/*
+ * Parses an expiry index key
+ */
+func parseExpiryKey(key string) (ExpiryEntry, error) {
+	parts := strings.Split(strings.TrimPrefix(key, expiryPrefix), "~")
+	if len(parts) != 3 {
+		return ExpiryEntry{}, newError(ErrLedger, "Error parsing expiry key")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return ExpiryEntry{}, newError(ErrLedger, "Error parsing expiry key")
+	}
+
+	return ExpiryEntry{Type: parts[0], ExpiresTs: expiresAt.Unix(), Vin: parts[2]}, nil
+}
+
+/*
+ * Moves the expiry of a credential of car 'vin' from
+ * 'previousTs' to 'ts'. Either is 0 for none.
+ */
+func updateExpiry(stub shim.ChaincodeStubInterface, expiryType string, vin string, previousTs int64, ts int64) error {
+	if previousTs == ts {
+		return nil
+	}
+
+	if previousTs != 0 {
+		err := stub.DelState(getExpiryKey(expiryType, previousTs, vin))
+		if err != nil {
+			return newError(ErrLedger, "Error removing expiry index entry")
+		}
+	}
+
+	if ts != 0 {
+		err := stub.PutState(getExpiryKey(expiryType, ts, vin), expiryValue)
+		if err != nil {
+			return newError(ErrLedger, "Error writing expiry index")
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the end of the day of a trip permit
+ */
+func permitExpiry(permit *TripPermit) int64 {
+	day, err := time.Parse(permitDateLayout, permit.ValidOn)
+	if err != nil {
+		return 0
+	}
+
+	return day.Add(24 * time.Hour).Unix()
+}
+
+/*
+ * Adds the expiry dates of the credentials held on 'car'
+ */
+func indexCarExpiries(stub shim.ChaincodeStubInterface, car *Car) error {
+	expiries := map[string][]int64{}
+	if car.Certificate.Insurer != "" && car.Policy.EndTs != 0 {
+		expiries[expiryInsurance] = append(expiries[expiryInsurance], car.Policy.EndTs)
+	}
+	if car.Emission.TestedTs != 0 {
+		expiries[expiryEmission] = append(expiries[expiryEmission], car.Emission.ExpiresTs)
+	}
+	if car.Permit.ValidOn != "" && !car.Permit.Consumed {
+		expiries[expiryPermit] = append(expiries[expiryPermit], permitExpiry(&car.Permit))
+	}
+	if car.CoverNote != nil {
+		expiries[expiryCoverNote] = append(expiries[expiryCoverNote], car.CoverNote.ExpiresTs)
+	}
+	if car.Listing.Hold != nil {
+		expiries[expiryDeposit] = append(expiries[expiryDeposit], car.Listing.Hold.ExpiresTs)
+	}
+	if car.RoadTaxDueTs != 0 {
+		expiries[expiryRoadTax] = append(expiries[expiryRoadTax], car.RoadTaxDueTs)
+	}
+	if car.Rental.Status != "" {
+		expiries[expiryRental] = append(expiries[expiryRental], car.Rental.EndTs)
+	}
+	for _, warranty := range car.Warranties {
+		if !warranty.Voided {
+			expiries[expiryWarranty] = append(expiries[expiryWarranty], warranty.EndTs)
+		}
+	}
+
+	// type order, so all peers write the same
+	for _, expiryType := range expiryTypes {
+		for _, ts := range expiries[expiryType] {
+			err := updateExpiry(stub, expiryType, car.Vin, 0, ts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Drops the expired credentials of 'car' at 'now' and
+ * revokes the confirmation if the car no longer meets
+ * the registration rules of its numberplate.
+ *
+ * Returns whether the car changed and whether it was revoked.
+ */
+func expireCredentials(config Config, car *Car, now int64) (bool, bool) {
+	changed := false
+	revoked := false
+
+	if car.Certificate.Numberplate != "" && evaluateRules(registrationRules(config, car.Certificate.Numberplate), car, now) != nil {
+		car.Certificate.Numberplate = ""
+		car.RevocationDueTs = 0
+		car.InsuranceExempt = false
+		changed = true
+		revoked = true
+	} else if car.RevocationDueTs != 0 && IsInsuredByPolicy(car, now) {
+		car.RevocationDueTs = 0
+		changed = true
+	}
+
+	if car.CoverNote != nil && now >= car.CoverNote.ExpiresTs {
+		car.CoverNote = nil
+		changed = true
+	}
+
+	if car.Permit.ValidOn != "" && !car.Permit.Consumed && permitExpiry(&car.Permit) <= now {
+		car.Permit = TripPermit{}
+		changed = true
+	}
+
+	return changed, revoked
+}
+
+/*
+ * Returns the index entries of 'expiryType' expiring
+ * in ['fromTs', 'toTs'), soonest first
+ */
+func getExpiryEntries(stub shim.ChaincodeStubInterface, expiryType string, fromTs int64, toTs int64, limit int) ([]ExpiryEntry, error) {
+	iterator, err := stub.GetStateByRange(expiryRangeKey(expiryType, fromTs), expiryRangeKey(expiryType, toTs))
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading expiry index")
+	}
+	defer iterator.Close()
+
+	entries := []ExpiryEntry{}
+	for iterator.HasNext() && (limit == 0 || len(entries) < limit) {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading expiry index")
+		}
+
+		entry, err := parseExpiryKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+/*
+ * Lists the credentials expiring in a time window,
+ * e.g. all policies ending next month.
+ *
+ * Expects 'args':
+ *  from                                     unix timestamp
+ *  to, exclusive                            unix timestamp
+ *  (optional) type, all types if missing    string
+ *
+ * On success,
+ * returns the expiry entries by type, soonest first.
+ */
+func (t *CarChaincode) getExpiring(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fromTs, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'getExpiring' expects the start of the window as unix timestamp")
+	}
+
+	toTs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || toTs <= fromTs {
+		return errorResponse(ErrInvalidArgument, "'getExpiring' expects the end of the window after the start")
+	}
+
+	types := expiryTypes
+	if len(args) > 2 && args[2] != "" {
+		types = []string{args[2]}
+	}
+
+	entries := []ExpiryEntry{}
+	for _, expiryType := range types {
+		typeEntries, err := getExpiryEntries(stub, expiryType, fromTs, toTs, 0)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		entries = append(entries, typeEntries...)
+	}
+
+	entriesAsBytes, _ := ledgerjson.Marshal(entries)
+	return shim.Success(entriesAsBytes)
+}
+
+/*
+ * Processes the expiry index up to now, at most 'batch size'
+ * entries per call. Callers repeat the call while the report
+ * says there are more.
+ *
+ * Expects 'args':
+ *  (optional) batch size                    int
+ *
+ * On success,
+ * returns the expiration report.
+ */
+func (t *CarChaincode) processExpirations(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	batch := defaultExpirationBatch
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		batch, err = strconv.Atoi(args[0])
+		if err != nil || batch < 1 || batch > maxExpirationBatch {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("'processExpirations' expects a batch size between 1 and %d", maxExpirationBatch))
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// one more than the batch tells if there are more
+	due := []ExpiryEntry{}
+	for _, expiryType := range expiryTypes {
+		entries, err := getExpiryEntries(stub, expiryType, 0, now+1, batch+1-len(due))
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		due = append(due, entries...)
+		if len(due) > batch {
+			break
+		}
+	}
+
+	report := ExpirationReport{Revoked: []string{}}
+	if len(due) > batch {
+		due = due[:batch]
+		report.More = true
+	}
+
+	// read and write every car once, however
+	// many of its credentials expired
+	cars := make(map[string]*Car)
+	order := []string{}
+	for _, entry := range due {
+		err = updateExpiry(stub, entry.Type, entry.Vin, entry.ExpiresTs, 0)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		report.Processed++
+
+		if _, ok := cars[entry.Vin]; ok || !containsString(carExpiryTypes, entry.Type) {
+			continue
+		}
+
+		// archived or removed cars only drop their entries
+		car, _, err := t.getHandoffCar(stub, entry.Vin)
+		if err == nil {
+			cars[entry.Vin] = &car
+			order = append(order, entry.Vin)
+		} else {
+			cars[entry.Vin] = nil
+		}
+	}
+
+	for _, vin := range order {
+		car := cars[vin]
+		changed, revoked := expireCredentials(config, car, now)
+		if !changed {
+			continue
+		}
+
+		carAsBytes, _ := ledgerjson.Marshal(car)
+		err = stub.PutState(vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+
+		if revoked {
+			report.Revoked = append(report.Revoked, vin)
+		}
+	}
+
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	if len(report.Revoked) > 0 {
+		err = stub.SetEvent("carsRevoked", reportAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error emitting 'carsRevoked' event")
+		}
+	}
+
+	fmt.Printf("Processed %d expirations, revoked %d cars\n", report.Processed, len(report.Revoked))
+	return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestProcessExpirations(t *testing.T) {
+	expired := "WVWZZZ6R6HY260780"
+	lapsed := "WVWZZZ6RXHY260782"
+	insured := "WVWZZZ6R8HY260781"
+	policyHash := strings.Repeat("ab", 32)
+	ts := func(days int) string {
+		return strconv.FormatInt(time.Now().Add(time.Duration(days)*24*time.Hour).Unix(), 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, "amag", expired, "axa")
+	insureCar(t, stub, "carol", lapsed, "axa")
+	insureCar(t, stub, "bobby", insured, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", "amag", "dot", expired, "ZH 1111"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", "carol", "dot", lapsed, "ZH 3333"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", "bobby", "dot", insured, "ZH 2222"))
+
+	// a renewal moves the expiry of the policy
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", expired, ts(-40), ts(-20), policyHash))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", expired, ts(-20), ts(-10), policyHash))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", lapsed, ts(-40), ts(-5), policyHash))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", insured, ts(-20), ts(30), policyHash))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getExpiring", "inspector", "dot", ts(-60), ts(60), expiryInsurance))
+	entries := []ExpiryEntry{}
+	json.Unmarshal(response.Payload, &entries)
+	if len(entries) != 3 || entries[0].Vin != expired || entries[1].Vin != lapsed || entries[2].Vin != insured {
+		t.Fatalf("Expected the three policies soonest first, got %s", response.Payload)
+	}
+
+	// what expires next month?
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getExpiring", "inspector", "dot", ts(0), ts(31)))
+	entries = []ExpiryEntry{}
+	json.Unmarshal(response.Payload, &entries)
+	if len(entries) != 1 || entries[0].Vin != insured || entries[0].Type != expiryInsurance {
+		t.Errorf("Expected the policy of the insured car, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("processExpirations", "amag", "garage"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("processExpirations", "inspector", "dot", "1"))
+	report := ExpirationReport{}
+	json.Unmarshal(response.Payload, &report)
+	if report.Processed != 1 || !report.More || len(report.Revoked) != 1 || report.Revoked[0] != expired {
+		t.Fatalf("Expected the first batch to revoke the first lapsed car, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("processExpirations", "inspector", "dot"))
+	report = ExpirationReport{}
+	json.Unmarshal(response.Payload, &report)
+	if report.Processed != 1 || report.More || len(report.Revoked) != 1 || report.Revoked[0] != lapsed {
+		t.Errorf("Expected the second batch to revoke the second lapsed car, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", "amag", "user", expired))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "" {
+		t.Error("Car with lapsed policy should have lost its numberplate")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", "bobby", "user", insured))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "ZH 2222" {
+		t.Error("Insured car should keep its numberplate")
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Returns the export index
+ */
+func (t *CarChaincode) getExportIndex(stub shim.ChaincodeStubInterface) (map[string]ExportCertificate, error) {
+	response := t.read(stub, exportIndexStr)
+	exportIndex := make(map[string]ExportCertificate)
+	err := ledgerjson.Unmarshal(response.Payload, &exportIndex)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing export index")
+	}
+
+	return exportIndex, nil
+}
+
+/*
+ * Hashes a car for an export certificate
+ */
+func hashCar(car Car) string {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	hash := sha256.Sum256(carAsBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Checks if 'hash' is the hash of 'car'.
+ *
+ * Certificates of chaincode versions before canonical
+ * JSON, or of another country still running one, hash
+ * the plain 'encoding/json' output, so that is accepted too.
+ */
+func matchesCarHash(hash string, car Car) bool {
+	if hash == hashCar(car) {
+		return true
+	}
+
+	carAsBytes, _ := json.Marshal(car)
+	legacyHash := sha256.Sum256(carAsBytes)
+	return hash == hex.EncodeToString(legacyHash[:])
+}
+
+/*
+ * Checks the signature of the exporting registry over
+ * the hash of 'cert' with the certificate registered
+ * for it, see 'registerOrganizationCertificate'
+ */
+func verifyExportSignature(stub shim.ChaincodeStubInterface, cert *ExportCertificate) error {
+	signature, err := base64.StdEncoding.DecodeString(cert.Signature)
+	if err != nil || len(signature) == 0 || cert.Registry == "" {
+		return newError(ErrInvalidArgument, "Export certificate is missing the registry or its signature")
+	}
+
+	orgCertificate, err := getOrganizationCertificate(stub, cert.Registry)
+	if err != nil {
+		return err
+	} else if orgCertificate.Kind != organizationRegistry {
+		return newError(ErrForbidden, fmt.Sprintf("Forbidden: '%s' is not registered as a vehicle registry", cert.Registry))
+	}
+
+	// registered certificates parsed before
+	certificate, err := parseCertificate(orgCertificate.Certificate)
+	if err != nil {
+		return newError(ErrInternal, "Error parsing registered certificate")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	} else if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+		return newError(ErrInvalidArgument, fmt.Sprintf("The certificate of registry '%s' is not valid", cert.Registry))
+	}
+
+	digest, err := hex.DecodeString(cert.Hash)
+	if err != nil || !checkSignature(certificate, digest, signature) {
+		return newError(ErrInvalidArgument, fmt.Sprintf("Export certificate is not signed by registry '%s'", cert.Registry))
+	}
+
+	return nil
+}
+
+/*
+ * Exports a car to another country.
+ *
+ * The car is deregistered locally, it loses its numberplate
+ * and insurance, and moves to the archive. The DOT hands
+ * out an export certificate with the car as it was at
+ * the time of export. Like a car changing hands, a car
+ * pledged, rented, sold in installments, held, frozen or
+ * handed off cannot leave.
+ *
+ * The DOT signs the hash of the certificate with the key
+ * of its registry, which never comes to the ledger, and
+ * adds its name and the signature. The DOT of the
+ * destination country registers the car with this
+ * certificate again, see 'importCar'.
+ *
+ * On success,
+ * returns the export certificate.
+ */
+func (t *CarChaincode) exportCar(stub shim.ChaincodeStubInterface, vin string, destination string) pb.Response {
+	if destination == "" {
+		return errorResponse(ErrInvalidArgument, "'exportCar' expects a non-empty destination country")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the export certificate vouches for the VIN,
+	// which we only trust for registered cars
+	if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Cannot export an unregistered car")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// an export must not escape a loan or a contract
+	err = t.checkMovable(stub, &car)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkHold(&car, "", now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	cert := ExportCertificate{
+		Car:                car,
+		Owner:              owner,
+		DestinationCountry: destination,
+		ExportedTs:         now,
+		Hash:               hashCar(car)}
+
+	// deregister the car locally
+	err = updateInsuredIndex(stub, vin, car.Certificate.Insurer, "")
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Certificate.Vin = ""
+	car.Certificate.Numberplate = ""
+	car.Certificate.Insurer = ""
+	car.InsuranceExempt = false
+	car.ExportedTo = destination
+
+	// the car leaves the active state,
+	// auditors find it in the archive
+	err = t.archiveCar(stub, owner, car, archiveExported)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	exportIndex, err := t.getExportIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	exportIndex[car.Vin] = cert
+	indexAsBytes, _ := ledgerjson.Marshal(exportIndex)
+	err = stub.PutState(exportIndexStr, indexAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing export index")
+	}
+
+	fmt.Printf("Exported car with VIN '%s' to '%s'\n", vin, destination)
+
+	certAsBytes, _ := ledgerjson.Marshal(cert)
+	return shim.Success(certAsBytes)
+}
+
+/*
+ * Imports a car with an export certificate of another registry.
+ *
+ * The certificate is validated against its hash, and the
+ * hash against the signature of the exporting registry.
+ * The car gets registered for the owner named in the
+ * certificate. Numberplate and insurance have to be
+ * applied for again.
+ *
+ * Arguments required:
+ * [0] Export certificate          (json)
+ * [1] Customs clearance           (json)
+ *
+ * On success,
+ * returns the imported car.
+ */
+func (t *CarChaincode) importCar(stub shim.ChaincodeStubInterface, certData string, customsData string) pb.Response {
+	cert := ExportCertificate{}
+	err := ledgerjson.Unmarshal([]byte(certData), &cert)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing export certificate")
+	}
+
+	customs := Customs{}
+	err = ledgerjson.Unmarshal([]byte(customsData), &customs)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing customs clearance data")
+	}
+
+	// validate the certificate
+	car := cert.Car
+	if car.Vin == "" || cert.Owner == "" {
+		return errorResponse(ErrInvalidArgument, "Export certificate is missing the car VIN or owner")
+	} else if !matchesCarHash(cert.Hash, car) {
+		return errorResponse(ErrInvalidArgument, "Export certificate does not match the exported car")
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Export certificate is not for a registered car")
+	}
+
+	// the hash only ties the car to the certificate,
+	// the signature proves the registry issued it
+	err = verifyExportSignature(stub, &cert)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// no import without customs clearance
+	if customs.Declaration == "" || customs.Office == "" {
+		return errorResponse(ErrInvalidArgument, "Customs clearance needs a declaration number and a customs office")
+	}
+
+	// a car exported from here before is archived and
+	// no longer in the car index, any other car with
+	// that VIN blocks the import
+	owner, err := t.getOwner(stub, car.Vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner != "" {
+		return errorResponse(ErrCarExists, fmt.Sprintf("Car with vin '%s' is already registered here", car.Vin))
+	}
+
+	// scrapped cars do not come back
+	archived, err := getArchival(stub, car.Vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if archived != nil && archived.Reason == archiveScrapped {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with vin '%s' was scrapped here", car.Vin))
+	}
+
+	customs.ClearedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// register the car for its owner, the car
+	// needs a local numberplate and insurance
+	car.Certificate.Username = cert.Owner
+	car.Certificate.Numberplate = ""
+	car.Certificate.Insurer = ""
+	car.InsuranceExempt = false
+	car.Permit = TripPermit{}
+	car.ExportedTo = ""
+	car.Customs = customs
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	// hand the car over to the owner
+	newOwner, pseudonym, err := t.ensureOwner(stub, cert.Owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = addOwnership(stub, newOwner.Name, car.Vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = indexCar(stub, car.Vin, pseudonym)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Imported car with VIN '%s' for owner '%s'\n", car.Vin, newOwner.Name)
+
+	return shim.Success(carAsBytes)
+}
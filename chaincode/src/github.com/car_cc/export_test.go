@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestExportAndImportCar(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	customsData := `{ "declaration": "CH-2017-0042", "office": "Basel" }`
+
+	// two registries, one per country
+	swiss := shim.NewMockStub("car", &CarChaincode{})
+	german := shim.NewMockStub("car", &CarChaincode{})
+
+	ccSetup(t, swiss)
+	ccSetup(t, german)
+
+	insureCar(t, swiss, username, vin, "axa")
+
+	// the german DOT trusts the key of the swiss registry
+	key, certificate := signingCertificate(t, "CH", time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	german.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "inspector", "dot", "CH", organizationRegistry, certificate))
+	german.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "inspector", "dot", "VW", "manufacturer", certificate))
+	sign := func(cert ExportCertificate, registry string) string {
+		cert.Registry = registry
+		digest, _ := hex.DecodeString(cert.Hash)
+		signature, _ := ecdsa.SignASN1(rand.Reader, key, digest)
+		cert.Signature = base64.StdEncoding.EncodeToString(signature)
+		certAsBytes, _ := json.Marshal(cert)
+		return string(certAsBytes)
+	}
+
+	// seized cars stay
+	swiss.MockInvoke(uuid, util.ToChaincodeArgs("seizeCar", "officer", "police", vin, "ZH-2024-17"))
+	response := swiss.MockInvoke(uuid, util.ToChaincodeArgs("exportCar", username, "dot", vin, "DE"))
+	expectErrorCode(t, response, ErrInvalidState)
+	swiss.MockInvoke(uuid, util.ToChaincodeArgs("releaseCar", "judge", "court", vin, "ZH-2024-17"))
+
+	// export the car as DOT
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("exportCar", username, "dot", vin, "DE"))
+	cert := ExportCertificate{}
+	err := json.Unmarshal(response.Payload, &cert)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if cert.Owner != username || cert.DestinationCountry != "DE" {
+		t.Error("Export certificate has the wrong owner or destination")
+	}
+
+	// the car is no longer registered locally
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+	expectErrorCode(t, response, ErrCarNotFound)
+
+	// but kept in the archive
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("getArchivedCar", "inspector", "dot", vin))
+	archived := ArchivedCar{}
+	json.Unmarshal(response.Payload, &archived)
+	if IsRegistered(&archived.Car) || archived.Car.ExportedTo != "DE" || archived.Archival.Reason != archiveExported {
+		t.Error("Exported car should be deregistered and archived")
+	}
+	car := Car{}
+
+	// a tampered certificate is rejected
+	tampered := cert
+	tampered.Owner = "mallory"
+	tampered.Car.Certificate.Username = "mallory"
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", sign(tampered, "CH"), customsData))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// as is a car hashed by someone else than the registry
+	tampered = cert
+	tampered.Car.Classification = ""
+	tampered.Hash = hashCar(tampered.Car)
+	tamperedAsBytes, _ := json.Marshal(tampered)
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", string(tamperedAsBytes), customsData))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	tampered.Registry = "CH"
+	tampered.Signature = base64.StdEncoding.EncodeToString([]byte("forged"))
+	tamperedAsBytes, _ = json.Marshal(tampered)
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", string(tamperedAsBytes), customsData))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// only registries sign export certificates
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", sign(cert, "VW"), customsData))
+	expectErrorCode(t, response, ErrForbidden)
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", sign(cert, "FR"), customsData))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// imports need customs clearance
+	certAsBytes := sign(cert, "CH")
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", certAsBytes, `{}`))
+	if response.Status == shim.OK {
+		t.Error("Imports without customs clearance should be rejected")
+	}
+
+	// import the car in the other registry
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", certAsBytes, customsData))
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if !IsRegistered(&car) || IsInsured(&car, time.Now().Unix()) {
+		t.Error("Imported car should be registered, but not insured")
+	}
+
+	if car.Customs.Declaration != "CH-2017-0042" {
+		t.Error("Imported car should carry its customs clearance")
+	}
+
+	// the owner can read the car in the new registry
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// and is a user of the new registry
+	userIndex, _ := (&CarChaincode{}).getUserIndex(german)
+	if userIndex[username] != username {
+		t.Errorf("Expected '%s' in the user index of the new registry", username)
+	}
+
+	// importing the same car twice is not possible
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("importCar", "TESTING", "dot", certAsBytes, customsData))
+	if response.Status == shim.OK {
+		t.Error("A car cannot be imported twice")
+	}
+}
+
+func TestLegacyExportHash(t *testing.T) {
+	car := Car{Vin: "WVWZZZ6R6HY260780", Certificate: Certificate{Username: "amag"}}
+
+	carAsBytes, _ := json.Marshal(car)
+	legacyHash := sha256.Sum256(carAsBytes)
+	if !matchesCarHash(hex.EncodeToString(legacyHash[:]), car) || !matchesCarHash(hashCar(car), car) {
+		t.Error("Expected the canonical and the legacy hash to match")
+	}
+
+	car.Certificate.Username = "mallory"
+	if matchesCarHash(hex.EncodeToString(legacyHash[:]), car) {
+		t.Error("Expected the hash of another car not to match")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Parking fines and violations.
+ *
+ * The police and municipalities attach fines to a car with
+ * 'issueFine', due after a number of days. The owner pays
+ * a fine from their balance with 'payFine', the fine goes
+ * to the treasury, or contests it with 'contestFine'. An
+ * arbiter settles the contest with 'resolveFine', either
+ * upholding the fine or cancelling it. Fines are kept under
+ * 'fine~<vin>~<id>', the id being the issuing transaction.
+ *
+ * Fines stay with the car. They never block a transfer,
+ * but the car carries the amount of its unpaid fines, a
+ * listed car shows the 'unpaid_fines' badge and the sale
+ * event names them as a warning, so the buyer is told.
+ */
+
+// object type of fine keys
+const fineObjectType string = "fine"
+
+// fee name of fines in the treasury
+const fineName string = "fine"
+
+// days to pay a fine by default
+const defaultFineDueDays int = 30
+
+// badge and transfer warning of cars with unpaid fines
+const badgeUnpaidFines string = "unpaid_fines"
+
+// fine states
+const fineUnpaid string = "unpaid"
+const fineContested string = "contested"
+const finePaid string = "paid"
+const fineCancelled string = "cancelled"
+
+// outcomes of a contested fine
+var fineOutcomes = []string{"upheld", fineCancelled}
+
+/*
+ * Checks if a fine is unpaid and past its due date at 'now'
+ */
+func IsFineOverdue(fine *Fine, now int64) bool {
+	return fine.Status == fineUnpaid && now >= fine.DueTs
+}
+
+/*
+ * Returns what the buyer of 'car' is warned about
+ */
+func transferWarnings(car *Car) []string {
+	if car.UnpaidFines > 0 {
+		return []string{badgeUnpaidFines}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the ledger key of fine 'id' on car 'vin'
+ */
+func getFineKey(stub shim.ChaincodeStubInterface, vin string, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(fineObjectType, []string{vin, id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating fine key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads fine 'id' on car 'vin'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getFine(stub shim.ChaincodeStubInterface, vin string, id string) (*Fine, error) {
+	key, err := getFineKey(stub, vin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	fineAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading fine")
+	} else if fineAsBytes == nil {
+		return nil, nil
+	}
+
+	fine := Fine{}
+	err = ledgerjson.Unmarshal(fineAsBytes, &fine)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing fine")
+	}
+
+	return &fine, nil
+}
+
+/*
+ * Writes a fine
+ */
+func saveFine(stub shim.ChaincodeStubInterface, fine *Fine) ([]byte, error) {
+	key, err := getFineKey(stub, fine.Vin, fine.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	fineAsBytes, _ := ledgerjson.Marshal(fine)
+	err = stub.PutState(key, fineAsBytes)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error writing fine")
+	}
+
+	return fineAsBytes, nil
+}
+
+/*
+ * Reads fine 'id' on car 'vin' in 'status'
+ */
+func getFineIn(stub shim.ChaincodeStubInterface, vin string, id string, status string) (*Fine, error) {
+	fine, err := getFine(stub, vin, id)
+	if err != nil {
+		return nil, err
+	} else if fine == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no fine '%s' on car '%s'", id, vin))
+	} else if fine.Status != status {
+		return nil, newError(ErrInvalidState, fmt.Sprintf("The fine is %s", fine.Status))
+	}
+
+	return fine, nil
+}
+
+/*
+ * Adds 'amount' to the unpaid fines of 'car' and writes it
+ */
+func addUnpaidFines(stub shim.ChaincodeStubInterface, car *Car, amount int) error {
+	car.UnpaidFines += amount
+	refreshListingBadges(car)
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing car")
+	}
+
+	return nil
+}
+
+/*
+ * Attaches a fine of 'amount' for 'reason' to car 'vin',
+ * due after 'dueDays', 0 for the default.
+ *
+ * On success,
+ * returns the fine.
+ */
+func (t *CarChaincode) issueFine(stub shim.ChaincodeStubInterface, username string, vin string, amount int, reason string, dueDays int) pb.Response {
+	if amount <= 0 {
+		return errorResponse(ErrInvalidArgument, "'issueFine' expects a positive amount")
+	} else if dueDays < 0 {
+		return errorResponse(ErrInvalidArgument, "'issueFine' expects the days to pay not to be negative")
+	} else if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'issueFine' expects a reason")
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if dueDays == 0 {
+		dueDays = defaultFineDueDays
+	}
+
+	fine := Fine{
+		Id:       stub.GetTxID(),
+		Vin:      vin,
+		Owner:    owner,
+		Reason:   reason,
+		Amount:   amount,
+		Status:   fineUnpaid,
+		IssuedBy: username,
+		IssuedTs: now,
+		DueTs:    now + int64(dueDays)*secondsPerDay}
+
+	fineAsBytes, err := saveFine(stub, &fine)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = addUnpaidFines(stub, &car, amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Fine of %d issued on car '%s' by '%s'\n", amount, vin, username)
+	return shim.Success(fineAsBytes)
+}
+
+/*
+ * Pays unpaid fine 'id' on car 'vin'
+ * from the balance of the owner.
+ *
+ * On success,
+ * returns the fine.
+ */
+func (t *CarChaincode) payFine(stub shim.ChaincodeStubInterface, username string, vin string, id string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fine, err := getFineIn(stub, vin, id, fineUnpaid)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.chargeFee(stub, username, fineName, fine.Amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fine.Status = finePaid
+	fine.PaidBy = username
+	fine.PaidTs = now
+
+	fineAsBytes, err := saveFine(stub, fine)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = addUnpaidFines(stub, &car, -fine.Amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Fine '%s' on car '%s' paid by '%s'\n", id, vin, username)
+	return shim.Success(fineAsBytes)
+}
+
+/*
+ * Contests unpaid fine 'id' on car 'vin' for
+ * 'reason', to be settled by an arbiter.
+ *
+ * On success,
+ * returns the fine.
+ */
+func (t *CarChaincode) contestFine(stub shim.ChaincodeStubInterface, username string, vin string, id string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'contestFine' expects a reason")
+	}
+
+	_, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fine, err := getFineIn(stub, vin, id, fineUnpaid)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fine.Status = fineContested
+	fine.Contest = &FineContest{ContestedBy: username, Reason: reason, ContestedTs: now}
+
+	fineAsBytes, err := saveFine(stub, fine)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Fine '%s' on car '%s' contested by '%s'\n", id, vin, username)
+	return shim.Success(fineAsBytes)
+}
+
+/*
+ * Settles the contest of fine 'id' on car 'vin' with
+ * 'outcome': 'upheld' makes it payable again, 'cancelled'
+ * drops it.
+ *
+ * On success,
+ * returns the fine.
+ */
+func (t *CarChaincode) resolveFine(stub shim.ChaincodeStubInterface, username string, vin string, id string, outcome string, note string) pb.Response {
+	fine, err := getFineIn(stub, vin, id, fineContested)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fine.Contest.Outcome = outcome
+	fine.Contest.Note = note
+	fine.Contest.ResolvedBy = username
+	fine.Contest.ResolvedTs = now
+
+	fine.Status = fineUnpaid
+	if outcome == fineCancelled {
+		fine.Status = fineCancelled
+
+		car, _, err := t.getHandoffCar(stub, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		err = addUnpaidFines(stub, &car, -fine.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	fineAsBytes, err := saveFine(stub, fine)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Contest of fine '%s' on car '%s' resolved as %s\n", id, vin, outcome)
+	return shim.Success(fineAsBytes)
+}
+
+/*
+ * Lists the fines of car 'vin' for the owner,
+ * the police and municipalities.
+ *
+ * On success,
+ * returns the fines with whether they are overdue.
+ */
+func (t *CarChaincode) getFines(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	if role != "police" && role != "municipality" {
+		_, err := t.getCar(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(fineObjectType, []string{vin})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading fines")
+	}
+	defer iterator.Close()
+
+	fines := []Fine{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading fines")
+		}
+
+		fine := Fine{}
+		err = ledgerjson.Unmarshal(kv.Value, &fine)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing fine")
+		}
+
+		fine.Overdue = IsFineOverdue(&fine, now)
+		fines = append(fines, fine)
+	}
+
+	finesAsBytes, _ := ledgerjson.Marshal(fines)
+	return shim.Success(finesAsBytes)
+}
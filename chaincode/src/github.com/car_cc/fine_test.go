@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestFines(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "50"))
+	readFines := func(username string, role string) []Fine {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getFines", username, role, vin))
+		fines := []Fine{}
+		json.Unmarshal(response.Payload, &fines)
+		return fines
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("issueFine", seller, "user", vin, "40", "parking"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	stub.MockInvoke("1", util.ToChaincodeArgs("issueFine", "officer", "police", vin, "40", "parking"))
+	stub.MockInvoke("2", util.ToChaincodeArgs("issueFine", "zurich", "municipality", vin, "15", "no permit", "10"))
+	if fines := readFines("officer", "police"); len(fines) != 2 || fines[1].Status != fineUnpaid || fines[1].Overdue {
+		t.Fatalf("Expected 2 unpaid fines, got %v", fines)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", seller, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.UnpaidFines != 55 || len(car.Listing.Badges) != 1 || car.Listing.Badges[0] != badgeUnpaidFines {
+		t.Errorf("Expected a listed car with 55 in unpaid fines, got %d and %v", car.UnpaidFines, car.Listing.Badges)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("contestFine", seller, "user", vin, "2", "permit on the dashboard"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payFine", seller, "user", vin, "2"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveFine", "judy", "arbiter", vin, "2", "cancelled"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// unpaid fines do not block the sale, the buyer is warned
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "50", vin, buyer))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	sale := CarSale{}
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		if event.EventName == "carSold" {
+			json.Unmarshal(event.Payload, &sale)
+		}
+	}
+	if len(sale.Warnings) != 1 || sale.Warnings[0] != badgeUnpaidFines {
+		t.Errorf("Expected the sale to warn of unpaid fines, got %v", sale.Warnings)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payFine", seller, "user", vin, "1"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("payFine", buyer, "user", vin, "1"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	fines := readFines(buyer, "user")
+	if car.UnpaidFines != 0 || fines[0].Status != finePaid || fines[1].Status != fineCancelled {
+		t.Errorf("Expected all fines settled, got %d and %v", car.UnpaidFines, fines)
+	}
+}
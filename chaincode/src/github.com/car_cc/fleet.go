@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Fleet accounts.
+ *
+ * A company owns its cars through a fleet account. Nobody
+ * invokes as the fleet itself: fleet admins act for the
+ * fleet, e.g. to transfer its cars, and employees are
+ * assigned to cars as drivers, which lets them read the car.
+ */
+
+// fleet member roles
+const fleetAdmin string = "admin"
+const fleetDriver string = "driver"
+
+/*
+ * Checks if a user is a fleet account
+ */
+func IsFleet(user *User) bool {
+	return len(user.Fleet.Admins) > 0
+}
+
+/*
+ * Checks if 'username' administers the fleet 'fleet'
+ */
+func isFleetAdmin(fleet *User, username string) bool {
+	return containsString(fleet.Fleet.Admins, username)
+}
+
+/*
+ * Reads fleet 'name' for one of its admins
+ */
+func (t *CarChaincode) getFleetAsAdmin(stub shim.ChaincodeStubInterface, username string, name string) (User, error) {
+	fleet, err := t.getUser(stub, name)
+	if err != nil {
+		return User{}, err
+	} else if !IsFleet(&fleet) {
+		return User{}, newError(ErrNotFound, fmt.Sprintf("'%s' is not a fleet", name))
+	} else if !isFleetAdmin(&fleet, username) {
+		return User{}, newError(ErrForbidden, fmt.Sprintf("Forbidden: you are no admin of fleet '%s'", name))
+	}
+
+	return fleet, nil
+}
+
+/*
+ * Returns the fleet owning car 'vin' if 'username'
+ * administers it, or 'nil' otherwise.
+ */
+func (t *CarChaincode) adminFleetOf(stub shim.ChaincodeStubInterface, username string, vin string) (*User, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	fleet, err := t.getUser(stub, owner)
+	if err != nil || !IsFleet(&fleet) || !isFleetAdmin(&fleet, username) {
+		return nil, nil
+	}
+
+	return &fleet, nil
+}
+
+/*
+ * Checks if 'username' is assigned to drive car 'vin'
+ */
+func (t *CarChaincode) isAssignedDriver(stub shim.ChaincodeStubInterface, username string, vin string) bool {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return false
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	return err == nil && containsString(car.Drivers, username)
+}
+
+/*
+ * Creates a fleet account with the invoker as first admin.
+ *
+ * On success,
+ * returns the fleet.
+ */
+func (t *CarChaincode) createFleet(stub shim.ChaincodeStubInterface, username string, name string) pb.Response {
+	if name == "" {
+		return errorResponse(ErrInvalidArgument, "'createFleet' expects a non-empty fleet name")
+	}
+
+	_, err := t.getUser(stub, name)
+	if err == nil {
+		return errorResponse(ErrUserExists, fmt.Sprintf("User with username '%s' already exists. Choose another fleet name.", name))
+	}
+
+	_, err = t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fleet := User{
+		Name:    name,
+		Cars:    []string{},
+		Balance: 0,
+		Fleet:   Fleet{Admins: []string{username}, Drivers: []string{}}}
+
+	err = t.addUser(stub, fleet)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fleetAsBytes, _ := ledgerjson.Marshal(fleet)
+	return shim.Success(fleetAsBytes)
+}
+
+/*
+ * Adds an employee to a fleet as admin or driver.
+ *
+ * Arguments required:
+ * [0] Fleet name                  (string)
+ * [1] Username of the employee    (string)
+ * [2] Role                        (string)
+ *     'admin' or 'driver'
+ *
+ * On success,
+ * returns the fleet.
+ */
+func (t *CarChaincode) addFleetMember(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	name := args[0]
+	member := args[1]
+	role := args[2]
+
+	fleet, err := t.getFleetAsAdmin(stub, username, name)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.getUser(stub, member)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	switch role {
+	case fleetAdmin:
+		if isFleetAdmin(&fleet, member) {
+			return errorResponse(ErrAlreadyExists, fmt.Sprintf("User '%s' already administers fleet '%s'", member, name))
+		}
+		fleet.Fleet.Admins = append(fleet.Fleet.Admins, member)
+	case fleetDriver:
+		if containsString(fleet.Fleet.Drivers, member) {
+			return errorResponse(ErrAlreadyExists, fmt.Sprintf("User '%s' already drives for fleet '%s'", member, name))
+		}
+		fleet.Fleet.Drivers = append(fleet.Fleet.Drivers, member)
+	default:
+		return errorResponse(ErrInvalidArgument, "'addFleetMember' expects role 'admin' or 'driver'")
+	}
+
+	err = t.saveUser(stub, fleet)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fleetAsBytes, _ := ledgerjson.Marshal(fleet)
+	return shim.Success(fleetAsBytes)
+}
+
+/*
+ * Assigns a driver of the fleet to a fleet car,
+ * or removes the assignment.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) assignDriver(stub shim.ChaincodeStubInterface, username string, vin string, driver string, assign bool) pb.Response {
+	fleet, err := t.adminFleetOf(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if fleet == nil {
+		return errorResponse(ErrForbidden, "Forbidden: only fleet admins can assign drivers to fleet cars")
+	}
+
+	car, err := t.getCar(stub, fleet.Name, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if assign {
+		if !containsString(fleet.Fleet.Drivers, driver) {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("User '%s' is no driver of fleet '%s'", driver, fleet.Name))
+		} else if containsString(car.Drivers, driver) {
+			return errorResponse(ErrAlreadyExists, fmt.Sprintf("User '%s' is already assigned to this car", driver))
+		}
+		car.Drivers = append(car.Drivers, driver)
+	} else {
+		if !containsString(car.Drivers, driver) {
+			return errorResponse(ErrNotFound, fmt.Sprintf("User '%s' is not assigned to this car", driver))
+		}
+		drivers := []string{}
+		for _, assigned := range car.Drivers {
+			if assigned != driver {
+				drivers = append(drivers, assigned)
+			}
+		}
+		car.Drivers = drivers
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
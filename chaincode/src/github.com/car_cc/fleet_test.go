@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestFleetDrivers(t *testing.T) {
+	garage := "amag"
+	admin := "alice"
+	driver := "dan"
+	fleet := "acme"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	for _, name := range []string{garage, admin, driver, buyer} {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", name, "user"))
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("createFleet", admin, "user", fleet))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addFleetMember", driver, "user", fleet, driver, "admin"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addFleetMember", admin, "user", fleet, driver, "driver"))
+
+	// the fleet buys a car
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", garage, "dot", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", garage, "garage", vin, fleet))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", driver, "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assignDriver", driver, "user", vin, driver))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assignDriver", admin, "user", vin, driver))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(car.Drivers) != 1 || car.Drivers[0] != driver {
+		t.Errorf("Driver should be assigned to the car: %v", car.Drivers)
+	}
+
+	// drivers read the car, but cannot transfer it
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", driver, "user", vin))
+	if response.Status != shim.OK {
+		t.Error("Assigned driver should read the car: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", driver, "user", vin, buyer))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	// nobody invokes as the fleet itself
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", fleet, "user", vin, buyer))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", admin, "user", vin, buyer))
+	car = Car{}
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Certificate.Username != buyer || len(car.Drivers) != 0 {
+		t.Errorf("Fleet admin should have transferred the car without drivers: %v", car)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Garage certification registry.
+ *
+ * The DOT certifies garages for a scope of work, with
+ * 'certifyGarage', until an expiry date. Certifications
+ * are public and kept under
+ * 'garageCertification~<garage>~<scope>'.
+ *
+ * Garages need a current certification for:
+ * - 'inspection': attaching inspection reports, issuing
+ *   trip permits and proposing cars with registration
+ *   data measured in an inspection
+ * - 'emission': recording emission tests as a garage
+ * - 'ev_high_voltage': replacing traction batteries and
+ *   recording battery health
+ *
+ * Owners attaching their own documents and emission
+ * stations, which are certified as oracles, are not
+ * affected.
+ */
+
+// object type of garage certification keys
+const garageCertificationObjectType string = "garageCertification"
+
+// certification scopes
+const scopeInspection string = "inspection"
+const scopeEmission string = "emission"
+const scopeEvHighVoltage string = "ev_high_voltage"
+
+var certificationScopes = []string{scopeInspection, scopeEmission, scopeEvHighVoltage}
+
+/*
+ * Returns the ledger key of the certification
+ * of 'garage' for 'scope'
+ */
+func getGarageCertificationKey(stub shim.ChaincodeStubInterface, garage string, scope string) (string, error) {
+	key, err := stub.CreateCompositeKey(garageCertificationObjectType, []string{garage, scope})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating garage certification key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the certification of 'garage' for 'scope'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getGarageCertification(stub shim.ChaincodeStubInterface, garage string, scope string) (*GarageCertification, error) {
+	key, err := getGarageCertificationKey(stub, garage, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	certificationAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading garage certification")
+	} else if certificationAsBytes == nil {
+		return nil, nil
+	}
+
+	certification := GarageCertification{}
+	err = ledgerjson.Unmarshal(certificationAsBytes, &certification)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing garage certification")
+	}
+
+	return &certification, nil
+}
+
+/*
+ * Writes a garage certification to ledger
+ */
+func saveGarageCertification(stub shim.ChaincodeStubInterface, certification *GarageCertification) error {
+	key, err := getGarageCertificationKey(stub, certification.Garage, certification.Scope)
+	if err != nil {
+		return err
+	}
+
+	certificationAsBytes, _ := ledgerjson.Marshal(certification)
+	err = stub.PutState(key, certificationAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing garage certification")
+	}
+
+	return nil
+}
+
+/*
+ * Checks if a certification is valid at 'now'
+ */
+func IsCertificationCurrent(certification *GarageCertification, now int64) bool {
+	return certification != nil && certification.RevokedTs == 0 && now < certification.ExpiresTs
+}
+
+/*
+ * Fails unless 'garage' holds a current
+ * certification for 'scope'
+ */
+func requireCertification(stub shim.ChaincodeStubInterface, garage string, scope string) error {
+	certification, err := getGarageCertification(stub, garage, scope)
+	if err != nil {
+		return err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	if !IsCertificationCurrent(certification, now) {
+		return newErrorWithDetails(ErrForbidden, fmt.Sprintf("Forbidden: garage '%s' holds no current '%s' certification", garage, scope), map[string]string{"scope": scope})
+	}
+
+	return nil
+}
+
+/*
+ * Checks if a registration proposal carries data
+ * measured in an inspection, like the tested max speed
+ */
+func hasInspectionData(proposal *RegistrationProposal) bool {
+	return proposal.NumberOfDoors != "" || proposal.NumberOfCylinders != 0 || proposal.NumberOfAxis != 0 || proposal.MaxSpeed != 0
+}
+
+/*
+ * Fails unless 'garage' may propose 'proposal',
+ * see 'hasInspectionData'
+ */
+func requireProposalCertification(stub shim.ChaincodeStubInterface, garage string, proposal *RegistrationProposal) error {
+	if !hasInspectionData(proposal) {
+		return nil
+	}
+
+	return requireCertification(stub, garage, scopeInspection)
+}
+
+/*
+ * Certifies 'garage' for 'scope' until 'expiresTs',
+ * replacing an earlier certification for the scope.
+ *
+ * On success,
+ * returns the certification.
+ */
+func (t *CarChaincode) certifyGarage(stub shim.ChaincodeStubInterface, issuer string, garage string, scope string, expiresTs int64, reference string) pb.Response {
+	if !containsString(certificationScopes, scope) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'certifyGarage' expects one of the scopes %s", strings.Join(certificationScopes, ", ")))
+	} else if reference == "" {
+		return errorResponse(ErrInvalidArgument, "'certifyGarage' expects a non-empty certificate reference")
+	}
+
+	_, err := t.getUser(stub, garage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if expiresTs <= now {
+		return errorResponse(ErrInvalidArgument, "'certifyGarage' expects an expiry in the future")
+	}
+
+	certification := GarageCertification{
+		Garage:    garage,
+		Scope:     scope,
+		Reference: reference,
+		IssuedBy:  issuer,
+		IssuedTs:  now,
+		ExpiresTs: expiresTs}
+
+	err = saveGarageCertification(stub, &certification)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Garage '%s' is certified for '%s' until %d\n", garage, scope, expiresTs)
+
+	certificationAsBytes, _ := ledgerjson.Marshal(certification)
+	return shim.Success(certificationAsBytes)
+}
+
+/*
+ * Revokes the certification of 'garage' for 'scope'.
+ *
+ * On success,
+ * returns the revoked certification.
+ */
+func (t *CarChaincode) revokeGarageCertification(stub shim.ChaincodeStubInterface, garage string, scope string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'revokeGarageCertification' expects a non-empty reason")
+	}
+
+	certification, err := getGarageCertification(stub, garage, scope)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if certification == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("Garage '%s' is not certified for '%s'", garage, scope))
+	} else if certification.RevokedTs != 0 {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("The '%s' certification of garage '%s' is revoked already", scope, garage))
+	}
+
+	certification.RevokedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	certification.RevocationReason = reason
+
+	err = saveGarageCertification(stub, certification)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	certificationAsBytes, _ := ledgerjson.Marshal(certification)
+	return shim.Success(certificationAsBytes)
+}
+
+/*
+ * Lists the certifications of 'garage', including
+ * expired and revoked ones.
+ *
+ * On success,
+ * returns the certifications by scope.
+ */
+func (t *CarChaincode) getGarageCertifications(stub shim.ChaincodeStubInterface, garage string) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(garageCertificationObjectType, []string{garage})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading garage certifications")
+	}
+	defer iterator.Close()
+
+	certifications := []GarageCertification{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading garage certifications")
+		}
+
+		certification := GarageCertification{}
+		err = ledgerjson.Unmarshal(kv.Value, &certification)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing garage certification")
+		}
+
+		certifications = append(certifications, certification)
+	}
+
+	certificationsAsBytes, _ := ledgerjson.Marshal(certifications)
+	return shim.Success(certificationsAsBytes)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func certify(stub *shim.MockStub, garage string, scope string) {
+	expiry := strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", garage, scope, expiry, "C-"+garage))
+}
+
+func TestGarageCertification(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	report := strings.Repeat("ab", 32)
+	ts := func(days int) string {
+		return strconv.FormatInt(time.Now().Add(time.Duration(days)*24*time.Hour).Unix(), 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+
+	// uncertified garages do no high-voltage work
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partBattery, "B-1"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partEngine, "E-1"))
+	if response.Status != shim.OK {
+		t.Fatal("Replacing an engine should need no certification: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", garage, "garage", vin, "inspection_report", report))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", garage, "garage", garage, scopeEvHighVoltage, ts(365), "HV-17"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", garage, scopeEvHighVoltage, ts(-1), "HV-17"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", "nobody", scopeEvHighVoltage, ts(365), "HV-17"))
+	expectErrorCode(t, response, ErrUserNotFound)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", garage, scopeEvHighVoltage, ts(365), "HV-17"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", garage, scopeInspection, ts(365), "IN-4"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partBattery, "B-1"))
+	if response.Status != shim.OK {
+		t.Fatal("Certified garage should be able to replace the battery: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", garage, "garage", vin, "inspection_report", report))
+	if response.Status != shim.OK {
+		t.Fatal("Certified garage should be able to attach an inspection report: " + response.Message)
+	}
+
+	// the registry is public
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getGarageCertifications", "bobby", "user", garage))
+	certifications := []GarageCertification{}
+	json.Unmarshal(response.Payload, &certifications)
+	if len(certifications) != 2 || certifications[0].Scope != scopeEvHighVoltage || certifications[1].Scope != scopeInspection {
+		t.Fatalf("Expected the 'ev_high_voltage' and 'inspection' certifications, got %v", certifications)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revokeGarageCertification", "inspector", "dot", garage, scopeEvHighVoltage, "failed audit"))
+	certification := GarageCertification{}
+	json.Unmarshal(response.Payload, &certification)
+	if certification.RevokedTs == 0 || certification.RevocationReason != "failed audit" {
+		t.Errorf("Unexpected revoked certification: %v", certification)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partBattery, "B-2"))
+	expectErrorCode(t, response, ErrForbidden)
+}
+
+func TestProposalCertification(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+	data := `{ "number_of_doors": "4+1", "max_speed": 180 }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	// uncertified garages propose cars without inspection data only
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`, data))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	if response.Status != shim.OK {
+		t.Fatal("Proposals without inspection data should need no certification: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("amendProposal", garage, "garage", vin, data))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stock := `[ { "car": { "vin": "` + otherVin + `" }, "registration_proposal": ` + data + ` } ]`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("bulkImportCars", garage, "garage", stock))
+	expectErrorCode(t, response, ErrForbidden)
+
+	certify(stub, garage, scopeInspection)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("amendProposal", garage, "garage", vin, data))
+	if response.Status != shim.OK {
+		t.Fatal("Certified garage should be able to amend with inspection data: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("bulkImportCars", garage, "garage", stock))
+	if response.Status != shim.OK {
+		t.Fatal("Certified garage should be able to import proposals with inspection data: " + response.Message)
+	}
+}
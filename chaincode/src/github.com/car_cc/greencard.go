@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * International insurance proof.
+ *
+ * Instead of a paper green card, the insurer of a car
+ * under a full policy issues a proof of insurance for a
+ * list of countries with 'issueInsuranceProof'. The proof
+ * is valid from its issue until the given end, at most the
+ * end of the policy, and refers to the policy by the hash
+ * of its document. A new proof replaces the earlier one.
+ *
+ * Border control checks it with 'verifyInsuranceProof',
+ * optionally for the country being entered. A proof is
+ * only valid while the insurer who issued it still
+ * insures the car under the same policy.
+ */
+
+// validity of a proof for a policy without an end
+const defaultInsuranceProofDays int = 365
+
+// ISO 3166 alpha-2 country codes
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+/*
+ * Checks the insurance proof of 'car' at 'now' for 'country',
+ * '' for any. Returns why it is not valid, '' if it is.
+ */
+func insuranceProofProblem(car *Car, country string, now int64) string {
+	proof := car.InsuranceProof
+	if proof == nil {
+		return "no insurance proof"
+	} else if proof.Insurer != car.Certificate.Insurer || proof.PolicyReference != car.Policy.DocumentHash || !IsInsuredByPolicy(car, now) {
+		return "policy of the proof no longer in force"
+	} else if now < proof.ValidFromTs || now >= proof.ValidUntilTs {
+		return "outside the validity of the proof"
+	}
+
+	if country == "" {
+		return ""
+	}
+
+	for _, covered := range proof.Countries {
+		if covered == country {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("'%s' not covered", country)
+}
+
+/*
+ * Issues a proof of insurance of car 'vin' for 'countries'
+ * until 'validUntilTs', 0 for the end of the policy.
+ *
+ * On success,
+ * returns the proof.
+ */
+func (t *CarChaincode) issueInsuranceProof(stub shim.ChaincodeStubInterface, insurer string, vin string, countriesJson string, validUntilTs int64) pb.Response {
+	countries := []string{}
+	err := ledgerjson.Unmarshal([]byte(countriesJson), &countries)
+	if err != nil || len(countries) == 0 {
+		return errorResponse(ErrInvalidArgument, "'issueInsuranceProof' expects a non-empty list of countries as json")
+	}
+
+	for _, country := range countries {
+		if !countryCodePattern.MatchString(country) {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("'issueInsuranceProof' expects two letter country codes, got '%s'", country))
+		}
+	}
+
+	err = requireAccreditedInsurer(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Certificate.Insurer != insurer {
+		return errorResponse(ErrForbidden, "Forbidden: the car is not insured by you")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// cover notes are not proven abroad
+	if !IsInsuredByPolicy(&car, now) {
+		return errorResponse(ErrInvalidState, "The car has no policy in force")
+	}
+
+	end := car.Policy.EndTs
+	if end == 0 {
+		end = now + int64(defaultInsuranceProofDays)*secondsPerDay
+	}
+
+	if validUntilTs == 0 {
+		validUntilTs = end
+	} else if validUntilTs <= now || validUntilTs > end {
+		return errorResponse(ErrInvalidArgument, "'issueInsuranceProof' expects the end of validity within the policy")
+	}
+
+	car.InsuranceProof = &InsuranceProof{
+		Number:          stub.GetTxID(),
+		Insurer:         insurer,
+		PolicyReference: car.Policy.DocumentHash,
+		Countries:       countries,
+		ValidFromTs:     now,
+		ValidUntilTs:    validUntilTs}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Insurance proof for %v issued on car '%s' by '%s'\n", countries, vin, insurer)
+
+	proofAsBytes, _ := ledgerjson.Marshal(car.InsuranceProof)
+	return shim.Success(proofAsBytes)
+}
+
+/*
+ * Verifies the insurance proof of car 'vin',
+ * for entering 'country' if not ''.
+ *
+ * On success,
+ * returns the check with the proof, if any.
+ */
+func (t *CarChaincode) verifyInsuranceProof(stub shim.ChaincodeStubInterface, vin string, country string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	problem := insuranceProofProblem(&car, country, now)
+	check := InsuranceProofCheck{
+		Vin:         vin,
+		Numberplate: car.Certificate.Numberplate,
+		Country:     country,
+		Valid:       problem == "",
+		Reason:      problem,
+		Proof:       car.InsuranceProof}
+
+	checkAsBytes, _ := ledgerjson.Marshal(check)
+	return shim.Success(checkAsBytes)
+}
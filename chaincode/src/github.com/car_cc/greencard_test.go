@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInsuranceProof(t *testing.T) {
+	vin := "WVWZZZ6R6HY260780"
+	policyHash := strings.Repeat("ab", 32)
+	ts := func(days int) string {
+		return strconv.FormatInt(time.Now().Add(time.Duration(days)*24*time.Hour).Unix(), 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, "amag", vin, "axa")
+	end := ts(30)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", vin, ts(-1), end, policyHash))
+	verify := func(country string) InsuranceProofCheck {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyInsuranceProof", "customs", "border_control", vin, country))
+		check := InsuranceProofCheck{}
+		json.Unmarshal(response.Payload, &check)
+		return check
+	}
+
+	if check := verify(""); check.Valid || check.Proof != nil {
+		t.Errorf("Expected no proof before it is issued, got %v", check)
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("issueInsuranceProof", "axa", "insurer", vin, `["Germany"]`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueInsuranceProof", "axa", "insurer", vin, `["DE", "FR"]`, ts(60)))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueInsuranceProof", "axa", "insurer", vin, `["DE", "FR"]`))
+	proof := InsuranceProof{}
+	json.Unmarshal(response.Payload, &proof)
+	if proof.PolicyReference != policyHash || strconv.FormatInt(proof.ValidUntilTs, 10) != end {
+		t.Fatalf("Expected a proof until the end of the policy, got %v: %s", proof, response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyInsuranceProof", "officer", "police", vin))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	if check := verify("DE"); !check.Valid || check.Proof == nil {
+		t.Errorf("Expected a valid proof for 'DE', got %v", check)
+	}
+	if check := verify("IT"); check.Valid || check.Reason == "" {
+		t.Errorf("Expected no valid proof for 'IT', got %v", check)
+	}
+
+	// a proof lapses with the policy
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", "amag", "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if insuranceProofProblem(&car, "", proof.ValidUntilTs) == "" {
+		t.Error("Proof should lapse at the end of the policy")
+	}
+
+	// as does one of an earlier policy
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", vin, ts(-1), end, strings.Repeat("cd", 32)))
+	if check := verify("DE"); check.Valid {
+		t.Error("Proof should not be valid for a replaced policy")
+	}
+}
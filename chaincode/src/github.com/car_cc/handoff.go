@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Handoff of a car between two channels.
+ *
+ * Writes through 'InvokeChaincode' on another channel are
+ * never committed, so a car cannot move in one transaction.
+ * Instead both registries run a two-phase protocol and check
+ * each others state with read-only chaincode calls:
+ *
+ *  1. source 'lockHandoff'      car is locked on the source channel
+ *  2. target 'acceptHandoff'    target copies the locked car as pending
+ *  3. source 'confirmHandoff'   source sees the pending copy and releases the car
+ *  4. target 'activateHandoff'  target sees the release and activates the car
+ *
+ * A locked car can be unlocked with 'abortHandoff' as long
+ * as the target reports no pending copy. At no point is the
+ * car active on both channels.
+ */
+
+// handoff states
+const handoffLocked string = "locked"
+const handoffReleased string = "released"
+const handoffPending string = "pending"
+
+// role of a registry calling another registry
+const registryRole string = "registry"
+
+/*
+ * Checks if a car can be traded and used on this channel.
+ */
+func IsActive(car *Car) bool {
+	active := car.Handoff.Status == ""
+
+	if !active {
+		fmt.Printf("Car with VIN '%s' is not active, handoff is '%s'\n", car.Vin, car.Handoff.Status)
+	}
+
+	return active
+}
+
+/*
+ * Hashes a car without its handoff state, so both
+ * channels compute the same hash for the same car.
+ */
+func hashHandoffCar(car Car) string {
+	car.Handoff = Handoff{}
+	return hashCar(car)
+}
+
+/*
+ * Checks the handoff hash of a car locked on another
+ * channel, which may still run an older chaincode,
+ * see 'matchesCarHash'
+ */
+func matchesHandoffHash(car Car) bool {
+	hash := car.Handoff.Hash
+	car.Handoff = Handoff{}
+	return matchesCarHash(hash, car)
+}
+
+/*
+ * Reads a car on another channel through its car chaincode.
+ * Fails with 'ErrCarNotFound' only if the other channel
+ * reports that it has no such car.
+ */
+func (t *CarChaincode) queryOtherChannel(stub shim.ChaincodeStubInterface, handoff Handoff, vin string) (Car, error) {
+	args := [][]byte{[]byte("getHandoff"), []byte(handoff.Channel), []byte(registryRole), []byte(vin)}
+	response := stub.InvokeChaincode(handoff.Chaincode, args, handoff.Channel)
+	if response.Status != shim.OK {
+		if errorFromResponse(response).Code == ErrCarNotFound {
+			return Car{}, newError(ErrCarNotFound, fmt.Sprintf("Car with VIN '%s' does not exist on channel '%s'", vin, handoff.Channel))
+		}
+		return Car{}, newError(ErrLedger, "Error reading car on channel '"+handoff.Channel+"': "+response.Message)
+	}
+
+	car := Car{}
+	err := ledgerjson.Unmarshal(response.Payload, &car)
+	if err != nil {
+		return Car{}, newError(ErrLedger, "Error parsing car from channel '"+handoff.Channel+"'")
+	}
+
+	return car, nil
+}
+
+/*
+ * Reads a car regardless of its owner
+ */
+func (t *CarChaincode) getHandoffCar(stub shim.ChaincodeStubInterface, vin string) (Car, string, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return Car{}, "", err
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return Car{}, "", newError(ErrCarNotFound, "Failed to fetch car with vin '"+vin+"' from ledger")
+	}
+
+	return car, owner, nil
+}
+
+/*
+ * Writes a car with updated handoff state back to ledger
+ */
+func (t *CarChaincode) saveHandoffCar(stub shim.ChaincodeStubInterface, car Car) pb.Response {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Returns a car together with its handoff state.
+ *
+ * Called by the registry on the other channel.
+ */
+func (t *CarChaincode) getHandoff(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Step 1, on the source channel:
+ * locks a car for the move to another channel.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Target channel              (string)
+ * [2] Car chaincode on target     (string)
+ *
+ * On success,
+ * returns the locked car.
+ */
+func (t *CarChaincode) lockHandoff(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	vin := args[0]
+	channel := args[1]
+	chaincode := args[2]
+
+	if channel == "" || chaincode == "" {
+		return errorResponse(ErrInvalidArgument, "'lockHandoff' expects a non-empty target channel and chaincode")
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if !IsActive(&car) {
+		return errorResponse(ErrNotActive, fmt.Sprintf("Car with VIN '%s' is already handed off", vin))
+	}
+
+	car.Handoff = Handoff{
+		Status:    handoffLocked,
+		Channel:   channel,
+		Chaincode: chaincode,
+		Owner:     owner,
+		Hash:      hashHandoffCar(car)}
+
+	return t.saveHandoffCar(stub, car)
+}
+
+/*
+ * Step 2, on the target channel:
+ * copies a locked car from the source channel as pending.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Source channel              (string)
+ * [2] Car chaincode on source     (string)
+ *
+ * On success,
+ * returns the pending car.
+ */
+func (t *CarChaincode) acceptHandoff(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	vin := args[0]
+	source := Handoff{Channel: args[1], Chaincode: args[2]}
+
+	// the car must not exist here yet
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner != "" {
+		return errorResponse(ErrCarExists, fmt.Sprintf("Car with vin '%s' already exists on this channel", vin))
+	}
+
+	car, err := t.queryOtherChannel(stub, source, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the source must have locked the car for us
+	if car.Handoff.Status != handoffLocked {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not locked for a handoff", vin))
+	} else if !matchesHandoffHash(car) {
+		return errorResponse(ErrInvalidArgument, "Handoff hash does not match the locked car")
+	}
+
+	// keep the car pending until the source released it
+	car.Handoff = Handoff{
+		Status:    handoffPending,
+		Channel:   source.Channel,
+		Chaincode: source.Chaincode,
+		Owner:     car.Handoff.Owner,
+		Hash:      car.Handoff.Hash}
+
+	pseudonym, err := registerPseudonym(stub, car.Handoff.Owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = indexCar(stub, car.Vin, pseudonym)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	return t.saveHandoffCar(stub, car)
+}
+
+/*
+ * Step 3, on the source channel:
+ * releases a locked car once the target holds a pending copy.
+ * The car stays on this channel as a record, but is
+ * removed from the owners list of cars.
+ *
+ * On success,
+ * returns the released car.
+ */
+func (t *CarChaincode) confirmHandoff(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if car.Handoff.Status != handoffLocked {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not locked for a handoff", vin))
+	}
+
+	target, err := t.queryOtherChannel(stub, car.Handoff, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if target.Handoff.Status != handoffPending || target.Handoff.Hash != car.Handoff.Hash {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not pending on channel '%s'", vin, car.Handoff.Channel))
+	}
+
+	err = removeOwnership(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Handoff.Status = handoffReleased
+	return t.saveHandoffCar(stub, car)
+}
+
+/*
+ * Step 4, on the target channel:
+ * activates a pending car once the source released it.
+ *
+ * On success,
+ * returns the active car.
+ */
+func (t *CarChaincode) activateHandoff(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if car.Handoff.Status != handoffPending {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not pending", vin))
+	}
+
+	source, err := t.queryOtherChannel(stub, car.Handoff, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if source.Handoff.Status != handoffReleased {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not yet released on channel '%s'", vin, car.Handoff.Channel))
+	}
+
+	// hand the car over to its owner
+	_, _, err = t.ensureOwner(stub, owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = addOwnership(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Handoff = Handoff{}
+	return t.saveHandoffCar(stub, car)
+}
+
+/*
+ * Unlocks a car on the source channel, as long as
+ * the target channel holds no pending copy of it.
+ * A car stays locked while the target cannot be read.
+ *
+ * On success,
+ * returns the active car.
+ */
+func (t *CarChaincode) abortHandoff(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if car.Handoff.Status != handoffLocked {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is not locked for a handoff", vin))
+	}
+
+	// only a target without the car never accepted it,
+	// an unreachable target may hold a pending copy
+	target, err := t.queryOtherChannel(stub, car.Handoff, vin)
+	if err != nil {
+		if ccErr, ok := err.(*ChaincodeError); !ok || ccErr.Code != ErrCarNotFound {
+			return errorResponseFrom(err)
+		}
+	} else if target.Handoff.Status == handoffPending {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' is already pending on channel '%s'", vin, car.Handoff.Channel))
+	}
+
+	car.Handoff = Handoff{}
+	return t.saveHandoffCar(stub, car)
+}
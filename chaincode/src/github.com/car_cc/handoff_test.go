@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Registry on a channel that cannot be read
+type unavailableChaincode struct{}
+
+func (c *unavailableChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *unavailableChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Error("channel unavailable")
+}
+
+func TestCrossChannelHandoff(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	// two registries on two channels, which can read each other
+	swiss := shim.NewMockStub("car", &CarChaincode{})
+	german := shim.NewMockStub("car", &CarChaincode{})
+	swiss.MockPeerChaincode("car/de", german)
+	german.MockPeerChaincode("car/ch", swiss)
+
+	ccSetup(t, swiss)
+	ccSetup(t, german)
+
+	insureCar(t, swiss, username, vin, "axa")
+
+	// the target cannot accept a car that is not locked
+	response := german.MockInvoke(uuid, util.ToChaincodeArgs("acceptHandoff", "TESTING", "dot", vin, "ch", "car"))
+	if response.Status == shim.OK {
+		t.Error("Only locked cars can be accepted")
+	}
+
+	// 1. lock on the source channel
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("lockHandoff", "TESTING", "dot", vin, "de", "car"))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if IsActive(&car) {
+		t.Error("Locked car should not be active")
+	}
+
+	// a locked car cannot be transferred
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("transfer", username, "user", vin, "bobby"))
+	if response.Status == shim.OK {
+		t.Error("Locked cars cannot be transferred")
+	}
+
+	// releasing before the target accepted is not possible
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("confirmHandoff", "TESTING", "dot", vin))
+	if response.Status == shim.OK {
+		t.Error("Source cannot release a car the target does not hold")
+	}
+
+	// 2. accept on the target channel
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("acceptHandoff", "TESTING", "dot", vin, "ch", "car"))
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if IsActive(&car) || car.Handoff.Status != handoffPending {
+		t.Error("Accepted car should be pending")
+	}
+
+	// activating before the source released is not possible
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("activateHandoff", "TESTING", "dot", vin))
+	if response.Status == shim.OK {
+		t.Error("Target cannot activate a car the source did not release")
+	}
+
+	// aborting is no longer possible either
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("abortHandoff", "TESTING", "dot", vin))
+	if response.Status == shim.OK {
+		t.Error("Cars pending on the target cannot be unlocked")
+	}
+
+	// 3. release on the source channel
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("confirmHandoff", "TESTING", "dot", vin))
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Handoff.Status != handoffReleased {
+		t.Error("Car should be released on the source channel")
+	}
+
+	// 4. activate on the target channel
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("activateHandoff", "TESTING", "dot", vin))
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if !IsActive(&car) || car.Certificate.Username != username {
+		t.Error("Car should be active for its owner on the target channel")
+	}
+
+	// the owner can read the car on the target channel
+	response = german.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// the owner became a user like any new user, but
+	// without the identity of the registry activating
+	owner, err := (&CarChaincode{}).getUser(german, username)
+	if err != nil {
+		t.Fatal(err)
+	} else if owner.Balance != newUserCredits || owner.ClaimsHistory.SinceTs == 0 || owner.Identity != "" {
+		t.Errorf("Unexpected owner on the target channel: %v", owner)
+	}
+
+	userIndex, _ := (&CarChaincode{}).getUserIndex(german)
+	if userIndex[username] != username {
+		t.Errorf("Expected '%s' in the user index of the target channel", username)
+	}
+}
+
+func TestAbortHandoff(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	swiss := shim.NewMockStub("car", &CarChaincode{})
+	german := shim.NewMockStub("car", &CarChaincode{})
+	french := shim.NewMockStub("car", &unavailableChaincode{})
+	swiss.MockPeerChaincode("car/de", german)
+	swiss.MockPeerChaincode("car/fr", french)
+
+	ccSetup(t, swiss)
+	ccSetup(t, german)
+
+	insureCar(t, swiss, username, vin, "axa")
+	insureCar(t, swiss, username, otherVin, "axa")
+
+	// the car stays locked while the target cannot tell
+	response := swiss.MockInvoke(uuid, util.ToChaincodeArgs("lockHandoff", "TESTING", "dot", vin, "fr", "car"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("abortHandoff", "TESTING", "dot", vin))
+	expectErrorCode(t, response, ErrLedger)
+
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("getHandoff", "TESTING", "dot", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Handoff.Status != handoffLocked {
+		t.Errorf("Car should still be locked, handoff is %v", car.Handoff)
+	}
+
+	// a target without the car never accepted it
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("lockHandoff", "TESTING", "dot", otherVin, "de", "car"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = swiss.MockInvoke(uuid, util.ToChaincodeArgs("abortHandoff", "TESTING", "dot", otherVin))
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if !IsActive(&car) {
+		t.Error("Car should be active again on the source channel")
+	}
+}
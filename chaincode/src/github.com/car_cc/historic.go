@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Historic vehicles.
+ *
+ * The DOT classifies cars older than the configured
+ * 'historic_age_years' as historic vehicles with
+ * 'classifyHistoric'. Historic cars are confirmed without
+ * a current emission test, and their listings carry the
+ * 'historic' badge.
+ *
+ * A historic car loses the classification when it is
+ * substantially modified, i.e. a garage installs another
+ * engine or a traction battery, or when the DOT calls
+ * 'declassifyHistoric'.
+ */
+
+// cars this old can be classified as historic by default
+const defaultHistoricAgeYears int = 30
+
+// listing badge of historic cars
+const badgeHistoric string = "historic"
+
+// part types whose replacement ends the historic classification
+var historicModifications = []string{partEngine, partBattery}
+
+/*
+ * Checks if 'car' is classified as historic vehicle
+ */
+func IsHistoric(car *Car) bool {
+	return car.Historic.ClassifiedTs != 0 && car.Historic.DeclassifiedTs == 0
+}
+
+/*
+ * Returns the age a car needs to be classified as historic, in seconds
+ */
+func historicAge(config Config) int64 {
+	years := config.HistoricAgeYears
+	if years <= 0 {
+		years = defaultHistoricAgeYears
+	}
+
+	return int64(years) * 365 * secondsPerDay
+}
+
+/*
+ * Ends the historic classification of 'car'
+ */
+func declassify(car *Car, by string, reason string, now int64) {
+	car.Historic.DeclassifiedBy = by
+	car.Historic.DeclassifiedTs = now
+	car.Historic.Reason = reason
+	refreshListingBadges(car)
+}
+
+/*
+ * Classifies car 'vin' as historic vehicle.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) classifyHistoric(stub shim.ChaincodeStubInterface, officer string, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsHistoric(&car) {
+		return errorResponse(ErrInvalidState, "Car is classified as historic already")
+	} else if IsWrittenOff(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is classified as '%s' and cannot be historic", car.Classification))
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if now-car.CreatedTs < historicAge(config) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is younger than %d years and cannot be historic", historicAge(config)/(365*secondsPerDay)))
+	}
+
+	car.Historic = HistoricClassification{ClassifiedBy: officer, ClassifiedTs: now}
+
+	fmt.Printf("Car '%s' classified as historic by '%s'\n", vin, officer)
+	return t.saveListedCar(stub, &car)
+}
+
+/*
+ * Ends the historic classification of car 'vin' for 'reason'.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) declassifyHistoric(stub shim.ChaincodeStubInterface, officer string, vin string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'declassifyHistoric' expects a non-empty reason")
+	}
+
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsHistoric(&car) {
+		return errorResponse(ErrInvalidState, "Car is not classified as historic")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	declassify(&car, officer, reason, now)
+
+	fmt.Printf("Car '%s' declassified by '%s': %s\n", vin, officer, reason)
+	return t.saveListedCar(stub, &car)
+}
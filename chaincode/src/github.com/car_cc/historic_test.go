@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestHistoricVehicle(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	car := insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", owner, "garage", vin, "25000"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("classifyHistoric", "inspector", "dot", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// make the car 31 years old
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "garage", vin))
+	json.Unmarshal(response.Payload, &car)
+	stub.MockTransactionStart(uuid)
+	car.CreatedTs -= 31 * 365 * secondsPerDay
+	carAsBytes, _ := json.Marshal(car)
+	stub.PutState(vin, carAsBytes)
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("classifyHistoric", owner, "garage", vin))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("classifyHistoric", "inspector", "dot", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if !IsHistoric(&car) || len(car.Listing.Badges) != 1 || car.Listing.Badges[0] != badgeHistoric {
+		t.Fatalf("Expected a historic car with listing badge, got %s", response.Message)
+	}
+
+	// historic cars are confirmed without emission test
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	if response.Status != shim.OK {
+		t.Error("Historic car should be confirmable: " + response.Message)
+	}
+
+	// a new engine ends the classification
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", owner, "garage", vin, partGearbox, "G-1"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", owner, "garage", vin, partEngine, "E-1"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "garage", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if IsHistoric(&car) || car.Historic.DeclassifiedBy != owner || len(car.Listing.Badges) != 0 {
+		t.Errorf("Expected the engine swap to end the classification, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("declassifyHistoric", "inspector", "dot", vin, "modified"))
+	expectErrorCode(t, response, ErrInvalidState)
+}
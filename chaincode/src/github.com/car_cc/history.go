@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Reads all committed revisions of a car, oldest first,
+ * revisions of the same second by transaction id.
+ *
+ * The history comes from the history database of the
+ * peer, so it needs 'enableHistoryDatabase' in the
+ * ledger configuration.
+ *
+ * On success,
+ * returns the car history.
+ */
+func (t *CarChaincode) getCarHistory(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	vinErr := ValidateVin(vin)
+	if vinErr != nil {
+		return errorResponseFrom(vinErr)
+	}
+
+	allowed, err := t.canRead(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !allowed {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
+	}
+
+	iterator, err := stub.GetHistoryForKey(vin)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading car history")
+	}
+	defer iterator.Close()
+
+	history := []CarRevision{}
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading car history")
+		}
+
+		revision := CarRevision{TxId: modification.TxId, Deleted: modification.IsDelete}
+		if modification.Timestamp != nil {
+			revision.Ts = modification.Timestamp.Seconds
+		}
+
+		if !modification.IsDelete {
+			car := Car{}
+			err = ledgerjson.Unmarshal(modification.Value, &car)
+			if err != nil {
+				return errorResponse(ErrLedger, "Error parsing car history")
+			}
+			revision.Car = &car
+		}
+
+		history = append(history, revision)
+	}
+
+	// the history database does not promise an order
+	sort.Sort(revisionsByTs(history))
+
+	historyAsBytes, _ := ledgerjson.Marshal(history)
+	return shim.Success(historyAsBytes)
+}
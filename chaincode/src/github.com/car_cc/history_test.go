@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Iterates over fixed key modifications
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (i *historyIterator) HasNext() bool { return len(i.modifications) > 0 }
+func (i *historyIterator) Close() error  { return nil }
+func (i *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := i.modifications[0]
+	i.modifications = i.modifications[1:]
+	return modification, nil
+}
+
+// Returns the modifications of 'historyChaincode' as
+// history of every key, the mock stub has none
+type historyStub struct {
+	shim.ChaincodeStubInterface
+	modifications []*queryresult.KeyModification
+}
+
+func (s *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: s.modifications}, nil
+}
+
+// Invokes through a 'historyStub'
+type historyChaincode struct {
+	CarChaincode
+	modifications []*queryresult.KeyModification
+}
+
+func (c *historyChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return c.CarChaincode.Invoke(&historyStub{ChaincodeStubInterface: stub, modifications: c.modifications})
+}
+
+func TestCarHistoryAccess(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getCarHistory", owner, "user", "WVWZZZ6R6HY26078"))
+	expectErrorCode(t, response, ErrVinLength)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getCarHistory", "bobby", "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	// the mock stub has no history database
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getCarHistory", owner, "user", vin))
+	expectErrorCode(t, response, ErrLedger)
+}
+
+func TestCarHistoryOrder(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	cc := &historyChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	// the history database returns the revisions out of
+	// order, two of them were committed in the same second
+	carAsBytes, _ := json.Marshal(Car{Vin: vin})
+	cc.modifications = []*queryresult.KeyModification{
+		{TxId: "c", Value: carAsBytes, Timestamp: &timestamp.Timestamp{Seconds: 1500000300}},
+		{TxId: "b", IsDelete: true, Timestamp: &timestamp.Timestamp{Seconds: 1500000100}},
+		{TxId: "a", Value: carAsBytes, Timestamp: &timestamp.Timestamp{Seconds: 1500000100}},
+	}
+
+	first := stub.MockInvoke(uuid, util.ToChaincodeArgs("getCarHistory", owner, "user", vin))
+	second := stub.MockInvoke(uuid, util.ToChaincodeArgs("getCarHistory", owner, "user", vin))
+	if string(first.Payload) != string(second.Payload) {
+		t.Error("Reading the car history twice should return the same result")
+	}
+
+	history := []CarRevision{}
+	err := json.Unmarshal(first.Payload, &history)
+	if err != nil {
+		t.Fatal(first.Message)
+	}
+
+	if len(history) != 3 || history[0].TxId != "a" || history[1].TxId != "b" || history[2].TxId != "c" {
+		t.Fatalf("Expected the revisions oldest first, then by transaction id, got %v", history)
+	}
+
+	if history[0].Car == nil || history[0].Car.Vin != vin || !history[1].Deleted || history[1].Car != nil {
+		t.Errorf("Unexpected revisions: %v", history)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Identity binding.
+ *
+ * A username is bound to the certificate of the client
+ * that created it. Only the hash of the serialized identity
+ * (MSP id and certificate) is stored on the ledger.
+ */
+
+/*
+ * Returns the hash of the invoking client identity,
+ * or an empty string if the invoker is unknown.
+ */
+func getCallerIdentity(stub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return "", newError(ErrInternal, "Error reading the invoking client identity")
+	} else if len(creator) == 0 {
+		return "", nil
+	}
+
+	hash := sha256.Sum256(creator)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+/*
+ * Checks that the invoker owns the username.
+ *
+ * Names without a user, like the DOT or insurers
+ * acting by role, and users created before identity
+ * binding have no identity to check against.
+ */
+func (t *CarChaincode) checkIdentity(stub shim.ChaincodeStubInterface, username string) error {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return nil
+	} else if IsFleet(&user) {
+		return newError(ErrForbidden, fmt.Sprintf("Forbidden: fleet '%s' acts through its admins", username))
+	} else if user.Identity == "" {
+		return nil
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return err
+	} else if caller != user.Identity {
+		return newError(ErrIdentityMismatch, fmt.Sprintf("Forbidden: username '%s' is bound to another identity", username))
+	}
+
+	return nil
+}
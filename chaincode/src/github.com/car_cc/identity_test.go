@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// mock stub invoked by a client with identity 'creator'
+type identityStub struct {
+	*shim.MockStub
+	creator []byte
+	args    []string
+}
+
+func (s *identityStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *identityStub) GetFunctionAndParameters() (string, []string) {
+	return s.args[0], s.args[1:]
+}
+
+func invokeAs(stub *shim.MockStub, creator string, args ...string) pb.Response {
+	stub.MockTransactionStart(uuid)
+	defer stub.MockTransactionEnd(uuid)
+
+	return (&CarChaincode{}).Invoke(&identityStub{stub, []byte(creator), args})
+}
+
+func TestIdentityBinding(t *testing.T) {
+	username := "amag"
+	alice := "Org1MSP alice certificate"
+	mallory := "Org1MSP mallory certificate"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	response := invokeAs(stub, alice, "createUser", username, "garage")
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// the username is bound to alice
+	response = invokeAs(stub, alice, "readUser", username, "garage")
+	user := User{}
+	err := json.Unmarshal(response.Payload, &user)
+	if err != nil {
+		t.Fatal(response.Message)
+	} else if user.Identity == "" {
+		t.Error("User should be bound to an identity")
+	}
+
+	// mallory cannot act as amag
+	response = invokeAs(stub, mallory, "readUser", username, "garage")
+	expectErrorCode(t, response, ErrIdentityMismatch)
+
+	response = invokeAs(stub, mallory, "create", username, "garage", `{ "vin": "WVWZZZ6R6HY260780" }`)
+	expectErrorCode(t, response, ErrIdentityMismatch)
+
+	response = invokeAs(stub, alice, "create", username, "garage", `{ "vin": "WVWZZZ6R6HY260780" }`)
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// cars can only be created for existing users
+	response = invokeAs(stub, mallory, "create", "mallory", "garage", `{ "vin": "WVWZZZ6R8HY260781" }`)
+	expectErrorCode(t, response, ErrUserNotFound)
+}
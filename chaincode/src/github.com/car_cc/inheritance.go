@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Inheritance.
+ *
+ * When the owner of a car dies, the DOT hands the car to
+ * the heir without consent of the owner or acceptance of
+ * the heir. The first DOT officer calling 'inheritCar'
+ * records the heir and the hash of the probate document
+ * under 'inheritance~<vin>'; a second officer, with another
+ * username and client identity, calling it with the same
+ * heir and hash completes the transfer.
+ *
+ * Co-owned cars, cars still confirmed and cars rented out,
+ * sold in installments, handed off or frozen are not
+ * inherited; a deposit placed on the car goes back to
+ * the buyer.
+ */
+
+// object type of inheritance keys
+const inheritanceObjectType string = "inheritance"
+
+// status of an inheritance
+const inheritancePending string = "pending"
+const inheritanceCompleted string = "completed"
+
+/*
+ * Returns the ledger key of the inheritance of a car
+ */
+func getInheritanceKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(inheritanceObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating inheritance key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the pending inheritance of a car.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getPendingInheritance(stub shim.ChaincodeStubInterface, vin string) (*Inheritance, error) {
+	key, err := getInheritanceKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	inheritanceAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading inheritance")
+	} else if inheritanceAsBytes == nil {
+		return nil, nil
+	}
+
+	inheritance := Inheritance{}
+	err = ledgerjson.Unmarshal(inheritanceAsBytes, &inheritance)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing inheritance")
+	} else if inheritance.Status != inheritancePending {
+		return nil, nil
+	}
+
+	return &inheritance, nil
+}
+
+/*
+ * Writes an inheritance to the ledger
+ */
+func saveInheritance(stub shim.ChaincodeStubInterface, inheritance *Inheritance) error {
+	key, err := getInheritanceKey(stub, inheritance.Vin)
+	if err != nil {
+		return err
+	}
+
+	inheritanceAsBytes, _ := ledgerjson.Marshal(inheritance)
+	err = stub.PutState(key, inheritanceAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing inheritance")
+	}
+
+	return nil
+}
+
+/*
+ * Checks that 'car' can pass to an heir
+ */
+func checkInheritance(car *Car, now int64) error {
+	if IsCoOwned(car) {
+		return newError(ErrInvalidState, "The car is co-owned. Shares pass with 'changeCoOwnership'")
+	} else if IsConfirmed(car, now) {
+		return newError(ErrInvalidState, "The car is still confirmed. It has to be revoked first")
+	} else if IsRented(car) {
+		return newError(ErrInvalidState, "The car is rented out. The rental has to end first")
+	} else if IsSoldInInstallments(car) {
+		return newError(ErrInvalidState, "The car is sold in installments. The plan has to end first")
+	} else if !IsActive(car) {
+		return newError(ErrNotActive, "The car is handed off to another channel")
+	} else if IsFrozen(car) {
+		return newError(ErrInvalidState, "The car is frozen")
+	}
+
+	return nil
+}
+
+/*
+ * Approves the inheritance of car 'vin' by 'heir',
+ * backed by the probate document with sha256 'probateHash'.
+ * The approval of a second DOT officer transfers the car.
+ *
+ * On success,
+ * returns the inheritance.
+ */
+func (t *CarChaincode) inheritCar(stub shim.ChaincodeStubInterface, officer string, vin string, heir string, probateHash string) pb.Response {
+	hash, err := hex.DecodeString(probateHash)
+	if err != nil || len(hash) != 32 {
+		return errorResponse(ErrInvalidArgument, "'inheritCar' expects the hex encoded sha256 of the probate document")
+	}
+
+	_, err = t.getUser(stub, heir)
+	if err != nil {
+		return errorResponse(ErrUserNotFound, fmt.Sprintf("Heir '%s' does not exist", heir))
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner == heir {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'%s' owns the car already", heir))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkInheritance(&car, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	approval := InheritanceApproval{Officer: officer, Identity: caller, Ts: now, TxId: stub.GetTxID()}
+
+	inheritance, err := getPendingInheritance(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if inheritance == nil {
+		inheritance = &Inheritance{
+			Vin:         vin,
+			Owner:       owner,
+			Heir:        heir,
+			ProbateHash: probateHash,
+			Status:      inheritancePending,
+			Approvals:   []InheritanceApproval{approval},
+		}
+
+		err = saveInheritance(stub, inheritance)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		fmt.Printf("Inheritance of '%s' by '%s' approved by '%s', waiting for a second approval\n", vin, heir, officer)
+		inheritanceAsBytes, _ := ledgerjson.Marshal(inheritance)
+		return shim.Success(inheritanceAsBytes)
+	}
+
+	if inheritance.Owner != owner || inheritance.Heir != heir || inheritance.ProbateHash != probateHash {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Another inheritance of '%s' is pending, cancel it first", vin))
+	}
+
+	// the second approval comes from another officer
+	first := inheritance.Approvals[0]
+	if first.Officer == officer || first.Identity != "" && first.Identity == caller {
+		return errorResponse(ErrForbidden, "Forbidden: the inheritance needs the approval of a second DOT officer")
+	}
+
+	// the deposit goes back to whoever paid it
+	if hold := car.Listing.Hold; hold != nil {
+		_, err = t.updateBalance(stub, depositPayer(hold), hold.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	inheritance.Approvals = append(inheritance.Approvals, approval)
+	inheritance.Status = inheritanceCompleted
+	inheritance.CompletedTs = now
+
+	err = saveInheritance(stub, inheritance)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordPrice(stub, &car, priceKindInheritance, 0)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Car '%s' inherited by '%s'\n", vin, heir)
+	return t.changeOwner(stub, car, owner, heir)
+}
+
+/*
+ * Drops the pending inheritance of a car.
+ *
+ * On success,
+ * returns the dropped inheritance.
+ */
+func (t *CarChaincode) cancelInheritance(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	inheritance, err := getPendingInheritance(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if inheritance == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no pending inheritance of '%s'", vin))
+	}
+
+	key, err := getInheritanceKey(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error removing inheritance")
+	}
+
+	inheritanceAsBytes, _ := ledgerjson.Marshal(inheritance)
+	return shim.Success(inheritanceAsBytes)
+}
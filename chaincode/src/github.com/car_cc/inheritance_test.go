@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInheritCar(t *testing.T) {
+	owner := "amag"
+	heir := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	probate := strings.Repeat("0f", 32)
+	alice := "DOTMSP alice certificate"
+	carol := "DOTMSP carol certificate"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("inheritCar", "alice", "dot", vin, heir, probate))
+	expectErrorCode(t, response, ErrUserNotFound)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", heir, "user"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("inheritCar", "alice", "dot", vin, heir, "probate.pdf"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("inheritCar", heir, "user", vin, heir, probate))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = invokeAs(stub, alice, "inheritCar", "alice", "dot", vin, heir, probate)
+	inheritance := Inheritance{}
+	json.Unmarshal(response.Payload, &inheritance)
+	if inheritance.Status != inheritancePending || inheritance.Owner != owner {
+		t.Fatalf("Expected a pending inheritance, got %s", response.Payload)
+	}
+
+	// a second approval needs another officer and identity
+	response = invokeAs(stub, alice, "inheritCar", "alice", "dot", vin, heir, probate)
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = invokeAs(stub, alice, "inheritCar", "carol", "dot", vin, heir, probate)
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = invokeAs(stub, carol, "inheritCar", "carol", "dot", vin, "mallory", probate)
+	expectErrorCode(t, response, ErrUserNotFound)
+
+	response = invokeAs(stub, carol, "inheritCar", "carol", "dot", vin, heir, probate)
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Username != heir {
+		t.Fatalf("Expected the car to go to the heir, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", heir, "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPriceHistory", "inspector", "dot", vin))
+	records := []PriceRecord{}
+	json.Unmarshal(response.Payload, &records)
+	if len(records) != 1 || records[0].Kind != priceKindInheritance {
+		t.Errorf("Expected the inheritance in the price history, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("cancelInheritance", "alice", "dot", vin))
+	expectErrorCode(t, response, ErrNotFound)
+}
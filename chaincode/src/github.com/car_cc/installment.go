@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Sales in installments.
+ *
+ * The owner offers a car to a buyer for a price in a number
+ * of installments with 'offerInstallments', the buyer commits
+ * to the plan with 'acceptInstallments'. Every 'payInstallment'
+ * moves one installment from the buyer to the seller, one
+ * period after the other. The car stays with the seller and
+ * cannot be transferred until the final installment, which
+ * also pays the transfer tax on the price and transfers the
+ * car to the buyer. Once an installment is overdue for longer
+ * than the grace period, the seller ends the plan with
+ * 'reclaimCar' and keeps the installments paid so far.
+ */
+
+// installment plan states
+const installmentsOffered string = "offered"
+const installmentsActive string = "active"
+const installmentsDefaulted string = "defaulted"
+
+// days an installment may be overdue by default
+const defaultInstallmentGraceDays int = 14
+
+/*
+ * Checks if a car is sold in installments or a plan is offered
+ */
+func IsSoldInInstallments(car *Car) bool {
+	return car.Installments.Status == installmentsOffered || car.Installments.Status == installmentsActive
+}
+
+/*
+ * Returns how long an installment may be overdue, in seconds
+ */
+func installmentGracePeriod(config Config) int64 {
+	days := config.InstallmentGraceDays
+	if days <= 0 {
+		days = defaultInstallmentGraceDays
+	}
+
+	return int64(days) * secondsPerDay
+}
+
+/*
+ * Returns the amount of the next installment of 'plan',
+ * the last installment pays the remainder of the price
+ */
+func nextInstallment(plan *InstallmentPlan) int {
+	if plan.Paid == plan.Count-1 {
+		return plan.Price - plan.PaidAmount
+	}
+
+	return plan.Price / plan.Count
+}
+
+/*
+ * Offers the car to 'plan.Buyer' for 'plan.Price' in
+ * 'plan.Count' installments, one every 'plan.PeriodDays'.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) offerInstallments(stub shim.ChaincodeStubInterface, username string, vin string, plan InstallmentPlan) pb.Response {
+	if plan.Buyer == "" || plan.Buyer == username {
+		return errorResponse(ErrInvalidArgument, "'offerInstallments' expects another user as buyer")
+	} else if plan.Price <= 0 {
+		return errorResponse(ErrInvalidArgument, "'offerInstallments' expects a positive price")
+	} else if plan.Count < 1 || plan.Count > plan.Price {
+		return errorResponse(ErrInvalidArgument, "'offerInstallments' expects between 1 installment and one per credit of the price")
+	} else if plan.PeriodDays < 1 {
+		return errorResponse(ErrInvalidArgument, "'offerInstallments' expects a period of at least one day")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is already sold in installments to '%s'", car.Installments.Buyer))
+	} else if IsRented(&car) {
+		return errorResponse(ErrInvalidState, "The car is rented out. The rental has to end first")
+	} else if car.Listing.Hold != nil {
+		return errorResponse(ErrInvalidState, "A deposit is placed on the car. It has to be cancelled first")
+	} else if !IsActive(&car) {
+		return errorResponse(ErrNotActive, "The car is handed off to another channel and cannot be sold")
+	} else if IsFrozen(&car) {
+		return errorResponse(ErrInvalidState, "The car is frozen and cannot be sold")
+	}
+
+	_, err = t.getUser(stub, plan.Buyer)
+	if err != nil {
+		return errorResponse(ErrUserNotFound, "User does not exist. Username: '"+plan.Buyer+"'")
+	}
+
+	// the price is taxed like a sale, so it has
+	// to stand the comparison with the reference value
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if car.Certificate.Brand != "" && car.Certificate.Model != "" {
+		reference, err := getReferenceValue(stub, car.Certificate.Brand, car.Certificate.Model)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if reference != nil && isUndervalued(config, plan.Price, reference.Value) {
+			return errorResponse(ErrInvalidState, "The price is far below the reference value. Sell the car with 'sell' to have it reviewed by the DOT")
+		}
+	}
+
+	// the seller finances the whole price
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAppraisal(config, &car, plan.Price, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Installments = InstallmentPlan{
+		Buyer:      plan.Buyer,
+		Status:     installmentsOffered,
+		Price:      plan.Price,
+		Count:      plan.Count,
+		PeriodDays: plan.PeriodDays,
+	}
+
+	return t.saveInstallmentCar(stub, &car)
+}
+
+/*
+ * Lets the buyer commit to an offered plan.
+ * The first installment is due one period later.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) acceptInstallments(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, _, err := t.getInstallmentCar(stub, username, vin, installmentsOffered)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Installments.Status = installmentsActive
+	car.Installments.AcceptedTs = now
+	car.Installments.NextDueTs = now + int64(car.Installments.PeriodDays)*secondsPerDay
+
+	return t.saveInstallmentCar(stub, &car)
+}
+
+/*
+ * Pays the next installment of the buyer to the seller.
+ *
+ * The final installment also pays the transfer tax on the
+ * price and transfers the car to the buyer, so it fails
+ * while the car is still confirmed.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) payInstallment(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, seller, err := t.getInstallmentCar(stub, username, vin, installmentsActive)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	plan := &car.Installments
+	amount := nextInstallment(plan)
+	final := plan.Paid == plan.Count-1
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	tax, stake := 0, 0
+	if final {
+		if IsConfirmed(&car, now) {
+			return errorResponse(ErrInvalidState, "The car is still confirmed. It has to be revoked first in order to do the transfer")
+		}
+
+		config, err := t.getConfig(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		tax = scheduledFee(config, transferTaxName, plan.Price, 0)
+		stake = releaseListingStake(&car)
+	}
+
+	_, err = t.updateBalance(stub, username, -(amount + tax))
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, seller, amount+stake)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	plan.Paid++
+	plan.PaidAmount += amount
+	plan.NextDueTs += int64(plan.PeriodDays) * secondsPerDay
+
+	if !final {
+		fmt.Printf("Installment %d of %d for car '%s' paid\n", plan.Paid, plan.Count, vin)
+		return t.saveInstallmentCar(stub, &car)
+	}
+
+	err = t.collectFee(stub, transferTaxName, tax)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordPrice(stub, &car, priceKindInstallments, plan.Price)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = recordCompletedSale(stub, seller, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the plan ends with the transfer
+	car.Installments = InstallmentPlan{}
+	fmt.Printf("Final installment for car '%s' paid, transferring it to '%s'\n", vin, username)
+
+	return t.changeOwner(stub, car, seller, username)
+}
+
+/*
+ * Ends a plan with an installment overdue for longer than
+ * the grace period, or withdraws an offer that was not
+ * accepted. The seller keeps the installments paid so far.
+ *
+ * On success,
+ * returns the ended plan.
+ */
+func (t *CarChaincode) reclaimCar(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, "Car is not sold in installments")
+	}
+
+	plan := car.Installments
+	if plan.Status == installmentsActive {
+		config, err := t.getConfig(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		now, err := txUnix(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		if now <= plan.NextDueTs+installmentGracePeriod(config) {
+			return errorResponse(ErrInvalidState, "No installment is overdue for longer than the grace period")
+		}
+
+		plan.Status = installmentsDefaulted
+
+		err = recordReputation(stub, plan.Buyer, reputationCancelledDeal, vin, username, sideBuyer)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	car.Installments = InstallmentPlan{}
+	response := t.saveInstallmentCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	fmt.Printf("Installment plan of car '%s' with '%s' ended\n", vin, plan.Buyer)
+
+	planAsBytes, _ := ledgerjson.Marshal(plan)
+	return shim.Success(planAsBytes)
+}
+
+/*
+ * Reads a car sold in installments to 'username'
+ * in plan state 'status', and the seller
+ */
+func (t *CarChaincode) getInstallmentCar(stub shim.ChaincodeStubInterface, username string, vin string, status string) (Car, string, error) {
+	seller, err := t.getOwner(stub, vin)
+	if err != nil {
+		return Car{}, "", err
+	}
+
+	car, err := t.getCar(stub, seller, vin)
+	if err != nil {
+		return Car{}, "", err
+	} else if car.Installments.Buyer != username {
+		return Car{}, "", newError(ErrForbidden, "Forbidden: this car is not sold to you in installments")
+	} else if car.Installments.Status != status {
+		return Car{}, "", newError(ErrInvalidState, fmt.Sprintf("Installment plan is '%s', expected '%s'", car.Installments.Status, status))
+	}
+
+	return car, seller, nil
+}
+
+/*
+ * Writes a car with a changed installment plan
+ */
+func (t *CarChaincode) saveInstallmentCar(stub shim.ChaincodeStubInterface, car *Car) pb.Response {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInstallments(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", transferTaxName, "0", "10"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "50", "0", "30"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "50", "3", "30"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptInstallments", buyer, "user", vin))
+
+	// the car cannot be sold to someone else meanwhile
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", seller, "garage", vin, "mallory"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// nothing is overdue yet
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reclaimCar", seller, "garage", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// 16 and 16, the last installment pays the remaining 18 plus tax
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Username != buyer || IsSoldInInstallments(&car) {
+		t.Fatalf("Expected the car to go to the buyer with the final installment, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", buyer, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 100-50-5 {
+		t.Errorf("Buyer should have paid price and tax, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", seller, "user"))
+	user = User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 100+50 {
+		t.Errorf("Seller should have received the price, balance is %d", user.Balance)
+	}
+}
+
+func TestReclaimCarAfterMissedInstallment(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("offerInstallments", seller, "garage", vin, buyer, "60", "2", "30"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptInstallments", buyer, "user", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+
+	// let the second installment run past the grace period
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", seller, "garage", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	stub.MockTransactionStart(uuid)
+	car.Installments.NextDueTs -= int64(61+defaultInstallmentGraceDays) * secondsPerDay
+	carAsBytes, _ := json.Marshal(car)
+	stub.PutState(vin, carAsBytes)
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reclaimCar", buyer, "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reclaimCar", seller, "garage", vin))
+	plan := InstallmentPlan{}
+	json.Unmarshal(response.Payload, &plan)
+	if plan.Status != installmentsDefaulted || plan.PaidAmount != 30 {
+		t.Fatalf("Expected a defaulted plan, got %s", response.Message)
+	}
+
+	// the seller keeps the car and the first installment
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payInstallment", buyer, "user", vin))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", seller, "garage", vin, buyer))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"encoding/hex"
 	"fmt"
+	"sort"
 
+	"github.com/car_cc/ledgerjson"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -21,14 +22,17 @@ import (
  * occur when changing numberplates.
  *
  * In any case, the car has to be registered before it can be insured.
+ * A policy with a coverage period only insures the car within
+ * that period, so the insurance ends at 'now' without a transaction.
+ * A current cover note of the owner insures the car as well.
  */
-func IsInsured(car *Car) bool {
+func IsInsured(car *Car, now int64) bool {
 	// cannot be insured without car papers
 	if !IsRegistered(car) {
 		return false
 	}
 
-	insured := car.Certificate.Insurer != ""
+	insured := IsInsuredByPolicy(car, now) || HasCurrentCoverNote(car, now)
 
 	if insured {
 		fmt.Printf("Car with VIN '%s' is insured by company '%s'\n", car.Vin, car.Certificate.Insurer)
@@ -39,15 +43,85 @@ func IsInsured(car *Car) bool {
 	return insured
 }
 
+/*
+ * Checks if a car is insured by a full policy,
+ * not by a cover note only
+ */
+func IsInsuredByPolicy(car *Car, now int64) bool {
+	return car.Certificate.Insurer != "" && IsPolicyCurrent(&car.Policy, now)
+}
+
+/*
+ * Checks if 'now' is within the coverage period of a policy
+ */
+func IsPolicyCurrent(policy *InsurancePolicy, now int64) bool {
+	if policy.EndTs == 0 {
+		return true
+	}
+
+	return policy.StartTs <= now && now < policy.EndTs
+}
+
+/*
+ * Sets the coverage period and the policy document hash of
+ * a car insured by 'insurer'. Insurers renew a policy
+ * by setting a new period.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) setPolicy(stub shim.ChaincodeStubInterface, insurer string, vin string, policy InsurancePolicy) pb.Response {
+	if policy.EndTs <= policy.StartTs {
+		return errorResponse(ErrInvalidArgument, "'setPolicy' expects the end of coverage after the start")
+	} else if _, err := hex.DecodeString(policy.DocumentHash); err != nil || len(policy.DocumentHash) != 64 {
+		return errorResponse(ErrInvalidArgument, "'setPolicy' expects the hex encoded sha256 hash of the policy document")
+	}
+
+	err := requireAccreditedInsurer(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Certificate.Insurer != insurer {
+		return errorResponse(ErrForbidden, "Forbidden: the car is not insured by you")
+	}
+
+	err = updateExpiry(stub, expiryInsurance, vin, car.Policy.EndTs, policy.EndTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Policy = policy
+
+	// the full policy is recorded, no need to revoke the car
+	car.RevocationDueTs = 0
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
+
 /*
  * Returns the insurer index
  */
 func (t *CarChaincode) getInsurerIndex(stub shim.ChaincodeStubInterface) (map[string]Insurer, error) {
 	response := t.read(stub, insurerIndexStr)
 	insurerIndex := make(map[string]Insurer)
-	err := json.Unmarshal(response.Payload, &insurerIndex)
+	err := ledgerjson.Unmarshal(response.Payload, &insurerIndex)
 	if err != nil {
-		return nil, errors.New("Error parsing insurer index")
+		return nil, newError(ErrLedger, "Error parsing insurer index")
 	}
 
 	return insurerIndex, nil
@@ -60,11 +134,15 @@ func (t *CarChaincode) getInsurer(stub shim.ChaincodeStubInterface, company stri
 	// load all insurers
 	insurerIndex, err := t.getInsurerIndex(stub)
 	if err != nil {
-		return shim.Error("Error reading insurer index")
+		return errorResponse(ErrLedger, "Error reading insurer index")
 	}
 
+	// return the proposals in a stable order,
+	// independent of when they were filed
 	ret := insurerIndex[company]
-	retAsBytes, _ := json.Marshal(ret)
+	sort.Stable(insureProposalsByCar(ret.Proposals))
+
+	retAsBytes, _ := ledgerjson.Marshal(ret)
 	return shim.Success(retAsBytes)
 }
 
@@ -80,14 +158,19 @@ func (t *CarChaincode) getInsurer(stub shim.ChaincodeStubInterface, company stri
  * returns the removed insurance proposal
  */
 func (t *CarChaincode) insuranceAccept(stub shim.ChaincodeStubInterface, username string, vin string, company string) pb.Response {
+	err := requireAccreditedInsurer(stub, company)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
 	car, err := t.getCar(stub, username, vin)
 	if err != nil {
-		return shim.Error("Error fetching car")
+		return errorResponseFrom(err)
 	}
 
 	insurerIndex, err := t.getInsurerIndex(stub)
 	if err != nil {
-		return shim.Error("Error fetching insurer index")
+		return errorResponse(ErrLedger, "Error fetching insurer index")
 	}
 
 	insurer := insurerIndex[company]
@@ -103,15 +186,22 @@ func (t *CarChaincode) insuranceAccept(stub shim.ChaincodeStubInterface, usernam
 			// if we are sure the car VIN is approved by the DOT
 			// and the car has a valid certificate
 			if !IsRegistered(&car) {
-				return shim.Error("Go register your car first")
+				return errorResponse(ErrNotRegistered, "Go register your car first")
+			}
+
+			// insure the car, the insurer
+			// sets the policy period afterwards
+			err = updateInsuredIndex(stub, vin, car.Certificate.Insurer, company)
+			if err != nil {
+				return errorResponseFrom(err)
 			}
 
-			// insure the car
 			car.Certificate.Insurer = company
-			carAsBytes, err := json.Marshal(car)
+			car.Policy = InsurancePolicy{}
+			carAsBytes, err := ledgerjson.Marshal(car)
 			err = stub.PutState(car.Vin, carAsBytes)
 			if err != nil {
-				return shim.Error("Error writing car")
+				return errorResponse(ErrLedger, "Error writing car")
 			}
 
 			// remove proposal
@@ -123,13 +213,13 @@ func (t *CarChaincode) insuranceAccept(stub shim.ChaincodeStubInterface, usernam
 	// write udpated insurer index back to ledger
 	insurer.Proposals = newProposals
 	insurerIndex[company] = insurer
-	indexAsBytes, _ := json.Marshal(insurerIndex)
+	indexAsBytes, _ := ledgerjson.Marshal(insurerIndex)
 	err = stub.PutState(insurerIndexStr, indexAsBytes)
 	if err != nil {
-		return shim.Error("Error writing insurer index")
+		return errorResponse(ErrLedger, "Error writing insurer index")
 	}
 
-	propAsBytes, _ := json.Marshal(validProposal)
+	propAsBytes, _ := ledgerjson.Marshal(validProposal)
 	return shim.Success(propAsBytes)
 }
 
@@ -139,18 +229,22 @@ func (t *CarChaincode) insuranceAccept(stub shim.ChaincodeStubInterface, usernam
  *
  * The car does not need to be registered.
  * A car numberplate is not required.
- * The proposal will be recorded even if no
- * insurance company with that name exists.
+ * The insurance company has to be accredited,
+ * see 'accreditInsurer'.
  *
  * On success,
  * returns the insurance proposal
  */
 func (t *CarChaincode) insureProposal(stub shim.ChaincodeStubInterface, username string, vin string, company string) pb.Response {
+	err := requireAccreditedInsurer(stub, company)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
 	// load all insurers
 	insurerIndex, err := t.getInsurerIndex(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	// check if this insurance company even exists
@@ -172,12 +266,12 @@ func (t *CarChaincode) insureProposal(stub shim.ChaincodeStubInterface, username
 	insurerIndex[company] = insurer
 
 	// write udpated insurer index back to ledger
-	indexAsBytes, _ := json.Marshal(insurerIndex)
+	indexAsBytes, _ := ledgerjson.Marshal(insurerIndex)
 	err = stub.PutState(insurerIndexStr, indexAsBytes)
 	if err != nil {
-		return shim.Error("Error writing insurer index")
+		return errorResponse(ErrLedger, "Error writing insurer index")
 	}
 
-	proposalAsBytes, _ := json.Marshal(proposal)
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
 	return shim.Success(proposalAsBytes)
 }
@@ -3,7 +3,9 @@ package main
 import (
     "fmt"
     "encoding/json"
+    "strconv"
     "testing"
+    "time"
 
     "github.com/hyperledger/fabric/core/chaincode/shim"
     "github.com/hyperledger/fabric/common/util"
@@ -13,23 +15,25 @@ func TestIsInsured(t *testing.T) {
     // create a new car without insurance
     car := &Car{}
 
-    if (IsInsured(car)) {
+    if (IsInsured(car, time.Now().Unix())) {
         t.Error("Car should not be insured initially")
     }
 }
 
 func TestInsureProposal(t *testing.T) {
     username := "amag"
-    vin      := "WVW ZZZ 6RZ HY26 0780"
+    vin      := "WVWZZZ6R6HY260780"
 
     // create and name a new chaincode mock
     carChaincode := &CarChaincode{}
     stub := shim.NewMockStub("car", carChaincode)
 
     ccSetup(t, stub)
+    accredit(stub, "axa")
 
     // create a new car
     carData := `{ "vin": "` + vin + `" }`
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
     response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
     // payload should contain the car
@@ -54,7 +58,7 @@ func TestInsureProposal(t *testing.T) {
 
 func TestGetInsurerAndInsuranceAccept(t *testing.T) {
     username         := "amag"
-    vin              := "WVW ZZZ 6RZ HY26 0780"
+    vin              := "WVWZZZ6R6HY260780"
     insuranceCompany := "axa"
 
     // create and name a new chaincode mock
@@ -62,9 +66,11 @@ func TestGetInsurerAndInsuranceAccept(t *testing.T) {
     stub := shim.NewMockStub("car", carChaincode)
 
     ccSetup(t, stub)
+    accredit(stub, insuranceCompany)
 
     // create a new car
     carData := `{ "vin": "` + vin + `" }`
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
     response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
 
     // payload should contain the car
@@ -101,7 +107,7 @@ func TestGetInsurerAndInsuranceAccept(t *testing.T) {
         t.Error(response.Message)
     }
 
-    if IsInsured(&car) {
+    if IsInsured(&car, time.Now().Unix()) {
         t.Error("The reigistered car should not yet be insured")
     }
 
@@ -144,7 +150,162 @@ func TestGetInsurerAndInsuranceAccept(t *testing.T) {
 
     fmt.Println(car.Certificate)
 
-    if !IsInsured(&car) {
+    if !IsInsured(&car, time.Now().Unix()) {
         t.Error("The reigistered car should be insured by now")
     }
-}
\ No newline at end of file
+}
+
+func TestGetInsurerProposalOrder(t *testing.T) {
+    username         := "amag"
+    insuranceCompany := "axa"
+    vins             := []string{"WVWZZZ6RXHY260782", "WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+    // create and name a new chaincode mock
+    carChaincode := &CarChaincode{}
+    stub := shim.NewMockStub("car", carChaincode)
+
+    ccSetup(t, stub)
+    accredit(stub, insuranceCompany)
+
+    // create the cars and propose them for insurance
+    // in an order that differs from the VIN order
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "garage"))
+    for _, vin := range vins {
+        carData := `{ "vin": "` + vin + `" }`
+        stub.MockInvoke(uuid, util.ToChaincodeArgs("create", username, "garage", carData))
+        stub.MockInvoke(uuid, util.ToChaincodeArgs("insureProposal", username, "user", vin, insuranceCompany))
+    }
+
+    // read the insurer twice, both reads should
+    // return the exact same payload
+    first := stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsurer", username, "insurer", insuranceCompany))
+    second := stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsurer", username, "insurer", insuranceCompany))
+    if string(first.Payload) != string(second.Payload) {
+        t.Error("Reading the same insurer twice should return the same result")
+    }
+
+    insurer := Insurer {}
+    err := json.Unmarshal(first.Payload, &insurer)
+    if (err != nil) {
+        t.Error("Error fetching insurance records")
+    }
+
+    if len(insurer.Proposals) != len(vins) {
+        t.Fatalf("Insurer should have %d proposals, but has %d", len(vins), len(insurer.Proposals))
+    }
+
+    // proposals should be sorted by car VIN
+    for i := 1; i < len(insurer.Proposals); i++ {
+        if insurer.Proposals[i-1].Car > insurer.Proposals[i].Car {
+            t.Error("Insurance proposals should be sorted by car VIN")
+        }
+    }
+}
+
+func TestTransferPortfolio(t *testing.T) {
+    username := "amag"
+    vins     := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781", "WVWZZZ6RXHY260782"}
+
+    // create and name a new chaincode mock
+    carChaincode := &CarChaincode{}
+    stub := shim.NewMockStub("car", carChaincode)
+
+    ccSetup(t, stub)
+    accredit(stub, "axa")
+
+    for _, vin := range vins {
+        insureCar(t, stub, username, vin, "failing")
+    }
+
+    // only the regulator moves portfolios
+    response := stub.MockInvoke(uuid, util.ToChaincodeArgs("transferPortfolio", "failing", "insurer", "failing", "axa", "FINMA-1", "2"))
+    if (response.Status == shim.OK) {
+        t.Error("Insurers should not be allowed to move portfolios")
+    }
+
+    // move the first chunk
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transferPortfolio", "TESTING", "regulator", "failing", "axa", "FINMA-1", "2"))
+    transfer := PortfolioTransfer {}
+    err := json.Unmarshal(response.Payload, &transfer)
+    if (err != nil) {
+        t.Fatal(response.Message)
+    }
+
+    if len(transfer.Cars) != 2 || transfer.Remaining != 1 {
+        t.Error(fmt.Sprintf("First chunk should move 2 policies and leave 1, moved %d and left %d", len(transfer.Cars), transfer.Remaining))
+    }
+
+    // move the rest
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transferPortfolio", "TESTING", "regulator", "failing", "axa", "FINMA-1", "2"))
+    err = json.Unmarshal(response.Payload, &transfer)
+    if (err != nil) {
+        t.Fatal(response.Message)
+    }
+
+    if len(transfer.Cars) != 3 || transfer.Remaining != 0 {
+        t.Error("Second chunk should move the last policy")
+    }
+
+    // all cars are insured by axa now
+    for _, vin := range vins {
+        response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "TESTING", vin))
+        car := Car {}
+        json.Unmarshal(response.Payload, &car)
+        if (car.Certificate.Insurer != "axa") {
+            t.Error("Car with VIN '" + vin + "' should be insured by axa")
+        }
+    }
+
+    // every moved policy is recorded for the insurers of the order
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getMovedPolicies", "axa", "insurer", "FINMA-1"))
+    policies := []PolicyMoved {}
+    json.Unmarshal(response.Payload, &policies)
+    if len(policies) != 3 || policies[0].Car != vins[0] || policies[2].To != "axa" {
+        t.Error(fmt.Sprintf("Expected the 3 moved policies in VIN order, got %v: %s", policies, response.Message))
+    }
+
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getMovedPolicies", "zurich", "insurer", "FINMA-1"))
+    expectErrorCode(t, response, ErrForbidden)
+}
+
+func TestPolicyCoverage(t *testing.T) {
+    username := "amag"
+    vin      := "WVWZZZ6R6HY260780"
+    document := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+    now      := time.Now()
+    past     := strconv.FormatInt(now.Add(-48 * time.Hour).Unix(), 10)
+    expired  := strconv.FormatInt(now.Add(-24 * time.Hour).Unix(), 10)
+    future   := strconv.FormatInt(now.Add(24 * time.Hour).Unix(), 10)
+
+    stub := shim.NewMockStub("car", &CarChaincode{})
+    ccSetup(t, stub)
+    insureCar(t, stub, username, vin, "axa")
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", username, "dot", vin, "ZH 1234"))
+
+    response := stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "zurich", "insurer", vin, past, future, document))
+    expectErrorCode(t, response, ErrForbidden)
+
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", vin, past, future, "policy.pdf"))
+    expectErrorCode(t, response, ErrInvalidArgument)
+
+    // the policy ran out yesterday, so the car
+    // is neither insured nor confirmed anymore
+    stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", vin, past, expired, document))
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "user", vin))
+    car := Car {}
+    json.Unmarshal(response.Payload, &car)
+    if IsInsured(&car, now.Unix()) || IsConfirmed(&car, now.Unix()) {
+        t.Error("Car with an expired policy should not be insured")
+    }
+
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "Rear-ended at a red light", "30"))
+    expectErrorCode(t, response, ErrNotInsured)
+
+    // renewing the policy insures the car again
+    response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", vin, past, future, document))
+    car = Car {}
+    json.Unmarshal(response.Payload, &car)
+    if !IsConfirmed(&car, now.Unix()) || car.Policy.DocumentHash != document {
+        t.Error("Car with a renewed policy should be confirmed again")
+    }
+}
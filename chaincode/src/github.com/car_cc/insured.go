@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Insurer portfolios.
+ *
+ * Every module changing the insurer of a car also moves
+ * the key 'insurer~<insurer>~<vin>', so an insurer lists
+ * its book of business with a single partial key query,
+ * see 'getInsuredCars' and 'getPoliciesExpiring'. Index
+ * entries are hints only, every car is checked against its
+ * current state, so entries of cars archived since are
+ * skipped.
+ */
+
+// object type of insured car keys
+const insuredObjectType string = "insurer"
+
+// value of insured car keys, the key holds all data
+var insuredValue = []byte{0x00}
+
+// default number of cars on a page of insured cars
+const defaultInsuredPageSize int = 50
+
+/*
+ * Moves the insured car key of car 'vin' from
+ * insurer 'from' to insurer 'to', either may be empty
+ */
+func updateInsuredIndex(stub shim.ChaincodeStubInterface, vin string, from string, to string) error {
+	if from == to {
+		return nil
+	}
+
+	if from != "" {
+		key, err := stub.CreateCompositeKey(insuredObjectType, []string{from, vin})
+		if err != nil {
+			return newError(ErrInternal, "Error creating insured car key")
+		}
+
+		err = stub.DelState(key)
+		if err != nil {
+			return newError(ErrLedger, "Error deleting insured car key")
+		}
+	}
+
+	if to != "" {
+		key, err := stub.CreateCompositeKey(insuredObjectType, []string{to, vin})
+		if err != nil {
+			return newError(ErrInternal, "Error creating insured car key")
+		}
+
+		err = stub.PutState(key, insuredValue)
+		if err != nil {
+			return newError(ErrLedger, "Error writing insured car key")
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Reads the cars insured by 'insurer' in VIN order,
+ * calling 'visit' until it returns false
+ */
+func forEachInsuredCar(stub shim.ChaincodeStubInterface, insurer string, visit func(car *Car) bool) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(insuredObjectType, []string{insurer})
+	if err != nil {
+		return newError(ErrLedger, "Error reading insured cars")
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return newError(ErrLedger, "Error reading insured cars")
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 2 {
+			return newError(ErrLedger, "Error parsing insured car key")
+		}
+
+		carAsBytes, err := stub.GetState(attributes[1])
+		if err != nil {
+			return newError(ErrLedger, "Error reading car")
+		} else if carAsBytes == nil {
+			continue
+		}
+
+		car := Car{}
+		err = ledgerjson.Unmarshal(carAsBytes, &car)
+		if err != nil {
+			return newError(ErrLedger, "Error parsing car")
+		}
+
+		// stale entry
+		if IsArchived(&car) || car.Certificate.Insurer != insurer {
+			continue
+		}
+
+		if !visit(&car) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns what an insurer sees of a car it insures
+ */
+func insuredCar(car *Car) InsuredCar {
+	return InsuredCar{
+		Vin:         car.Vin,
+		Brand:       car.Certificate.Brand,
+		Model:       car.Certificate.Model,
+		Type:        car.Certificate.Type,
+		Numberplate: car.Certificate.Numberplate,
+		Policy:      car.Policy}
+}
+
+/*
+ * Returns a page of the cars insured by 'insurer' in VIN
+ * order. Pass the bookmark of a page to get the next page.
+ *
+ * Arguments optional:
+ * [0] Page size                   (int)
+ * [1] Bookmark                    (string)
+ *
+ * On success,
+ * returns the page of insured cars.
+ */
+func (t *CarChaincode) getInsuredCars(stub shim.ChaincodeStubInterface, insurer string, args []string) pb.Response {
+	pageSize := defaultInsuredPageSize
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return errorResponse(ErrInvalidArgument, "'getInsuredCars' expects a positive page size")
+		}
+	}
+
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	page := InsuredCarPage{Cars: []InsuredCar{}}
+	err := forEachInsuredCar(stub, insurer, func(car *Car) bool {
+		if car.Vin <= bookmark {
+			return true
+		}
+
+		// one more car, so there is a next page
+		if len(page.Cars) == pageSize {
+			page.Bookmark = page.Cars[pageSize-1].Vin
+			return false
+		}
+
+		page.Cars = append(page.Cars, insuredCar(car))
+		return true
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	pageAsBytes, _ := ledgerjson.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+/*
+ * Lists the policies of 'insurer' ending within
+ * the next 'withinDays' days.
+ *
+ * On success,
+ * returns the insured cars by end of coverage.
+ */
+func (t *CarChaincode) getPoliciesExpiring(stub shim.ChaincodeStubInterface, insurer string, withinDays int) pb.Response {
+	if withinDays < 1 {
+		return errorResponse(ErrInvalidArgument, "'getPoliciesExpiring' expects a positive number of days")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	until := now + int64(withinDays)*secondsPerDay
+
+	cars := []InsuredCar{}
+	err = forEachInsuredCar(stub, insurer, func(car *Car) bool {
+		if car.Policy.EndTs >= now && car.Policy.EndTs < until {
+			cars = append(cars, insuredCar(car))
+		}
+		return true
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	sort.Sort(insuredCarsByPolicyEnd(cars))
+
+	fmt.Printf("%d policies of '%s' end within %d days\n", len(cars), insurer, withinDays)
+
+	carsAsBytes, _ := ledgerjson.Marshal(cars)
+	return shim.Success(carsAsBytes)
+}
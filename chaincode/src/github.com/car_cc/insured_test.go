@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInsurerPortfolio(t *testing.T) {
+	soon := "WVWZZZ6R6HY260780"
+	later := "WVWZZZ6R8HY260781"
+	other := "WVWZZZ6RXHY260782"
+	policyHash := strings.Repeat("ab", 32)
+	ts := func(days int) string {
+		return strconv.FormatInt(time.Now().Add(time.Duration(days)*24*time.Hour).Unix(), 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, "amag", soon, "axa")
+	insureCar(t, stub, "bobby", later, "axa")
+	insureCar(t, stub, "carol", other, "zurich")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", soon, ts(-300), ts(10), policyHash))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "axa", "insurer", later, ts(-300), ts(40), policyHash))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPolicy", "zurich", "insurer", other, ts(-300), ts(5), policyHash))
+
+	// page through the book of 'axa'
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "axa", "insurer", "1"))
+	page := InsuredCarPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Cars) != 1 || page.Cars[0].Vin != soon || page.Bookmark != soon {
+		t.Fatalf("Unexpected first page: %v", page)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "axa", "insurer", "1", page.Bookmark))
+	page = InsuredCarPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Cars) != 1 || page.Cars[0].Vin != later || page.Bookmark != "" {
+		t.Fatalf("Unexpected last page: %v", page)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "amag", "user"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPoliciesExpiring", "axa", "insurer", "0"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPoliciesExpiring", "axa", "insurer", "30"))
+	cars := []InsuredCar{}
+	json.Unmarshal(response.Payload, &cars)
+	if len(cars) != 1 || cars[0].Vin != soon {
+		t.Errorf("Expected only the policy of '%s' to end within 30 days, got %v", soon, cars)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPoliciesExpiring", "axa", "insurer", "60"))
+	cars = []InsuredCar{}
+	json.Unmarshal(response.Payload, &cars)
+	if len(cars) != 2 || cars[0].Vin != soon || cars[1].Vin != later {
+		t.Errorf("Expected both policies by end of coverage, got %v", cars)
+	}
+
+	// a revoked car leaves the book
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("revoke", "amag", "dot", soon))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "axa", "insurer"))
+	page = InsuredCarPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Cars) != 1 || page.Cars[0].Vin != later {
+		t.Errorf("Expected only car '%s' insured by 'axa', got %v", later, page.Cars)
+	}
+
+	// a portfolio transfer moves the book
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transferPortfolio", "finma", "regulator", "zurich", "axa", "FINMA-1"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "axa", "insurer"))
+	page = InsuredCarPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Cars) != 2 || page.Cars[0].Vin != later || page.Cars[1].Vin != other {
+		t.Errorf("Expected cars '%s' and '%s' insured by 'axa', got %v", later, other, page.Cars)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "zurich", "insurer"))
+	page = InsuredCarPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Cars) != 0 {
+		t.Errorf("Expected no cars insured by 'zurich', got %v", page.Cars)
+	}
+}
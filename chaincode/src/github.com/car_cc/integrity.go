@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Index integrity.
+ *
+ * Who owns a car is kept twice, in the car index keys
+ * 'car~<vin>' (owner pseudonym) and in the ownership
+ * keys 'user~<pseudonym>~<vin>' the car lists of users
+ * are derived from. 'verifyIndexIntegrity' cross-checks
+ * both against the car states and reports every mismatch.
+ * It reads the car states from the range of plain keys
+ * VINs fall into and the ownership keys by object type,
+ * never the whole ledger.
+ *
+ * With repair, the mismatches the car index settles are
+ * fixed: index entries of missing or archived cars are
+ * removed, missing ownership keys are added and ownership
+ * keys the index does not back are deleted. Cars missing
+ * from the index and unknown pseudonyms need a decision
+ * on the owner and are only reported.
+ */
+
+// kinds of index integrity issues
+const issueMissingCar string = "missing_car"     // indexed, but no car state
+const issueArchivedCar string = "archived_car"   // indexed, but archived
+const issueUnindexedCar string = "unindexed_car" // car state, but not indexed
+const issueUnknownOwner string = "unknown_owner" // index pseudonym without user
+const issueMissingLink string = "missing_link"   // indexed, but no ownership key
+const issueDanglingLink string = "dangling_link" // ownership key the index does not back
+
+// plain keys VINs fall into, VINs are digits and capital
+// letters, while indexes start with '_' and composite
+// keys, which are read by object type, with 0x00
+const vinRangeStart string = "0"
+const vinRangeEnd string = "Z" + maxKeySuffix
+
+/*
+ * Reads the car states, which are stored
+ * under their VIN, by VIN
+ */
+func readCarStates(stub shim.ChaincodeStubInterface) (map[string]Car, error) {
+	iterator, err := stub.GetStateByRange(vinRangeStart, vinRangeEnd)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading car states")
+	}
+	defer iterator.Close()
+
+	cars := make(map[string]Car)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading car states")
+		}
+
+		// users and settings in the range are no cars
+		if !strings.HasPrefix(string(kv.Value), "{") {
+			continue
+		}
+
+		car := Car{}
+		if ledgerjson.Unmarshal(kv.Value, &car) == nil && car.Vin == kv.Key {
+			cars[kv.Key] = car
+		}
+	}
+
+	return cars, nil
+}
+
+/*
+ * Reads the ownership keys as
+ * owner pseudonyms by VIN
+ */
+func readOwnershipLinks(stub shim.ChaincodeStubInterface) (map[string][]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(ownershipObjectType, []string{})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading ownership keys")
+	}
+	defer iterator.Close()
+
+	links := make(map[string][]string)
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading ownership keys")
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 2 {
+			return nil, newError(ErrLedger, "Error parsing ownership key")
+		}
+		links[attributes[1]] = append(links[attributes[1]], attributes[0])
+	}
+
+	return links, nil
+}
+
+/*
+ * Cross-checks the car index, the ownership keys
+ * and the car states.
+ *
+ * Arguments optional:
+ * [0] Repair                      (bool)
+ *
+ * On success,
+ * returns the report.
+ */
+func (t *CarChaincode) verifyIndexIntegrity(stub shim.ChaincodeStubInterface, repair bool) pb.Response {
+	carIndex, err := t.getCarIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	cars, err := readCarStates(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	links, err := readOwnershipLinks(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	report := IntegrityReport{IndexedCars: len(carIndex), CarStates: len(cars), Issues: []IntegrityIssue{}}
+	for _, owners := range links {
+		report.OwnershipKeys += len(owners)
+	}
+
+	// index entries that have to go
+	dropped := []string{}
+	for vin, pseudonym := range carIndex {
+		car, stored := cars[vin]
+		if !stored {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: issueMissingCar, Vin: vin, Owner: pseudonym, Repairable: true})
+			dropped = append(dropped, vin)
+			continue
+		} else if IsArchived(&car) {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: issueArchivedCar, Vin: vin, Owner: pseudonym, Repairable: true})
+			dropped = append(dropped, vin)
+			continue
+		}
+
+		if pseudonym == "" {
+			continue
+		} else if _, err := resolveOwner(stub, pseudonym); err != nil {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: issueUnknownOwner, Vin: vin, Owner: pseudonym})
+		}
+
+		linked := false
+		for _, owner := range links[vin] {
+			linked = linked || owner == pseudonym
+		}
+		if !linked {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: issueMissingLink, Vin: vin, Owner: pseudonym, Repairable: true})
+		}
+	}
+
+	for _, vin := range dropped {
+		delete(carIndex, vin)
+	}
+
+	for vin, owners := range links {
+		for _, owner := range owners {
+			if carIndex[vin] != owner {
+				report.Issues = append(report.Issues, IntegrityIssue{Kind: issueDanglingLink, Vin: vin, Owner: owner, Repairable: true})
+			}
+		}
+	}
+
+	for vin, car := range cars {
+		if _, indexed := carIndex[vin]; !indexed && !IsArchived(&car) {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: issueUnindexedCar, Vin: vin})
+		}
+	}
+
+	sort.Sort(integrityIssuesByCar(report.Issues))
+
+	if repair {
+		err = repairIndex(stub, dropped, report.Issues)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		for i := range report.Issues {
+			report.Issues[i].Repaired = report.Issues[i].Repairable
+		}
+	}
+
+	fmt.Printf("Index integrity: %d issues, repair %t\n", len(report.Issues), repair)
+
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
+
+/*
+ * Fixes the repairable issues, removing the
+ * 'dropped' entries from the car index
+ */
+func repairIndex(stub shim.ChaincodeStubInterface, dropped []string, issues []IntegrityIssue) error {
+	// VIN order, so all peers write the same
+	sort.Strings(dropped)
+	for _, vin := range dropped {
+		err := unindexCar(stub, vin)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, issue := range issues {
+		if issue.Kind != issueMissingLink && issue.Kind != issueDanglingLink {
+			continue
+		}
+
+		key, err := stub.CreateCompositeKey(ownershipObjectType, []string{issue.Owner, issue.Vin})
+		if err != nil {
+			return newError(ErrInternal, "Error creating ownership key")
+		}
+
+		if issue.Kind == issueMissingLink {
+			err = stub.PutState(key, ownershipValue)
+		} else {
+			err = stub.DelState(key)
+		}
+		if err != nil {
+			return newError(ErrLedger, fmt.Sprintf("Error repairing ownership key of car '%s'", issue.Vin))
+		}
+	}
+
+	return nil
+}
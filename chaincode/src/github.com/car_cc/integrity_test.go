@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Stub that refuses ranges without an end key
+type boundedRangeStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func (s *boundedRangeStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if endKey == "" {
+		return nil, fmt.Errorf("range from '%s' has no end key", startKey)
+	}
+	return s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+}
+
+// Invokes through a 'boundedRangeStub' while strict
+type boundedRangeChaincode struct {
+	CarChaincode
+	strict bool
+}
+
+func (c *boundedRangeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if c.strict {
+		stub = &boundedRangeStub{ChaincodeStubInterface: stub}
+	}
+	return c.CarChaincode.Invoke(stub)
+}
+
+func TestVerifyIndexIntegrity(t *testing.T) {
+	garage := "amag"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781", "WVWZZZ6RXHY260782"}
+
+	cc := &boundedRangeChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	for _, vin := range vins {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	}
+
+	// the check reads bounded key spaces only
+	cc.strict = true
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyIndexIntegrity", "inspector", "dot"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyIndexIntegrity", "root", "admin"))
+	report := IntegrityReport{}
+	json.Unmarshal(response.Payload, &report)
+	if response.Status != shim.OK || report.IndexedCars != 3 || report.CarStates != 3 || report.OwnershipKeys != 3 || len(report.Issues) != 0 {
+		t.Fatalf("Expected a consistent ledger, got %s %s", response.Message, response.Payload)
+	}
+
+	// lose a car, an ownership key and link a car to someone else
+	stub.MockTransactionStart(uuid)
+	stub.DelState(vins[0])
+	removeOwnership(stub, garage, vins[1])
+	addOwnership(stub, "emil", vins[2])
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyIndexIntegrity", "root", "admin"))
+	report = IntegrityReport{}
+	json.Unmarshal(response.Payload, &report)
+	kinds := []string{issueDanglingLink, issueMissingCar, issueMissingLink, issueDanglingLink}
+	if len(report.Issues) != len(kinds) {
+		t.Fatalf("Expected %d issues, got %s", len(kinds), response.Payload)
+	}
+	for i, kind := range kinds {
+		if report.Issues[i].Kind != kind || report.Issues[i].Repaired {
+			t.Errorf("Expected an unrepaired '%s' issue, got %+v", kind, report.Issues[i])
+		}
+	}
+
+	// the car index settles all of them
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyIndexIntegrity", "root", "admin", "true"))
+	report = IntegrityReport{}
+	json.Unmarshal(response.Payload, &report)
+	if len(report.Issues) != len(kinds) || !report.Issues[0].Repaired {
+		t.Fatalf("Expected the issues repaired, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyIndexIntegrity", "root", "admin"))
+	report = IntegrityReport{}
+	json.Unmarshal(response.Payload, &report)
+	if len(report.Issues) != 0 || report.IndexedCars != 2 || report.OwnershipKeys != 2 {
+		t.Errorf("Expected a consistent ledger after the repair, got %s", response.Payload)
+	}
+}
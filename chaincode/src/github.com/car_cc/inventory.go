@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// seconds per day, for days in stock
+const secondsPerDay int64 = 24 * 60 * 60
+
+/*
+ * Returns the inventory index,
+ * the stock of every garage by VIN
+ */
+func (t *CarChaincode) getInventoryIndex(stub shim.ChaincodeStubInterface) (map[string]map[string]InventoryEntry, error) {
+	response := t.read(stub, inventoryIndexStr)
+	inventory := make(map[string]map[string]InventoryEntry)
+	err := ledgerjson.Unmarshal(response.Payload, &inventory)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing inventory index")
+	}
+
+	return inventory, nil
+}
+
+/*
+ * Writes the inventory index back to ledger
+ */
+func (t *CarChaincode) saveInventoryIndex(stub shim.ChaincodeStubInterface, inventory map[string]map[string]InventoryEntry) error {
+	indexAsBytes, _ := ledgerjson.Marshal(inventory)
+	err := stub.PutState(inventoryIndexStr, indexAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing inventory index")
+	}
+
+	return nil
+}
+
+/*
+ * Takes a car out of the stock of a garage,
+ * e.g. after the car was sold.
+ */
+func (t *CarChaincode) removeFromInventory(stub shim.ChaincodeStubInterface, garage string, vin string) error {
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := inventory[garage][vin]; !ok {
+		return nil
+	}
+
+	delete(inventory[garage], vin)
+	return t.saveInventoryIndex(stub, inventory)
+}
+
+/*
+ * Returns the status of a car as shown in the inventory
+ */
+func carStatus(car *Car, now int64) string {
+	switch {
+	case car.ExportedTo != "":
+		return "exported"
+	case car.Handoff.Status != "":
+		return car.Handoff.Status
+	case IsWrittenOff(car):
+		return car.Classification
+	case IsConfirmed(car, now):
+		return "confirmed"
+	case IsInsured(car, now):
+		return "insured"
+	case IsRegistered(car):
+		return "registered"
+	}
+
+	return "unregistered"
+}
+
+/*
+ * Lists all cars in stock of a garage with
+ * their status and the days they are in stock.
+ *
+ * On success,
+ * returns the inventory, oldest stock first.
+ */
+func (t *CarChaincode) getInventory(stub shim.ChaincodeStubInterface, garage string) pb.Response {
+	_, err := t.getUser(stub, garage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	cars, err := getUserCars(stub, garage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	items := []InventoryItem{}
+	for _, vin := range cars {
+		car, err := t.getCar(stub, garage, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		// cars the garage got before the inventory
+		// existed are in stock since their creation
+		entry, ok := inventory[garage][vin]
+		if !ok {
+			entry = InventoryEntry{StockedTs: car.CreatedTs}
+		}
+
+		items = append(items, InventoryItem{
+			Vin:         vin,
+			Status:      carStatus(&car, now),
+			StockedTs:   entry.StockedTs,
+			DaysInStock: (now - entry.StockedTs) / secondsPerDay,
+			Salesperson: entry.Salesperson,
+			Battery:     batteryReport(&car),
+			Appraisal:   latestAppraisal(&car)})
+	}
+
+	sort.Sort(inventoryByStockedTs(items))
+
+	itemsAsBytes, _ := ledgerjson.Marshal(items)
+	return shim.Success(itemsAsBytes)
+}
+
+/*
+ * Onboards the existing stock of a dealership in one
+ * transaction. Either all cars are imported or none.
+ *
+ * Arguments required:
+ * [0] List of cars to import      (json, []InventoryImport)
+ *
+ * On success,
+ * returns the imported cars.
+ */
+func (t *CarChaincode) bulkImportCars(stub shim.ChaincodeStubInterface, garage string, importData string) pb.Response {
+	imports := []InventoryImport{}
+	err := ledgerjson.Unmarshal([]byte(importData), &imports)
+	if err != nil || len(imports) == 0 {
+		return errorResponse(ErrInvalidArgument, "'bulkImportCars' expects a non-empty list of cars as json")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	batch, err := t.newCarBatch(stub, garage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	cars := []Car{}
+	for _, item := range imports {
+		car := item.Car
+		car.CreatedTs = now
+
+		stockedTs := item.StockedTs
+		if stockedTs == 0 {
+			stockedTs = now
+		} else if stockedTs > now {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("Car with vin '%s' cannot be in stock from a future date", car.Vin))
+		}
+
+		err = batch.addCar(stub, &car, item.RegistrationProposal, stockedTs)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		entry := batch.inventory[garage][car.Vin]
+		entry.Salesperson = item.Salesperson
+		batch.inventory[garage][car.Vin] = entry
+
+		cars = append(cars, car)
+	}
+
+	err = t.saveCarBatch(stub, batch)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Imported %d cars into the inventory of garage '%s'\n", len(cars), garage)
+
+	carsAsBytes, _ := ledgerjson.Marshal(cars)
+	return shim.Success(carsAsBytes)
+}
+
+/*
+ * Assigns a salesperson of the garage to a car in stock.
+ *
+ * On success,
+ * returns the inventory entry of the car.
+ */
+func (t *CarChaincode) assignSalesperson(stub shim.ChaincodeStubInterface, garage string, vin string, salesperson string) pb.Response {
+	if salesperson == "" {
+		return errorResponse(ErrInvalidArgument, "'assignSalesperson' expects a non-empty salesperson")
+	}
+
+	// only cars of the garage can be assigned
+	car, err := t.getCar(stub, garage, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	inventory, err := t.getInventoryIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	stock, ok := inventory[garage]
+	if !ok {
+		stock = make(map[string]InventoryEntry)
+		inventory[garage] = stock
+	}
+
+	entry, ok := stock[vin]
+	if !ok {
+		entry = InventoryEntry{StockedTs: car.CreatedTs}
+	}
+
+	entry.Salesperson = salesperson
+	stock[vin] = entry
+
+	err = t.saveInventoryIndex(stub, inventory)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	entryAsBytes, _ := ledgerjson.Marshal(entry)
+	return shim.Success(entryAsBytes)
+}
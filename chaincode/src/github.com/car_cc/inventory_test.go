@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestGarageInventory(t *testing.T) {
+	garage := "amag"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	// a duplicate VIN fails the whole import
+	tenDaysAgo := strconv.FormatInt(stub.TxTimestamp.Seconds-10*secondsPerDay, 10)
+	importData := `[
+		{ "car": { "vin": "WVWZZZ6R6HY260780" }, "stocked_ts": ` + tenDaysAgo + `, "salesperson": "anna" },
+		{ "car": { "vin": "WVWZZZ6R6HY260780" } }
+	]`
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("bulkImportCars", garage, "garage", importData))
+	expectErrorCode(t, response, ErrCarExists)
+
+	importData = `[
+		{ "car": { "vin": "WVWZZZ6R6HY260780" }, "stocked_ts": ` + tenDaysAgo + `, "salesperson": "anna" },
+		{ "car": { "vin": "WVWZZZ6R8HY260781" } }
+	]`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("bulkImportCars", garage, "garage", importData))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "WVWZZZ6RXHY260782" }`))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assignSalesperson", garage, "garage", "WVWZZZ6RXHY260782", "ben"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", garage, "garage"))
+	items := []InventoryItem{}
+	err := json.Unmarshal(response.Payload, &items)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("Inventory should hold 3 cars, but holds %d", len(items))
+	}
+
+	// oldest stock first
+	if items[0].Vin != "WVWZZZ6R6HY260780" || items[0].DaysInStock != 10 || items[0].Salesperson != "anna" {
+		t.Errorf("Unexpected oldest inventory item: %v", items[0])
+	} else if items[0].Status != "unregistered" {
+		t.Errorf("Imported car should be unregistered, but is '%s'", items[0].Status)
+	}
+
+	if items[2].Vin != "WVWZZZ6RXHY260782" || items[2].Salesperson != "ben" {
+		t.Errorf("Unexpected newest inventory item: %v", items[2])
+	}
+
+	// sold cars leave the inventory
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", garage, "garage", "WVWZZZ6R6HY260780", "bobby"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInventory", garage, "garage"))
+	err = json.Unmarshal(response.Payload, &items)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(items) != 2 {
+		t.Errorf("Inventory should hold 2 cars after the sale, but holds %d", len(items))
+	}
+}
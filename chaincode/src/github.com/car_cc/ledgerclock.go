@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Ledger clock.
+ *
+ * Every endorsing peer executes a transaction on its own, so
+ * reading the wall clock gives each peer a different time and
+ * their endorsements no longer match. All timestamps are taken
+ * from the transaction proposal instead, which is the same on
+ * all peers.
+ */
+
+/*
+ * Returns the time of the transaction proposal in UTC
+ */
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, newError(ErrInternal, "Error reading transaction timestamp")
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+/*
+ * Returns the time of the transaction proposal
+ * as unix timestamp
+ */
+func txUnix(stub shim.ChaincodeStubInterface) (int64, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	return now.Unix(), nil
+}
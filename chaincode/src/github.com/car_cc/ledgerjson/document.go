@@ -0,0 +1,128 @@
+package ledgerjson
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+ * Versioned documents.
+ *
+ * Structs stored on the ledger implement 'Document'.
+ * 'Marshal' stamps them with their 'docType' and
+ * 'schemaVersion', and 'Unmarshal' upgrades documents
+ * of an older version on read with the upgrades
+ * registered for the type, so renaming a field does not
+ * break state written before. Upgraded documents are
+ * written in the current version the next time they
+ * are saved.
+ *
+ * Documents without a stamp predate it and are read as
+ * version 0, which has the shape of version 1.
+ */
+
+// fields stamped on every document
+const docTypeField string = "docType"
+const schemaVersionField string = "schemaVersion"
+
+/*
+ * Struct stored on the ledger
+ */
+type Document interface {
+	DocType() string
+	SchemaVersion() int // current version, raised with every upgrade
+}
+
+/*
+ * Converts a decoded document from one schema version
+ * to the next, in place
+ */
+type Upgrade func(document map[string]interface{}) error
+
+// upgrades by document type and the version they upgrade from
+var upgrades = map[string]map[int]Upgrade{}
+
+/*
+ * Registers the upgrade of documents of 'docType'
+ * from version 'from' to 'from' + 1
+ */
+func RegisterUpgrade(docType string, from int, upgrade Upgrade) {
+	if upgrades[docType] == nil {
+		upgrades[docType] = map[int]Upgrade{}
+	}
+
+	upgrades[docType][from] = upgrade
+}
+
+/*
+ * Parses the JSON document 'data' into 'v'.
+ *
+ * Documents written in an older schema version are
+ * upgraded first. Other values are parsed as
+ * 'encoding/json' does.
+ */
+func Unmarshal(data []byte, v interface{}) error {
+	doc, ok := v.(Document)
+	if !ok {
+		return json.Unmarshal(data, v)
+	}
+
+	stamp := struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}{}
+	err := json.Unmarshal(data, &stamp)
+	if err != nil {
+		// let 'encoding/json' report what is wrong
+		return json.Unmarshal(data, v)
+	}
+
+	version := stamp.SchemaVersion
+	if version > doc.SchemaVersion() {
+		return fmt.Errorf("%s has schema version %d, newer than %d", doc.DocType(), version, doc.SchemaVersion())
+	} else if version == doc.SchemaVersion() || !hasUpgrade(doc.DocType(), version, doc.SchemaVersion()) {
+		return json.Unmarshal(data, v)
+	}
+
+	value, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	document, ok := value.(map[string]interface{})
+	if !ok {
+		return json.Unmarshal(data, v)
+	}
+
+	for ; version < doc.SchemaVersion(); version++ {
+		upgrade, ok := upgrades[doc.DocType()][version]
+		if !ok {
+			continue
+		}
+
+		err = upgrade(document)
+		if err != nil {
+			return fmt.Errorf("upgrading %s from schema version %d: %s", doc.DocType(), version, err.Error())
+		}
+	}
+
+	upgradedAsBytes, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(upgradedAsBytes, v)
+}
+
+/*
+ * Checks if there is an upgrade of 'docType' between
+ * the versions 'from' and 'to'
+ */
+func hasUpgrade(docType string, from int, to int) bool {
+	for version := from; version < to; version++ {
+		if _, ok := upgrades[docType][version]; ok {
+			return true
+		}
+	}
+
+	return false
+}
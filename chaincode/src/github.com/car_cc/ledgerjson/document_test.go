@@ -0,0 +1,70 @@
+package ledgerjson
+
+import (
+	"fmt"
+	"testing"
+)
+
+type garage struct {
+	Name string `json:"name"`
+	Zip  string `json:"zip"`
+}
+
+func (garage) DocType() string    { return "garage" }
+func (garage) SchemaVersion() int { return 2 }
+
+func init() {
+	// version 2 renamed 'postcode' to 'zip'
+	RegisterUpgrade("garage", 1, func(document map[string]interface{}) error {
+		if _, ok := document["postcode"].(string); !ok {
+			return fmt.Errorf("'postcode' is missing")
+		}
+		document["zip"] = document["postcode"]
+		delete(document, "postcode")
+		return nil
+	})
+}
+
+func TestDocumentStamp(t *testing.T) {
+	dataAsBytes, _ := Marshal(garage{Name: "amag", Zip: "8005"})
+	expected := `{"docType":"garage","name":"amag","schemaVersion":2,"zip":"8005"}`
+	if string(dataAsBytes) != expected {
+		t.Errorf("Expected %s, got %s", expected, dataAsBytes)
+	}
+
+	dataAsBytes, _ = Marshal((*garage)(nil))
+	if string(dataAsBytes) != "null" {
+		t.Errorf("Expected null, got %s", dataAsBytes)
+	}
+}
+
+func TestDocumentUpgrade(t *testing.T) {
+	g := garage{}
+	err := Unmarshal([]byte(`{"docType":"garage","name":"amag","postcode":"8005","schemaVersion":1}`), &g)
+	if err != nil || g.Zip != "8005" {
+		t.Errorf("Expected the postcode upgraded to zip, got %+v, %v", g, err)
+	}
+
+	// unstamped documents have the shape of version 1
+	g = garage{}
+	Unmarshal([]byte(`{"name":"amag","postcode":"8005"}`), &g)
+	if g.Zip != "8005" {
+		t.Errorf("Expected the legacy document upgraded, got %+v", g)
+	}
+
+	g = garage{}
+	Unmarshal([]byte(`{"name":"amag","zip":"8005","schemaVersion":2}`), &g)
+	if g.Zip != "8005" {
+		t.Errorf("Expected the current document as it is, got %+v", g)
+	}
+
+	err = Unmarshal([]byte(`{"name":"amag","schemaVersion":1}`), &g)
+	if err == nil {
+		t.Error("Expected the failed upgrade to be reported")
+	}
+
+	err = Unmarshal([]byte(`{"name":"amag","schemaVersion":3}`), &g)
+	if err == nil {
+		t.Error("Expected documents of a newer version to be refused")
+	}
+}
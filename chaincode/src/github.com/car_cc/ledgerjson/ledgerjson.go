@@ -0,0 +1,184 @@
+package ledgerjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Canonical JSON for ledger state.
+ *
+ * Every peer endorsing a transaction has to write the same
+ * bytes, and hashes of state, like the state hash of car
+ * certificates, have to be reproducible by anybody. The
+ * output of 'encoding/json' follows the declaration order
+ * of struct fields and the float formatting of the Go
+ * version, so it is canonicalized here:
+ *
+ *  - object keys are sorted, for structs and maps alike
+ *  - integers are written as they are, so int64 values
+ *    like timestamps stay exact
+ *  - other numbers with an integral value below 2^53 are
+ *    written as integers ('2.0' becomes '2')
+ *  - all other numbers are written in the shortest form
+ *    that parses back to the same float64
+ *  - there is no insignificant whitespace
+ *
+ * Strings are escaped like 'encoding/json' does.
+ * Documents are stamped with their type and schema
+ * version, see 'Document'.
+ */
+
+// largest integer a float64 holds exactly
+const maxExactInteger float64 = 1 << 53
+
+/*
+ * Returns the canonical JSON encoding of 'v'
+ */
+func Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// nil documents are written as null, without stamp
+	if object, ok := value.(map[string]interface{}); ok {
+		if doc, ok := v.(Document); ok {
+			object[docTypeField] = doc.DocType()
+			object[schemaVersionField] = json.Number(strconv.Itoa(doc.SchemaVersion()))
+		}
+	}
+
+	return canonical(value)
+}
+
+/*
+ * Rewrites the JSON document 'data' in canonical form
+ */
+func Canonicalize(data []byte) ([]byte, error) {
+	value, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return canonical(value)
+}
+
+/*
+ * Decodes a JSON value, keeping numbers as written
+ */
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+/*
+ * Returns the canonical encoding of a decoded value
+ */
+func canonical(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := encode(&buffer, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+/*
+ * Writes a decoded JSON value to 'buffer'
+ */
+func encode(buffer *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteString("null")
+	case bool:
+		buffer.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		number, err := formatNumber(v)
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(number)
+	case string:
+		stringAsBytes, _ := json.Marshal(v)
+		buffer.Write(stringAsBytes)
+	case []interface{}:
+		buffer.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			err := encode(buffer, element)
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buffer.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			keyAsBytes, _ := json.Marshal(key)
+			buffer.Write(keyAsBytes)
+			buffer.WriteByte(':')
+			err := encode(buffer, v[key])
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	}
+
+	return nil
+}
+
+/*
+ * Returns the canonical form of a number
+ */
+func formatNumber(number json.Number) (string, error) {
+	literal := number.String()
+	if !strings.ContainsAny(literal, ".eE") {
+		return literal, nil
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return "", err
+	} else if f == math.Trunc(f) && math.Abs(f) < maxExactInteger {
+		return strconv.FormatInt(int64(f), 10), nil
+	}
+
+	// 'encoding/json' writes the shortest exact form
+	floatAsBytes, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+
+	return string(floatAsBytes), nil
+}
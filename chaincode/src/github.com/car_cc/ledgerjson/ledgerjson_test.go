@@ -0,0 +1,43 @@
+package ledgerjson
+
+import (
+	"testing"
+)
+
+type battery struct {
+	Soh    float64        `json:"soh"`
+	Cycles int64          `json:"cycles"`
+	Parts  map[string]int `json:"parts"`
+	Note   string         `json:"note"`
+}
+
+func TestMarshal(t *testing.T) {
+	dataAsBytes, err := Marshal(battery{Soh: 87.5, Cycles: 1234567890123, Parts: map[string]int{"z": 1, "a": 2}, Note: `new "cells"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"cycles":1234567890123,"note":"new \"cells\"","parts":{"a":2,"z":1},"soh":87.5}`
+	if string(dataAsBytes) != expected {
+		t.Errorf("Expected %s, got %s", expected, dataAsBytes)
+	}
+}
+
+func TestCanonicalizeNumbers(t *testing.T) {
+	for input, expected := range map[string]string{
+		`[2.0, 1e2, -0.0, 1.50, 1e-7, 12345678901234567890]`: `[2,100,0,1.5,1e-7,12345678901234567890]`,
+		`{ "b": [ true, null ], "a": { "y": 1, "x": "" } }`:  `{"a":{"x":"","y":1},"b":[true,null]}`,
+	} {
+		canonical, err := Canonicalize([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(canonical) != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, canonical)
+		}
+	}
+
+	_, err := Canonicalize([]byte(`{ "a": `))
+	if err == nil {
+		t.Error("Expected an error for malformed JSON")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Market listings and deposits.
+ *
+ * The owner lists a car for an asking price with 'listCar'.
+ * A buyer takes a listed car off the market for the hold
+ * period with 'placeDeposit', the deposit is taken from their
+ * balance. While the hold lasts, the car can only be sold to
+ * the buyer, and the deposit counts towards the price.
+ *
+ * 'cancelDeposit' ends a hold early. If the buyer backs out,
+ * the deposit goes to the owner. If the owner backs out, or
+ * the hold period is over, it goes back to the buyer.
+ *
+ * Listing a car stakes the 'listing_deposit' of the fee
+ * schedule from the seller's balance. The stake goes back
+ * with the sale or any other change of owner, and when
+ * taking the car off the market while no deposit holds it.
+ * A seller backing out during the hold period, by
+ * unlisting the car or cancelling the deposit, forfeits
+ * the stake to the buyer.
+ */
+
+// days a deposit holds a car by default
+const defaultDepositHoldDays int = 7
+
+// fee name of the listing deposit and its default
+const listingDepositName string = "listing_deposit"
+const defaultListingDeposit int = 5
+
+/*
+ * Checks if a car is listed on the market
+ */
+func IsListed(car *Car) bool {
+	return car.Listing.Price > 0
+}
+
+/*
+ * Checks if a deposit holds a car at 'now'
+ */
+func IsHeld(car *Car, now int64) bool {
+	return car.Listing.Hold != nil && now < car.Listing.Hold.ExpiresTs
+}
+
+/*
+ * Checks that a held car only goes to the depositor
+ */
+func checkHold(car *Car, receiver string, now int64) error {
+	if IsHeld(car, now) && car.Listing.Hold.Buyer != receiver {
+		return newError(ErrInvalidState, fmt.Sprintf("The car is held by a deposit of '%s'", car.Listing.Hold.Buyer))
+	}
+
+	return nil
+}
+
+/*
+ * Returns who paid a deposit and gets it back,
+ * the bank of a loan or the buyer
+ */
+func depositPayer(hold *DepositHold) string {
+	if hold.Funder != "" {
+		return hold.Funder
+	}
+
+	return hold.Buyer
+}
+
+/*
+ * Takes the stake off the listing of a car,
+ * returning what the seller gets back
+ */
+func releaseListingStake(car *Car) int {
+	stake := car.Listing.Stake
+	car.Listing.Stake = 0
+	return stake
+}
+
+/*
+ * Returns how long a deposit holds a car, in seconds
+ */
+func depositHoldPeriod(config Config) int64 {
+	days := config.DepositHoldDays
+	if days <= 0 {
+		days = defaultDepositHoldDays
+	}
+
+	return int64(days) * secondsPerDay
+}
+
+/*
+ * Lists a car for 'price', or changes the asking price.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) listCar(stub shim.ChaincodeStubInterface, username string, vin string, price int) pb.Response {
+	if price <= 0 {
+		return errorResponse(ErrInvalidArgument, "'listCar' expects a positive asking price")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsActive(&car) {
+		return errorResponse(ErrNotActive, "The car is handed off to another channel and cannot be listed")
+	} else if IsFrozen(&car) {
+		return errorResponse(ErrInvalidState, "The car is frozen and cannot be listed")
+	} else if IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is sold in installments to '%s'", car.Installments.Buyer))
+	} else if car.Listing.Hold != nil {
+		return errorResponse(ErrInvalidState, "A deposit is placed on the car. It has to be cancelled first")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the stake is taken once, when the car goes on the market
+	if !IsListed(&car) {
+		config, err := t.getConfig(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		stake := scheduledFee(config, listingDepositName, price, defaultListingDeposit)
+		if stake > 0 {
+			_, err = t.updateBalance(stub, username, -stake)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+		}
+
+		car.Listing.ListedTs = now
+		car.Listing.Stake = stake
+	}
+	car.Listing.Price = price
+
+	return t.saveListedCar(stub, &car)
+}
+
+/*
+ * Takes a car off the market. A deposit on the car
+ * goes back to whoever paid it, the stake of the
+ * seller goes to the buyer during the hold period.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) unlistCar(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) {
+		return errorResponse(ErrInvalidState, "Car is not listed")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	stake := releaseListingStake(&car)
+	if hold := car.Listing.Hold; hold != nil {
+		payer, refund, forfeit := depositPayer(hold), hold.Amount, 0
+		if IsHeld(&car, now) {
+			forfeit, stake = stake, 0
+		}
+		if payer == hold.Buyer {
+			refund, forfeit = refund+forfeit, 0
+		}
+
+		_, err = t.updateBalance(stub, payer, refund)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		if forfeit > 0 {
+			_, err = t.updateBalance(stub, hold.Buyer, forfeit)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+		}
+	}
+
+	if stake > 0 {
+		_, err = t.updateBalance(stub, username, stake)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	car.Listing = Listing{}
+
+	return t.saveListedCar(stub, &car)
+}
+
+/*
+ * Places a deposit of 'amount' on a listed car, which
+ * takes it off the market for the hold period. A deposit
+ * of someone else whose hold period is over goes back.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) placeDeposit(stub shim.ChaincodeStubInterface, username string, vin string, amount int) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner == username {
+		return errorResponse(ErrInvalidArgument, "'placeDeposit' expects a car of another user")
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) {
+		return errorResponse(ErrInvalidState, "Car is not listed")
+	}
+
+	if amount <= 0 || amount > car.Listing.Price {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'placeDeposit' expects a deposit between 1 and the asking price of %d", car.Listing.Price))
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkHold(&car, username, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkQueue(&car, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// a lapsed deposit goes back first, the buyer's
+	// own deposit is replaced in a single update
+	charge := amount
+	if hold := car.Listing.Hold; hold != nil && depositPayer(hold) == username {
+		charge -= hold.Amount
+	} else if hold != nil {
+		_, err = t.updateBalance(stub, depositPayer(hold), hold.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	_, err = t.updateBalance(stub, username, -charge)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	previousTs := int64(0)
+	if car.Listing.Hold != nil {
+		previousTs = car.Listing.Hold.ExpiresTs
+	}
+
+	car.Listing.Hold = &DepositHold{
+		Buyer:     username,
+		Amount:    amount,
+		PlacedTs:  now,
+		ExpiresTs: now + depositHoldPeriod(config),
+	}
+
+	err = updateExpiry(stub, expiryDeposit, vin, previousTs, car.Listing.Hold.ExpiresTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Deposit of %d placed on car '%s' by '%s'\n", amount, vin, username)
+	return t.saveListedCar(stub, &car)
+}
+
+/*
+ * Cancels the deposit on a car, by the buyer or the owner.
+ *
+ * The deposit goes to the owner if the buyer backs out
+ * during the hold period, otherwise back to whoever paid it.
+ * An owner backing out during the hold period forfeits
+ * the stake to the buyer. The car stays listed.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) cancelDeposit(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	hold := car.Listing.Hold
+	if hold == nil {
+		return errorResponse(ErrInvalidState, "There is no deposit on the car")
+	} else if username != owner && username != hold.Buyer {
+		return errorResponse(ErrForbidden, "Forbidden: only the owner and the buyer can cancel the deposit")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	receiver, amount, forfeit := depositPayer(hold), hold.Amount, 0
+	if username == hold.Buyer && IsHeld(&car, now) {
+		receiver = owner
+	} else if username == owner && IsHeld(&car, now) {
+		forfeit = releaseListingStake(&car)
+	}
+	if receiver == hold.Buyer {
+		amount, forfeit = amount+forfeit, 0
+	}
+
+	// backing out during the hold period cancels the deal
+	if IsHeld(&car, now) {
+		counterparty, side := owner, sideBuyer
+		if username == owner {
+			counterparty, side = hold.Buyer, sideSeller
+		}
+
+		err = recordReputation(stub, username, reputationCancelledDeal, vin, counterparty, side)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	_, err = t.updateBalance(stub, receiver, amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if forfeit > 0 {
+		_, err = t.updateBalance(stub, hold.Buyer, forfeit)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	car.Listing.Hold = nil
+	fmt.Printf("Deposit on car '%s' cancelled by '%s', %d went to '%s'\n", vin, username, amount, receiver)
+
+	return t.saveListedCar(stub, &car)
+}
+
+/*
+ * Sets the badges of a listed car
+ */
+func refreshListingBadges(car *Car) {
+	car.Listing.Badges = nil
+	if IsListed(car) && IsHistoric(car) {
+		car.Listing.Badges = append(car.Listing.Badges, badgeHistoric)
+	}
+	if IsListed(car) && car.UnpaidFines > 0 {
+		car.Listing.Badges = append(car.Listing.Badges, badgeUnpaidFines)
+	}
+}
+
+/*
+ * Writes a car with a changed listing
+ */
+func (t *CarChaincode) saveListedCar(stub shim.ChaincodeStubInterface, car *Car) pb.Response {
+	refreshListingBadges(car)
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
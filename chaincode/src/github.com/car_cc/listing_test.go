@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDepositOnListedCar(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	other := "mallory"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", other, "user"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "20"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "61"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "20"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// the car is off the market for everyone else
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", other, "user", vin, "30"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, other))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", seller, "garage", vin, buyer))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// the buyer backs out and loses the deposit
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("cancelDeposit", buyer, "user", vin))
+
+	// the owner backs out and the deposit goes back
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", other, "user", vin, "10"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("cancelDeposit", seller, "garage", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Listing.Hold != nil || !IsListed(&car) {
+		t.Fatalf("Expected the car to stay listed without deposit, got %s", response.Message)
+	}
+
+	// the deposit converts into the purchase
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "20"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Username != buyer || IsListed(&car) {
+		t.Fatalf("Expected the car to be sold to the buyer and unlisted, got %s", response.Message)
+	}
+
+	// the owner forfeited the listing stake to 'other' when backing out
+	balances := map[string]int{seller: 100 - defaultListingDeposit + 20 + 60, buyer: 100 - 20 - 60, other: 100 + defaultListingDeposit}
+	for username, balance := range balances {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected a balance of %d for '%s', got %d", balance, username, user.Balance)
+		}
+	}
+}
+
+func TestListingStake(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+
+	balance := func(username string) int {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		return user.Balance
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Listing.Stake != defaultListingDeposit || balance(seller) != 100-defaultListingDeposit {
+		t.Fatalf("Expected a stake of %d, got %d", defaultListingDeposit, car.Listing.Stake)
+	}
+
+	// changing the price stakes nothing more
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "50"))
+
+	// an honest withdrawal gets the stake back
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("unlistCar", seller, "garage", vin))
+	if balance(seller) != 100 {
+		t.Errorf("Expected the stake back, balance is %d", balance(seller))
+	}
+
+	// backing out after the buyer's deposit forfeits it
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "20"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("unlistCar", seller, "garage", vin))
+	if balance(seller) != 100-defaultListingDeposit || balance(buyer) != 100+defaultListingDeposit {
+		t.Errorf("Expected the stake to go to the buyer, balances are %d and %d", balance(seller), balance(buyer))
+	}
+
+	// a sale gets the stake back
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	if balance(seller) != 100-defaultListingDeposit+60 {
+		t.Errorf("Expected the price and the stake for the seller, balance is %d", balance(seller))
+	}
+}
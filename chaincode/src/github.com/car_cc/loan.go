@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Car loans.
+ *
+ * A buyer asks a bank to finance part of the asking price
+ * of a listed car with 'requestLoan'. Loans are kept under
+ * 'loan~<id>', the id being the transaction id of the
+ * request. The bank turns the request down with 'rejectLoan'
+ * or funds it with 'approveLoan', which places the amount
+ * from the bank's balance as deposit of the buyer on the
+ * car. The deposit counts towards the price like any other,
+ * if it goes back instead, it goes back to the bank.
+ *
+ * When the car goes to the buyer on the funded deposit, it
+ * is pledged to the bank with a lien for the amount and its
+ * interest, see 'loanSchedule'. A car under a lien cannot be
+ * transferred until the owner has repaid the loan with
+ * 'repayLoan'.
+ */
+
+// object type of loan keys
+const loanObjectType string = "loan"
+
+// loan states
+const loanRequested string = "requested"
+const loanRejected string = "rejected"
+const loanFunded string = "funded"
+const loanSecured string = "secured"
+const loanRepaid string = "repaid"
+
+/*
+ * Returns the ledger key of loan 'id'
+ */
+func getLoanKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(loanObjectType, []string{id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating loan key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads loan 'id'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getLoan(stub shim.ChaincodeStubInterface, id string) (*Loan, error) {
+	key, err := getLoanKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+
+	loanAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading loan")
+	} else if loanAsBytes == nil {
+		return nil, nil
+	}
+
+	loan := Loan{}
+	err = ledgerjson.Unmarshal(loanAsBytes, &loan)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing loan")
+	}
+
+	return &loan, nil
+}
+
+/*
+ * Writes a loan
+ */
+func saveLoan(stub shim.ChaincodeStubInterface, loan *Loan) error {
+	key, err := getLoanKey(stub, loan.Id)
+	if err != nil {
+		return err
+	}
+
+	loanAsBytes, _ := ledgerjson.Marshal(loan)
+	err = stub.PutState(key, loanAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing loan")
+	}
+
+	return nil
+}
+
+/*
+ * Reads loan 'id' of 'bank' in state 'status'
+ */
+func getBankLoan(stub shim.ChaincodeStubInterface, bank string, id string, status string) (*Loan, error) {
+	loan, err := getLoan(stub, id)
+	if err != nil {
+		return nil, err
+	} else if loan == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no loan '%s'", id))
+	} else if loan.Bank != bank {
+		return nil, newError(ErrForbidden, "Forbidden: the loan was requested from another bank")
+	} else if loan.Status != status {
+		return nil, newError(ErrInvalidState, fmt.Sprintf("The loan is %s", loan.Status))
+	}
+
+	return loan, nil
+}
+
+/*
+ * Checks that a car pledged to a bank stays with the owner
+ */
+func checkLien(car *Car) error {
+	if car.Lien != nil {
+		return newError(ErrInvalidState, fmt.Sprintf("The car is pledged to '%s'. The loan has to be repaid first in order to do the transfer", car.Lien.Bank))
+	}
+
+	return nil
+}
+
+/*
+ * Pledges a car going to the buyer on a deposit
+ * funded by a loan to the bank
+ */
+func pledgeCar(stub shim.ChaincodeStubInterface, car *Car, hold *DepositHold) error {
+	if hold.Loan == "" {
+		return nil
+	}
+
+	loan, err := getLoan(stub, hold.Loan)
+	if err != nil {
+		return err
+	} else if loan == nil {
+		return newError(ErrLedger, fmt.Sprintf("Loan '%s' funding the deposit is missing", hold.Loan))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	loan.Status = loanSecured
+	loan.SecuredTs = now
+	err = saveLoan(stub, loan)
+	if err != nil {
+		return err
+	}
+
+	car.Lien = &Lien{
+		Bank:      loan.Bank,
+		Loan:      loan.Id,
+		Amount:    loanTotal(loanSchedule(loan)),
+		CreatedTs: now}
+
+	fmt.Printf("Car '%s' pledged to '%s' for %d\n", car.Vin, loan.Bank, car.Lien.Amount)
+	return nil
+}
+
+/*
+ * Asks 'bank' to finance 'amount' of the asking
+ * price of listed car 'vin' for 'username'.
+ *
+ * On success,
+ * returns the loan.
+ */
+func (t *CarChaincode) requestLoan(stub shim.ChaincodeStubInterface, username string, vin string, bank string, amount int) pb.Response {
+	if bank == "" || bank == username {
+		return errorResponse(ErrInvalidArgument, "'requestLoan' expects another user as bank")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner == username || owner == bank {
+		return errorResponse(ErrInvalidArgument, "'requestLoan' expects a car of another user")
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) {
+		return errorResponse(ErrInvalidState, "Car is not listed")
+	} else if amount <= 0 || amount > car.Listing.Price {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'requestLoan' expects an amount between 1 and the asking price of %d", car.Listing.Price))
+	}
+
+	_, err = t.getUser(stub, bank)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	loan := Loan{
+		Id:          stub.GetTxID(),
+		Vin:         vin,
+		Buyer:       username,
+		Seller:      owner,
+		Bank:        bank,
+		Amount:      amount,
+		Status:      loanRequested,
+		RequestedTs: now}
+
+	err = saveLoan(stub, &loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("'%s' asked '%s' for a loan of %d on car '%s'\n", username, bank, amount, vin)
+
+	loanAsBytes, _ := ledgerjson.Marshal(loan)
+	return shim.Success(loanAsBytes)
+}
+
+/*
+ * Funds loan 'id' on 'terms', placing the amount from
+ * the balance of the bank as deposit of the buyer on
+ * the car. A lapsed deposit of someone else goes back.
+ *
+ * On success,
+ * returns the loan.
+ */
+func (t *CarChaincode) approveLoan(stub shim.ChaincodeStubInterface, username string, id string, terms LoanTerms) pb.Response {
+	if terms.Rate < 0 {
+		return errorResponse(ErrInvalidArgument, "'approveLoan' expects an interest rate of at least 0")
+	} else if terms.PeriodDays <= 0 {
+		return errorResponse(ErrInvalidArgument, "'approveLoan' expects a period of at least one day")
+	}
+
+	loan, err := getBankLoan(stub, username, id, loanRequested)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if terms.Installments <= 0 || terms.Installments > loan.Amount {
+		return errorResponse(ErrInvalidArgument, "'approveLoan' expects between 1 installment and one per credit of the loan")
+	}
+
+	owner, err := t.getOwner(stub, loan.Vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner != loan.Seller {
+		return errorResponse(ErrInvalidState, "The car changed owner since the loan was requested")
+	}
+
+	car, err := t.getCar(stub, owner, loan.Vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) || loan.Amount > car.Listing.Price {
+		return errorResponse(ErrInvalidState, "The car is no longer listed for at least the amount of the loan")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkHold(&car, loan.Buyer, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkQueue(&car, loan.Buyer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the deposit on the car goes back, a deposit
+	// the bank paid itself is replaced in a single update
+	charge := loan.Amount
+	previousTs := int64(0)
+	if hold := car.Listing.Hold; hold != nil {
+		previousTs = hold.ExpiresTs
+		if depositPayer(hold) == username {
+			charge -= hold.Amount
+		} else {
+			_, err = t.updateBalance(stub, depositPayer(hold), hold.Amount)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+		}
+	}
+
+	_, err = t.updateBalance(stub, username, -charge)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Listing.Hold = &DepositHold{
+		Buyer:     loan.Buyer,
+		Amount:    loan.Amount,
+		PlacedTs:  now,
+		ExpiresTs: now + depositHoldPeriod(config),
+		Funder:    username,
+		Loan:      loan.Id,
+	}
+
+	err = updateExpiry(stub, expiryDeposit, loan.Vin, previousTs, car.Listing.Hold.ExpiresTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	loan.Status = loanFunded
+	loan.Terms = terms
+	loan.DecidedTs = now
+	err = saveLoan(stub, loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	response := t.saveListedCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	fmt.Printf("Loan '%s' of %d for car '%s' funded by '%s'\n", id, loan.Amount, loan.Vin, username)
+
+	loanAsBytes, _ := ledgerjson.Marshal(loan)
+	return shim.Success(loanAsBytes)
+}
+
+/*
+ * Turns loan 'id' down for 'reason'.
+ *
+ * On success,
+ * returns the loan.
+ */
+func (t *CarChaincode) rejectLoan(stub shim.ChaincodeStubInterface, username string, id string, reason string) pb.Response {
+	loan, err := getBankLoan(stub, username, id, loanRequested)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	loan.Status = loanRejected
+	loan.Reason = reason
+	loan.DecidedTs = now
+	err = saveLoan(stub, loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	loanAsBytes, _ := ledgerjson.Marshal(loan)
+	return shim.Success(loanAsBytes)
+}
+
+/*
+ * Pays back 'amount' of the loan car 'vin' is
+ * pledged for. The lien ends with the last payment.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) repayLoan(stub shim.ChaincodeStubInterface, username string, vin string, amount int) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Lien == nil {
+		return errorResponse(ErrInvalidState, "The car is not pledged to a bank")
+	} else if amount <= 0 || amount > car.Lien.Amount {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'repayLoan' expects an amount between 1 and the outstanding %d", car.Lien.Amount))
+	}
+
+	loan, err := getLoan(stub, car.Lien.Loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if loan == nil {
+		return errorResponse(ErrLedger, fmt.Sprintf("Loan '%s' of the lien is missing", car.Lien.Loan))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// paying while installments are overdue counts as late
+	if loanStatusAt(loan, now).Arrears > 0 {
+		loan.LatePayments++
+	}
+
+	_, err = t.updateBalance(stub, username, -amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, car.Lien.Bank, amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	loan.Repaid += amount
+	car.Lien.Amount -= amount
+	if car.Lien.Amount == 0 {
+		loan.Status = loanRepaid
+		car.Lien = nil
+		fmt.Printf("Loan '%s' repaid, car '%s' released\n", loan.Id, vin)
+	}
+
+	err = saveLoan(stub, loan)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Reads loan 'id' for the buyer,
+ * the seller and the bank.
+ *
+ * On success,
+ * returns the loan.
+ */
+func (t *CarChaincode) readLoan(stub shim.ChaincodeStubInterface, username string, id string) pb.Response {
+	loan, err := getLoan(stub, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if loan == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no loan '%s'", id))
+	} else if username != loan.Buyer && username != loan.Seller && username != loan.Bank {
+		return errorResponse(ErrForbidden, "Forbidden: only the buyer, the seller and the bank can read the loan")
+	}
+
+	loanAsBytes, _ := ledgerjson.Marshal(loan)
+	return shim.Success(loanAsBytes)
+}
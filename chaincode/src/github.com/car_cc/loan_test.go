@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestLoanLien(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	bank := "ubs"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", bank, "bank"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "emil", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+
+	response := stub.MockInvoke("1", util.ToChaincodeArgs("requestLoan", buyer, "user", vin, bank, "40"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", buyer, "user", "1"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", "emil", "bank", "1"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveLoan", bank, "bank", "1"))
+	loan := Loan{}
+	json.Unmarshal(response.Payload, &loan)
+	if loan.Status != loanFunded {
+		t.Fatalf("Expected a funded loan, got %v: %s", loan, response.Message)
+	}
+
+	// the funded deposit counts towards the price
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Lien == nil || car.Lien.Bank != bank || car.Lien.Amount != 40 {
+		t.Fatalf("Expected the car pledged to '%s' for 40: %s", bank, response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", buyer, "user", vin, "emil"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("repayLoan", buyer, "user", vin, "50"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("repayLoan", buyer, "user", vin, "40"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readLoan", bank, "bank", "1"))
+	json.Unmarshal(response.Payload, &loan)
+	if loan.Status != loanRepaid || loan.Repaid != 40 {
+		t.Errorf("Expected the loan repaid, got %v", loan)
+	}
+
+	for username, balance := range map[string]int{buyer: 40, bank: 100, seller: 160} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected a balance of %d for '%s', got %d", balance, username, user.Balance)
+		}
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", buyer, "user", vin, "emil"))
+	if response.Status != shim.OK {
+		t.Fatal("Car should transfer once the loan is repaid: " + response.Message)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Public car facts.
+ *
+ * Buyers need to check a few basic facts before they
+ * contact a seller: what the car is, whether it may be
+ * driven, open recalls and whether it was reported stolen.
+ * 'lookupCar' returns just these facts to everybody, the
+ * full car stays with the owner and granted readers.
+ */
+
+/*
+ * Returns the redacted public view of 'car'
+ */
+func publicCar(car *Car, now int64) PublicCar {
+	recalls := car.Recalls
+	if recalls == nil {
+		recalls = []string{}
+	}
+
+	return PublicCar{
+		Vin:     car.Vin,
+		Brand:   car.Certificate.Brand,
+		Model:   car.Certificate.Model,
+		Type:    car.Certificate.Type,
+		Year:    time.Unix(car.CreatedTs, 0).UTC().Year(),
+		Status:  carStatus(car, now),
+		Recalls: recalls,
+		Stolen:  car.Stolen,
+
+		OdometerDiscrepancy: car.Odometer.Status == odometerDiscrepancy,
+
+		Battery:   batteryReport(car),
+		Appraisal: latestAppraisal(car)}
+}
+
+/*
+ * Looks up the public facts of a car.
+ *
+ * Open to every user, no ownership required.
+ *
+ * On success,
+ * returns the public car view.
+ */
+func (t *CarChaincode) lookupCar(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	viewAsBytes, _ := ledgerjson.Marshal(publicCar(&car, now))
+	return shim.Success(viewAsBytes)
+}
+
+/*
+ * Files a recall campaign against a car.
+ *
+ * Emits 'recallFiled' with the car's public view.
+ *
+ * On success,
+ * returns the public car view.
+ */
+func (t *CarChaincode) fileRecall(stub shim.ChaincodeStubInterface, vin string, campaign string) pb.Response {
+	if campaign == "" {
+		return errorResponse(ErrInvalidArgument, "'fileRecall' expects a non-empty recall campaign")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	for _, recall := range car.Recalls {
+		if recall == campaign {
+			return errorResponse(ErrAlreadyExists, fmt.Sprintf("Recall '%s' was already filed for car with VIN '%s'", campaign, vin))
+		}
+	}
+
+	car.Recalls = append(car.Recalls, campaign)
+
+	return t.savePublicFlags(stub, &car, "recallFiled")
+}
+
+/*
+ * Sets or clears the stolen flag of a car.
+ *
+ * Owners can report their car stolen, only the DOT
+ * clears the flag once the car is recovered.
+ * Emits 'stolenReported' with the car's public view.
+ *
+ * On success,
+ * returns the public car view.
+ */
+func (t *CarChaincode) reportStolen(stub shim.ChaincodeStubInterface, username string, role string, vin string, stolen bool) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if role != "dot" && owner != username {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
+	} else if !stolen && role != "dot" {
+		return errorResponse(ErrForbiddenRole, fmt.Sprintf("Sorry, role '%s' is not allowed to clear a theft report.", role))
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Stolen = stolen
+
+	return t.savePublicFlags(stub, &car, "stolenReported")
+}
+
+/*
+ * Writes a car with changed public flags
+ * and emits 'event' with its public view.
+ */
+func (t *CarChaincode) savePublicFlags(stub shim.ChaincodeStubInterface, car *Car, event string) pb.Response {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	viewAsBytes, _ := ledgerjson.Marshal(publicCar(car, now))
+	err = stub.SetEvent(event, viewAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting '"+event+"' event")
+	}
+
+	return shim.Success(viewAsBytes)
+}
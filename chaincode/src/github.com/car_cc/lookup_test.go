@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestLookupCar(t *testing.T) {
+	owner := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage",
+		`{ "vin": "`+vin+`", "certificate": { "brand": "VW", "model": "Polo", "color": "red" } }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	// the full car stays with the owner
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("lookupCar", buyer, "user", vin))
+	view := PublicCar{}
+	err := json.Unmarshal(response.Payload, &view)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if view.Brand != "VW" || view.Model != "Polo" || view.Status != "registered" || view.Year < 2017 || view.Stolen || len(view.Recalls) != 0 {
+		t.Errorf("Unexpected public car view: %v", view)
+	}
+
+	// recalls and theft reports show up
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileRecall", owner, "user", vin, "airbag 2017-04"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("fileRecall", "inspector", "dot", vin, "airbag 2017-04"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reportStolen", buyer, "user", vin, "true"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("reportStolen", owner, "user", vin, "true"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("lookupCar", buyer, "user", vin))
+	view = PublicCar{}
+	json.Unmarshal(response.Payload, &view)
+	if !view.Stolen || len(view.Recalls) != 1 || view.Recalls[0] != "airbag 2017-04" {
+		t.Errorf("Public view should show the recall and theft report: %v", view)
+	}
+
+	// only the DOT clears a theft report
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reportStolen", owner, "user", vin, "false"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+}
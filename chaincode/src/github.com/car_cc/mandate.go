@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Mandates (power of attorney).
+ *
+ * An owner can mandate another user, e.g. a dealer, to do
+ * some operations on one car in the owner's name until the
+ * mandate expires. Mandates are kept under
+ * 'mandate~<vin>~<agent>' and only hold for the owner who
+ * granted them, so they end with a change of ownership.
+ */
+
+// object type of mandate keys
+const mandateObjectType string = "mandate"
+
+// operations an owner can mandate
+const (
+	mandateSell     string = "sell"
+	mandateTransfer string = "transfer"
+	mandateInsure   string = "insure"
+	mandateRevoke   string = "revoke"
+	mandateRead     string = "read"
+	mandateService  string = "service"
+)
+
+var mandateOperations = []string{mandateSell, mandateTransfer, mandateInsure, mandateRevoke, mandateRead, mandateService}
+
+/*
+ * Returns the ledger key of the mandate of 'agent' for car 'vin'
+ */
+func getMandateKey(stub shim.ChaincodeStubInterface, vin string, agent string) (string, error) {
+	key, err := stub.CreateCompositeKey(mandateObjectType, []string{vin, agent})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating mandate key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the mandate of 'agent' for car 'vin'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func (t *CarChaincode) getMandate(stub shim.ChaincodeStubInterface, vin string, agent string) (*Mandate, error) {
+	key, err := getMandateKey(stub, vin, agent)
+	if err != nil {
+		return nil, err
+	}
+
+	mandateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading mandate")
+	} else if mandateAsBytes == nil {
+		return nil, nil
+	}
+
+	mandate := Mandate{}
+	err = ledgerjson.Unmarshal(mandateAsBytes, &mandate)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing mandate")
+	}
+
+	return &mandate, nil
+}
+
+/*
+ * Checks if 'mandate' allows 'operation' for 'owner' at 'now'
+ */
+func mandateAllows(mandate *Mandate, owner string, operation string, now int64) bool {
+	if mandate == nil || mandate.Owner != owner || mandate.ExpiresTs <= now {
+		return false
+	}
+
+	for _, allowed := range mandate.Operations {
+		if allowed == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Returns the user 'username' acts for on car 'vin'.
+ *
+ * That is the car owner if 'username' administers the
+ * owning fleet or holds a valid mandate of the owner for
+ * 'operation', and 'username' otherwise, so the ownership
+ * checks of the operation apply as usual.
+ */
+func (t *CarChaincode) principal(stub shim.ChaincodeStubInterface, username string, vin string, operation string) (string, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil || owner == username {
+		return username, nil
+	}
+
+	// fleet admins act for the fleet
+	fleet, err := t.adminFleetOf(stub, username, vin)
+	if err != nil {
+		return "", err
+	} else if fleet != nil {
+		return fleet.Name, nil
+	}
+
+	mandate, err := t.getMandate(stub, vin, username)
+	if err != nil || mandate == nil {
+		return username, err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return "", err
+	}
+
+	if mandateAllows(mandate, owner, operation, now) {
+		fmt.Printf("User '%s' acts for '%s' on car '%s' by mandate\n", username, owner, vin)
+		return owner, nil
+	}
+
+	return username, nil
+}
+
+/*
+ * Lets the car owner mandate 'agent' to do 'operations'
+ * on the car until 'expiryTs'. Granting again to the same
+ * agent replaces the mandate.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Username of the agent       (string)
+ * [2] Operations                  (string, comma separated)
+ *     'sell', 'transfer', 'insure', 'revoke', 'read', 'service'
+ * [3] Expiry timestamp            (int, unix timestamp)
+ *
+ * On success,
+ * returns the mandate.
+ */
+func (t *CarChaincode) grantMandate(stub shim.ChaincodeStubInterface, username string, vin string, agent string, operations string, expiryTs int64) pb.Response {
+	if agent == "" || agent == username {
+		return errorResponse(ErrInvalidArgument, "'grantMandate' expects another user as agent")
+	}
+
+	ops := strings.Split(operations, ",")
+	for i, op := range ops {
+		ops[i] = strings.TrimSpace(op)
+		valid := false
+		for _, known := range mandateOperations {
+			valid = valid || ops[i] == known
+		}
+		if !valid {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("Unknown operation '%s', expected one of: %s", ops[i], strings.Join(mandateOperations, ", ")))
+		}
+	}
+
+	// only the owner can mandate
+	_, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.getUser(stub, agent)
+	if err != nil {
+		return errorResponse(ErrUserNotFound, "User does not exist. Username: '"+agent+"'")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if expiryTs <= now {
+		return errorResponse(ErrInvalidArgument, "'grantMandate' expects an expiry in the future")
+	}
+
+	mandate := Mandate{
+		Vin:        vin,
+		Owner:      username,
+		Agent:      agent,
+		Operations: ops,
+		GrantedTs:  now,
+		ExpiresTs:  expiryTs}
+
+	// a new mandate of the agent replaces the old one
+	previous, err := t.getMandate(stub, vin, agent)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	previousTs := int64(0)
+	if previous != nil {
+		previousTs = previous.ExpiresTs
+	}
+
+	err = updateExpiry(stub, expiryMandate, vin, previousTs, expiryTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := getMandateKey(stub, vin, agent)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	mandateAsBytes, _ := ledgerjson.Marshal(mandate)
+	err = stub.PutState(key, mandateAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing mandate")
+	}
+
+	return shim.Success(mandateAsBytes)
+}
+
+/*
+ * Lets the car owner withdraw the mandate of 'agent'
+ *
+ * On success,
+ * returns nothing.
+ */
+func (t *CarChaincode) revokeMandate(stub shim.ChaincodeStubInterface, username string, vin string, agent string) pb.Response {
+	_, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	mandate, err := t.getMandate(stub, vin, agent)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if mandate == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("User '%s' holds no mandate for car with VIN '%s'", agent, vin))
+	}
+
+	key, err := getMandateKey(stub, vin, agent)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error deleting mandate")
+	}
+
+	return shim.Success(nil)
+}
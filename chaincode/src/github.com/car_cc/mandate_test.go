@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestMandate(t *testing.T) {
+	owner := "emil"
+	dealer := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	expiry := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", dealer, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("grantMandate", owner, "user", vin, dealer, "sell,drive", expiry))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantMandate", dealer, "garage", vin, dealer, "sell", expiry))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantMandate", owner, "user", vin, dealer, "sell, read", expiry))
+	mandate := Mandate{}
+	err := json.Unmarshal(response.Payload, &mandate)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// the dealer can read and sell, but not give the car away
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", dealer, "garage", vin))
+	if response.Status != shim.OK {
+		t.Error("Dealer should read the car by mandate: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", dealer, "garage", vin, buyer))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", dealer, "garage", "50", vin, buyer))
+	car := Car{}
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Certificate.Username != buyer {
+		t.Errorf("Car should belong to '%s' now, but belongs to '%s'", buyer, car.Certificate.Username)
+	}
+
+	// the owner got paid
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", owner, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 150 {
+		t.Errorf("Owner should have been paid, balance is %d", user.Balance)
+	}
+
+	// the mandate ended with the sale
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", dealer, "garage", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * State schema migrations.
+ *
+ * The ledger state carries a schema version under
+ * '_schemaVersion'. Upgrading the chaincode runs 'Init'
+ * again, which keeps existing state, and the admin then
+ * calls 'migrate' once to convert it to the current schema.
+ * Until then, all other functions are refused, so old and
+ * new state formats are never mixed.
+ *
+ * Deployments without a schema version predate
+ * migrations and are at version 0.
+ */
+
+// ledger key of the schema version
+const schemaVersionStr string = "_schemaVersion"
+
+// legacy registration proposal index, replaced in schema version 1
+const legacyRegistrationProposalIndexStr string = "_registrationProposals"
+
+// legacy car index, replaced in schema version 7
+const legacyCarIndexStr string = "_cars"
+
+// object type of the legacy expiry index, replaced in schema version 5
+const legacyExpiryObjectType string = "expiry"
+
+// object type of legacy journal keys, replaced in schema version 9
+const legacyJournalObjectType string = "journal"
+
+// legacy claim index, replaced in schema version 10
+const legacyClaimIndexStr string = "_claims"
+
+type migration struct {
+	version     int
+	description string
+	run         func(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error
+}
+
+/*
+ * State the migrations of one run share. Fabric does not
+ * return writes of the running transaction on a read, so
+ * the car index and the cars are read once and the
+ * migrations work on these copies. Up to schema version 7
+ * the car index is the legacy map, which
+ * 'migrateCarIndexKeys' writes as per car keys.
+ */
+type migrationState struct {
+	carIndex map[string]string
+	cars     map[string]*Car // nil for VINs without a car
+}
+
+/*
+ * Returns car 'vin' as changed by the migrations
+ * before, nil if the VIN holds no car
+ */
+func (s *migrationState) car(stub shim.ChaincodeStubInterface, vin string) (*Car, error) {
+	if car, ok := s.cars[vin]; ok {
+		return car, nil
+	}
+
+	carAsBytes, err := stub.GetState(vin)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading car")
+	}
+
+	car := &Car{}
+	err = ledgerjson.Unmarshal(carAsBytes, car)
+	if carAsBytes == nil || err != nil {
+		car = nil
+	}
+
+	s.cars[vin] = car
+	return car, nil
+}
+
+// migrations in version order, the last one is the current schema
+var migrations = []migration{
+	{1, "move registration proposals to per car keys", migrateRegistrationProposals},
+	{2, "move the car lists of users to ownership keys", migrateOwnership},
+	{3, "move exported cars to the archive", migrateExportedCars},
+	{4, "name car owners by pseudonym", migrateOwnerPseudonyms},
+	{5, "index expiry dates by range keys", migrateExpiryIndex},
+	{6, "index insured cars by insurer", migrateInsuredIndex},
+	{7, "move the car index to per car keys", migrateCarIndexKeys},
+	{8, "record revocation proposals with their creation", migrateRevocationProposals},
+	{9, "key the journal by range keys", migrateJournalKeys},
+	{10, "move claims to per claim keys", migrateClaims},
+}
+
+/*
+ * Returns the schema version the chaincode works with
+ */
+func currentSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+/*
+ * Reads the schema version of the ledger state
+ */
+func (t *CarChaincode) getSchemaVersion(stub shim.ChaincodeStubInterface) (int, error) {
+	versionAsBytes, err := stub.GetState(schemaVersionStr)
+	if err != nil {
+		return 0, newError(ErrLedger, "Error reading schema version")
+	} else if versionAsBytes == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(versionAsBytes))
+	if err != nil {
+		return 0, newError(ErrLedger, "Error parsing schema version")
+	}
+
+	return version, nil
+}
+
+/*
+ * Writes the schema version of the ledger state
+ */
+func (t *CarChaincode) setSchemaVersion(stub shim.ChaincodeStubInterface, version int) error {
+	err := stub.PutState(schemaVersionStr, []byte(strconv.Itoa(version)))
+	if err != nil {
+		return newError(ErrLedger, "Error writing schema version")
+	}
+
+	return nil
+}
+
+/*
+ * Checks if the ledger already holds state of an
+ * earlier deployment, which 'Init' must not clear
+ */
+func (t *CarChaincode) hasState(stub shim.ChaincodeStubInterface) (bool, error) {
+	for _, key := range []string{schemaVersionStr, legacyCarIndexStr} {
+		valAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return false, newError(ErrLedger, "Error reading ledger state")
+		} else if valAsBytes != nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+ * Refuses to work on state with an old schema
+ */
+func (t *CarChaincode) checkSchemaVersion(stub shim.ChaincodeStubInterface) error {
+	version, err := t.getSchemaVersion(stub)
+	if err != nil {
+		return err
+	} else if version < currentSchemaVersion() {
+		return newError(ErrInvalidState, fmt.Sprintf("Ledger state has schema version %d, but %d is required. Run 'migrate' first", version, currentSchemaVersion()))
+	}
+
+	return nil
+}
+
+/*
+ * Migrates the ledger state to the current schema version.
+ *
+ * Runs all migrations newer than the state in order,
+ * passing on the car index and the cars, see
+ * 'migrationState'. Any failure rolls back the whole
+ * transaction, so the state is never left half migrated.
+ *
+ * On success,
+ * returns the migration result.
+ */
+func (t *CarChaincode) migrate(stub shim.ChaincodeStubInterface, role string) pb.Response {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAdmin(stub, config, role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	version, err := t.getSchemaVersion(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if version >= currentSchemaVersion() {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Ledger state is already at schema version %d", version))
+	}
+
+	// the car index names owners by username until
+	// schema version 4, so it is read without 'getOwner'
+	carIndex, err := getLegacyCarIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if carIndex == nil {
+		carIndex, err = t.getCarIndex(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	state := &migrationState{carIndex: carIndex, cars: make(map[string]*Car)}
+	result := MigrationResult{From: version, To: currentSchemaVersion(), Applied: []string{}}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		err = m.run(t, stub, state)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		fmt.Printf("Migrated ledger state to schema version %d: %s\n", m.version, m.description)
+		result.Applied = append(result.Applied, m.description)
+	}
+
+	err = t.setSchemaVersion(stub, result.To)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	resultAsBytes, _ := ledgerjson.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+/*
+ * Reads the car index kept as one map under '_cars'
+ * before schema version 7, nil if there is none
+ */
+func getLegacyCarIndex(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	indexAsBytes, err := stub.GetState(legacyCarIndexStr)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading legacy car index")
+	} else if indexAsBytes == nil {
+		return nil, nil
+	}
+
+	carIndex := make(map[string]string)
+	err = ledgerjson.Unmarshal(indexAsBytes, &carIndex)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing legacy car index")
+	}
+
+	return carIndex, nil
+}
+
+/*
+ * Schema version 1:
+ * moves registration proposals from the single
+ * '_registrationProposals' map to one key per car.
+ *
+ * Legacy proposals had no owner, status or timestamps, so
+ * they become pending proposals of the current car owner,
+ * created with the car.
+ */
+func migrateRegistrationProposals(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	indexAsBytes, err := stub.GetState(legacyRegistrationProposalIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error reading legacy registration proposal index")
+	} else if indexAsBytes == nil {
+		return nil
+	}
+
+	index := make(map[string]RegistrationProposal)
+	err = ledgerjson.Unmarshal(indexAsBytes, &index)
+	if err != nil {
+		return newError(ErrLedger, "Error parsing legacy registration proposal index")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	// VIN order, so all peers write the same
+	vins := make([]string, 0, len(index))
+	for vin := range index {
+		vins = append(vins, vin)
+	}
+	sort.Strings(vins)
+
+	for _, vin := range vins {
+		proposal := index[vin]
+
+		// proposals of deleted cars are dropped
+		owner := state.carIndex[vin]
+		if owner == "" || ValidateVin(vin) != nil {
+			continue
+		}
+
+		car, err := state.car(stub, vin)
+		if err != nil {
+			return err
+		} else if car == nil {
+			return newError(ErrCarNotFound, "Failed to fetch car with vin '"+vin+"' from ledger")
+		}
+
+		proposal.Car = vin
+		proposal.Owner = owner
+		proposal.Status = proposalPending
+		proposal.CreatedTs = car.CreatedTs
+		proposal.ExpiresTs = car.CreatedTs + proposalTtl(config)
+
+		// registered cars already passed review
+		if IsRegistered(car) {
+			proposal.Status = proposalApproved
+		}
+
+		err = t.saveProposal(stub, proposal)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = stub.DelState(legacyRegistrationProposalIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error deleting legacy registration proposal index")
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 2:
+ * links every car to its owner with an ownership key
+ * and drops the car lists from the users.
+ *
+ * The car index is the authority on ownership, so the
+ * links are taken from it rather than the user lists.
+ */
+func migrateOwnership(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	carIndex := state.carIndex
+
+	// VIN order, so all peers write the same
+	vins := make([]string, 0, len(carIndex))
+	for vin := range carIndex {
+		vins = append(vins, vin)
+	}
+	sort.Strings(vins)
+
+	for _, vin := range vins {
+		if carIndex[vin] == "" {
+			continue
+		}
+
+		err := addOwnership(stub, carIndex[vin], vin)
+		if err != nil {
+			return err
+		}
+	}
+
+	userIndex, err := t.getUserIndex(stub)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(userIndex))
+	for name := range userIndex {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		user, err := t.getUser(stub, name)
+		if err != nil || user.Cars == nil {
+			continue
+		}
+
+		err = t.saveUser(stub, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 3:
+ * moves cars exported before the archive existed
+ * to the archive, see 'archiveCar'.
+ *
+ * The cars leave the car index, so later migrations
+ * pass them over. Stock entries and read grants of
+ * these cars are left, both are only looked at for
+ * cars with an owner.
+ */
+func migrateExportedCars(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	// VIN order, so all peers write the same
+	for _, vin := range sortedKeys(state.carIndex) {
+		car, err := state.car(stub, vin)
+		if err != nil {
+			return err
+		} else if car == nil || car.ExportedTo == "" || IsArchived(car) {
+			continue
+		}
+
+		err = t.writeArchive(stub, state.carIndex[vin], *car, archiveExported)
+		if err != nil {
+			return err
+		}
+		delete(state.carIndex, vin)
+		state.cars[vin] = nil
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 4:
+ * replaces the usernames in the car index and the
+ * ownership keys by owner pseudonyms, see 'pseudonymOf'.
+ *
+ * The DOT has to set the pseudonym secret first. Links
+ * written by earlier migrations of this run already use
+ * pseudonyms, writing them again does no harm.
+ */
+func migrateOwnerPseudonyms(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	carIndex := state.carIndex
+
+	// VIN order, so all peers write the same
+	for _, vin := range sortedKeys(carIndex) {
+		username := carIndex[vin]
+		if username == "" {
+			continue
+		}
+
+		legacyKey, err := stub.CreateCompositeKey(ownershipObjectType, []string{username, vin})
+		if err != nil {
+			return newError(ErrInternal, "Error creating ownership key")
+		}
+
+		err = stub.DelState(legacyKey)
+		if err != nil {
+			return newError(ErrLedger, "Error deleting legacy ownership key")
+		}
+
+		err = addOwnership(stub, username, vin)
+		if err != nil {
+			return err
+		}
+
+		carIndex[vin], err = registerPseudonym(stub, username)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 5:
+ * replaces the composite 'expiry' keys by the range keys
+ * of the expiry index and adds the expiry dates held on
+ * cars, see 'indexCarExpiries'. Mandates, transit permits
+ * and registration proposals are indexed when set again.
+ */
+func migrateExpiryIndex(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(legacyExpiryObjectType, []string{})
+	if err != nil {
+		return newError(ErrLedger, "Error reading legacy expiry index")
+	}
+
+	legacyKeys := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return newError(ErrLedger, "Error reading legacy expiry index")
+		}
+		legacyKeys = append(legacyKeys, kv.Key)
+	}
+	iterator.Close()
+
+	for _, key := range legacyKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return newError(ErrLedger, "Error deleting legacy expiry key")
+		}
+	}
+
+	// VIN order, so all peers write the same
+	for _, vin := range sortedKeys(state.carIndex) {
+		car, err := state.car(stub, vin)
+		if err != nil {
+			return err
+		} else if car == nil || IsArchived(car) {
+			continue
+		}
+
+		err = indexCarExpiries(stub, car)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 6:
+ * writes the insured car keys of the
+ * insured cars, see 'updateInsuredIndex'
+ */
+func migrateInsuredIndex(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	for _, vin := range sortedKeys(state.carIndex) {
+		car, err := state.car(stub, vin)
+		if err != nil {
+			return err
+		} else if car == nil || IsArchived(car) {
+			continue
+		}
+
+		err = updateInsuredIndex(stub, vin, "", car.Certificate.Insurer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 7:
+ * moves the car index from the single '_cars' map to
+ * one key per car, see 'indexCar', with the entries
+ * the migrations before left in the copy.
+ */
+func migrateCarIndexKeys(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	// VIN order, so all peers write the same
+	for _, vin := range sortedKeys(state.carIndex) {
+		err := indexCar(stub, vin, state.carIndex[vin])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := stub.DelState(legacyCarIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error deleting legacy car index")
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 8:
+ * revocation proposals were the username of the
+ * proposing owner by VIN, they become proposals
+ * made at timestamp 0, see 'RevocationProposal'.
+ */
+func migrateRevocationProposals(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	indexAsBytes, err := stub.GetState(revocationProposalIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error reading legacy revocation proposal index")
+	} else if indexAsBytes == nil {
+		return nil
+	}
+
+	legacyIndex := make(map[string]string)
+	err = ledgerjson.Unmarshal(indexAsBytes, &legacyIndex)
+	if err != nil {
+		return newError(ErrLedger, "Error parsing legacy revocation proposal index")
+	}
+
+	index := make(map[string]RevocationProposal)
+	for vin, username := range legacyIndex {
+		index[vin] = RevocationProposal{Car: vin, User: username}
+	}
+
+	return saveRevocationProposalIndex(stub, index)
+}
+
+/*
+ * Schema version 9:
+ * moves the journal entries from composite keys
+ * to the range keys of 'getJournalKey'
+ */
+func migrateJournalKeys(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(legacyJournalObjectType, []string{})
+	if err != nil {
+		return newError(ErrLedger, "Error reading legacy journal")
+	}
+
+	legacyKeys := []string{}
+	entries := []JournalEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return newError(ErrLedger, "Error reading legacy journal")
+		}
+
+		entry := JournalEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			iterator.Close()
+			return newError(ErrLedger, "Error parsing legacy journal entry")
+		}
+
+		legacyKeys = append(legacyKeys, kv.Key)
+		entries = append(entries, entry)
+	}
+	iterator.Close()
+
+	for i, key := range legacyKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return newError(ErrLedger, "Error deleting legacy journal key")
+		}
+
+		entryAsBytes, _ := ledgerjson.Marshal(entries[i])
+		err = stub.PutState(getJournalKey(entries[i].Ts, entries[i].TxId), entryAsBytes)
+		if err != nil {
+			return newError(ErrLedger, "Error writing journal entry")
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Schema version 10:
+ * moves claims from the single '_claims' map to one
+ * key per claim, see 'saveClaim'. Legacy claims keep
+ * their ids, new claims are named by transaction.
+ */
+func migrateClaims(t *CarChaincode, stub shim.ChaincodeStubInterface, state *migrationState) error {
+	indexAsBytes, err := stub.GetState(legacyClaimIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error reading legacy claim index")
+	} else if indexAsBytes == nil {
+		return nil
+	}
+
+	claimIndex := make(map[string]Claim)
+	err = ledgerjson.Unmarshal(indexAsBytes, &claimIndex)
+	if err != nil {
+		return newError(ErrLedger, "Error parsing legacy claim index")
+	}
+
+	// id order, so all peers write the same
+	ids := make([]string, 0, len(claimIndex))
+	for id := range claimIndex {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		err = t.saveClaim(stub, claimIndex[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = stub.DelState(legacyClaimIndexStr)
+	if err != nil {
+		return newError(ErrLedger, "Error deleting legacy claim index")
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestMigrateLegacyState(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	exportedVin := "WVWZZZ6R8HY260781"
+
+	// state written by a deployment before schema versions
+	cc := &staleReadChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	stub.MockTransactionStart(uuid)
+	carAsBytes, _ := json.Marshal(Car{Vin: vin, CreatedTs: 1500000000, Certificate: Certificate{Username: owner, Insurer: "axa"}})
+	exportedAsBytes, _ := json.Marshal(Car{Vin: exportedVin, CreatedTs: 1500000000, ExportedTo: "DE"})
+	userAsBytes, _ := json.Marshal(User{Name: owner, Cars: []string{vin, exportedVin}, Balance: 100})
+	stub.PutState(vin, carAsBytes)
+	stub.PutState(exportedVin, exportedAsBytes)
+	stub.PutState(owner, userAsBytes)
+	stub.PutState(legacyCarIndexStr, []byte(`{ "`+vin+`": "`+owner+`", "`+exportedVin+`": "`+owner+`" }`))
+	stub.PutState(userIndexStr, []byte(`{ "`+owner+`": "`+owner+`" }`))
+	stub.PutState(legacyRegistrationProposalIndexStr, []byte(`{ "`+vin+`": { "car": "`+vin+`" } }`))
+	stub.PutState(revocationProposalIndexStr, []byte(`{ "`+vin+`": "`+owner+`" }`))
+	stub.PutState(legacyClaimIndexStr, []byte(`{ "clm_1": { "id": "clm_1", "car": "`+vin+`", "user": "`+owner+`", "insurer": "axa", "amount": 30, "status": "approved" } }`))
+	journalKey, _ := stub.CreateCompositeKey(legacyJournalObjectType, []string{"001500000000", "tx-legacy"})
+	stub.PutState(journalKey, []byte(`{ "tx_id": "tx-legacy", "ts": 1500000000, "changed": [ "`+vin+`" ], "deleted": [] }`))
+	stub.MockTransactionEnd(uuid)
+
+	// the upgrade keeps the state
+	response := stub.MockInit(uuid, util.ToChaincodeArgs("init", "999"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// nothing works before the migration
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("migrate", owner, "user"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	// owner pseudonyms need the secret of the DOT
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("migrate", "admin", "admin"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.TransientMap = map[string][]byte{pseudonymSecretTransient: []byte(testPseudonymSecret)}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setPseudonymSecret", "inspector", "dot"))
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// all steps run in one transaction, which
+	// does not read what earlier steps wrote
+	cc.strict = true
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("migrate", "admin", "admin"))
+	cc.strict = false
+	result := MigrationResult{}
+	err := json.Unmarshal(response.Payload, &result)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if result.From != 0 || result.To != currentSchemaVersion() || len(result.Applied) != len(migrations) {
+		t.Errorf("Unexpected migration result: %v", result)
+	}
+
+	// the legacy proposal is now in the review queue
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", "inspector", "dot"))
+	page := ProposalPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Proposals) != 1 || page.Proposals[0].Owner != owner || page.Proposals[0].CreatedTs != 1500000000 {
+		t.Errorf("Unexpected proposals after migration: %v", page.Proposals)
+	}
+
+	// the revocation proposal is kept, made at an unknown time
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRevocationProposals", "inspector", "dot"))
+	revocations := []RevocationProposal{}
+	json.Unmarshal(response.Payload, &revocations)
+	if len(revocations) != 1 || revocations[0].Car != vin || revocations[0].User != owner || revocations[0].CreatedTs != 0 {
+		t.Errorf("Unexpected revocation proposals after migration: %v", revocations)
+	}
+
+	legacyAsBytes, _ := stub.GetState(legacyRegistrationProposalIndexStr)
+	if legacyAsBytes != nil {
+		t.Error("Legacy registration proposal index should be deleted")
+	}
+
+	// the claim keeps its id under its own key
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getClaims", "axa", "insurer"))
+	claims := []Claim{}
+	json.Unmarshal(response.Payload, &claims)
+	if len(claims) != 1 || claims[0].Id != "clm_1" || claims[0].Amount != 30 {
+		t.Errorf("Unexpected claims after migration: %v", claims)
+	}
+
+	legacyAsBytes, _ = stub.GetState(legacyClaimIndexStr)
+	if legacyAsBytes != nil {
+		t.Error("Legacy claim index should be deleted")
+	}
+
+	// the journal entry moved to its range key
+	legacyAsBytes, _ = stub.GetState(journalKey)
+	entry := JournalEntry{}
+	entryAsBytes, _ := stub.GetState(getJournalKey(1500000000, "tx-legacy"))
+	json.Unmarshal(entryAsBytes, &entry)
+	if legacyAsBytes != nil || len(entry.Changed) != 1 || entry.Changed[0] != vin {
+		t.Errorf("Expected the journal entry under its range key, got %v", entry)
+	}
+
+	legacyAsBytes, _ = stub.GetState(legacyCarIndexStr)
+	if legacyAsBytes != nil {
+		t.Error("Legacy car index should be deleted")
+	}
+
+	// the car is linked to its owner
+	cars, err := getUserCars(stub, owner)
+	if err != nil || len(cars) != 1 || cars[0] != vin {
+		t.Errorf("Expected car '%s' linked to '%s', got %v", vin, owner, cars)
+	}
+
+	// by pseudonym only
+	carIndex, _ := (&CarChaincode{}).getCarIndex(stub)
+	if carIndex[vin] == owner {
+		t.Error("The car index should name the owner by pseudonym")
+	} else if _, ok := carIndex[exportedVin]; ok {
+		t.Error("The exported car should not be back in the car index")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolvePseudonym", "inspector", "dot", carIndex[vin]))
+	if string(response.Payload) != owner {
+		t.Errorf("Expected pseudonym to resolve to '%s', got '%s'", owner, response.Payload)
+	}
+
+	// the exported car moved to the archive
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getArchivedCar", "inspector", "dot", exportedVin))
+	archived := ArchivedCar{}
+	err = json.Unmarshal(response.Payload, &archived)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if archived.Owner != owner || archived.Archival.Reason != archiveExported || archived.Car.ExportedTo != "DE" {
+		t.Errorf("Unexpected archived car after migration: %v", archived)
+	}
+
+	// the insurer lists the car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuredCars", "axa", "insurer"))
+	insured := InsuredCarPage{}
+	json.Unmarshal(response.Payload, &insured)
+	if len(insured.Cars) != 1 || insured.Cars[0].Vin != vin {
+		t.Errorf("Expected car '%s' insured by 'axa', got %v", vin, insured.Cars)
+	}
+
+	// migrations only run once
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("migrate", "admin", "admin"))
+	expectErrorCode(t, response, ErrInvalidState)
+}
@@ -1,10 +1,493 @@
 package main
 
+import (
+	"encoding/json"
+)
+
 type Car struct {
 	Certificate Certificate `json:"certificate"` // vehicle certificate issued by the DOT
 	CreatedTs   int64       `json:"created_ts"`  // birth date
-	Vin         string      `json:"vin"`         // vehicle identification number ('WVW ZZZ 6RZ HY26 0780')
+	Vin         string      `json:"vin"`         // vehicle identification number ('WVWZZZ6R6HY260780')
 	UsageData   UsageData   `json:"usage_data"`  // car usage profile, interesting for car rentals
+
+	Classification    string `json:"classification"`     // '', 'salvage', 'total_loss' or 'rebuilt'
+	RebuildInspection string `json:"rebuild_inspection"` // DOT inspection report for a rebuilt salvage car
+
+	Permit     TripPermit `json:"permit"`      // temporary permit to drive to the inspection
+	ExportedTo string     `json:"exported_to"` // destination country after an export
+	Customs    Customs    `json:"customs"`     // customs clearance of an imported car
+	Handoff    Handoff    `json:"handoff"`     // pending move to or from another channel
+
+	Recalls []string `json:"recalls"` // open recall campaigns filed by the DOT
+	Stolen  bool     `json:"stolen"`  // reported stolen and not recovered yet
+
+	VinConflict bool    `json:"vin_conflict"` // frozen while a second car with this VIN is investigated
+	Seizure     Seizure `json:"seizure"`      // seizure by the police or a court
+
+	Historic HistoricClassification `json:"historic"` // historic vehicle classification by the DOT
+
+	Modification    *ModificationRequest `json:"modification,omitempty"` // pending modification request
+	NeedsInspection bool                 `json:"needs_inspection"`       // unapproved structural modification, blocks confirmation
+
+	CoOwnership  CoOwnership       `json:"co_ownership"` // co-owners and their shares
+	Drivers      []string          `json:"drivers"`      // employees assigned to a fleet car
+	Rental       Rental            `json:"rental"`       // current short-term rental
+	Installments InstallmentPlan   `json:"installments"` // current sale in installments
+	Listing      Listing           `json:"listing"`      // offer of the car on the market
+	Device       TelematicsDevice  `json:"device"`       // telematics device reporting trips
+	Odometer     Odometer          `json:"odometer"`     // latest attested mileage
+	Emission     EmissionTest      `json:"emission"`     // latest emission test
+	Warranties   []Warranty        `json:"warranties"`   // warranties, moving with the car
+	Parts        map[string]string `json:"parts"`        // serial of the installed part by part type
+	Battery      BatteryHealth     `json:"battery"`      // latest battery report of electric cars
+	Appraisal    Appraisal         `json:"appraisal"`    // latest appraisal
+	Policy       InsurancePolicy   `json:"policy"`       // policy of the insurer in the certificate
+	Birth        BirthLink         `json:"birth"`        // birth certificate of the manufacturer, if any
+
+	Lien *Lien `json:"lien,omitempty"` // pledge to the bank financing the purchase
+
+	CoverNote       *CoverNote `json:"cover_note,omitempty"` // temporary insurance of the owner
+	RevocationDueTs int64      `json:"revocation_due_ts"`    // confirmed on a cover note, revoked then without a full policy
+
+	InsuranceExempt bool `json:"insurance_exempt"` // confirmed in a jurisdiction not requiring insurance
+
+	InsuranceProof *InsuranceProof `json:"insurance_proof,omitempty"` // international proof of insurance, see 'issueInsuranceProof'
+
+	RoadTaxDueTs int64 `json:"road_tax_due_ts"` // due date of the earliest unpaid road tax, 0 if paid up
+
+	Toll TollAccount `json:"toll"` // toll account of the owner and unpaid toll
+
+	UnpaidFines int `json:"unpaid_fines"` // amount of the fines neither paid nor cancelled, see 'getFines'
+
+	Archived *Archival `json:"archived,omitempty"` // only set on the tombstone of an archived car
+}
+
+/*
+ * Historic vehicle classification, see 'classifyHistoric'
+ */
+type HistoricClassification struct {
+	ClassifiedBy   string `json:"classified_by"`
+	ClassifiedTs   int64  `json:"classified_ts"`
+	DeclassifiedBy string `json:"declassified_by"`
+	DeclassifiedTs int64  `json:"declassified_ts"` // 0 while the car is historic
+	Reason         string `json:"reason"`          // reason of the declassification
+}
+
+/*
+ * Request to approve a modification of a car,
+ * see 'requestModificationApproval'
+ */
+type ModificationRequest struct {
+	Vin         string   `json:"vin"`
+	Owner       string   `json:"owner"`
+	RequestedBy string   `json:"requested_by"` // owner or garage with a service mandate
+	Description string   `json:"description"`
+	PartsHashes []string `json:"parts_hashes"` // sha256 of the part documents
+	Structural  bool     `json:"structural"`
+	Status      string   `json:"status"` // 'pending', 'approved' or 'rejected'
+	RequestedTs int64    `json:"requested_ts"`
+	TxId        string   `json:"tx_id"`
+	ReviewedBy  string   `json:"reviewed_by"`
+	ReviewedTs  int64    `json:"reviewed_ts"`
+	Note        string   `json:"note"` // reason of a rejection
+}
+
+/*
+ * Off-chain document attached to a car,
+ * see 'attachDocument'
+ */
+type Attachment struct {
+	Vin        string `json:"vin"`
+	Type       string `json:"type"`        // 'photo', 'invoice', 'service_record', 'inspection_report' or 'other'
+	Hash       string `json:"hash"`        // sha256 of the document
+	Uri        string `json:"uri"`         // https or IPFS location, empty if not published
+	AttachedBy string `json:"attached_by"` // owner or garage with a service mandate
+	AttachedTs int64  `json:"attached_ts"`
+	TxId       string `json:"tx_id"`
+
+	Signatures []SignatureVerification `json:"signatures,omitempty"` // latest result per organization, see 'verifyDocumentSignature'
+}
+
+/*
+ * Signing certificate of an organization,
+ * see 'registerOrganizationCertificate'
+ */
+type OrganizationCertificate struct {
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`        // 'manufacturer', 'insurer', 'inspection' or 'registry'
+	Certificate  string `json:"certificate"` // PEM encoded X.509 certificate
+	Fingerprint  string `json:"fingerprint"` // sha256 of the DER encoded certificate
+	NotAfter     int64  `json:"not_after"`
+	RegisteredTs int64  `json:"registered_ts"`
+}
+
+/*
+ * Result of checking the signature of an
+ * organization over an attached document
+ */
+type SignatureVerification struct {
+	Organization string `json:"organization"`
+	Kind         string `json:"kind"`
+	Fingerprint  string `json:"fingerprint"` // certificate the signature was checked with
+	Signature    string `json:"signature"`   // base64 encoded
+	Valid        bool   `json:"valid"`
+	Reason       string `json:"reason"` // why the signature is not valid
+	VerifiedBy   string `json:"verified_by"`
+	VerifiedTs   int64  `json:"verified_ts"`
+}
+
+type ModificationRegister struct {
+	Vin             string                `json:"vin"`
+	NeedsInspection bool                  `json:"needs_inspection"`
+	Pending         *ModificationRequest  `json:"pending"`
+	Reviewed        []ModificationRequest `json:"reviewed"`
+}
+
+/*
+ * Insurance policy of a car, see 'setPolicy'.
+ * Policies without an end cover the car until revoked.
+ */
+type InsurancePolicy struct {
+	StartTs      int64  `json:"start_ts"`
+	EndTs        int64  `json:"end_ts"`        // 0 for no end
+	DocumentHash string `json:"document_hash"` // sha256 of the off-chain policy document
+}
+
+/*
+ * Temporary insurance of the owner of a car,
+ * see 'issueCoverNote'
+ */
+type CoverNote struct {
+	Insurer   string `json:"insurer"`
+	Owner     string `json:"owner"` // the note does not pass to the next owner
+	IssuedTs  int64  `json:"issued_ts"`
+	ExpiresTs int64  `json:"expires_ts"`
+}
+
+/*
+ * Value of a car appraised by a certified appraiser,
+ * see 'recordAppraisal'
+ */
+type Appraisal struct {
+	Vin         string `json:"vin"`
+	Value       int    `json:"value"`
+	AppraisedTs int64  `json:"appraised_ts"` // date of the appraisal
+	Methodology string `json:"methodology"`  // e.g. 'inspection', 'market comparison'
+	Appraiser   string `json:"appraiser"`    // name of the certified appraiser
+	Username    string `json:"username"`
+	Identity    string `json:"identity"` // hash of the client identity that signed the appraisal
+	Msp         string `json:"msp"`
+	RecordedTs  int64  `json:"recorded_ts"`
+	TxId        string `json:"tx_id"`
+}
+
+/*
+ * Battery health report, see 'recordBatteryHealth'
+ */
+type BatteryHealth struct {
+	StateOfHealth float64 `json:"state_of_health"` // remaining capacity in % of the new battery
+	Cycles        int     `json:"cycles"`          // full charge cycles
+	CapacityKwh   float64 `json:"capacity_kwh"`    // measured usable capacity
+	MeasuredBy    string  `json:"measured_by"`     // name of the authorized garage
+	Garage        string  `json:"garage"`          // username of the garage
+	MeasuredTs    int64   `json:"measured_ts"`
+}
+
+/*
+ * Major component bound to a car, see 'replacePart'
+ */
+type Part struct {
+	Serial      string `json:"serial"`
+	Type        string `json:"type"` // 'engine', 'gearbox' or 'battery'
+	Vin         string `json:"vin"`  // car the part is or was last installed in
+	InstalledTs int64  `json:"installed_ts"`
+	InstalledBy string `json:"installed_by"` // garage
+	RemovedTs   int64  `json:"removed_ts"`   // 0 while installed
+}
+
+type PartReplacement struct {
+	Vin         string `json:"vin"`
+	Type        string `json:"type"`
+	Serial      string `json:"serial"`       // installed part
+	Previous    string `json:"previous"`     // removed part, empty if none was recorded
+	PreviousVin string `json:"previous_vin"` // car the installed part was taken from, if any
+	Garage      string `json:"garage"`
+	Ts          int64  `json:"ts"`
+}
+
+type PartHistory struct {
+	Vin          string            `json:"vin"`
+	Parts        map[string]string `json:"parts"`
+	Replacements []PartReplacement `json:"replacements"`
+}
+
+/*
+ * Warranty of a manufacturer or dealer, see 'addWarranty'
+ */
+type Warranty struct {
+	Id         int    `json:"id"` // position in the car's warranties, starting at 1
+	Issuer     string `json:"issuer"`
+	Coverage   string `json:"coverage"` // e.g. 'powertrain', 'full'
+	StartTs    int64  `json:"start_ts"`
+	EndTs      int64  `json:"end_ts"`
+	KmLimit    int    `json:"km_limit"` // 0 for no limit
+	IssuedTs   int64  `json:"issued_ts"`
+	Voided     bool   `json:"voided"`
+	VoidReason string `json:"void_reason"`
+	VoidedTs   int64  `json:"voided_ts"`
+}
+
+type WarrantyStatus struct {
+	Warranty Warranty `json:"warranty"`
+	Active   bool     `json:"active"` // covers the car right now
+}
+
+/*
+ * Certification of a garage for a scope of
+ * work, see 'certifyGarage'
+ */
+type GarageCertification struct {
+	Garage    string `json:"garage"`
+	Scope     string `json:"scope"`     // 'inspection', 'emission' or 'ev_high_voltage'
+	Reference string `json:"reference"` // number of the certificate issued off-chain
+	IssuedBy  string `json:"issued_by"`
+	IssuedTs  int64  `json:"issued_ts"`
+	ExpiresTs int64  `json:"expires_ts"`
+
+	RevokedTs        int64  `json:"revoked_ts"` // 0 unless revoked
+	RevocationReason string `json:"revocation_reason"`
+}
+
+/*
+ * Emission test result of a certified station
+ */
+type EmissionTest struct {
+	Co2       int    `json:"co2"`   // g/km
+	Class     string `json:"class"` // 'euro1' to 'euro6' or 'electric'
+	Station   string `json:"station"`
+	TestedTs  int64  `json:"tested_ts"`
+	ExpiresTs int64  `json:"expires_ts"`
+}
+
+/*
+ * Emission status of a car, see 'getEmissionStatus'
+ */
+type EmissionStatus struct {
+	Vin      string       `json:"vin"`
+	Test     EmissionTest `json:"test"`
+	Current  bool         `json:"current"`  // test is not expired
+	Required bool         `json:"required"` // car is old enough to need a test
+	Badge    string       `json:"badge"`    // 'zero', 'green', 'yellow', 'red' or 'none'
+}
+
+/*
+ * Attested mileage of a car
+ */
+type Odometer struct {
+	Km         int    `json:"km"`          // latest reading
+	ObservedTs int64  `json:"observed_ts"` // when the latest reading was taken
+	Status     string `json:"status"`      // '' or 'odometerDiscrepancy'
+	Reason     string `json:"reason"`      // conflicting readings, or how the DOT resolved them
+}
+
+/*
+ * External data provider, see 'registerOracle'
+ */
+type Oracle struct {
+	Name     string `json:"name"`
+	Identity string `json:"identity"` // hash of the oracle client identity
+	Msp      string `json:"msp"`      // MSP id of the oracle organization
+	Kind     string `json:"kind"`     // e.g. 'inspection' or 'manufacturer'
+	Active   bool   `json:"active"`
+}
+
+/*
+ * Mileage reading of an oracle
+ */
+type MileageAttestation struct {
+	Vin        string `json:"vin"`
+	Km         int    `json:"km"`
+	ObservedTs int64  `json:"observed_ts"` // when the reading was taken
+	RecordedTs int64  `json:"recorded_ts"` // when it was pushed to the ledger
+	Oracle     string `json:"oracle"`
+	Identity   string `json:"identity"`
+	Msp        string `json:"msp"`
+	Conflicts  bool   `json:"conflicts"` // contradicts an earlier reading
+}
+
+/*
+ * Telematics device of a car, see 'enrollDevice'
+ */
+type TelematicsDevice struct {
+	Identity      string `json:"identity"` // hash of the device client identity
+	EnrolledTs    int64  `json:"enrolled_ts"`
+	Revoked       bool   `json:"revoked"`
+	LastTripEndTs int64  `json:"last_trip_end_ts"` // new trips start after this
+}
+
+/*
+ * Trip reported by a telematics device
+ */
+type Trip struct {
+	Vin     string `json:"vin"`
+	Device  string `json:"device"` // identity hash of the reporting device
+	StartTs int64  `json:"start_ts"`
+	EndTs   int64  `json:"end_ts"`
+	Km      int    `json:"km"`
+}
+
+/*
+ * Short-term rental of a car, see 'startRental'
+ */
+type Rental struct {
+	Renter        string         `json:"renter"`
+	Status        string         `json:"status"` // '', 'offered' or 'active'
+	StartTs       int64          `json:"start_ts"`
+	EndTs         int64          `json:"end_ts"`
+	Deposit       int            `json:"deposit"` // taken from the renter when accepting
+	DamageReports []DamageReport `json:"damage_reports"`
+}
+
+/*
+ * Price a car changed its owner for, see 'getPriceHistory'
+ */
+type PriceRecord struct {
+	Vin       string `json:"vin"`
+	Kind      string `json:"kind"`                 // 'sale', 'installments' or 'transfer'
+	Price     int    `json:"price"`                // 0 for transfers and private prices
+	PriceHash string `json:"price_hash,omitempty"` // sha256 of salt and price of a private price
+	Brand     string `json:"brand"`
+	Model     string `json:"model"`
+	Ts        int64  `json:"ts"`
+	TxId      string `json:"tx_id"`
+}
+
+/*
+ * Public sale prices of a model, see 'averagePriceByModel'
+ */
+type ModelPriceStats struct {
+	Brand   string `json:"brand"`
+	Model   string `json:"model"`
+	Sales   int    `json:"sales"`
+	Total   int    `json:"total"`
+	Average int    `json:"average"`
+}
+
+/*
+ * Offer of a car on the market, see 'listCar'
+ */
+type Listing struct {
+	Price    int          `json:"price"` // asking price, 0 if the car is not listed
+	ListedTs int64        `json:"listed_ts"`
+	Stake    int          `json:"stake"`            // listing deposit of the seller, see 'listCar'
+	Hold     *DepositHold `json:"hold,omitempty"`   // deposit taking the car off the market
+	Badges   []string     `json:"badges,omitempty"` // 'historic'
+	Queue    []QueueEntry `json:"queue,omitempty"`  // interested buyers, first come first served
+}
+
+/*
+ * Buyer waiting for a listed car, see 'joinQueue'
+ */
+type QueueEntry struct {
+	Buyer    string `json:"buyer"`
+	JoinedTs int64  `json:"joined_ts"`
+}
+
+/*
+ * Buyer skipped in the queue of a listed car,
+ * see 'skipQueuedBuyer'
+ */
+type QueueSkip struct {
+	Vin      string `json:"vin"`
+	Buyer    string `json:"buyer"`
+	Seller   string `json:"seller"`
+	Reason   string `json:"reason"`
+	JoinedTs int64  `json:"joined_ts"`
+	Ts       int64  `json:"ts"`
+}
+
+/*
+ * Deposit of a buyer on a listed car, see 'placeDeposit'
+ */
+type DepositHold struct {
+	Buyer     string `json:"buyer"`
+	Amount    int    `json:"amount"` // taken from the buyer when placing the deposit
+	PlacedTs  int64  `json:"placed_ts"`
+	ExpiresTs int64  `json:"expires_ts"`       // end of the hold period
+	Funder    string `json:"funder,omitempty"` // bank paying the deposit, the buyer if empty
+	Loan      string `json:"loan,omitempty"`   // loan funding the deposit, see 'approveLoan'
+}
+
+/*
+ * Sale of a car in installments, see 'offerInstallments'
+ */
+type InstallmentPlan struct {
+	Buyer      string `json:"buyer"`
+	Status     string `json:"status"` // '', 'offered' or 'active', 'defaulted' for a reclaimed car
+	Price      int    `json:"price"`  // total price, the last installment pays the remainder
+	Count      int    `json:"count"`  // number of installments
+	PeriodDays int    `json:"period_days"`
+	Paid       int    `json:"paid"`        // installments paid so far
+	PaidAmount int    `json:"paid_amount"` // credits paid so far
+	AcceptedTs int64  `json:"accepted_ts"`
+	NextDueTs  int64  `json:"next_due_ts"` // due date of the next installment
+}
+
+type DamageReport struct {
+	ReportedTs  int64  `json:"reported_ts"`
+	Description string `json:"description"`
+}
+
+/*
+ * Owners of a car with their shares
+ */
+type CoOwnership struct {
+	Shares            map[string]int    `json:"shares"`             // share in percent by username, empty for a single owner
+	Quorum            int               `json:"quorum"`             // share needed to transfer the car, 0 for unanimous
+	Pending           *CoOwnerChange    `json:"pending"`            // change waiting for approval
+	Approvals         []string          `json:"approvals"`          // owners who approved the pending change
+	TransferApprovals map[string]string `json:"transfer_approvals"` // receiver each co-owner consented to
+}
+
+/*
+ * Change of the co-owners, see 'changeCoOwnership'
+ */
+type CoOwnerChange struct {
+	Action string `json:"action"` // 'add', 'remove' or 'quorum'
+	User   string `json:"user"`
+	Share  int    `json:"share"`
+	Quorum int    `json:"quorum"`
+}
+
+/*
+ * Mandate of an owner for another user, see 'grantMandate'
+ */
+type Mandate struct {
+	Vin        string   `json:"vin"`
+	Owner      string   `json:"owner"`      // owner who granted the mandate
+	Agent      string   `json:"agent"`      // user acting for the owner
+	Operations []string `json:"operations"` // 'sell', 'transfer', 'insure', 'revoke', 'read', 'service'
+	GrantedTs  int64    `json:"granted_ts"`
+	ExpiresTs  int64    `json:"expires_ts"`
+}
+
+/*
+ * Public facts of a car, see 'lookupCar'
+ */
+type PublicCar struct {
+	Vin     string   `json:"vin"`
+	Brand   string   `json:"brand"`
+	Model   string   `json:"model"`
+	Type    string   `json:"type"`
+	Year    int      `json:"year"` // year the car was created
+	Status  string   `json:"status"`
+	Recalls []string `json:"recalls"`
+	Stolen  bool     `json:"stolen"`
+
+	OdometerDiscrepancy bool `json:"odometer_discrepancy"`
+
+	Battery   *BatteryHealth `json:"battery,omitempty"`   // electric cars only
+	Appraisal *Appraisal     `json:"appraisal,omitempty"` // latest appraisal, if any
 }
 
 type UsageData struct {
@@ -21,9 +504,178 @@ type DataContribution struct {
 }
 
 type User struct {
-	Name    string   `json:"name"`
-	Cars    []string `json:"cars"`
-	Balance int      `json:"balance"`
+	Name     string   `json:"name"`
+	Cars     []string `json:"cars"` // derived from the ownership keys, see 'getUserCars'
+	Balance  int      `json:"balance"`
+	Identity string   `json:"identity"` // hash of the client certificate bound to the username
+	Fleet    Fleet    `json:"fleet"`    // members, if the user is a fleet account
+
+	ClaimsHistory ClaimsHistory    `json:"claims_history"`
+	RiskConsents  map[string]int64 `json:"risk_consents"` // expiry of the consent by insurer
+
+	ForgottenTs int64 `json:"forgotten_ts"` // when the personal data was erased, see 'forgetUser'
+}
+
+/*
+ * Personal data of a user, kept in a private
+ * data collection, see 'setPersonalData'
+ */
+type PersonalData struct {
+	Address    string `json:"address"`
+	Phone      string `json:"phone"`
+	NationalId string `json:"national_id"`
+	UpdatedTs  int64  `json:"updated_ts"`
+}
+
+/*
+ * Profile of a user, kept in a private data
+ * collection, see 'updateProfile'
+ */
+type Profile struct {
+	Name     string `json:"name,omitempty"`     // the username, only in responses
+	Identity string `json:"identity,omitempty"` // the bound identity, only in responses
+
+	DisplayName   string                  `json:"display_name"`
+	Email         string                  `json:"email"`
+	Notifications NotificationPreferences `json:"notifications"`
+	Certificates  []string                `json:"certificates"` // sha256 of further client certificates of the user, hex encoded
+	UpdatedTs     int64                   `json:"updated_ts"`
+}
+
+/*
+ * How and about what a user wants to be notified
+ */
+type NotificationPreferences struct {
+	Channel string   `json:"channel"` // 'email', 'sms' or 'none'
+	Events  []string `json:"events"`  // chaincode events, e.g. 'carSold'
+}
+
+/*
+ * Summary of the claims of a user, see 'getRiskProfile'
+ */
+type ClaimsHistory struct {
+	SinceTs       int64 `json:"since_ts"` // start of the history, 0 for older users
+	TotalClaims   int   `json:"total_claims"`
+	SettledClaims int   `json:"settled_claims"`
+	SettledAmount int   `json:"settled_amount"`
+	LastSettledTs int64 `json:"last_settled_ts"`
+}
+
+type RiskProfile struct {
+	User               string        `json:"user"`
+	History            ClaimsHistory `json:"history"`
+	YearsWithoutClaims int64         `json:"years_without_claims"`
+}
+
+/*
+ * Members of a fleet account
+ */
+type Fleet struct {
+	Admins  []string `json:"admins"`  // employees acting for the fleet
+	Drivers []string `json:"drivers"` // employees that can be assigned to fleet cars
+}
+
+/*
+ * Results of the DOT reporting queries, see 'stats.go'
+ */
+type StatusCounts struct {
+	Counts   map[string]int `json:"counts"` // cars by status
+	Bookmark string         `json:"bookmark"`
+}
+
+type RegistrationCount struct {
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Count    int    `json:"count"`
+	Bookmark string `json:"bookmark"`
+}
+
+type BrandCount struct {
+	Brand string `json:"brand"`
+	Count int    `json:"count"`
+}
+
+type BrandRanking struct {
+	Brands   []BrandCount `json:"brands"` // most registered brand first
+	Bookmark string       `json:"bookmark"`
+}
+
+/*
+ * Page of a ledger export, see 'exportState'
+ */
+type SnapshotPage struct {
+	Format   string          `json:"format"`  // always 'car_cc.snapshot'
+	Version  int             `json:"version"` // envelope version
+	Mode     string          `json:"mode"`    // 'full' or 'incremental'
+	Prefix   string          `json:"prefix"`
+	Entries  []SnapshotEntry `json:"entries"`
+	Bookmark string          `json:"bookmark"` // empty on the last page
+	Position int64           `json:"position"` // journal position to continue incrementally from
+}
+
+type SnapshotEntry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Deleted bool            `json:"deleted,omitempty"`
+	TxId    string          `json:"tx_id,omitempty"` // changing transaction, incremental mode only
+}
+
+/*
+ * Keys changed by one transaction, see 'journalStub'
+ */
+type JournalEntry struct {
+	TxId    string   `json:"tx_id"`
+	Ts      int64    `json:"ts"`
+	Changed []string `json:"changed"`
+	Deleted []string `json:"deleted"`
+}
+
+/*
+ * First outcome of a call with an idempotency key,
+ * see 'invokeOnce'
+ */
+type TxDedup struct {
+	TxId     string `json:"tx_id"`
+	Ts       int64  `json:"ts"`
+	Function string `json:"function"`
+	CallHash string `json:"call_hash"` // sha256 of function and arguments
+	Payload  []byte `json:"payload"`   // response of the first call
+}
+
+/*
+ * Privileged change of the ledger, see 'getAuditLog'
+ */
+type AuditEntry struct {
+	TxId     string `json:"tx_id"`
+	Ts       int64  `json:"ts"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Identity string `json:"identity"` // sha256 of the invoking client identity, '' if unknown
+	Function string `json:"function"`
+	ArgsHash string `json:"args_hash"` // sha256 of function and arguments
+}
+
+type InventoryEntry struct {
+	StockedTs   int64  `json:"stocked_ts"`  // when the car came into stock
+	Salesperson string `json:"salesperson"` // employee handling the car
+}
+
+type InventoryItem struct {
+	Vin         string `json:"vin"`
+	Status      string `json:"status"` // 'unregistered', 'registered', 'insured', 'confirmed', ...
+	StockedTs   int64  `json:"stocked_ts"`
+	DaysInStock int64  `json:"days_in_stock"`
+	Salesperson string `json:"salesperson"`
+
+	Battery   *BatteryHealth `json:"battery,omitempty"`   // electric cars only
+	Appraisal *Appraisal     `json:"appraisal,omitempty"` // latest appraisal, if any
+}
+
+type InventoryImport struct {
+	Car                  Car                  `json:"car"`
+	RegistrationProposal RegistrationProposal `json:"registration_proposal"`
+	StockedTs            int64                `json:"stocked_ts"` // (optional) in stock since, defaults to now
+	Salesperson          string               `json:"salesperson"`
 }
 
 type Insurer struct {
@@ -37,28 +689,1167 @@ type InsureProposal struct {
 }
 
 /*
- * Fahrzeugausweis
- *
- * The car certificate information is attested by the DOT
+ * Request of an owner to revoke a car, see 'revocationProposal'
  */
-type Certificate struct {
-	Username    string `json:"username"`    // car owners name
-	Insurer     string `json:"insurer"`     // the name of an insurance company
-	Numberplate string `json:"numberplate"` // number plate ('AG 104 739')
-	Vin         string `json:"vin"`         // vehicle identification number ('WVW ZZZ 6RZ HY26 0780')
-	Color       string `json:"color"`
-	Type        string `json:"type"` // type: 'passenger car', 'truck', ...
-	Brand       string `json:"brand"`
+type RevocationProposal struct {
+	Car       string `json:"car"`
+	User      string `json:"user"`
+	CreatedTs int64  `json:"created_ts"` // 0 for proposals made before schema version 8
 }
 
 /*
- * Pruefungsbericht
- * (Form. 13.20 A)
+ * Request for insurance quotes, see 'requestQuote'
  */
-type RegistrationProposal struct {
-	Car               string `json:"car"`
-	NumberOfDoors     string `json:"number_of_doors"`     // '4+1' for a passenger car
-	NumberOfCylinders int    `json:"number_of_cylinders"` // 3, 4, 6, 8 ?
-	NumberOfAxis      int    `json:"number_of_axis"`      // typically 2
-	MaxSpeed          int    `json:"max_speed"`           // maximum speed as tested
+type QuoteRequest struct {
+	Car       QuoteCarData `json:"car"` // the car facts shared with insurers
+	Owner     string       `json:"owner"`
+	Coverage  string       `json:"coverage"` // 'liability', 'partial' or 'comprehensive'
+	Status    string       `json:"status"`   // 'open' or 'accepted'
+	CreatedTs int64        `json:"created_ts"`
+	Insurer   string       `json:"insurer"` // insurer of the accepted quote
+
+	ProductInsurer string `json:"product_insurer,omitempty"` // insurance product the request started from
+	ProductId      string `json:"product_id,omitempty"`
+}
+
+type QuoteCarData struct {
+	Vin           string `json:"vin"`
+	Brand         string `json:"brand"`
+	Model         string `json:"model"`
+	Type          string `json:"type"`
+	Year          int    `json:"year"`
+	MileAge       int    `json:"mile_age"`
+	EmissionClass string `json:"emission_class"`
+	Stolen        bool   `json:"stolen"`
+}
+
+type Quote struct {
+	Vin         string `json:"vin"`
+	Insurer     string `json:"insurer"`
+	Coverage    string `json:"coverage"`
+	Price       int    `json:"price"`
+	Conditions  string `json:"conditions"`
+	RequestTs   int64  `json:"request_ts"` // creation of the answered request
+	SubmittedTs int64  `json:"submitted_ts"`
+}
+
+/*
+ * Accreditation of an insurer by the
+ * regulator, see 'accreditInsurer'
+ */
+type Accreditation struct {
+	Insurer      string                `json:"insurer"`
+	Licence      string                `json:"licence"` // licence number of the regulator
+	Status       string                `json:"status"`  // 'active' or 'suspended'
+	AccreditedBy string                `json:"accredited_by"`
+	AccreditedTs int64                 `json:"accredited_ts"`
+	Changes      []AccreditationChange `json:"changes"` // suspensions and reinstatements
+}
+
+/*
+ * Suspension or reinstatement of an insurer
+ */
+type AccreditationChange struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	By     string `json:"by"`
+	Ts     int64  `json:"ts"`
+}
+
+/*
+ * Coverage product an insurer offers in the
+ * insurance marketplace
+ */
+type InsuranceProduct struct {
+	Id          string             `json:"id"` // unique per insurer
+	Insurer     string             `json:"insurer"`
+	Name        string             `json:"name"`
+	Coverage    string             `json:"coverage"`     // 'liability', 'partial' or 'comprehensive'
+	BasePremium int                `json:"base_premium"` // price of the quote for an eligible car
+	Eligibility ProductEligibility `json:"eligibility"`
+	Active      bool               `json:"active"` // false once withdrawn
+	PublishedTs int64              `json:"published_ts"`
+}
+
+/*
+ * Rules a car has to meet for an insurance product,
+ * empty lists and zero limits do not restrict
+ */
+type ProductEligibility struct {
+	Types           []string `json:"types"`
+	Brands          []string `json:"brands"`
+	EmissionClasses []string `json:"emission_classes"`
+	MaxAge          int      `json:"max_age"`     // years
+	MaxMileage      int      `json:"max_mileage"` // km
+}
+
+/*
+ * Car in the portfolio of an insurer
+ */
+type InsuredCar struct {
+	Vin         string          `json:"vin"`
+	Brand       string          `json:"brand"`
+	Model       string          `json:"model"`
+	Type        string          `json:"type"`
+	Numberplate string          `json:"numberplate"`
+	Policy      InsurancePolicy `json:"policy"`
+}
+
+/*
+ * A page of insured cars
+ */
+type InsuredCarPage struct {
+	Cars     []InsuredCar `json:"cars"`
+	Bookmark string       `json:"bookmark"` // pass to get the next page, empty on the last page
+}
+
+/*
+ * Insurance claim filed by a car owner after an accident.
+ *
+ * A claim moves through the states
+ * 'filed' -> 'approved' | 'rejected' and 'approved' -> 'settled'.
+ */
+type Claim struct {
+	Id        string `json:"id"`
+	Car       string `json:"car"`
+	User      string `json:"user"`     // claimant, the car owner
+	Insurer   string `json:"insurer"`  // insurance company of the car
+	Accident  string `json:"accident"` // accident report as given by the owner
+	Amount    int    `json:"amount"`   // requested payout in credits
+	Status    string `json:"status"`
+	Reason    string `json:"reason"` // reason for a rejection
+	CreatedTs int64  `json:"created_ts"`
+	OnLedger  bool   `json:"on_ledger"` // payout settled against user balances
+
+	Liable       string `json:"liable,omitempty"`        // prospect liable by the terms of a test drive during the accident
+	LiableAmount int    `json:"liable_amount,omitempty"` // part of the amount the prospect is liable for
+}
+
+/*
+ * Net position of an owner over their
+ * cars, see 'getOwnerEquity'
+ */
+type OwnerEquity struct {
+	Owner   string      `json:"owner"`
+	Balance int         `json:"balance"`
+	Value   int         `json:"value"`  // sum of the latest appraisals
+	Claims  int         `json:"claims"` // approved claims not paid out yet
+	Liens   int         `json:"liens"`  // remaining principal of the loans
+	Fees    int         `json:"fees"`   // unpaid fines, toll debt and road tax
+	Equity  int         `json:"equity"` // value and claims less liens and fees
+	Cars    []CarEquity `json:"cars"`
+}
+
+type CarEquity struct {
+	Vin         string `json:"vin"`
+	Value       int    `json:"value"` // latest appraisal, 0 if none
+	AppraisedTs int64  `json:"appraised_ts"`
+	Claims      int    `json:"claims"`
+	Lien        int    `json:"lien"` // remaining principal of the loan
+	Fines       int    `json:"fines"`
+	Tolls       int    `json:"tolls"`
+	RoadTax     int    `json:"road_tax"`
+	Fees        int    `json:"fees"`
+	Equity      int    `json:"equity"`
+}
+
+/*
+ * Fahrzeugausweis
+ *
+ * The car certificate information is attested by the DOT
+ */
+type Certificate struct {
+	Username    string `json:"username"`    // car owners name
+	Insurer     string `json:"insurer"`     // the name of an insurance company
+	Numberplate string `json:"numberplate"` // number plate ('AG 104 739')
+	Vin         string `json:"vin"`         // vehicle identification number ('WVWZZZ6R6HY260780')
+	Color       string `json:"color"`
+	Type        string `json:"type"` // type: 'passenger car', 'truck', ...
+	Brand       string `json:"brand"`
+	Model       string `json:"model"`
+	Variant     string `json:"variant"`
+	CatalogId   string `json:"catalog_id"` // vehicle catalog entry, see 'addCatalogEntry'
+
+	RegisteredTs int64 `json:"registered_ts"` // registration date, 0 for cars registered before it was kept
+
+	// revision of the issued document, see 'issueCertificate'
+	Version   int    `json:"version"`    // 0 until the first confirmation
+	IssuedBy  string `json:"issued_by"`  // hash of the DOT client identity
+	IssuedTs  int64  `json:"issued_ts"`  // issue date
+	StateHash string `json:"state_hash"` // sha256 of the car state at issuance, hex encoded
+}
+
+/*
+ * Issued revision of a car certificate
+ */
+type CertificateRevision struct {
+	Vin         string      `json:"vin"`
+	Version     int         `json:"version"`
+	Certificate Certificate `json:"certificate"`
+}
+
+/*
+ * Temporary permit to drive an unregistered car
+ * to the inspection station.
+ */
+type TripPermit struct {
+	IssuedBy string `json:"issued_by"` // DOT or garage user who issued the permit
+	ValidOn  string `json:"valid_on"`  // day of the trip ('2017-06-30')
+	Route    string `json:"route"`     // route reference, e.g. 'garage AMAG Zurich -> DOT Regensdorf'
+	Consumed bool   `json:"consumed"`  // set after the inspection was recorded
+}
+
+/*
+ * Temporary export plate of a car driven abroad,
+ * see 'issueTransitPermit'
+ */
+type TransitPermit struct {
+	Vin         string `json:"vin"`
+	Plate       string `json:"plate"`       // temporary plate ('EXP 1A2B3C4D')
+	Destination string `json:"destination"` // destination country, if known
+	IssuedBy    string `json:"issued_by"`
+	IssuedTs    int64  `json:"issued_ts"`
+	ExpiresTs   int64  `json:"expires_ts"`
+}
+
+/*
+ * Chaincode configuration
+ */
+type Config struct {
+	Admins []string `json:"admins"` // hashes of the client identities allowed to change the configuration
+
+	StickerKey      string `json:"sticker_key"`       // DOT public key for stickers, hex encoded ed25519
+	ProposalTtlDays int    `json:"proposal_ttl_days"` // days a registration proposal stays open, 0 for the default
+
+	PlateFormats   map[string]string `json:"plate_formats"`   // numberplate regex by jurisdiction ('ZH'), '' for the default
+	Fees           map[string]int    `json:"fees"`            // fee schedule by fee name ('plate_reservation'), missing fees use the default
+	FeePercentages map[string]int    `json:"fee_percentages"` // percentage of the charged amount by fee name ('transfer_tax')
+
+	ValuationThreshold   int `json:"valuation_threshold"`    // percent of the reference value below which sales are reviewed, 0 for the default
+	InstallmentGraceDays int `json:"installment_grace_days"` // days an installment may be overdue before the seller reclaims the car, 0 for the default
+	DepositHoldDays      int `json:"deposit_hold_days"`      // days a deposit takes a listed car off the market, 0 for the default
+
+	AppraisalThreshold    int `json:"appraisal_threshold"`     // financed amount above which a current appraisal is required, 0 for never
+	AppraisalValidityDays int `json:"appraisal_validity_days"` // days an appraisal stays current, 0 for the default
+
+	CatalogRequired bool `json:"catalog_required"` // new cars need a vehicle catalog entry
+
+	Custodian string `json:"custodian"` // state account taking seized cars into custody, '' for none
+
+	HistoricAgeYears int `json:"historic_age_years"` // age from which cars can be classified as historic, 0 for the default
+
+	RegistrationRules map[string][]RegistrationRule `json:"registration_rules"` // confirmation rules by jurisdiction, '' for the default, see 'registrationRules'
+
+	RoadTax map[string]RoadTaxRule `json:"road_tax"` // road tax by jurisdiction, '' for the default, see 'roadTaxRule'
+
+	TollDebtThreshold int `json:"toll_debt_threshold"` // unpaid toll above which transfers are refused, 0 for the default
+
+	ReportOwnerShare int `json:"report_owner_share"` // percentage of the vehicle report fee paid to the owner of the car
+}
+
+/*
+ * Requirement a car has to meet to be confirmed
+ * in a jurisdiction, see 'evaluateRules'
+ */
+type RegistrationRule struct {
+	Requirement  string   `json:"requirement"`   // 'insurance', 'emission_test', 'emission_class' or 'road_tax'
+	MinAgeYears  int      `json:"min_age_years"` // applies to cars of at least this age, 0 for every car
+	IntervalDays int      `json:"interval_days"` // 'emission_test': days the test stays valid for confirmation, 0 for its expiry date
+	Classes      []string `json:"classes"`       // 'emission_class': accepted emission classes
+}
+
+/*
+ * Annual road tax of a jurisdiction, see 'assessRoadTax'
+ */
+type RoadTaxRule struct {
+	Base            int            `json:"base"`             // flat part of the tax
+	PerTonne        int            `json:"per_tonne"`        // per started tonne of weight
+	ClassSurcharges map[string]int `json:"class_surcharges"` // by emission class, negative for a rebate
+	DueDays         int            `json:"due_days"`         // days to pay after the assessment, 0 for the default
+}
+
+/*
+ * Entry of the expiry index, see 'getExpiring'
+ */
+type ExpiryEntry struct {
+	Type      string `json:"type"` // 'insurance', 'emission', 'permit', 'cover_note', ...
+	Vin       string `json:"vin"`
+	ExpiresTs int64  `json:"expires_ts"`
+}
+
+/*
+ * Compressed result of a query, see 'compressResponse'
+ */
+type CompressedPayload struct {
+	Encoding       string `json:"encoding"`        // 'gzip'
+	Size           int    `json:"size"`            // bytes of the uncompressed result
+	CompressedSize int    `json:"compressed_size"` // bytes of the compressed result
+	Count          int    `json:"count"`           // elements of the result if it is an array, 0 otherwise
+	Payload        []byte `json:"payload"`         // compressed result, base64 encoded
+}
+
+/*
+ * Result of 'processExpirations'
+ */
+type ExpirationReport struct {
+	Processed int      `json:"processed"` // expiry index entries processed
+	Revoked   []string `json:"revoked"`   // VINs of the cars that lost their numberplate
+	More      bool     `json:"more"`      // more entries are due, call again
+}
+
+/*
+ * DOT treasury account collecting taxes and fees
+ */
+type Treasury struct {
+	Balance   int            `json:"balance"`
+	Collected map[string]int `json:"collected"` // total collected by fee name
+}
+
+/*
+ * Result of a state migration
+ */
+type MigrationResult struct {
+	From    int      `json:"from"`    // schema version before
+	To      int      `json:"to"`      // schema version after
+	Applied []string `json:"applied"` // descriptions of the applied migrations
+}
+
+/*
+ * Reservation of a personalized numberplate
+ */
+type PlateReservation struct {
+	Numberplate string `json:"numberplate"`
+	Username    string `json:"username"`
+	ReservedTs  int64  `json:"reserved_ts"`
+	ExpiresTs   int64  `json:"expires_ts"`
+	Fee         int    `json:"fee"`
+}
+
+/*
+ * Content of a registration sticker on the windshield
+ */
+type Sticker struct {
+	Vin         string `json:"v"`
+	Numberplate string `json:"p"`
+	ValidUntil  int64  `json:"u"` // unix timestamp
+	Flags       int    `json:"f"` // see sticker flags
+}
+
+/*
+ * Result of a sticker verification
+ */
+type StickerCheck struct {
+	Sticker Sticker `json:"sticker"`
+	Valid   bool    `json:"valid"`   // signed by the DOT
+	Expired bool    `json:"expired"` // registration validity ran out
+}
+
+/*
+ * State of a car moving between two channels.
+ *
+ * On the source channel a car is 'locked' and then 'released',
+ * on the target channel it is 'pending' until activated.
+ * A car with a handoff status is not active.
+ */
+type Handoff struct {
+	Status    string `json:"status"`
+	Channel   string `json:"channel"`   // the other channel
+	Chaincode string `json:"chaincode"` // car chaincode on the other channel
+	Owner     string `json:"owner"`
+	Hash      string `json:"hash"` // sha256 of the car without handoff, hex encoded
+}
+
+/*
+ * Regulatory order to move the policies of
+ * an insolvent insurer to another insurer
+ */
+type PortfolioTransfer struct {
+	OrderRef  string   `json:"order_ref"` // reference of the regulatory order
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Cars      []string `json:"cars"`      // VINs of all policies moved so far
+	Remaining int      `json:"remaining"` // policies left for the next chunk
+	UpdatedTs int64    `json:"updated_ts"`
+}
+
+/*
+ * Export certificate, issued by the DOT when a car
+ * leaves the country. The importing DOT validates it
+ * before registering the car in its own registry.
+ */
+type ExportCertificate struct {
+	Car                Car     `json:"car"`   // car as it was at the time of export
+	Owner              string  `json:"owner"` // owner at the time of export
+	DestinationCountry string  `json:"destination_country"`
+	ExportedTs         int64   `json:"exported_ts"`
+	Hash               string  `json:"hash"`      // sha256 of the exported car, hex encoded
+	Registry           string  `json:"registry"`  // exporting registry, as registered with its certificate
+	Signature          string  `json:"signature"` // of the registry over the hash, base64 encoded
+	Customs            Customs `json:"customs"`
+}
+
+/*
+ * Why and when a car left the active state, see 'archiveCar'
+ */
+type Archival struct {
+	Reason string `json:"reason"` // 'scrapped' or 'exported'
+	Ts     int64  `json:"ts"`
+	TxId   string `json:"tx_id"`
+}
+
+/*
+ * Archived car, kept under 'archive~<vin>' for auditors
+ */
+type ArchivedCar struct {
+	Car      Car      `json:"car"`   // car as it was when archived
+	Owner    string   `json:"owner"` // owner when archived, '' for legacy cars without one
+	Archival Archival `json:"archival"`
+}
+
+/*
+ * Left at the key of an archived car, reads
+ * as a 'Car' with only 'Vin' and 'Archived' set
+ */
+type Tombstone struct {
+	Vin      string   `json:"vin"`
+	Archived Archival `json:"archived"`
+}
+
+/*
+ * Customs clearance of an imported car
+ */
+type Customs struct {
+	Declaration string `json:"declaration"` // customs declaration number
+	Office      string `json:"office"`      // customs office that cleared the car
+	ClearedTs   int64  `json:"cleared_ts"`
+	Duty        int    `json:"duty"` // import duty paid
+}
+
+/*
+ * What the police gets to see on a roadside check
+ */
+type PoliceLookup struct {
+	Vin            string     `json:"vin"`
+	Numberplate    string     `json:"numberplate"`
+	Registered     bool       `json:"registered"`
+	Insured        bool       `json:"insured"`
+	Confirmed      bool       `json:"confirmed"`
+	Classification string     `json:"classification"`
+	Permit         TripPermit `json:"permit"`
+	PermitValid    bool       `json:"permit_valid"` // permit can be used today
+	Seized         bool       `json:"seized"`
+
+	TransitPermit *TransitPermit `json:"transit_permit,omitempty"` // only while it is valid
+}
+
+/*
+ * International proof of insurance replacing
+ * the paper green card, see 'issueInsuranceProof'
+ */
+type InsuranceProof struct {
+	Number          string   `json:"number"` // transaction id of the issue
+	Insurer         string   `json:"insurer"`
+	PolicyReference string   `json:"policy_reference"` // sha256 of the policy document
+	Countries       []string `json:"countries"`        // ISO 3166 alpha-2 codes
+	ValidFromTs     int64    `json:"valid_from_ts"`
+	ValidUntilTs    int64    `json:"valid_until_ts"`
+}
+
+/*
+ * What border control gets to see,
+ * see 'verifyInsuranceProof'
+ */
+type InsuranceProofCheck struct {
+	Vin         string          `json:"vin"`
+	Numberplate string          `json:"numberplate"`
+	Country     string          `json:"country"` // '' if not checked
+	Valid       bool            `json:"valid"`
+	Reason      string          `json:"reason"` // why the proof is not valid
+	Proof       *InsuranceProof `json:"proof,omitempty"`
+}
+
+/*
+ * Purchased access to the vehicle report
+ * of a car, see 'purchaseVehicleReport'
+ */
+type ReportToken struct {
+	Id          string `json:"id"` // transaction id of the purchase
+	Vin         string `json:"vin"`
+	Buyer       string `json:"buyer"`
+	Fee         int    `json:"fee"`
+	OwnerShare  int    `json:"owner_share"` // part of the fee paid to the owner of the car
+	PurchasedTs int64  `json:"purchased_ts"`
+	RedeemedTs  int64  `json:"redeemed_ts"` // 0 while unused
+	StateHash   string `json:"state_hash"`  // of the report the token was redeemed for
+}
+
+/*
+ * Pre-purchase report of a car,
+ * see 'getVehicleReport'
+ */
+type VehicleReport struct {
+	Vin          string `json:"vin"`
+	Brand        string `json:"brand"`
+	Model        string `json:"model"`
+	Numberplate  string `json:"numberplate"`
+	RegisteredTs int64  `json:"registered_ts"`
+
+	Classification string `json:"classification"` // '', 'salvage', 'total_loss' or 'rebuilt'
+	Stolen         bool   `json:"stolen"`
+
+	Ownerships       int               `json:"ownerships"` // number of owners so far
+	OwnershipChanges []OwnershipChange `json:"ownership_changes"`
+
+	Mileage        []MileageReading `json:"mileage"`         // oracle readings, earliest first
+	OdometerStatus string           `json:"odometer_status"` // '' or 'odometerDiscrepancy'
+
+	Accidents []AccidentRecord `json:"accidents"` // insurance claims, oldest first
+	Recalls   []string         `json:"recalls"`   // open recall campaigns
+	Lien      *Lien            `json:"lien,omitempty"`
+
+	Emission          EmissionTest `json:"emission"`           // latest emission test
+	RebuildInspection string       `json:"rebuild_inspection"` // DOT inspection report of a rebuilt car
+	NeedsInspection   bool         `json:"needs_inspection"`   // unapproved structural modification
+
+	GeneratedTs int64  `json:"generated_ts"`
+	StateHash   string `json:"state_hash"` // sha256 of the report with an empty 'state_hash'
+}
+
+/*
+ * Change of hands in a vehicle report
+ */
+type OwnershipChange struct {
+	Kind string `json:"kind"` // kind of the price record, 'sale', 'transfer', ...
+	Ts   int64  `json:"ts"`
+}
+
+/*
+ * Odometer reading in a vehicle report
+ */
+type MileageReading struct {
+	Km         int    `json:"km"`
+	ObservedTs int64  `json:"observed_ts"`
+	Oracle     string `json:"oracle"`
+	Conflicts  bool   `json:"conflicts"`
+}
+
+/*
+ * Insurance claim in a vehicle report
+ */
+type AccidentRecord struct {
+	Insurer string `json:"insurer"`
+	Amount  int    `json:"amount"`
+	Status  string `json:"status"`
+	Ts      int64  `json:"ts"`
+}
+
+/*
+ * Pruefungsbericht
+ * (Form. 13.20 A)
+ */
+type RegistrationProposal struct {
+	Car               string `json:"car"`
+	NumberOfDoors     string `json:"number_of_doors"`     // '4+1' for a passenger car
+	NumberOfCylinders int    `json:"number_of_cylinders"` // 3, 4, 6, 8 ?
+	NumberOfAxis      int    `json:"number_of_axis"`      // typically 2
+	MaxSpeed          int    `json:"max_speed"`           // maximum speed as tested
+
+	Owner      string `json:"owner"`       // garage that created the car
+	Status     string `json:"status"`      // 'pending', 'approved' or 'rejected'
+	CreatedTs  int64  `json:"created_ts"`  // when the car was created
+	Reviewer   string `json:"reviewer"`    // DOT user that reviewed the proposal
+	ReviewedTs int64  `json:"reviewed_ts"` // when the proposal was reviewed
+	Reason     string `json:"reason"`      // reason for a rejection
+	ExpiresTs  int64  `json:"expires_ts"`  // pending proposals cannot be approved after this
+
+	Amendments []ProposalAmendment `json:"amendments,omitempty"` // change requests and amendments, oldest first
+}
+
+/*
+ * Entry of the amendment history of a registration
+ * proposal, see 'amendProposal'
+ */
+type ProposalAmendment struct {
+	Action  string `json:"action"` // 'changes_requested' or 'amended'
+	By      string `json:"by"`
+	Ts      int64  `json:"ts"`
+	Comment string `json:"comment"`
+
+	// registration data replaced by an amendment
+	NumberOfDoors     string `json:"number_of_doors,omitempty"`
+	NumberOfCylinders int    `json:"number_of_cylinders,omitempty"`
+	NumberOfAxis      int    `json:"number_of_axis,omitempty"`
+	MaxSpeed          int    `json:"max_speed,omitempty"`
+}
+
+/*
+ * Payload of the 'proposalApproved' event
+ */
+type ProposalApproval struct {
+	Vin      string `json:"vin"`
+	Reviewer string `json:"reviewer"`
+	Ts       int64  `json:"ts"`
+}
+
+/*
+ * Payload of the 'proposalsApproved' event,
+ * see 'approveProposalsBatch'
+ */
+type ProposalBatchApproval struct {
+	Reviewer string             `json:"reviewer"`
+	Ts       int64              `json:"ts"`
+	Approved []ProposalApproval `json:"approved"` // one entry per registered car
+	Failed   int                `json:"failed"`
+}
+
+/*
+ * A page of registration proposals
+ */
+type ProposalPage struct {
+	Proposals []RegistrationProposal `json:"proposals"`
+	Bookmark  string                 `json:"bookmark"` // pass to get the next page, empty on the last page
+}
+
+/*
+ * Outcome for a single car of a batch
+ */
+type BatchResult struct {
+	Vin   string          `json:"vin"`
+	Ok    bool            `json:"ok"`
+	Error *ChaincodeError `json:"error,omitempty"`
+}
+
+/*
+ * Committed revision of a car, see 'getCarHistory'
+ */
+type CarRevision struct {
+	TxId    string `json:"tx_id"`
+	Ts      int64  `json:"ts"`
+	Car     *Car   `json:"car,omitempty"` // nil if the car was deleted
+	Deleted bool   `json:"deleted"`
+}
+
+/*
+ * Reference value of a model for the transfer tax,
+ * see 'setReferenceValue'
+ */
+type ReferenceValue struct {
+	Brand     string `json:"brand"`
+	Model     string `json:"model"`
+	Value     int    `json:"value"`
+	UpdatedTs int64  `json:"updated_ts"`
+}
+
+/*
+ * Seizure of a car, see 'seizeCar'
+ */
+type Seizure struct {
+	CaseRef  string `json:"case_ref"` // case reference of the police or court, '' if not seized
+	SeizedBy string `json:"seized_by"`
+	Role     string `json:"role"` // 'police' or 'court'
+	SeizedTs int64  `json:"seized_ts"`
+
+	Custodian     string `json:"custodian"`      // custodian account the car was transferred to, if any
+	PreviousOwner string `json:"previous_owner"` // owner before the custody
+
+	ReleasedBy string `json:"released_by"` // only set in the 'carReleased' event
+	ReleasedTs int64  `json:"released_ts"`
+}
+
+/*
+ * Payload of the 'carSeized' and 'carReleased' events
+ */
+type SeizureEvent struct {
+	Vin     string  `json:"vin"`
+	Seizure Seizure `json:"seizure"`
+	TxId    string  `json:"tx_id"`
+}
+
+/*
+ * Transfer of a car to the heir of its owner,
+ * see 'inheritCar'
+ */
+type Inheritance struct {
+	Vin         string                `json:"vin"`
+	Owner       string                `json:"owner"` // the deceased owner
+	Heir        string                `json:"heir"`
+	ProbateHash string                `json:"probate_hash"` // sha256 of the probate document, hex encoded
+	Status      string                `json:"status"`       // 'pending' or 'completed'
+	Approvals   []InheritanceApproval `json:"approvals"`
+	CompletedTs int64                 `json:"completed_ts"`
+}
+
+/*
+ * Approval of an inheritance by a DOT officer
+ */
+type InheritanceApproval struct {
+	Officer  string `json:"officer"`
+	Identity string `json:"identity"` // hash of the client identity of the officer
+	Ts       int64  `json:"ts"`
+	TxId     string `json:"tx_id"`
+}
+
+/*
+ * Second car claiming the VIN of a registered car,
+ * see 'openVinConflict'
+ */
+type VinConflict struct {
+	Vin      string `json:"vin"`
+	Status   string `json:"status"` // 'open' or 'resolved'
+	Owner    string `json:"owner"`  // owner of the registered car when the conflict was opened
+	Claim    Car    `json:"claim"`  // the second car as presented
+	Claimant string `json:"claimant"`
+	OpenedBy string `json:"opened_by"`
+	OpenedTs int64  `json:"opened_ts"`
+
+	Notes []VinConflictNote `json:"notes"` // investigation notes, oldest first
+
+	Fraudulent string `json:"fraudulent"` // 'registered' or 'claim', '' until resolved
+	ResolvedBy string `json:"resolved_by"`
+	ResolvedTs int64  `json:"resolved_ts"`
+}
+
+/*
+ * Investigation note on a VIN conflict
+ */
+type VinConflictNote struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+	Ts     int64  `json:"ts"`
+	TxId   string `json:"tx_id"`
+}
+
+/*
+ * Fraudulent record of a VIN, see 'resolveVinConflict'
+ */
+type QuarantinedCar struct {
+	Car           Car    `json:"car"`
+	Owner         string `json:"owner"`
+	QuarantinedTs int64  `json:"quarantined_ts"`
+	TxId          string `json:"tx_id"`
+}
+
+/*
+ * Technical data sheet of a car leaving the factory
+ */
+type TechnicalData struct {
+	Brand        string   `json:"brand"`
+	Model        string   `json:"model"`
+	Weight       int      `json:"weight"` // kg
+	EngineNumber string   `json:"engine_number"`
+	ColorCodes   []string `json:"color_codes"` // paint codes of the manufacturer
+}
+
+/*
+ * Birth certificate issued by the manufacturer
+ * of a car, see 'manufacture'
+ */
+type BirthCertificate struct {
+	Vin          string        `json:"vin"`
+	Manufacturer string        `json:"manufacturer"`
+	Identity     string        `json:"identity"` // hash of the client identity that signed the data sheet
+	Data         TechnicalData `json:"data"`
+	DataHash     string        `json:"data_hash"` // sha256 of the data sheet as submitted, hex encoded
+	IssuedTs     int64         `json:"issued_ts"`
+	TxId         string        `json:"tx_id"`
+
+	Garage   string `json:"garage"`    // garage that created the car, '' until then
+	LinkedTs int64  `json:"linked_ts"` // creation date of the car
+}
+
+/*
+ * Reference of a car to its birth certificate
+ */
+type BirthLink struct {
+	Manufacturer string `json:"manufacturer"`
+	DataHash     string `json:"data_hash"`
+	IssuedTs     int64  `json:"issued_ts"`
+}
+
+/*
+ * Make, model and variant in the vehicle catalog,
+ * see 'addCatalogEntry'
+ */
+type CatalogEntry struct {
+	Id            string `json:"id"`
+	Brand         string `json:"brand"`
+	Model         string `json:"model"`
+	Variant       string `json:"variant"`        // trim level ('1.0 TSI Comfortline')
+	Engine        string `json:"engine"`         // engine code or description
+	EmissionClass string `json:"emission_class"` // 'euro1' to 'euro6' or 'electric'
+	AddedTs       int64  `json:"added_ts"`
+}
+
+/*
+ * Sale with a declared price far below the reference
+ * value, held back for the DOT, see 'approveSale'
+ */
+type SaleReview struct {
+	Vin            string `json:"vin"`
+	Seller         string `json:"seller"`
+	Buyer          string `json:"buyer"`
+	Price          int    `json:"price"`           // declared price
+	ReferenceValue int    `json:"reference_value"` // reference value of the model at the time of sale
+	Status         string `json:"status"`          // 'pending', 'approved' or 'rejected'
+	CreatedTs      int64  `json:"created_ts"`
+	Reviewer       string `json:"reviewer"`
+	ReviewedTs     int64  `json:"reviewed_ts"`
+	AssessedValue  int    `json:"assessed_value"` // value the transfer tax was charged on
+	Reason         string `json:"reason"`         // reason of a rejection
+}
+
+/*
+ * Sale of several cars to one buyer,
+ * see 'sellDeal'
+ */
+type Deal struct {
+	Id     string     `json:"id"` // transaction that closed the deal
+	Seller string     `json:"seller"`
+	Buyer  string     `json:"buyer"`
+	SoldBy string     `json:"sold_by"` // seller or agent with a sell mandate
+	Price  int        `json:"price"`   // total price
+	Cars   []DealItem `json:"cars"`
+	Ts     int64      `json:"ts"`
+}
+
+/*
+ * Car of a deal with the part of the price
+ * allocated to it
+ */
+type DealItem struct {
+	Vin   string `json:"vin"`
+	Price int    `json:"price"`
+	Tax   int    `json:"tax"` // transfer tax on the allocated price
+}
+
+/*
+ * Result of 'verifyIndexIntegrity'
+ */
+type IntegrityReport struct {
+	IndexedCars   int              `json:"indexed_cars"`
+	CarStates     int              `json:"car_states"`
+	OwnershipKeys int              `json:"ownership_keys"`
+	Issues        []IntegrityIssue `json:"issues"`
+}
+
+/*
+ * Mismatch between the car index, the
+ * ownership keys and the car states
+ */
+type IntegrityIssue struct {
+	Kind       string `json:"kind"` // e.g. 'missing_car' or 'dangling_link'
+	Vin        string `json:"vin"`
+	Owner      string `json:"owner"`      // owner pseudonym
+	Repairable bool   `json:"repairable"` // the car index settles it
+	Repaired   bool   `json:"repaired"`
+}
+
+/*
+ * Two cars side by side, see 'compareCars'
+ */
+type CarComparison struct {
+	A           CarSummary      `json:"a"`
+	B           CarSummary      `json:"b"`
+	Differences []CarDifference `json:"differences"`
+}
+
+/*
+ * Facts of a car for a comparison
+ */
+type CarSummary struct {
+	Vin            string        `json:"vin"`
+	Brand          string        `json:"brand"`
+	Model          string        `json:"model"`
+	Variant        string        `json:"variant"`
+	Type           string        `json:"type"`
+	Color          string        `json:"color"`
+	CatalogId      string        `json:"catalog_id"`
+	Classification string        `json:"classification"`
+	CreatedTs      int64         `json:"created_ts"`
+	Mileage        int           `json:"mileage"` // km
+	EmissionClass  string        `json:"emission_class"`
+	Accidents      int           `json:"accidents"`       // claims not rejected
+	LastServiceTs  int64         `json:"last_service_ts"` // 0 if never serviced
+	LastPrice      int           `json:"last_price"`      // latest public price, 0 if none
+	Prices         []PriceRecord `json:"prices"`
+}
+
+/*
+ * Fact in which two compared cars differ
+ */
+type CarDifference struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+/*
+ * Payload of the 'carSold' event
+ */
+type CarSale struct {
+	Vin    string `json:"vin"`
+	Seller string `json:"seller"`
+	Buyer  string `json:"buyer"`
+	Price  int    `json:"price"`
+	Ts     int64  `json:"ts"`
+
+	Warnings []string `json:"warnings,omitempty"` // 'unpaid_fines'
+}
+
+/*
+ * Event counted in the reputation of a user,
+ * see 'getReputation'
+ */
+type ReputationEntry struct {
+	User         string `json:"user"`
+	Kind         string `json:"kind"` // 'completed_sale', 'cancelled_deal', 'dispute_opened' or 'dispute_lost'
+	Vin          string `json:"vin"`
+	Counterparty string `json:"counterparty"`
+	Side         string `json:"side"` // 'seller' or 'buyer'
+	Ts           int64  `json:"ts"`
+	TxId         string `json:"tx_id"`
+}
+
+/*
+ * Reputation counters of a user
+ */
+type Reputation struct {
+	User           string `json:"user"`
+	CompletedSales int    `json:"completed_sales"` // as seller or buyer
+	CancelledDeals int    `json:"cancelled_deals"` // backed out of a deposit or defaulted on installments
+	DisputesOpened int    `json:"disputes_opened"`
+	DisputesLost   int    `json:"disputes_lost"`
+}
+
+/*
+ * Dispute of a buyer over a sale or deal,
+ * see 'openDispute'
+ */
+type Dispute struct {
+	Id         string            `json:"id"` // transaction that closed the sale or deal
+	Vins       []string          `json:"vins"`
+	Buyer      string            `json:"buyer"`
+	Seller     string            `json:"seller"`
+	Reason     string            `json:"reason"`
+	Claim      int               `json:"claim"`   // amount the buyer asks back
+	Escrow     int               `json:"escrow"`  // part of the claim held from the seller
+	Deposit    int               `json:"deposit"` // dispute deposit of the buyer
+	Evidence   []DisputeEvidence `json:"evidence"`
+	Status     string            `json:"status"` // 'open', 'refund', 'reversal', 'penalty' or 'dismissed'
+	OpenedTs   int64             `json:"opened_ts"`
+	ResolvedBy string            `json:"resolved_by"`
+	ResolvedTs int64             `json:"resolved_ts"`
+	Note       string            `json:"note"` // note of the arbiter on the resolution
+}
+
+/*
+ * Document submitted to a dispute,
+ * see 'addDisputeEvidence'
+ */
+type DisputeEvidence struct {
+	Hash        string `json:"hash"` // hex encoded sha256 of the document
+	Description string `json:"description"`
+	By          string `json:"by"`
+	Ts          int64  `json:"ts"`
+}
+
+/*
+ * Test drive of a prospective buyer, see 'scheduleTestDrive'
+ */
+type TestDrive struct {
+	Vin         string         `json:"vin"`
+	Owner       string         `json:"owner"`
+	Prospect    string         `json:"prospect"`
+	FromTs      int64          `json:"from_ts"`
+	ToTs        int64          `json:"to_ts"`
+	Terms       TestDriveTerms `json:"terms"`
+	Insurer     string         `json:"insurer"` // insurer of the car when scheduling, '' if uninsured
+	Insured     bool           `json:"insured"` // the policy covers the whole test drive
+	ScheduledBy string         `json:"scheduled_by"`
+	ScheduledTs int64          `json:"scheduled_ts"`
+}
+
+/*
+ * Liability agreed for a test drive
+ */
+type TestDriveTerms struct {
+	Liability string `json:"liability"` // 'prospect' or 'owner', liable for accidents during the test drive
+	Excess    int    `json:"excess"`    // most the prospect is liable for, 0 for the full damage
+}
+
+/*
+ * Loan of a bank financing the purchase of a
+ * listed car, see 'requestLoan'
+ */
+type Loan struct {
+	Id          string `json:"id"` // transaction id of the request
+	Vin         string `json:"vin"`
+	Buyer       string `json:"buyer"`
+	Seller      string `json:"seller"` // owner of the car when requested
+	Bank        string `json:"bank"`
+	Amount      int    `json:"amount"` // financed part of the asking price
+	Repaid      int    `json:"repaid"`
+	Status      string `json:"status"` // 'requested', 'rejected', 'funded', 'secured' or 'repaid'
+	Reason      string `json:"reason"` // reason of the bank for a rejection
+	RequestedTs int64  `json:"requested_ts"`
+	DecidedTs   int64  `json:"decided_ts"`
+	SecuredTs   int64  `json:"secured_ts"` // transfer of the car to the buyer
+
+	Terms        LoanTerms `json:"terms"`         // set by the bank when funding the loan
+	LatePayments int       `json:"late_payments"` // payments made while in arrears
+}
+
+/*
+ * Repayment terms of a loan, see 'approveLoan'
+ */
+type LoanTerms struct {
+	Rate         int `json:"rate"`         // yearly interest rate in basis points
+	Installments int `json:"installments"` // number of installments
+	PeriodDays   int `json:"period_days"`  // days between installments
+}
+
+/*
+ * Installment in the amortization schedule of a loan
+ */
+type LoanInstallment struct {
+	Number    int   `json:"number"` // starting at 1
+	DueTs     int64 `json:"due_ts"`
+	Principal int   `json:"principal"`
+	Interest  int   `json:"interest"` // accrued on the remaining principal over the period
+}
+
+/*
+ * Repayment status of the loan a car is
+ * pledged for, see 'getLoanStatus'
+ */
+type LoanStatus struct {
+	Loan          string            `json:"loan"`
+	Vin           string            `json:"vin"`
+	Bank          string            `json:"bank"`
+	Terms         LoanTerms         `json:"terms"`
+	Principal     int               `json:"principal"`   // remaining principal
+	Outstanding   int               `json:"outstanding"` // remaining principal and interest
+	NextDueTs     int64             `json:"next_due_ts"` // first installment not paid in full
+	NextDueAmount int               `json:"next_due_amount"`
+	Arrears       int               `json:"arrears"` // due but not paid
+	Flags         []string          `json:"flags"`   // 'in_arrears', 'late_payment'
+	Schedule      []LoanInstallment `json:"schedule"`
+}
+
+/*
+ * Pledge of a car to the bank financing its
+ * purchase, see 'repayLoan'
+ */
+type Lien struct {
+	Bank      string `json:"bank"`
+	Loan      string `json:"loan"`
+	Amount    int    `json:"amount"` // outstanding amount of the loan
+	CreatedTs int64  `json:"created_ts"`
+}
+
+/*
+ * Subsidy program of the government with its
+ * budget, see 'setSubsidyProgram'
+ */
+type SubsidyProgram struct {
+	Name      string `json:"name"`
+	Budget    int    `json:"budget"`
+	Granted   int    `json:"granted"` // sum of all grants so far
+	CreatedTs int64  `json:"created_ts"`
+	UpdatedBy string `json:"updated_by"`
+	UpdatedTs int64  `json:"updated_ts"`
+}
+
+/*
+ * Subsidy paid to the owner of an
+ * electric car, see 'grantSubsidy'
+ */
+type SubsidyGrant struct {
+	Program   string `json:"program"`
+	Vin       string `json:"vin"`
+	Buyer     string `json:"buyer"` // owner of the car when granted
+	Amount    int    `json:"amount"`
+	GrantedBy string `json:"granted_by"`
+	Ts        int64  `json:"ts"`
+	TxId      string `json:"tx_id"`
+}
+
+/*
+ * Road tax assessed on a car for a year,
+ * see 'assessRoadTax'
+ */
+type TaxAssessment struct {
+	Vin           string `json:"vin"`
+	Year          int    `json:"year"`
+	Jurisdiction  string `json:"jurisdiction"` // of the numberplate when assessed
+	Owner         string `json:"owner"`
+	Weight        int    `json:"weight"`         // kg, from the birth certificate, 0 if unknown
+	EmissionClass string `json:"emission_class"` // of the latest emission test, '' if none
+	Amount        int    `json:"amount"`
+	AssessedBy    string `json:"assessed_by"`
+	AssessedTs    int64  `json:"assessed_ts"`
+	DueTs         int64  `json:"due_ts"`
+	PaidBy        string `json:"paid_by"`
+	PaidTs        int64  `json:"paid_ts"` // 0 while unpaid
+}
+
+/*
+ * Link of a car to a toll account,
+ * see 'linkTollAccount'
+ */
+type TollAccount struct {
+	Operator string `json:"operator"` // toll operator of the account or of the unpaid toll
+	Account  string `json:"account"`  // account of the owner with the operator, '' if unlinked
+	LinkedTs int64  `json:"linked_ts"`
+	Debt     int    `json:"debt"` // toll the balance of the owner did not cover
+}
+
+/*
+ * Toll usage of a car posted by
+ * the toll operator, see 'postTolls'
+ */
+type TollPosting struct {
+	Vin       string `json:"vin"`
+	Reference string `json:"reference"` // id of the usage with the operator, unique per car
+	Amount    int    `json:"amount"`
+	UsageTs   int64  `json:"usage_ts"`  // when the car passed
+	Operator  string `json:"operator"`  // set on posting
+	Account   string `json:"account"`   // set on posting
+	Owner     string `json:"owner"`     // set on posting
+	Paid      int    `json:"paid"`      // settled from the balance of the owner, the rest is debt
+	PostedTs  int64  `json:"posted_ts"` // set on posting
+}
+
+/*
+ * Fine attached to a car by the police
+ * or a municipality, see 'issueFine'
+ */
+type Fine struct {
+	Id       string       `json:"id"` // transaction id of the issue
+	Vin      string       `json:"vin"`
+	Owner    string       `json:"owner"` // owner when issued
+	Reason   string       `json:"reason"`
+	Amount   int          `json:"amount"`
+	Status   string       `json:"status"` // 'unpaid', 'contested', 'paid' or 'cancelled'
+	IssuedBy string       `json:"issued_by"`
+	IssuedTs int64        `json:"issued_ts"`
+	DueTs    int64        `json:"due_ts"`
+	PaidBy   string       `json:"paid_by"`
+	PaidTs   int64        `json:"paid_ts"`
+	Contest  *FineContest `json:"contest,omitempty"`
+	Overdue  bool         `json:"overdue"` // set when read
+}
+
+/*
+ * Contest of a fine by the owner,
+ * see 'contestFine'
+ */
+type FineContest struct {
+	ContestedBy string `json:"contested_by"`
+	Reason      string `json:"reason"`
+	ContestedTs int64  `json:"contested_ts"`
+	Outcome     string `json:"outcome"` // 'upheld' or 'cancelled', '' while open
+	Note        string `json:"note"`
+	ResolvedBy  string `json:"resolved_by"`
+	ResolvedTs  int64  `json:"resolved_ts"`
 }
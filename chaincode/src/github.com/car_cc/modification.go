@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Modification approvals (tuning register).
+ *
+ * Owners, or garages with a 'service' mandate, ask the DOT
+ * to approve a modification with 'requestModificationApproval',
+ * passing a description and the sha256 of the documents of
+ * the installed parts. A car has one open request at a time,
+ * kept on the car. Reviewed requests move to
+ * 'modification~<vin>~<ts>~<txid>'.
+ *
+ * A structural modification needs an inspection: the car
+ * cannot be confirmed until the DOT approves the request.
+ * After a rejection, the DOT clears the car with
+ * 'clearInspection' once the modification is undone.
+ */
+
+// object type of reviewed modification keys
+const modificationObjectType string = "modification"
+
+// status of a modification request
+const modificationPending string = "pending"
+const modificationApproved string = "approved"
+const modificationRejected string = "rejected"
+
+/*
+ * Writes a reviewed modification request to the ledger
+ */
+func saveReviewedModification(stub shim.ChaincodeStubInterface, request *ModificationRequest) error {
+	key, err := stub.CreateCompositeKey(modificationObjectType, []string{request.Vin, fmt.Sprintf("%012d", request.RequestedTs), request.TxId})
+	if err != nil {
+		return newError(ErrInternal, "Error creating modification key")
+	}
+
+	requestAsBytes, _ := ledgerjson.Marshal(request)
+	err = stub.PutState(key, requestAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing modification request")
+	}
+
+	return nil
+}
+
+/*
+ * Asks the DOT to approve a modification of the car
+ * of 'owner'. A structural modification blocks the
+ * confirmation of the car until it is approved.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  description                              string
+ *  part document hashes                     JSON array of hex sha256
+ *  (optional) structural modification       bool
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) requestModificationApproval(stub shim.ChaincodeStubInterface, owner string, requester string, args []string) pb.Response {
+	vin := args[0]
+	description := args[1]
+	if description == "" {
+		return errorResponse(ErrInvalidArgument, "'requestModificationApproval' expects a non-empty description")
+	}
+
+	hashes := []string{}
+	err := ledgerjson.Unmarshal([]byte(args[2]), &hashes)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'requestModificationApproval' expects a JSON array of part document hashes")
+	}
+	for _, hash := range hashes {
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != 32 {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("Part document hash '%s' is no hex encoded sha256", hash))
+		}
+	}
+
+	structural := false
+	if len(args) > 3 {
+		structural, err = strconv.ParseBool(args[3])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "'requestModificationApproval' expects 'true' or 'false' for structural")
+		}
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsActive(&car) {
+		return errorResponse(ErrNotActive, "The car is handed off to another channel")
+	} else if car.Modification != nil {
+		return errorResponse(ErrInvalidState, "A modification request of the car is pending already")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Modification = &ModificationRequest{
+		Vin:         vin,
+		Owner:       owner,
+		RequestedBy: requester,
+		Description: description,
+		PartsHashes: hashes,
+		Structural:  structural,
+		Status:      modificationPending,
+		RequestedTs: now,
+		TxId:        stub.GetTxID()}
+
+	// unapproved structural modifications need an inspection
+	if structural {
+		car.NeedsInspection = true
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Modification of car '%s' requested by '%s'\n", vin, requester)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Approves or rejects the pending modification request
+ * of car 'vin'. Approving a structural modification
+ * clears the inspection.
+ *
+ * On success,
+ * returns the reviewed request.
+ */
+func (t *CarChaincode) reviewModification(stub shim.ChaincodeStubInterface, officer string, vin string, status string, note string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Modification == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no pending modification request of car '%s'", vin))
+	} else if status == modificationRejected && note == "" {
+		return errorResponse(ErrInvalidArgument, "A rejection needs a reason")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request := car.Modification
+	request.Status = status
+	request.ReviewedBy = officer
+	request.ReviewedTs = now
+	request.Note = note
+
+	err = saveReviewedModification(stub, request)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if status == modificationApproved && request.Structural {
+		car.NeedsInspection = false
+	}
+	car.Modification = nil
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Modification of car '%s' %s by '%s'\n", vin, status, officer)
+	requestAsBytes, _ := ledgerjson.Marshal(request)
+	return shim.Success(requestAsBytes)
+}
+
+/*
+ * Clears the inspection of a car whose structural
+ * modification was rejected and undone.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) clearInspection(stub shim.ChaincodeStubInterface, officer string, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !car.NeedsInspection {
+		return errorResponse(ErrInvalidState, "Car does not need an inspection")
+	} else if car.Modification != nil {
+		return errorResponse(ErrInvalidState, "A modification request of the car is pending. It has to be reviewed first")
+	}
+
+	car.NeedsInspection = false
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Inspection of car '%s' cleared by '%s'\n", vin, officer)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Returns the pending and all reviewed modification
+ * requests of a car, oldest first.
+ *
+ * Anybody can read the tuning register, like
+ * the part history.
+ *
+ * On success,
+ * returns the modification register.
+ */
+func (t *CarChaincode) getModifications(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(modificationObjectType, []string{vin})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading modification requests")
+	}
+	defer iterator.Close()
+
+	register := ModificationRegister{
+		Vin:             vin,
+		NeedsInspection: car.NeedsInspection,
+		Pending:         car.Modification,
+		Reviewed:        []ModificationRequest{}}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading modification requests")
+		}
+
+		request := ModificationRequest{}
+		err = ledgerjson.Unmarshal(kv.Value, &request)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing modification request")
+		}
+
+		register.Reviewed = append(register.Reviewed, request)
+	}
+
+	registerAsBytes, _ := ledgerjson.Marshal(register)
+	return shim.Success(registerAsBytes)
+}
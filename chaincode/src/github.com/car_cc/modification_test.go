@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestModificationApproval(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	parts := `["` + strings.Repeat("ab", 32) + `"]`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("requestModificationApproval", owner, "user", vin, "lowered suspension", `["coilovers.pdf"]`, "true"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestModificationApproval", "bobby", "user", vin, "lowered suspension", parts, "true"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke("2", util.ToChaincodeArgs("requestModificationApproval", owner, "user", vin, "lowered suspension", parts, "true"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if !car.NeedsInspection || car.Modification == nil || car.Modification.Status != modificationPending {
+		t.Fatalf("Expected a pending structural modification, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestModificationApproval", owner, "user", vin, "exhaust", parts))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// the unapproved modification blocks the confirmation
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectModification", "inspector", "dot", vin, ""))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectModification", "inspector", "dot", vin, "ground clearance too low"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("clearInspection", "inspector", "dot", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// approved structural modifications need no inspection
+	stub.MockInvoke("3", util.ToChaincodeArgs("requestModificationApproval", owner, "user", vin, "tow bar", parts, "true"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveModification", "inspector", "dot", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	if response.Status != shim.OK {
+		t.Error("Approved modification should not block the confirmation: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getModifications", "bobby", "user", vin))
+	register := ModificationRegister{}
+	json.Unmarshal(response.Payload, &register)
+	if register.Pending != nil || len(register.Reviewed) != 2 || register.Reviewed[0].Status != modificationRejected || register.Reviewed[1].Status != modificationApproved {
+		t.Errorf("Expected a rejected and an approved modification, got %s", response.Payload)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Odometer oracles.
+ *
+ * External data providers, like inspection stations or
+ * manufacturer telematics backends, push mileage readings
+ * with 'attestMileage'. The DOT registers every provider
+ * with the hash of its client identity and its MSP, which
+ * are recorded with each attestation.
+ *
+ * Readings have to grow with time. An attestation that
+ * contradicts an earlier one is kept, but flags the car
+ * with an odometer discrepancy until the DOT resolves it.
+ * Attestations are kept under
+ * 'odometer~<vin>~<observed>~<oracle identity>'.
+ */
+
+// object types of oracle and attestation keys
+const oracleObjectType string = "oracle"
+const odometerObjectType string = "odometer"
+
+// odometer status of a car with conflicting readings
+const odometerDiscrepancy string = "odometerDiscrepancy"
+
+/*
+ * Reads the oracle registered with identity hash 'identity'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func (t *CarChaincode) getOracle(stub shim.ChaincodeStubInterface, identity string) (*Oracle, error) {
+	key, err := stub.CreateCompositeKey(oracleObjectType, []string{identity})
+	if err != nil {
+		return nil, newError(ErrInternal, "Error creating oracle key")
+	}
+
+	oracleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading oracle")
+	} else if oracleAsBytes == nil {
+		return nil, nil
+	}
+
+	oracle := Oracle{}
+	err = ledgerjson.Unmarshal(oracleAsBytes, &oracle)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing oracle")
+	}
+
+	return &oracle, nil
+}
+
+/*
+ * Registers or deactivates a data provider.
+ *
+ * On success,
+ * returns the oracle.
+ */
+func (t *CarChaincode) registerOracle(stub shim.ChaincodeStubInterface, oracle Oracle) pb.Response {
+	if oracle.Name == "" || oracle.Msp == "" {
+		return errorResponse(ErrInvalidArgument, "'registerOracle' expects a name and an MSP id")
+	} else if len(oracle.Identity) != 64 {
+		return errorResponse(ErrInvalidArgument, "'registerOracle' expects the hex encoded sha256 hash of the oracle identity")
+	}
+
+	key, err := stub.CreateCompositeKey(oracleObjectType, []string{oracle.Identity})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating oracle key")
+	}
+
+	oracleAsBytes, _ := ledgerjson.Marshal(oracle)
+	err = stub.PutState(key, oracleAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing oracle")
+	}
+
+	return shim.Success(oracleAsBytes)
+}
+
+/*
+ * Checks if two readings contradict each other,
+ * i.e. the later one shows less mileage
+ */
+func readingsConflict(a *MileageAttestation, b *MileageAttestation) bool {
+	switch {
+	case a.ObservedTs < b.ObservedTs:
+		return a.Km > b.Km
+	case a.ObservedTs > b.ObservedTs:
+		return a.Km < b.Km
+	}
+
+	return a.Km != b.Km
+}
+
+/*
+ * Records a mileage reading of a registered oracle.
+ *
+ * On success,
+ * returns the attestation.
+ */
+func (t *CarChaincode) attestMileage(stub shim.ChaincodeStubInterface, vin string, attestation MileageAttestation) pb.Response {
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	oracle, err := t.getOracle(stub, caller)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if oracle == nil || !oracle.Active {
+		return errorResponse(ErrForbidden, "Forbidden: the invoker is no registered oracle")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if attestation.Km < 0 || attestation.ObservedTs <= 0 || attestation.ObservedTs > now {
+		return errorResponse(ErrInvalidArgument, "'attestMileage' expects a past reading of 0 km or more")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	attestation.Vin = vin
+	attestation.Oracle = oracle.Name
+	attestation.Identity = caller
+	attestation.Msp = oracle.Msp
+	attestation.RecordedTs = now
+
+	// compare with all earlier readings of the car
+	iterator, err := stub.GetStateByPartialCompositeKey(odometerObjectType, []string{vin})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading mileage attestations")
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading mileage attestations")
+		}
+
+		earlier := MileageAttestation{}
+		err = ledgerjson.Unmarshal(kv.Value, &earlier)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing mileage attestation")
+		}
+
+		if readingsConflict(&earlier, &attestation) {
+			attestation.Conflicts = true
+			car.Odometer.Status = odometerDiscrepancy
+			car.Odometer.Reason = fmt.Sprintf("%s read %d km at %d, %s read %d km at %d",
+				earlier.Oracle, earlier.Km, earlier.ObservedTs, attestation.Oracle, attestation.Km, attestation.ObservedTs)
+			break
+		}
+	}
+
+	if attestation.ObservedTs >= car.Odometer.ObservedTs {
+		car.Odometer.Km = attestation.Km
+		car.Odometer.ObservedTs = attestation.ObservedTs
+	}
+
+	key, err := stub.CreateCompositeKey(odometerObjectType, []string{vin, fmt.Sprintf("%012d", attestation.ObservedTs), caller})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating attestation key")
+	}
+
+	attestationAsBytes, _ := ledgerjson.Marshal(attestation)
+	err = stub.PutState(key, attestationAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing mileage attestation")
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(attestationAsBytes)
+}
+
+/*
+ * Clears the odometer discrepancy of a car
+ * after the DOT checked it.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) resolveOdometerDiscrepancy(stub shim.ChaincodeStubInterface, vin string, resolution string) pb.Response {
+	if resolution == "" {
+		return errorResponse(ErrInvalidArgument, "'resolveOdometerDiscrepancy' expects a non-empty resolution")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Odometer.Status != odometerDiscrepancy {
+		return errorResponse(ErrInvalidState, "The car has no odometer discrepancy")
+	}
+
+	car.Odometer.Status = ""
+	car.Odometer.Reason = resolution
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
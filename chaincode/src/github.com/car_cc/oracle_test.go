@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestOdometerAttestations(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	station := "InspectMSP station Regensdorf"
+	backend := "VWMSP telematics backend"
+	stationHash := sha256.Sum256([]byte(station))
+	backendHash := sha256.Sum256([]byte(backend))
+
+	now := time.Now().Unix()
+	ts := func(offset int64) string {
+		return strconv.FormatInt(now+offset, 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	// unregistered providers cannot attest
+	response := invokeAs(stub, station, "attestMileage", "station", "oracle", vin, "12000", ts(-3600))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "Regensdorf", hex.EncodeToString(stationHash[:]), "InspectMSP", "inspection", "true"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOracle", "inspector", "dot", "VW", hex.EncodeToString(backendHash[:]), "VWMSP", "manufacturer", "true"))
+
+	response = invokeAs(stub, station, "attestMileage", "station", "oracle", vin, "12000", ts(-3600))
+	attestation := MileageAttestation{}
+	err := json.Unmarshal(response.Payload, &attestation)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if attestation.Msp != "InspectMSP" || attestation.Identity != hex.EncodeToString(stationHash[:]) || attestation.Conflicts {
+		t.Errorf("Unexpected attestation: %v", attestation)
+	}
+
+	// a later reading with more mileage is fine
+	invokeAs(stub, backend, "attestMileage", "vw", "oracle", vin, "12500", ts(-1800))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("lookupCar", "bobby", "user", vin))
+	view := PublicCar{}
+	json.Unmarshal(response.Payload, &view)
+	if view.OdometerDiscrepancy {
+		t.Error("Consistent readings should not flag the car")
+	}
+
+	// a later reading with less mileage flags the car
+	response = invokeAs(stub, backend, "attestMileage", "vw", "oracle", vin, "9000", ts(-600))
+	attestation = MileageAttestation{}
+	json.Unmarshal(response.Payload, &attestation)
+	if !attestation.Conflicts {
+		t.Error("Rolled back reading should conflict")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Odometer.Status != odometerDiscrepancy {
+		t.Errorf("Car should show an odometer discrepancy, but is '%s'", car.Odometer.Status)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveOdometerDiscrepancy", "inspector", "dot", vin, "instrument cluster replaced"))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Odometer.Status != "" {
+		t.Error("DOT should have resolved the discrepancy")
+	}
+}
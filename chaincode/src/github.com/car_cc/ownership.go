@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Ownership links.
+ *
+ * The cars of a user are kept as one key per car under
+ * 'user~<pseudonym>~<vin>' instead of a list in the user,
+ * so a garage creating many cars in parallel does not
+ * rewrite the same user record in every transaction.
+ * 'User.Cars' is derived from the links when a user is
+ * read, see 'getUserCars'. Owners are named by their
+ * pseudonym, see 'pseudonymOf'.
+ */
+
+// object type of ownership keys
+const ownershipObjectType string = "user"
+
+// value of an ownership key, empty values would delete the key
+var ownershipValue = []byte{0x00}
+
+/*
+ * Returns the ledger key linking car 'vin' to 'username'
+ */
+func getOwnershipKey(stub shim.ChaincodeStubInterface, username string, vin string) (string, error) {
+	pseudonym, err := pseudonymOf(stub, username)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := stub.CreateCompositeKey(ownershipObjectType, []string{pseudonym, vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating ownership key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Links car 'vin' to its owner 'username'
+ */
+func addOwnership(stub shim.ChaincodeStubInterface, username string, vin string) error {
+	key, err := getOwnershipKey(stub, username, vin)
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutState(key, ownershipValue)
+	if err != nil {
+		return newError(ErrLedger, fmt.Sprintf("Error linking car '%s' to user '%s'", vin, username))
+	}
+
+	return nil
+}
+
+/*
+ * Removes the link of car 'vin' to its former owner 'username'
+ */
+func removeOwnership(stub shim.ChaincodeStubInterface, username string, vin string) error {
+	key, err := getOwnershipKey(stub, username, vin)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return newError(ErrLedger, fmt.Sprintf("Error unlinking car '%s' from user '%s'", vin, username))
+	}
+
+	return nil
+}
+
+/*
+ * Returns the VINs of the cars of 'username', sorted
+ */
+func getUserCars(stub shim.ChaincodeStubInterface, username string) ([]string, error) {
+	pseudonym, err := pseudonymOf(stub, username)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(ownershipObjectType, []string{pseudonym})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading cars of user")
+	}
+	defer iterator.Close()
+
+	cars := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading cars of user")
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 2 {
+			return nil, newError(ErrLedger, "Error parsing ownership key")
+		}
+		cars = append(cars, attributes[1])
+	}
+
+	return cars, nil
+}
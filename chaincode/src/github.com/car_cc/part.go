@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Parts provenance.
+ *
+ * Major components carry serial numbers. Every serial is
+ * bound to one car at a time under 'part~<serial>', so a
+ * part cannot be installed in two cars. Every replacement is
+ * kept under 'partHistory~<vin>~<ts>~<type>~<serial>', so
+ * buyers can spot engine swaps and parts from other cars.
+ */
+
+// object types of part keys
+const partObjectType string = "part"
+const partHistoryObjectType string = "partHistory"
+
+// tracked part types
+const (
+	partEngine  string = "engine"
+	partGearbox string = "gearbox"
+	partBattery string = "battery"
+)
+
+var partTypes = []string{partEngine, partGearbox, partBattery}
+
+/*
+ * Returns the ledger key of the part with serial 'serial'
+ */
+func getPartKey(stub shim.ChaincodeStubInterface, serial string) (string, error) {
+	key, err := stub.CreateCompositeKey(partObjectType, []string{serial})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating part key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the part with serial 'serial'.
+ *
+ * Returns 'nil' if the serial was never installed.
+ */
+func (t *CarChaincode) getPart(stub shim.ChaincodeStubInterface, serial string) (*Part, error) {
+	key, err := getPartKey(stub, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	partAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading part")
+	} else if partAsBytes == nil {
+		return nil, nil
+	}
+
+	part := Part{}
+	err = ledgerjson.Unmarshal(partAsBytes, &part)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing part")
+	}
+
+	return &part, nil
+}
+
+/*
+ * Writes a part to ledger
+ */
+func (t *CarChaincode) savePart(stub shim.ChaincodeStubInterface, part *Part) error {
+	key, err := getPartKey(stub, part.Serial)
+	if err != nil {
+		return err
+	}
+
+	partAsBytes, _ := ledgerjson.Marshal(part)
+	err = stub.PutState(key, partAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing part")
+	}
+
+	return nil
+}
+
+/*
+ * Installs the part with serial 'serial' as 'partType'
+ * in the car of 'owner', replacing the current part.
+ *
+ * Garages replace parts in their own cars, or in their
+ * customers' cars with a 'service' mandate.
+ *
+ * On success,
+ * returns the replacement.
+ */
+func (t *CarChaincode) replacePart(stub shim.ChaincodeStubInterface, owner string, garage string, vin string, partType string, serial string) pb.Response {
+	if !containsString(partTypes, partType) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'replacePart' expects one of the part types %s", strings.Join(partTypes, ", ")))
+	} else if serial == "" {
+		return errorResponse(ErrInvalidArgument, "'replacePart' expects a non-empty serial number")
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	replacement := PartReplacement{
+		Vin:      vin,
+		Type:     partType,
+		Serial:   serial,
+		Previous: car.Parts[partType],
+		Garage:   garage,
+		Ts:       now}
+
+	part, err := t.getPart(stub, serial)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if part != nil && part.RemovedTs == 0 {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Part '%s' is installed in car with VIN '%s'", serial, part.Vin))
+	} else if part != nil && part.Type != partType {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Part '%s' is a %s, not a %s", serial, part.Type, partType))
+	} else if part != nil && part.Vin != vin {
+		// used part, taken from another car
+		replacement.PreviousVin = part.Vin
+	}
+
+	if replacement.Previous != "" {
+		previous, err := t.getPart(stub, replacement.Previous)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if previous != nil {
+			previous.RemovedTs = now
+			err = t.savePart(stub, previous)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+		}
+	}
+
+	err = t.savePart(stub, &Part{
+		Serial:      serial,
+		Type:        partType,
+		Vin:         vin,
+		InstalledTs: now,
+		InstalledBy: garage})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := stub.CreateCompositeKey(partHistoryObjectType, []string{vin, fmt.Sprintf("%012d", now), partType, serial})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating part history key")
+	}
+
+	replacementAsBytes, _ := ledgerjson.Marshal(replacement)
+	err = stub.PutState(key, replacementAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing part replacement")
+	}
+
+	if car.Parts == nil {
+		car.Parts = make(map[string]string)
+	}
+	car.Parts[partType] = serial
+
+	// a new engine or battery ends the historic classification
+	if IsHistoric(&car) && containsString(historicModifications, partType) {
+		declassify(&car, garage, fmt.Sprintf("%s replaced with '%s'", partType, serial), now)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Garage '%s' installed %s '%s' in car '%s'\n", garage, partType, serial, vin)
+
+	return shim.Success(replacementAsBytes)
+}
+
+/*
+ * Reads the part replacements of car 'vin', oldest first
+ */
+func readPartReplacements(stub shim.ChaincodeStubInterface, vin string) ([]PartReplacement, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(partHistoryObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading part history")
+	}
+	defer iterator.Close()
+
+	replacements := []PartReplacement{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading part history")
+		}
+
+		replacement := PartReplacement{}
+		err = ledgerjson.Unmarshal(kv.Value, &replacement)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing part replacement")
+		}
+
+		replacements = append(replacements, replacement)
+	}
+
+	return replacements, nil
+}
+
+/*
+ * Returns the installed parts and all part
+ * replacements of a car, oldest first.
+ *
+ * Anybody can read the part history, so buyers
+ * can check a car before they buy it.
+ *
+ * On success,
+ * returns the part history.
+ */
+func (t *CarChaincode) getPartHistory(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	replacements, err := readPartReplacements(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	history := PartHistory{Vin: vin, Parts: car.Parts, Replacements: replacements}
+	historyAsBytes, _ := ledgerjson.Marshal(history)
+	return shim.Success(historyAsBytes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestPartHistory(t *testing.T) {
+	owner := "amag"
+	garage := "repairshop"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+	expiry := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+otherVin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partEngine, "E-1"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("grantMandate", owner, "user", vin, garage, "service", expiry))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, "wheel", "W-1"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partEngine, "E-1"))
+	if response.Status != shim.OK {
+		t.Fatal("Mandated garage should be able to replace parts: " + response.Message)
+	}
+
+	// a part cannot be in two cars at once
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", owner, "garage", otherVin, partEngine, "E-1"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	// engine swap, then the old engine goes into the other car
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", garage, "garage", vin, partEngine, "E-2"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", owner, "garage", otherVin, partEngine, "E-1"))
+	replacement := PartReplacement{}
+	json.Unmarshal(response.Payload, &replacement)
+	if replacement.PreviousVin != vin {
+		t.Errorf("Used engine should point to the car it came from: %v", replacement)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPartHistory", "bobby", "user", vin))
+	history := PartHistory{}
+	err := json.Unmarshal(response.Payload, &history)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if history.Parts[partEngine] != "E-2" || len(history.Replacements) != 2 || history.Replacements[1].Previous != "E-1" {
+		t.Errorf("Unexpected part history: %v", history)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// day format of trip permits
+const permitDateLayout string = "2006-01-02"
+
+/*
+ * Checks if a car has an unused trip permit for day 'day'.
+ *
+ * 'day' is formatted like '2017-06-30'.
+ */
+func IsPermitValid(car *Car, day string) bool {
+	if car.Permit.ValidOn == "" || car.Permit.Consumed {
+		return false
+	}
+
+	return car.Permit.ValidOn == day
+}
+
+/*
+ * Marks the trip permit of a car as consumed.
+ *
+ * Called whenever an inspection is recorded, the car
+ * made it to the inspection station by then.
+ */
+func consumePermit(car *Car) {
+	if car.Permit.ValidOn != "" && !car.Permit.Consumed {
+		fmt.Printf("Consumed trip permit of car with VIN '%s'\n", car.Vin)
+		car.Permit.Consumed = true
+	}
+}
+
+/*
+ * Issues a temporary permit to drive a car
+ * without numberplate to the inspection station.
+ *
+ * Permits are issued for unregistered or salvage cars only
+ * and are valid for a single day. A new permit replaces
+ * the old one. Garages need a current 'inspection'
+ * certification to issue them, see 'certifyGarage'.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Day of the trip             (string, '2017-06-30')
+ * [2] Route reference             (string)
+ *
+ * On success,
+ * returns the car with permit.
+ */
+func (t *CarChaincode) issuePermit(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+	validOn := args[1]
+	route := args[2]
+
+	if route == "" {
+		return errorResponse(ErrInvalidArgument, "'issuePermit' expects a non-empty route reference")
+	}
+
+	day, err := time.Parse(permitDateLayout, validOn)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'issuePermit' expects the day of the trip formatted as '"+permitDateLayout+"'")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	today := now.Format(permitDateLayout)
+	if day.Format(permitDateLayout) < today {
+		return errorResponse(ErrInvalidArgument, "Cannot issue a trip permit for a day in the past")
+	}
+
+	// the issuer is not necessarily the car owner,
+	// look up the owner in the car index
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// only cars on the way to an inspection need a permit
+	if IsRegistered(&car) && car.Classification != classSalvage {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car with VIN '%s' does not need a trip permit", vin))
+	}
+
+	previousTs := int64(0)
+	if car.Permit.ValidOn != "" {
+		previousTs = permitExpiry(&car.Permit)
+	}
+
+	car.Permit = TripPermit{
+		IssuedBy: username,
+		ValidOn:  validOn,
+		Route:    route}
+
+	err = updateExpiry(stub, expiryPermit, vin, previousTs, permitExpiry(&car.Permit))
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Issued trip permit for car with VIN '%s' on '%s'\n", vin, validOn)
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Roadside check of a car by the police.
+ *
+ * Returns the status of the car together with
+ * its trip permit and transit permit, if any.
+ */
+func (t *CarChaincode) policeLookup(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	today := now.Format(permitDateLayout)
+	lookup := PoliceLookup{
+		Vin:            car.Vin,
+		Numberplate:    car.Certificate.Numberplate,
+		Registered:     IsRegistered(&car),
+		Insured:        IsInsured(&car, now.Unix()),
+		Confirmed:      IsConfirmed(&car, now.Unix()),
+		Classification: car.Classification,
+		Permit:         car.Permit,
+		PermitValid:    IsPermitValid(&car, today),
+		Seized:         IsSeized(&car)}
+
+	permit, err := getTransitPermit(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsTransitPermitValid(permit, now.Unix()) {
+		lookup.TransitPermit = permit
+	}
+
+	lookupAsBytes, _ := ledgerjson.Marshal(lookup)
+	return shim.Success(lookupAsBytes)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Personal data.
+ *
+ * Address, phone and national ID of a user are kept in
+ * the private data collection 'personalData', keyed by
+ * username, and are passed in the transient field
+ * 'personalData', so they never end up in a block.
+ * The public state only knows the username, which stays
+ * as a pseudonym in the car history after the personal
+ * data was erased with 'forgetUser'.
+ *
+ * See 'fixtures/collections_config.json'.
+ */
+
+// private data collection of personal data
+const personalDataCollection string = "personalData"
+
+// transient field holding the personal data
+const personalDataTransient string = "personalData"
+
+/*
+ * Reads the personal data of 'username'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getPersonalData(stub shim.ChaincodeStubInterface, username string) (*PersonalData, error) {
+	dataAsBytes, err := stub.GetPrivateData(personalDataCollection, username)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading personal data")
+	} else if dataAsBytes == nil {
+		return nil, nil
+	}
+
+	data := PersonalData{}
+	err = ledgerjson.Unmarshal(dataAsBytes, &data)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing personal data")
+	}
+
+	return &data, nil
+}
+
+/*
+ * Erases the personal data of 'username'
+ */
+func erasePersonalData(stub shim.ChaincodeStubInterface, username string) error {
+	err := stub.DelPrivateData(personalDataCollection, username)
+	if err != nil {
+		return newError(ErrLedger, "Error erasing personal data")
+	}
+
+	return nil
+}
+
+/*
+ * Stores the personal data of the invoker, passed
+ * as JSON in the transient field 'personalData'.
+ *
+ * On success,
+ * returns 'nil'.
+ */
+func (t *CarChaincode) setPersonalData(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	_, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading transient data")
+	}
+
+	dataAsBytes, ok := transient[personalDataTransient]
+	if !ok {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'setPersonalData' expects the personal data in the transient field '%s'", personalDataTransient))
+	}
+
+	data := PersonalData{}
+	err = ledgerjson.Unmarshal(dataAsBytes, &data)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing personal data")
+	}
+
+	data.UpdatedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	dataAsBytes, _ = ledgerjson.Marshal(data)
+	err = stub.PutPrivateData(personalDataCollection, username, dataAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing personal data")
+	}
+
+	fmt.Printf("Stored personal data of user '%s'\n", username)
+	return shim.Success(nil)
+}
+
+/*
+ * Reads the personal data of the invoker.
+ *
+ * On success,
+ * returns the personal data.
+ */
+func (t *CarChaincode) readPersonalData(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	data, err := getPersonalData(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if data == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no personal data of user '%s'", username))
+	}
+
+	dataAsBytes, _ := ledgerjson.Marshal(data)
+	return shim.Success(dataAsBytes)
+}
+
+/*
+ * Erases the personal data and the profile of a user.
+ *
+ * The user and its cars stay, the username remains
+ * as a pseudonym in the ledger history.
+ *
+ * On success,
+ * returns the user.
+ */
+func (t *CarChaincode) forgetUser(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = erasePersonalData(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = eraseProfile(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	user.ForgottenTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.saveUser(stub, user)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Erased personal data of user '%s'\n", username)
+
+	userAsBytes, _ := ledgerjson.Marshal(user)
+	return shim.Success(userAsBytes)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestForgetUser(t *testing.T) {
+	username := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "amag", "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", username, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", "amag", "garage", vin, username))
+
+	// personal data is only accepted as transient data
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("setPersonalData", username, "user"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.TransientMap = map[string][]byte{personalDataTransient: []byte(`{ "address": "Bahnhofstrasse 1, Zurich", "national_id": "756.1234.5678.97" }`)}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setPersonalData", username, "user"))
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readPersonalData", username, "user"))
+	data := PersonalData{}
+	json.Unmarshal(response.Payload, &data)
+	if data.NationalId != "756.1234.5678.97" {
+		t.Errorf("Unexpected personal data: %v", data)
+	}
+
+	// the public state holds no personal data
+	userAsBytes, _ := stub.GetState("usr_" + username)
+	if strings.Contains(string(userAsBytes), data.NationalId) {
+		t.Error("Personal data should not be in the public state")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("forgetUser", username, "user", username))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("forgetUser", "admin", "admin", username))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.ForgottenTs == 0 {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readPersonalData", username, "user"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// the car stays with the pseudonymous user
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Numberplate reservations.
+ *
+ * Users can reserve a personalized numberplate for a fee.
+ * Until the reservation expires, only they can get the
+ * plate assigned at 'confirm'. Reservations are kept
+ * under 'plate~<numberplate>'.
+ */
+
+// object type of plate reservation keys
+const plateObjectType string = "plate"
+
+// fee deducted from the balance for a reservation,
+// unless the fee schedule sets 'plate_reservation'
+const plateReservationFee int = 20
+const plateReservationFeeName string = "plate_reservation"
+
+// days a reservation holds
+const plateReservationDays int = 90
+
+/*
+ * Returns the ledger key of the reservation of 'numberplate'
+ */
+func getPlateKey(stub shim.ChaincodeStubInterface, numberplate string) (string, error) {
+	key, err := stub.CreateCompositeKey(plateObjectType, []string{numberplate})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating numberplate key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Checks a numberplate against the format of its jurisdiction.
+ *
+ * The jurisdiction is the plate prefix up to the first space
+ * ('ZH' for 'ZH 7878'). Plates of jurisdictions without an own
+ * format are checked against the default format, stored under
+ * the empty jurisdiction. Without any formats configured, every
+ * non-empty plate is accepted.
+ */
+func validatePlate(config Config, numberplate string) error {
+	if numberplate == "" {
+		return newError(ErrInvalidArgument, "Numberplate is empty")
+	} else if len(config.PlateFormats) == 0 {
+		return nil
+	}
+
+	jurisdiction := plateJurisdiction(numberplate)
+	format, ok := config.PlateFormats[jurisdiction]
+	if !ok {
+		format, ok = config.PlateFormats[""]
+	}
+	if !ok {
+		return newErrorWithDetails(ErrInvalidArgument, fmt.Sprintf("Unknown numberplate jurisdiction '%s'", jurisdiction), map[string]string{"numberplate": numberplate})
+	}
+
+	// formats are checked when set, see 'setPlateFormat'
+	matched, _ := regexp.MatchString("^(?:"+format+")$", numberplate)
+	if !matched {
+		return newErrorWithDetails(ErrInvalidArgument, fmt.Sprintf("Numberplate '%s' does not match the format of jurisdiction '%s'", numberplate, jurisdiction), map[string]string{"numberplate": numberplate})
+	}
+
+	return nil
+}
+
+/*
+ * Reads the reservation of 'numberplate'.
+ *
+ * Returns 'nil' if the plate was never reserved.
+ */
+func (t *CarChaincode) getPlateReservation(stub shim.ChaincodeStubInterface, numberplate string) (*PlateReservation, error) {
+	key, err := getPlateKey(stub, numberplate)
+	if err != nil {
+		return nil, err
+	}
+
+	reservationAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading numberplate reservation")
+	} else if reservationAsBytes == nil {
+		return nil, nil
+	}
+
+	reservation := PlateReservation{}
+	err = ledgerjson.Unmarshal(reservationAsBytes, &reservation)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing numberplate reservation")
+	}
+
+	return &reservation, nil
+}
+
+/*
+ * Checks if a car already carries 'numberplate'.
+ *
+ * Walks the car index in VIN order, so all peers
+ * fail on the same car if something goes wrong.
+ */
+func (t *CarChaincode) isPlateInUse(stub shim.ChaincodeStubInterface, numberplate string) (bool, error) {
+	carIndex, err := t.getCarIndex(stub)
+	if err != nil {
+		return false, newError(ErrLedger, "Error reading car index")
+	}
+
+	for _, carVin := range sortedKeys(carIndex) {
+		owner, err := resolveOwner(stub, carIndex[carVin])
+		if err != nil {
+			return false, err
+		}
+
+		// get the full car object with certificate
+		carToCheck, err := t.getCar(stub, owner, carVin)
+		if err != nil {
+			return false, newError(ErrCarNotFound, "Failed to fetch car with vin '"+carVin+"' from ledger")
+		}
+
+		if carToCheck.Certificate.Numberplate == numberplate {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+ * Checks that 'username' may get 'numberplate' assigned.
+ *
+ * Returns the reservation of 'username' for the plate,
+ * which the caller has to release, or 'nil'.
+ */
+func (t *CarChaincode) checkPlateAvailable(stub shim.ChaincodeStubInterface, username string, numberplate string) (*PlateReservation, error) {
+	inUse, err := t.isPlateInUse(stub, numberplate)
+	if err != nil {
+		return nil, err
+	} else if inUse {
+		return nil, newError(ErrNumberplateInUse, "Car numberplate already in use. Please use another one!")
+	}
+
+	reservation, err := t.getPlateReservation(stub, numberplate)
+	if err != nil || reservation == nil {
+		return nil, err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// expired reservations no longer block the plate
+	if reservation.ExpiresTs <= now {
+		return nil, nil
+	} else if reservation.Username != username {
+		return nil, newError(ErrNumberplateInUse, fmt.Sprintf("Numberplate '%s' is reserved by another user", numberplate))
+	}
+
+	return reservation, nil
+}
+
+/*
+ * Removes the reservation of 'numberplate'
+ */
+func (t *CarChaincode) releasePlate(stub shim.ChaincodeStubInterface, numberplate string) error {
+	key, err := getPlateKey(stub, numberplate)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return newError(ErrLedger, "Error deleting numberplate reservation")
+	}
+
+	return nil
+}
+
+/*
+ * Reserves a personalized numberplate for 'username'.
+ *
+ * The reservation fee is deducted from the user balance.
+ * While the reservation holds, only 'username' can get the
+ * plate assigned when one of their cars is confirmed.
+ *
+ * On success,
+ * returns the reservation.
+ */
+func (t *CarChaincode) reservePlate(stub shim.ChaincodeStubInterface, username string, numberplate string) pb.Response {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = validatePlate(config, numberplate)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reservation, err := t.checkPlateAvailable(stub, username, numberplate)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if reservation != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("You already reserved numberplate '%s'", numberplate))
+	}
+
+	fee := configFee(config, plateReservationFeeName, plateReservationFee)
+	err = t.chargeFee(stub, username, plateReservationFeeName, fee)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reservation = &PlateReservation{
+		Numberplate: numberplate,
+		Username:    username,
+		ReservedTs:  now,
+		ExpiresTs:   now + int64(plateReservationDays)*secondsPerDay,
+		Fee:         fee}
+
+	key, err := getPlateKey(stub, numberplate)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reservationAsBytes, _ := ledgerjson.Marshal(reservation)
+	err = stub.PutState(key, reservationAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing numberplate reservation")
+	}
+
+	return shim.Success(reservationAsBytes)
+}
+
+/*
+ * Sets the numberplate format of a jurisdiction.
+ *
+ * Arguments required:
+ * [0] Jurisdiction                (string)
+ *     empty for the default format
+ * [1] Format                      (regular expression)
+ *     empty to remove the format
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setPlateFormat(stub shim.ChaincodeStubInterface, jurisdiction string, format string) pb.Response {
+	if format != "" {
+		_, err := regexp.Compile("^(?:" + format + ")$")
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "'setPlateFormat' expects a valid regular expression: "+err.Error())
+		}
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if config.PlateFormats == nil {
+		config.PlateFormats = make(map[string]string)
+	}
+
+	if format == "" {
+		delete(config.PlateFormats, jurisdiction)
+	} else {
+		config.PlateFormats[jurisdiction] = format
+	}
+
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReservePlate(t *testing.T) {
+	owner := "amag"
+	other := "emil"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	insureCar(t, stub, other, otherVin, "axa")
+
+	// Zurich plates have up to six digits
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setPlateFormat", "inspector", "dot", "ZH", `ZH [1-9][0-9]{0,5}`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", owner, "user", "ZH 0815"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", owner, "user", "BE 12"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// the fee is deducted from the balance
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", owner, "user", "ZH 7"))
+	reservation := PlateReservation{}
+	err := json.Unmarshal(response.Payload, &reservation)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if reservation.Username != owner || reservation.ExpiresTs <= reservation.ReservedTs {
+		t.Errorf("Unexpected reservation: %v", reservation)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", owner, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 100-plateReservationFee {
+		t.Errorf("Balance should be %d after the reservation, but is %d", 100-plateReservationFee, user.Balance)
+	}
+
+	// nobody else gets the reserved plate
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", other, "user", "ZH 7"))
+	expectErrorCode(t, response, ErrNumberplateInUse)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", other, "dot", otherVin, "ZH 7"))
+	expectErrorCode(t, response, ErrNumberplateInUse)
+
+	// but the owner does
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "ZH 7" {
+		t.Errorf("Car should carry the reserved plate, but has '%s'", car.Certificate.Numberplate)
+	}
+
+	// now the plate is in use
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reservePlate", other, "user", "ZH 7"))
+	expectErrorCode(t, response, ErrNumberplateInUse)
+}
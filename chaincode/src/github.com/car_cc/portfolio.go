@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// default number of policies moved per transaction
+const defaultPortfolioChunk int = 50
+
+// object type of moved policy records
+const policyMovedObjectType string = "policyMoved"
+
+/*
+ * A single policy moved to another insurer
+ */
+type PolicyMoved struct {
+	Car      string `json:"car"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	OrderRef string `json:"order_ref"`
+	MovedTs  int64  `json:"moved_ts"`
+	TxId     string `json:"tx_id"` // transaction of the chunk that moved the policy
+}
+
+/*
+ * Returns the portfolio transfer index
+ */
+func (t *CarChaincode) getPortfolioTransferIndex(stub shim.ChaincodeStubInterface) (map[string]PortfolioTransfer, error) {
+	response := t.read(stub, portfolioTransferIndexStr)
+	transferIndex := make(map[string]PortfolioTransfer)
+	err := ledgerjson.Unmarshal(response.Payload, &transferIndex)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing portfolio transfer index")
+	}
+
+	return transferIndex, nil
+}
+
+/*
+ * Moves the policies of an insolvent insurer to another insurer.
+ *
+ * A large portfolio does not fit into a single transaction,
+ * so at most 'chunk size' policies are moved per call. The
+ * regulator repeats the call with the same order reference
+ * until no policies remain. Apart from the insurer, the
+ * policies are left untouched.
+ *
+ * Open insurance proposals and open claims move along with
+ * the first chunk.
+ *
+ * Every moved policy is recorded under
+ * 'policyMoved~<order reference>~<vin>'. Fabric only keeps
+ * one event per transaction, so 'policiesTransferred' carries
+ * the list of the policies moved by the chunk, listeners that
+ * missed it read the records with 'getMovedPolicies'.
+ *
+ * Arguments required:
+ * [0] Failed insurer              (string)
+ * [1] Receiving insurer           (string)
+ * [2] Regulatory order reference  (string)
+ * [3] (optional) Chunk size       (int)
+ *
+ * On success,
+ * returns the portfolio transfer record.
+ */
+func (t *CarChaincode) transferPortfolio(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	from := args[0]
+	to := args[1]
+	orderRef := args[2]
+	chunk := defaultPortfolioChunk
+	if len(args) > 3 {
+		var err error
+		chunk, err = strconv.Atoi(args[3])
+		if err != nil || chunk < 1 {
+			return errorResponse(ErrInvalidArgument, "'transferPortfolio' expects a positive chunk size")
+		}
+	}
+
+	if from == "" || to == "" || orderRef == "" {
+		return errorResponse(ErrInvalidArgument, "'transferPortfolio' expects non-empty insurers and order reference")
+	} else if from == to {
+		return errorResponse(ErrInvalidArgument, "Cannot transfer a portfolio to the same insurer")
+	}
+
+	// the portfolio goes to an accredited insurer only
+	err := requireAccreditedInsurer(stub, to)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	transferIndex, err := t.getPortfolioTransferIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// continue a running order or start a new one
+	transfer, running := transferIndex[orderRef]
+	if !running {
+		transfer = PortfolioTransfer{OrderRef: orderRef, From: from, To: to, Cars: []string{}}
+	} else if transfer.From != from || transfer.To != to {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Order '%s' moves policies from '%s' to '%s'", orderRef, transfer.From, transfer.To))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the book of the insurer is in VIN order,
+	// so every chunk is the same on all peers
+	cars := []*Car{}
+	remaining := 0
+	err = forEachInsuredCar(stub, from, func(car *Car) bool {
+		if len(cars) == chunk {
+			remaining++
+		} else {
+			cars = append(cars, car)
+		}
+		return true
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	moved := []PolicyMoved{}
+	for _, car := range cars {
+		err = updateInsuredIndex(stub, car.Vin, from, to)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		car.Certificate.Insurer = to
+		carAsBytes, _ := ledgerjson.Marshal(car)
+		err = stub.PutState(car.Vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+
+		policy := PolicyMoved{Car: car.Vin, From: from, To: to, OrderRef: orderRef, MovedTs: now, TxId: stub.GetTxID()}
+		err = recordPolicyMoved(stub, policy)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		moved = append(moved, policy)
+		transfer.Cars = append(transfer.Cars, car.Vin)
+	}
+
+	if !running {
+		err = t.moveInsuranceProposals(stub, from, to)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		err = t.moveOpenClaims(stub, from, to)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	// record the progress of the regulatory order
+	transfer.Remaining = remaining
+	transfer.UpdatedTs = now
+
+	transferIndex[orderRef] = transfer
+	indexAsBytes, _ := ledgerjson.Marshal(transferIndex)
+	err = stub.PutState(portfolioTransferIndexStr, indexAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing portfolio transfer index")
+	}
+
+	movedAsBytes, _ := ledgerjson.Marshal(moved)
+	err = stub.SetEvent("policiesTransferred", movedAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting policy transfer event")
+	}
+
+	fmt.Printf("Moved %d policies from '%s' to '%s', %d remaining\n", len(moved), from, to, remaining)
+
+	transferAsBytes, _ := ledgerjson.Marshal(transfer)
+	return shim.Success(transferAsBytes)
+}
+
+/*
+ * Records that a policy moved under a regulatory order
+ */
+func recordPolicyMoved(stub shim.ChaincodeStubInterface, policy PolicyMoved) error {
+	key, err := stub.CreateCompositeKey(policyMovedObjectType, []string{policy.OrderRef, policy.Car})
+	if err != nil {
+		return newError(ErrInternal, "Error creating moved policy key")
+	}
+
+	policyAsBytes, _ := ledgerjson.Marshal(policy)
+	err = stub.PutState(key, policyAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing moved policy")
+	}
+
+	return nil
+}
+
+/*
+ * Returns the policies moved under regulatory order
+ * 'orderRef', to the regulator and both insurers.
+ *
+ * On success,
+ * returns the moved policies in VIN order.
+ */
+func (t *CarChaincode) getMovedPolicies(stub shim.ChaincodeStubInterface, username string, role string, orderRef string) pb.Response {
+	transferIndex, err := t.getPortfolioTransferIndex(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	transfer, ok := transferIndex[orderRef]
+	if !ok {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no portfolio transfer under order '%s'", orderRef))
+	} else if role != "regulator" && username != transfer.From && username != transfer.To {
+		return errorResponse(ErrForbidden, "Forbidden: only the regulator and the insurers of the order can read the moved policies")
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(policyMovedObjectType, []string{orderRef})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading moved policies")
+	}
+	defer iterator.Close()
+
+	policies := []PolicyMoved{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading moved policies")
+		}
+
+		policy := PolicyMoved{}
+		err = ledgerjson.Unmarshal(kv.Value, &policy)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing moved policy")
+		}
+
+		policies = append(policies, policy)
+	}
+
+	policiesAsBytes, _ := ledgerjson.Marshal(policies)
+	return shim.Success(policiesAsBytes)
+}
+
+/*
+ * Hands the open insurance proposals of insurer 'from'
+ * over to insurer 'to'.
+ */
+func (t *CarChaincode) moveInsuranceProposals(stub shim.ChaincodeStubInterface, from string, to string) error {
+	insurerIndex, err := t.getInsurerIndex(stub)
+	if err != nil {
+		return err
+	}
+
+	failed, ok := insurerIndex[from]
+	if !ok || len(failed.Proposals) == 0 {
+		return nil
+	}
+
+	receiver, ok := insurerIndex[to]
+	if !ok {
+		receiver = Insurer{Name: to}
+	}
+
+	receiver.Proposals = append(receiver.Proposals, failed.Proposals...)
+	failed.Proposals = []InsureProposal{}
+	insurerIndex[from] = failed
+	insurerIndex[to] = receiver
+
+	indexAsBytes, _ := ledgerjson.Marshal(insurerIndex)
+	err = stub.PutState(insurerIndexStr, indexAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing insurer index")
+	}
+
+	return nil
+}
+
+/*
+ * Hands the claims of insurer 'from' that are not
+ * yet closed over to insurer 'to'.
+ */
+func (t *CarChaincode) moveOpenClaims(stub shim.ChaincodeStubInterface, from string, to string) error {
+	claims, err := t.findClaims(stub, func(claim *Claim) bool {
+		return claim.Insurer == from && (claim.Status == claimFiled || claim.Status == claimApproved)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, claim := range claims {
+		claim.Insurer = to
+		err = t.saveClaim(stub, claim)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
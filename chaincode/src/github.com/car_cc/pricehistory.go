@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Price history.
+ *
+ * Every change of ownership appends a record to
+ * 'price~<vin>~<ts>~<txid>' with the price paid, 0 for
+ * transfers, inheritances, seizures and reversals. A sale passing a salt in the
+ * transient field 'priceSalt' keeps its price private, the
+ * record only holds the hex encoded sha256 of the salt
+ * followed by the price in decimal, so the parties can
+ * prove the price later.
+ *
+ * Public prices of sales also add to 'pricestats~<brand>~<model>',
+ * which 'averagePriceByModel' reads for market transparency.
+ */
+
+// object type of price records
+const priceObjectType string = "price"
+
+// object type of price statistics by model
+const priceStatsObjectType string = "pricestats"
+
+// transient field holding the salt of a private price
+const priceSaltTransient string = "priceSalt"
+
+// how the owner changed
+const priceKindSale string = "sale"
+const priceKindInstallments string = "installments"
+const priceKindTransfer string = "transfer"
+const priceKindInheritance string = "inheritance"
+const priceKindSeizure string = "seizure"
+const priceKindReversal string = "reversal"
+
+/*
+ * Returns the hash of a private price
+ */
+func hashPrice(salt []byte, price int) string {
+	hash := sha256.Sum256(append(salt, []byte(strconv.Itoa(price))...))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Returns the ledger key of the price statistics of a model
+ */
+func getPriceStatsKey(stub shim.ChaincodeStubInterface, brand string, model string) (string, error) {
+	key, err := stub.CreateCompositeKey(priceStatsObjectType, []string{brand, model})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating price statistics key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the price statistics of a model.
+ *
+ * Returns 'nil' if no public sale was recorded yet.
+ */
+func getPriceStats(stub shim.ChaincodeStubInterface, brand string, model string) (*ModelPriceStats, error) {
+	key, err := getPriceStatsKey(stub, brand, model)
+	if err != nil {
+		return nil, err
+	}
+
+	statsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading price statistics")
+	} else if statsAsBytes == nil {
+		return nil, nil
+	}
+
+	stats := ModelPriceStats{}
+	err = ledgerjson.Unmarshal(statsAsBytes, &stats)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing price statistics")
+	}
+
+	return &stats, nil
+}
+
+/*
+ * Adds public sale prices to the statistics of their model
+ */
+func addToPriceStats(stub shim.ChaincodeStubInterface, brand string, model string, prices ...int) error {
+	stats, err := getPriceStats(stub, brand, model)
+	if err != nil {
+		return err
+	} else if stats == nil {
+		stats = &ModelPriceStats{Brand: brand, Model: model}
+	}
+
+	for _, price := range prices {
+		stats.Sales++
+		stats.Total += price
+	}
+	stats.Average = stats.Total / stats.Sales
+
+	key, err := getPriceStatsKey(stub, brand, model)
+	if err != nil {
+		return err
+	}
+
+	statsAsBytes, _ := ledgerjson.Marshal(stats)
+	err = stub.PutState(key, statsAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing price statistics")
+	}
+
+	return nil
+}
+
+/*
+ * Appends the price 'car' changed its owner for
+ * to its price history
+ */
+func recordPrice(stub shim.ChaincodeStubInterface, car *Car, kind string, price int) error {
+	record, err := writePriceRecord(stub, car, kind, price)
+	if err != nil || !isPublicPrice(record) {
+		return err
+	}
+
+	return addToPriceStats(stub, record.Brand, record.Model, price)
+}
+
+/*
+ * Checks if 'record' is a public sale price,
+ * which counts in the statistics of its model
+ */
+func isPublicPrice(record PriceRecord) bool {
+	paid := record.Kind == priceKindSale || record.Kind == priceKindInstallments
+	return paid && record.PriceHash == "" && record.Brand != "" && record.Model != ""
+}
+
+/*
+ * Writes the price record of 'car', leaving the
+ * statistics to the caller.
+ *
+ * Returns the record.
+ */
+func writePriceRecord(stub shim.ChaincodeStubInterface, car *Car, kind string, price int) (PriceRecord, error) {
+	now, err := txUnix(stub)
+	if err != nil {
+		return PriceRecord{}, err
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return PriceRecord{}, newError(ErrLedger, "Error reading transient data")
+	}
+
+	record := PriceRecord{
+		Vin:   car.Vin,
+		Kind:  kind,
+		Price: price,
+		Brand: car.Certificate.Brand,
+		Model: car.Certificate.Model,
+		Ts:    now,
+		TxId:  stub.GetTxID(),
+	}
+
+	// only sales have a price
+	paid := kind == priceKindSale || kind == priceKindInstallments
+
+	salt := transient[priceSaltTransient]
+	if len(salt) > 0 && paid {
+		record.Price = 0
+		record.PriceHash = hashPrice(salt, price)
+	}
+
+	key, err := stub.CreateCompositeKey(priceObjectType, []string{record.Vin, fmt.Sprintf("%020d", record.Ts), record.TxId})
+	if err != nil {
+		return PriceRecord{}, newError(ErrInternal, "Error creating price record key")
+	}
+
+	recordAsBytes, _ := ledgerjson.Marshal(record)
+	err = stub.PutState(key, recordAsBytes)
+	if err != nil {
+		return PriceRecord{}, newError(ErrLedger, "Error writing price record")
+	}
+
+	return record, nil
+}
+
+/*
+ * Reads the price records of car 'vin', oldest first
+ */
+func readPriceHistory(stub shim.ChaincodeStubInterface, vin string) ([]PriceRecord, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(priceObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading price history")
+	}
+	defer iterator.Close()
+
+	records := []PriceRecord{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading price history")
+		}
+
+		record := PriceRecord{}
+		err = ledgerjson.Unmarshal(kv.Value, &record)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing price record")
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+/*
+ * Returns the price history of a car, oldest first.
+ *
+ * The DOT reads the history of every car, everybody
+ * else needs to be allowed to read the car by its owner,
+ * see 'grantReadAccess'.
+ *
+ * On success,
+ * returns the price records.
+ */
+func (t *CarChaincode) getPriceHistory(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	if role != "dot" {
+		allowed, err := t.canRead(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if !allowed {
+			return errorResponse(ErrNotOwner, "Forbidden: the owner did not allow you to read this car")
+		}
+	}
+
+	records, err := readPriceHistory(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	recordsAsBytes, _ := ledgerjson.Marshal(records)
+	return shim.Success(recordsAsBytes)
+}
+
+/*
+ * Returns the average public sale price of a model.
+ *
+ * On success,
+ * returns the price statistics.
+ */
+func (t *CarChaincode) averagePriceByModel(stub shim.ChaincodeStubInterface, brand string, model string) pb.Response {
+	stats, err := getPriceStats(stub, brand, model)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if stats == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There are no sales of %s %s yet", brand, model))
+	}
+
+	statsAsBytes, _ := ledgerjson.Marshal(stats)
+	return shim.Success(statsAsBytes)
+}
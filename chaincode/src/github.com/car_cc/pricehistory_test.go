@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestPriceHistory(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	salt := []byte("4f1c0a9e")
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage",
+		`{ "vin": "`+vin+`", "certificate": { "brand": "VW", "model": "Polo" } }`))
+	stub.MockInvoke("2", util.ToChaincodeArgs("sell", garage, "garage", "40", vin, "bobby"))
+
+	// the second sale keeps its price private
+	stub.TransientMap = map[string][]byte{priceSaltTransient: salt}
+	response := stub.MockInvoke("3", util.ToChaincodeArgs("sell", "bobby", "user", "30", vin, "mallory"))
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	stub.MockInvoke("4", util.ToChaincodeArgs("transfer", "mallory", "user", vin, garage))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPriceHistory", "inspector", "dot", vin))
+	records := []PriceRecord{}
+	json.Unmarshal(response.Payload, &records)
+	if len(records) != 3 {
+		t.Fatalf("Expected three price records, got %s", response.Payload)
+	}
+
+	if records[0].Kind != priceKindSale || records[0].Price != 40 {
+		t.Errorf("Unexpected price record of the first sale: %v", records[0])
+	}
+
+	if records[1].Price != 0 || records[1].PriceHash != hashPrice(salt, 30) {
+		t.Errorf("Expected only the hash of the private price, got %v", records[1])
+	}
+
+	if records[2].Kind != priceKindTransfer {
+		t.Errorf("Unexpected price record of the transfer: %v", records[2])
+	}
+
+	// buyers need the consent of the owner
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPriceHistory", "eve", "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("grantReadAccess", garage, "garage", vin, "eve", expiry))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPriceHistory", "eve", "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// only the public price counts
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("averagePriceByModel", "eve", "user", "VW", "Polo"))
+	stats := ModelPriceStats{}
+	json.Unmarshal(response.Payload, &stats)
+	if stats.Sales != 1 || stats.Average != 40 {
+		t.Errorf("Unexpected price statistics: %v", stats)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("averagePriceByModel", "eve", "user", "VW", "Golf"))
+	expectErrorCode(t, response, ErrNotFound)
+}
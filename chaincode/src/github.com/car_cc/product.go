@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Insurance marketplace.
+ *
+ * Insurers publish their coverage products with
+ * 'publishInsuranceProduct', each with a coverage, a base
+ * premium and the rules a car has to meet. Products are
+ * public and kept under 'insuranceProduct~<insurer>~<id>'.
+ *
+ * Owners browse the products, optionally only those their
+ * car is eligible for, and start a quote request right from
+ * a product with 'requestProductQuote'. The request is open
+ * to all insurers like any other, the insurer of the product
+ * quotes the base premium at once, so the owner can accept
+ * it without waiting.
+ */
+
+// object type of insurance product keys
+const insuranceProductObjectType string = "insuranceProduct"
+
+/*
+ * Returns the ledger key of product 'id' of 'insurer'
+ */
+func getInsuranceProductKey(stub shim.ChaincodeStubInterface, insurer string, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(insuranceProductObjectType, []string{insurer, id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating insurance product key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads product 'id' of 'insurer'
+ */
+func getInsuranceProduct(stub shim.ChaincodeStubInterface, insurer string, id string) (*InsuranceProduct, error) {
+	key, err := getInsuranceProductKey(stub, insurer, id)
+	if err != nil {
+		return nil, err
+	}
+
+	productAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading insurance product")
+	} else if productAsBytes == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("Insurer '%s' has no product '%s'", insurer, id))
+	}
+
+	product := InsuranceProduct{}
+	err = ledgerjson.Unmarshal(productAsBytes, &product)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing insurance product")
+	}
+
+	return &product, nil
+}
+
+/*
+ * Writes a product to ledger
+ */
+func saveInsuranceProduct(stub shim.ChaincodeStubInterface, product *InsuranceProduct) error {
+	key, err := getInsuranceProductKey(stub, product.Insurer, product.Id)
+	if err != nil {
+		return err
+	}
+
+	productAsBytes, _ := ledgerjson.Marshal(product)
+	err = stub.PutState(key, productAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing insurance product")
+	}
+
+	return nil
+}
+
+/*
+ * Returns why 'car' does not meet the rules of
+ * 'product' in year 'year', empty if it does
+ */
+func productIneligibility(product *InsuranceProduct, car *QuoteCarData, year int) string {
+	rules := product.Eligibility
+	switch {
+	case car.Stolen:
+		return "the car is reported stolen"
+	case len(rules.Types) > 0 && !containsString(rules.Types, car.Type):
+		return fmt.Sprintf("type '%s' is not covered", car.Type)
+	case len(rules.Brands) > 0 && !containsString(rules.Brands, car.Brand):
+		return fmt.Sprintf("brand '%s' is not covered", car.Brand)
+	case len(rules.EmissionClasses) > 0 && !containsString(rules.EmissionClasses, car.EmissionClass):
+		return fmt.Sprintf("emission class '%s' is not covered", car.EmissionClass)
+	case rules.MaxAge > 0 && year-car.Year > rules.MaxAge:
+		return fmt.Sprintf("the car is older than %d years", rules.MaxAge)
+	case rules.MaxMileage > 0 && car.MileAge > rules.MaxMileage:
+		return fmt.Sprintf("the car has more than %d km", rules.MaxMileage)
+	}
+
+	return ""
+}
+
+/*
+ * Publishes a coverage product of 'insurer',
+ * replacing an earlier one with the same id.
+ *
+ * Expects the product as json with id, name,
+ * coverage, base premium and eligibility rules.
+ *
+ * On success,
+ * returns the product.
+ */
+func (t *CarChaincode) publishInsuranceProduct(stub shim.ChaincodeStubInterface, insurer string, productData string) pb.Response {
+	product := InsuranceProduct{}
+	err := ledgerjson.Unmarshal([]byte(productData), &product)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing insurance product. Expecting InsuranceProduct as json.")
+	}
+
+	if product.Id == "" || product.Name == "" {
+		return errorResponse(ErrInvalidArgument, "'publishInsuranceProduct' expects a non-empty id and name")
+	} else if !containsString(coverageTypes, product.Coverage) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'publishInsuranceProduct' expects one of the coverage types %s", strings.Join(coverageTypes, ", ")))
+	} else if product.BasePremium <= 0 {
+		return errorResponse(ErrInvalidArgument, "'publishInsuranceProduct' expects a positive base premium")
+	} else if product.Eligibility.MaxAge < 0 || product.Eligibility.MaxMileage < 0 {
+		return errorResponse(ErrInvalidArgument, "'publishInsuranceProduct' expects non-negative limits")
+	}
+
+	// the premium is paid to the insurer's account
+	_, err = t.getUser(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	product.Insurer = insurer
+	product.Active = true
+	product.PublishedTs = now
+
+	err = saveInsuranceProduct(stub, &product)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Insurer '%s' published product '%s'\n", insurer, product.Id)
+
+	productAsBytes, _ := ledgerjson.Marshal(product)
+	return shim.Success(productAsBytes)
+}
+
+/*
+ * Withdraws product 'id' of 'insurer' from the
+ * marketplace. Open quote requests started from
+ * it are not affected.
+ *
+ * On success,
+ * returns the product.
+ */
+func (t *CarChaincode) withdrawInsuranceProduct(stub shim.ChaincodeStubInterface, insurer string, id string) pb.Response {
+	product, err := getInsuranceProduct(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !product.Active {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Product '%s' is withdrawn already", id))
+	}
+
+	product.Active = false
+	err = saveInsuranceProduct(stub, product)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	productAsBytes, _ := ledgerjson.Marshal(product)
+	return shim.Success(productAsBytes)
+}
+
+/*
+ * Lists the active insurance products.
+ *
+ * Arguments optional:
+ * [0] Vin                         (string)
+ *
+ * With a VIN of a car of 'username', only the
+ * products the car is eligible for are listed.
+ *
+ * On success,
+ * returns the products by insurer and id.
+ */
+func (t *CarChaincode) getInsuranceProducts(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	var car *QuoteCarData
+	year := 0
+	if vin != "" {
+		owned, err := t.getCar(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		data := quoteCarData(&owned)
+		car = &data
+
+		now, err := txTime(stub)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+		year = now.UTC().Year()
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(insuranceProductObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading insurance products")
+	}
+	defer iterator.Close()
+
+	products := []InsuranceProduct{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading insurance products")
+		}
+
+		product := InsuranceProduct{}
+		err = ledgerjson.Unmarshal(kv.Value, &product)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing insurance product")
+		}
+
+		if !product.Active || (car != nil && productIneligibility(&product, car, year) != "") {
+			continue
+		}
+		products = append(products, product)
+	}
+
+	productsAsBytes, _ := ledgerjson.Marshal(products)
+	return shim.Success(productsAsBytes)
+}
+
+/*
+ * Opens a quote request for car 'vin' with the coverage
+ * of product 'id' of 'insurer', which quotes its base
+ * premium right away.
+ *
+ * On success,
+ * returns the quote request.
+ */
+func (t *CarChaincode) requestProductQuote(stub shim.ChaincodeStubInterface, username string, vin string, insurer string, id string) pb.Response {
+	product, err := getInsuranceProduct(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !product.Active {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Product '%s' of insurer '%s' is withdrawn", id, insurer))
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Go register your car first")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	data := quoteCarData(&car)
+	reason := productIneligibility(product, &data, now.UTC().Year())
+	if reason != "" {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car '%s' is not eligible for product '%s': %s", vin, id, reason))
+	}
+
+	request, err := t.openQuoteRequest(stub, username, &car, product.Coverage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request.ProductInsurer = insurer
+	request.ProductId = id
+	err = t.saveQuoteRequest(stub, request)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quote := Quote{
+		Vin:         vin,
+		Insurer:     insurer,
+		Coverage:    product.Coverage,
+		Price:       product.BasePremium,
+		Conditions:  fmt.Sprintf("Product '%s'", product.Name),
+		RequestTs:   request.CreatedTs,
+		SubmittedTs: request.CreatedTs}
+
+	err = saveQuote(stub, &quote)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Quote request for car '%s' started from product '%s' of '%s'\n", vin, id, insurer)
+
+	requestAsBytes, _ := ledgerjson.Marshal(request)
+	return shim.Success(requestAsBytes)
+}
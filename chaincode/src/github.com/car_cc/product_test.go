@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInsuranceMarketplace(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	accredit(stub, "axa", "zurich")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "axa", "insurer"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "zurich", "insurer"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`", "certificate": { "brand": "VW" } }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("publishInsuranceProduct", "axa", "insurer", `{ "id": "basic", "name": "Basic", "coverage": "everything", "base_premium": 30 }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("publishInsuranceProduct", owner, "user", `{ "id": "basic", "name": "Basic", "coverage": "liability", "base_premium": 30 }`))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("publishInsuranceProduct", "axa", "insurer", `{ "id": "basic", "name": "Basic", "coverage": "liability", "base_premium": 30 }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("publishInsuranceProduct", "axa", "insurer", `{ "id": "old", "name": "Old", "coverage": "partial", "base_premium": 20 }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("publishInsuranceProduct", "zurich", "insurer", `{ "id": "bmw", "name": "BMW only", "coverage": "comprehensive", "base_premium": 50, "eligibility": { "brands": ["BMW"] } }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("withdrawInsuranceProduct", "axa", "insurer", "old"))
+
+	// everyone browses the active products
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuranceProducts", "bobby", "user"))
+	products := []InsuranceProduct{}
+	json.Unmarshal(response.Payload, &products)
+	if len(products) != 2 || products[0].Id != "basic" || products[1].Id != "bmw" {
+		t.Fatalf("Expected the products 'basic' and 'bmw', got %v", products)
+	}
+
+	// the owner sees those the car is eligible for
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuranceProducts", owner, "user", vin))
+	products = []InsuranceProduct{}
+	json.Unmarshal(response.Payload, &products)
+	if len(products) != 1 || products[0].Id != "basic" {
+		t.Fatalf("Expected only the product 'basic', got %v", products)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getInsuranceProducts", "bobby", "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestProductQuote", owner, "user", vin, "zurich", "bmw"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestProductQuote", owner, "user", vin, "axa", "old"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestProductQuote", owner, "user", vin, "axa", "basic"))
+	request := QuoteRequest{}
+	err := json.Unmarshal(response.Payload, &request)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if request.Coverage != "liability" || request.ProductInsurer != "axa" || request.ProductId != "basic" {
+		t.Errorf("Request should have started from product 'basic' of 'axa': %v", request)
+	}
+
+	// the request is open to other insurers, too
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("submitQuote", "zurich", "insurer", vin, "25", ""))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQuotes", owner, "user", vin))
+	quotes := []Quote{}
+	json.Unmarshal(response.Payload, &quotes)
+	if len(quotes) != 2 || quotes[0].Insurer != "axa" || quotes[0].Price != 30 {
+		t.Fatalf("Expected the base premium of 'axa' and the quote of 'zurich', got %v", quotes)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptQuote", owner, "user", vin, "axa"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Insurer != "axa" {
+		t.Errorf("Car should be insured by 'axa', not '%s'", car.Certificate.Insurer)
+	}
+}
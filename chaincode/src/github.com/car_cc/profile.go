@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * User profiles.
+ *
+ * Users keep their contact details, notification
+ * preferences and the client certificates linked to their
+ * username in a profile. Like the personal data, profiles
+ * are kept in the private data collection 'personalData',
+ * under 'profile~<username>', and are passed in the
+ * transient field 'profile'. The user itself, its balance
+ * and its cars are never written by a profile change.
+ *
+ * Only the identity bound to the username can change the
+ * profile, see 'createUser'.
+ */
+
+// object type of profile keys
+const profileObjectType string = "profile"
+
+// transient field holding the profile
+const profileTransient string = "profile"
+
+// channels users are notified on
+var notificationChannels = []string{"email", "sms", "none"}
+
+/*
+ * Returns the private data key of the profile of 'username'
+ */
+func getProfileKey(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	key, err := stub.CreateCompositeKey(profileObjectType, []string{username})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating profile key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the profile of 'username'.
+ *
+ * Returns an empty profile if there is none.
+ */
+func getProfile(stub shim.ChaincodeStubInterface, username string) (Profile, error) {
+	key, err := getProfileKey(stub, username)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profileAsBytes, err := stub.GetPrivateData(personalDataCollection, key)
+	if err != nil {
+		return Profile{}, newError(ErrLedger, "Error reading profile")
+	}
+
+	profile := Profile{}
+	if profileAsBytes == nil {
+		return profile, nil
+	}
+
+	err = ledgerjson.Unmarshal(profileAsBytes, &profile)
+	if err != nil {
+		return Profile{}, newError(ErrLedger, "Error parsing profile")
+	}
+
+	return profile, nil
+}
+
+/*
+ * Erases the profile of 'username'
+ */
+func eraseProfile(stub shim.ChaincodeStubInterface, username string) error {
+	key, err := getProfileKey(stub, username)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DelPrivateData(personalDataCollection, key)
+	if err != nil {
+		return newError(ErrLedger, "Error erasing profile")
+	}
+
+	return nil
+}
+
+/*
+ * Checks the values of a profile
+ */
+func validateProfile(profile Profile) error {
+	channel := profile.Notifications.Channel
+	if channel != "" && !containsString(notificationChannels, channel) {
+		return newError(ErrInvalidArgument, fmt.Sprintf("Unknown notification channel '%s'", channel))
+	}
+
+	for _, certificate := range profile.Certificates {
+		hash, err := hex.DecodeString(certificate)
+		if err != nil || len(hash) != 32 {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Linked certificate '%s' is no hex encoded sha256", certificate))
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Reads the profile of the invoker.
+ *
+ * On success,
+ * returns the profile with the bound identity.
+ */
+func (t *CarChaincode) getMyProfile(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	profile, err := getProfile(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	profile.Name = user.Name
+	profile.Identity = user.Identity
+
+	profileAsBytes, _ := ledgerjson.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+/*
+ * Replaces the profile of the invoker with the profile
+ * passed as JSON in the transient field 'profile'.
+ * The invoker has to be the identity bound to the username.
+ *
+ * On success,
+ * returns the profile.
+ */
+func (t *CarChaincode) updateProfile(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// users without bound identity are not checked on
+	// invoke, so their profile could be changed by anyone
+	caller, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if user.Identity == "" || caller != user.Identity {
+		return errorResponse(ErrIdentityMismatch, fmt.Sprintf("Forbidden: only the identity bound to '%s' can change its profile", username))
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading transient data")
+	}
+
+	profileAsBytes, ok := transient[profileTransient]
+	if !ok {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'updateProfile' expects the profile in the transient field '%s'", profileTransient))
+	}
+
+	profile := Profile{}
+	err = ledgerjson.Unmarshal(profileAsBytes, &profile)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing profile")
+	}
+
+	err = validateProfile(profile)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the name and the bound identity come from the user
+	profile.Name = ""
+	profile.Identity = ""
+	profile.UpdatedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := getProfileKey(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	profileAsBytes, _ = ledgerjson.Marshal(profile)
+	err = stub.PutPrivateData(personalDataCollection, key, profileAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing profile")
+	}
+
+	fmt.Printf("Updated profile of user '%s'\n", username)
+
+	profile.Name = user.Name
+	profile.Identity = user.Identity
+	profileAsBytes, _ = ledgerjson.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
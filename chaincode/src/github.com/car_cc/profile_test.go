@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	username := "bobby"
+	bobby := "Org1MSP bobby certificate"
+	mallory := "Org1MSP mallory certificate"
+	vin := "WVWZZZ6R6HY260780"
+	laptop := strings.Repeat("ab", 32)
+	profile := `{ "display_name": "Bobby", "email": "bobby@example.com", "notifications": { "channel": "email", "events": ["carSold"] }, "certificates": ["` + laptop + `"] }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "amag", "garage", `{ "vin": "`+vin+`" }`))
+	invokeAs(stub, bobby, "createUser", username, "user")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", "amag", "garage", vin, username))
+
+	// the profile is only accepted as transient data
+	response := invokeAs(stub, bobby, "updateProfile", username, "user")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.TransientMap = map[string][]byte{profileTransient: []byte(`{ "notifications": { "channel": "pigeon" } }`)}
+	response = invokeAs(stub, bobby, "updateProfile", username, "user")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.TransientMap = map[string][]byte{profileTransient: []byte(`{ "certificates": ["laptop"] }`)}
+	response = invokeAs(stub, bobby, "updateProfile", username, "user")
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.TransientMap = map[string][]byte{profileTransient: []byte(profile)}
+	response = invokeAs(stub, mallory, "updateProfile", username, "user")
+	expectErrorCode(t, response, ErrIdentityMismatch)
+
+	response = invokeAs(stub, bobby, "updateProfile", username, "user")
+	stub.TransientMap = nil
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = invokeAs(stub, bobby, "getMyProfile", username, "user")
+	myProfile := Profile{}
+	json.Unmarshal(response.Payload, &myProfile)
+	if myProfile.Email != "bobby@example.com" || myProfile.Notifications.Channel != "email" || len(myProfile.Certificates) != 1 {
+		t.Errorf("Unexpected profile: %s", response.Payload)
+	}
+
+	if myProfile.Name != username || myProfile.Identity == "" {
+		t.Errorf("Expected the profile with the bound identity, got %s", response.Payload)
+	}
+
+	// the cars stay with the user
+	response = invokeAs(stub, bobby, "readUser", username, "user")
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if len(user.Cars) != 1 || user.Cars[0] != vin {
+		t.Errorf("Expected the car to stay with the user, got %s", response.Payload)
+	}
+
+	// users without bound identity cannot change a profile
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "eve", "user"))
+	stub.TransientMap = map[string][]byte{profileTransient: []byte(profile)}
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("updateProfile", "eve", "user"))
+	stub.TransientMap = nil
+	expectErrorCode(t, response, ErrIdentityMismatch)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+)
+
+/*
+ * Field projection.
+ *
+ * 'readCar' takes an optional list of fields and returns
+ * only those, to keep payloads of mobile clients small
+ * and to share no more than needed with delegated
+ * readers. Fields are JSON names of the car, nested
+ * fields separated by dots ('certificate.numberplate').
+ * They are checked against the car schema, so typos are
+ * reported instead of silently returning nothing.
+ *
+ * Arrays and maps are projected as a whole.
+ */
+
+/*
+ * Checks that 'field' is a path of properties in the car schema
+ */
+func validateField(field string) error {
+	schema := schemaDefs["Car"]
+	for _, name := range strings.Split(field, ".") {
+		for schema != nil && schema.Ref != "" {
+			schema = schemaDefs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+		}
+
+		if schema == nil || schema.Properties[name] == nil {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Unknown car field '%s'", field))
+		}
+		schema = schema.Properties[name]
+	}
+
+	return nil
+}
+
+/*
+ * Returns the car JSON 'carAsBytes' reduced to 'fields'.
+ * Fields the car omits are left out.
+ */
+func projectCar(carAsBytes []byte, fields []string) ([]byte, error) {
+	car := map[string]interface{}{}
+	err := ledgerjson.Unmarshal(carAsBytes, &car)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing car")
+	}
+
+	projection := map[string]interface{}{}
+	for _, field := range fields {
+		err = validateField(field)
+		if err != nil {
+			return nil, err
+		}
+
+		names := strings.Split(field, ".")
+		source := car
+		target := projection
+		for _, name := range names[:len(names)-1] {
+			source, _ = source[name].(map[string]interface{})
+			if source == nil {
+				break
+			}
+
+			next, ok := target[name].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				target[name] = next
+			}
+			target = next
+		}
+
+		if value, ok := source[names[len(names)-1]]; ok {
+			target[names[len(names)-1]] = value
+		}
+	}
+
+	projectionAsBytes, _ := ledgerjson.Marshal(projection)
+	return projectionAsBytes, nil
+}
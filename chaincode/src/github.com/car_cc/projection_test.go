@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReadCarFields(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 1234"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin, `["vin", "certificate.numberplate", "cover_note"]`))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	car := map[string]interface{}{}
+	json.Unmarshal(response.Payload, &car)
+	certificate, _ := car["certificate"].(map[string]interface{})
+	if len(car) != 2 || car["vin"] != vin || len(certificate) != 1 || certificate["numberplate"] != "ZH 1234" {
+		t.Errorf("Expected only the VIN and numberplate, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin, `["certificate.plate"]`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", "bobby", "user", vin, `["vin"]`))
+	expectErrorCode(t, response, ErrNotOwner)
+}
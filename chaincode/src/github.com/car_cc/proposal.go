@@ -0,0 +1,622 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Registration proposal review queue.
+ *
+ * Every proposal lives under its own composite key
+ * 'proposal~<vin>', so creating a car does not rewrite
+ * all open proposals and two garages creating cars at
+ * the same time do not conflict on a shared index.
+ *
+ * Before deciding, the DOT can send a proposal back
+ * with 'requestProposalChanges'. The garage answers with
+ * 'amendProposal', which puts it back in the queue. Both
+ * are kept in the amendment history of the proposal.
+ */
+
+// object type of registration proposal keys
+const proposalObjectType string = "proposal"
+
+// proposal states
+const proposalPending string = "pending"
+const proposalApproved string = "approved"
+const proposalRejected string = "rejected"
+const proposalChangesRequested string = "changes_requested"
+
+// actions of the amendment history
+const amendmentChangesRequested string = "changes_requested"
+const amendmentAmended string = "amended"
+
+// default page size of 'getPendingProposals'
+const defaultProposalPageSize int = 20
+
+// maximum number of proposals approved per batch, see 'maxBatchSize'
+const maxApprovalBatchSize int = 200
+
+// days a registration proposal stays open by default
+const defaultProposalTtlDays int = 30
+
+/*
+ * Checks if a proposal can no longer be approved
+ */
+func IsProposalExpired(proposal *RegistrationProposal, now int64) bool {
+	return proposal.ExpiresTs != 0 && proposal.ExpiresTs <= now
+}
+
+/*
+ * Returns the ledger key of the proposal for car 'vin'
+ */
+func getProposalKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(proposalObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating registration proposal key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the registration proposal for car 'vin'
+ */
+func (t *CarChaincode) getProposal(stub shim.ChaincodeStubInterface, vin string) (RegistrationProposal, error) {
+	key, err := getProposalKey(stub, vin)
+	if err != nil {
+		return RegistrationProposal{}, err
+	}
+
+	proposalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return RegistrationProposal{}, newError(ErrLedger, "Error reading registration proposal")
+	} else if proposalAsBytes == nil {
+		return RegistrationProposal{}, newError(ErrNotFound, fmt.Sprintf("There exists no registration proposal for car with VIN: %s", vin))
+	}
+
+	proposal := RegistrationProposal{}
+	err = ledgerjson.Unmarshal(proposalAsBytes, &proposal)
+	if err != nil {
+		return RegistrationProposal{}, newError(ErrLedger, "Error parsing registration proposal")
+	}
+
+	return proposal, nil
+}
+
+/*
+ * Writes a registration proposal to ledger
+ */
+func (t *CarChaincode) saveProposal(stub shim.ChaincodeStubInterface, proposal RegistrationProposal) error {
+	key, err := getProposalKey(stub, proposal.Car)
+	if err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
+	err = stub.PutState(key, proposalAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing registration proposal")
+	}
+
+	return nil
+}
+
+/*
+ * Calls 'visit' for every registration proposal in VIN order,
+ * until 'visit' returns false.
+ */
+func (t *CarChaincode) forEachProposal(stub shim.ChaincodeStubInterface, visit func(RegistrationProposal) bool) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(proposalObjectType, []string{})
+	if err != nil {
+		return newError(ErrLedger, "Error reading registration proposals")
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return newError(ErrLedger, "Error reading registration proposals")
+		}
+
+		proposal := RegistrationProposal{}
+		err = ledgerjson.Unmarshal(kv.Value, &proposal)
+		if err != nil {
+			return newError(ErrLedger, "Error parsing registration proposal")
+		}
+
+		if !visit(proposal) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Closes a pending proposal with status 'status'
+ * and records the reviewer.
+ */
+func (t *CarChaincode) reviewProposal(stub shim.ChaincodeStubInterface, proposal *RegistrationProposal, status string, reviewer string, reason string) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = status
+	proposal.Reviewer = reviewer
+	proposal.ReviewedTs = now
+	proposal.Reason = reason
+
+	// reviewed proposals no longer expire
+	err = updateExpiry(stub, expiryProposal, proposal.Car, proposal.ExpiresTs, 0)
+	if err != nil {
+		return err
+	}
+
+	return t.saveProposal(stub, *proposal)
+}
+
+/*
+ * Reads all open registration proposals.
+ *
+ * On success,
+ * returns the open proposals, oldest first.
+ */
+func (t *CarChaincode) readRegistrationProposals(stub shim.ChaincodeStubInterface) pb.Response {
+	proposals, err := t.getOpenProposals(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	proposalsAsBytes, _ := ledgerjson.Marshal(proposals)
+	return shim.Success(proposalsAsBytes)
+}
+
+/*
+ * Returns the pending registration proposals ordered
+ * by creation, ties broken by VIN, see 'proposalsByCreatedTs'
+ */
+func (t *CarChaincode) getOpenProposals(stub shim.ChaincodeStubInterface) ([]RegistrationProposal, error) {
+	proposals := []RegistrationProposal{}
+	err := t.forEachProposal(stub, func(proposal RegistrationProposal) bool {
+		if proposal.Status == proposalPending {
+			proposals = append(proposals, proposal)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(proposalsByCreatedTs(proposals))
+	return proposals, nil
+}
+
+/*
+ * Returns a page of pending registration proposals, oldest
+ * first, ties broken by VIN. Pass the bookmark of a page to
+ * get the next page. The bookmark holds the creation and VIN
+ * of the last proposal on the page, so it stays valid when
+ * that proposal is reviewed or purged in the meantime.
+ *
+ * Arguments optional:
+ * [0] Page size                   (int)
+ * [1] Bookmark                    (string)
+ *
+ * On success,
+ * returns the proposal page.
+ */
+func (t *CarChaincode) getPendingProposals(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	pageSize := defaultProposalPageSize
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return errorResponse(ErrInvalidArgument, "'getPendingProposals' expects a positive page size")
+		}
+	}
+
+	proposals, err := t.getOpenProposals(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if len(args) > 1 && args[1] != "" {
+		last, err := parseProposalBookmark(args[1])
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		// skip up to the last proposal of the previous page
+		next := sort.Search(len(proposals), func(i int) bool {
+			return proposalsByCreatedTs{last, proposals[i]}.Less(0, 1)
+		})
+		proposals = proposals[next:]
+	}
+
+	page := ProposalPage{Proposals: proposals}
+	if len(proposals) > pageSize {
+		page.Proposals = proposals[:pageSize]
+		page.Bookmark = proposalBookmark(proposals[pageSize-1])
+	}
+
+	pageAsBytes, _ := ledgerjson.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+/*
+ * Returns the bookmark of a proposal page
+ * ending with 'proposal', '<createdTs>:<vin>'
+ */
+func proposalBookmark(proposal RegistrationProposal) string {
+	return strconv.FormatInt(proposal.CreatedTs, 10) + ":" + proposal.Car
+}
+
+/*
+ * Parses a bookmark of 'proposalBookmark' into
+ * the proposal it names
+ */
+func parseProposalBookmark(bookmark string) (RegistrationProposal, error) {
+	parts := strings.SplitN(bookmark, ":", 2)
+	if len(parts) != 2 {
+		return RegistrationProposal{}, newError(ErrInvalidArgument, fmt.Sprintf("Invalid proposal bookmark '%s'", bookmark))
+	}
+
+	createdTs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return RegistrationProposal{}, newError(ErrInvalidArgument, fmt.Sprintf("Invalid proposal bookmark '%s'", bookmark))
+	}
+
+	return RegistrationProposal{Car: parts[1], CreatedTs: createdTs}, nil
+}
+
+/*
+ * Approves a pending registration proposal and
+ * registers the car for its owner.
+ *
+ * Emits 'proposalApproved' if 'emit' is set, a batch
+ * emits one event for all its cars instead.
+ *
+ * On success,
+ * returns the registered car.
+ */
+func (t *CarChaincode) approveProposal(stub shim.ChaincodeStubInterface, reviewer string, vin string, emit bool) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	response := t.registerCar(stub, owner, vin, reviewer)
+	if response.Status != shim.OK || !emit {
+		return response
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	approvalAsBytes, _ := ledgerjson.Marshal(ProposalApproval{Vin: vin, Reviewer: reviewer, Ts: now})
+	err = stub.SetEvent("proposalApproved", approvalAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting proposal approval event")
+	}
+
+	return response
+}
+
+/*
+ * Approves many pending registration proposals
+ * in one transaction.
+ *
+ * Like 'createBatch', a proposal that cannot be approved
+ * does not fail the batch, it gets an error result. Only
+ * ledger errors abort the batch.
+ *
+ * Fabric only keeps one event per transaction, so the
+ * cars do not emit 'proposalApproved', 'proposalsApproved'
+ * carries the approval of each car instead.
+ *
+ * Arguments required:
+ * [0] List of VINs                (json, []string)
+ *
+ * On success,
+ * returns a result per VIN in input order.
+ */
+func (t *CarChaincode) approveProposalsBatch(stub shim.ChaincodeStubInterface, reviewer string, vinsData string) pb.Response {
+	vins := []string{}
+	err := ledgerjson.Unmarshal([]byte(vinsData), &vins)
+	if err != nil || len(vins) == 0 {
+		return errorResponse(ErrInvalidArgument, "'approveProposalsBatch' expects a non-empty list of VINs as json")
+	} else if len(vins) > maxApprovalBatchSize {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'approveProposalsBatch' accepts at most %d VINs, got %d", maxApprovalBatchSize, len(vins)))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	batch := ProposalBatchApproval{Reviewer: reviewer, Ts: now, Approved: []ProposalApproval{}}
+	results := []BatchResult{}
+	seen := make(map[string]bool)
+	for _, vin := range vins {
+		if seen[vin] {
+			results = append(results, BatchResult{Vin: vin, Error: newError(ErrInvalidArgument, fmt.Sprintf("Car '%s' is part of the batch twice", vin))})
+			batch.Failed++
+			continue
+		}
+		seen[vin] = true
+
+		response := t.approveProposal(stub, reviewer, vin, false)
+		if response.Status != shim.OK {
+			ccErr := errorFromResponse(response)
+			if ccErr.Code == ErrLedger || ccErr.Code == ErrInternal {
+				return response
+			}
+
+			results = append(results, BatchResult{Vin: vin, Error: ccErr})
+			batch.Failed++
+			continue
+		}
+
+		results = append(results, BatchResult{Vin: vin, Ok: true})
+		batch.Approved = append(batch.Approved, ProposalApproval{Vin: vin, Reviewer: reviewer, Ts: now})
+	}
+
+	if len(batch.Approved) > 0 {
+		batchAsBytes, _ := ledgerjson.Marshal(batch)
+		err = stub.SetEvent("proposalsApproved", batchAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error emitting proposal approval event")
+		}
+	}
+
+	fmt.Printf("Approved %d of %d registration proposals\n", len(batch.Approved), len(vins))
+
+	resultsAsBytes, _ := ledgerjson.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
+
+/*
+ * Rejects a pending registration proposal.
+ * The car stays unregistered.
+ *
+ * On success,
+ * returns the rejected proposal.
+ */
+func (t *CarChaincode) rejectProposal(stub shim.ChaincodeStubInterface, reviewer string, vin string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'rejectProposal' expects a non-empty reason")
+	}
+
+	proposal, err := t.getProposal(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if proposal.Status != proposalPending && proposal.Status != proposalChangesRequested {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot reject proposal for car with VIN '%s' with status '%s'", vin, proposal.Status))
+	}
+
+	err = t.reviewProposal(stub, &proposal, proposalRejected, reviewer, reason)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
+	return shim.Success(proposalAsBytes)
+}
+
+/*
+ * Sends a pending registration proposal back to
+ * the garage that created the car.
+ *
+ * On success,
+ * returns the proposal.
+ */
+func (t *CarChaincode) requestProposalChanges(stub shim.ChaincodeStubInterface, reviewer string, vin string, comment string) pb.Response {
+	if comment == "" {
+		return errorResponse(ErrInvalidArgument, "'requestProposalChanges' expects a comment on what to change")
+	}
+
+	proposal, err := t.getProposal(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if proposal.Status != proposalPending {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot request changes to proposal for car with VIN '%s' with status '%s'", vin, proposal.Status))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	proposal.Status = proposalChangesRequested
+	proposal.Amendments = append(proposal.Amendments, ProposalAmendment{
+		Action:  amendmentChangesRequested,
+		By:      reviewer,
+		Ts:      now,
+		Comment: comment,
+	})
+
+	err = t.saveProposal(stub, proposal)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
+	return shim.Success(proposalAsBytes)
+}
+
+/*
+ * Replaces the registration data of an open proposal
+ * and queues it for review again.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  RegistrationProposal                     json
+ *  (optional) comment                       string
+ *
+ * On success,
+ * returns the amended proposal.
+ */
+func (t *CarChaincode) amendProposal(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+
+	amended := RegistrationProposal{}
+	err := ledgerjson.Unmarshal([]byte(args[1]), &amended)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Error parsing registration data. Expecting RegistrationProposal as json.")
+	}
+
+	comment := ""
+	if len(args) > 2 {
+		comment = args[2]
+	}
+
+	proposal, err := t.getProposal(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if proposal.Owner != username {
+		return errorResponse(ErrNotOwner, "Forbidden: only the garage that created the car amends its proposal")
+	} else if proposal.Status != proposalPending && proposal.Status != proposalChangesRequested {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot amend proposal for car with VIN '%s' with status '%s'", vin, proposal.Status))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsProposalExpired(&proposal, now) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Registration proposal for car with VIN '%s' expired", vin))
+	}
+
+	// inspection data comes from certified garages only
+	err = requireProposalCertification(stub, username, &amended)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// keep the data the amendment replaces
+	proposal.Amendments = append(proposal.Amendments, ProposalAmendment{
+		Action:            amendmentAmended,
+		By:                username,
+		Ts:                now,
+		Comment:           comment,
+		NumberOfDoors:     proposal.NumberOfDoors,
+		NumberOfCylinders: proposal.NumberOfCylinders,
+		NumberOfAxis:      proposal.NumberOfAxis,
+		MaxSpeed:          proposal.MaxSpeed,
+	})
+
+	proposal.NumberOfDoors = amended.NumberOfDoors
+	proposal.NumberOfCylinders = amended.NumberOfCylinders
+	proposal.NumberOfAxis = amended.NumberOfAxis
+	proposal.MaxSpeed = amended.MaxSpeed
+	proposal.Status = proposalPending
+
+	err = t.saveProposal(stub, proposal)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Registration proposal for car '%s' amended by '%s'\n", vin, username)
+
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
+	return shim.Success(proposalAsBytes)
+}
+
+/*
+ * Reads the registration proposal of a car with its
+ * amendment history, for the DOT and the garage that
+ * created the car.
+ *
+ * On success,
+ * returns the proposal.
+ */
+func (t *CarChaincode) readProposal(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	proposal, err := t.getProposal(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if role != "dot" && proposal.Owner != username {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your registration proposal")
+	}
+
+	proposalAsBytes, _ := ledgerjson.Marshal(proposal)
+	return shim.Success(proposalAsBytes)
+}
+
+/*
+ * Removes registration proposals older than the configured
+ * proposal expiry, or older than 'maximum age' if given.
+ *
+ * Fabric only keeps one event per transaction, so the
+ * purged proposals are emitted together as 'proposalsPurged'.
+ *
+ * Arguments optional:
+ * [0] Maximum age in days         (int)
+ *
+ * On success,
+ * returns the purged proposals.
+ */
+func (t *CarChaincode) purgeExpiredProposals(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	maxAge := proposalTtl(config)
+	if len(args) > 0 {
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 0 {
+			return errorResponse(ErrInvalidArgument, "'purgeExpiredProposals' expects the maximum age as number of days")
+		}
+		maxAge = int64(days) * secondsPerDay
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	purged := []RegistrationProposal{}
+	err = t.forEachProposal(stub, func(proposal RegistrationProposal) bool {
+		if proposal.CreatedTs < now-maxAge {
+			purged = append(purged, proposal)
+		}
+		return true
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	for _, proposal := range purged {
+		key, err := getProposalKey(stub, proposal.Car)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error deleting registration proposal")
+		}
+	}
+
+	purgedAsBytes, _ := ledgerjson.Marshal(purged)
+	if len(purged) > 0 {
+		err = stub.SetEvent("proposalsPurged", purgedAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error emitting proposal purge event")
+		}
+	}
+
+	fmt.Printf("Purged %d registration proposals\n", len(purged))
+
+	return shim.Success(purgedAsBytes)
+}
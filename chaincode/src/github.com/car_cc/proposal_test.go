@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestProposalReviewQueue(t *testing.T) {
+	garage := "amag"
+	reviewer := "inspector"
+	vins := []string{"WVWZZZ6RXHY260782", "WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	for _, vin := range vins {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	}
+
+	// first page, created in the same second, so in VIN order
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "2"))
+	page := ProposalPage{}
+	err := json.Unmarshal(response.Payload, &page)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(page.Proposals) != 2 || page.Proposals[0].Car != vins[1] || page.Proposals[1].Car != vins[2] {
+		t.Fatalf("Unexpected first page: %v", page.Proposals)
+	} else if page.Bookmark != proposalBookmark(page.Proposals[1]) {
+		t.Errorf("Bookmark should name '%s', but is '%s'", vins[2], page.Bookmark)
+	}
+
+	// second and last page
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "2", page.Bookmark))
+	page = ProposalPage{}
+	err = json.Unmarshal(response.Payload, &page)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != vins[0] || page.Bookmark != "" {
+		t.Errorf("Unexpected last page: %v", page)
+	}
+
+	// reject one proposal, approve another
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectProposal", reviewer, "dot", vins[0], "brakes failed"))
+	proposal := RegistrationProposal{}
+	err = json.Unmarshal(response.Payload, &proposal)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if proposal.Status != proposalRejected || proposal.Reviewer != reviewer || proposal.Reason != "brakes failed" {
+		t.Errorf("Unexpected rejected proposal: %v", proposal)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposal", reviewer, "dot", vins[1]))
+	car := Car{}
+	err = json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if !IsRegistered(&car) || car.Certificate.Username != garage {
+		t.Error("Approved car should be registered for its owner")
+	}
+
+	// rejected cars cannot be registered
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposal", reviewer, "dot", vins[0]))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// only one proposal is left
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot"))
+	page = ProposalPage{}
+	err = json.Unmarshal(response.Payload, &page)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != vins[2] {
+		t.Errorf("Only the proposal for '%s' should be pending: %v", vins[2], page.Proposals)
+	}
+
+	// the approval is kept for the audit
+	proposal, err = (&CarChaincode{}).getProposal(stub, vins[1])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if proposal.Status != proposalApproved || proposal.Reviewer != reviewer || proposal.ReviewedTs == 0 {
+		t.Errorf("Unexpected approved proposal: %v", proposal)
+	}
+}
+
+func TestPurgeExpiredProposals(t *testing.T) {
+	garage := "amag"
+	reviewer := "inspector"
+	oldVin := "WVWZZZ6R6HY260780"
+	newVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+oldVin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+newVin+`" }`))
+
+	// age one proposal past the default expiry
+	cc := CarChaincode{}
+	stub.MockTransactionStart(uuid)
+	proposal, err := cc.getProposal(stub, oldVin)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proposal.CreatedTs -= 40 * secondsPerDay
+	proposal.ExpiresTs -= 40 * secondsPerDay
+	cc.saveProposal(stub, proposal)
+	stub.MockTransactionEnd(uuid)
+
+	// expired proposals cannot be approved
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposal", reviewer, "dot", oldVin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// garages may not purge proposals
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("purgeExpiredProposals", garage, "garage"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("purgeExpiredProposals", reviewer, "dot"))
+	purged := []RegistrationProposal{}
+	err = json.Unmarshal(response.Payload, &purged)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(purged) != 1 || purged[0].Car != oldVin {
+		t.Fatalf("Only the old proposal should be purged, got: %v", purged)
+	}
+
+	// the new proposal is still open
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot"))
+	page := ProposalPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != newVin {
+		t.Errorf("Unexpected pending proposals after purge: %v", page.Proposals)
+	}
+
+	// a shorter TTL applies to new proposals
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setProposalTtl", reviewer, "dot", "7"))
+	config := Config{}
+	json.Unmarshal(response.Payload, &config)
+	if config.ProposalTtlDays != 7 {
+		t.Errorf("Proposal TTL should be 7 days, but is %d", config.ProposalTtlDays)
+	}
+}
+
+func TestAmendProposal(t *testing.T) {
+	garage := "amag"
+	reviewer := "inspector"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "emil", "garage"))
+	certify(stub, garage, scopeInspection)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`, `{ "number_of_doors": "4+1", "max_speed": 180 }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("requestProposalChanges", reviewer, "dot", vin, ""))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestProposalChanges", reviewer, "dot", vin, "max speed does not match the type approval"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// cars with changes requested cannot be registered
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposal", reviewer, "dot", vin))
+	expectErrorCode(t, response, ErrNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("amendProposal", "emil", "garage", vin, `{ "number_of_doors": "4+1", "max_speed": 210 }`))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("amendProposal", garage, "garage", vin, `{ "number_of_doors": "4+1", "max_speed": 210 }`, "corrected"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getProposal", "emil", "garage", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getProposal", reviewer, "dot", vin))
+	proposal := RegistrationProposal{}
+	json.Unmarshal(response.Payload, &proposal)
+	if proposal.Status != proposalPending || proposal.MaxSpeed != 210 || proposal.Car != vin || proposal.Owner != garage {
+		t.Fatalf("Expected the amended proposal back in the queue, got %s", response.Payload)
+	} else if len(proposal.Amendments) != 2 || proposal.Amendments[0].Action != amendmentChangesRequested || proposal.Amendments[1].Action != amendmentAmended {
+		t.Fatalf("Expected the change request and the amendment, got %+v", proposal.Amendments)
+	} else if proposal.Amendments[1].MaxSpeed != 180 || proposal.Amendments[1].Comment != "corrected" {
+		t.Errorf("Expected the replaced data in the history, got %+v", proposal.Amendments[1])
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposal", reviewer, "dot", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("amendProposal", garage, "garage", vin, `{ "max_speed": 250 }`))
+	expectErrorCode(t, response, ErrInvalidState)
+}
+
+func TestApproveProposalsBatch(t *testing.T) {
+	garage := "amag"
+	reviewer := "inspector"
+	vins := []string{"WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781", "WVWZZZ6RXHY260782"}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	for _, vin := range vins {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectProposal", reviewer, "dot", vins[2], "brakes failed"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposalsBatch", reviewer, "dot", "[]"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposalsBatch", garage, "garage", `["`+vins[0]+`"]`))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		<-stub.ChaincodeEventsChannel
+	}
+
+	batch := `["` + vins[0] + `", "` + vins[2] + `", "` + vins[1] + `", "` + vins[0] + `"]`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveProposalsBatch", reviewer, "dot", batch))
+	results := []BatchResult{}
+	err := json.Unmarshal(response.Payload, &results)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	// the rejected proposal and the duplicate fail
+	if len(results) != 4 || !results[0].Ok || results[1].Ok || !results[2].Ok || results[3].Ok {
+		t.Fatalf("Unexpected results: %v", results)
+	} else if results[1].Error.Code != ErrNotFound || results[3].Error.Code != ErrInvalidArgument {
+		t.Errorf("Unexpected errors: %v, %v", results[1].Error, results[3].Error)
+	}
+
+	for _, vin := range vins[:2] {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "user", vin))
+		car := Car{}
+		json.Unmarshal(response.Payload, &car)
+		if !IsRegistered(&car) {
+			t.Errorf("Car '%s' should be registered", vin)
+		}
+	}
+
+	// only the aggregate, it lists the approvals
+	approvals := 0
+	aggregate := ProposalBatchApproval{}
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		event := <-stub.ChaincodeEventsChannel
+		if event.EventName == "proposalApproved" {
+			approvals++
+		} else if event.EventName == "proposalsApproved" {
+			json.Unmarshal(event.Payload, &aggregate)
+		}
+	}
+
+	if approvals != 0 {
+		t.Errorf("Expected no 'proposalApproved' events in a batch, got %d", approvals)
+	}
+
+	if len(aggregate.Approved) != 2 || aggregate.Approved[0].Vin != vins[0] || aggregate.Approved[1].Vin != vins[1] || aggregate.Failed != 2 || aggregate.Reviewer != reviewer {
+		t.Errorf("Unexpected 'proposalsApproved' event: %v", aggregate)
+	}
+}
+
+func TestPendingProposalOrder(t *testing.T) {
+	reviewer := "inspector"
+	vins := []string{"WVWZZZ6RXHY260782", "WVWZZZ6R6HY260780", "WVWZZZ6R8HY260781"}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	// the newest proposal has the lowest VIN,
+	// the two older ones were created together
+	stub.MockTransactionStart(uuid)
+	for i, createdTs := range []int64{1500000300, 1500000100, 1500000100} {
+		(&CarChaincode{}).saveProposal(stub, RegistrationProposal{Car: vins[i], Status: proposalPending, CreatedTs: createdTs})
+	}
+	stub.MockTransactionEnd(uuid)
+
+	first := stub.MockInvoke(uuid, util.ToChaincodeArgs("readRegistrationProposals", reviewer, "dot"))
+	second := stub.MockInvoke(uuid, util.ToChaincodeArgs("readRegistrationProposals", reviewer, "dot"))
+	if string(first.Payload) != string(second.Payload) {
+		t.Error("Reading the proposals twice should return the same result")
+	}
+
+	proposals := []RegistrationProposal{}
+	err := json.Unmarshal(first.Payload, &proposals)
+	if err != nil {
+		t.Fatal(first.Message)
+	}
+
+	if len(proposals) != 3 || proposals[0].Car != vins[1] || proposals[1].Car != vins[2] || proposals[2].Car != vins[0] {
+		t.Fatalf("Expected the proposals oldest first, then by VIN, got %v", proposals)
+	}
+
+	// the pages follow the same order
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "1"))
+	page := ProposalPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != vins[1] {
+		t.Fatalf("Unexpected first page: %v", page)
+	}
+
+	// the bookmark outlives the proposal it names
+	stub.MockTransactionStart(uuid)
+	key, _ := getProposalKey(stub, vins[1])
+	stub.DelState(key)
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "1", page.Bookmark))
+	page = ProposalPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != vins[2] {
+		t.Fatalf("Unexpected second page: %v", page)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "1", page.Bookmark))
+	page = ProposalPage{}
+	json.Unmarshal(response.Payload, &page)
+	if len(page.Proposals) != 1 || page.Proposals[0].Car != vins[0] || page.Bookmark != "" {
+		t.Errorf("Unexpected last page: %v", page)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getPendingProposals", reviewer, "dot", "1", vins[0]))
+	expectErrorCode(t, response, ErrInvalidArgument)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Owner pseudonyms.
+ *
+ * The car index and the ownership keys name owners by a
+ * pseudonym, the HMAC-SHA256 of the username keyed with a
+ * secret of the DOT. The secret and the pseudonym to
+ * username mapping are kept in the private data collection
+ * 'ownerPseudonyms', so reading the ledger does not reveal
+ * who owns which car, and guessing usernames does not help
+ * without the secret. Only the DOT resolves pseudonyms,
+ * with 'resolvePseudonym'.
+ *
+ * The secret is set once with 'setPseudonymSecret',
+ * before the first car is created.
+ */
+
+// private data collection of the secret and the mapping
+const pseudonymCollection string = "ownerPseudonyms"
+
+// key of the secret in the collection
+const pseudonymSecretStr string = "_pseudonymSecret"
+
+// transient field holding the secret
+const pseudonymSecretTransient string = "pseudonymSecret"
+
+// shortest accepted secret
+const minPseudonymSecretLength = 32
+
+/*
+ * Reads the pseudonym secret
+ */
+func getPseudonymSecret(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	secret, err := stub.GetPrivateData(pseudonymCollection, pseudonymSecretStr)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading pseudonym secret")
+	} else if secret == nil {
+		return nil, newError(ErrInvalidState, "No pseudonym secret set. The DOT sets one with 'setPseudonymSecret' first")
+	}
+
+	return secret, nil
+}
+
+/*
+ * Returns the pseudonym of 'username'
+ */
+func pseudonymOf(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	secret, err := getPseudonymSecret(stub)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+/*
+ * Returns the pseudonym of 'username' and keeps the
+ * mapping, so the pseudonym can be resolved again.
+ * Used whenever a pseudonym is written to the ledger.
+ */
+func registerPseudonym(stub shim.ChaincodeStubInterface, username string) (string, error) {
+	pseudonym, err := pseudonymOf(stub, username)
+	if err != nil {
+		return "", err
+	}
+
+	known, err := stub.GetPrivateData(pseudonymCollection, pseudonym)
+	if err != nil {
+		return "", newError(ErrLedger, "Error reading pseudonym")
+	} else if known != nil {
+		return pseudonym, nil
+	}
+
+	err = stub.PutPrivateData(pseudonymCollection, pseudonym, []byte(username))
+	if err != nil {
+		return "", newError(ErrLedger, "Error writing pseudonym")
+	}
+
+	return pseudonym, nil
+}
+
+/*
+ * Returns the username behind 'pseudonym',
+ * or an empty string for an empty pseudonym
+ */
+func resolveOwner(stub shim.ChaincodeStubInterface, pseudonym string) (string, error) {
+	if pseudonym == "" {
+		return "", nil
+	}
+
+	username, err := stub.GetPrivateData(pseudonymCollection, pseudonym)
+	if err != nil {
+		return "", newError(ErrLedger, "Error reading pseudonym")
+	} else if username == nil {
+		return "", newError(ErrLedger, fmt.Sprintf("Unknown owner pseudonym '%s'", pseudonym))
+	}
+
+	return string(username), nil
+}
+
+/*
+ * Sets the pseudonym secret, passed in the transient
+ * field 'pseudonymSecret'. The secret cannot be changed,
+ * as all pseudonyms on the ledger depend on it.
+ *
+ * Returns 'nil' on success.
+ */
+func (t *CarChaincode) setPseudonymSecret(stub shim.ChaincodeStubInterface) pb.Response {
+	known, err := stub.GetPrivateData(pseudonymCollection, pseudonymSecretStr)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading pseudonym secret")
+	} else if known != nil {
+		return errorResponse(ErrInvalidState, "The pseudonym secret is already set")
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading transient data")
+	}
+
+	secret := transient[pseudonymSecretTransient]
+	if len(secret) < minPseudonymSecretLength {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'setPseudonymSecret' expects a secret of at least %d bytes in transient field '%s'", minPseudonymSecretLength, pseudonymSecretTransient))
+	}
+
+	err = stub.PutPrivateData(pseudonymCollection, pseudonymSecretStr, secret)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing pseudonym secret")
+	}
+
+	fmt.Println("Pseudonym secret set")
+	return shim.Success(nil)
+}
+
+/*
+ * Resolves an owner pseudonym of the car index.
+ *
+ * On success,
+ * returns the username.
+ */
+func (t *CarChaincode) resolvePseudonym(stub shim.ChaincodeStubInterface, pseudonym string) pb.Response {
+	username, err := stub.GetPrivateData(pseudonymCollection, pseudonym)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading pseudonym")
+	} else if username == nil || pseudonym == pseudonymSecretStr {
+		return errorResponse(ErrNotFound, fmt.Sprintf("Unknown owner pseudonym '%s'", pseudonym))
+	}
+
+	return shim.Success(username)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Reservation queue of listed cars.
+ *
+ * Buyers interested in a listed car line up with
+ * 'joinQueue'. While buyers wait, the car only takes a
+ * deposit from and goes to the first of them. The seller
+ * moves on to the next buyer with 'skipQueuedBuyer', which
+ * needs a reason, kept under 'queueSkip~<vin>~<ts>~<txid>'
+ * beyond the listing. The queue ends with the listing.
+ */
+
+// object type of queue skip keys
+const queueSkipObjectType string = "queueSkip"
+
+/*
+ * Checks that a listed car with a queue
+ * only goes to the first buyer in line
+ */
+func checkQueue(car *Car, receiver string) error {
+	if IsListed(car) && len(car.Listing.Queue) > 0 && car.Listing.Queue[0].Buyer != receiver {
+		return newError(ErrInvalidState, fmt.Sprintf("'%s' is first in the queue for the car, skip them with a reason first", car.Listing.Queue[0].Buyer))
+	}
+
+	return nil
+}
+
+/*
+ * Returns the position of 'buyer' in the
+ * queue of a car, -1 if not queued
+ */
+func queuePosition(car *Car, buyer string) int {
+	for i, entry := range car.Listing.Queue {
+		if entry.Buyer == buyer {
+			return i
+		}
+	}
+
+	return -1
+}
+
+/*
+ * Queues 'username' for listed car 'vin'.
+ *
+ * On success,
+ * returns the queue.
+ */
+func (t *CarChaincode) joinQueue(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if owner == username {
+		return errorResponse(ErrInvalidArgument, "'joinQueue' expects a car of another user")
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) {
+		return errorResponse(ErrInvalidState, "Car is not listed")
+	} else if queuePosition(&car, username) >= 0 {
+		return errorResponse(ErrAlreadyExists, "You are in the queue for the car already")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Listing.Queue = append(car.Listing.Queue, QueueEntry{Buyer: username, JoinedTs: now})
+
+	response := t.saveListedCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	fmt.Printf("'%s' is number %d in the queue for car '%s'\n", username, len(car.Listing.Queue), vin)
+
+	queueAsBytes, _ := ledgerjson.Marshal(car.Listing.Queue)
+	return shim.Success(queueAsBytes)
+}
+
+/*
+ * Takes 'username' out of the queue for car 'vin'.
+ *
+ * On success,
+ * returns the queue.
+ */
+func (t *CarChaincode) leaveQueue(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	position := queuePosition(&car, username)
+	if position < 0 {
+		return errorResponse(ErrNotFound, "You are not in the queue for the car")
+	}
+
+	car.Listing.Queue = append(car.Listing.Queue[:position], car.Listing.Queue[position+1:]...)
+
+	response := t.saveListedCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	queueAsBytes, _ := ledgerjson.Marshal(car.Listing.Queue)
+	return shim.Success(queueAsBytes)
+}
+
+/*
+ * Skips the first buyer in the queue for car 'vin'
+ * for 'reason', so the seller deals with the next one.
+ *
+ * On success,
+ * returns the skip.
+ */
+func (t *CarChaincode) skipQueuedBuyer(stub shim.ChaincodeStubInterface, username string, vin string, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'skipQueuedBuyer' expects a non-empty reason")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if len(car.Listing.Queue) == 0 {
+		return errorResponse(ErrInvalidState, "Nobody is in the queue for the car")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	first := car.Listing.Queue[0]
+	if hold := car.Listing.Hold; hold != nil && hold.Buyer == first.Buyer && IsHeld(&car, now) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("'%s' holds the car with a deposit, cancel it first", first.Buyer))
+	}
+
+	skip := QueueSkip{
+		Vin:      vin,
+		Buyer:    first.Buyer,
+		Seller:   username,
+		Reason:   reason,
+		JoinedTs: first.JoinedTs,
+		Ts:       now}
+
+	key, err := stub.CreateCompositeKey(queueSkipObjectType, []string{vin, fmt.Sprintf("%020d", now), stub.GetTxID()})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating queue skip key")
+	}
+
+	skipAsBytes, _ := ledgerjson.Marshal(skip)
+	err = stub.PutState(key, skipAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing queue skip")
+	}
+
+	car.Listing.Queue = car.Listing.Queue[1:]
+
+	response := t.saveListedCar(stub, &car)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	fmt.Printf("'%s' skipped in the queue for car '%s': %s\n", first.Buyer, vin, reason)
+	return shim.Success(skipAsBytes)
+}
+
+/*
+ * Returns the queue for listed car 'vin'.
+ *
+ * On success,
+ * returns the queued buyers, first in line first.
+ */
+func (t *CarChaincode) getQueue(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsListed(&car) {
+		return errorResponse(ErrInvalidState, "Car is not listed")
+	}
+
+	queue := car.Listing.Queue
+	if queue == nil {
+		queue = []QueueEntry{}
+	}
+
+	queueAsBytes, _ := ledgerjson.Marshal(queue)
+	return shim.Success(queueAsBytes)
+}
+
+/*
+ * Lists the buyers skipped in queues for car 'vin'.
+ * The DOT reads all of them, sellers and buyers
+ * those they took part in.
+ *
+ * On success,
+ * returns the skips.
+ */
+func (t *CarChaincode) getQueueSkips(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(queueSkipObjectType, []string{vin})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading queue skips")
+	}
+	defer iterator.Close()
+
+	skips := []QueueSkip{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading queue skips")
+		}
+
+		skip := QueueSkip{}
+		err = ledgerjson.Unmarshal(kv.Value, &skip)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing queue skip")
+		}
+
+		if role == "dot" || skip.Buyer == username || skip.Seller == username {
+			skips = append(skips, skip)
+		}
+	}
+
+	skipsAsBytes, _ := ledgerjson.Marshal(skips)
+	return shim.Success(skipsAsBytes)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReservationQueue(t *testing.T) {
+	seller := "amag"
+	first := "bobby"
+	second := "emil"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", first, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", second, "user"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("joinQueue", first, "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("joinQueue", first, "user", vin))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("joinQueue", second, "user", vin))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("joinQueue", first, "user", vin))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	// the seller works through the queue in order
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", second, "user", vin, "20"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, second))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("skipQueuedBuyer", seller, "garage", vin, ""))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("skipQueuedBuyer", seller, "garage", vin, "did not answer for a week"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQueue", "mallory", "user", vin))
+	queue := []QueueEntry{}
+	json.Unmarshal(response.Payload, &queue)
+	if len(queue) != 1 || queue[0].Buyer != second {
+		t.Fatalf("Expected only '%s' in the queue, got %v", second, queue)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, second))
+	if response.Status != shim.OK {
+		t.Fatal("Car should go to the first buyer in line: " + response.Message)
+	}
+
+	// the skip outlives the listing
+	for username, count := range map[string]int{first: 1, "mallory": 0} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQueueSkips", username, "user", vin))
+		skips := []QueueSkip{}
+		json.Unmarshal(response.Payload, &skips)
+		if len(skips) != count {
+			t.Errorf("Expected %d skips for '%s', got %v", count, username, skips)
+		}
+	}
+}
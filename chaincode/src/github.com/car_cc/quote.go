@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Insurance quotes.
+ *
+ * An owner asks insurers for quotes with 'requestQuote'. The
+ * request shares only the car facts relevant for a premium,
+ * not the owner's other data. Insurers answer with a price
+ * and conditions and the owner accepts one of the quotes,
+ * which insures the car. Requests are kept under
+ * 'quoteRequest~<vin>', quotes under 'quote~<vin>~<insurer>'.
+ */
+
+// object types of quote keys
+const quoteRequestObjectType string = "quoteRequest"
+const quoteObjectType string = "quote"
+
+// quote request states
+const quoteRequestOpen string = "open"
+const quoteRequestAccepted string = "accepted"
+
+// coverage types an owner can ask for
+var coverageTypes = []string{"liability", "partial", "comprehensive"}
+
+/*
+ * Returns the ledger key of the quote request for car 'vin'
+ */
+func getQuoteRequestKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(quoteRequestObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating quote request key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the latest quote request for car 'vin'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func (t *CarChaincode) getQuoteRequest(stub shim.ChaincodeStubInterface, vin string) (*QuoteRequest, error) {
+	key, err := getQuoteRequestKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	requestAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading quote request")
+	} else if requestAsBytes == nil {
+		return nil, nil
+	}
+
+	request := QuoteRequest{}
+	err = ledgerjson.Unmarshal(requestAsBytes, &request)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing quote request")
+	}
+
+	return &request, nil
+}
+
+/*
+ * Writes a quote request to ledger
+ */
+func (t *CarChaincode) saveQuoteRequest(stub shim.ChaincodeStubInterface, request *QuoteRequest) error {
+	key, err := getQuoteRequestKey(stub, request.Car.Vin)
+	if err != nil {
+		return err
+	}
+
+	requestAsBytes, _ := ledgerjson.Marshal(request)
+	err = stub.PutState(key, requestAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing quote request")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the open quote request for car 'vin'
+ */
+func (t *CarChaincode) getOpenQuoteRequest(stub shim.ChaincodeStubInterface, vin string) (*QuoteRequest, error) {
+	request, err := t.getQuoteRequest(stub, vin)
+	if err != nil {
+		return nil, err
+	} else if request == nil || request.Status != quoteRequestOpen {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no open quote request for car with VIN '%s'", vin))
+	}
+
+	return request, nil
+}
+
+/*
+ * Returns the car facts an insurer sees in a quote request
+ */
+func quoteCarData(car *Car) QuoteCarData {
+	return QuoteCarData{
+		Vin:           car.Vin,
+		Brand:         car.Certificate.Brand,
+		Model:         car.Certificate.Model,
+		Type:          car.Certificate.Type,
+		Year:          time.Unix(car.CreatedTs, 0).UTC().Year(),
+		MileAge:       carMileage(car),
+		EmissionClass: car.Emission.Class,
+		Stolen:        car.Stolen}
+}
+
+/*
+ * Asks insurers for quotes to insure the car
+ * with coverage 'coverage'.
+ *
+ * On success,
+ * returns the quote request.
+ */
+func (t *CarChaincode) requestQuote(stub shim.ChaincodeStubInterface, username string, vin string, coverage string) pb.Response {
+	if !containsString(coverageTypes, coverage) {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'requestQuote' expects one of the coverage types %s", strings.Join(coverageTypes, ", ")))
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Go register your car first")
+	}
+
+	request, err := t.openQuoteRequest(stub, username, &car, coverage)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	requestAsBytes, _ := ledgerjson.Marshal(request)
+	return shim.Success(requestAsBytes)
+}
+
+/*
+ * Opens a quote request for 'car' of 'username', unless
+ * there is an open one already
+ */
+func (t *CarChaincode) openQuoteRequest(stub shim.ChaincodeStubInterface, username string, car *Car, coverage string) (*QuoteRequest, error) {
+	request, err := t.getQuoteRequest(stub, car.Vin)
+	if err != nil {
+		return nil, err
+	} else if request != nil && request.Status == quoteRequestOpen {
+		return nil, newError(ErrAlreadyExists, fmt.Sprintf("There is an open quote request for car with VIN '%s'", car.Vin))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	request = &QuoteRequest{
+		Car:       quoteCarData(car),
+		Owner:     username,
+		Coverage:  coverage,
+		Status:    quoteRequestOpen,
+		CreatedTs: now}
+
+	err = t.saveQuoteRequest(stub, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+/*
+ * Lists all open quote requests for insurers.
+ *
+ * On success,
+ * returns the open quote requests in VIN order.
+ */
+func (t *CarChaincode) getQuoteRequests(stub shim.ChaincodeStubInterface) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(quoteRequestObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading quote requests")
+	}
+	defer iterator.Close()
+
+	requests := []QuoteRequest{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading quote requests")
+		}
+
+		request := QuoteRequest{}
+		err = ledgerjson.Unmarshal(kv.Value, &request)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing quote request")
+		}
+
+		if request.Status == quoteRequestOpen {
+			requests = append(requests, request)
+		}
+	}
+
+	requestsAsBytes, _ := ledgerjson.Marshal(requests)
+	return shim.Success(requestsAsBytes)
+}
+
+/*
+ * Answers the open quote request for car 'vin' with
+ * a price and conditions, replacing an earlier quote
+ * of the same insurer.
+ *
+ * On success,
+ * returns the quote.
+ */
+func (t *CarChaincode) submitQuote(stub shim.ChaincodeStubInterface, insurer string, vin string, price int, conditions string) pb.Response {
+	if price <= 0 {
+		return errorResponse(ErrInvalidArgument, "'submitQuote' expects a positive price")
+	}
+
+	// the premium is paid to the insurer's account
+	_, err := t.getUser(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request, err := t.getOpenQuoteRequest(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quote := Quote{
+		Vin:         vin,
+		Insurer:     insurer,
+		Coverage:    request.Coverage,
+		Price:       price,
+		Conditions:  conditions,
+		RequestTs:   request.CreatedTs,
+		SubmittedTs: now}
+
+	err = saveQuote(stub, &quote)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quoteAsBytes, _ := ledgerjson.Marshal(quote)
+	return shim.Success(quoteAsBytes)
+}
+
+/*
+ * Writes a quote to ledger
+ */
+func saveQuote(stub shim.ChaincodeStubInterface, quote *Quote) error {
+	key, err := stub.CreateCompositeKey(quoteObjectType, []string{quote.Vin, quote.Insurer})
+	if err != nil {
+		return newError(ErrInternal, "Error creating quote key")
+	}
+
+	quoteAsBytes, _ := ledgerjson.Marshal(quote)
+	err = stub.PutState(key, quoteAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing quote")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the quotes for the open quote request of car 'vin'.
+ * Quotes for earlier requests are left out.
+ */
+func (t *CarChaincode) getRequestQuotes(stub shim.ChaincodeStubInterface, request *QuoteRequest) ([]Quote, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(quoteObjectType, []string{request.Car.Vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading quotes")
+	}
+	defer iterator.Close()
+
+	quotes := []Quote{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading quotes")
+		}
+
+		quote := Quote{}
+		err = ledgerjson.Unmarshal(kv.Value, &quote)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing quote")
+		}
+
+		if quote.RequestTs == request.CreatedTs {
+			quotes = append(quotes, quote)
+		}
+	}
+
+	return quotes, nil
+}
+
+/*
+ * Lists the quotes for the open quote request of a car.
+ *
+ * On success,
+ * returns the quotes by insurer name.
+ */
+func (t *CarChaincode) getQuotes(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	_, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request, err := t.getOpenQuoteRequest(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quotes, err := t.getRequestQuotes(stub, request)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quotesAsBytes, _ := ledgerjson.Marshal(quotes)
+	return shim.Success(quotesAsBytes)
+}
+
+/*
+ * Accepts the quote of 'insurer'. The owner pays the
+ * price to the insurer and the car is insured by it.
+ *
+ * On success,
+ * returns the insured car.
+ */
+func (t *CarChaincode) acceptQuote(stub shim.ChaincodeStubInterface, username string, vin string, insurer string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request, err := t.getOpenQuoteRequest(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	quotes, err := t.getRequestQuotes(stub, request)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	var accepted *Quote
+	for i := range quotes {
+		if quotes[i].Insurer == insurer {
+			accepted = &quotes[i]
+		}
+	}
+	if accepted == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("Insurer '%s' did not quote for car with VIN '%s'", insurer, vin))
+	}
+
+	err = requireAccreditedInsurer(stub, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, username, -accepted.Price)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, insurer, accepted.Price)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	request.Status = quoteRequestAccepted
+	request.Insurer = insurer
+	err = t.saveQuoteRequest(stub, request)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = updateInsuredIndex(stub, vin, car.Certificate.Insurer, insurer)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Certificate.Insurer = insurer
+	car.Policy = InsurancePolicy{}
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Car '%s' is insured by '%s' for %d\n", vin, insurer, accepted.Price)
+
+	return shim.Success(carAsBytes)
+}
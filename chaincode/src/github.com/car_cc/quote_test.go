@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestQuoteFlow(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	accredit(stub, "axa", "zurich")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "axa", "insurer"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "zurich", "insurer"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("requestQuote", owner, "user", vin, "comprehensive"))
+	expectErrorCode(t, response, ErrNotRegistered)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestQuote", owner, "user", vin, "everything"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("submitQuote", "axa", "insurer", vin, "40", "no young drivers"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("requestQuote", owner, "user", vin, "comprehensive"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("requestQuote", owner, "user", vin, "liability"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQuoteRequests", "axa", "insurer"))
+	requests := []QuoteRequest{}
+	json.Unmarshal(response.Payload, &requests)
+	if len(requests) != 1 || requests[0].Car.Vin != vin || requests[0].Coverage != "comprehensive" {
+		t.Fatalf("Insurers should see the open request: %v", requests)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("submitQuote", "axa", "insurer", vin, "40", "no young drivers"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("submitQuote", "zurich", "insurer", vin, "60", ""))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQuotes", owner, "user", vin))
+	quotes := []Quote{}
+	json.Unmarshal(response.Payload, &quotes)
+	if len(quotes) != 2 {
+		t.Fatalf("Owner should see both quotes: %v", quotes)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptQuote", owner, "user", vin, "allianz"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptQuote", owner, "user", vin, "axa"))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Certificate.Insurer != "axa" {
+		t.Errorf("Car should be insured by 'axa', not '%s'", car.Certificate.Insurer)
+	}
+
+	for name, balance := range map[string]int{owner: 60, "axa": 140, "zurich": 100} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("read", "TESTING", "TESTING", "usr_"+name))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected balance %d of '%s', got %d", balance, name, user.Balance)
+		}
+	}
+
+	// the request is closed
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getQuotes", owner, "user", vin))
+	expectErrorCode(t, response, ErrNotFound)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Short-term rentals.
+ *
+ * The owner offers a rental to a renter with 'startRental',
+ * the renter accepts it and pays the deposit from their
+ * balance. While the rental is active, the renter can report
+ * damages and the owner cannot transfer the car. 'endRental'
+ * returns the deposit, minus what the owner keeps for damages.
+ */
+
+// rental states
+const rentalOffered string = "offered"
+const rentalActive string = "active"
+
+/*
+ * Checks if a car is rented out or a rental is offered
+ */
+func IsRented(car *Car) bool {
+	return car.Rental.Status != ""
+}
+
+/*
+ * Offers a rental of the car to the renter of 'rental',
+ * from its start until its end, for its deposit.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) startRental(stub shim.ChaincodeStubInterface, username string, vin string, rental Rental) pb.Response {
+	if rental.Renter == "" || rental.Renter == username {
+		return errorResponse(ErrInvalidArgument, "'startRental' expects another user as renter")
+	} else if rental.EndTs <= rental.StartTs {
+		return errorResponse(ErrInvalidArgument, "'startRental' expects the end after the start")
+	} else if rental.Deposit < 0 {
+		return errorResponse(ErrInvalidArgument, "'startRental' expects a deposit of 0 or more")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsRented(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is already rented to '%s'", car.Rental.Renter))
+	} else if IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is sold in installments to '%s'", car.Installments.Buyer))
+	} else if IsFrozen(&car) {
+		return errorResponse(ErrInvalidState, "Car is frozen and cannot be rented out")
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Only registered cars can be rented out")
+	}
+
+	_, err = t.getUser(stub, rental.Renter)
+	if err != nil {
+		return errorResponse(ErrUserNotFound, "User does not exist. Username: '"+rental.Renter+"'")
+	}
+
+	rental.Status = rentalOffered
+	rental.DamageReports = []DamageReport{}
+	car.Rental = rental
+
+	err = updateExpiry(stub, expiryRental, vin, 0, rental.EndTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	return t.saveRentedCar(stub, &car)
+}
+
+/*
+ * Lets the renter accept an offered rental.
+ * The deposit is taken from the renter's balance.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) acceptRental(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getRentedCar(stub, username, vin, rentalOffered)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, username, -car.Rental.Deposit)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Rental.Status = rentalActive
+
+	return t.saveRentedCar(stub, &car)
+}
+
+/*
+ * Lets the renter report a damage during an active rental.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) reportDamage(stub shim.ChaincodeStubInterface, username string, vin string, description string) pb.Response {
+	if description == "" {
+		return errorResponse(ErrInvalidArgument, "'reportDamage' expects a non-empty description")
+	}
+
+	car, err := t.getRentedCar(stub, username, vin, rentalActive)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Rental.DamageReports = append(car.Rental.DamageReports, DamageReport{ReportedTs: now, Description: description})
+
+	return t.saveRentedCar(stub, &car)
+}
+
+/*
+ * Ends a rental, or withdraws an offer that was not accepted.
+ *
+ * The owner keeps 'damages' of the deposit,
+ * the rest goes back to the renter.
+ *
+ * On success,
+ * returns the ended rental.
+ */
+func (t *CarChaincode) endRental(stub shim.ChaincodeStubInterface, username string, vin string, damages int) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsRented(&car) {
+		return errorResponse(ErrInvalidState, "Car is not rented out")
+	}
+
+	rental := car.Rental
+	if rental.Status == rentalActive {
+		if damages < 0 || damages > rental.Deposit {
+			return errorResponse(ErrInvalidArgument, fmt.Sprintf("Damages must be between 0 and the deposit of %d", rental.Deposit))
+		}
+
+		_, err = t.updateBalance(stub, rental.Renter, rental.Deposit-damages)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		if damages > 0 {
+			_, err = t.updateBalance(stub, username, damages)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+		}
+	}
+
+	car.Rental = Rental{}
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	rentalAsBytes, _ := ledgerjson.Marshal(rental)
+	return shim.Success(rentalAsBytes)
+}
+
+/*
+ * Reads a car rented to 'username' in rental state 'status'
+ */
+func (t *CarChaincode) getRentedCar(stub shim.ChaincodeStubInterface, username string, vin string, status string) (Car, error) {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return Car{}, err
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return Car{}, err
+	} else if car.Rental.Renter != username {
+		return Car{}, newError(ErrForbidden, "Forbidden: this car is not rented to you")
+	} else if car.Rental.Status != status {
+		return Car{}, newError(ErrInvalidState, fmt.Sprintf("Rental is '%s', expected '%s'", car.Rental.Status, status))
+	}
+
+	return car, nil
+}
+
+/*
+ * Writes a car with a changed rental
+ */
+func (t *CarChaincode) saveRentedCar(stub shim.ChaincodeStubInterface, car *Car) pb.Response {
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err := stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRental(t *testing.T) {
+	owner := "amag"
+	renter := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	start := strconv.FormatInt(time.Now().Unix(), 10)
+	end := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", renter, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("startRental", owner, "user", vin, renter, end, start, "40"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("startRental", owner, "user", vin, renter, start, end, "40"))
+
+	// no damage reports before the rental is accepted
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("reportDamage", renter, "user", vin, "scratch"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("acceptRental", renter, "user", vin))
+	car := Car{}
+	err := json.Unmarshal(response.Payload, &car)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if car.Rental.Status != rentalActive || car.Rental.Renter != renter {
+		t.Fatalf("Rental should be active: %v", car.Rental)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("reportDamage", renter, "user", vin, "scratch on the left door"))
+
+	// rented cars cannot be transferred
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, "carl"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// the owner keeps 15 for the scratch
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("endRental", owner, "user", vin, "15"))
+	rental := Rental{}
+	err = json.Unmarshal(response.Payload, &rental)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if len(rental.DamageReports) != 1 {
+		t.Errorf("Ended rental should contain the damage report: %v", rental)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", renter, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 85 {
+		t.Errorf("Renter should get 25 of the deposit back, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", owner, "user"))
+	user = User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 115 {
+		t.Errorf("Owner should keep 15 for damages, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, renter))
+	if response.Status != shim.OK {
+		t.Error("Car should be transferable after the rental: " + response.Message)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Pre-purchase vehicle reports.
+ *
+ * 'getVehicleReport' puts together what a buyer wants to
+ * know about a car in one document: the ownership changes,
+ * the mileage readings of the oracles, accidents claimed
+ * with insurers, open recalls, the lien of a bank and the
+ * inspections. Owners no longer have to be asked, nor
+ * given away, the names of the earlier owners.
+ *
+ * Everybody allowed to read the car gets the report, see
+ * 'canRead'. Anybody else buys a report token for the car
+ * with 'purchaseVehicleReport' and redeems it once for the
+ * report with 'redeemVehicleReport'. The fee is split
+ * between the treasury and the owner of the car by
+ * 'report_owner_share' in the configuration. Tokens are
+ * bound to the car and the buyer and kept under
+ * 'reportToken~<vin>~<id>', the id being the purchasing
+ * transaction.
+ *
+ * The report carries the sha256 of its canonical JSON,
+ * taken with an empty 'state_hash'. Anybody can recompute
+ * it from the document to check it was not altered.
+ */
+
+// object type of report token keys
+const reportTokenObjectType string = "reportToken"
+
+// fee name of a vehicle report and its default
+const vehicleReportName string = "vehicle_report"
+const defaultVehicleReportFee int = 5
+
+/*
+ * Hashes a vehicle report without its own hash
+ */
+func hashVehicleReport(report VehicleReport) string {
+	report.StateHash = ""
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	hash := sha256.Sum256(reportAsBytes)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Returns the ledger key of report token 'id' for car 'vin'
+ */
+func getReportTokenKey(stub shim.ChaincodeStubInterface, vin string, id string) (string, error) {
+	key, err := stub.CreateCompositeKey(reportTokenObjectType, []string{vin, id})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating report token key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Writes a report token
+ */
+func saveReportToken(stub shim.ChaincodeStubInterface, token *ReportToken) ([]byte, error) {
+	key, err := getReportTokenKey(stub, token.Vin, token.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenAsBytes, _ := ledgerjson.Marshal(token)
+	err = stub.PutState(key, tokenAsBytes)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error writing report token")
+	}
+
+	return tokenAsBytes, nil
+}
+
+/*
+ * Reads unused report token 'id' of 'buyer' for car 'vin'
+ */
+func getReportToken(stub shim.ChaincodeStubInterface, buyer string, vin string, id string) (*ReportToken, error) {
+	key, err := getReportTokenKey(stub, vin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading report token")
+	} else if tokenAsBytes == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There exists no report token '%s' for car '%s'", id, vin))
+	}
+
+	token := ReportToken{}
+	err = ledgerjson.Unmarshal(tokenAsBytes, &token)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing report token")
+	} else if token.Buyer != buyer {
+		return nil, newError(ErrForbidden, "Forbidden: the report token was bought by somebody else")
+	} else if token.RedeemedTs != 0 {
+		return nil, newError(ErrInvalidState, "The report token is used already")
+	}
+
+	return &token, nil
+}
+
+/*
+ * Buys a one-time token to read the vehicle report
+ * of car 'vin'. The owner of the car gets their share
+ * of the fee, the treasury the rest.
+ *
+ * On success,
+ * returns the token.
+ */
+func (t *CarChaincode) purchaseVehicleReport(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fee := scheduledFee(config, vehicleReportName, 0, defaultVehicleReportFee)
+	share := fee * config.ReportOwnerShare / 100
+
+	// balances are written once per user
+	if owner == username {
+		_, err = t.updateBalance(stub, username, share-fee)
+	} else {
+		_, err = t.updateBalance(stub, username, -fee)
+		if err == nil && share > 0 {
+			_, err = t.updateBalance(stub, owner, share)
+		}
+	}
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = t.collectFee(stub, vehicleReportName, fee-share)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	token := ReportToken{
+		Id:          stub.GetTxID(),
+		Vin:         vin,
+		Buyer:       username,
+		Fee:         fee,
+		OwnerShare:  share,
+		PurchasedTs: now}
+
+	tokenAsBytes, err := saveReportToken(stub, &token)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Vehicle report of car '%s' purchased by '%s'\n", vin, username)
+	return shim.Success(tokenAsBytes)
+}
+
+/*
+ * Reads the mileage readings of car 'vin', earliest first
+ */
+func readMileageReadings(stub shim.ChaincodeStubInterface, vin string) ([]MileageAttestation, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(odometerObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading mileage attestations")
+	}
+	defer iterator.Close()
+
+	readings := []MileageAttestation{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading mileage attestations")
+		}
+
+		reading := MileageAttestation{}
+		err = ledgerjson.Unmarshal(kv.Value, &reading)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing mileage attestation")
+		}
+
+		readings = append(readings, reading)
+	}
+
+	return readings, nil
+}
+
+/*
+ * Puts together the pre-purchase report of 'car' at 'now'
+ */
+func (t *CarChaincode) buildVehicleReport(stub shim.ChaincodeStubInterface, car *Car, now int64) (VehicleReport, error) {
+	report := VehicleReport{
+		Vin:               car.Vin,
+		Brand:             car.Certificate.Brand,
+		Model:             car.Certificate.Model,
+		Numberplate:       car.Certificate.Numberplate,
+		RegisteredTs:      car.Certificate.RegisteredTs,
+		Classification:    car.Classification,
+		Stolen:            car.Stolen,
+		Ownerships:        1,
+		OwnershipChanges:  []OwnershipChange{},
+		Mileage:           []MileageReading{},
+		OdometerStatus:    car.Odometer.Status,
+		Accidents:         []AccidentRecord{},
+		Recalls:           car.Recalls,
+		Lien:              car.Lien,
+		Emission:          car.Emission,
+		RebuildInspection: car.RebuildInspection,
+		NeedsInspection:   car.NeedsInspection,
+		GeneratedTs:       now}
+
+	// every change of hands is in the price history,
+	// the report leaves out the prices
+	records, err := readPriceHistory(stub, car.Vin)
+	if err != nil {
+		return VehicleReport{}, err
+	}
+
+	for _, record := range records {
+		report.OwnershipChanges = append(report.OwnershipChanges, OwnershipChange{Kind: record.Kind, Ts: record.Ts})
+
+		// a reversal goes back to an earlier owner
+		if record.Kind != priceKindReversal {
+			report.Ownerships++
+		}
+	}
+
+	readings, err := readMileageReadings(stub, car.Vin)
+	if err != nil {
+		return VehicleReport{}, err
+	}
+
+	for _, reading := range readings {
+		report.Mileage = append(report.Mileage, MileageReading{Km: reading.Km, ObservedTs: reading.ObservedTs, Oracle: reading.Oracle, Conflicts: reading.Conflicts})
+	}
+
+	claims, err := t.findClaims(stub, func(claim *Claim) bool {
+		return claim.Car == car.Vin
+	})
+	if err != nil {
+		return VehicleReport{}, err
+	}
+
+	for _, claim := range claims {
+		report.Accidents = append(report.Accidents, AccidentRecord{Insurer: claim.Insurer, Amount: claim.Amount, Status: claim.Status, Ts: claim.CreatedTs})
+	}
+
+	report.StateHash = hashVehicleReport(report)
+	return report, nil
+}
+
+/*
+ * Returns the pre-purchase report of car 'vin'
+ * to the DOT and everybody allowed to read the car.
+ *
+ * On success,
+ * returns the report with its hash.
+ */
+func (t *CarChaincode) getVehicleReport(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if role != "dot" {
+		allowed, err := t.canRead(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if !allowed {
+			return errorResponse(ErrForbidden, "Forbidden: the report needs the consent of the owner or a report token, see 'purchaseVehicleReport'")
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	report, err := t.buildVehicleReport(stub, &car, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
+
+/*
+ * Uses report token 'id' of 'username'
+ * for the report of car 'vin'.
+ *
+ * On success,
+ * returns the report with its hash.
+ */
+func (t *CarChaincode) redeemVehicleReport(stub shim.ChaincodeStubInterface, username string, vin string, id string) pb.Response {
+	token, err := getReportToken(stub, username, vin, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	report, err := t.buildVehicleReport(stub, &car, now)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	token.RedeemedTs = now
+	token.StateHash = report.StateHash
+	_, err = saveReportToken(stub, token)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reportAsBytes, _ := ledgerjson.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestVehicleReport(t *testing.T) {
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, "amag", vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "carol", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", "amag", "user", vin, "rear-ended", "30"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", "amag", "user", vin, "bobby"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getVehicleReport", "carol", "user", vin))
+	expectErrorCode(t, response, ErrForbidden)
+
+	// the owner gets 40% of the fee
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "report_owner_share": 40, "fees": { "vehicle_report": 10 } }`))
+	response = stub.MockInvoke("7", util.ToChaincodeArgs("purchaseVehicleReport", "carol", "user", vin))
+	token := ReportToken{}
+	json.Unmarshal(response.Payload, &token)
+	if token.Id != "7" || token.Fee != 10 || token.OwnerShare != 4 {
+		t.Fatalf("Expected a report token for 10, got %v: %s", token, response.Message)
+	}
+
+	for username, balance := range map[string]int{"carol": 90, "bobby": 104} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected a balance of %d for '%s', got %d", balance, username, user.Balance)
+		}
+	}
+
+	// tokens are bound to the buyer and the car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("redeemVehicleReport", "amag", "user", vin, "7"))
+	expectErrorCode(t, response, ErrForbidden)
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("redeemVehicleReport", "carol", "user", "WVWZZZ6R8HY260781", "7"))
+	expectErrorCode(t, response, ErrNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("redeemVehicleReport", "carol", "user", vin, "7"))
+	report := VehicleReport{}
+	json.Unmarshal(response.Payload, &report)
+	if report.Ownerships != 2 || len(report.OwnershipChanges) != 1 || report.OwnershipChanges[0].Kind != priceKindTransfer {
+		t.Errorf("Expected 2 owners, got %d and %v: %s", report.Ownerships, report.OwnershipChanges, response.Message)
+	}
+	if len(report.Accidents) != 1 || report.Accidents[0].Insurer != "axa" || report.Accidents[0].Amount != 30 {
+		t.Errorf("Expected the claim as accident, got %v", report.Accidents)
+	}
+
+	// the hash covers the report
+	if report.StateHash == "" || hashVehicleReport(report) != report.StateHash {
+		t.Errorf("Expected a verifiable state hash, got '%s'", report.StateHash)
+	}
+	report.Ownerships = 1
+	if hashVehicleReport(report) == report.StateHash {
+		t.Error("Altered report should not match the state hash")
+	}
+
+	// and used once
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("redeemVehicleReport", "carol", "user", vin, "7"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// the owner needs no token
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getVehicleReport", "bobby", "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * User reputation.
+ *
+ * The trade modules count what a user did as one key per
+ * event under 'reputation~<user>~<kind>~<vin>~<txid>':
+ * - 'completed_sale': a sale or the final installment,
+ *   for the seller and the buyer
+ * - 'cancelled_deal': backing out of a deposit during the
+ *   hold period, or defaulting on installments
+ * - 'dispute_opened': opening a dispute over a sale
+ * - 'dispute_lost': losing a dispute
+ *
+ * Like ownership links, events are only ever added, so
+ * parallel trades of a dealer do not rewrite the same
+ * record. No route writes them, 'getReputation' adds
+ * them up for counterparties assessing the risk of a deal.
+ */
+
+// object type of reputation keys
+const reputationObjectType string = "reputation"
+
+// kinds of reputation events
+const reputationCompletedSale string = "completed_sale"
+const reputationCancelledDeal string = "cancelled_deal"
+const reputationDisputeOpened string = "dispute_opened"
+const reputationDisputeLost string = "dispute_lost"
+
+// sides of a trade
+const sideSeller string = "seller"
+const sideBuyer string = "buyer"
+
+/*
+ * Counts an event of 'kind' about car 'vin'
+ * in the reputation of 'user'
+ */
+func recordReputation(stub shim.ChaincodeStubInterface, user string, kind string, vin string, counterparty string, side string) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	entry := ReputationEntry{
+		User:         user,
+		Kind:         kind,
+		Vin:          vin,
+		Counterparty: counterparty,
+		Side:         side,
+		Ts:           now,
+		TxId:         stub.GetTxID()}
+
+	key, err := stub.CreateCompositeKey(reputationObjectType, []string{user, kind, vin, entry.TxId})
+	if err != nil {
+		return newError(ErrInternal, "Error creating reputation key")
+	}
+
+	entryAsBytes, _ := ledgerjson.Marshal(entry)
+	err = stub.PutState(key, entryAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing reputation")
+	}
+
+	return nil
+}
+
+/*
+ * Counts a completed sale in the
+ * reputation of seller and buyer
+ */
+func recordCompletedSale(stub shim.ChaincodeStubInterface, seller string, buyer string, vin string) error {
+	err := recordReputation(stub, seller, reputationCompletedSale, vin, buyer, sideSeller)
+	if err != nil {
+		return err
+	}
+
+	return recordReputation(stub, buyer, reputationCompletedSale, vin, seller, sideBuyer)
+}
+
+/*
+ * Reads the events of 'kind' about car 'vin'
+ * in the reputation of 'user'
+ */
+func getReputationEntries(stub shim.ChaincodeStubInterface, user string, kind string, vin string) ([]ReputationEntry, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(reputationObjectType, []string{user, kind, vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading reputation")
+	}
+	defer iterator.Close()
+
+	entries := []ReputationEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading reputation")
+		}
+
+		entry := ReputationEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing reputation")
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+/*
+ * Returns the reputation counters of 'user'.
+ * Anyone can read them.
+ *
+ * On success,
+ * returns the reputation.
+ */
+func (t *CarChaincode) getReputation(stub shim.ChaincodeStubInterface, user string) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(reputationObjectType, []string{user})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading reputation")
+	}
+	defer iterator.Close()
+
+	reputation := Reputation{User: user}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading reputation")
+		}
+
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 4 {
+			return errorResponse(ErrLedger, "Error parsing reputation key")
+		}
+
+		switch attributes[1] {
+		case reputationCompletedSale:
+			reputation.CompletedSales++
+		case reputationCancelledDeal:
+			reputation.CancelledDeals++
+		case reputationDisputeOpened:
+			reputation.DisputesOpened++
+		case reputationDisputeLost:
+			reputation.DisputesLost++
+		}
+	}
+
+	fmt.Printf("Reputation of '%s': %d sales, %d cancelled deals, %d disputes lost\n", user, reputation.CompletedSales, reputation.CancelledDeals, reputation.DisputesLost)
+
+	reputationAsBytes, _ := ledgerjson.Marshal(reputation)
+	return shim.Success(reputationAsBytes)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReputation(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+
+	// the buyer backs out of a deposit
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", seller, "garage", vin, "60"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("placeDeposit", buyer, "user", vin, "20"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("cancelDeposit", buyer, "user", vin))
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+
+	// the buyer loses a dispute over the sale
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("openDispute", buyer, "user", uuid, "engine broken"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveDispute", "judy", "arbiter", uuid, disputeDismissed))
+
+	// anyone reads the counters
+	expected := map[string]Reputation{
+		seller: {User: seller, CompletedSales: 1},
+		buyer:  {User: buyer, CompletedSales: 1, CancelledDeals: 1, DisputesOpened: 1, DisputesLost: 1},
+	}
+	for username, reputation := range expected {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getReputation", "mallory", "user", username))
+		actual := Reputation{}
+		json.Unmarshal(response.Payload, &actual)
+		if actual != reputation {
+			t.Errorf("Expected the reputation %v, got %v", reputation, actual)
+		}
+	}
+}
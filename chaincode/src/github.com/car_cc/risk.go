@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Claims history and risk profile.
+ *
+ * The claim module keeps a claims summary on every user.
+ * Insurers read it as risk profile, e.g. to grant a no-claims
+ * bonus, but only while the user consents to it. Consents
+ * are kept on the user by insurer with their expiry.
+ */
+
+// seconds per year, for years without claims
+const secondsPerYear int64 = 365 * secondsPerDay
+
+/*
+ * Returns the full years without a settled claim,
+ * counted from the start of the history.
+ * Users created before the history was kept have none.
+ */
+func yearsWithoutClaims(history *ClaimsHistory, now int64) int64 {
+	if history.SinceTs == 0 {
+		return 0
+	}
+
+	from := history.SinceTs
+	if history.LastSettledTs > from {
+		from = history.LastSettledTs
+	}
+
+	return (now - from) / secondsPerYear
+}
+
+/*
+ * Counts a filed claim in the history of 'username'
+ */
+func (t *CarChaincode) countFiledClaim(stub shim.ChaincodeStubInterface, username string) error {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return err
+	}
+
+	user.ClaimsHistory.TotalClaims++
+	return t.saveUser(stub, user)
+}
+
+/*
+ * Counts a settled claim in the history of the claimant
+ * and pays out the claim if it is settled on the ledger.
+ *
+ * Both happen in one write, since the transaction
+ * does not read its own writes.
+ */
+func (t *CarChaincode) countSettledClaim(stub shim.ChaincodeStubInterface, claim *Claim, onLedger bool) error {
+	user, err := t.getUser(stub, claim.User)
+	if err != nil {
+		return err
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	if onLedger {
+		user.Balance += claim.Amount
+	}
+
+	user.ClaimsHistory.SettledClaims++
+	user.ClaimsHistory.SettledAmount += claim.Amount
+	user.ClaimsHistory.LastSettledTs = now
+	return t.saveUser(stub, user)
+}
+
+/*
+ * Lets a user consent to 'insurer' reading the
+ * risk profile until 'expiryTs'. An expiry of
+ * 0 withdraws the consent.
+ *
+ * On success,
+ * returns the consents of the user.
+ */
+func (t *CarChaincode) consentRiskProfile(stub shim.ChaincodeStubInterface, username string, insurer string, expiryTs int64) pb.Response {
+	if insurer == "" || insurer == username {
+		return errorResponse(ErrInvalidArgument, "'consentRiskProfile' expects the name of an insurer")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if expiryTs != 0 && expiryTs <= now {
+		return errorResponse(ErrInvalidArgument, "Cannot consent until a date in the past")
+	}
+
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if expiryTs == 0 {
+		delete(user.RiskConsents, insurer)
+	} else {
+		if user.RiskConsents == nil {
+			user.RiskConsents = make(map[string]int64)
+		}
+		user.RiskConsents[insurer] = expiryTs
+	}
+
+	err = t.saveUser(stub, user)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	consentsAsBytes, _ := ledgerjson.Marshal(user.RiskConsents)
+	return shim.Success(consentsAsBytes)
+}
+
+/*
+ * Reads the risk profile of user 'username'
+ * for 'insurer', who needs the user's consent.
+ *
+ * On success,
+ * returns the risk profile.
+ */
+func (t *CarChaincode) getRiskProfile(stub shim.ChaincodeStubInterface, insurer string, username string) pb.Response {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if user.RiskConsents[insurer] <= now {
+		return errorResponse(ErrForbidden, fmt.Sprintf("Forbidden: user '%s' did not consent to '%s' reading the risk profile", username, insurer))
+	}
+
+	profile := RiskProfile{
+		User:               username,
+		History:            user.ClaimsHistory,
+		YearsWithoutClaims: yearsWithoutClaims(&user.ClaimsHistory, now)}
+
+	profileAsBytes, _ := ledgerjson.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
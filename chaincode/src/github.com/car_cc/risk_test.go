@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestYearsWithoutClaims(t *testing.T) {
+	now := 10 * secondsPerYear
+	cases := []struct {
+		history ClaimsHistory
+		years   int64
+	}{
+		{ClaimsHistory{}, 0},
+		{ClaimsHistory{SinceTs: 1}, 9},
+		{ClaimsHistory{SinceTs: 1, LastSettledTs: 7 * secondsPerYear}, 3},
+	}
+
+	for _, c := range cases {
+		if years := yearsWithoutClaims(&c.history, now); years != c.years {
+			t.Errorf("Expected %d years without claims for %v, got %d", c.years, c.history, years)
+		}
+	}
+}
+
+func TestRiskProfile(t *testing.T) {
+	username := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	expiry := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, username, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "axa", "insurer"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getRiskProfile", "axa", "insurer", username))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("consentRiskProfile", username, "user", "axa", expiry))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", username, "user", vin, "rear-ended", "30"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("approveClaim", "axa", "insurer", claim.Id))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("settleClaim", "axa", "insurer", claim.Id, "true"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRiskProfile", "axa", "insurer", username))
+	profile := RiskProfile{}
+	err := json.Unmarshal(response.Payload, &profile)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	history := profile.History
+	if history.SinceTs == 0 || history.TotalClaims != 1 || history.SettledClaims != 1 || history.SettledAmount != 30 || profile.YearsWithoutClaims != 0 {
+		t.Errorf("Unexpected risk profile: %v", profile)
+	}
+
+	// the payout is not lost with the history update
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 130 {
+		t.Errorf("Claimant should have been paid, balance is %d", user.Balance)
+	}
+
+	// other insurers and withdrawn consents are refused
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRiskProfile", "zurich", "insurer", username))
+	expectErrorCode(t, response, ErrForbidden)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("consentRiskProfile", username, "user", "axa", "0"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getRiskProfile", "axa", "insurer", username))
+	expectErrorCode(t, response, ErrForbidden)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Road tax.
+ *
+ * The DOT assesses the annual road tax of a confirmed car
+ * with 'assessRoadTax'. The tax follows the rule of the
+ * jurisdiction of the numberplate in the configuration
+ * under 'road_tax', '' holding the default: a flat part,
+ * an amount per started tonne of the weight on the birth
+ * certificate and a surcharge by emission class of the
+ * latest emission test. Assessments are kept under
+ * 'roadTax~<vin>~<year>', one per car and year.
+ *
+ * The owner pays all unpaid assessments of a car from
+ * their balance with 'payRoadTax', the tax goes to the
+ * treasury. The car carries the due date of its earliest
+ * unpaid assessment, which is in the expiry index. Once
+ * it passes, the 'road_tax' registration rule keeps the
+ * car from being confirmed, and 'processExpirations'
+ * revokes it.
+ */
+
+// object type of road tax assessment keys
+const roadTaxObjectType string = "roadTax"
+
+// fee name of the road tax in the treasury
+const roadTaxName string = "road_tax"
+
+// road tax of jurisdictions without a configured rule
+var defaultRoadTaxRule = RoadTaxRule{Base: 10, PerTonne: 5}
+
+// days to pay an assessment by default
+const defaultRoadTaxDueDays int = 30
+
+/*
+ * Checks if the road tax of a car is overdue at 'now'
+ */
+func IsRoadTaxOverdue(car *Car, now int64) bool {
+	return car.RoadTaxDueTs != 0 && now >= car.RoadTaxDueTs
+}
+
+/*
+ * Returns the road tax rule for 'numberplate'
+ */
+func roadTaxRule(config Config, numberplate string) RoadTaxRule {
+	rule, ok := config.RoadTax[plateJurisdiction(numberplate)]
+	if !ok {
+		rule, ok = config.RoadTax[""]
+	}
+	if !ok {
+		return defaultRoadTaxRule
+	}
+
+	return rule
+}
+
+/*
+ * Checks the road tax rule of a jurisdiction
+ */
+func validateRoadTaxRule(jurisdiction string, rule RoadTaxRule) error {
+	if rule.Base < 0 || rule.PerTonne < 0 || rule.DueDays < 0 {
+		return newError(ErrInvalidArgument, fmt.Sprintf("Amounts and days must not be negative in the road tax of jurisdiction '%s'", jurisdiction))
+	}
+
+	for class := range rule.ClassSurcharges {
+		if _, ok := emissionBadges[class]; !ok {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Unknown emission class '%s' in the road tax of jurisdiction '%s'", class, jurisdiction))
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Computes the road tax of a car of 'weight' kg and
+ * emission class 'class' under 'rule', at least 0
+ */
+func computeRoadTax(rule RoadTaxRule, weight int, class string) int {
+	tonnes := (weight + 999) / 1000
+	tax := rule.Base + tonnes*rule.PerTonne + rule.ClassSurcharges[class]
+	if tax < 0 {
+		return 0
+	}
+
+	return tax
+}
+
+/*
+ * Returns the ledger key of the road tax of car 'vin' in 'year'
+ */
+func getRoadTaxKey(stub shim.ChaincodeStubInterface, vin string, year int) (string, error) {
+	key, err := stub.CreateCompositeKey(roadTaxObjectType, []string{vin, fmt.Sprintf("%04d", year)})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating road tax key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Writes a road tax assessment
+ */
+func saveTaxAssessment(stub shim.ChaincodeStubInterface, assessment *TaxAssessment) error {
+	key, err := getRoadTaxKey(stub, assessment.Vin, assessment.Year)
+	if err != nil {
+		return err
+	}
+
+	assessmentAsBytes, _ := ledgerjson.Marshal(assessment)
+	err = stub.PutState(key, assessmentAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing road tax assessment")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the road tax assessments of car 'vin', earliest year first
+ */
+func getTaxAssessments(stub shim.ChaincodeStubInterface, vin string) ([]TaxAssessment, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(roadTaxObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading road tax assessments")
+	}
+	defer iterator.Close()
+
+	assessments := []TaxAssessment{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading road tax assessments")
+		}
+
+		assessment := TaxAssessment{}
+		err = ledgerjson.Unmarshal(kv.Value, &assessment)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing road tax assessment")
+		}
+
+		assessments = append(assessments, assessment)
+	}
+
+	return assessments, nil
+}
+
+/*
+ * Assesses the road tax of confirmed car 'vin' for 'year'.
+ *
+ * On success,
+ * returns the assessment.
+ */
+func (t *CarChaincode) assessRoadTax(stub shim.ChaincodeStubInterface, username string, vin string, year int) pb.Response {
+	if year < 1000 || year > 9999 {
+		return errorResponse(ErrInvalidArgument, "'assessRoadTax' expects a four digit year")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Certificate.Numberplate == "" {
+		return errorResponse(ErrInvalidState, "Only confirmed cars are assessed for road tax")
+	}
+
+	key, err := getRoadTaxKey(stub, vin, year)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading road tax assessment")
+	} else if existing != nil {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Road tax of car '%s' for %d is assessed already", vin, year))
+	}
+
+	birth, err := getBirthCertificate(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	weight := 0
+	if birth != nil {
+		weight = birth.Data.Weight
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	rule := roadTaxRule(config, car.Certificate.Numberplate)
+	dueDays := rule.DueDays
+	if dueDays <= 0 {
+		dueDays = defaultRoadTaxDueDays
+	}
+
+	assessment := TaxAssessment{
+		Vin:           vin,
+		Year:          year,
+		Jurisdiction:  plateJurisdiction(car.Certificate.Numberplate),
+		Owner:         owner,
+		Weight:        weight,
+		EmissionClass: car.Emission.Class,
+		Amount:        computeRoadTax(rule, weight, car.Emission.Class),
+		AssessedBy:    username,
+		AssessedTs:    now,
+		DueTs:         now + int64(dueDays)*secondsPerDay}
+
+	// an assessment without tax is paid right away
+	if assessment.Amount == 0 {
+		assessment.PaidTs = now
+	}
+
+	err = saveTaxAssessment(stub, &assessment)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// the car is due with its earliest unpaid assessment
+	if assessment.PaidTs == 0 && (car.RoadTaxDueTs == 0 || assessment.DueTs < car.RoadTaxDueTs) {
+		err = updateExpiry(stub, expiryRoadTax, vin, car.RoadTaxDueTs, assessment.DueTs)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		car.RoadTaxDueTs = assessment.DueTs
+		carAsBytes, _ := ledgerjson.Marshal(car)
+		err = stub.PutState(vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+	}
+
+	fmt.Printf("Road tax of %d assessed on car '%s' for %d\n", assessment.Amount, vin, year)
+
+	assessmentAsBytes, _ := ledgerjson.Marshal(assessment)
+	return shim.Success(assessmentAsBytes)
+}
+
+/*
+ * Pays all unpaid road tax assessments of car 'vin'
+ * from the balance of the owner.
+ *
+ * On success,
+ * returns the paid assessments.
+ */
+func (t *CarChaincode) payRoadTax(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	assessments, err := getTaxAssessments(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	paid := []TaxAssessment{}
+	total := 0
+	for _, assessment := range assessments {
+		if assessment.PaidTs != 0 {
+			continue
+		}
+
+		assessment.PaidBy = username
+		assessment.PaidTs = now
+		err = saveTaxAssessment(stub, &assessment)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		paid = append(paid, assessment)
+		total += assessment.Amount
+	}
+
+	if len(paid) == 0 {
+		return errorResponse(ErrInvalidState, "There is no unpaid road tax on the car")
+	}
+
+	err = t.chargeFee(stub, username, roadTaxName, total)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = updateExpiry(stub, expiryRoadTax, vin, car.RoadTaxDueTs, 0)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.RoadTaxDueTs = 0
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Road tax of %d paid on car '%s' by '%s'\n", total, vin, username)
+
+	paidAsBytes, _ := ledgerjson.Marshal(paid)
+	return shim.Success(paidAsBytes)
+}
+
+/*
+ * Lists the road tax assessments of car 'vin'
+ * for the owner and the DOT.
+ *
+ * On success,
+ * returns the assessments, earliest year first.
+ */
+func (t *CarChaincode) readTaxAssessments(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	if role != "dot" {
+		_, err := t.getCar(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	assessments, err := getTaxAssessments(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	assessmentsAsBytes, _ := ledgerjson.Marshal(assessments)
+	return shim.Success(assessmentsAsBytes)
+}
+
+/*
+ * Sets the road tax rule of a jurisdiction,
+ * '' for the default.
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setRoadTaxRule(stub shim.ChaincodeStubInterface, jurisdiction string, ruleJson string) pb.Response {
+	rule := RoadTaxRule{}
+	err := ledgerjson.Unmarshal([]byte(ruleJson), &rule)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'setRoadTaxRule' expects a JSON road tax rule")
+	}
+
+	err = validateRoadTaxRule(jurisdiction, rule)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if config.RoadTax == nil {
+		config.RoadTax = make(map[string]RoadTaxRule)
+	}
+
+	config.RoadTax[jurisdiction] = rule
+
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRoadTax(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	sheet := `{ "brand": "VW", "model": "Polo", "weight": 1160, "engine_number": "DKL042311", "color_codes": ["LC9X"] }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	balance := func() int {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", owner, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		return user.Balance
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("manufacture", "volkswagen", "manufacturer", vin, sheet))
+	insureCar(t, stub, owner, vin, "axa")
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("assessRoadTax", "inspector", "dot", vin, "2025"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setRoadTaxRule", "inspector", "dot", "ZH", `{ "base": 20, "per_tonne": 10, "class_surcharges": { "euro9": 5 } }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setRoadTaxRule", "inspector", "dot", "ZH", `{ "base": 20, "per_tonne": 10 }`))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assessRoadTax", owner, "user", vin, "2025"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	// 20 and 10 for each of the 2 started tonnes
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assessRoadTax", "inspector", "dot", vin, "2025"))
+	assessment := TaxAssessment{}
+	json.Unmarshal(response.Payload, &assessment)
+	if assessment.Amount != 40 || assessment.Weight != 1160 || assessment.Jurisdiction != "ZH" {
+		t.Fatalf("Expected a road tax of 40 in 'ZH', got %v: %s", assessment, response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("assessRoadTax", "inspector", "dot", vin, "2025"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.RoadTaxDueTs != assessment.DueTs {
+		t.Errorf("Expected the car due with the assessment, got %d", car.RoadTaxDueTs)
+	}
+
+	before := balance()
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("payRoadTax", owner, "user", vin))
+	if paid := before - balance(); paid != 40 {
+		t.Errorf("Expected 40 paid, got %d", paid)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("payRoadTax", owner, "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// overdue road tax revokes the car
+	config := Config{RegistrationRules: map[string][]RegistrationRule{"": {{Requirement: ruleRoadTax}}}}
+	if changed, revoked := expireCredentials(config, &car, assessment.DueTs); !changed || !revoked {
+		t.Error("Car with overdue road tax should be revoked")
+	}
+}
@@ -0,0 +1,2449 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Routing table of the invoke functions.
+ *
+ * Every function declares its arguments, the roles
+ * allowed to call it and whether it only reads the
+ * ledger. 'Invoke' checks all of that before calling
+ * the handler, so handlers only check what depends on
+ * the ledger, like ownership or mandates.
+ */
+
+/*
+ * One call of an invoke function, after
+ * 'username' and 'role' were split off
+ */
+type invocation struct {
+	function string
+	username string
+	role     string
+	args     []string
+
+	// the stub as passed by the peer, without journal
+	ledger shim.ChaincodeStubInterface
+}
+
+type handler func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response
+
+type route struct {
+	args FunctionSchema
+
+	// roles allowed to call the function, any role if empty
+	roles []string
+	// what the roles are allowed to do, for the error message
+	action string
+
+	// read-only functions must not change the ledger
+	readOnly bool
+	// accepts an idempotency key, see 'invokeOnce'
+	idempotent bool
+	// may run before the state is migrated, see 'migrate'
+	anySchema bool
+	// may be simulated without writing, see 'simulationStub'
+	simulatable bool
+
+	handler handler
+}
+
+/*
+ * Returns an error if 'role' may not call the function
+ */
+func (r route) checkRole(role string) error {
+	if len(r.roles) == 0 {
+		return nil
+	}
+
+	for _, allowed := range r.roles {
+		if role == allowed {
+			return nil
+		}
+	}
+
+	return newError(ErrForbiddenRole, fmt.Sprintf("Sorry, role '%s' is not allowed to %s.", role, r.action))
+}
+
+/*
+ * Returns the names of all invoke functions, sorted
+ */
+func functionNames() []string {
+	names := []string{}
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filled in 'init', as handlers like 'getSchemas' read the table
+var routes map[string]route
+
+func init() {
+	routes = map[string]route{
+
+		// GENERAL FUNCTIONS
+		"read": {
+			args:     args(textArg("key")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				if reflect.TypeOf(call.ledger).String() != "*shim.MockStub" {
+					// only allow unrestricted queries from the test files
+					return errorResponse(ErrForbiddenRole, fmt.Sprintf("Sorry, role '%s' is not allowed to do unrestricted queries on the ledger.", call.role))
+				}
+				return t.read(stub, call.args[0])
+			},
+		},
+
+		"getSchemas": {
+			args:     optionalArgs(0, textArg("function")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getSchemas(stub, call.args)
+			},
+		},
+
+		"migrate": {
+			args:      args(),
+			anySchema: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.migrate(stub, call.role)
+			},
+		},
+
+		"readCar": {
+			args:     optionalArgs(1, textArg("vin"), jsonArg("fields", &Schema{Type: "array", Items: &Schema{Type: "string"}})),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readCar(stub, call.username, call.args)
+			},
+		},
+
+		"getVehicleReport": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getVehicleReport(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"purchaseVehicleReport": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.purchaseVehicleReport(stub, call.username, call.args[0])
+			},
+		},
+
+		"redeemVehicleReport": {
+			args: args(textArg("vin"), textArg("token id")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.redeemVehicleReport(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"getCarHistory": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getCarHistory(stub, call.username, call.args[0])
+			},
+		},
+
+		"getCertificate": {
+			args:     optionalArgs(1, textArg("vin"), integerArg("version")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getCertificate(stub, call.args)
+			},
+		},
+
+		"lookupCar": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.lookupCar(stub, call.args[0])
+			},
+		},
+
+		"fileRecall": {
+			args:   args(textArg("vin"), textArg("campaign")),
+			roles:  []string{"dot"},
+			action: "file recalls",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.fileRecall(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"reportStolen": {
+			args: args(textArg("vin"), booleanArg("stolen")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				stolen, err := strconv.ParseBool(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'reportStolen' expects 'true' or 'false' as stolen flag")
+				}
+				return t.reportStolen(stub, call.username, call.role, call.args[0], stolen)
+			},
+		},
+
+		"addCoOwner": {
+			args: args(textArg("vin"), textArg("co-owner"), integerArg("share in percent")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				share, err := strconv.Atoi(call.args[2])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'addCoOwner' expects the share as integer percent")
+				}
+				return t.changeCoOwnership(stub, call.username, call.args[0], CoOwnerChange{Action: coOwnerAdd, User: call.args[1], Share: share})
+			},
+		},
+
+		"removeCoOwner": {
+			args: args(textArg("vin"), textArg("co-owner")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.changeCoOwnership(stub, call.username, call.args[0], CoOwnerChange{Action: coOwnerRemove, User: call.args[1]})
+			},
+		},
+
+		"setCoOwnerQuorum": {
+			args: args(textArg("vin"), integerArg("quorum in percent")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				quorum, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'setCoOwnerQuorum' expects the quorum as integer percent")
+				}
+				return t.changeCoOwnership(stub, call.username, call.args[0], CoOwnerChange{Action: coOwnerQuorum, Quorum: quorum})
+			},
+		},
+
+		"approveTransfer": {
+			args: args(textArg("vin"), textArg("receiver")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveTransfer(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"grantMandate": {
+			args: args(textArg("vin"), textArg("agent"), textArg("operations"), timestampArg("expiry")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				expiryTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'grantMandate' expects the expiry as unix timestamp")
+				}
+				return t.grantMandate(stub, call.username, call.args[0], call.args[1], call.args[2], expiryTs)
+			},
+		},
+
+		"revokeMandate": {
+			args: args(textArg("vin"), textArg("agent")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revokeMandate(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"createFleet": {
+			args: args(textArg("fleet")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.createFleet(stub, call.username, call.args[0])
+			},
+		},
+
+		"addFleetMember": {
+			args: args(textArg("fleet"), textArg("member"), enumArg("fleet role", "admin", "driver")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.addFleetMember(stub, call.username, call.args)
+			},
+		},
+
+		"assignDriver": {
+			args: args(textArg("vin"), textArg("driver")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.assignDriver(stub, call.username, call.args[0], call.args[1], true)
+			},
+		},
+
+		"unassignDriver": {
+			args: args(textArg("vin"), textArg("driver")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.assignDriver(stub, call.username, call.args[0], call.args[1], false)
+			},
+		},
+
+		"startRental": {
+			args: args(textArg("vin"), textArg("renter"), timestampArg("start"), timestampArg("end"), integerArg("deposit")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				startTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'startRental' expects the start as unix timestamp")
+				}
+				endTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'startRental' expects the end as unix timestamp")
+				}
+				deposit, err := strconv.Atoi(call.args[4])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'startRental' expects the deposit as integer")
+				}
+				return t.startRental(stub, call.username, call.args[0], Rental{Renter: call.args[1], StartTs: startTs, EndTs: endTs, Deposit: deposit})
+			},
+		},
+
+		"acceptRental": {
+			args:       args(textArg("vin")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.acceptRental(stub, call.username, call.args[0])
+			},
+		},
+
+		"reportDamage": {
+			args: args(textArg("vin"), textArg("description")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.reportDamage(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"endRental": {
+			args:       args(textArg("vin"), integerArg("damages")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				damages, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'endRental' expects the damages as integer")
+				}
+				return t.endRental(stub, call.username, call.args[0], damages)
+			},
+		},
+
+		"offerInstallments": {
+			args: args(textArg("vin"), textArg("buyer"), integerArg("price"), integerArg("installments"), integerArg("period days")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				price, err := strconv.Atoi(call.args[2])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'offerInstallments' expects the price as integer")
+				}
+				count, err := strconv.Atoi(call.args[3])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'offerInstallments' expects the number of installments as integer")
+				}
+				periodDays, err := strconv.Atoi(call.args[4])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'offerInstallments' expects the period in days as integer")
+				}
+				plan := InstallmentPlan{Buyer: call.args[1], Price: price, Count: count, PeriodDays: periodDays}
+				return t.offerInstallments(stub, call.username, call.args[0], plan)
+			},
+		},
+
+		"acceptInstallments": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.acceptInstallments(stub, call.username, call.args[0])
+			},
+		},
+
+		"payInstallment": {
+			args:       args(textArg("vin")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.payInstallment(stub, call.username, call.args[0])
+			},
+		},
+
+		"reclaimCar": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.reclaimCar(stub, call.username, call.args[0])
+			},
+		},
+
+		"listCar": {
+			args: args(textArg("vin"), integerArg("price")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				price, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'listCar' expects the price as integer")
+				}
+				return t.listCar(stub, call.username, call.args[0], price)
+			},
+		},
+
+		"unlistCar": {
+			args:       args(textArg("vin")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.unlistCar(stub, call.username, call.args[0])
+			},
+		},
+
+		"placeDeposit": {
+			args:       args(textArg("vin"), integerArg("amount")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				amount, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'placeDeposit' expects the amount as integer")
+				}
+				return t.placeDeposit(stub, call.username, call.args[0], amount)
+			},
+		},
+
+		"cancelDeposit": {
+			args:       args(textArg("vin")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.cancelDeposit(stub, call.username, call.args[0])
+			},
+		},
+
+		"joinQueue": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.joinQueue(stub, call.username, call.args[0])
+			},
+		},
+
+		"leaveQueue": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.leaveQueue(stub, call.username, call.args[0])
+			},
+		},
+
+		"skipQueuedBuyer": {
+			args: args(textArg("vin"), textArg("reason")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// agents act with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateSell)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.skipQueuedBuyer(stub, principal, call.args[0], call.args[1])
+			},
+		},
+
+		"getQueue": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getQueue(stub, call.args[0])
+			},
+		},
+
+		"getQueueSkips": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getQueueSkips(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"requestLoan": {
+			args: args(textArg("vin"), textArg("bank"), integerArg("amount")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				amount, err := strconv.Atoi(call.args[2])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'requestLoan' expects the amount as integer")
+				}
+				return t.requestLoan(stub, call.username, call.args[0], call.args[1], amount)
+			},
+		},
+
+		"approveLoan": {
+			args: optionalArgs(1, textArg("loan id"), integerArg("rate"), integerArg("installments"), integerArg("period")),
+			// only banks are allowed to fund loans
+			roles:  []string{"bank"},
+			action: "fund loans",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// without terms, the loan is due at once after a period, free of interest
+				terms := LoanTerms{Installments: 1, PeriodDays: defaultLoanPeriodDays}
+				if len(call.args) > 1 {
+					terms.Rate, _ = strconv.Atoi(call.args[1])
+				}
+				if len(call.args) > 2 {
+					terms.Installments, _ = strconv.Atoi(call.args[2])
+				}
+				if len(call.args) > 3 {
+					terms.PeriodDays, _ = strconv.Atoi(call.args[3])
+				}
+				return t.approveLoan(stub, call.username, call.args[0], terms)
+			},
+		},
+
+		"rejectLoan": {
+			args:   optionalArgs(1, textArg("loan id"), textArg("reason")),
+			roles:  []string{"bank"},
+			action: "reject loans",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				reason := ""
+				if len(call.args) > 1 {
+					reason = call.args[1]
+				}
+				return t.rejectLoan(stub, call.username, call.args[0], reason)
+			},
+		},
+
+		"repayLoan": {
+			args: args(textArg("vin"), integerArg("amount")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				amount, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'repayLoan' expects the amount as integer")
+				}
+				return t.repayLoan(stub, call.username, call.args[0], amount)
+			},
+		},
+
+		"getLoanStatus": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getLoanStatus(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"readLoan": {
+			args:     args(textArg("loan id")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readLoan(stub, call.username, call.args[0])
+			},
+		},
+
+		"setSubsidyProgram": {
+			args: args(textArg("program"), integerArg("budget")),
+			// only the government runs subsidy programs
+			roles:  []string{"government"},
+			action: "run subsidy programs",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				budget, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'setSubsidyProgram' expects the budget as integer")
+				}
+				return t.setSubsidyProgram(stub, call.username, call.args[0], budget)
+			},
+		},
+
+		"grantSubsidy": {
+			args:   args(textArg("vin"), integerArg("amount"), textArg("program")),
+			roles:  []string{"government"},
+			action: "grant subsidies",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				amount, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'grantSubsidy' expects the amount as integer")
+				}
+				return t.grantSubsidy(stub, call.username, call.args[0], amount, call.args[2])
+			},
+		},
+
+		"readSubsidyProgram": {
+			args:     args(textArg("program")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readSubsidyProgram(stub, call.args[0])
+			},
+		},
+
+		"assessRoadTax": {
+			args: args(textArg("vin"), integerArg("year")),
+			// only the DOT assesses road tax
+			roles:  []string{"dot"},
+			action: "assess road tax",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				year, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'assessRoadTax' expects the year as integer")
+				}
+				return t.assessRoadTax(stub, call.username, call.args[0], year)
+			},
+		},
+
+		"payRoadTax": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.payRoadTax(stub, call.username, call.args[0])
+			},
+		},
+
+		"readTaxAssessments": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readTaxAssessments(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"linkTollAccount": {
+			args: args(textArg("vin"), textArg("operator"), textArg("account")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.linkTollAccount(stub, call.username, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"unlinkTollAccount": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.unlinkTollAccount(stub, call.username, call.args[0])
+			},
+		},
+
+		"postTolls": {
+			// bad postings fail one by one
+			args:       args(jsonArg("postings", &Schema{Type: "array", MinItems: 1, Items: ref("TollPosting")})),
+			roles:      []string{"toll_operator"},
+			action:     "post tolls",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.postTolls(stub, call.username, call.args[0])
+			},
+		},
+
+		"settleTollDebt": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.settleTollDebt(stub, call.username, call.args[0])
+			},
+		},
+
+		"issueFine": {
+			args:   optionalArgs(3, textArg("vin"), integerArg("amount"), textArg("reason"), integerArg("due days")),
+			roles:  []string{"police", "municipality"},
+			action: "issue fines",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				amount, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'issueFine' expects the amount as integer")
+				}
+				dueDays := 0
+				if len(call.args) > 3 {
+					dueDays, err = strconv.Atoi(call.args[3])
+					if err != nil {
+						return errorResponse(ErrInvalidArgument, "'issueFine' expects the days to pay as integer")
+					}
+				}
+				return t.issueFine(stub, call.username, call.args[0], amount, call.args[2], dueDays)
+			},
+		},
+
+		"payFine": {
+			args: args(textArg("vin"), textArg("fine id")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.payFine(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"contestFine": {
+			args: args(textArg("vin"), textArg("fine id"), textArg("reason")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.contestFine(stub, call.username, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"resolveFine": {
+			args: optionalArgs(3, textArg("vin"), textArg("fine id"), enumArg("outcome", fineOutcomes...), textArg("note")),
+			// contested fines are settled like disputes
+			roles:  []string{"arbiter"},
+			action: "resolve fines",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				note := ""
+				if len(call.args) > 3 {
+					note = call.args[3]
+				}
+				return t.resolveFine(stub, call.username, call.args[0], call.args[1], call.args[2], note)
+			},
+		},
+
+		"getFines": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getFines(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"getReputation": {
+			args:     args(textArg("user")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getReputation(stub, call.args[0])
+			},
+		},
+
+		"openDispute": {
+			args: optionalArgs(2, textArg("deal id"), textArg("reason"), integerArg("claim")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				claim := 0
+				if len(call.args) > 2 {
+					claim, _ = strconv.Atoi(call.args[2])
+				}
+				return t.openDispute(stub, call.username, call.args[0], call.args[1], claim)
+			},
+		},
+
+		"addDisputeEvidence": {
+			args: optionalArgs(2, textArg("dispute id"), &Schema{Title: "document hash", Type: "string", Pattern: "^[0-9a-f]{64}$", Description: "hex encoded sha256"}, textArg("description")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				description := ""
+				if len(call.args) > 2 {
+					description = call.args[2]
+				}
+				return t.addDisputeEvidence(stub, call.username, call.role, call.args[0], call.args[1], description)
+			},
+		},
+
+		"readDispute": {
+			args:     args(textArg("dispute id")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readDispute(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"resolveDispute": {
+			args: optionalArgs(2, textArg("dispute id"), enumArg("outcome", disputeOutcomes...), textArg("note")),
+			// only arbiters are allowed to settle disputes
+			roles:  []string{"arbiter"},
+			action: "resolve disputes",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				note := ""
+				if len(call.args) > 2 {
+					note = call.args[2]
+				}
+				return t.resolveDispute(stub, call.username, call.args[0], call.args[1], note)
+			},
+		},
+
+		"getOpenDisputes": {
+			args:     args(),
+			roles:    []string{"arbiter"},
+			action:   "resolve disputes",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getOpenDisputes(stub)
+			},
+		},
+
+		"enrollDevice": {
+			args: args(textArg("vin"), textArg("device identity hash")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.enrollDevice(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"revokeDevice": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revokeDevice(stub, call.username, call.args[0])
+			},
+		},
+
+		"logTrip": {
+			args: args(textArg("vin"), timestampArg("start"), timestampArg("end"), integerArg("distance in km")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				startTs, err := strconv.ParseInt(call.args[1], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'logTrip' expects the start as unix timestamp")
+				}
+				endTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'logTrip' expects the end as unix timestamp")
+				}
+				km, err := strconv.Atoi(call.args[3])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'logTrip' expects the distance as integer km")
+				}
+				// the device is checked by its identity, not the username
+				return t.logTrip(stub, call.args[0], Trip{StartTs: startTs, EndTs: endTs, Km: km})
+			},
+		},
+
+		"registerOracle": {
+			args:   args(textArg("name"), textArg("identity hash"), textArg("MSP id"), textArg("kind"), booleanArg("active")),
+			roles:  []string{"dot"},
+			action: "register oracles",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				active, err := strconv.ParseBool(call.args[4])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'registerOracle' expects 'true' or 'false' for active")
+				}
+				return t.registerOracle(stub, Oracle{Name: call.args[0], Identity: call.args[1], Msp: call.args[2], Kind: call.args[3], Active: active})
+			},
+		},
+
+		"certifyGarage": {
+			args:   args(textArg("garage"), enumArg("scope", certificationScopes...), timestampArg("expiry"), textArg("certificate reference")),
+			roles:  []string{"dot"},
+			action: "certify garages",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				expiresTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'certifyGarage' expects the expiry as unix timestamp")
+				}
+				return t.certifyGarage(stub, call.username, call.args[0], call.args[1], expiresTs, call.args[3])
+			},
+		},
+
+		"revokeGarageCertification": {
+			args:   args(textArg("garage"), enumArg("scope", certificationScopes...), textArg("reason")),
+			roles:  []string{"dot"},
+			action: "certify garages",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revokeGarageCertification(stub, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"getGarageCertifications": {
+			args:     args(textArg("garage")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getGarageCertifications(stub, call.args[0])
+			},
+		},
+
+		"attestMileage": {
+			args: args(textArg("vin"), integerArg("mileage in km"), timestampArg("reading time")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				km, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'attestMileage' expects the mileage as integer km")
+				}
+				observedTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'attestMileage' expects the reading time as unix timestamp")
+				}
+				// the oracle is checked by its identity, not the username
+				return t.attestMileage(stub, call.args[0], MileageAttestation{Km: km, ObservedTs: observedTs})
+			},
+		},
+
+		"resolveOdometerDiscrepancy": {
+			args:   args(textArg("vin"), textArg("resolution")),
+			roles:  []string{"dot"},
+			action: "resolve odometer discrepancies",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.resolveOdometerDiscrepancy(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"recordEmissionTest": {
+			args: args(textArg("vin"), integerArg("CO2 in g/km"), textArg("emission class"), timestampArg("expiry")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				co2, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordEmissionTest' expects CO2 as integer g/km")
+				}
+				expiryTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordEmissionTest' expects the expiry as unix timestamp")
+				}
+				// the station is checked by its identity, not the username,
+				// garages running the station need a certification, too
+				if call.role == "garage" {
+					err = requireCertification(stub, call.username, scopeEmission)
+					if err != nil {
+						return errorResponseFrom(err)
+					}
+				}
+				return t.recordEmissionTest(stub, call.args[0], EmissionTest{Co2: co2, Class: call.args[2], ExpiresTs: expiryTs})
+			},
+		},
+
+		"getRegistrationRules": {
+			args:     args(textArg("jurisdiction")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getRegistrationRules(stub, call.args[0])
+			},
+		},
+
+		"getEmissionStatus": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getEmissionStatus(stub, call.args[0])
+			},
+		},
+
+		"recordBatteryHealth": {
+			args:   args(textArg("vin"), numberArg("state of health in percent"), integerArg("cycles"), numberArg("capacity in kWh")),
+			roles:  []string{"garage"},
+			action: "record battery health",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				stateOfHealth, err := strconv.ParseFloat(call.args[1], 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects the state of health as number")
+				}
+				cycles, err := strconv.Atoi(call.args[2])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects the cycle count as integer")
+				}
+				capacityKwh, err := strconv.ParseFloat(call.args[3], 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordBatteryHealth' expects the capacity as number")
+				}
+				err = requireCertification(stub, call.username, scopeEvHighVoltage)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.recordBatteryHealth(stub, call.username, call.args[0], BatteryHealth{StateOfHealth: stateOfHealth, Cycles: cycles, CapacityKwh: capacityKwh})
+			},
+		},
+
+		"recordAppraisal": {
+			args:   args(textArg("vin"), integerArg("value"), textArg("methodology"), timestampArg("appraisal date")),
+			roles:  []string{"appraiser"},
+			action: "record appraisals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				value, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordAppraisal' expects the value as integer")
+				}
+				appraisedTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'recordAppraisal' expects the appraisal date as unix timestamp")
+				}
+				// the appraiser is checked by its identity
+				return t.recordAppraisal(stub, call.username, call.args[0], Appraisal{Value: value, Methodology: call.args[2], AppraisedTs: appraisedTs})
+			},
+		},
+
+		"getAppraisals": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// the DOT reads every car, others need a read grant of the owner
+				return t.getAppraisals(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"addWarranty": {
+			args:   args(textArg("vin"), textArg("coverage"), timestampArg("start"), timestampArg("end"), integerArg("km limit")),
+			roles:  []string{"manufacturer", "garage"},
+			action: "grant warranties",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				startTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'addWarranty' expects the start as unix timestamp")
+				}
+				endTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'addWarranty' expects the end as unix timestamp")
+				}
+				kmLimit, err := strconv.Atoi(call.args[4])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'addWarranty' expects the km limit as integer")
+				}
+				return t.addWarranty(stub, call.username, call.role, call.args[0], Warranty{Coverage: call.args[1], StartTs: startTs, EndTs: endTs, KmLimit: kmLimit})
+			},
+		},
+
+		"voidWarranty": {
+			args: args(textArg("vin"), integerArg("warranty id"), textArg("reason")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				id, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'voidWarranty' expects the warranty id as integer")
+				}
+				return t.voidWarranty(stub, call.username, call.args[0], id, call.args[2])
+			},
+		},
+
+		"getWarranties": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getWarranties(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"replacePart": {
+			args:   args(textArg("vin"), textArg("part type"), textArg("serial")),
+			roles:  []string{"garage"},
+			action: "replace parts",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// garages service customer cars with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateService)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				// traction batteries are high-voltage work
+				if call.args[1] == partBattery {
+					err = requireCertification(stub, call.username, scopeEvHighVoltage)
+					if err != nil {
+						return errorResponseFrom(err)
+					}
+				}
+				return t.replacePart(stub, principal, call.username, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"requestModificationApproval": {
+			args:   optionalArgs(3, textArg("vin"), textArg("description"), jsonArg("part document hashes", &Schema{Type: "array", Items: &Schema{Type: "string", Pattern: "^[0-9a-f]{64}$"}}), booleanArg("structural")),
+			roles:  []string{"user", "garage"},
+			action: "request modification approvals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// garages modify customer cars with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateService)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.requestModificationApproval(stub, principal, call.username, call.args)
+			},
+		},
+
+		"getModifications": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getModifications(stub, call.args[0])
+			},
+		},
+
+		"attachDocument": {
+			args:   optionalArgs(3, textArg("vin"), enumArg("document type", attachmentTypes...), &Schema{Title: "document hash", Type: "string", Pattern: "^[0-9a-f]{64}$", Description: "hex encoded sha256"}, &Schema{Title: "uri", Type: "string", Pattern: "^((https|ipfs)://.+)?$"}),
+			roles:  []string{"user", "garage"},
+			action: "attach documents",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// garages attach invoices and photos with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateService)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				// and inspection reports only if certified
+				if call.role == "garage" && call.args[1] == "inspection_report" {
+					err = requireCertification(stub, call.username, scopeInspection)
+					if err != nil {
+						return errorResponseFrom(err)
+					}
+				}
+				return t.attachDocument(stub, principal, call.username, call.args)
+			},
+		},
+
+		"getDocuments": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getDocuments(stub, call.username, call.args[0])
+			},
+		},
+
+		"registerOrganizationCertificate": {
+			args:   args(textArg("organization"), enumArg("kind", organizationKinds...), textArg("PEM encoded certificate")),
+			roles:  []string{"dot"},
+			action: "register organization certificates",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.registerOrganizationCertificate(stub, call.args)
+			},
+		},
+
+		"verifyDocumentSignature": {
+			args: args(textArg("vin"), &Schema{Title: "document hash", Type: "string", Pattern: "^[0-9a-f]{64}$", Description: "hex encoded sha256"}, textArg("organization"), textArg("base64 encoded signature")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.verifyDocumentSignature(stub, call.username, call.args)
+			},
+		},
+
+		"getPartHistory": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getPartHistory(stub, call.args[0])
+			},
+		},
+
+		"getPriceHistory": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// the DOT reads every car, others need a read grant of the owner
+				return t.getPriceHistory(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"compareCars": {
+			args:     args(textArg("vin"), textArg("other vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// the DOT reads every car, others need a read grant of the owner
+				return t.compareCars(stub, call.username, call.role, call.args[0], call.args[1])
+			},
+		},
+
+		"averagePriceByModel": {
+			args:     args(textArg("brand"), textArg("model")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.averagePriceByModel(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"grantReadAccess": {
+			args: args(textArg("vin"), textArg("reader"), timestampArg("expiry")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.grantReadAccess(stub, call.username, call.args)
+			},
+		},
+
+		"revokeReadAccess": {
+			args: args(textArg("vin"), textArg("reader")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revokeReadAccess(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"consentRiskProfile": {
+			args: args(textArg("insurer"), timestampArg("expiry, 0 to withdraw")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				expiryTs, err := strconv.ParseInt(call.args[1], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'consentRiskProfile' expects the expiry as unix timestamp")
+				}
+				return t.consentRiskProfile(stub, call.username, call.args[0], expiryTs)
+			},
+		},
+
+		"getRiskProfile": {
+			args:     args(textArg("user")),
+			roles:    []string{"insurer"},
+			action:   "read risk profiles",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getRiskProfile(stub, call.username, call.args[0])
+			},
+		},
+
+		// USER FUNCTIONS
+		"createUser": {
+			args: args(),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.createUser(stub, call.username)
+			},
+		},
+
+		"readUser": {
+			args:     args(),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readUser(stub, call.username)
+			},
+		},
+
+		"deleteUser": {
+			args: args(textArg("remaining balance recipient")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.deleteUser(stub, call.username, call.args[0])
+			},
+		},
+
+		"setPersonalData": {
+			// the personal data is passed as transient data
+			args: args(),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setPersonalData(stub, call.username)
+			},
+		},
+
+		"readPersonalData": {
+			args:     args(),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readPersonalData(stub, call.username)
+			},
+		},
+
+		"getMyProfile": {
+			args:     args(),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getMyProfile(stub, call.username)
+			},
+		},
+
+		"updateProfile": {
+			// the profile is passed as transient data
+			args: args(),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.updateProfile(stub, call.username)
+			},
+		},
+
+		"transfer": {
+			args: args(textArg("vin"), textArg("receiver")),
+			// only allow users and garage users to transer cars
+			roles:       []string{"user", "garage"},
+			action:      "transfer cars",
+			idempotent:  true,
+			simulatable: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// agents act with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateTransfer)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.transfer(stub, principal, call.args)
+			},
+		},
+
+		"revocationProposal": {
+			args:   args(textArg("vin")),
+			roles:  []string{"user"},
+			action: "create a revocation proposal",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateRevoke)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.revocationProposal(stub, principal, call.args[0])
+			},
+		},
+
+		"insureProposal": {
+			args: args(textArg("vin"), textArg("insurer")),
+			// only normal users are allowed to do insurance proposals
+			roles:  []string{"user"},
+			action: "create an insurance proposal",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.insureProposal(stub, principal, call.args[0], call.args[1])
+			},
+		},
+
+		"sell": {
+			args: args(integerArg("price"), textArg("vin"), textArg("buyer")),
+			// only allow users and garage users to sell cars
+			roles:      []string{"user", "garage"},
+			action:     "sell cars",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// agents act with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[1], mandateSell)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.sell(stub, principal, call.args)
+			},
+		},
+
+		"sellDeal": {
+			args: args(textArg("buyer"), jsonArg("deal", ref("Deal"))),
+			// fleet sales and swaps between garages
+			roles:      []string{"user", "garage"},
+			action:     "sell cars",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// agents act with the owner's mandate, checked per car
+				return t.sellDeal(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"scheduleTestDrive": {
+			args:   optionalArgs(4, textArg("vin"), textArg("prospect"), timestampArg("from"), timestampArg("to"), enumArg("liability", liabilityProspect, liabilityOwner), integerArg("excess")),
+			roles:  []string{"user", "garage"},
+			action: "schedule test drives",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				fromTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'scheduleTestDrive' expects the start as unix timestamp")
+				}
+				toTs, err := strconv.ParseInt(call.args[3], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'scheduleTestDrive' expects the end as unix timestamp")
+				}
+
+				// the prospect is liable unless agreed otherwise
+				terms := TestDriveTerms{Liability: liabilityProspect}
+				if len(call.args) > 4 {
+					terms.Liability = call.args[4]
+				}
+				if len(call.args) > 5 {
+					terms.Excess, _ = strconv.Atoi(call.args[5])
+				}
+
+				// dealers schedule test drives with the owner's mandate
+				principal, err := t.principal(stub, call.username, call.args[0], mandateSell)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.scheduleTestDrive(stub, call.username, principal, call.args[0], call.args[1], fromTs, toTs, terms)
+			},
+		},
+
+		"readTestDrives": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readTestDrives(stub, call.username, call.args[0])
+			},
+		},
+
+		"getDeal": {
+			args:     args(textArg("deal id")),
+			roles:    []string{"user", "garage", "dot"},
+			action:   "read deals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readDeal(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"updateBalance": {
+			args: args(integerArg("balance")),
+			// only a user is allowed to update balance
+			roles:  []string{"user"},
+			action: "update the balance of a user",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				/* TODO
+				newBalance64, err := strconv.ParseInt(args[0], 10, 64)
+				var newBalance int
+				newBalance = int(newBalance64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "Error converting string to int.")
+				}
+				return t.updateBalance(shim, username, newBalance)
+				*/
+				return errorResponse(ErrUnknownFunction, "Invoke did not find function: "+call.function)
+			},
+		},
+
+		// MANUFACTURER FUNCTIONS
+		"manufacture": {
+			args:   args(textArg("vin"), jsonArg("technical data sheet", ref("TechnicalData"))),
+			roles:  []string{"manufacturer"},
+			action: "issue birth certificates",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.manufacture(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"readBirthCertificate": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readBirthCertificate(stub, call.args[0])
+			},
+		},
+
+		// GARAGE FUNCTIONS
+		"create": {
+			args:        optionalArgs(1, jsonArg("car", ref("Car")), jsonArg("registration proposal", ref("RegistrationProposal"))),
+			roles:       []string{"garage"},
+			action:      "create cars",
+			idempotent:  true,
+			simulatable: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.createCar(stub, call.username, call.args)
+			},
+		},
+
+		"createBatch": {
+			// malformed cars fail one by one
+			args:   args(jsonArg("cars", &Schema{Type: "array", MinItems: 1, Items: &Schema{Description: "car, see '#/$defs/Car'"}})),
+			roles:  []string{"garage"},
+			action: "create cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.createBatch(stub, call.username, call.args[0])
+			},
+		},
+
+		"getInventory": {
+			args:     args(),
+			roles:    []string{"garage"},
+			action:   "read an inventory",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getInventory(stub, call.username)
+			},
+		},
+
+		"bulkImportCars": {
+			args:   args(jsonArg("cars", &Schema{Type: "array", MinItems: 1, Items: ref("InventoryImport")})),
+			roles:  []string{"garage"},
+			action: "import an inventory",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.bulkImportCars(stub, call.username, call.args[0])
+			},
+		},
+
+		"assignSalesperson": {
+			args:   args(textArg("vin"), textArg("salesperson")),
+			roles:  []string{"garage"},
+			action: "assign salespeople",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.assignSalesperson(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"issuePermit": {
+			args: args(textArg("vin"), textArg("day of the trip"), textArg("route")),
+			// only the DOT and garages are allowed to issue trip permits
+			roles:  []string{"dot", "garage"},
+			action: "issue trip permits",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// garages send cars to the inspection if certified for it
+				if call.role == "garage" {
+					err := requireCertification(stub, call.username, scopeInspection)
+					if err != nil {
+						return errorResponseFrom(err)
+					}
+				}
+				return t.issuePermit(stub, call.username, call.args)
+			},
+		},
+
+		"issueTransitPermit": {
+			args:   optionalArgs(2, textArg("vin"), integerArg("valid days"), textArg("destination country")),
+			roles:  []string{"dot"},
+			action: "issue transit permits",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.issueTransitPermit(stub, call.username, call.args)
+			},
+		},
+
+		"readTransitPermit": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readTransitPermit(stub, call.args[0])
+			},
+		},
+
+		// PUBLIC FUNCTIONS
+		"revokeLapsedCoverNote": {
+			args: args(textArg("vin")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revokeLapsedCoverNote(stub, call.args[0])
+			},
+		},
+
+		"verifySticker": {
+			args:     args(textArg("sticker payload")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.verifySticker(stub, call.args[0])
+			},
+		},
+
+		"validateVin": {
+			args:     args(textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.validateVin(stub, call.args[0])
+			},
+		},
+
+		// POLICE AND COURT FUNCTIONS
+		"policeLookup": {
+			args:     args(textArg("vin")),
+			roles:    []string{"police"},
+			action:   "do police lookups",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.policeLookup(stub, call.args[0])
+			},
+		},
+
+		"verifyInsuranceProof": {
+			args:     optionalArgs(1, textArg("vin"), textArg("country")),
+			roles:    []string{"border_control"},
+			action:   "verify insurance proofs",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				country := ""
+				if len(call.args) > 1 {
+					country = call.args[1]
+				}
+				return t.verifyInsuranceProof(stub, call.args[0], country)
+			},
+		},
+
+		"seizeCar": {
+			args:   optionalArgs(2, textArg("vin"), textArg("case reference"), booleanArg("take into custody")),
+			roles:  []string{"police", "court"},
+			action: "seize cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.seizeCar(stub, call.username, call.role, call.args)
+			},
+		},
+
+		"releaseCar": {
+			args:   args(textArg("vin"), textArg("case reference")),
+			roles:  []string{"police", "court"},
+			action: "release seized cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.releaseCar(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		// DOT FUNCTIONS
+		"revoke": {
+			args: args(textArg("vin")),
+			// only the DOT is allowed to revoke cars
+			roles:  []string{"dot"},
+			action: "revoke cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.revoke(stub, call.username, call.args[0])
+			},
+		},
+
+		"delete": {
+			args: args(textArg("vin")),
+			// only the DOT is allowed to delete cars
+			roles:  []string{"dot"},
+			action: "delete cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.delete(stub, call.args[0])
+			},
+		},
+
+		"getArchivedCar": {
+			args: args(textArg("vin")),
+			// archived cars are kept for audits by the DOT and the regulator
+			roles:    []string{"dot", "regulator"},
+			action:   "read archived cars",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getArchivedCar(stub, call.args[0])
+			},
+		},
+
+		"inheritCar": {
+			args:   args(textArg("vin"), textArg("heir"), textArg("probate document hash")),
+			roles:  []string{"dot"},
+			action: "hand cars to heirs",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.inheritCar(stub, call.username, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"cancelInheritance": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "hand cars to heirs",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.cancelInheritance(stub, call.args[0])
+			},
+		},
+
+		"processExpirations": {
+			args:   optionalArgs(0, integerArg("batch size")),
+			roles:  []string{"dot"},
+			action: "process expirations",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.processExpirations(stub, call.args)
+			},
+		},
+
+		"getExpiring": {
+			args:     optionalArgs(2, timestampArg("from"), timestampArg("to, exclusive"), enumArg("type", expiryTypes...)),
+			roles:    []string{"dot", "regulator"},
+			action:   "list expiring credentials",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getExpiring(stub, call.args)
+			},
+		},
+
+		"classifyHistoric": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "classify historic vehicles",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.classifyHistoric(stub, call.username, call.args[0])
+			},
+		},
+
+		"declassifyHistoric": {
+			args:   args(textArg("vin"), textArg("reason")),
+			roles:  []string{"dot"},
+			action: "classify historic vehicles",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.declassifyHistoric(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"approveModification": {
+			args:   optionalArgs(1, textArg("vin"), textArg("note")),
+			roles:  []string{"dot"},
+			action: "review modifications",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				note := ""
+				if len(call.args) > 1 {
+					note = call.args[1]
+				}
+				return t.reviewModification(stub, call.username, call.args[0], modificationApproved, note)
+			},
+		},
+
+		"rejectModification": {
+			args:   args(textArg("vin"), textArg("reason")),
+			roles:  []string{"dot"},
+			action: "review modifications",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.reviewModification(stub, call.username, call.args[0], modificationRejected, call.args[1])
+			},
+		},
+
+		"clearInspection": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "review modifications",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.clearInspection(stub, call.username, call.args[0])
+			},
+		},
+
+		"openVinConflict": {
+			args:   args(textArg("vin"), textArg("claimant"), jsonArg("second car", ref("Car")), textArg("note")),
+			roles:  []string{"dot"},
+			action: "investigate VIN conflicts",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.openVinConflict(stub, call.username, call.args[0], call.args[1], call.args[2], call.args[3])
+			},
+		},
+
+		"addVinConflictNote": {
+			args:   args(textArg("vin"), textArg("note")),
+			roles:  []string{"dot"},
+			action: "investigate VIN conflicts",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.addVinConflictNote(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"resolveVinConflict": {
+			args:   args(textArg("vin"), enumArg("fraudulent record", fraudulentRegistered, fraudulentClaim), textArg("note")),
+			roles:  []string{"dot"},
+			action: "investigate VIN conflicts",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.resolveVinConflict(stub, call.username, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"readVinConflict": {
+			args:     args(textArg("vin")),
+			roles:    []string{"dot"},
+			action:   "investigate VIN conflicts",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readVinConflict(stub, call.args[0])
+			},
+		},
+
+		"setPseudonymSecret": {
+			// the secret is passed as transient data
+			args: args(),
+			// only the DOT is allowed to set the pseudonym secret,
+			// migrations of owners need it
+			roles:     []string{"dot"},
+			action:    "set the pseudonym secret",
+			anySchema: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setPseudonymSecret(stub)
+			},
+		},
+
+		"resolvePseudonym": {
+			args: args(textArg("pseudonym")),
+			// only the DOT is allowed to learn who owns a car
+			roles:    []string{"dot"},
+			action:   "resolve owner pseudonyms",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.resolvePseudonym(stub, call.args[0])
+			},
+		},
+
+		"readRegistrationProposals": {
+			args: args(),
+			// only the DOT is allowed to read registration proposals
+			roles:    []string{"dot"},
+			action:   "read registration proposals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readRegistrationProposals(stub)
+			},
+		},
+
+		"getPendingProposals": {
+			args:     optionalArgs(0, optionalIntegerArg("page size"), textArg("bookmark")),
+			roles:    []string{"dot"},
+			action:   "read registration proposals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getPendingProposals(stub, call.args)
+			},
+		},
+
+		"verifyIndexIntegrity": {
+			args:   optionalArgs(0, booleanArg("repair")),
+			roles:  []string{"admin"},
+			action: "verify the index integrity",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				repair := false
+				if len(call.args) > 0 {
+					repair, _ = strconv.ParseBool(call.args[0])
+				}
+				return t.verifyIndexIntegrity(stub, repair)
+			},
+		},
+
+		"purgeExpiredProposals": {
+			args:   optionalArgs(0, integerArg("maximum age in days")),
+			roles:  []string{"dot", "admin"},
+			action: "purge registration proposals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.purgeExpiredProposals(stub, call.args)
+			},
+		},
+
+		"setProposalTtl": {
+			args:   args(integerArg("days")),
+			roles:  []string{"dot", "admin"},
+			action: "change the proposal expiry",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setProposalTtl(stub, call.args[0])
+			},
+		},
+
+		"approveProposal": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "register cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveProposal(stub, call.username, call.args[0], true)
+			},
+		},
+
+		"approveProposalsBatch": {
+			args:   args(jsonArg("VINs", &Schema{Type: "array", Items: &Schema{Type: "string"}})),
+			roles:  []string{"dot"},
+			action: "register cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveProposalsBatch(stub, call.username, call.args[0])
+			},
+		},
+
+		"rejectProposal": {
+			args:   args(textArg("vin"), textArg("reason")),
+			roles:  []string{"dot"},
+			action: "reject registration proposals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.rejectProposal(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"requestProposalChanges": {
+			args:   args(textArg("vin"), textArg("comment")),
+			roles:  []string{"dot"},
+			action: "request changes to registration proposals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.requestProposalChanges(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"amendProposal": {
+			args:   optionalArgs(2, textArg("vin"), jsonArg("registration proposal", ref("RegistrationProposal")), textArg("comment")),
+			roles:  []string{"garage"},
+			action: "amend registration proposals",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.amendProposal(stub, call.username, call.args)
+			},
+		},
+
+		"getProposal": {
+			args:     args(textArg("vin")),
+			roles:    []string{"dot", "garage"},
+			action:   "read registration proposals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readProposal(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"register": {
+			args: args(textArg("vin")),
+			// only the DOT is allowed to register new cars
+			roles:  []string{"dot"},
+			action: "register cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.register(stub, call.username, call.args[0])
+			},
+		},
+
+		"confirm": {
+			args: args(textArg("vin"), textArg("numberplate")),
+			// only the DOT is allowed to confirm cars
+			roles:       []string{"dot"},
+			action:      "confirm cars",
+			simulatable: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.confirm(stub, call.username, call.args)
+			},
+		},
+
+		"approveRebuild": {
+			args: args(textArg("vin"), textArg("inspection report")),
+			// only the DOT is allowed to inspect rebuilt cars
+			roles:  []string{"dot"},
+			action: "approve rebuilds",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveRebuild(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"exportCar": {
+			args: args(textArg("vin"), textArg("destination country")),
+			// only the DOT is allowed to deregister cars for export
+			roles:  []string{"dot"},
+			action: "export cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.exportCar(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"importCar": {
+			args: args(jsonArg("export certificate", ref("ExportCertificate")), jsonArg("customs clearance", ref("Customs"))),
+			// only the DOT is allowed to register imported cars
+			roles:  []string{"dot"},
+			action: "import cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.importCar(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"lockHandoff": {
+			args: args(textArg("vin"), textArg("target channel"), textArg("target chaincode")),
+			// only the DOT is allowed to move cars between channels
+			roles:  []string{"dot"},
+			action: "hand off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.lockHandoff(stub, call.args)
+			},
+		},
+
+		"acceptHandoff": {
+			args:   args(textArg("vin"), textArg("source channel"), textArg("source chaincode")),
+			roles:  []string{"dot"},
+			action: "hand off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.acceptHandoff(stub, call.args)
+			},
+		},
+
+		"confirmHandoff": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "hand off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.confirmHandoff(stub, call.args[0])
+			},
+		},
+
+		"activateHandoff": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "hand off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.activateHandoff(stub, call.args[0])
+			},
+		},
+
+		"abortHandoff": {
+			args:   args(textArg("vin")),
+			roles:  []string{"dot"},
+			action: "hand off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.abortHandoff(stub, call.args[0])
+			},
+		},
+
+		"getHandoff": {
+			args: args(textArg("vin")),
+			// only registries read cars across channels
+			roles:    []string{"dot", registryRole},
+			action:   "read handoffs",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getHandoff(stub, call.args[0])
+			},
+		},
+
+		"updateConfig": {
+			args: args(jsonArg("configuration changes", ref("Config"))),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// admins are checked against the configuration
+				return t.updateConfig(stub, call.role, call.args[0])
+			},
+		},
+
+		"readConfig": {
+			args:     args(),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.read(stub, configStr)
+			},
+		},
+
+		"setFeeSchedule": {
+			args: args(textArg("fee"), integerArg("flat amount"), integerArg("percentage")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// admins are checked against the configuration
+				return t.setFeeSchedule(stub, call.role, call.args)
+			},
+		},
+
+		"setReferenceValue": {
+			args: args(textArg("brand"), textArg("model"), integerArg("value")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// admins are checked against the configuration
+				return t.setReferenceValue(stub, call.role, call.args)
+			},
+		},
+
+		"readReferenceValue": {
+			args:     args(textArg("brand"), textArg("model")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.readReferenceValue(stub, call.args)
+			},
+		},
+
+		"addCatalogEntry": {
+			args: args(jsonArg("catalog entry", ref("CatalogEntry"))),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// admins are checked against the configuration
+				return t.addCatalogEntry(stub, call.role, call.args[0])
+			},
+		},
+
+		"searchCatalog": {
+			args:     optionalArgs(0, textArg("brand"), textArg("model")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.searchCatalog(stub, call.args)
+			},
+		},
+
+		"getFlaggedSales": {
+			args:     args(),
+			roles:    []string{"dot"},
+			action:   "review sales",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getFlaggedSales(stub)
+			},
+		},
+
+		"approveSale": {
+			args: optionalArgs(1, textArg("vin"), optionalIntegerArg("assessed value")),
+			// only the DOT is allowed to review sales
+			roles:      []string{"dot"},
+			action:     "review sales",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveSale(stub, call.username, call.args)
+			},
+		},
+
+		"rejectSale": {
+			args:   args(textArg("vin"), textArg("reason")),
+			roles:  []string{"dot"},
+			action: "review sales",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.rejectSale(stub, call.username, call.args)
+			},
+		},
+
+		"getTreasuryBalance": {
+			args:     args(),
+			roles:    []string{"dot", "admin"},
+			action:   "read the treasury",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getTreasuryBalance(stub)
+			},
+		},
+
+		"exportState": {
+			args:     optionalArgs(1, textArg("key prefix"), textArg("bookmark"), optionalIntegerArg("page size"), timestampArg("journal position")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				config, err := t.getConfig(stub)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				err = checkAdmin(stub, config, call.role)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.exportState(stub, call.args)
+			},
+		},
+
+		"forgetUser": {
+			args: args(textArg("username")),
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				// admins are checked against the configuration
+				config, err := t.getConfig(stub)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				err = checkAdmin(stub, config, call.role)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.forgetUser(stub, call.args[0])
+			},
+		},
+
+		"countCarsByStatus": {
+			args:     optionalArgs(0, optionalIntegerArg("page size"), textArg("bookmark")),
+			roles:    []string{"dot"},
+			action:   "read registry statistics",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.countCarsByStatus(stub, call.args)
+			},
+		},
+
+		"countRegistrationsInPeriod": {
+			args:     optionalArgs(2, timestampArg("start"), timestampArg("end"), optionalIntegerArg("page size"), textArg("bookmark")),
+			roles:    []string{"dot"},
+			action:   "read registry statistics",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.countRegistrationsInPeriod(stub, call.args)
+			},
+		},
+
+		"topBrandsRegistered": {
+			args:     optionalArgs(0, optionalIntegerArg("number of brands"), optionalIntegerArg("page size"), textArg("bookmark")),
+			roles:    []string{"dot"},
+			action:   "read registry statistics",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.topBrandsRegistered(stub, call.args)
+			},
+		},
+
+		"setStickerKey": {
+			args: args(textArg("public key, hex encoded")),
+			// only the DOT is allowed to set its signing key
+			roles:  []string{"dot"},
+			action: "set the sticker key",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setStickerKey(stub, call.args[0])
+			},
+		},
+
+		"reservePlate": {
+			args:       args(textArg("numberplate")),
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.reservePlate(stub, call.username, call.args[0])
+			},
+		},
+
+		"setPlateFormat": {
+			args: args(textArg("jurisdiction"), textArg("numberplate format")),
+			// only the DOT decides what numberplates look like
+			roles:  []string{"dot"},
+			action: "set numberplate formats",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setPlateFormat(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"setRegistrationRules": {
+			args: args(textArg("jurisdiction"), jsonArg("rules", &Schema{Type: "array", Items: ref("RegistrationRule")})),
+			// only the DOT decides what a car needs to be confirmed
+			roles:  []string{"dot"},
+			action: "set registration rules",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setRegistrationRules(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"setRoadTaxRule": {
+			args:   args(textArg("jurisdiction"), jsonArg("rule", ref("RoadTaxRule"))),
+			roles:  []string{"dot"},
+			action: "set the road tax",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setRoadTaxRule(stub, call.args[0], call.args[1])
+			},
+		},
+
+		"generateSticker": {
+			args: args(textArg("vin")),
+			// only the DOT is allowed to issue registration stickers
+			roles:    []string{"dot"},
+			action:   "issue stickers",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.generateSticker(stub, call.args[0])
+			},
+		},
+
+		"getRevocationProposals": {
+			args:     args(),
+			roles:    []string{"dot"},
+			action:   "query revocation proposals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getRevocationProposals(stub)
+			},
+		},
+
+		// INSURANCE FUNCTIONS
+		"requestQuote": {
+			args:   args(textArg("vin"), textArg("coverage")),
+			roles:  []string{"user"},
+			action: "request insurance quotes",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.requestQuote(stub, principal, call.args[0], call.args[1])
+			},
+		},
+
+		"getQuotes": {
+			args:     args(textArg("vin")),
+			roles:    []string{"user"},
+			action:   "read insurance quotes",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.getQuotes(stub, principal, call.args[0])
+			},
+		},
+
+		"acceptQuote": {
+			args:       args(textArg("vin"), textArg("insurer")),
+			roles:      []string{"user"},
+			action:     "accept insurance quotes",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.acceptQuote(stub, principal, call.args[0], call.args[1])
+			},
+		},
+
+		"getQuoteRequests": {
+			args:     args(),
+			roles:    []string{"insurer"},
+			action:   "read quote requests",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getQuoteRequests(stub)
+			},
+		},
+
+		"submitQuote": {
+			args:   args(textArg("vin"), integerArg("price"), textArg("conditions")),
+			roles:  []string{"insurer"},
+			action: "submit quotes",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				price, err := strconv.Atoi(call.args[1])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'submitQuote' expects the price as integer")
+				}
+				return t.submitQuote(stub, call.username, call.args[0], price, call.args[2])
+			},
+		},
+
+		"getInsuredCars": {
+			args:     optionalArgs(0, optionalIntegerArg("page size"), textArg("bookmark")),
+			roles:    []string{"insurer"},
+			action:   "read insured cars",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getInsuredCars(stub, call.username, call.args)
+			},
+		},
+
+		"getPoliciesExpiring": {
+			args:     args(integerArg("days")),
+			roles:    []string{"insurer"},
+			action:   "read insured cars",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				days, err := strconv.Atoi(call.args[0])
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'getPoliciesExpiring' expects the number of days as integer")
+				}
+				return t.getPoliciesExpiring(stub, call.username, days)
+			},
+		},
+
+		"publishInsuranceProduct": {
+			args:   args(jsonArg("product", ref("InsuranceProduct"))),
+			roles:  []string{"insurer"},
+			action: "publish insurance products",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.publishInsuranceProduct(stub, call.username, call.args[0])
+			},
+		},
+
+		"withdrawInsuranceProduct": {
+			args:   args(textArg("product id")),
+			roles:  []string{"insurer"},
+			action: "withdraw insurance products",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.withdrawInsuranceProduct(stub, call.username, call.args[0])
+			},
+		},
+
+		"getInsuranceProducts": {
+			args:     optionalArgs(0, textArg("vin")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				if len(call.args) == 0 || call.args[0] == "" {
+					return t.getInsuranceProducts(stub, call.username, "")
+				}
+
+				// filtering by eligibility needs the car
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.getInsuranceProducts(stub, principal, call.args[0])
+			},
+		},
+
+		"requestProductQuote": {
+			args:   args(textArg("vin"), textArg("insurer"), textArg("product id")),
+			roles:  []string{"user"},
+			action: "request insurance quotes",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				principal, err := t.principal(stub, call.username, call.args[0], mandateInsure)
+				if err != nil {
+					return errorResponseFrom(err)
+				}
+				return t.requestProductQuote(stub, principal, call.args[0], call.args[1], call.args[2])
+			},
+		},
+
+		"insuranceAccept": {
+			args: args(textArg("vin"), textArg("insurer")),
+			// only insurers are allowed to create insurance contracts
+			roles:  []string{"insurer"},
+			action: "create an insurance proposal",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.insuranceAccept(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"issueCoverNote": {
+			args:   optionalArgs(1, textArg("vin"), integerArg("valid days")),
+			roles:  []string{"insurer"},
+			action: "issue cover notes",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.issueCoverNote(stub, call.username, call.args)
+			},
+		},
+
+		"issueInsuranceProof": {
+			args:   optionalArgs(2, textArg("vin"), jsonArg("countries", &Schema{Type: "array", MinItems: 1, Items: &Schema{Type: "string"}}), timestampArg("valid until, 0 for the end of the policy")),
+			roles:  []string{"insurer"},
+			action: "issue insurance proofs",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				validUntilTs := int64(0)
+				if len(call.args) > 2 {
+					var err error
+					validUntilTs, err = strconv.ParseInt(call.args[2], 10, 64)
+					if err != nil {
+						return errorResponse(ErrInvalidArgument, "'issueInsuranceProof' expects the end of validity as unix timestamp")
+					}
+				}
+				return t.issueInsuranceProof(stub, call.username, call.args[0], call.args[1], validUntilTs)
+			},
+		},
+
+		"setPolicy": {
+			args:   args(textArg("vin"), timestampArg("start"), timestampArg("end, 0 for no end"), textArg("policy document hash")),
+			roles:  []string{"insurer"},
+			action: "set insurance policies",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				startTs, err := strconv.ParseInt(call.args[1], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'setPolicy' expects the start as unix timestamp")
+				}
+				endTs, err := strconv.ParseInt(call.args[2], 10, 64)
+				if err != nil {
+					return errorResponse(ErrInvalidArgument, "'setPolicy' expects the end as unix timestamp")
+				}
+				return t.setPolicy(stub, call.username, call.args[0], InsurancePolicy{StartTs: startTs, EndTs: endTs, DocumentHash: call.args[3]})
+			},
+		},
+
+		"getInsurer": {
+			args: args(textArg("insurer")),
+			// only insurers are allowed to read their insurance proposals
+			roles:    []string{"insurer"},
+			action:   "read insurance proposals",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getInsurer(stub, call.args[0])
+			},
+		},
+
+		"fileClaim": {
+			args: args(textArg("vin"), textArg("accident report"), integerArg("claimed amount")),
+			// only car owners are allowed to file claims
+			roles:  []string{"user"},
+			action: "file an insurance claim",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.fileClaim(stub, call.username, call.args)
+			},
+		},
+
+		"approveClaim": {
+			args: args(textArg("claim id")),
+			// only insurers are allowed to process claims
+			roles:  []string{"insurer"},
+			action: "approve insurance claims",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.approveClaim(stub, call.username, call.args[0])
+			},
+		},
+
+		"rejectClaim": {
+			args:   args(textArg("claim id"), textArg("reason")),
+			roles:  []string{"insurer"},
+			action: "reject insurance claims",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.rejectClaim(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"settleClaim": {
+			args:       optionalArgs(1, textArg("claim id"), booleanArg("pay out on the ledger")),
+			roles:      []string{"insurer"},
+			action:     "settle insurance claims",
+			idempotent: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.settleClaim(stub, call.username, call.args)
+			},
+		},
+
+		"markSalvage": {
+			args: args(textArg("vin"), textArg("claim id"), textArg("classification")),
+			// only insurers are allowed to write off cars
+			roles:  []string{"insurer"},
+			action: "write off cars",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.markSalvage(stub, call.username, call.args)
+			},
+		},
+
+		"getClaims": {
+			args: args(),
+			// only insurers are allowed to read their claims
+			roles:    []string{"insurer"},
+			action:   "read insurance claims",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getClaims(stub, call.username)
+			},
+		},
+
+		"getOwnerEquity": {
+			args:     args(textArg("owner")),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getOwnerEquity(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		// REGULATOR FUNCTIONS
+		"getAuditLog": {
+			args: args(timestampArg("start"), timestampArg("end")),
+			// only the regulator audits privileged changes
+			roles:    []string{"regulator"},
+			action:   "read the audit log",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getAuditLog(stub, call.args)
+			},
+		},
+
+		"accreditInsurer": {
+			args:   args(textArg("insurer"), textArg("licence")),
+			roles:  []string{"regulator"},
+			action: "accredit insurers",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.accreditInsurer(stub, call.username, call.args[0], call.args[1])
+			},
+		},
+
+		"suspendInsurer": {
+			args:   args(textArg("insurer"), textArg("reason")),
+			roles:  []string{"regulator"},
+			action: "accredit insurers",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.setAccreditationStatus(stub, call.username, call.args[0], accreditationSuspended, call.args[1])
+			},
+		},
+
+		"reinstateInsurer": {
+			args:   optionalArgs(1, textArg("insurer"), textArg("reason")),
+			roles:  []string{"regulator"},
+			action: "accredit insurers",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				reason := ""
+				if len(call.args) > 1 {
+					reason = call.args[1]
+				}
+				return t.setAccreditationStatus(stub, call.username, call.args[0], accreditationActive, reason)
+			},
+		},
+
+		"getAccreditedInsurers": {
+			args:     args(),
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getAccreditedInsurers(stub)
+			},
+		},
+
+		"getMovedPolicies": {
+			args:     args(textArg("order reference")),
+			roles:    []string{"regulator", "insurer"},
+			action:   "read moved policies",
+			readOnly: true,
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.getMovedPolicies(stub, call.username, call.role, call.args[0])
+			},
+		},
+
+		"transferPortfolio": {
+			args: optionalArgs(3, textArg("failed insurer"), textArg("receiving insurer"), textArg("order reference"), integerArg("chunk size")),
+			// only the regulator is allowed to move insurance policies
+			roles:  []string{"regulator"},
+			action: "transfer insurance portfolios",
+			handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+				return t.transferPortfolio(stub, call.args)
+			},
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestRoutes(t *testing.T) {
+	for name, route := range routes {
+		if route.handler == nil {
+			t.Errorf("Function '%s' has no handler", name)
+		}
+		if len(route.roles) > 0 && route.action == "" {
+			t.Errorf("Function '%s' restricts roles, but has no action for the error message", name)
+		}
+	}
+}
+
+func TestUnknownFunction(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("fly", "bobby", "user"))
+	expectErrorCode(t, response, ErrUnknownFunction)
+
+	envelope := ChaincodeError{}
+	json.Unmarshal([]byte(response.Message), &envelope)
+	functions := strings.Split(envelope.Details["functions"], ",")
+	if len(functions) != len(routes) {
+		t.Errorf("Expected all %d functions in the details, got %d", len(routes), len(functions))
+	}
+}
+
+func TestRouteRoles(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("revoke", "bobby", "user", "WVWZZZ6RZHY260780"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+}
+
+func TestReadOnlyRoute(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	routes["writeSomething"] = route{
+		args:     args(),
+		readOnly: true,
+		handler: func(t *CarChaincode, stub shim.ChaincodeStubInterface, call invocation) pb.Response {
+			stub.PutState("something", []byte("written"))
+			return shim.Success(nil)
+		},
+	}
+	defer delete(routes, "writeSomething")
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("writeSomething", "bobby", "user"))
+	expectErrorCode(t, response, ErrInternal)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Registration rules.
+ *
+ * What a car needs to be confirmed differs between cantons
+ * and states. Every jurisdiction, the numberplate prefix up
+ * to the first space like for plate formats, has a list of
+ * rules in the configuration under 'registration_rules';
+ * '' holds the default list. Without either the built-in
+ * rules apply: insurance for every car, a current
+ * emission test from an age of 4 years and no overdue
+ * road tax.
+ *
+ * 'confirm' evaluates the rules of the numberplate and
+ * 'processExpirations' revokes cars no longer meeting them.
+ * Historic cars are exempt from the emission rules.
+ */
+
+// registration rule requirements
+const ruleInsurance string = "insurance"
+const ruleEmissionTest string = "emission_test"
+const ruleEmissionClass string = "emission_class"
+const ruleRoadTax string = "road_tax"
+
+// rules of jurisdictions without configured rules
+var defaultRegistrationRules = []RegistrationRule{
+	{Requirement: ruleInsurance},
+	{Requirement: ruleEmissionTest, MinAgeYears: int(emissionTestAgeYears)},
+	{Requirement: ruleRoadTax},
+}
+
+// check of every requirement, returns an error if 'car' fails 'rule' at 'now'
+var ruleChecks = map[string]func(rule RegistrationRule, car *Car, now int64) error{
+	ruleInsurance: func(rule RegistrationRule, car *Car, now int64) error {
+		if !IsInsured(car, now) {
+			return newError(ErrNotInsured, "Car is not insured. Please insure car first before trying to confirm it")
+		}
+		return nil
+	},
+	ruleEmissionTest: func(rule RegistrationRule, car *Car, now int64) error {
+		if !hasCurrentEmissionTest(car, now) && rule.MinAgeYears > 0 {
+			return newError(ErrInvalidState, fmt.Sprintf("Cars older than %d years need a current emission test to be confirmed", rule.MinAgeYears))
+		} else if !hasCurrentEmissionTest(car, now) {
+			return newError(ErrInvalidState, "Cars need a current emission test to be confirmed")
+		} else if rule.IntervalDays > 0 && now-car.Emission.TestedTs > int64(rule.IntervalDays)*secondsPerDay {
+			return newError(ErrInvalidState, fmt.Sprintf("The emission test has to be younger than %d days", rule.IntervalDays))
+		}
+		return nil
+	},
+	ruleEmissionClass: func(rule RegistrationRule, car *Car, now int64) error {
+		if !hasCurrentEmissionTest(car, now) || !containsString(rule.Classes, car.Emission.Class) {
+			return newError(ErrInvalidState, fmt.Sprintf("Only cars of emission class %s are confirmed", strings.Join(rule.Classes, ", ")))
+		}
+		return nil
+	},
+	ruleRoadTax: func(rule RegistrationRule, car *Car, now int64) error {
+		if IsRoadTaxOverdue(car, now) {
+			return newError(ErrInvalidState, "The road tax of the car is overdue. Please pay it with 'payRoadTax' first")
+		}
+		return nil
+	},
+}
+
+/*
+ * Returns the jurisdiction of a numberplate
+ */
+func plateJurisdiction(numberplate string) string {
+	return strings.SplitN(numberplate, " ", 2)[0]
+}
+
+/*
+ * Returns the registration rules for 'numberplate'
+ */
+func registrationRules(config Config, numberplate string) []RegistrationRule {
+	rules, ok := config.RegistrationRules[plateJurisdiction(numberplate)]
+	if !ok {
+		rules, ok = config.RegistrationRules[""]
+	}
+	if !ok {
+		return defaultRegistrationRules
+	}
+
+	return rules
+}
+
+/*
+ * Checks if a rule applies to 'car' at 'now'
+ */
+func ruleApplies(rule RegistrationRule, car *Car, now int64) bool {
+	if (rule.Requirement == ruleEmissionTest || rule.Requirement == ruleEmissionClass) && IsHistoric(car) {
+		return false
+	}
+
+	return now-car.CreatedTs >= int64(rule.MinAgeYears)*365*secondsPerDay
+}
+
+/*
+ * Evaluates the registration rules for 'car' at 'now'.
+ *
+ * Returns the error of the first rule not met.
+ */
+func evaluateRules(rules []RegistrationRule, car *Car, now int64) error {
+	for _, rule := range rules {
+		if !ruleApplies(rule, car, now) {
+			continue
+		}
+
+		// rules are checked when set, see 'validateRules'
+		err := ruleChecks[rule.Requirement](rule, car, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Checks if the rules require insurance
+ */
+func requiresInsurance(rules []RegistrationRule) bool {
+	for _, rule := range rules {
+		if rule.Requirement == ruleInsurance {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Checks the registration rules of a jurisdiction
+ */
+func validateRules(jurisdiction string, rules []RegistrationRule) error {
+	for _, rule := range rules {
+		if _, ok := ruleChecks[rule.Requirement]; !ok {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Unknown requirement '%s' in the rules of jurisdiction '%s'", rule.Requirement, jurisdiction))
+		} else if rule.MinAgeYears < 0 || rule.IntervalDays < 0 {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Age and interval must not be negative in the rules of jurisdiction '%s'", jurisdiction))
+		} else if rule.Requirement == ruleEmissionClass && len(rule.Classes) == 0 {
+			return newError(ErrInvalidArgument, fmt.Sprintf("Emission class rules of jurisdiction '%s' need at least one class", jurisdiction))
+		}
+
+		for _, class := range rule.Classes {
+			if _, ok := emissionBadges[class]; !ok {
+				return newError(ErrInvalidArgument, fmt.Sprintf("Unknown emission class '%s' in the rules of jurisdiction '%s'", class, jurisdiction))
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Sets the registration rules of a jurisdiction.
+ *
+ * Arguments required:
+ * [0] Jurisdiction                (string)
+ *     empty for the default rules
+ * [1] Rules                       (JSON array)
+ *     'null' to remove the rules
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setRegistrationRules(stub shim.ChaincodeStubInterface, jurisdiction string, rulesJson string) pb.Response {
+	var rules []RegistrationRule
+	err := ledgerjson.Unmarshal([]byte(rulesJson), &rules)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'setRegistrationRules' expects a JSON array of rules")
+	}
+
+	err = validateRules(jurisdiction, rules)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if config.RegistrationRules == nil {
+		config.RegistrationRules = make(map[string][]RegistrationRule)
+	}
+
+	if rules == nil {
+		delete(config.RegistrationRules, jurisdiction)
+	} else {
+		config.RegistrationRules[jurisdiction] = rules
+	}
+
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
+
+/*
+ * Returns the registration rules in effect for
+ * numberplates of a jurisdiction
+ */
+func (t *CarChaincode) getRegistrationRules(stub shim.ChaincodeStubInterface, jurisdiction string) pb.Response {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	rulesAsBytes, _ := ledgerjson.Marshal(registrationRules(config, jurisdiction+" "))
+	return shim.Success(rulesAsBytes)
+}
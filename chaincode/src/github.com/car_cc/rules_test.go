@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRegistrationRules(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	// without configured rules the built-in rules apply
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getRegistrationRules", owner, "user", "ZH"))
+	rules := []RegistrationRule{}
+	json.Unmarshal(response.Payload, &rules)
+	if len(rules) != 3 || !requiresInsurance(rules) {
+		t.Fatalf("Expected the built-in rules, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setRegistrationRules", owner, "garage", "AI", `[]`))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setRegistrationRules", "inspector", "dot", "AI", `[{ "requirement": "sticker" }]`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setRegistrationRules", "inspector", "dot", "BE", `[{ "requirement": "emission_class", "classes": ["euro7"] }]`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// one canton asks for clean cars only, another for nothing
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setRegistrationRules", "inspector", "dot", "BE", `[{ "requirement": "emission_class", "classes": ["electric", "euro6"] }]`))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setRegistrationRules", "inspector", "dot", "AI", `[]`))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 1234"))
+	expectErrorCode(t, response, ErrNotInsured)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "BE 1234"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "AI 1234"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "AI 1234" || !car.InsuranceExempt {
+		t.Fatalf("Expected the car confirmed without insurance, got %s", response.Message)
+	}
+
+	// the expiry run keeps the car confirmed
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("processExpirations", "cron", "dot"))
+	report := ExpirationReport{}
+	json.Unmarshal(response.Payload, &report)
+	if response.Status != shim.OK || len(report.Revoked) != 0 {
+		t.Errorf("Expected no revocation, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("revoke", owner, "dot", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if response.Status != shim.OK || car.InsuranceExempt {
+		t.Errorf("Expected the revocation to drop the exemption, got %s", response.Message)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// car classifications after a claim
+const classSalvage string = "salvage"
+const classTotalLoss string = "total_loss"
+const classRebuilt string = "rebuilt"
+
+/*
+ * Checks if a car was written off by its insurer.
+ *
+ * Written off cars cannot be confirmed for road use.
+ * A salvage car can get back on the road after the DOT
+ * approved its rebuild, a total loss never.
+ */
+func IsWrittenOff(car *Car) bool {
+	return car.Classification == classSalvage || car.Classification == classTotalLoss
+}
+
+/*
+ * Classifies a car as salvage or total loss after a claim.
+ *
+ * Only the insurer who processed the claim can write off
+ * the car. The claim has to be approved or settled.
+ * Writing off a car removes its numberplate.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Claim id                    (string)
+ * [2] Classification              ('salvage' or 'total_loss')
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) markSalvage(stub shim.ChaincodeStubInterface, insurer string, args []string) pb.Response {
+	vin := args[0]
+	id := args[1]
+	classification := args[2]
+
+	if classification != classSalvage && classification != classTotalLoss {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Unknown classification '%s'. Expecting '%s' or '%s'", classification, classSalvage, classTotalLoss))
+	}
+
+	// the claim already checks for the right insurer
+	claim, err := t.getClaim(stub, insurer, id)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if claim.Car != vin {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Claim '%s' was not filed for car with VIN '%s'", id, vin))
+	} else if claim.Status != claimApproved && claim.Status != claimSettled {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Cannot write off a car for claim '%s' with status '%s'", id, claim.Status))
+	}
+
+	// the insurer is not the car owner,
+	// look up the owner in the car index
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// a written off car is no longer allowed on the road
+	car.Classification = classification
+	car.RebuildInspection = ""
+	car.Certificate.Numberplate = ""
+	car.InsuranceExempt = false
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Car with VIN '%s' classified as '%s' by insurer '%s'\n", vin, classification, insurer)
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Records a DOT rebuild inspection for a salvage car.
+ *
+ * After the rebuild was approved, the car is classified
+ * as 'rebuilt' and can be confirmed again.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Inspection report           (string)
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) approveRebuild(stub shim.ChaincodeStubInterface, vin string, report string) pb.Response {
+	if report == "" {
+		return errorResponse(ErrInvalidArgument, "'approveRebuild' expects a non-empty inspection report")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// a total loss cannot be rebuilt
+	if car.Classification != classSalvage {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Only salvage cars can be rebuilt. Car with VIN '%s' is classified as '%s'", vin, car.Classification))
+	}
+
+	car.Classification = classRebuilt
+	car.RebuildInspection = report
+
+	// the car made it to the inspection
+	consumePermit(&car)
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(car.Vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Approved rebuild of car with VIN '%s'\n", vin)
+
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Argument schemas.
+ *
+ * Every invoke function declares its arguments after
+ * 'username' and 'role' as JSON Schema (draft 2020-12),
+ * see 'routes'.
+ * 'Invoke' validates the arguments before dispatching,
+ * and 'getSchemas' hands the schemas to clients, so they
+ * can generate forms and validate locally.
+ *
+ * Arguments are always strings. Numbers and flags are
+ * described by a pattern, JSON documents by their
+ * content schema. The schemas of JSON documents are
+ * derived from the models, so they cannot drift apart.
+ *
+ * VINs are not checked here, 'ValidateVin' reports
+ * them with their own error codes.
+ */
+
+// dialect of the schemas
+const schemaDialect string = "https://json-schema.org/draft/2020-12/schema"
+
+// patterns of numeric and boolean arguments, as parsed by 'strconv'
+const integerPattern string = "^[+-]?[0-9]+$"
+const numberPattern string = `^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`
+const booleanPattern string = "^(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)$"
+
+/*
+ * JSON Schema, as far as the chaincode uses it
+ */
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinItems             int                `json:"minItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	ContentMediaType     string             `json:"contentMediaType,omitempty"`
+	ContentSchema        *Schema            `json:"contentSchema,omitempty"`
+}
+
+/*
+ * Arguments of an invoke function. The arguments
+ * after 'MinArgs' are optional.
+ */
+type FunctionSchema struct {
+	Args    []*Schema `json:"args"`
+	MinArgs int       `json:"min_args"`
+}
+
+/*
+ * All schemas, as returned by 'getSchemas'
+ */
+type SchemaCatalog struct {
+	Dialect   string                    `json:"$schema"`
+	Defs      map[string]*Schema        `json:"$defs"`
+	Functions map[string]FunctionSchema `json:"functions"`
+}
+
+func textArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string"}
+}
+
+func integerArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string", Pattern: integerPattern}
+}
+
+func timestampArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string", Pattern: integerPattern, Description: "unix timestamp"}
+}
+
+func optionalIntegerArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string", Pattern: "^([+-]?[0-9]+)?$", Description: "empty for the default"}
+}
+
+func numberArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string", Pattern: numberPattern}
+}
+
+func booleanArg(title string) *Schema {
+	return &Schema{Title: title, Type: "string", Pattern: booleanPattern}
+}
+
+func enumArg(title string, values ...string) *Schema {
+	return &Schema{Title: title, Type: "string", Enum: values}
+}
+
+func jsonArg(title string, content *Schema) *Schema {
+	return &Schema{Title: title, Type: "string", ContentMediaType: "application/json", ContentSchema: content}
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+func args(schemas ...*Schema) FunctionSchema {
+	return FunctionSchema{Args: schemas, MinArgs: len(schemas)}
+}
+
+func optionalArgs(minArgs int, schemas ...*Schema) FunctionSchema {
+	return FunctionSchema{Args: schemas, MinArgs: minArgs}
+}
+
+// schemas of JSON documents, derived from the models
+var schemaDefs = map[string]*Schema{}
+
+func init() {
+	for _, model := range []interface{}{Car{}, RegistrationProposal{}, InventoryImport{}, ExportCertificate{}, Customs{}, Config{}, CatalogEntry{}, TechnicalData{}, Deal{}, InsuranceProduct{}, TollPosting{}} {
+		modelSchema(reflect.TypeOf(model))
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+/*
+ * Returns the schema of a model type, named
+ * structs are added to the definitions
+ */
+func modelSchema(t reflect.Type) *Schema {
+	switch {
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Kind() == reflect.Ptr:
+		return modelSchema(t.Elem())
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: modelSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: modelSchema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := schemaDefs[t.Name()]; !ok {
+			// register first, models may refer to themselves
+			def := &Schema{Type: "object", Properties: map[string]*Schema{}}
+			schemaDefs[t.Name()] = def
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if name == "-" || field.PkgPath != "" {
+					continue
+				} else if name == "" {
+					name = field.Name
+				}
+				def.Properties[name] = modelSchema(field.Type)
+			}
+		}
+		return ref(t.Name())
+	}
+
+	return &Schema{}
+}
+
+/*
+ * Validates a JSON value against 'schema'.
+ *
+ * Like 'encoding/json', null is accepted for every
+ * property and unknown properties are ignored.
+ */
+func validateValue(schema *Schema, value interface{}, path string) error {
+	if schema.Ref != "" {
+		return validateValue(schemaDefs[strings.TrimPrefix(schema.Ref, "#/$defs/")], value, path)
+	} else if value == nil && path != "$" {
+		return nil
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'%s' must be an object", path)
+		}
+		for name, property := range object {
+			propertySchema := schema.Properties[name]
+			if propertySchema == nil {
+				propertySchema = schema.AdditionalProperties
+			}
+			if propertySchema != nil {
+				err := validateValue(propertySchema, property, path+"."+name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("'%s' must be an array", path)
+		} else if len(array) < schema.MinItems {
+			return fmt.Errorf("'%s' needs at least %d items", path, schema.MinItems)
+		}
+		for i, item := range array {
+			err := validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("'%s' must be a string", path)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("'%s' must be a boolean", path)
+		}
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("'%s' must be a number", path)
+		}
+		if _, err := number.Int64(); schema.Type == "integer" && err != nil {
+			return fmt.Errorf("'%s' must be an integer", path)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Validates one argument against its schema
+ */
+func validateArg(schema *Schema, arg string) error {
+	if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(arg) {
+		return fmt.Errorf("'%s' must match '%s', got '%s'", schema.Title, schema.Pattern, arg)
+	}
+
+	if len(schema.Enum) > 0 {
+		allowed := false
+		for _, value := range schema.Enum {
+			allowed = allowed || value == arg
+		}
+		if !allowed {
+			return fmt.Errorf("'%s' must be one of '%s', got '%s'", schema.Title, strings.Join(schema.Enum, "', '"), arg)
+		}
+	}
+
+	if schema.ContentSchema != nil {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+		decoder.UseNumber()
+
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err != nil {
+			return fmt.Errorf("'%s' must be JSON: %v", schema.Title, err)
+		}
+
+		err = validateValue(schema.ContentSchema, value, "$")
+		if err != nil {
+			return fmt.Errorf("'%s' does not match its schema: %v", schema.Title, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Validates the arguments of 'function' after
+ * 'username' and 'role' against its schema
+ */
+func validateArgs(function string, schema FunctionSchema, args []string) error {
+	if len(args) < schema.MinArgs || len(args) > len(schema.Args) {
+		expected := fmt.Sprintf("%d", schema.MinArgs)
+		if len(schema.Args) > schema.MinArgs {
+			expected = fmt.Sprintf("%d to %d", schema.MinArgs, len(schema.Args))
+		}
+		return newErrorWithDetails(ErrInvalidArgument, fmt.Sprintf("'%s' expects %s arguments, got %d", function, expected, len(args)), map[string]string{"function": function})
+	}
+
+	for i, arg := range args {
+		err := validateArg(schema.Args[i], arg)
+		if err != nil {
+			return newErrorWithDetails(ErrInvalidArgument, fmt.Sprintf("'%s': %v", function, err), map[string]string{"function": function, "argument": schema.Args[i].Title})
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the argument schemas of all invoke functions,
+ * or of 'function' only if given.
+ *
+ * On success,
+ * returns the schema catalog.
+ */
+func (t *CarChaincode) getSchemas(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	catalog := SchemaCatalog{Dialect: schemaDialect, Defs: schemaDefs, Functions: map[string]FunctionSchema{}}
+
+	if len(args) > 0 {
+		route, ok := routes[args[0]]
+		if !ok {
+			return errorResponseFrom(newErrorWithDetails(ErrNotFound, fmt.Sprintf("There is no function '%s'", args[0]), map[string]string{"functions": strings.Join(functionNames(), ",")}))
+		}
+		catalog.Functions[args[0]] = route.args
+	} else {
+		for name, route := range routes {
+			catalog.Functions[name] = route.args
+		}
+	}
+
+	catalogAsBytes, _ := ledgerjson.Marshal(catalog)
+	return shim.Success(catalogAsBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestValidateArgs(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": 17 }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`", "certificate": { "brand": "VW", "version": 1.5 } }`))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`, "{}", "too many"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	// unknown fields and nulls are fine, like with 'encoding/json'
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`", "color_code": "LC9X", "recalls": null }`))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", garage, "garage", "cheap", vin, "bobby"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	envelope := ChaincodeError{}
+	json.Unmarshal([]byte(response.Message), &envelope)
+	if envelope.Details["argument"] != "price" {
+		t.Errorf("Expected the invalid argument 'price' in the details, got %v", envelope.Details)
+	}
+}
+
+func TestGetSchemas(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("getSchemas", "bobby", "user"))
+	catalog := SchemaCatalog{}
+	err := json.Unmarshal(response.Payload, &catalog)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if catalog.Defs["Car"] == nil || catalog.Defs["Car"].Properties["certificate"].Ref != "#/$defs/Certificate" {
+		t.Error("Expected the car schema to refer to the certificate schema")
+	}
+	if len(catalog.Functions["sell"].Args) != 3 {
+		t.Errorf("Expected 3 arguments of 'sell', got %v", catalog.Functions["sell"])
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getSchemas", "bobby", "user", "transfer"))
+	catalog = SchemaCatalog{}
+	json.Unmarshal(response.Payload, &catalog)
+	if len(catalog.Functions) != 1 || catalog.Functions["transfer"].MinArgs != 2 {
+		t.Errorf("Expected the schema of 'transfer' only, got %v", catalog.Functions)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getSchemas", "bobby", "user", "fly"))
+	expectErrorCode(t, response, ErrNotFound)
+}
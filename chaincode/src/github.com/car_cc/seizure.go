@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Seizures.
+ *
+ * The police or a court seize a car with 'seizeCar' under
+ * a case reference. A seized car is frozen like a car with
+ * a VIN conflict, see 'IsFrozen'. The seizure can take the
+ * car into custody as well, which transfers it to the state
+ * custodian account of the configuration without consent
+ * of the owner.
+ *
+ * Every seizure action needs the case reference and emits
+ * 'carSeized' or 'carReleased'; police and court calls are
+ * in the audit log.
+ */
+
+/*
+ * Checks if 'car' is seized
+ */
+func IsSeized(car *Car) bool {
+	return car.Seizure.CaseRef != ""
+}
+
+/*
+ * Writes a car with a changed seizure and
+ * emits 'event' with the seizure
+ */
+func emitSeizure(stub shim.ChaincodeStubInterface, car *Car, event string) error {
+	seizureAsBytes, _ := ledgerjson.Marshal(SeizureEvent{Vin: car.Vin, Seizure: car.Seizure, TxId: stub.GetTxID()})
+	err := stub.SetEvent(event, seizureAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error emitting '"+event+"' event")
+	}
+
+	return nil
+}
+
+/*
+ * Seizes car 'vin' under case 'caseRef'. With 'custody',
+ * the car goes to the state custodian account.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  case reference                           string
+ *  (optional) take into custody             bool
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) seizeCar(stub shim.ChaincodeStubInterface, username string, role string, args []string) pb.Response {
+	vin := args[0]
+	caseRef := args[1]
+	if caseRef == "" {
+		return errorResponse(ErrInvalidArgument, "'seizeCar' expects a non-empty case reference")
+	}
+
+	custody := false
+	if len(args) > 2 {
+		var err error
+		custody, err = strconv.ParseBool(args[2])
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "'seizeCar' expects 'true' or 'false' for custody")
+		}
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsSeized(&car) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Car is seized already under case '%s'", car.Seizure.CaseRef))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Seizure = Seizure{CaseRef: caseRef, SeizedBy: username, Role: role, SeizedTs: now}
+
+	if !custody {
+		carAsBytes, _ := ledgerjson.Marshal(car)
+		err = stub.PutState(vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+
+		err = emitSeizure(stub, &car, "carSeized")
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		fmt.Printf("Car '%s' seized by '%s' under case '%s'\n", vin, username, caseRef)
+		return shim.Success(carAsBytes)
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if config.Custodian == "" {
+		return errorResponse(ErrInvalidState, "There is no custodian account configured")
+	} else if owner == config.Custodian {
+		return errorResponse(ErrInvalidState, "Car is in custody already")
+	} else if IsRented(&car) || IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, "The car is rented out or sold in installments. Seize it without custody until that is settled")
+	}
+
+	// the deposit goes back to whoever paid it
+	if hold := car.Listing.Hold; hold != nil {
+		_, err = t.updateBalance(stub, depositPayer(hold), hold.Amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	car.Seizure.Custodian = config.Custodian
+	car.Seizure.PreviousOwner = owner
+
+	err = recordPrice(stub, &car, priceKindSeizure, 0)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = emitSeizure(stub, &car, "carSeized")
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Car '%s' seized by '%s' under case '%s' and taken into custody\n", vin, username, caseRef)
+	return t.changeOwner(stub, car, owner, config.Custodian)
+}
+
+/*
+ * Releases a seized car. Cars in custody stay with the
+ * custodian, which transfers them like any owner.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) releaseCar(stub shim.ChaincodeStubInterface, username string, vin string, caseRef string) pb.Response {
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsSeized(&car) {
+		return errorResponse(ErrInvalidState, "Car is not seized")
+	} else if car.Seizure.CaseRef != caseRef {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("Car is seized under another case than '%s'", caseRef))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Seizure.ReleasedBy = username
+	car.Seizure.ReleasedTs = now
+
+	err = emitSeizure(stub, &car, "carReleased")
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Seizure = Seizure{}
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Car '%s' released by '%s' from case '%s'\n", vin, username, caseRef)
+	return shim.Success(carAsBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSeizeCar(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("seizeCar", owner, "garage", vin, "ZH-2024-17"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("seizeCar", "officer", "police", vin, ""))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke("2", util.ToChaincodeArgs("seizeCar", "officer", "police", vin, "ZH-2024-17"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	if stub.ChaincodeEventsChannel == nil || len(stub.ChaincodeEventsChannel) == 0 {
+		t.Error("Expected a 'carSeized' event")
+	} else if event := <-stub.ChaincodeEventsChannel; event.EventName != "carSeized" {
+		t.Errorf("Expected a 'carSeized' event, got '%s'", event.EventName)
+	}
+
+	// the seized car is frozen
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "garage", vin, "bobby"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "officer", "police", vin))
+	lookup := PoliceLookup{}
+	json.Unmarshal(response.Payload, &lookup)
+	if !lookup.Seized {
+		t.Errorf("Expected the police lookup to show the seizure, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("releaseCar", "judge", "court", vin, "ZH-2024-99"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("releaseCar", "judge", "court", vin, "ZH-2024-17"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// the court takes the car into custody
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("seizeCar", "judge", "court", vin, "ZH-2024-18", "true"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "custodian": "state-custody" }`))
+	response = stub.MockInvoke("3", util.ToChaincodeArgs("seizeCar", "judge", "court", vin, "ZH-2024-18", "true"))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Username != "state-custody" || car.Seizure.PreviousOwner != owner {
+		t.Fatalf("Expected the car in custody, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", "state-custody", "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	// seizures are in the audit log
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getAuditLog", "auditor", "regulator", "0", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)))
+	entries := []AuditEntry{}
+	json.Unmarshal(response.Payload, &entries)
+	seizures := 0
+	for _, entry := range entries {
+		if entry.Function == "seizeCar" {
+			seizures++
+		}
+	}
+	if seizures != 2 {
+		t.Errorf("Expected both seizures in the audit log, got %s", response.Payload)
+	}
+}
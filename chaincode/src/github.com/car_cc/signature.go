@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Signed documents.
+ *
+ * Manufacturers sign their certificates of conformity,
+ * insurers their policies, the registries of other
+ * countries their export certificates, see 'importCar'.
+ * The DOT registers the X.509 certificate an organization
+ * signs with under 'orgcert~<name>' with
+ * 'registerOrganizationCertificate'.
+ *
+ * A signature over a document is a signature over its
+ * sha256, so 'verifyDocumentSignature' checks it against
+ * the hash of an attachment without the document itself.
+ * ECDSA (ASN.1) and RSA (PKCS #1 v1.5) signatures with
+ * SHA-256 are supported. The result is stored on the
+ * attachment, one per organization, so the next owner
+ * sees who signed what without checking again.
+ */
+
+// object type of organization certificate keys
+const orgCertificateObjectType string = "orgcert"
+
+// kind of the vehicle registries of other countries
+const organizationRegistry string = "registry"
+
+// kinds of signing organizations
+var organizationKinds = []string{"manufacturer", "insurer", "inspection", organizationRegistry}
+
+/*
+ * Reads the certificate of organization 'name'
+ */
+func getOrganizationCertificate(stub shim.ChaincodeStubInterface, name string) (*OrganizationCertificate, error) {
+	key, err := stub.CreateCompositeKey(orgCertificateObjectType, []string{name})
+	if err != nil {
+		return nil, newError(ErrInternal, "Error creating certificate key")
+	}
+
+	certificateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading certificate")
+	} else if certificateAsBytes == nil {
+		return nil, newError(ErrNotFound, fmt.Sprintf("No certificate registered for organization '%s'", name))
+	}
+
+	certificate := OrganizationCertificate{}
+	err = ledgerjson.Unmarshal(certificateAsBytes, &certificate)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing certificate")
+	}
+
+	return &certificate, nil
+}
+
+/*
+ * Parses a PEM encoded X.509 certificate
+ */
+func parseCertificate(certificatePem string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certificatePem))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, newError(ErrInvalidArgument, "Expected a PEM encoded certificate")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, fmt.Sprintf("Invalid certificate: %s", err.Error()))
+	}
+
+	switch certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return certificate, nil
+	default:
+		return nil, newError(ErrInvalidArgument, "Only ECDSA and RSA certificates are supported")
+	}
+}
+
+/*
+ * Checks 'signature' over the sha256 'digest'
+ * with the key of 'certificate'
+ */
+func checkSignature(certificate *x509.Certificate, digest []byte, signature []byte) bool {
+	switch key := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest, signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+	}
+
+	return false
+}
+
+/*
+ * Registers the signing certificate of an organization,
+ * replacing the one registered before.
+ *
+ * Expects 'args':
+ *  organization name                        string
+ *  kind                                     string
+ *  PEM encoded certificate                  string
+ *
+ * On success,
+ * returns the registered certificate.
+ */
+func (t *CarChaincode) registerOrganizationCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	name := args[0]
+	if name == "" {
+		return errorResponse(ErrInvalidArgument, "'registerOrganizationCertificate' expects an organization name")
+	}
+
+	certificate, err := parseCertificate(args[2])
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fingerprint := sha256.Sum256(certificate.Raw)
+	orgCertificate := OrganizationCertificate{
+		Name:         name,
+		Kind:         args[1],
+		Certificate:  args[2],
+		Fingerprint:  hex.EncodeToString(fingerprint[:]),
+		NotAfter:     certificate.NotAfter.Unix(),
+		RegisteredTs: now,
+	}
+
+	key, err := stub.CreateCompositeKey(orgCertificateObjectType, []string{name})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating certificate key")
+	}
+
+	certificateAsBytes, _ := ledgerjson.Marshal(orgCertificate)
+	err = stub.PutState(key, certificateAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing certificate")
+	}
+
+	fmt.Printf("Certificate '%s' registered for organization '%s'\n", orgCertificate.Fingerprint, name)
+	return shim.Success(certificateAsBytes)
+}
+
+/*
+ * Verifies the signature of an organization over a
+ * document attached to a car and stores the result
+ * on the attachment.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  document hash                            string
+ *  organization name                        string
+ *  signature, base64 encoded                string
+ *
+ * On success,
+ * returns the attachment.
+ */
+func (t *CarChaincode) verifyDocumentSignature(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+	hash := args[1]
+
+	signature, err := base64.StdEncoding.DecodeString(args[3])
+	if err != nil || len(signature) == 0 {
+		return errorResponse(ErrInvalidArgument, "'verifyDocumentSignature' expects a base64 encoded signature")
+	}
+
+	allowed, err := t.canRead(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !allowed {
+		return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
+	}
+
+	attachments, err := getAttachments(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	var attachment *Attachment
+	for i := range attachments {
+		if attachments[i].Hash == hash {
+			attachment = &attachments[i]
+		}
+	}
+	if attachment == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("The document '%s' is not attached to the car", hash))
+	}
+
+	orgCertificate, err := getOrganizationCertificate(stub, args[2])
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// registered certificates parsed before
+	certificate, err := parseCertificate(orgCertificate.Certificate)
+	if err != nil {
+		return errorResponse(ErrInternal, "Error parsing registered certificate")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	digest, _ := hex.DecodeString(hash)
+	verification := SignatureVerification{
+		Organization: orgCertificate.Name,
+		Kind:         orgCertificate.Kind,
+		Fingerprint:  orgCertificate.Fingerprint,
+		Signature:    args[3],
+		VerifiedBy:   username,
+		VerifiedTs:   now.Unix(),
+	}
+
+	if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+		verification.Reason = "certificate not valid at the time of verification"
+	} else if !checkSignature(certificate, digest, signature) {
+		verification.Reason = "signature does not match the document"
+	} else {
+		verification.Valid = true
+	}
+
+	// keep the latest result per organization
+	signatures := []SignatureVerification{}
+	for _, previous := range attachment.Signatures {
+		if previous.Organization != verification.Organization {
+			signatures = append(signatures, previous)
+		}
+	}
+	attachment.Signatures = append(signatures, verification)
+
+	key, err := attachmentKey(stub, attachment)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	attachmentAsBytes, _ := ledgerjson.Marshal(attachment)
+	err = stub.PutState(key, attachmentAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing attachment")
+	}
+
+	fmt.Printf("Signature of '%s' over document '%s' of car '%s' valid: %t\n", verification.Organization, hash, vin, verification.Valid)
+	return shim.Success(attachmentAsBytes)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Creates a self-signed certificate for 'name',
+ * valid until 'notAfter'
+ */
+func signingCertificate(t *testing.T, name string, notAfter time.Time) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestVerifyDocumentSignature(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	document := []byte("certificate of conformity WVWZZZ6R6HY260780")
+	digest := sha256.Sum256(document)
+	hash := hex.EncodeToString(digest[:])
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("attachDocument", owner, "user", vin, "other", hash))
+
+	key, certificate := signingCertificate(t, "VW", time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	signatureAsBytes, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	signature := base64.StdEncoding.EncodeToString(signatureAsBytes)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "amag", "user", "VW", "manufacturer", certificate))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "inspector", "dot", "VW", "manufacturer", "not a certificate"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyDocumentSignature", owner, "user", vin, hash, "VW", signature))
+	expectErrorCode(t, response, ErrNotFound)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "inspector", "dot", "VW", "manufacturer", certificate))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyDocumentSignature", "bobby", "user", vin, hash, "VW", signature))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	// a signature over another document
+	otherDigest := sha256.Sum256([]byte("forged"))
+	forgedAsBytes, _ := ecdsa.SignASN1(rand.Reader, key, otherDigest[:])
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyDocumentSignature", owner, "user", vin, hash, "VW", base64.StdEncoding.EncodeToString(forgedAsBytes)))
+	attachment := Attachment{}
+	json.Unmarshal(response.Payload, &attachment)
+	if len(attachment.Signatures) != 1 || attachment.Signatures[0].Valid {
+		t.Fatalf("Expected an invalid signature, got %s", response.Payload)
+	}
+
+	// the result replaces the earlier one of the organization
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyDocumentSignature", owner, "user", vin, hash, "VW", signature))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getDocuments", owner, "user", vin))
+	attachments := []Attachment{}
+	json.Unmarshal(response.Payload, &attachments)
+	if len(attachments) != 1 || len(attachments[0].Signatures) != 1 || !attachments[0].Signatures[0].Valid {
+		t.Fatalf("Expected a valid signature, got %s", response.Payload)
+	} else if attachments[0].Signatures[0].Kind != "manufacturer" || attachments[0].Signatures[0].VerifiedBy != owner {
+		t.Errorf("Expected the manufacturer signature verified by the owner, got %+v", attachments[0].Signatures[0])
+	}
+
+	// expired certificates do not verify
+	key, certificate = signingCertificate(t, "VW", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+	signatureAsBytes, _ = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("registerOrganizationCertificate", "inspector", "dot", "VW", "manufacturer", certificate))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("verifyDocumentSignature", owner, "user", vin, hash, "VW", base64.StdEncoding.EncodeToString(signatureAsBytes)))
+	attachment = Attachment{}
+	json.Unmarshal(response.Payload, &attachment)
+	if len(attachment.Signatures) != 1 || attachment.Signatures[0].Valid || attachment.Signatures[0].Reason == "" {
+		t.Errorf("Expected the expired certificate to fail, got %s", response.Payload)
+	}
+}
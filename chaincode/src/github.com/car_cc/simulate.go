@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*
+ * Simulated invocations.
+ *
+ * UIs pre-validate forms by sending 'transfer', 'confirm'
+ * or 'create' with the transient field 'simulate' set to
+ * 'true'. The function runs with all its checks, like
+ * ownership, insurance, numberplate uniqueness and the
+ * balance of the buyer, and returns what it would return,
+ * but its writes and events are dropped.
+ *
+ * Reads are not affected. As in a real transaction, they
+ * see the ledger state before the invocation.
+ */
+
+// transient field asking for a simulated invocation
+const simulateTransient string = "simulate"
+
+// message of the responses of simulated invocations
+const simulatedMessage string = "simulated"
+
+/*
+ * Stub that drops all writes and events
+ */
+type simulationStub struct {
+	shim.ChaincodeStubInterface
+	writes int
+}
+
+func newSimulationStub(stub shim.ChaincodeStubInterface) *simulationStub {
+	return &simulationStub{ChaincodeStubInterface: stub}
+}
+
+func (s *simulationStub) PutState(key string, value []byte) error {
+	s.writes++
+	return nil
+}
+
+func (s *simulationStub) DelState(key string) error {
+	s.writes++
+	return nil
+}
+
+func (s *simulationStub) PutPrivateData(collection string, key string, value []byte) error {
+	s.writes++
+	return nil
+}
+
+func (s *simulationStub) DelPrivateData(collection string, key string) error {
+	s.writes++
+	return nil
+}
+
+func (s *simulationStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
+
+/*
+ * Reads from the transient data if the
+ * invocation is to be simulated
+ */
+func isSimulation(stub shim.ChaincodeStubInterface) (bool, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return false, newError(ErrLedger, "Error reading transient data")
+	}
+
+	value, ok := transient[simulateTransient]
+	if !ok {
+		return false, nil
+	}
+
+	simulate, err := strconv.ParseBool(string(value))
+	if err != nil {
+		return false, newError(ErrInvalidArgument, fmt.Sprintf("Expected 'true' or 'false' for '%s'", simulateTransient))
+	}
+
+	return simulate, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSimulate(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+
+	stub.TransientMap = map[string][]byte{simulateTransient: []byte("true")}
+	defer func() { stub.TransientMap = nil }()
+	keys := len(stub.State)
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+otherVin+`" }`))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if response.Status != shim.OK || response.Message != simulatedMessage || car.Vin != otherVin {
+		t.Fatalf("Expected the simulated car, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	expectErrorCode(t, response, ErrCarExists)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", owner, "dot", vin, "ZH 7878"))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if response.Status != shim.OK || car.Certificate.Numberplate != "ZH 7878" {
+		t.Fatalf("Expected the simulated confirmation, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, "bobby"))
+	if response.Status != shim.OK || response.Message != simulatedMessage {
+		t.Fatalf("Expected the simulated transfer, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", "bobby", "user", vin, owner))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	// nothing was written, not even a journal entry
+	if len(stub.State) != keys {
+		t.Errorf("Expected %d keys after simulating, got %d", keys, len(stub.State))
+	}
+
+	stub.TransientMap = nil
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", vin))
+	car = Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Numberplate != "" {
+		t.Errorf("Expected the car unconfirmed, got numberplate '%s'", car.Certificate.Numberplate)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", owner, "user", otherVin))
+	expectErrorCode(t, response, ErrCarNotFound)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Ledger snapshots for off-chain analytics.
+ *
+ * 'exportState' pages through the ledger in a versioned
+ * envelope, so an ETL job can build a reporting database.
+ *
+ * For incremental exports, every transaction that writes
+ * records the keys it changed in a journal entry under
+ * the range key 'jrn~<ts>~<txid>'. Like the expiry index,
+ * the keys are plain strings, so a page of changes is a
+ * single 'GetStateByRange' starting at its position.
+ * Chaincode cannot see block heights, so the journal is
+ * keyed on the transaction timestamp and the ETL job maps
+ * transaction ids to blocks from the block events it
+ * receives.
+ */
+
+// envelope format of 'exportState'
+const snapshotFormat string = "car_cc.snapshot"
+const snapshotFormatVersion int = 1
+
+// prefix of journal keys
+const journalPrefix string = "jrn~"
+
+// default number of entries per snapshot page
+const defaultSnapshotPageSize int = 100
+
+// prefix selecting composite keys of one object type
+const snapshotObjectTypePrefix string = "~"
+
+// suffix of the end key of a range over all keys with a
+// prefix, as an empty end key is no upper bound everywhere
+const maxKeySuffix string = "\U0010FFFF"
+
+/*
+ * Stub that records the keys a transaction changes,
+ * see 'flush'
+ */
+type journalStub struct {
+	shim.ChaincodeStubInterface
+	changed map[string]bool // deleted keys map to false
+}
+
+func newJournalStub(stub shim.ChaincodeStubInterface) *journalStub {
+	return &journalStub{ChaincodeStubInterface: stub, changed: make(map[string]bool)}
+}
+
+func (j *journalStub) PutState(key string, value []byte) error {
+	j.changed[key] = true
+	return j.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (j *journalStub) DelState(key string) error {
+	j.changed[key] = false
+	return j.ChaincodeStubInterface.DelState(key)
+}
+
+/*
+ * Writes the journal entry of the transaction,
+ * if it changed any keys
+ */
+func (j *journalStub) flush() error {
+	if len(j.changed) == 0 {
+		return nil
+	}
+
+	now, err := txUnix(j)
+	if err != nil {
+		return err
+	}
+
+	entry := JournalEntry{TxId: j.GetTxID(), Ts: now, Changed: []string{}, Deleted: []string{}}
+	for key, written := range j.changed {
+		if written {
+			entry.Changed = append(entry.Changed, key)
+		} else {
+			entry.Deleted = append(entry.Deleted, key)
+		}
+	}
+	sort.Strings(entry.Changed)
+	sort.Strings(entry.Deleted)
+
+	entryAsBytes, _ := ledgerjson.Marshal(entry)
+	err = j.ChaincodeStubInterface.PutState(getJournalKey(now, entry.TxId), entryAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing journal entry")
+	}
+
+	return nil
+}
+
+/*
+ * Returns the first journal key of transactions at 'ts',
+ * the keys sort by timestamp
+ */
+func journalRangeKey(ts int64) string {
+	return fmt.Sprintf("%s%012d~", journalPrefix, ts)
+}
+
+/*
+ * Returns the journal key of transaction 'txId' at 'ts'
+ */
+func getJournalKey(ts int64, txId string) string {
+	return journalRangeKey(ts) + txId
+}
+
+/*
+ * Returns a ledger value for the envelope. Values
+ * that are no JSON are exported as JSON string.
+ */
+func snapshotValue(value []byte) json.RawMessage {
+	if json.Valid(value) {
+		return json.RawMessage(value)
+	}
+
+	valueAsBytes, _ := ledgerjson.Marshal(string(value))
+	return json.RawMessage(valueAsBytes)
+}
+
+/*
+ * Checks if 'key' is selected by an export prefix
+ */
+func snapshotSelects(stub shim.ChaincodeStubInterface, prefix string, key string) bool {
+	if !strings.HasPrefix(prefix, snapshotObjectTypePrefix) {
+		// plain prefixes never select composite keys, and the
+		// journal is the bookkeeping of the export itself
+		return !strings.HasPrefix(key, "\x00") && !strings.HasPrefix(key, journalPrefix) && strings.HasPrefix(key, prefix)
+	}
+
+	objectType, _, err := stub.SplitCompositeKey(key)
+	return err == nil && strings.HasPrefix(key, "\x00") && objectType == prefix[len(snapshotObjectTypePrefix):]
+}
+
+/*
+ * Exports a page of ledger entries.
+ *
+ * A plain prefix selects the keys starting with it,
+ * e.g. 'usr_', '~<type>' selects the composite keys
+ * of one object type, e.g. '~proposal'.
+ *
+ * Without a journal position, all selected entries are
+ * exported. With a position, only entries changed by
+ * transactions at or after it are exported, including
+ * deleted ones. Every page returns the position to
+ * continue incrementally from.
+ *
+ * Arguments required:
+ * [0] Key prefix                  (string)
+ *
+ * Arguments optional:
+ * [1] Bookmark                    (string)
+ * [2] Page size                   (int)
+ * [3] Journal position            (int, unix timestamp)
+ *
+ * On success,
+ * returns the snapshot page.
+ */
+func (t *CarChaincode) exportState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	prefix := args[0]
+
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	pageSize := defaultSnapshotPageSize
+	if len(args) > 2 && args[2] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[2])
+		if err != nil || pageSize < 1 {
+			return errorResponse(ErrInvalidArgument, "'exportState' expects a positive page size")
+		}
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	page := SnapshotPage{
+		Format:  snapshotFormat,
+		Version: snapshotFormatVersion,
+		Mode:    "full",
+		Prefix:  prefix,
+		Entries: []SnapshotEntry{},
+		// continue from this export with the journal
+		Position: now}
+
+	if len(args) > 3 {
+		since, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return errorResponse(ErrInvalidArgument, "'exportState' expects the journal position as unix timestamp")
+		}
+
+		page.Mode = "incremental"
+		page.Position = since
+		err = t.exportChanges(stub, &page, since, bookmark, pageSize)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	} else {
+		err = t.exportEntries(stub, &page, bookmark, pageSize)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	pageAsBytes, _ := ledgerjson.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+/*
+ * Adds up to 'pageSize' selected entries after
+ * 'bookmark' in key order to the page.
+ *
+ * A plain prefix is a key range, which the page starts
+ * at the bookmark. Fabric refuses ranges over composite
+ * keys, so object types are read from their first key.
+ */
+func (t *CarChaincode) exportEntries(stub shim.ChaincodeStubInterface, page *SnapshotPage, bookmark string, pageSize int) error {
+	var iterator shim.StateQueryIteratorInterface
+	var err error
+	if strings.HasPrefix(page.Prefix, snapshotObjectTypePrefix) {
+		iterator, err = stub.GetStateByPartialCompositeKey(page.Prefix[len(snapshotObjectTypePrefix):], []string{})
+	} else {
+		start := page.Prefix
+		if bookmark > start {
+			start = bookmark
+		}
+		iterator, err = stub.GetStateByRange(start, page.Prefix+maxKeySuffix)
+	}
+	if err != nil {
+		return newError(ErrLedger, "Error reading ledger entries")
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return newError(ErrLedger, "Error reading ledger entries")
+		}
+
+		if kv.Key <= bookmark || !snapshotSelects(stub, page.Prefix, kv.Key) {
+			continue
+		}
+
+		// one more entry, so there is a next page
+		if len(page.Entries) == pageSize {
+			page.Bookmark = page.Entries[pageSize-1].Key
+			return nil
+		}
+
+		page.Entries = append(page.Entries, SnapshotEntry{Key: kv.Key, Value: snapshotValue(kv.Value)})
+	}
+
+	return nil
+}
+
+/*
+ * Adds the selected entries changed by up to 'pageSize'
+ * transactions at or after 'since' to the page. The
+ * bookmark is the journal key of the last transaction,
+ * the page reads the journal from there on.
+ */
+func (t *CarChaincode) exportChanges(stub shim.ChaincodeStubInterface, page *SnapshotPage, since int64, bookmark string, pageSize int) error {
+	start := journalRangeKey(since)
+	if bookmark > start {
+		start = bookmark
+	}
+
+	iterator, err := stub.GetStateByRange(start, journalPrefix+maxKeySuffix)
+	if err != nil {
+		return newError(ErrLedger, "Error reading journal")
+	}
+	defer iterator.Close()
+
+	transactions := 0
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return newError(ErrLedger, "Error reading journal")
+		}
+
+		if kv.Key == bookmark {
+			continue
+		}
+
+		// one more transaction, so there is a next page
+		if transactions == pageSize {
+			return nil
+		}
+
+		entry := JournalEntry{}
+		err = ledgerjson.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return newError(ErrLedger, "Error parsing journal entry")
+		}
+
+		for _, key := range entry.Changed {
+			if !snapshotSelects(stub, page.Prefix, key) {
+				continue
+			}
+
+			value, err := stub.GetState(key)
+			if err != nil {
+				return newError(ErrLedger, "Error reading ledger entry")
+			}
+
+			// deleted by a later transaction
+			if value == nil {
+				page.Entries = append(page.Entries, SnapshotEntry{Key: key, TxId: entry.TxId, Deleted: true})
+			} else {
+				page.Entries = append(page.Entries, SnapshotEntry{Key: key, TxId: entry.TxId, Value: snapshotValue(value)})
+			}
+		}
+
+		for _, key := range entry.Deleted {
+			if snapshotSelects(stub, page.Prefix, key) {
+				page.Entries = append(page.Entries, SnapshotEntry{Key: key, TxId: entry.TxId, Deleted: true})
+			}
+		}
+
+		transactions++
+		page.Bookmark = kv.Key
+		page.Position = entry.Ts
+	}
+
+	// no more transactions
+	page.Bookmark = ""
+	return nil
+}
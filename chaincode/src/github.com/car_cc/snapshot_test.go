@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Records the start keys of range queries
+type rangeStub struct {
+	shim.ChaincodeStubInterface
+	starts *[]string
+}
+
+func (s *rangeStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	*s.starts = append(*s.starts, startKey)
+	return s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+}
+
+// Invokes through a 'rangeStub'
+type rangeChaincode struct {
+	CarChaincode
+	starts []string
+}
+
+func (c *rangeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return c.CarChaincode.Invoke(&rangeStub{ChaincodeStubInterface: stub, starts: &c.starts})
+}
+
+func TestExportState(t *testing.T) {
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "bobby", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "amag", "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "amag", "garage", "usr_"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	// full export in pages of one entry
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", "", "1"))
+	page := SnapshotPage{}
+	err := json.Unmarshal(response.Payload, &page)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if page.Format != snapshotFormat || page.Version != snapshotFormatVersion || page.Mode != "full" {
+		t.Errorf("Unexpected envelope: %v", page)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Key != "usr_amag" || page.Bookmark != "usr_amag" {
+		t.Fatalf("Unexpected first page: %v", page)
+	}
+
+	user := User{}
+	json.Unmarshal(page.Entries[0].Value, &user)
+	// the cars are linked by ownership keys
+	if user.Name != "amag" || len(user.Cars) != 0 {
+		t.Errorf("Unexpected exported user: %v", user)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", page.Bookmark, "1"))
+	next := SnapshotPage{}
+	json.Unmarshal(response.Payload, &next)
+	if len(next.Entries) != 1 || next.Entries[0].Key != "usr_bobby" || next.Bookmark != "" {
+		t.Fatalf("Unexpected last page: %v", next)
+	}
+
+	// composite keys by object type
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "~proposal"))
+	proposals := SnapshotPage{}
+	json.Unmarshal(response.Payload, &proposals)
+	if len(proposals.Entries) != 1 {
+		t.Errorf("Expected the registration proposal, got %v", proposals.Entries)
+	}
+
+	// incremental export of the changes after the full export
+	stub.MockInvoke("tx-carl", util.ToChaincodeArgs("createUser", "carl", "user"))
+
+	position := strconv.FormatInt(page.Position, 10)
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", "", "", position))
+	changes := SnapshotPage{}
+	json.Unmarshal(response.Payload, &changes)
+	if changes.Mode != "incremental" {
+		t.Errorf("Expected an incremental export, got '%s'", changes.Mode)
+	}
+
+	found := false
+	for _, entry := range changes.Entries {
+		if entry.Key == "usr_carl" && entry.TxId == "tx-carl" {
+			found = true
+		} else if entry.Key[:4] != "usr_" {
+			t.Errorf("Export should only contain users, got '%s'", entry.Key)
+		}
+	}
+	if !found {
+		t.Errorf("Incremental export should contain the new user: %v", changes.Entries)
+	}
+}
+
+func TestExportStatePageRanges(t *testing.T) {
+	cc := &rangeChaincode{}
+	stub := shim.NewMockStub("car", cc)
+	ccSetup(t, stub)
+	stub.MockInvoke("tx-amag", util.ToChaincodeArgs("createUser", "amag", "garage"))
+	stub.MockInvoke("tx-bobby", util.ToChaincodeArgs("createUser", "bobby", "user"))
+	stub.MockInvoke("tx-carl", util.ToChaincodeArgs("createUser", "carl", "user"))
+
+	// every page starts reading at its bookmark
+	cc.starts = nil
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", "", "1"))
+	page := SnapshotPage{}
+	json.Unmarshal(response.Payload, &page)
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", page.Bookmark, "1"))
+	next := SnapshotPage{}
+	json.Unmarshal(response.Payload, &next)
+	if len(next.Entries) != 1 || next.Entries[0].Key != "usr_bobby" {
+		t.Fatalf("Unexpected second page: %v", next)
+	}
+
+	if len(cc.starts) != 2 || cc.starts[0] != "usr_" || cc.starts[1] != page.Bookmark {
+		t.Errorf("Expected the pages to start at the prefix and the bookmark, got %q", cc.starts)
+	}
+
+	// the journal is read from the position, then the bookmark
+	cc.starts = nil
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", "", "1", "0"))
+	changes := SnapshotPage{}
+	json.Unmarshal(response.Payload, &changes)
+	if changes.Bookmark == "" {
+		t.Fatalf("Expected more changes after the first transaction: %v", changes)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", "usr_", changes.Bookmark, "1", "0"))
+	if len(cc.starts) != 2 || cc.starts[0] != journalRangeKey(0) || cc.starts[1] != changes.Bookmark {
+		t.Errorf("Expected the journal read from the position and the bookmark, got %q", cc.starts)
+	}
+
+	// the journal is no ledger entry of its own
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("exportState", "admin", "admin", ""))
+	all := SnapshotPage{}
+	json.Unmarshal(response.Payload, &all)
+	for _, entry := range all.Entries {
+		if strings.HasPrefix(entry.Key, journalPrefix) {
+			t.Errorf("Full export should not contain journal entry '%s'", entry.Key)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+)
+
+/*
+ * Sort helpers for list queries.
+ *
+ * Go randomizes the iteration order of maps, so every list
+ * we build from an index has to be sorted explicitly before
+ * it is returned or written back to the ledger. Otherwise
+ * peers endorse different payloads for the same transaction
+ * and clients cannot diff two results reliably.
+ */
+
+/*
+ * Returns the keys of a string index in ascending order.
+ */
+func sortedKeys(index map[string]string) []string {
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+ * Insurance proposals ordered by car VIN,
+ * ties broken by the proposing username.
+ */
+type insureProposalsByCar []InsureProposal
+
+func (p insureProposalsByCar) Len() int      { return len(p) }
+func (p insureProposalsByCar) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p insureProposalsByCar) Less(i, j int) bool {
+	if p[i].Car != p[j].Car {
+		return p[i].Car < p[j].Car
+	}
+	return p[i].User < p[j].User
+}
+
+/*
+ * Claims ordered by creation timestamp,
+ * ties broken by claim id.
+ */
+type claimsByCreatedTs []Claim
+
+func (c claimsByCreatedTs) Len() int      { return len(c) }
+func (c claimsByCreatedTs) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c claimsByCreatedTs) Less(i, j int) bool {
+	if c[i].CreatedTs != c[j].CreatedTs {
+		return c[i].CreatedTs < c[j].CreatedTs
+	}
+	return c[i].Id < c[j].Id
+}
+
+/*
+ * Inventory items ordered by stock date, oldest stock
+ * first, ties broken by VIN.
+ */
+type inventoryByStockedTs []InventoryItem
+
+func (s inventoryByStockedTs) Len() int      { return len(s) }
+func (s inventoryByStockedTs) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s inventoryByStockedTs) Less(i, j int) bool {
+	if s[i].StockedTs != s[j].StockedTs {
+		return s[i].StockedTs < s[j].StockedTs
+	}
+	return s[i].Vin < s[j].Vin
+}
+
+/*
+ * Brand counts ordered by count, highest
+ * first, ties broken by brand name.
+ */
+type brandsByCount []BrandCount
+
+func (b brandsByCount) Len() int      { return len(b) }
+func (b brandsByCount) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b brandsByCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Brand < b[j].Brand
+}
+
+/*
+ * Index integrity issues ordered by car VIN,
+ * ties broken by kind and owner.
+ */
+type integrityIssuesByCar []IntegrityIssue
+
+func (p integrityIssuesByCar) Len() int      { return len(p) }
+func (p integrityIssuesByCar) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p integrityIssuesByCar) Less(i, j int) bool {
+	if p[i].Vin != p[j].Vin {
+		return p[i].Vin < p[j].Vin
+	} else if p[i].Kind != p[j].Kind {
+		return p[i].Kind < p[j].Kind
+	}
+	return p[i].Owner < p[j].Owner
+}
+
+/*
+ * Insured cars ordered by end of coverage,
+ * ties broken by VIN.
+ */
+type insuredCarsByPolicyEnd []InsuredCar
+
+func (c insuredCarsByPolicyEnd) Len() int      { return len(c) }
+func (c insuredCarsByPolicyEnd) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c insuredCarsByPolicyEnd) Less(i, j int) bool {
+	if c[i].Policy.EndTs != c[j].Policy.EndTs {
+		return c[i].Policy.EndTs < c[j].Policy.EndTs
+	}
+	return c[i].Vin < c[j].Vin
+}
+
+/*
+ * Registration proposals ordered by creation
+ * timestamp, ties broken by VIN.
+ */
+type proposalsByCreatedTs []RegistrationProposal
+
+func (p proposalsByCreatedTs) Len() int      { return len(p) }
+func (p proposalsByCreatedTs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p proposalsByCreatedTs) Less(i, j int) bool {
+	if p[i].CreatedTs != p[j].CreatedTs {
+		return p[i].CreatedTs < p[j].CreatedTs
+	}
+	return p[i].Car < p[j].Car
+}
+
+/*
+ * Revocation proposals ordered by creation
+ * timestamp, ties broken by VIN.
+ */
+type revocationProposalsByCreatedTs []RevocationProposal
+
+func (p revocationProposalsByCreatedTs) Len() int      { return len(p) }
+func (p revocationProposalsByCreatedTs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p revocationProposalsByCreatedTs) Less(i, j int) bool {
+	if p[i].CreatedTs != p[j].CreatedTs {
+		return p[i].CreatedTs < p[j].CreatedTs
+	}
+	return p[i].Car < p[j].Car
+}
+
+/*
+ * Car revisions ordered by commit timestamp,
+ * ties broken by transaction id.
+ */
+type revisionsByTs []CarRevision
+
+func (r revisionsByTs) Len() int      { return len(r) }
+func (r revisionsByTs) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r revisionsByTs) Less(i, j int) bool {
+	if r[i].Ts != r[j].Ts {
+		return r[i].Ts < r[j].Ts
+	}
+	return r[i].TxId < r[j].TxId
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * DOT reporting.
+ *
+ * Aggregates over all cars, so the DOT can monitor the
+ * registry without exporting the ledger. Every query
+ * counts one page of cars in VIN order and returns a
+ * bookmark for the next page. Clients add up the counts
+ * of all pages.
+ */
+
+// default number of cars counted per page
+const defaultStatsPageSize int = 500
+
+/*
+ * Parses the optional page size and bookmark
+ * arguments of a reporting query
+ */
+func statsPaging(function string, args []string) (int, string, error) {
+	pageSize := defaultStatsPageSize
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		pageSize, err = strconv.Atoi(args[0])
+		if err != nil || pageSize < 1 {
+			return 0, "", newError(ErrInvalidArgument, "'"+function+"' expects a positive page size")
+		}
+	}
+
+	bookmark := ""
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+
+	return pageSize, bookmark, nil
+}
+
+/*
+ * Calls 'visit' for up to 'pageSize' cars after
+ * 'bookmark' in VIN order.
+ *
+ * Returns the bookmark of the next page,
+ * empty if there are no more cars.
+ */
+func (t *CarChaincode) forEachCarPage(stub shim.ChaincodeStubInterface, pageSize int, bookmark string, visit func(*Car)) (string, error) {
+	carIndex, err := t.getCarIndex(stub)
+	if err != nil {
+		return "", err
+	}
+
+	vins := sortedKeys(carIndex)
+	start := sort.SearchStrings(vins, bookmark)
+	if start < len(vins) && vins[start] == bookmark {
+		start++
+	}
+
+	next := ""
+	if start+pageSize < len(vins) {
+		next = vins[start+pageSize-1]
+		vins = vins[:start+pageSize]
+	}
+
+	for _, vin := range vins[start:] {
+		owner, err := resolveOwner(stub, carIndex[vin])
+		if err != nil {
+			return "", err
+		}
+
+		car, err := t.getCar(stub, owner, vin)
+		if err != nil {
+			return "", err
+		}
+
+		visit(&car)
+	}
+
+	return next, nil
+}
+
+/*
+ * Counts the cars by status, see 'carStatus'.
+ *
+ * Arguments optional:
+ * [0] Page size                   (int)
+ * [1] Bookmark                    (string)
+ *
+ * On success,
+ * returns the counts by status.
+ */
+func (t *CarChaincode) countCarsByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	pageSize, bookmark, err := statsPaging("countCarsByStatus", args)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	counts := StatusCounts{Counts: make(map[string]int)}
+	counts.Bookmark, err = t.forEachCarPage(stub, pageSize, bookmark, func(car *Car) {
+		counts.Counts[carStatus(car, now)]++
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	countsAsBytes, _ := ledgerjson.Marshal(counts)
+	return shim.Success(countsAsBytes)
+}
+
+/*
+ * Counts the cars registered from 'from' until
+ * before 'to'. Cars registered before registration
+ * dates were recorded are not counted.
+ *
+ * Arguments required:
+ * [0] From                        (int, unix timestamp)
+ * [1] To                          (int, unix timestamp)
+ *
+ * Arguments optional:
+ * [2] Page size                   (int)
+ * [3] Bookmark                    (string)
+ *
+ * On success,
+ * returns the registration count.
+ */
+func (t *CarChaincode) countRegistrationsInPeriod(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	from, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'countRegistrationsInPeriod' expects the start as unix timestamp")
+	}
+
+	to, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || to < from {
+		return errorResponse(ErrInvalidArgument, "'countRegistrationsInPeriod' expects the end as unix timestamp after the start")
+	}
+
+	pageSize, bookmark, err := statsPaging("countRegistrationsInPeriod", args[2:])
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	count := RegistrationCount{From: from, To: to}
+	count.Bookmark, err = t.forEachCarPage(stub, pageSize, bookmark, func(car *Car) {
+		registeredTs := car.Certificate.RegisteredTs
+		if IsRegistered(car) && registeredTs != 0 && registeredTs >= from && registeredTs < to {
+			count.Count++
+		}
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	countAsBytes, _ := ledgerjson.Marshal(count)
+	return shim.Success(countAsBytes)
+}
+
+/*
+ * Ranks the brands of registered cars by count.
+ *
+ * Counts of several pages have to be added up before
+ * ranking, so the limit only applies to the last page
+ * of a query.
+ *
+ * Arguments optional:
+ * [0] Number of brands, 0 for all (int)
+ * [1] Page size                   (int)
+ * [2] Bookmark                    (string)
+ *
+ * On success,
+ * returns the brand ranking.
+ */
+func (t *CarChaincode) topBrandsRegistered(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	limit := 0
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		limit, err = strconv.Atoi(args[0])
+		if err != nil || limit < 0 {
+			return errorResponse(ErrInvalidArgument, "'topBrandsRegistered' expects the number of brands as integer")
+		}
+		args = args[1:]
+	}
+
+	pageSize, bookmark, err := statsPaging("topBrandsRegistered", args)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	counts := make(map[string]int)
+	ranking := BrandRanking{Brands: []BrandCount{}}
+	ranking.Bookmark, err = t.forEachCarPage(stub, pageSize, bookmark, func(car *Car) {
+		if IsRegistered(car) && car.Certificate.Brand != "" {
+			counts[car.Certificate.Brand]++
+		}
+	})
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	for brand, count := range counts {
+		ranking.Brands = append(ranking.Brands, BrandCount{Brand: brand, Count: count})
+	}
+	sort.Sort(brandsByCount(ranking.Brands))
+
+	if limit > 0 && ranking.Bookmark == "" && len(ranking.Brands) > limit {
+		ranking.Brands = ranking.Brands[:limit]
+	}
+
+	rankingAsBytes, _ := ledgerjson.Marshal(ranking)
+	return shim.Success(rankingAsBytes)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRegistryStatistics(t *testing.T) {
+	garage := "amag"
+	cars := map[string]string{
+		"WVWZZZ6R6HY260780": "VW",
+		"WVWZZZ6R8HY260781": "VW",
+		"WVWZZZ6RXHY260782": "Skoda",
+		"WVWZZZ6R1HY260783": "",
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	for vin, brand := range cars {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`", "certificate": { "brand": "`+brand+`" } }`))
+		if brand != "" {
+			stub.MockInvoke(uuid, util.ToChaincodeArgs("register", garage, "dot", vin))
+		}
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("countCarsByStatus", garage, "garage"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	// count in pages of three cars
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("countCarsByStatus", "dot", "dot", "3"))
+	counts := StatusCounts{}
+	json.Unmarshal(response.Payload, &counts)
+	if counts.Bookmark == "" {
+		t.Fatalf("First page should have a bookmark: %v", counts)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("countCarsByStatus", "dot", "dot", "3", counts.Bookmark))
+	next := StatusCounts{}
+	json.Unmarshal(response.Payload, &next)
+	if next.Bookmark != "" {
+		t.Errorf("Last page should have no bookmark: %v", next)
+	}
+
+	for status, count := range next.Counts {
+		counts.Counts[status] += count
+	}
+	if counts.Counts["registered"] != 3 || counts.Counts["unregistered"] != 1 {
+		t.Errorf("Unexpected status counts: %v", counts.Counts)
+	}
+
+	from := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	to := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("countRegistrationsInPeriod", "dot", "dot", from, to))
+	registrations := RegistrationCount{}
+	json.Unmarshal(response.Payload, &registrations)
+	if registrations.Count != 3 {
+		t.Errorf("Expected 3 registrations in the last hour, got %d", registrations.Count)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("countRegistrationsInPeriod", "dot", "dot", to, from))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("topBrandsRegistered", "dot", "dot", "1"))
+	ranking := BrandRanking{}
+	json.Unmarshal(response.Payload, &ranking)
+	if len(ranking.Brands) != 1 || ranking.Brands[0] != (BrandCount{Brand: "VW", Count: 2}) {
+		t.Errorf("Unexpected brand ranking: %v", ranking.Brands)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// sticker flags
+const (
+	stickerRegistered = 1 << iota
+	stickerInsured
+	stickerConfirmed
+	stickerWrittenOff
+)
+
+// registration validity of a new sticker
+const stickerValidity = 365 * 24 * time.Hour
+
+// transient field holding the DOT signing key
+const stickerKeyTransient string = "stickerKey"
+
+/*
+ * Reads the DOT public key for stickers from the configuration
+ */
+func (t *CarChaincode) getStickerKey(stub shim.ChaincodeStubInterface) (ed25519.PublicKey, error) {
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(config.StickerKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, newError(ErrNotFound, "No sticker key configured. Set one with 'setStickerKey' first")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+/*
+ * Generates the payload of a registration sticker.
+ *
+ * The payload is '<sticker>.<signature>', both base64url
+ * encoded, and short enough for a QR code. It is signed with
+ * the DOT private key, which is passed in the transient field
+ * 'stickerKey' so it never ends up on the ledger. ed25519
+ * signatures are deterministic, so all endorsers sign the
+ * same payload.
+ *
+ * On success,
+ * returns the sticker payload.
+ */
+func (t *CarChaincode) generateSticker(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	publicKey, err := t.getStickerKey(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading transient data")
+	}
+
+	// accept the 32 byte seed or the full private key
+	var privateKey ed25519.PrivateKey
+	seed := transient[stickerKeyTransient]
+	if len(seed) == ed25519.SeedSize {
+		privateKey = ed25519.NewKeyFromSeed(seed)
+	} else if len(seed) == ed25519.PrivateKeySize {
+		privateKey = ed25519.PrivateKey(seed)
+	} else {
+		return errorResponse(ErrInvalidArgument, "'generateSticker' expects the DOT signing key in transient field '"+stickerKeyTransient+"'")
+	}
+
+	if !bytes.Equal(privateKey.Public().(ed25519.PublicKey), publicKey) {
+		return errorResponse(ErrInvalidArgument, "Signing key does not match the configured sticker key")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Cannot issue a sticker for an unregistered car")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	sticker := Sticker{
+		Vin:         car.Vin,
+		Numberplate: car.Certificate.Numberplate,
+		ValidUntil:  now.Add(stickerValidity).Unix(),
+		Flags:       stickerRegistered}
+
+	if IsInsured(&car, now.Unix()) {
+		sticker.Flags |= stickerInsured
+	}
+	if IsConfirmed(&car, now.Unix()) {
+		sticker.Flags |= stickerConfirmed
+	}
+	if IsWrittenOff(&car) {
+		sticker.Flags |= stickerWrittenOff
+	}
+
+	stickerAsBytes, _ := ledgerjson.Marshal(sticker)
+	signature := ed25519.Sign(privateKey, stickerAsBytes)
+
+	payload := base64.RawURLEncoding.EncodeToString(stickerAsBytes) + "." +
+		base64.RawURLEncoding.EncodeToString(signature)
+
+	return shim.Success([]byte(payload))
+}
+
+/*
+ * Verifies a sticker payload against the DOT public key.
+ *
+ * Roadside devices can do the same check offline
+ * with the public key from the configuration.
+ *
+ * On success,
+ * returns the sticker check.
+ */
+func (t *CarChaincode) verifySticker(stub shim.ChaincodeStubInterface, payload string) pb.Response {
+	publicKey, err := t.getStickerKey(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return errorResponse(ErrInvalidArgument, "Malformed sticker payload")
+	}
+
+	stickerAsBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Malformed sticker payload")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Malformed sticker signature")
+	}
+
+	check := StickerCheck{}
+	err = ledgerjson.Unmarshal(stickerAsBytes, &check.Sticker)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "Malformed sticker payload")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	check.Valid = ed25519.Verify(publicKey, stickerAsBytes, signature)
+	check.Expired = check.Sticker.ValidUntil < now
+
+	checkAsBytes, _ := ledgerjson.Marshal(check)
+	return shim.Success(checkAsBytes)
+}
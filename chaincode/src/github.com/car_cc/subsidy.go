@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * EV subsidies.
+ *
+ * The government sets up subsidy programs with a budget
+ * with 'setSubsidyProgram' and pays subsidies out of them
+ * with 'grantSubsidy', crediting the owner of a registered
+ * electric car. Programs are kept under
+ * 'subsidyProgram~<program>' with what they granted so far,
+ * grants never exceed the budget.
+ *
+ * Every car and every owner gets at most one grant per
+ * program. Grants are kept under 'subsidyGrant~<program>~<vin>'
+ * and indexed under 'subsidyBuyer~<program>~<buyer>', so
+ * both checks are a single read.
+ */
+
+// object types of subsidy keys
+const subsidyProgramObjectType string = "subsidyProgram"
+const subsidyGrantObjectType string = "subsidyGrant"
+const subsidyBuyerObjectType string = "subsidyBuyer"
+
+/*
+ * Reads subsidy program 'program'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getSubsidyProgram(stub shim.ChaincodeStubInterface, program string) (*SubsidyProgram, error) {
+	key, err := stub.CreateCompositeKey(subsidyProgramObjectType, []string{program})
+	if err != nil {
+		return nil, newError(ErrInternal, "Error creating subsidy program key")
+	}
+
+	programAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading subsidy program")
+	} else if programAsBytes == nil {
+		return nil, nil
+	}
+
+	subsidyProgram := SubsidyProgram{}
+	err = ledgerjson.Unmarshal(programAsBytes, &subsidyProgram)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing subsidy program")
+	}
+
+	return &subsidyProgram, nil
+}
+
+/*
+ * Writes a subsidy program
+ */
+func saveSubsidyProgram(stub shim.ChaincodeStubInterface, program *SubsidyProgram) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(subsidyProgramObjectType, []string{program.Name})
+	if err != nil {
+		return nil, newError(ErrInternal, "Error creating subsidy program key")
+	}
+
+	programAsBytes, _ := ledgerjson.Marshal(program)
+	err = stub.PutState(key, programAsBytes)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error writing subsidy program")
+	}
+
+	return programAsBytes, nil
+}
+
+/*
+ * Checks if the key of 'objectType' for
+ * 'program' and 'id' holds a grant
+ */
+func hasSubsidyGrant(stub shim.ChaincodeStubInterface, objectType string, program string, id string) (bool, error) {
+	key, err := stub.CreateCompositeKey(objectType, []string{program, id})
+	if err != nil {
+		return false, newError(ErrInternal, "Error creating subsidy grant key")
+	}
+
+	grantAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, newError(ErrLedger, "Error reading subsidy grant")
+	}
+
+	return grantAsBytes != nil, nil
+}
+
+/*
+ * Sets up subsidy program 'program' with 'budget',
+ * or changes the budget of an existing program.
+ *
+ * On success,
+ * returns the program.
+ */
+func (t *CarChaincode) setSubsidyProgram(stub shim.ChaincodeStubInterface, username string, program string, budget int) pb.Response {
+	if program == "" {
+		return errorResponse(ErrInvalidArgument, "'setSubsidyProgram' expects a program name")
+	}
+
+	subsidyProgram, err := getSubsidyProgram(stub, program)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if subsidyProgram == nil {
+		subsidyProgram = &SubsidyProgram{Name: program, CreatedTs: now}
+	}
+
+	if budget < subsidyProgram.Granted {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'setSubsidyProgram' expects a budget of at least the %d granted", subsidyProgram.Granted))
+	}
+
+	subsidyProgram.Budget = budget
+	subsidyProgram.UpdatedBy = username
+	subsidyProgram.UpdatedTs = now
+
+	programAsBytes, err := saveSubsidyProgram(stub, subsidyProgram)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	return shim.Success(programAsBytes)
+}
+
+/*
+ * Grants a subsidy of 'amount' out of 'program' to the
+ * owner of registered electric car 'vin'.
+ *
+ * On success,
+ * returns the grant.
+ */
+func (t *CarChaincode) grantSubsidy(stub shim.ChaincodeStubInterface, username string, vin string, amount int, program string) pb.Response {
+	if amount <= 0 {
+		return errorResponse(ErrInvalidArgument, "'grantSubsidy' expects a positive amount")
+	}
+
+	subsidyProgram, err := getSubsidyProgram(stub, program)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if subsidyProgram == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no subsidy program '%s'", program))
+	} else if subsidyProgram.Granted+amount > subsidyProgram.Budget {
+		return errorResponse(ErrInsufficientFunds, fmt.Sprintf("Program '%s' has %d of its budget left", program, subsidyProgram.Budget-subsidyProgram.Granted))
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsElectric(&car) {
+		return errorResponse(ErrInvalidState, "Only electric cars qualify for subsidies")
+	} else if !IsRegistered(&car) {
+		return errorResponse(ErrInvalidState, "The car has to be registered first")
+	}
+
+	// one grant per car and per owner
+	granted, err := hasSubsidyGrant(stub, subsidyGrantObjectType, program, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if granted {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("Car '%s' got a subsidy of program '%s' already", vin, program))
+	}
+
+	granted, err = hasSubsidyGrant(stub, subsidyBuyerObjectType, program, owner)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if granted {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("'%s' got a subsidy of program '%s' already", owner, program))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	grant := SubsidyGrant{
+		Program:   program,
+		Vin:       vin,
+		Buyer:     owner,
+		Amount:    amount,
+		GrantedBy: username,
+		Ts:        now,
+		TxId:      stub.GetTxID()}
+
+	grantAsBytes, _ := ledgerjson.Marshal(grant)
+	for _, key := range [][]string{{subsidyGrantObjectType, program, vin}, {subsidyBuyerObjectType, program, owner}} {
+		grantKey, err := stub.CreateCompositeKey(key[0], key[1:])
+		if err != nil {
+			return errorResponse(ErrInternal, "Error creating subsidy grant key")
+		}
+
+		err = stub.PutState(grantKey, grantAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing subsidy grant")
+		}
+	}
+
+	subsidyProgram.Granted += amount
+	_, err = saveSubsidyProgram(stub, subsidyProgram)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	_, err = t.updateBalance(stub, owner, amount)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Subsidy of %d of program '%s' granted to '%s' for car '%s'\n", amount, program, owner, vin)
+	return shim.Success(grantAsBytes)
+}
+
+/*
+ * Reads subsidy program 'program'.
+ *
+ * On success,
+ * returns the program with its budget.
+ */
+func (t *CarChaincode) readSubsidyProgram(stub shim.ChaincodeStubInterface, program string) pb.Response {
+	subsidyProgram, err := getSubsidyProgram(stub, program)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if subsidyProgram == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no subsidy program '%s'", program))
+	}
+
+	programAsBytes, _ := ledgerjson.Marshal(subsidyProgram)
+	return shim.Success(programAsBytes)
+}
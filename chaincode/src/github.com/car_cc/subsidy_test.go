@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestGrantSubsidy(t *testing.T) {
+	dealer := "amag"
+	vin := "WVWZZZ6R6HY260780"
+	secondVin := "WVWZZZ6R8HY260781"
+	petrolVin := "WVWZZZ6RXHY260782"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", dealer, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "bobby", "user"))
+
+	// batteries are fitted by certified garages
+	expiry := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("certifyGarage", "inspector", "dot", dealer, scopeEvHighVoltage, expiry, "HV-"+dealer))
+
+	for i, carVin := range []string{vin, secondVin, petrolVin} {
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("create", dealer, "garage", `{ "vin": "`+carVin+`" }`))
+		stub.MockInvoke(uuid, util.ToChaincodeArgs("register", dealer, "dot", carVin))
+		if carVin != petrolVin {
+			stub.MockInvoke(uuid, util.ToChaincodeArgs("replacePart", dealer, "garage", carVin, partBattery, "B-"+strconv.Itoa(i)))
+		}
+	}
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", dealer, "garage", secondVin, "bobby"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("setSubsidyProgram", dealer, "garage", "ev2025", "50"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setSubsidyProgram", "bund", "government", "ev2025", "50"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantSubsidy", "bund", "government", petrolVin, "20", "ev2025"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantSubsidy", "bund", "government", vin, "20", "ev2025"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// no second grant for the car, nor for its owner
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantSubsidy", "bund", "government", vin, "20", "ev2025"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", dealer, "garage", vin, "bobby"))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("grantSubsidy", "bund", "government", secondVin, "40", "ev2025"))
+	expectErrorCode(t, response, ErrInsufficientFunds)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("grantSubsidy", "bund", "government", secondVin, "30", "ev2025"))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readSubsidyProgram", "bobby", "user", "ev2025"))
+	program := SubsidyProgram{}
+	json.Unmarshal(response.Payload, &program)
+	if program.Granted != 50 {
+		t.Errorf("Expected 50 granted, got %d", program.Granted)
+	}
+
+	for username, balance := range map[string]int{dealer: 120, "bobby": 130} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.Balance != balance {
+			t.Errorf("Expected a balance of %d for '%s', got %d", balance, username, user.Balance)
+		}
+	}
+}
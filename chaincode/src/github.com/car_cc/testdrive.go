@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Test drives.
+ *
+ * The owner, or an agent with a sell mandate, schedules a
+ * test drive of a prospective buyer with 'scheduleTestDrive'.
+ * Test drives are kept under 'testDrive~<vin>~<from>' with
+ * the agreed liability and whether the car's insurance
+ * covers the whole drive. During the drive the prospect may
+ * read the car like an assigned driver.
+ *
+ * A claim filed for an accident during the drive names the
+ * prospect as liable, up to the agreed excess, if the terms
+ * put the liability on the prospect. The claim then counts
+ * in the claims history of the prospect instead of the owner.
+ */
+
+// object type of test drive keys
+const testDriveObjectType string = "testDrive"
+
+// longest test drive
+const maxTestDriveDays int64 = 3
+
+// parties liable for accidents during a test drive
+const liabilityProspect string = "prospect"
+const liabilityOwner string = "owner"
+
+/*
+ * Reads the test drives of car 'vin', earliest first
+ */
+func getTestDrives(stub shim.ChaincodeStubInterface, vin string) ([]TestDrive, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(testDriveObjectType, []string{vin})
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading test drives")
+	}
+	defer iterator.Close()
+
+	drives := []TestDrive{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, newError(ErrLedger, "Error reading test drives")
+		}
+
+		drive := TestDrive{}
+		err = ledgerjson.Unmarshal(kv.Value, &drive)
+		if err != nil {
+			return nil, newError(ErrLedger, "Error parsing test drive")
+		}
+
+		drives = append(drives, drive)
+	}
+
+	return drives, nil
+}
+
+/*
+ * Returns the test drive of car 'vin' at 'ts'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getTestDriveAt(stub shim.ChaincodeStubInterface, vin string, ts int64) (*TestDrive, error) {
+	drives, err := getTestDrives(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range drives {
+		if drives[i].FromTs <= ts && ts < drives[i].ToTs {
+			return &drives[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+/*
+ * Checks if 'username' test drives car 'vin' at 'now'
+ */
+func isTestDriving(stub shim.ChaincodeStubInterface, username string, vin string, now int64) bool {
+	drive, err := getTestDriveAt(stub, vin, now)
+	return err == nil && drive != nil && drive.Prospect == username
+}
+
+/*
+ * Attributes a claim for an accident during a test
+ * drive to the prospect, if the terms say so
+ */
+func attributeTestDriveLiability(stub shim.ChaincodeStubInterface, claim *Claim) error {
+	drive, err := getTestDriveAt(stub, claim.Car, claim.CreatedTs)
+	if err != nil {
+		return err
+	} else if drive == nil || drive.Terms.Liability != liabilityProspect {
+		return nil
+	}
+
+	claim.Liable = drive.Prospect
+	claim.LiableAmount = claim.Amount
+	if drive.Terms.Excess > 0 && drive.Terms.Excess < claim.Amount {
+		claim.LiableAmount = drive.Terms.Excess
+	}
+
+	return nil
+}
+
+/*
+ * Schedules a test drive of car 'vin' by 'prospect'
+ * from 'fromTs' until 'toTs' on 'terms'.
+ *
+ * On success,
+ * returns the test drive.
+ */
+func (t *CarChaincode) scheduleTestDrive(stub shim.ChaincodeStubInterface, username string, owner string, vin string, prospect string, fromTs int64, toTs int64, terms TestDriveTerms) pb.Response {
+	if prospect == "" || prospect == owner {
+		return errorResponse(ErrInvalidArgument, "'scheduleTestDrive' expects a prospect other than the owner")
+	} else if terms.Liability != liabilityProspect && terms.Liability != liabilityOwner {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'scheduleTestDrive' expects the liability '%s' or '%s'", liabilityProspect, liabilityOwner))
+	} else if terms.Excess < 0 {
+		return errorResponse(ErrInvalidArgument, "'scheduleTestDrive' expects an excess of at least 0")
+	} else if toTs <= fromTs || toTs-fromTs > maxTestDriveDays*secondsPerDay {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'scheduleTestDrive' expects a test drive of at most %d days", maxTestDriveDays))
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if IsRented(&car) || IsSoldInInstallments(&car) {
+		return errorResponse(ErrInvalidState, "The car is rented out or sold in installments")
+	}
+
+	_, err = t.getUser(stub, prospect)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if toTs <= now {
+		return errorResponse(ErrInvalidArgument, "Cannot schedule a test drive in the past")
+	}
+
+	drives, err := getTestDrives(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+	for _, drive := range drives {
+		if drive.FromTs < toTs && fromTs < drive.ToTs {
+			return errorResponse(ErrInvalidState, fmt.Sprintf("The car is test driven by '%s' at that time", drive.Prospect))
+		}
+	}
+
+	drive := TestDrive{
+		Vin:         vin,
+		Owner:       owner,
+		Prospect:    prospect,
+		FromTs:      fromTs,
+		ToTs:        toTs,
+		Terms:       terms,
+		Insurer:     car.Certificate.Insurer,
+		Insured:     IsInsured(&car, fromTs) && IsInsured(&car, toTs-1),
+		ScheduledBy: username,
+		ScheduledTs: now}
+
+	key, err := stub.CreateCompositeKey(testDriveObjectType, []string{vin, fmt.Sprintf("%020d", fromTs)})
+	if err != nil {
+		return errorResponse(ErrInternal, "Error creating test drive key")
+	}
+
+	driveAsBytes, _ := ledgerjson.Marshal(drive)
+	err = stub.PutState(key, driveAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing test drive")
+	}
+
+	fmt.Printf("Test drive of car '%s' by '%s' scheduled, liable is the %s\n", vin, prospect, terms.Liability)
+	return shim.Success(driveAsBytes)
+}
+
+/*
+ * Lists the test drives of car 'vin'
+ * for those allowed to read the car.
+ *
+ * On success,
+ * returns the test drives, earliest first.
+ */
+func (t *CarChaincode) readTestDrives(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	allowed, err := t.canRead(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !allowed {
+		return errorResponse(ErrNotOwner, "Forbidden: the owner did not allow you to read this car")
+	}
+
+	drives, err := getTestDrives(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	drivesAsBytes, _ := ledgerjson.Marshal(drives)
+	return shim.Success(drivesAsBytes)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTestDriveLiability(t *testing.T) {
+	owner := "amag"
+	prospect := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	ts := func(hours int) string {
+		return strconv.FormatInt(time.Now().Add(time.Duration(hours)*time.Hour).Unix(), 10)
+	}
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", prospect, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "emil", "user"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", prospect, "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("scheduleTestDrive", "mallory", "user", vin, prospect, ts(-1), ts(2)))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("scheduleTestDrive", owner, "garage", vin, prospect, ts(-1), ts(24*4)))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("scheduleTestDrive", owner, "garage", vin, prospect, ts(-1), ts(2), liabilityProspect, "30"))
+	drive := TestDrive{}
+	err := json.Unmarshal(response.Payload, &drive)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if drive.Insurer != "axa" || !drive.Insured || drive.Terms.Excess != 30 {
+		t.Errorf("Expected a test drive covered by 'axa' with an excess of 30: %v", drive)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("scheduleTestDrive", owner, "garage", vin, "emil", ts(1), ts(3)))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	// the prospect drives the car
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", prospect, "user", vin))
+	if response.Status != shim.OK {
+		t.Fatal("Prospect should read the car during the test drive: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("fileClaim", owner, "user", vin, "hit a pole", "100"))
+	claim := Claim{}
+	json.Unmarshal(response.Payload, &claim)
+	if claim.Liable != prospect || claim.LiableAmount != 30 {
+		t.Fatalf("Expected the prospect to be liable for 30, got %v", claim)
+	}
+
+	// the claim counts for the prospect
+	for username, claims := range map[string]int{owner: 0, prospect: 1} {
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		if user.ClaimsHistory.TotalClaims != claims {
+			t.Errorf("Expected %d claims for '%s', got %d", claims, username, user.ClaimsHistory.TotalClaims)
+		}
+	}
+}
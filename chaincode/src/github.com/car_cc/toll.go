@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Toll and congestion charges.
+ *
+ * The owner links a car to their account with a toll
+ * operator with 'linkTollAccount'. The operator posts the
+ * usage of linked cars in batches with 'postTolls', every
+ * posting is settled from the balance of the owner right
+ * away. What the balance does not cover is added to the
+ * toll debt of the car, which the owner settles with
+ * 'settleTollDebt'. Postings are kept under
+ * 'tollPosting~<vin>~<reference>', so a batch sent twice
+ * is not charged twice.
+ *
+ * The debt stays with the car when it changes hands, the
+ * link does not. Above the threshold 'toll_debt_threshold'
+ * in the configuration, the car cannot be transferred.
+ */
+
+// object type of toll posting keys
+const tollPostingObjectType string = "tollPosting"
+
+// toll debt above which transfers are refused by default
+const defaultTollDebtThreshold int = 100
+
+/*
+ * Returns the toll debt above which transfers are refused
+ */
+func tollDebtThreshold(config Config) int {
+	if config.TollDebtThreshold <= 0 {
+		return defaultTollDebtThreshold
+	}
+
+	return config.TollDebtThreshold
+}
+
+/*
+ * Checks that a car with toll debt above
+ * the threshold stays with the owner
+ */
+func checkTollDebt(car *Car, config Config) error {
+	if car.Toll.Debt > tollDebtThreshold(config) {
+		return newError(ErrInvalidState, fmt.Sprintf("The car has unpaid toll of %d with '%s'. It has to be settled first in order to do the transfer", car.Toll.Debt, car.Toll.Operator))
+	}
+
+	return nil
+}
+
+/*
+ * Links car 'vin' to 'account' of the owner
+ * with toll operator 'operator'.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) linkTollAccount(stub shim.ChaincodeStubInterface, username string, vin string, operator string, account string) pb.Response {
+	if account == "" {
+		return errorResponse(ErrInvalidArgument, "'linkTollAccount' expects a non-empty account")
+	}
+
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// debt is owed to the operator it was posted by
+	if car.Toll.Debt > 0 && car.Toll.Operator != operator {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("The car has unpaid toll with '%s'. It has to be settled first", car.Toll.Operator))
+	}
+
+	_, err = t.getUser(stub, operator)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.Toll.Operator = operator
+	car.Toll.Account = account
+	car.Toll.LinkedTs = now
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Car '%s' linked to toll account '%s' with '%s'\n", vin, account, operator)
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Removes the toll account link of car 'vin'.
+ * Unpaid toll stays with the car.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) unlinkTollAccount(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Toll.Account == "" {
+		return errorResponse(ErrInvalidState, "The car is not linked to a toll account")
+	}
+
+	car.Toll.Account = ""
+	car.Toll.LinkedTs = 0
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	return shim.Success(carAsBytes)
+}
+
+/*
+ * Posts the toll usage of cars linked to
+ * the accounts of toll operator 'username'.
+ *
+ * Like 'createBatch', a bad posting does not fail the
+ * batch. Balances and cars are read once and written
+ * once, as a transaction does not see its own writes.
+ *
+ * Arguments required:
+ * [0] List of postings            (json, []TollPosting)
+ *
+ * On success,
+ * returns a result per posting in input order.
+ */
+func (t *CarChaincode) postTolls(stub shim.ChaincodeStubInterface, username string, postingsData string) pb.Response {
+	postings := []TollPosting{}
+	err := ledgerjson.Unmarshal([]byte(postingsData), &postings)
+	if err != nil || len(postings) == 0 {
+		return errorResponse(ErrInvalidArgument, "'postTolls' expects a non-empty list of toll postings as json")
+	} else if len(postings) > maxBatchSize {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'postTolls' accepts at most %d postings, got %d", maxBatchSize, len(postings)))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	cars := make(map[string]*Car)
+	owners := make(map[string]string)
+	balances := make(map[string]int)
+	charged := make(map[string]int)
+	posted := make(map[string]bool)
+	order := []string{}
+	total := 0
+
+	results := []BatchResult{}
+	for _, posting := range postings {
+		if posting.Amount <= 0 || posting.Reference == "" {
+			results = append(results, BatchResult{Vin: posting.Vin, Error: newError(ErrInvalidArgument, "Toll postings need a positive amount and a reference")})
+			continue
+		}
+
+		car, ok := cars[posting.Vin]
+		if !ok {
+			loaded, owner, err := t.getHandoffCar(stub, posting.Vin)
+			if ccErr, ok := err.(*ChaincodeError); ok && ccErr.Code == ErrLedger {
+				return errorResponseFrom(err)
+			} else if err != nil {
+				results = append(results, BatchResult{Vin: posting.Vin, Error: ccErr})
+				continue
+			}
+
+			car = &loaded
+			cars[posting.Vin] = car
+			owners[posting.Vin] = owner
+			order = append(order, posting.Vin)
+		}
+
+		if car.Toll.Operator != username || car.Toll.Account == "" {
+			results = append(results, BatchResult{Vin: posting.Vin, Error: newError(ErrForbidden, "Forbidden: the car is not linked to an account with the operator")})
+			continue
+		}
+
+		key, err := stub.CreateCompositeKey(tollPostingObjectType, []string{posting.Vin, posting.Reference})
+		if err != nil {
+			return errorResponse(ErrInternal, "Error creating toll posting key")
+		}
+
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading toll posting")
+		} else if existing != nil || posted[key] {
+			results = append(results, BatchResult{Vin: posting.Vin, Error: newError(ErrAlreadyExists, fmt.Sprintf("Toll posting '%s' is posted already", posting.Reference))})
+			continue
+		}
+		posted[key] = true
+
+		owner := owners[posting.Vin]
+		if _, ok := balances[owner]; !ok {
+			user, err := t.getUser(stub, owner)
+			if err != nil {
+				return errorResponseFrom(err)
+			}
+			balances[owner] = user.Balance
+		}
+
+		// the balance pays what it covers, the rest is debt
+		paid := posting.Amount
+		if paid > balances[owner] {
+			paid = balances[owner]
+		}
+		balances[owner] -= paid
+		charged[owner] += paid
+		total += paid
+		car.Toll.Debt += posting.Amount - paid
+
+		posting.Operator = username
+		posting.Account = car.Toll.Account
+		posting.Owner = owner
+		posting.Paid = paid
+		posting.PostedTs = now
+
+		postingAsBytes, _ := ledgerjson.Marshal(posting)
+		err = stub.PutState(key, postingAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing toll posting")
+		}
+
+		results = append(results, BatchResult{Vin: posting.Vin, Ok: true})
+	}
+
+	for _, vin := range order {
+		carAsBytes, _ := ledgerjson.Marshal(cars[vin])
+		err = stub.PutState(vin, carAsBytes)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error writing car")
+		}
+	}
+
+	for owner, amount := range charged {
+		if amount == 0 || owner == username {
+			continue
+		}
+
+		_, err = t.updateBalance(stub, owner, -amount)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	if total > charged[username] {
+		_, err = t.updateBalance(stub, username, total-charged[username])
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	fmt.Printf("Toll of %d settled by operator '%s' in %d postings\n", total, username, len(postings))
+
+	resultsAsBytes, _ := ledgerjson.Marshal(results)
+	return shim.Success(resultsAsBytes)
+}
+
+/*
+ * Pays the toll debt of car 'vin' from the
+ * balance of the owner to the toll operator.
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) settleTollDebt(stub shim.ChaincodeStubInterface, username string, vin string) pb.Response {
+	car, err := t.getCar(stub, username, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if car.Toll.Debt == 0 {
+		return errorResponse(ErrInvalidState, "There is no unpaid toll on the car")
+	}
+
+	debt := car.Toll.Debt
+	if car.Toll.Operator != username {
+		_, err = t.updateBalance(stub, username, -debt)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		_, err = t.updateBalance(stub, car.Toll.Operator, debt)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	}
+
+	car.Toll.Debt = 0
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	fmt.Printf("Toll debt of %d on car '%s' settled with '%s'\n", debt, vin, car.Toll.Operator)
+	return shim.Success(carAsBytes)
+}
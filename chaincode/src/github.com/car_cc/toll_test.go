@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTollPostings(t *testing.T) {
+	owner := "amag"
+	operator := "asfinag"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", operator, "toll_operator"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "bobby", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+otherVin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "toll_debt_threshold": 20 }`))
+	readCar := func(username string) Car {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", username, "user", vin))
+		car := Car{}
+		json.Unmarshal(response.Payload, &car)
+		return car
+	}
+	balance := func(username string) int {
+		response := stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", username, "user"))
+		user := User{}
+		json.Unmarshal(response.Payload, &user)
+		return user.Balance
+	}
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("linkTollAccount", owner, "user", vin, operator, "GO-4711"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	postings := `[
+		{ "vin": "` + vin + `", "reference": "r1", "amount": 60 },
+		{ "vin": "` + vin + `", "reference": "r2", "amount": 70 },
+		{ "vin": "` + vin + `", "reference": "r1", "amount": 60 },
+		{ "vin": "` + otherVin + `", "reference": "r3", "amount": 10 }
+	]`
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("postTolls", owner, "user", postings))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("postTolls", operator, "toll_operator", postings))
+	results := []BatchResult{}
+	json.Unmarshal(response.Payload, &results)
+	if len(results) != 4 || !results[0].Ok || !results[1].Ok {
+		t.Fatalf("Expected 4 results with the first two posted, got %v: %s", results, response.Message)
+	}
+	if results[2].Error == nil || results[2].Error.Code != ErrAlreadyExists {
+		t.Errorf("Expected the repeated posting to be refused, got %v", results[2])
+	}
+	if results[3].Error == nil || results[3].Error.Code != ErrForbidden {
+		t.Errorf("Expected the posting for the unlinked car to be refused, got %v", results[3])
+	}
+
+	// the balance of 100 covers all but 30
+	if car := readCar(owner); car.Toll.Debt != 30 {
+		t.Errorf("Expected a toll debt of 30, got %d", car.Toll.Debt)
+	}
+	if balance(owner) != 0 || balance(operator) != 200 {
+		t.Errorf("Expected 100 to go to the operator, got %d and %d", balance(owner), balance(operator))
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, "bobby"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("settleTollDebt", owner, "user", vin))
+	expectErrorCode(t, response, ErrInsufficientFunds)
+
+	// below the threshold, the debt goes with the car
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("updateConfig", "admin", "admin", `{ "toll_debt_threshold": 50 }`))
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, "bobby"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if car := readCar("bobby"); car.Toll.Account != "" || car.Toll.Debt != 30 {
+		t.Errorf("Expected the debt without the account link, got %v", car.Toll)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("settleTollDebt", "bobby", "user", vin))
+	if car := readCar("bobby"); car.Toll.Debt != 0 || balance("bobby") != 70 || balance(operator) != 230 {
+		t.Errorf("Expected the debt of 30 settled with the operator, got %d", car.Toll.Debt)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Transit permits.
+ *
+ * Cars leaving the country are driven to the destination
+ * on a temporary export plate before they are registered
+ * there. The DOT issues the plate and permit for a number
+ * of days with 'issueTransitPermit'. Permits are kept under
+ * 'transit~<vin>' apart from the car, so they stay readable
+ * after the export archived the car.
+ *
+ * Permits are never changed on expiry, every read checks
+ * the expiry date against the transaction time instead.
+ */
+
+// object type of transit permit keys
+const transitObjectType string = "transit"
+
+// longest a transit permit is issued for
+const maxTransitPermitDays int = 90
+
+/*
+ * Checks if a transit permit is not expired at 'now'
+ */
+func IsTransitPermitValid(permit *TransitPermit, now int64) bool {
+	return permit != nil && permit.IssuedTs <= now && now < permit.ExpiresTs
+}
+
+/*
+ * Returns the ledger key of the transit permit of a car
+ */
+func getTransitKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(transitObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating transit permit key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the latest transit permit of a car, expired or not.
+ *
+ * Returns 'nil' if none was issued.
+ */
+func getTransitPermit(stub shim.ChaincodeStubInterface, vin string) (*TransitPermit, error) {
+	key, err := getTransitKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	permitAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading transit permit")
+	} else if permitAsBytes == nil {
+		return nil, nil
+	}
+
+	permit := TransitPermit{}
+	err = ledgerjson.Unmarshal(permitAsBytes, &permit)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing transit permit")
+	}
+
+	return &permit, nil
+}
+
+/*
+ * Returns the temporary plate of a transit permit,
+ * derived from the VIN and the issuing transaction
+ */
+func transitPlate(vin string, txId string) string {
+	hash := sha256.Sum256([]byte(vin + txId))
+	return "EXP " + strings.ToUpper(hex.EncodeToString(hash[:4]))
+}
+
+/*
+ * Issues a temporary export plate and transit permit for
+ * a registered car without numberplate. A new permit
+ * replaces the old one.
+ *
+ * Expects 'args':
+ *  VIN                                      string
+ *  valid days                               int
+ *  (optional) destination country           string
+ *
+ * On success,
+ * returns the transit permit.
+ */
+func (t *CarChaincode) issueTransitPermit(stub shim.ChaincodeStubInterface, username string, args []string) pb.Response {
+	vin := args[0]
+	days, err := strconv.Atoi(args[1])
+	if err != nil || days < 1 || days > maxTransitPermitDays {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("'issueTransitPermit' expects between 1 and %d valid days", maxTransitPermitDays))
+	}
+
+	destination := ""
+	if len(args) > 2 {
+		destination = args[2]
+	}
+
+	car, _, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// like an export, the permit vouches for the VIN
+	if !IsRegistered(&car) {
+		return errorResponse(ErrNotRegistered, "Only registered cars get a transit permit")
+	} else if IsConfirmed(&car, now) {
+		return errorResponse(ErrInvalidState, "The car is confirmed and drives on its numberplate. It has to be revoked first")
+	} else if IsFrozen(&car) {
+		return errorResponse(ErrInvalidState, "The car is frozen and cannot get a transit permit")
+	}
+
+	permit := TransitPermit{
+		Vin:         vin,
+		Plate:       transitPlate(vin, stub.GetTxID()),
+		Destination: destination,
+		IssuedBy:    username,
+		IssuedTs:    now,
+		ExpiresTs:   now + int64(days)*secondsPerDay,
+	}
+
+	previous, err := getTransitPermit(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	previousTs := int64(0)
+	if previous != nil {
+		previousTs = previous.ExpiresTs
+	}
+
+	err = updateExpiry(stub, expiryTransit, vin, previousTs, permit.ExpiresTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := getTransitKey(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	permitAsBytes, _ := ledgerjson.Marshal(permit)
+	err = stub.PutState(key, permitAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing transit permit")
+	}
+
+	fmt.Printf("Issued transit permit '%s' for car with VIN '%s' until '%d'\n", permit.Plate, vin, permit.ExpiresTs)
+	return shim.Success(permitAsBytes)
+}
+
+/*
+ * Reads the transit permit of a car.
+ * Expired permits are not returned.
+ *
+ * On success,
+ * returns the transit permit.
+ */
+func (t *CarChaincode) readTransitPermit(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	permit, err := getTransitPermit(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if permit == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no transit permit for car with VIN '%s'", vin))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if !IsTransitPermitValid(permit, now) {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Transit permit '%s' expired", permit.Plate))
+	}
+
+	permitAsBytes, _ := ledgerjson.Marshal(permit)
+	return shim.Success(permitAsBytes)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTransitPermit(t *testing.T) {
+	owner := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", owner, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", owner, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("issueTransitPermit", "inspector", "dot", vin, "30"))
+	expectErrorCode(t, response, ErrNotRegistered)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", owner, "dot", vin))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueTransitPermit", owner, "garage", vin, "30"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueTransitPermit", "inspector", "dot", vin, "365"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("issueTransitPermit", "inspector", "dot", vin, "30", "DE"))
+	permit := TransitPermit{}
+	json.Unmarshal(response.Payload, &permit)
+	if !strings.HasPrefix(permit.Plate, "EXP ") || permit.Destination != "DE" || permit.ExpiresTs-permit.IssuedTs != 30*secondsPerDay {
+		t.Fatalf("Expected a transit permit for 30 days, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readTransitPermit", "bobby", "user", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "officer", "police", vin))
+	lookup := PoliceLookup{}
+	json.Unmarshal(response.Payload, &lookup)
+	if lookup.TransitPermit == nil || lookup.TransitPermit.Plate != permit.Plate {
+		t.Errorf("Expected the police lookup to show the transit permit, got %s", response.Payload)
+	}
+
+	// let the permit expire
+	stub.MockTransactionStart(uuid)
+	key, _ := getTransitKey(stub, vin)
+	permit.IssuedTs -= 31 * secondsPerDay
+	permit.ExpiresTs -= 31 * secondsPerDay
+	permitAsBytes, _ := json.Marshal(permit)
+	stub.PutState(key, permitAsBytes)
+	stub.MockTransactionEnd(uuid)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readTransitPermit", "bobby", "user", vin))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("policeLookup", "officer", "police", vin))
+	lookup = PoliceLookup{}
+	json.Unmarshal(response.Payload, &lookup)
+	if lookup.TransitPermit != nil {
+		t.Errorf("Expected no expired transit permit in the police lookup, got %s", response.Payload)
+	}
+}
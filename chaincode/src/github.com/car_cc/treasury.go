@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * DOT treasury.
+ *
+ * Taxes and fees are deducted from the payer's balance
+ * and collected on the treasury account. What is charged
+ * is set in the fee schedule of the configuration: every
+ * fee has a flat part and a percentage of the amount it
+ * is charged on, e.g. the sale price for the transfer tax.
+ */
+
+// ledger key of the treasury account
+const treasuryStr string = "_treasury"
+
+// fee names
+const transferTaxName string = "transfer_tax"     // paid by the buyer on every sale
+const registrationFeeName string = "registration" // paid by the owner on every confirmation
+
+/*
+ * Returns fee 'name' for 'amount' according to the fee schedule.
+ *
+ * Fees without a flat part in the schedule use 'fallback'.
+ */
+func scheduledFee(config Config, name string, amount int, fallback int) int {
+	return configFee(config, name, fallback) + amount*config.FeePercentages[name]/100
+}
+
+/*
+ * Reads the treasury account
+ */
+func (t *CarChaincode) getTreasury(stub shim.ChaincodeStubInterface) (Treasury, error) {
+	treasuryAsBytes, err := stub.GetState(treasuryStr)
+	if err != nil {
+		return Treasury{}, newError(ErrLedger, "Error reading treasury")
+	}
+
+	treasury := Treasury{Collected: make(map[string]int)}
+	if treasuryAsBytes == nil {
+		return treasury, nil
+	}
+
+	err = ledgerjson.Unmarshal(treasuryAsBytes, &treasury)
+	if err != nil {
+		return Treasury{}, newError(ErrLedger, "Error parsing treasury")
+	}
+
+	return treasury, nil
+}
+
+/*
+ * Credits fee 'name' to the treasury account.
+ *
+ * Reads within a transaction do not see its own writes,
+ * so call this at most once per transaction.
+ */
+func (t *CarChaincode) collectFee(stub shim.ChaincodeStubInterface, name string, fee int) error {
+	if fee == 0 {
+		return nil
+	}
+
+	treasury, err := t.getTreasury(stub)
+	if err != nil {
+		return err
+	}
+
+	treasury.Balance += fee
+	if treasury.Collected == nil {
+		treasury.Collected = make(map[string]int)
+	}
+	treasury.Collected[name] += fee
+
+	treasuryAsBytes, _ := ledgerjson.Marshal(treasury)
+	err = stub.PutState(treasuryStr, treasuryAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing treasury")
+	}
+
+	return nil
+}
+
+/*
+ * Deducts fee 'name' from the balance of 'payer'
+ * and credits it to the treasury account.
+ */
+func (t *CarChaincode) chargeFee(stub shim.ChaincodeStubInterface, payer string, name string, fee int) error {
+	if fee == 0 {
+		return nil
+	}
+
+	_, err := t.updateBalance(stub, payer, -fee)
+	if err != nil {
+		return err
+	}
+
+	return t.collectFee(stub, name, fee)
+}
+
+/*
+ * Reads the treasury account.
+ *
+ * On success,
+ * returns the treasury with its balance and
+ * the amount collected per fee.
+ */
+func (t *CarChaincode) getTreasuryBalance(stub shim.ChaincodeStubInterface) pb.Response {
+	treasury, err := t.getTreasury(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	treasuryAsBytes, _ := ledgerjson.Marshal(treasury)
+	return shim.Success(treasuryAsBytes)
+}
+
+/*
+ * Sets a fee of the fee schedule.
+ *
+ * Arguments required:
+ * [0] Fee name                    (string)
+ *     e.g. 'transfer_tax', 'registration', 'plate_reservation'
+ * [1] Flat amount                 (int)
+ * [2] Percentage of the amount    (int)
+ *
+ * On success,
+ * returns the configuration.
+ */
+func (t *CarChaincode) setFeeSchedule(stub shim.ChaincodeStubInterface, role string, args []string) pb.Response {
+	name := args[0]
+	flat, err := strconv.Atoi(args[1])
+	if err != nil || flat < 0 {
+		return errorResponse(ErrInvalidArgument, "'setFeeSchedule' expects a flat amount of 0 or more")
+	}
+
+	percent, err := strconv.Atoi(args[2])
+	if err != nil || percent < 0 || percent > 100 {
+		return errorResponse(ErrInvalidArgument, "'setFeeSchedule' expects a percentage between 0 and 100")
+	}
+
+	if name == "" {
+		return errorResponse(ErrInvalidArgument, "'setFeeSchedule' expects a non-empty fee name")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAdmin(stub, config, role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if config.Fees == nil {
+		config.Fees = make(map[string]int)
+	}
+	if config.FeePercentages == nil {
+		config.FeePercentages = make(map[string]int)
+	}
+
+	config.Fees[name] = flat
+	config.FeePercentages[name] = percent
+
+	err = t.saveConfig(stub, config)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Fee '%s' set to %d plus %d%%\n", name, flat, percent)
+
+	configAsBytes, _ := ledgerjson.Marshal(config)
+	return shim.Success(configAsBytes)
+}
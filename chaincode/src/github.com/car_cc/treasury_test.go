@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTransferTaxAndRegistrationFee(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	otherVin := "WVWZZZ6R8HY260781"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	// 10% transfer tax, 15 credits per confirmation
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", seller, "garage", transferTaxName, "0", "10"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", transferTaxName, "0", "101"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", transferTaxName, "0", "10"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", registrationFeeName, "15", "0"))
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("register", seller, "dot", vin))
+
+	// 95 plus tax is more than the buyer has
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "95", vin, buyer))
+	expectErrorCode(t, response, ErrInsufficientFunds)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "50", vin, buyer))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", buyer, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 45 {
+		t.Errorf("Buyer should have paid price and tax, balance is %d", user.Balance)
+	}
+
+	// the owner pays for the confirmation
+	insureCar(t, stub, seller, otherVin, "axa")
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("confirm", seller, "dot", otherVin, "ZH 7878"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", seller, "user"))
+	user = User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 150-15 {
+		t.Errorf("Owner should have paid the registration fee, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getTreasuryBalance", "inspector", "dot"))
+	treasury := Treasury{}
+	err := json.Unmarshal(response.Payload, &treasury)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if treasury.Balance != 20 || treasury.Collected[transferTaxName] != 5 || treasury.Collected[registrationFeeName] != 15 {
+		t.Errorf("Unexpected treasury: %v", treasury)
+	}
+}
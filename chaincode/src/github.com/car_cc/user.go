@@ -1,18 +1,24 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 
+	"github.com/car_cc/ledgerjson"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+// credits every new user gets to buy cars
+const newUserCredits int = 100
+
 /*
  * Creates a new user and appends it to the user index.
  * Returns an error if a user with the desired username already exists.
  *
+ * The username is bound to the identity of the invoking
+ * client. Later invocations with that username must come
+ * from the same identity.
+ *
  * Until we have an interface to stock up user credits,
  * every new user gets 100 credits for free to buy cars.
  *
@@ -20,90 +26,189 @@ import (
  * returns the user.
  */
 func (t *CarChaincode) createUser(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	identity, err := getCallerIdentity(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	user, err := t.newUser(stub, username, identity)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	// user creation successfull,
+	// return the user
+	userAsBytes, _ := ledgerjson.Marshal(user)
+	return shim.Success(userAsBytes)
+}
+
+/*
+ * Creates user 'username' with the credits of a new
+ * user, bound to 'identity' if not empty.
+ * Returns an error if the user already exists.
+ */
+func (t *CarChaincode) newUser(stub shim.ChaincodeStubInterface, username string, identity string) (User, error) {
+	if username == "" {
+		return User{}, newError(ErrInvalidArgument, "A user needs a non-empty username")
+	}
+
 	// check if user with this username already exists
 	_, err := t.getUser(stub, username)
 	if err == nil {
-		return shim.Error(fmt.Sprintf("User with username '%s' already exists. Choose another username.", username))
+		return User{}, newError(ErrUserExists, fmt.Sprintf("User with username '%s' already exists. Choose another username.", username))
 	}
 
 	// user does not exist yet,
 	// create user
 	fmt.Printf("User '%s' does not exist yet\nSaving new user with that username\n", username)
-	user := User{Name: username, Cars: []string{}, Balance: 100}
+	user := User{Name: username, Cars: []string{}, Balance: newUserCredits, Identity: identity}
 
+	err = t.addUser(stub, user)
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+/*
+ * Returns the user of car owner 'username' and its
+ * pseudonym, creating the user like 'createUser' if
+ * it does not exist yet.
+ *
+ * For owners of cars arriving from another ledger, e.g.
+ * by import or handoff. The owner does not invoke, so
+ * no identity is bound to a created user.
+ */
+func (t *CarChaincode) ensureOwner(stub shim.ChaincodeStubInterface, username string) (User, string, error) {
+	user, err := t.getUser(stub, username)
+	if err != nil {
+		user, err = t.newUser(stub, username, "")
+		if err != nil {
+			return User{}, "", err
+		}
+	}
+
+	pseudonym, err := registerPseudonym(stub, username)
+	if err != nil {
+		return User{}, "", err
+	}
+
+	return user, pseudonym, nil
+}
+
+/*
+ * Adds a new user to the user index and writes it to ledger
+ */
+func (t *CarChaincode) addUser(stub shim.ChaincodeStubInterface, user User) error {
 	userIndex, err := t.getUserIndex(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
+	// the claims history starts with the user
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+	user.ClaimsHistory.SinceTs = now
+
 	// map the user to the userIndex
-	userIndex[username] = username
-	fmt.Printf("Added user with Username '%s' to user index.\n", username)
+	userIndex[user.Name] = user.Name
+	fmt.Printf("Added user with Username '%s' to user index.\n", user.Name)
 
 	// write udpated user index back to ledger
-	indexAsBytes, _ := json.Marshal(userIndex)
+	indexAsBytes, _ := ledgerjson.Marshal(userIndex)
 	err = stub.PutState(userIndexStr, indexAsBytes)
 	if err != nil {
-		return shim.Error("Error writing updated user index to ledger")
+		return newError(ErrLedger, "Error writing updated user index to ledger")
 	}
 
 	// write new user to ledger
-	err = t.saveUser(stub, user)
+	return t.saveUser(stub, user)
+}
+
+/*
+ * Reads the user of the invoker with
+ * the cars linked to it.
+ *
+ * On success,
+ * returns the user.
+ */
+func (t *CarChaincode) readUser(stub shim.ChaincodeStubInterface, username string) pb.Response {
+	user, err := t.getUser(stub, username)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
-	// user creation successfull,
-	// return the user
-	userAsBytes, _ := json.Marshal(user)
+	user.Cars, err = getUserCars(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	userAsBytes, _ := ledgerjson.Marshal(user)
 	return shim.Success(userAsBytes)
 }
 
 /*
- * Deletes a user from the ledger.
+ * Deletes the user of the invoker from the ledger.
+ * The remaining balance goes to another user.
  *
  * Returns 'nil' on success.
  */
 func (t *CarChaincode) deleteUser(stub shim.ChaincodeStubInterface, username string, remainingBalanceRecipient string) pb.Response {
 	userIndexMap, err := t.getUserIndex(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponseFrom(err)
 	}
 
 	// getting user which shall be deleted
 	userToDelete, err := t.getUser(stub, username)
 	if err != nil {
-		return shim.Error("User to delete does not exist. Username: '" + username + "'")
+		return errorResponse(ErrUserNotFound, "User to delete does not exist. Username: '"+username+"'")
 	}
 
 	// getting the user which receives the remaining balance
 	balanceRecipient, err := t.getUser(stub, remainingBalanceRecipient)
 	if err != nil {
-		return shim.Error("User does not exist. Username: '" + username + "'")
+		return errorResponse(ErrUserNotFound, "User does not exist. Username: '"+remainingBalanceRecipient+"'")
 	}
 
 	// check if user doesn't own a car anymore
-	if len(userToDelete.Cars) != 0 {
-		return shim.Error("Deletion of user not possible. User '" + username + "' still owns '" + string(len(userToDelete.Cars)) + "' cars.")
+	cars, err := getUserCars(stub, username)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if len(cars) != 0 {
+		return errorResponse(ErrInvalidState, fmt.Sprintf("Deletion of user not possible. User '%s' still owns '%d' cars.", username, len(cars)))
 	}
 
 	// transfer remaining balance to chosen recipient
 	balanceRecipient.Balance += userToDelete.Balance
+	err = t.saveUser(stub, balanceRecipient)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
 
 	// delete user from user index
 	delete(userIndexMap, userToDelete.Name)
 
 	// write udpated user index back to ledger
-	indexAsBytes, _ := json.Marshal(userIndexMap)
+	indexAsBytes, _ := ledgerjson.Marshal(userIndexMap)
 	err = stub.PutState(userIndexStr, indexAsBytes)
 	if err != nil {
-		return shim.Error("Error writing user index")
+		return errorResponse(ErrLedger, "Error writing user index")
+	}
+
+	// personal data does not outlive the user
+	err = erasePersonalData(stub, userToDelete.Name)
+	if err != nil {
+		return errorResponseFrom(err)
 	}
 
 	// Delete the user key from the state in ledger
 	err = stub.DelState("usr_" + userToDelete.Name)
 	if err != nil {
-		return shim.Error("Failed to delete user from state")
+		return errorResponse(ErrLedger, "Failed to delete user from state")
 	}
 
 	fmt.Printf("Successfully deleted user with username: '%s'\n", userToDelete.Name)
@@ -116,9 +221,9 @@ func (t *CarChaincode) deleteUser(stub shim.ChaincodeStubInterface, username str
 func (t *CarChaincode) getUserIndex(stub shim.ChaincodeStubInterface) (map[string]string, error) {
 	response := t.read(stub, userIndexStr)
 	userIndex := make(map[string]string)
-	err := json.Unmarshal(response.Payload, &userIndex)
+	err := ledgerjson.Unmarshal(response.Payload, &userIndex)
 	if err != nil {
-		return nil, errors.New("Error parsing user index")
+		return nil, newError(ErrLedger, "Error parsing user index")
 	}
 
 	return userIndex, nil
@@ -130,9 +235,9 @@ func (t *CarChaincode) getUserIndex(stub shim.ChaincodeStubInterface) (map[strin
 func (t *CarChaincode) getUser(stub shim.ChaincodeStubInterface, username string) (User, error) {
 	response := t.read(stub, "usr_"+username)
 	var user User
-	err := json.Unmarshal(response.Payload, &user)
+	err := ledgerjson.Unmarshal(response.Payload, &user)
 	if err != nil {
-		return User{}, errors.New("Error parsing user index")
+		return User{}, newError(ErrUserNotFound, "User '"+username+"' does not exist")
 	}
 
 	return user, nil
@@ -142,10 +247,13 @@ func (t *CarChaincode) getUser(stub shim.ChaincodeStubInterface, username string
  * Writes updated user back to ledger
  */
 func (t *CarChaincode) saveUser(stub shim.ChaincodeStubInterface, user User) error {
-	userAsBytes, _ := json.Marshal(user)
+	// the cars are linked by ownership keys
+	user.Cars = nil
+
+	userAsBytes, _ := ledgerjson.Marshal(user)
 	err := stub.PutState("usr_"+user.Name, userAsBytes)
 	if err != nil {
-		return errors.New("Error writing userIndex back to ledger")
+		return newError(ErrLedger, "Error writing userIndex back to ledger")
 	}
 
 	return nil
@@ -158,7 +266,7 @@ func (t *CarChaincode) setBalance(stub shim.ChaincodeStubInterface, username str
 	// fetch user
 	user, err := t.getUser(stub, username)
 	if err != nil {
-		return User{}, errors.New("Error fetching user, balance not set")
+		return User{}, newError(ErrUserNotFound, "Error fetching user, balance not set")
 	}
 
 	// set new user balance
@@ -167,7 +275,7 @@ func (t *CarChaincode) setBalance(stub shim.ChaincodeStubInterface, username str
 	// save updated user
 	err = t.saveUser(stub, user)
 	if err != nil {
-		return User{}, errors.New("Error writing user, balance not set")
+		return User{}, newError(ErrLedger, "Error writing user, balance not set")
 	}
 
 	fmt.Printf("Balance of user '" + user.Name + "' successfully set")
@@ -182,12 +290,12 @@ func (t *CarChaincode) updateBalance(stub shim.ChaincodeStubInterface, username
 	// fetch user
 	user, err := t.getUser(stub, username)
 	if err != nil {
-		return User{}, errors.New("Error fetching user, balance not updated")
+		return User{}, newError(ErrUserNotFound, "Error fetching user, balance not updated")
 	}
 
 	// check if user balance does not go below zero
 	if user.Balance+updateAmount < 0 {
-		return user, errors.New("Updating balance not possible. User balance would go below zero")
+		return user, newError(ErrInsufficientFunds, "Updating balance not possible. User balance would go below zero")
 	}
 
 	// update user balance
@@ -196,10 +304,23 @@ func (t *CarChaincode) updateBalance(stub shim.ChaincodeStubInterface, username
 	// save updated user
 	err = t.saveUser(stub, user)
 	if err != nil {
-		return User{}, errors.New("Error writing user, balance not updated")
+		return User{}, newError(ErrLedger, "Error writing user, balance not updated")
 	}
 
 	fmt.Printf("Balance of user '" + user.Name + "' successfully updated")
 
 	return user, nil
 }
+
+/*
+ * Checks if 'list' contains 'value'
+ */
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-    "encoding/json"
 
+	"github.com/car_cc/ledgerjson"
     "github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
@@ -12,7 +12,7 @@ import (
 func clearStringIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
     index := make(map[string]string)
 
-    jsonAsBytes, err := json.Marshal(index)
+    jsonAsBytes, err := ledgerjson.Marshal(index)
     if err != nil {
         return err
     }
@@ -26,7 +26,7 @@ func clearStringIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
 func clearInsurerIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
     index := make(map[string]Insurer)
 
-    jsonAsBytes, err := json.Marshal(index)
+    jsonAsBytes, err := ledgerjson.Marshal(index)
     if err != nil {
         return err
     }
@@ -35,12 +35,54 @@ func clearInsurerIndex(indexStr string, stub shim.ChaincodeStubInterface) error
 }
 
 /*
- * Clears an index of type 'map[string]RegistrationProposal' on the ledger
+ * Clears an index of type 'map[string]ExportCertificate' on the ledger
  */
-func clearRegistrationProposalIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
-    index := make(map[string]RegistrationProposal)
+func clearExportIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
+    index := make(map[string]ExportCertificate)
 
-    jsonAsBytes, err := json.Marshal(index)
+    jsonAsBytes, err := ledgerjson.Marshal(index)
+    if err != nil {
+        return err
+    }
+
+    return stub.PutState(indexStr, jsonAsBytes)
+}
+
+/*
+ * Clears an index of type 'map[string]PortfolioTransfer' on the ledger
+ */
+func clearPortfolioTransferIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
+    index := make(map[string]PortfolioTransfer)
+
+    jsonAsBytes, err := ledgerjson.Marshal(index)
+    if err != nil {
+        return err
+    }
+
+    return stub.PutState(indexStr, jsonAsBytes)
+}
+
+/*
+ * Clears an index of type 'map[string]map[string]int64' on the ledger
+ */
+func clearReadGrantIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
+    index := make(map[string]map[string]int64)
+
+    jsonAsBytes, err := ledgerjson.Marshal(index)
+    if err != nil {
+        return err
+    }
+
+    return stub.PutState(indexStr, jsonAsBytes)
+}
+
+/*
+ * Clears an index of type 'map[string]map[string]InventoryEntry' on the ledger
+ */
+func clearInventoryIndex(indexStr string, stub shim.ChaincodeStubInterface) error {
+    index := make(map[string]map[string]InventoryEntry)
+
+    jsonAsBytes, err := ledgerjson.Marshal(index)
     if err != nil {
         return err
     }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Transfer tax valuation.
+ *
+ * Admins keep a reference value per brand and model under
+ * 'refvalue~<brand>~<model>'. A sale declaring a price below
+ * the configured share of the reference value is not
+ * completed, but held under 'salereview~<vin>' until the
+ * DOT approves it with 'approveSale' or rejects it with
+ * 'rejectSale'. On approval the transfer tax is charged on
+ * the value assessed by the DOT, so underdeclared prices
+ * do not lower the tax.
+ */
+
+// object type of reference value keys
+const referenceValueObjectType string = "refvalue"
+
+// object type of sale review keys
+const saleReviewObjectType string = "salereview"
+
+// sale review states
+const saleReviewPending string = "pending"
+const saleReviewApproved string = "approved"
+const saleReviewRejected string = "rejected"
+
+// percent of the reference value below which sales are reviewed by default
+const defaultValuationThreshold int = 50
+
+/*
+ * Returns the percent of the reference value
+ * below which a declared price is reviewed
+ */
+func valuationThreshold(config Config) int {
+	if config.ValuationThreshold <= 0 {
+		return defaultValuationThreshold
+	}
+
+	return config.ValuationThreshold
+}
+
+/*
+ * Checks if 'price' is suspiciously low for a car
+ * with reference value 'value'
+ */
+func isUndervalued(config Config, price int, value int) bool {
+	return price*100 < value*valuationThreshold(config)
+}
+
+/*
+ * Returns the ledger key of the reference value of a model
+ */
+func getReferenceValueKey(stub shim.ChaincodeStubInterface, brand string, model string) (string, error) {
+	key, err := stub.CreateCompositeKey(referenceValueObjectType, []string{brand, model})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating reference value key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the reference value of a model.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getReferenceValue(stub shim.ChaincodeStubInterface, brand string, model string) (*ReferenceValue, error) {
+	key, err := getReferenceValueKey(stub, brand, model)
+	if err != nil {
+		return nil, err
+	}
+
+	valueAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading reference value")
+	} else if valueAsBytes == nil {
+		return nil, nil
+	}
+
+	value := ReferenceValue{}
+	err = ledgerjson.Unmarshal(valueAsBytes, &value)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing reference value")
+	}
+
+	return &value, nil
+}
+
+/*
+ * Returns the ledger key of the sale review of car 'vin'
+ */
+func getSaleReviewKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(saleReviewObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating sale review key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the last sale review of car 'vin'.
+ *
+ * Returns 'nil' if there is none.
+ */
+func getSaleReview(stub shim.ChaincodeStubInterface, vin string) (*SaleReview, error) {
+	key, err := getSaleReviewKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	reviewAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading sale review")
+	} else if reviewAsBytes == nil {
+		return nil, nil
+	}
+
+	review := SaleReview{}
+	err = ledgerjson.Unmarshal(reviewAsBytes, &review)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing sale review")
+	}
+
+	return &review, nil
+}
+
+/*
+ * Writes a sale review to ledger
+ */
+func saveSaleReview(stub shim.ChaincodeStubInterface, review SaleReview) error {
+	key, err := getSaleReviewKey(stub, review.Vin)
+	if err != nil {
+		return err
+	}
+
+	reviewAsBytes, _ := ledgerjson.Marshal(review)
+	err = stub.PutState(key, reviewAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing sale review")
+	}
+
+	return nil
+}
+
+/*
+ * Reads the pending sale review of car 'vin'
+ */
+func getPendingSaleReview(stub shim.ChaincodeStubInterface, vin string) (SaleReview, error) {
+	review, err := getSaleReview(stub, vin)
+	if err != nil {
+		return SaleReview{}, err
+	} else if review == nil || review.Status != saleReviewPending {
+		return SaleReview{}, newError(ErrNotFound, fmt.Sprintf("There is no sale of car with VIN '%s' waiting for review", vin))
+	}
+
+	return *review, nil
+}
+
+/*
+ * Holds back a sale below the reference value for the DOT.
+ *
+ * Fabric only keeps one event per transaction, the
+ * 'saleFlagged' event replaces the 'carSold' event.
+ *
+ * On success,
+ * returns the sale review.
+ */
+func (t *CarChaincode) flagSale(stub shim.ChaincodeStubInterface, seller string, buyer string, vin string, price int, value int) pb.Response {
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	review := SaleReview{
+		Vin:            vin,
+		Seller:         seller,
+		Buyer:          buyer,
+		Price:          price,
+		ReferenceValue: value,
+		Status:         saleReviewPending,
+		CreatedTs:      now,
+	}
+
+	err = saveSaleReview(stub, review)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reviewAsBytes, _ := ledgerjson.Marshal(review)
+	err = stub.SetEvent("saleFlagged", reviewAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error emitting 'saleFlagged' event")
+	}
+
+	fmt.Printf("Sale of car '%s' for %d flagged, reference value is %d\n", vin, price, value)
+	return shim.Success(reviewAsBytes)
+}
+
+/*
+ * Sets the reference value of a model.
+ * A value of 0 removes the reference value.
+ *
+ * Arguments required:
+ * [0] Brand                       (string)
+ * [1] Model                       (string)
+ * [2] Value                       (int)
+ *
+ * On success,
+ * returns the reference value.
+ */
+func (t *CarChaincode) setReferenceValue(stub shim.ChaincodeStubInterface, role string, args []string) pb.Response {
+	brand := args[0]
+	model := args[1]
+	value, err := strconv.Atoi(args[2])
+	if err != nil || value < 0 {
+		return errorResponse(ErrInvalidArgument, "'setReferenceValue' expects a value of 0 or more")
+	}
+
+	if brand == "" || model == "" {
+		return errorResponse(ErrInvalidArgument, "'setReferenceValue' expects a non-empty brand and model")
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = checkAdmin(stub, config, role)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	key, err := getReferenceValueKey(stub, brand, model)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	reference := ReferenceValue{Brand: brand, Model: model, Value: value}
+	if value == 0 {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error removing reference value")
+		}
+
+		fmt.Printf("Reference value of %s %s removed\n", brand, model)
+		referenceAsBytes, _ := ledgerjson.Marshal(reference)
+		return shim.Success(referenceAsBytes)
+	}
+
+	reference.UpdatedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	referenceAsBytes, _ := ledgerjson.Marshal(reference)
+	err = stub.PutState(key, referenceAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing reference value")
+	}
+
+	fmt.Printf("Reference value of %s %s set to %d\n", brand, model, value)
+	return shim.Success(referenceAsBytes)
+}
+
+/*
+ * Reads the reference value of a model.
+ *
+ * Arguments required:
+ * [0] Brand                       (string)
+ * [1] Model                       (string)
+ *
+ * On success,
+ * returns the reference value.
+ */
+func (t *CarChaincode) readReferenceValue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	reference, err := getReferenceValue(stub, args[0], args[1])
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if reference == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no reference value for %s %s", args[0], args[1]))
+	}
+
+	referenceAsBytes, _ := ledgerjson.Marshal(reference)
+	return shim.Success(referenceAsBytes)
+}
+
+/*
+ * Returns all sales waiting for review, in VIN order.
+ *
+ * On success,
+ * returns the sale reviews.
+ */
+func (t *CarChaincode) getFlaggedSales(stub shim.ChaincodeStubInterface) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(saleReviewObjectType, []string{})
+	if err != nil {
+		return errorResponse(ErrLedger, "Error reading sale reviews")
+	}
+	defer iterator.Close()
+
+	reviews := []SaleReview{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errorResponse(ErrLedger, "Error reading sale reviews")
+		}
+
+		review := SaleReview{}
+		err = ledgerjson.Unmarshal(kv.Value, &review)
+		if err != nil {
+			return errorResponse(ErrLedger, "Error parsing sale review")
+		}
+
+		if review.Status == saleReviewPending {
+			reviews = append(reviews, review)
+		}
+	}
+
+	reviewsAsBytes, _ := ledgerjson.Marshal(reviews)
+	return shim.Success(reviewsAsBytes)
+}
+
+/*
+ * Approves a flagged sale and completes it. The buyer
+ * pays the declared price to the seller and the transfer
+ * tax on the assessed value to the treasury.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ *
+ * Arguments optional:
+ * [1] Assessed value              (int, defaults to the reference value)
+ *
+ * On success,
+ * returns the car.
+ */
+func (t *CarChaincode) approveSale(stub shim.ChaincodeStubInterface, reviewer string, args []string) pb.Response {
+	vin := args[0]
+
+	review, err := getPendingSaleReview(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	assessed := review.ReferenceValue
+	if len(args) > 1 && args[1] != "" {
+		assessed, err = strconv.Atoi(args[1])
+		if err != nil || assessed < 0 {
+			return errorResponse(ErrInvalidArgument, "'approveSale' expects an assessed value of 0 or more")
+		}
+	}
+
+	config, err := t.getConfig(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	response := t.completeSale(stub, config, review.Seller, review.Buyer, vin, review.Price, assessed)
+	if response.Status != shim.OK {
+		return response
+	}
+
+	review.ReviewedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	review.Status = saleReviewApproved
+	review.Reviewer = reviewer
+	review.AssessedValue = assessed
+	err = saveSaleReview(stub, review)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Sale of car '%s' approved by '%s', assessed at %d\n", vin, reviewer, assessed)
+	return response
+}
+
+/*
+ * Rejects a flagged sale. The car stays with the
+ * seller and no credits are moved.
+ *
+ * Arguments required:
+ * [0] VIN of the car              (string)
+ * [1] Reason                      (string)
+ *
+ * On success,
+ * returns the sale review.
+ */
+func (t *CarChaincode) rejectSale(stub shim.ChaincodeStubInterface, reviewer string, args []string) pb.Response {
+	vin := args[0]
+	reason := args[1]
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'rejectSale' expects a non-empty reason")
+	}
+
+	review, err := getPendingSaleReview(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	review.ReviewedTs, err = txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	review.Status = saleReviewRejected
+	review.Reviewer = reviewer
+	review.Reason = reason
+	err = saveSaleReview(stub, review)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("Sale of car '%s' rejected by '%s'\n", vin, reviewer)
+
+	reviewAsBytes, _ := ledgerjson.Marshal(review)
+	return shim.Success(reviewAsBytes)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestUndervaluedSaleIsReviewed(t *testing.T) {
+	seller := "amag"
+	buyer := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("setFeeSchedule", "admin", "admin", transferTaxName, "0", "10"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("setReferenceValue", seller, "garage", "VW", "Polo", "80"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("setReferenceValue", "admin", "admin", "VW", "Polo", "80"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", seller, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", seller, "garage",
+		`{ "vin": "`+vin+`", "certificate": { "brand": "VW", "model": "Polo" } }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", buyer, "user"))
+
+	// 20 is less than half of the reference value
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "20", vin, buyer))
+	review := SaleReview{}
+	json.Unmarshal(response.Payload, &review)
+	if review.Status != saleReviewPending || review.ReferenceValue != 80 {
+		t.Fatalf("Expected the sale to be flagged, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", seller, "garage", "60", vin, buyer))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", seller, "garage", vin))
+	if response.Status != shim.OK {
+		t.Error("The car should stay with the seller until the sale is approved")
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getFlaggedSales", "inspector", "dot"))
+	reviews := []SaleReview{}
+	json.Unmarshal(response.Payload, &reviews)
+	if len(reviews) != 1 || reviews[0].Vin != vin {
+		t.Fatalf("Expected one flagged sale, got %v", reviews)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveSale", seller, "garage", vin))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	// the tax is charged on the reference value
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveSale", "inspector", "dot", vin))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readUser", buyer, "user"))
+	user := User{}
+	json.Unmarshal(response.Payload, &user)
+	if user.Balance != 100-20-8 {
+		t.Errorf("Buyer should have paid the tax on the reference value, balance is %d", user.Balance)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("approveSale", "inspector", "dot", vin))
+	expectErrorCode(t, response, ErrNotFound)
+
+	// a rejected sale moves neither car nor credits
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", buyer, "user", "10", vin, seller))
+	json.Unmarshal(response.Payload, &review)
+	if review.Status != saleReviewPending {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("rejectSale", "inspector", "dot", vin, "price far below market value"))
+	json.Unmarshal(response.Payload, &review)
+	if review.Status != saleReviewRejected || review.Reviewer != "inspector" {
+		t.Fatalf("Expected a rejected sale, got %s", response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", buyer, "user", vin))
+	if response.Status != shim.OK {
+		t.Error("The car should stay with the owner after a rejected sale")
+	}
+
+	// fair prices are not reviewed
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("sell", buyer, "user", "40", vin, seller))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Username != seller {
+		t.Errorf("Expected the sale to complete, got %s", response.Message)
+	}
+}
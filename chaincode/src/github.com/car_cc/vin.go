@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// VIN length according to ISO 3779
+const vinLength int = 17
+
+// position of the check digit in the VIN
+const vinCheckDigitPos int = 8
+
+// weight of every VIN position for the check digit
+var vinWeights = [vinLength]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+/*
+ * Result of a VIN check
+ */
+type VinCheck struct {
+	Vin     string `json:"vin"`
+	Valid   bool   `json:"valid"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/*
+ * Returns the check digit value of a VIN character,
+ * or -1 for characters not allowed in a VIN.
+ *
+ * The letters I, O and Q look like digits and are never used.
+ */
+func vinValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'H':
+		return int(c-'A') + 1
+	case c >= 'J' && c <= 'N':
+		return int(c-'J') + 1
+	case c == 'P':
+		return 7
+	case c == 'R':
+		return 9
+	case c >= 'S' && c <= 'Z':
+		return int(c-'S') + 2
+	}
+
+	return -1
+}
+
+/*
+ * Validates a VIN according to ISO 3779.
+ *
+ * A valid VIN has 17 characters, uses only digits and
+ * capital letters except I, O and Q and carries a valid
+ * check digit at position 9.
+ *
+ * Returns 'nil' for a valid VIN.
+ */
+func ValidateVin(vin string) *ChaincodeError {
+	if len(vin) != vinLength {
+		return newErrorWithDetails(ErrVinLength, fmt.Sprintf("VIN must have %d characters, '%s' has %d", vinLength, vin, len(vin)), map[string]string{"vin": vin})
+	}
+
+	sum := 0
+	for i := 0; i < vinLength; i++ {
+		value := vinValue(vin[i])
+		if value < 0 {
+			return newErrorWithDetails(ErrVinCharacter, fmt.Sprintf("VIN '%s' contains invalid character '%c' at position %d", vin, vin[i], i+1), map[string]string{"vin": vin})
+		}
+		sum += value * vinWeights[i]
+	}
+
+	checkDigit := byte('0' + sum%11)
+	if sum%11 == 10 {
+		checkDigit = 'X'
+	}
+
+	if vin[vinCheckDigitPos] != checkDigit {
+		return newErrorWithDetails(ErrVinCheckDigit, fmt.Sprintf("VIN '%s' has check digit '%c', expected '%c'", vin, vin[vinCheckDigitPos], checkDigit), map[string]string{"vin": vin})
+	}
+
+	return nil
+}
+
+/*
+ * Checks a VIN without touching the ledger,
+ * so clients can validate their input up front.
+ *
+ * Returns the VIN check.
+ */
+func (t *CarChaincode) validateVin(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	check := VinCheck{Vin: vin, Valid: true}
+
+	vinErr := ValidateVin(vin)
+	if vinErr != nil {
+		check.Valid = false
+		check.Code = vinErr.Code
+		check.Message = vinErr.Message
+	}
+
+	checkAsBytes, _ := ledgerjson.Marshal(check)
+	return shim.Success(checkAsBytes)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestValidateVin(t *testing.T) {
+	vins := map[string]string{
+		"WVWZZZ6R6HY260780":     "",
+		"1M8GDM9AXKP042788":     "",
+		"WVW ZZZ 6RZ HY26 0780": ErrVinLength,
+		"WVWZZZ6R6HY26078":      ErrVinLength,
+		"WVWZZZ6R6HY26O780":     ErrVinCharacter,
+		"wvwzzz6r6hy260780":     ErrVinCharacter,
+		"WVWZZZ6R7HY260780":     ErrVinCheckDigit,
+	}
+
+	for vin, code := range vins {
+		vinErr := ValidateVin(vin)
+		if code == "" && vinErr != nil {
+			t.Errorf("VIN '%s' should be valid: %s", vin, vinErr.Error())
+		} else if code != "" && (vinErr == nil || vinErr.Code != code) {
+			t.Errorf("VIN '%s' should fail with '%s'", vin, code)
+		}
+	}
+}
+
+func TestCreateCarWithInvalidVin(t *testing.T) {
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "amag", "garage"))
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("create", "amag", "garage", `{ "vin": "WVWZZZ6R7HY260780" }`))
+	if response.Status == shim.OK {
+		t.Error("Cars with an invalid VIN should not be created")
+	}
+
+	carIndex, err := (&CarChaincode{}).getCarIndex(stub)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(carIndex) != 0 {
+		t.Error("Invalid VIN should not end up in the car index")
+	}
+
+	// clients can check the VIN up front
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("validateVin", "amag", "garage", "WVWZZZ6R7HY260780"))
+	check := VinCheck{}
+	err = json.Unmarshal(response.Payload, &check)
+	if err != nil {
+		t.Fatal(response.Message)
+	}
+
+	if check.Valid || check.Code != ErrVinCheckDigit {
+		t.Error("VIN check should report a wrong check digit")
+	}
+}
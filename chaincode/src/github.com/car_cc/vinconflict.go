@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Duplicate VIN conflicts.
+ *
+ * When a second physical car turns up with the VIN of a
+ * registered car, the DOT opens a conflict with the data
+ * of the second car and its claimed owner. The conflict
+ * is kept under 'vinconflict~<vin>' and freezes the
+ * registered car, which cannot be confirmed, transferred,
+ * listed, rented or sold in installments until the DOT
+ * resolves the conflict. Investigation notes are appended
+ * to the conflict with their author and transaction.
+ *
+ * Resolving the conflict moves the fraudulent record to
+ * 'quarantine~<vin>~<txid>'. If the registered car was the
+ * fraudulent one, the second car takes its VIN and goes to
+ * the claimed owner. Every step is a DOT call, so it is in
+ * the audit log as well.
+ */
+
+// object types of conflict and quarantine keys
+const vinConflictObjectType string = "vinconflict"
+const quarantineObjectType string = "quarantine"
+
+// status of a conflict
+const vinConflictOpen string = "open"
+const vinConflictResolved string = "resolved"
+
+// record found to be fraudulent
+const fraudulentRegistered string = "registered"
+const fraudulentClaim string = "claim"
+
+/*
+ * Checks if 'car' is frozen by an open VIN
+ * conflict or a seizure, see 'seizeCar'
+ */
+func IsFrozen(car *Car) bool {
+	return car.VinConflict || IsSeized(car)
+}
+
+/*
+ * Returns the ledger key of the conflict of a VIN
+ */
+func getVinConflictKey(stub shim.ChaincodeStubInterface, vin string) (string, error) {
+	key, err := stub.CreateCompositeKey(vinConflictObjectType, []string{vin})
+	if err != nil {
+		return "", newError(ErrInternal, "Error creating VIN conflict key")
+	}
+
+	return key, nil
+}
+
+/*
+ * Reads the conflict of a VIN.
+ *
+ * Returns 'nil' if there never was one.
+ */
+func getVinConflict(stub shim.ChaincodeStubInterface, vin string) (*VinConflict, error) {
+	key, err := getVinConflictKey(stub, vin)
+	if err != nil {
+		return nil, err
+	}
+
+	conflictAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error reading VIN conflict")
+	} else if conflictAsBytes == nil {
+		return nil, nil
+	}
+
+	conflict := VinConflict{}
+	err = ledgerjson.Unmarshal(conflictAsBytes, &conflict)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error parsing VIN conflict")
+	}
+
+	return &conflict, nil
+}
+
+/*
+ * Reads the open conflict of a VIN
+ */
+func getOpenVinConflict(stub shim.ChaincodeStubInterface, vin string) (*VinConflict, error) {
+	conflict, err := getVinConflict(stub, vin)
+	if err != nil {
+		return nil, err
+	} else if conflict == nil || conflict.Status != vinConflictOpen {
+		return nil, newError(ErrNotFound, fmt.Sprintf("There is no open VIN conflict for '%s'", vin))
+	}
+
+	return conflict, nil
+}
+
+/*
+ * Writes a conflict to the ledger
+ */
+func saveVinConflict(stub shim.ChaincodeStubInterface, conflict *VinConflict) ([]byte, error) {
+	key, err := getVinConflictKey(stub, conflict.Vin)
+	if err != nil {
+		return nil, err
+	}
+
+	conflictAsBytes, _ := ledgerjson.Marshal(conflict)
+	err = stub.PutState(key, conflictAsBytes)
+	if err != nil {
+		return nil, newError(ErrLedger, "Error writing VIN conflict")
+	}
+
+	return conflictAsBytes, nil
+}
+
+/*
+ * Appends an investigation note of 'reviewer' to a conflict
+ */
+func appendVinConflictNote(stub shim.ChaincodeStubInterface, conflict *VinConflict, reviewer string, text string) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	conflict.Notes = append(conflict.Notes, VinConflictNote{Author: reviewer, Text: text, Ts: now, TxId: stub.GetTxID()})
+	return nil
+}
+
+/*
+ * Moves a fraudulent record of a VIN to the quarantine
+ */
+func quarantineCar(stub shim.ChaincodeStubInterface, car Car, owner string) error {
+	now, err := txUnix(stub)
+	if err != nil {
+		return err
+	}
+
+	quarantined := QuarantinedCar{Car: car, Owner: owner, QuarantinedTs: now, TxId: stub.GetTxID()}
+	key, err := stub.CreateCompositeKey(quarantineObjectType, []string{car.Vin, quarantined.TxId})
+	if err != nil {
+		return newError(ErrInternal, "Error creating quarantine key")
+	}
+
+	quarantinedAsBytes, _ := ledgerjson.Marshal(quarantined)
+	err = stub.PutState(key, quarantinedAsBytes)
+	if err != nil {
+		return newError(ErrLedger, "Error writing quarantined car")
+	}
+
+	return nil
+}
+
+/*
+ * Opens a conflict for the VIN of a registered car,
+ * which a second car with owner 'claimant' claims.
+ * Freezes the registered car.
+ *
+ * On success,
+ * returns the conflict.
+ */
+func (t *CarChaincode) openVinConflict(stub shim.ChaincodeStubInterface, reviewer string, vin string, claimant string, claimAsJson string, note string) pb.Response {
+	claim := Car{}
+	err := ledgerjson.Unmarshal([]byte(claimAsJson), &claim)
+	if err != nil {
+		return errorResponse(ErrInvalidArgument, "'openVinConflict' expects the second car as json")
+	} else if claim.Vin != "" && claim.Vin != vin {
+		return errorResponse(ErrInvalidArgument, fmt.Sprintf("The second car claims vin '%s', not '%s'", claim.Vin, vin))
+	} else if note == "" {
+		return errorResponse(ErrInvalidArgument, "'openVinConflict' expects a non-empty note")
+	}
+
+	_, err = t.getUser(stub, claimant)
+	if err != nil {
+		return errorResponse(ErrUserNotFound, fmt.Sprintf("User '%s' does not exist", claimant))
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	existing, err := getVinConflict(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if existing != nil && existing.Status == vinConflictOpen {
+		return errorResponse(ErrAlreadyExists, fmt.Sprintf("There is an open VIN conflict for '%s' already", vin))
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	claim.Vin = vin
+	claim.CreatedTs = now
+	claim.Archived = nil
+	claim.VinConflict = true
+	conflict := VinConflict{
+		Vin:      vin,
+		Status:   vinConflictOpen,
+		Owner:    owner,
+		Claim:    claim,
+		Claimant: claimant,
+		OpenedBy: reviewer,
+		OpenedTs: now,
+	}
+
+	err = appendVinConflictNote(stub, &conflict, reviewer, note)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car.VinConflict = true
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	conflictAsBytes, err := saveVinConflict(stub, &conflict)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("VIN conflict for '%s' opened by '%s'\n", vin, reviewer)
+	return shim.Success(conflictAsBytes)
+}
+
+/*
+ * Attaches an investigation note to an open conflict.
+ *
+ * On success,
+ * returns the conflict.
+ */
+func (t *CarChaincode) addVinConflictNote(stub shim.ChaincodeStubInterface, reviewer string, vin string, note string) pb.Response {
+	if note == "" {
+		return errorResponse(ErrInvalidArgument, "'addVinConflictNote' expects a non-empty note")
+	}
+
+	conflict, err := getOpenVinConflict(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	err = appendVinConflictNote(stub, conflict, reviewer, note)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	conflictAsBytes, err := saveVinConflict(stub, conflict)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	return shim.Success(conflictAsBytes)
+}
+
+/*
+ * Resolves an open conflict, moving the 'fraudulent'
+ * record, 'registered' or 'claim', to the quarantine.
+ * The remaining car is unfrozen.
+ *
+ * On success,
+ * returns the conflict.
+ */
+func (t *CarChaincode) resolveVinConflict(stub shim.ChaincodeStubInterface, reviewer string, vin string, fraudulent string, note string) pb.Response {
+	if fraudulent != fraudulentRegistered && fraudulent != fraudulentClaim {
+		return errorResponse(ErrInvalidArgument, "'resolveVinConflict' expects the fraudulent record, 'registered' or 'claim'")
+	} else if note == "" {
+		return errorResponse(ErrInvalidArgument, "'resolveVinConflict' expects a non-empty note")
+	}
+
+	conflict, err := getOpenVinConflict(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, owner, err := t.getHandoffCar(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	genuine := car
+	if fraudulent == fraudulentClaim {
+		err = quarantineCar(stub, conflict.Claim, conflict.Claimant)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+	} else {
+		err = quarantineCar(stub, car, owner)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		// the second car takes the VIN
+		err = t.rekeyVinConflictClaim(stub, conflict, owner)
+		if err != nil {
+			return errorResponseFrom(err)
+		}
+
+		genuine = conflict.Claim
+		genuine.Certificate.Username = conflict.Claimant
+	}
+
+	err = appendVinConflictNote(stub, conflict, reviewer, note)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	conflict.Status = vinConflictResolved
+	conflict.Fraudulent = fraudulent
+	conflict.ResolvedBy = reviewer
+	conflict.ResolvedTs = now
+
+	genuine.VinConflict = false
+	carAsBytes, _ := ledgerjson.Marshal(genuine)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	conflictAsBytes, err := saveVinConflict(stub, conflict)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	fmt.Printf("VIN conflict for '%s' resolved by '%s', the %s record is quarantined\n", vin, reviewer, fraudulent)
+	return shim.Success(conflictAsBytes)
+}
+
+/*
+ * Hands the VIN of a quarantined registered car
+ * from 'owner' to the claimant of the conflict
+ */
+func (t *CarChaincode) rekeyVinConflictClaim(stub shim.ChaincodeStubInterface, conflict *VinConflict, owner string) error {
+	err := removeOwnership(stub, owner, conflict.Vin)
+	if err != nil {
+		return err
+	}
+
+	err = t.removeFromInventory(stub, owner, conflict.Vin)
+	if err != nil {
+		return err
+	}
+
+	err = t.clearReadGrants(stub, conflict.Vin)
+	if err != nil {
+		return err
+	}
+
+	pseudonym, err := registerPseudonym(stub, conflict.Claimant)
+	if err != nil {
+		return err
+	}
+
+	err = indexCar(stub, conflict.Vin, pseudonym)
+	if err != nil {
+		return err
+	}
+
+	return addOwnership(stub, conflict.Claimant, conflict.Vin)
+}
+
+/*
+ * Returns the conflict of a VIN with all notes.
+ *
+ * On success,
+ * returns the conflict.
+ */
+func (t *CarChaincode) readVinConflict(stub shim.ChaincodeStubInterface, vin string) pb.Response {
+	conflict, err := getVinConflict(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if conflict == nil {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There is no VIN conflict for '%s'", vin))
+	}
+
+	conflictAsBytes, _ := ledgerjson.Marshal(conflict)
+	return shim.Success(conflictAsBytes)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestVinConflict(t *testing.T) {
+	garage := "amag"
+	claimant := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	second := `{ "certificate": { "brand": "VW", "model": "Polo", "color": "red" } }`
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", claimant, "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("openVinConflict", garage, "garage", vin, claimant, second, "seen at inspection"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("openVinConflict", "inspector", "dot", vin, claimant, second, "seen at inspection"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("openVinConflict", "inspector", "dot", vin, claimant, second, "seen again"))
+	expectErrorCode(t, response, ErrAlreadyExists)
+
+	// the registered car is frozen
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", garage, "garage", vin, claimant))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("listCar", garage, "garage", vin, "60"))
+	expectErrorCode(t, response, ErrInvalidState)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addVinConflictNote", "inspector", "dot", vin, "engine number of the registered car was ground off"))
+
+	// the registered car is the forgery, the second car takes the VIN
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveVinConflict", "inspector", "dot", vin, fraudulentRegistered, "factory records match the second car"))
+	conflict := VinConflict{}
+	json.Unmarshal(response.Payload, &conflict)
+	if conflict.Status != vinConflictResolved || len(conflict.Notes) != 3 || conflict.Owner != garage {
+		t.Fatalf("Unexpected resolved conflict: %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", claimant, "user", vin))
+	car := Car{}
+	json.Unmarshal(response.Payload, &car)
+	if car.Certificate.Color != "red" || IsFrozen(&car) {
+		t.Errorf("Expected the unfrozen second car to go to the claimant, got %s", response.Payload)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("readCar", garage, "garage", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	key, _ := stub.CreateCompositeKey(quarantineObjectType, []string{vin, uuid})
+	quarantined := QuarantinedCar{}
+	json.Unmarshal(stub.State[key], &quarantined)
+	if quarantined.Owner != garage || quarantined.Car.Vin != vin {
+		t.Errorf("Expected the registered car in quarantine, got %s", stub.State[key])
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveVinConflict", "inspector", "dot", vin, fraudulentClaim, "again"))
+	expectErrorCode(t, response, ErrNotFound)
+}
+
+func TestVinConflictFraudulentClaim(t *testing.T) {
+	garage := "amag"
+	vin := "WVWZZZ6R6HY260780"
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", garage, "garage"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", "mallory", "user"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("create", garage, "garage", `{ "vin": "`+vin+`" }`))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("openVinConflict", "inspector", "dot", vin, "mallory", `{}`, "cloned plates"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("resolveVinConflict", "inspector", "dot", vin, fraudulentClaim, "clone"))
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// the registered car is free again
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", garage, "garage", vin, "bobby"))
+	if response.Status != shim.OK {
+		t.Error(response.Message)
+	}
+}
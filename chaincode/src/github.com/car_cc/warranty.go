@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/car_cc/ledgerjson"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Warranties.
+ *
+ * Manufacturers and dealers grant warranties on a car.
+ * Warranties are part of the car, so they move with it
+ * on every transfer. Only the issuer can void a warranty.
+ */
+
+/*
+ * Returns the mileage a warranty km limit is checked against
+ */
+func carMileage(car *Car) int {
+	if car.Odometer.Km > car.UsageData.MileAge {
+		return car.Odometer.Km
+	}
+
+	return car.UsageData.MileAge
+}
+
+/*
+ * Checks if a warranty covers the car at 'now'
+ */
+func isWarrantyActive(warranty *Warranty, car *Car, now int64) bool {
+	if warranty.Voided || now < warranty.StartTs || now >= warranty.EndTs {
+		return false
+	}
+
+	return warranty.KmLimit == 0 || carMileage(car) <= warranty.KmLimit
+}
+
+/*
+ * Grants a warranty on a car.
+ *
+ * Manufacturers can grant warranties on every car,
+ * dealers only on cars they own, i.e. when they
+ * create or sell the car.
+ *
+ * On success,
+ * returns the warranty.
+ */
+func (t *CarChaincode) addWarranty(stub shim.ChaincodeStubInterface, username string, role string, vin string, warranty Warranty) pb.Response {
+	if warranty.Coverage == "" {
+		return errorResponse(ErrInvalidArgument, "'addWarranty' expects a non-empty coverage")
+	} else if warranty.EndTs <= warranty.StartTs {
+		return errorResponse(ErrInvalidArgument, "'addWarranty' expects the end after the start")
+	} else if warranty.KmLimit < 0 {
+		return errorResponse(ErrInvalidArgument, "'addWarranty' expects a km limit of 0 or more")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	} else if role == "garage" && owner != username {
+		return errorResponse(ErrNotOwner, "Forbidden: dealers can only grant warranties on their own cars")
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	warranty.Id = len(car.Warranties) + 1
+	warranty.Issuer = username
+	warranty.IssuedTs = now
+	car.Warranties = append(car.Warranties, warranty)
+
+	err = updateExpiry(stub, expiryWarranty, vin, 0, warranty.EndTs)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	warrantyAsBytes, _ := ledgerjson.Marshal(warranty)
+	return shim.Success(warrantyAsBytes)
+}
+
+/*
+ * Voids a warranty. Only its issuer can void it.
+ *
+ * On success,
+ * returns the voided warranty.
+ */
+func (t *CarChaincode) voidWarranty(stub shim.ChaincodeStubInterface, username string, vin string, id int, reason string) pb.Response {
+	if reason == "" {
+		return errorResponse(ErrInvalidArgument, "'voidWarranty' expects a non-empty reason")
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	if id < 1 || id > len(car.Warranties) {
+		return errorResponse(ErrNotFound, fmt.Sprintf("There exists no warranty %d for car with VIN '%s'", id, vin))
+	}
+
+	warranty := &car.Warranties[id-1]
+	if warranty.Issuer != username {
+		return errorResponse(ErrForbidden, "Forbidden: only the issuer can void a warranty")
+	} else if warranty.Voided {
+		return errorResponse(ErrInvalidState, "Warranty is already voided")
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	warranty.Voided = true
+	warranty.VoidReason = reason
+	warranty.VoidedTs = now
+
+	carAsBytes, _ := ledgerjson.Marshal(car)
+	err = stub.PutState(vin, carAsBytes)
+	if err != nil {
+		return errorResponse(ErrLedger, "Error writing car")
+	}
+
+	warrantyAsBytes, _ := ledgerjson.Marshal(warranty)
+	return shim.Success(warrantyAsBytes)
+}
+
+/*
+ * Lists the warranties of a car and whether they
+ * cover the car right now.
+ *
+ * Owners and everybody who can read the car, and
+ * garages, who need to know before a repair.
+ *
+ * On success,
+ * returns the warranties.
+ */
+func (t *CarChaincode) getWarranties(stub shim.ChaincodeStubInterface, username string, role string, vin string) pb.Response {
+	if role != "garage" {
+		allowed, err := t.canRead(stub, username, vin)
+		if err != nil {
+			return errorResponseFrom(err)
+		} else if !allowed {
+			return errorResponse(ErrNotOwner, "Forbidden: this is not your car")
+		}
+	}
+
+	owner, err := t.getOwner(stub, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	car, err := t.getCar(stub, owner, vin)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	now, err := txUnix(stub)
+	if err != nil {
+		return errorResponseFrom(err)
+	}
+
+	statuses := []WarrantyStatus{}
+	for i := range car.Warranties {
+		statuses = append(statuses, WarrantyStatus{
+			Warranty: car.Warranties[i],
+			Active:   isWarrantyActive(&car.Warranties[i], &car, now)})
+	}
+
+	statusesAsBytes, _ := ledgerjson.Marshal(statuses)
+	return shim.Success(statusesAsBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestWarranty(t *testing.T) {
+	owner := "amag"
+	receiver := "bobby"
+	vin := "WVWZZZ6R6HY260780"
+	start := strconv.FormatInt(time.Now().Add(-24*time.Hour).Unix(), 10)
+	end := strconv.FormatInt(time.Now().Add(2*365*24*time.Hour).Unix(), 10)
+
+	stub := shim.NewMockStub("car", &CarChaincode{})
+	ccSetup(t, stub)
+	insureCar(t, stub, owner, vin, "axa")
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("createUser", receiver, "user"))
+
+	response := stub.MockInvoke(uuid, util.ToChaincodeArgs("addWarranty", owner, "user", vin, "full", start, end, "100000"))
+	expectErrorCode(t, response, ErrForbiddenRole)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addWarranty", "otherdealer", "garage", vin, "full", start, end, "100000"))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("addWarranty", "vw", "manufacturer", vin, "powertrain", end, start, "0"))
+	expectErrorCode(t, response, ErrInvalidArgument)
+
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addWarranty", "vw", "manufacturer", vin, "powertrain", start, end, "0"))
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("addWarranty", owner, "garage", vin, "full", start, end, "100000"))
+
+	// warranties move with the car
+	stub.MockInvoke(uuid, util.ToChaincodeArgs("transfer", owner, "user", vin, receiver))
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("voidWarranty", receiver, "user", vin, "2", "Tuning"))
+	expectErrorCode(t, response, ErrForbidden)
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("voidWarranty", owner, "garage", vin, "2", "Tuning"))
+	if response.Status != shim.OK {
+		t.Fatal("Issuer should be able to void the warranty: " + response.Message)
+	}
+
+	response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getWarranties", "stranger", "user", vin))
+	expectErrorCode(t, response, ErrNotOwner)
+
+	for _, reader := range []string{receiver, "repairshop"} {
+		role := "user"
+		if reader != receiver {
+			role = "garage"
+		}
+
+		response = stub.MockInvoke(uuid, util.ToChaincodeArgs("getWarranties", reader, role, vin))
+		statuses := []WarrantyStatus{}
+		err := json.Unmarshal(response.Payload, &statuses)
+		if err != nil {
+			t.Fatal(response.Message)
+		}
+
+		if len(statuses) != 2 || !statuses[0].Active || statuses[0].Warranty.Issuer != "vw" {
+			t.Fatalf("Manufacturer warranty should still cover the car: %v", statuses)
+		}
+		if statuses[1].Active || statuses[1].Warranty.VoidReason != "Tuning" {
+			t.Errorf("Voided warranty should not cover the car: %v", statuses[1])
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+/*
+ * Typed client of the car chaincode.
+ *
+ * Wraps a Fabric Gateway contract, so applications call
+ * 'CreateCar' instead of building JSON argument arrays.
+ * Transactions which fail with an MVCC read conflict are
+ * submitted again, and every call without a deadline
+ * gets the default timeout.
+ */
+type Client struct {
+	contract Contract
+	username string
+	role     string
+
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+
+	compress bool
+}
+
+/*
+ * Subset of '*client.Contract' the client uses
+ */
+type Contract interface {
+	SubmitWithContext(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error)
+	EvaluateWithContext(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error)
+}
+
+type Option func(*Client)
+
+/*
+ * Sets the timeout of calls without a deadline
+ */
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+/*
+ * Sets how often a transaction with an MVCC read conflict
+ * is submitted again, and the wait before the first retry.
+ * The wait doubles with every retry.
+ */
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+/*
+ * Asks for gzip compressed query results, for large
+ * inventories and histories near the gRPC message limit
+ */
+func WithCompression() Option {
+	return func(c *Client) {
+		c.compress = true
+	}
+}
+
+/*
+ * Creates a client invoking the chaincode as 'username'
+ * with role 'role'
+ */
+func New(contract Contract, username string, role string, options ...Option) *Client {
+	c := &Client{
+		contract: contract,
+		username: username,
+		role:     role,
+		timeout:  30 * time.Second,
+		retries:  3,
+		backoff:  100 * time.Millisecond}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+/*
+ * Checks if a transaction failed because another
+ * transaction changed the keys it read
+ */
+func IsConflict(err error) bool {
+	commitErr := &client.CommitError{}
+	if !errors.As(err, &commitErr) {
+		return false
+	}
+
+	return commitErr.Code == peer.TxValidationCode_MVCC_READ_CONFLICT ||
+		commitErr.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+}
+
+func (c *Client) arguments(args []string) client.ProposalOption {
+	return client.WithArguments(append([]string{c.username, c.role}, args...)...)
+}
+
+/*
+ * Passes an idempotency key to the chaincode, so a
+ * transaction retried after a timeout is only done once
+ */
+func idempotencyKey(key string) []client.ProposalOption {
+	if key == "" {
+		return nil
+	}
+
+	return []client.ProposalOption{client.WithTransient(map[string][]byte{"idempotencyKey": []byte(key)})}
+}
+
+/*
+ * Submits a transaction, again after read conflicts
+ */
+func (c *Client) submit(ctx context.Context, function string, args []string, options ...client.ProposalOption) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		result, err := c.contract.SubmitWithContext(ctx, function, append([]client.ProposalOption{c.arguments(args)}, options...)...)
+		if err == nil {
+			return result, nil
+		} else if !IsConflict(err) || attempt >= c.retries {
+			return nil, chaincodeError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+/*
+ * Evaluates a query on one peer
+ */
+func (c *Client) evaluate(ctx context.Context, function string, args ...string) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	options := []client.ProposalOption{c.arguments(args)}
+	if c.compress {
+		options = append(options, client.WithTransient(map[string][]byte{"compress": []byte("gzip")}))
+	}
+
+	result, err := c.contract.EvaluateWithContext(ctx, function, options...)
+	if err != nil {
+		return nil, chaincodeError(err)
+	} else if c.compress {
+		return decompress(result)
+	}
+
+	return result, nil
+}
+
+/*
+ * Runs a write function on one peer with all its
+ * checks, but without writing, see 'simulate.go'
+ * of the chaincode
+ */
+func (c *Client) simulate(ctx context.Context, function string, args ...string) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	result, err := c.contract.EvaluateWithContext(ctx, function, c.arguments(args), client.WithTransient(map[string][]byte{"simulate": []byte("true")}))
+	if err != nil {
+		return nil, chaincodeError(err)
+	}
+
+	return result, nil
+}
+
+/*
+ * Unpacks a compressed query result
+ */
+func decompress(result []byte) ([]byte, error) {
+	compressed := CompressedPayload{}
+	err := json.Unmarshal(result, &compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed.Payload))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+/*
+ * Returns the arguments of 'create'
+ */
+func createCarArgs(request CreateCarRequest) ([]string, error) {
+	carAsBytes := []byte(request.Car.Raw)
+	if len(carAsBytes) == 0 {
+		var err error
+		carAsBytes, err = json.Marshal(request.Car)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args := []string{string(carAsBytes)}
+	if request.Proposal != nil {
+		proposalAsBytes, err := json.Marshal(request.Proposal)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(proposalAsBytes))
+	}
+
+	return args, nil
+}
+
+/*
+ * Creates a new, unregistered car.
+ * Needs the 'garage' role.
+ */
+func (c *Client) CreateCar(ctx context.Context, request CreateCarRequest) (*Car, error) {
+	args, err := createCarArgs(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.submit(ctx, "create", args, idempotencyKey(request.IdempotencyKey)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Checks if a car could be created, without creating it.
+ * Returns the car 'CreateCar' would return.
+ */
+func (c *Client) SimulateCreateCar(ctx context.Context, request CreateCarRequest) (*Car, error) {
+	args, err := createCarArgs(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.simulate(ctx, "create", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Reads a car the client owns or may read.
+ * With 'fields' ('certificate.numberplate'), only
+ * those fields are returned and set.
+ */
+func (c *Client) ReadCar(ctx context.Context, vin string, fields ...string) (*Car, error) {
+	args := []string{vin}
+	if len(fields) > 0 {
+		fieldsAsBytes, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(fieldsAsBytes))
+	}
+
+	result, err := c.evaluate(ctx, "readCar", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Transfers a car to a new owner.
+ * Needs the 'user' or 'garage' role.
+ */
+func (c *Client) TransferCar(ctx context.Context, request TransferCarRequest) (*Car, error) {
+	result, err := c.submit(ctx, "transfer", []string{request.Vin, request.Receiver}, idempotencyKey(request.IdempotencyKey)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Checks if a car could be transferred, without
+ * transferring it
+ */
+func (c *Client) SimulateTransferCar(ctx context.Context, request TransferCarRequest) (*Car, error) {
+	result, err := c.simulate(ctx, "transfer", request.Vin, request.Receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Reads all committed revisions of a car, oldest first
+ */
+func (c *Client) GetHistory(ctx context.Context, vin string) ([]CarRevision, error) {
+	result, err := c.evaluate(ctx, "getCarHistory", vin)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []CarRevision{}
+	err = json.Unmarshal(result, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+/*
+ * Confirms an insured car and assigns a numberplate.
+ * Needs the 'dot' role.
+ */
+func (c *Client) ConfirmCar(ctx context.Context, vin string, numberplate string) (*Car, error) {
+	result, err := c.submit(ctx, "confirm", []string{vin, numberplate})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Checks if a car could be confirmed with the
+ * numberplate, without confirming it
+ */
+func (c *Client) SimulateConfirmCar(ctx context.Context, vin string, numberplate string) (*Car, error) {
+	result, err := c.simulate(ctx, "confirm", vin, numberplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCar(result)
+}
+
+/*
+ * Reads the insurance quotes for the open quote request
+ * of a car by insurer. Needs the 'user' role.
+ */
+func (c *Client) GetQuotes(ctx context.Context, vin string) (map[string]Quote, error) {
+	result, err := c.evaluate(ctx, "getQuotes", vin)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]Quote)
+	err = json.Unmarshal(result, &quotes)
+	if err != nil {
+		return nil, err
+	}
+
+	return quotes, nil
+}
+
+/*
+ * Processes the due expirations of policies, emission tests
+ * and permits, 'batchSize' index entries per transaction (0
+ * for the chaincode default), until none are left. Needs
+ * the 'dot' role, meant to be run by cron.
+ */
+func (c *Client) ProcessExpirations(ctx context.Context, batchSize int) (*ExpirationReport, error) {
+	args := []string{}
+	if batchSize > 0 {
+		args = append(args, strconv.Itoa(batchSize))
+	}
+
+	total := &ExpirationReport{Revoked: []string{}}
+	for {
+		result, err := c.submit(ctx, "processExpirations", args)
+		if err != nil {
+			return total, err
+		}
+
+		report := ExpirationReport{}
+		err = json.Unmarshal(result, &report)
+		if err != nil {
+			return total, err
+		}
+
+		total.Processed += report.Processed
+		total.Revoked = append(total.Revoked, report.Revoked...)
+		if !report.More {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+/*
+ * Contract returning the queued results in order
+ */
+type fakeContract struct {
+	results []error
+	calls   int
+}
+
+func (f *fakeContract) SubmitWithContext(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error) {
+	err := f.results[f.calls]
+	f.calls++
+	if err != nil {
+		return nil, err
+	}
+	return []byte(`{"vin":"WVWZZZ6R6HY260780","certificate":{"username":"bob"}}`), nil
+}
+
+func (f *fakeContract) EvaluateWithContext(ctx context.Context, name string, options ...client.ProposalOption) ([]byte, error) {
+	return f.SubmitWithContext(ctx, name, options...)
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	conflict := &client.CommitError{Code: peer.TxValidationCode_MVCC_READ_CONFLICT}
+	contract := &fakeContract{results: []error{conflict, conflict, nil}}
+	c := New(contract, "amag", "garage", WithRetries(3, time.Millisecond))
+
+	car, err := c.TransferCar(context.Background(), TransferCarRequest{Vin: "WVWZZZ6R6HY260780", Receiver: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contract.calls != 3 || car.Certificate.Username != "bob" {
+		t.Errorf("Expected the transfer after 3 attempts, got %d attempts and %+v", contract.calls, car)
+	}
+
+	// give up after the configured retries
+	contract = &fakeContract{results: []error{conflict, conflict}}
+	c = New(contract, "amag", "garage", WithRetries(1, time.Millisecond))
+	_, err = c.TransferCar(context.Background(), TransferCarRequest{Vin: "WVWZZZ6R6HY260780", Receiver: "bob"})
+	if !IsConflict(err) || contract.calls != 2 {
+		t.Errorf("Expected a conflict after 2 attempts, got %d attempts and %v", contract.calls, err)
+	}
+}
+
+func TestChaincodeErrorCode(t *testing.T) {
+	endorseErr := errors.New(`chaincode response 500, {"code":"NOT_OWNER","message":"Forbidden: this is not your car"}`)
+	contract := &fakeContract{results: []error{endorseErr}}
+	c := New(contract, "bobby", "user")
+
+	_, err := c.ReadCar(context.Background(), "WVWZZZ6R6HY260780")
+	if ErrorCode(err) != "NOT_OWNER" {
+		t.Errorf("Expected error code 'NOT_OWNER', got %v", err)
+	}
+
+	// no retries for errors of the chaincode
+	if contract.calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", contract.calls)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/grpc/status"
+)
+
+/*
+ * Error returned by the chaincode, with a code
+ * of the chaincode error catalog
+ */
+type Error struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+/*
+ * Returns the code of a chaincode error,
+ * or "" for other errors
+ */
+func ErrorCode(err error) string {
+	ccErr, ok := err.(*Error)
+	if !ok {
+		return ""
+	}
+	return ccErr.Code
+}
+
+/*
+ * Extracts the chaincode error envelope from a Fabric
+ * Gateway error. Errors without an envelope, e.g. from
+ * an unreachable peer, are returned as they are.
+ */
+func chaincodeError(err error) error {
+	messages := []string{err.Error()}
+	for _, detail := range status.Convert(err).Details() {
+		if message, ok := detail.(interface{ GetMessage() string }); ok {
+			messages = append(messages, message.GetMessage())
+		}
+	}
+
+	for _, message := range messages {
+		start := strings.Index(message, "{")
+		end := strings.LastIndex(message, "}")
+		if start < 0 || end < start {
+			continue
+		}
+
+		ccErr := &Error{}
+		if json.Unmarshal([]byte(message[start:end+1]), ccErr) == nil && ccErr.Code != "" {
+			return ccErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"encoding/json"
+)
+
+/*
+ * Request and response types of the car chaincode.
+ *
+ * They mirror 'models.go' of the chaincode, so the JSON
+ * field names have to stay in sync with it. Fields the
+ * client does not know are kept in 'Raw'.
+ */
+
+type Certificate struct {
+	Username     string `json:"username"`
+	Insurer      string `json:"insurer"`
+	Numberplate  string `json:"numberplate"`
+	Vin          string `json:"vin"`
+	Color        string `json:"color"`
+	Type         string `json:"type"`
+	Brand        string `json:"brand"`
+	Model        string `json:"model"`
+	Variant      string `json:"variant"`
+	CatalogId    string `json:"catalog_id"`
+	RegisteredTs int64  `json:"registered_ts"`
+	Version      int    `json:"version"`
+	IssuedTs     int64  `json:"issued_ts"`
+}
+
+type UsageData struct {
+	MileAge int    `json:"mile_age"`
+	Repairs string `json:"repairs"`
+}
+
+type Car struct {
+	Vin            string      `json:"vin"`
+	CreatedTs      int64       `json:"created_ts"`
+	Certificate    Certificate `json:"certificate"`
+	UsageData      UsageData   `json:"usage_data"`
+	Classification string      `json:"classification"`
+	Recalls        []string    `json:"recalls"`
+	Stolen         bool        `json:"stolen"`
+
+	Raw json.RawMessage `json:"-"` // car as returned by the chaincode, sent as it is by 'CreateCar'
+}
+
+type RegistrationProposal struct {
+	Car               string `json:"car"`
+	NumberOfDoors     string `json:"number_of_doors"`
+	NumberOfCylinders int    `json:"number_of_cylinders"`
+	NumberOfAxis      int    `json:"number_of_axis"`
+	MaxSpeed          int    `json:"max_speed"`
+
+	Owner      string `json:"owner"`
+	Status     string `json:"status"`
+	CreatedTs  int64  `json:"created_ts"`
+	Reviewer   string `json:"reviewer"`
+	ReviewedTs int64  `json:"reviewed_ts"`
+	Reason     string `json:"reason"`
+	ExpiresTs  int64  `json:"expires_ts"`
+}
+
+type CreateCarRequest struct {
+	Car      Car
+	Proposal *RegistrationProposal // optional registration data for the DOT
+
+	IdempotencyKey string // optional, retries with the same key create the car once
+}
+
+type TransferCarRequest struct {
+	Vin      string
+	Receiver string // username of the new owner
+
+	IdempotencyKey string // optional, retries with the same key transfer the car once
+}
+
+/*
+ * Committed revision of a car, see 'getCarHistory'
+ */
+type CarRevision struct {
+	TxId    string `json:"tx_id"`
+	Ts      int64  `json:"ts"`
+	Car     *Car   `json:"car,omitempty"` // nil if the car was deleted
+	Deleted bool   `json:"deleted"`
+}
+
+/*
+ * Parses a car and keeps the raw JSON
+ */
+func parseCar(carAsBytes []byte) (*Car, error) {
+	car := &Car{}
+	err := json.Unmarshal(carAsBytes, car)
+	if err != nil {
+		return nil, err
+	}
+
+	car.Raw = carAsBytes
+	return car, nil
+}
+
+/*
+ * Insurance quote for a car, see 'submitQuote'
+ */
+type Quote struct {
+	Vin         string `json:"vin"`
+	Insurer     string `json:"insurer"`
+	Coverage    string `json:"coverage"`
+	Price       int    `json:"price"`
+	Conditions  string `json:"conditions"`
+	RequestTs   int64  `json:"request_ts"`
+	SubmittedTs int64  `json:"submitted_ts"`
+}
+
+/*
+ * Compressed query result, see 'WithCompression'
+ */
+type CompressedPayload struct {
+	Encoding       string `json:"encoding"`
+	Size           int    `json:"size"`
+	CompressedSize int    `json:"compressed_size"`
+	Count          int    `json:"count"`
+	Payload        []byte `json:"payload"`
+}
+
+/*
+ * Expirations processed by 'processExpirations'
+ */
+type ExpirationReport struct {
+	Processed int      `json:"processed"` // expiry index entries processed
+	Revoked   []string `json:"revoked"`   // VINs of the cars that lost their numberplate
+	More      bool     `json:"more"`      // more entries are due
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+/*
+ * Identity wallet.
+ *
+ * A wallet is a directory with one '<label>.id' file per
+ * identity, in the file format of the Fabric SDK wallets.
+ * The optional fields 'username' and 'role' set the cc
+ * username and role the identity invokes with. They
+ * default to the label and 'user'.
+ *
+ * Used by the REST gateway and the 'cartrade' CLI.
+ */
+type Wallet struct {
+	dir string
+}
+
+type WalletEntry struct {
+	Type        string `json:"type"` // always 'X.509'
+	MspId       string `json:"mspId"`
+	Credentials struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"privateKey"`
+	} `json:"credentials"`
+
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+/*
+ * Identity loaded from the wallet, with its signer
+ */
+type WalletIdentity struct {
+	Label    string
+	Username string
+	Role     string
+	Id       *identity.X509Identity
+	Sign     identity.Sign
+}
+
+func NewWallet(dir string) *Wallet {
+	return &Wallet{dir: dir}
+}
+
+/*
+ * Loads the identity 'label' from the wallet
+ */
+func (w *Wallet) Get(label string) (*WalletIdentity, error) {
+	if label == "" || filepath.Base(label) != label {
+		return nil, fmt.Errorf("invalid identity label '%s'", label)
+	}
+
+	entryAsBytes, err := os.ReadFile(filepath.Join(w.dir, label+".id"))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := WalletEntry{}
+	err = json.Unmarshal(entryAsBytes, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("malformed wallet entry '%s': %v", label, err)
+	} else if entry.Type != "X.509" {
+		return nil, fmt.Errorf("wallet entry '%s' has unsupported type '%s'", label, entry.Type)
+	}
+
+	certificate, err := identity.CertificateFromPEM([]byte(entry.Credentials.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := identity.NewX509Identity(entry.MspId, certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(entry.Credentials.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	walletIdentity := &WalletIdentity{Label: label, Username: entry.Username, Role: entry.Role, Id: id, Sign: sign}
+	if walletIdentity.Username == "" {
+		walletIdentity.Username = label
+	}
+	if walletIdentity.Role == "" {
+		walletIdentity.Role = "user"
+	}
+
+	return walletIdentity, nil
+}
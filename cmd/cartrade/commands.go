@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	cartrade "github.com/bertkash/Car-Trading-Blockchain/client"
+	"github.com/spf13/cobra"
+)
+
+/*
+ * Returns the raw car, so the output shows
+ * all fields of the chaincode
+ */
+func rawCar(car *cartrade.Car, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return car.Raw, nil
+}
+
+func createCommand() *cobra.Command {
+	var proposalPath string
+
+	command := &cobra.Command{
+		Use:   "create <car.json>",
+		Short: "Create a new, unregistered car (garage)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			request := cartrade.CreateCarRequest{}
+			carAsBytes, err := readJson(args[0], &request.Car)
+			if err != nil {
+				return err
+			}
+
+			// pass all fields of the file, not only the typed ones
+			request.Car.Raw = carAsBytes
+
+			if proposalPath != "" {
+				request.Proposal = &cartrade.RegistrationProposal{}
+				_, err = readJson(proposalPath, request.Proposal)
+				if err != nil {
+					return err
+				}
+			}
+
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return rawCar(c.CreateCar(ctx, request))
+			})
+		},
+	}
+
+	command.Flags().StringVar(&proposalPath, "proposal", "", "registration proposal for the DOT (JSON file)")
+	return command
+}
+
+func readCommand() *cobra.Command {
+	var fields []string
+
+	command := &cobra.Command{
+		Use:   "read <vin>",
+		Short: "Read a car",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return rawCar(c.ReadCar(ctx, args[0], fields...))
+			})
+		},
+	}
+
+	command.Flags().StringSliceVar(&fields, "fields", nil, "return only these fields ('brand,certificate.numberplate')")
+	return command
+}
+
+func transferCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "transfer <vin> <receiver>",
+		Short: "Transfer a car to a new owner",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return rawCar(c.TransferCar(ctx, cartrade.TransferCarRequest{Vin: args[0], Receiver: args[1]}))
+			})
+		},
+	}
+}
+
+func confirmCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "confirm <vin> <numberplate>",
+		Short: "Confirm an insured car and assign a numberplate (DOT)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return rawCar(c.ConfirmCar(ctx, args[0], args[1]))
+			})
+		},
+	}
+}
+
+func historyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <vin>",
+		Short: "List all committed revisions of a car",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return c.GetHistory(ctx, args[0])
+			})
+		},
+	}
+}
+
+func offersCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "offers <vin>",
+		Short: "List the insurance quotes offered for a car",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return c.GetQuotes(ctx, args[0])
+			})
+		},
+	}
+}
+
+func expirationsCommand() *cobra.Command {
+	var batchSize int
+
+	command := &cobra.Command{
+		Use:   "expirations",
+		Short: "Revoke cars whose insurance or emission test expired, e.g. from cron (DOT)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *cartrade.Client) (interface{}, error) {
+				return c.ProcessExpirations(ctx, batchSize)
+			})
+		},
+	}
+	command.Flags().IntVar(&batchSize, "batch", 0, "expiry index entries per transaction, 0 for the chaincode default")
+	return command
+}
+
+/*
+ * Reads a JSON file into 'value' and
+ * returns the file content
+ */
+func readJson(path string, value interface{}) ([]byte, error) {
+	valueAsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(valueAsBytes, value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed JSON in '%s': %v", path, err)
+	}
+
+	return valueAsBytes, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	cartrade "github.com/bertkash/Car-Trading-Blockchain/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+/*
+ * Command-line tool for registry operations.
+ *
+ * Lets DOT clerks and garage admins work with the car
+ * chaincode without a UI. Connection profiles are Fabric
+ * common connection profiles (JSON), identities come from
+ * a wallet directory, see 'client.Wallet'.
+ */
+
+// global flags
+var (
+	profilePath string
+	walletDir   string
+	identity    string
+	peerName    string
+	channel     string
+	chaincode   string
+	timeout     time.Duration
+	compress    bool
+)
+
+func main() {
+	root := &cobra.Command{
+		Use:           "cartrade",
+		Short:         "Registry operations on the car chaincode",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&profilePath, "profile", "connection.json", "connection profile")
+	flags.StringVar(&walletDir, "wallet", "wallet", "identity wallet directory")
+	flags.StringVarP(&identity, "identity", "i", "", "wallet identity to act with (required)")
+	flags.StringVar(&peerName, "peer", "", "gateway peer, defaults to the first peer of the identity's organization")
+	flags.StringVar(&channel, "channel", "mychannel", "channel of the car chaincode")
+	flags.StringVar(&chaincode, "chaincode", "car_cc", "name of the car chaincode")
+	flags.DurationVar(&timeout, "timeout", 30*time.Second, "timeout of a command")
+	flags.BoolVar(&compress, "compress", false, "ask for gzip compressed query results")
+	root.MarkPersistentFlagRequired("identity")
+
+	root.AddCommand(createCommand(), readCommand(), transferCommand(), confirmCommand(), historyCommand(), offersCommand(), expirationsCommand())
+
+	err := root.Execute()
+	if err != nil {
+		// chaincode errors print as '<code>: <message>'
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+/*
+ * Connects with the wallet identity and calls 'run'
+ * with a client of the car chaincode
+ */
+func withClient(run func(ctx context.Context, c *cartrade.Client) (interface{}, error)) error {
+	profile, err := loadProfile(profilePath)
+	if err != nil {
+		return err
+	}
+
+	walletIdentity, err := cartrade.NewWallet(walletDir).Get(identity)
+	if err != nil {
+		return err
+	}
+
+	peer, err := profile.peerName(peerName, walletIdentity.Id.MspID())
+	if err != nil {
+		return err
+	}
+
+	connection, err := profile.dial(peer)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	gateway, err := client.Connect(walletIdentity.Id, client.WithSign(walletIdentity.Sign), client.WithClientConnection(connection))
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	contract := gateway.GetNetwork(channel).GetContract(chaincode)
+	options := []cartrade.Option{cartrade.WithTimeout(timeout)}
+	if compress {
+		options = append(options, cartrade.WithCompression())
+	}
+	c := cartrade.New(contract, walletIdentity.Username, walletIdentity.Role, options...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result, err := run(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(output))
+	return nil
+}
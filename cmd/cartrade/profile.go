@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+/*
+ * Subset of a Fabric common connection profile (JSON)
+ * needed to reach a gateway peer
+ */
+type ConnectionProfile struct {
+	Organizations map[string]struct {
+		MspId string   `json:"mspid"`
+		Peers []string `json:"peers"`
+	} `json:"organizations"`
+	Peers map[string]struct {
+		Url        string `json:"url"`
+		TlsCaCerts struct {
+			Pem  string `json:"pem"`
+			Path string `json:"path"`
+		} `json:"tlsCACerts"`
+		GrpcOptions map[string]interface{} `json:"grpcOptions"`
+	} `json:"peers"`
+
+	dir string // relative certificate paths start here
+}
+
+func loadProfile(path string) (*ConnectionProfile, error) {
+	profileAsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &ConnectionProfile{dir: filepath.Dir(path)}
+	err = json.Unmarshal(profileAsBytes, profile)
+	if err != nil {
+		return nil, fmt.Errorf("malformed connection profile '%s': %v", path, err)
+	}
+
+	return profile, nil
+}
+
+/*
+ * Returns the peer 'name', or the first peer of the
+ * organization with MSP 'mspId' if no name is given
+ */
+func (p *ConnectionProfile) peerName(name string, mspId string) (string, error) {
+	if name != "" {
+		if _, ok := p.Peers[name]; !ok {
+			return "", fmt.Errorf("peer '%s' not found in connection profile", name)
+		}
+		return name, nil
+	}
+
+	for _, org := range p.Organizations {
+		if org.MspId == mspId && len(org.Peers) > 0 {
+			peers := append([]string{}, org.Peers...)
+			sort.Strings(peers)
+			return peers[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("no peer of '%s' in connection profile, choose one with --peer", mspId)
+}
+
+/*
+ * Opens a gRPC connection to a peer of the profile
+ */
+func (p *ConnectionProfile) dial(name string) (*grpc.ClientConn, error) {
+	peer := p.Peers[name]
+
+	target := peer.Url
+	secure := true
+	if strings.HasPrefix(target, "grpcs://") {
+		target = strings.TrimPrefix(target, "grpcs://")
+	} else if strings.HasPrefix(target, "grpc://") {
+		target = strings.TrimPrefix(target, "grpc://")
+		secure = false
+	}
+
+	if !secure {
+		return grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	pem := []byte(peer.TlsCaCerts.Pem)
+	if len(pem) == 0 {
+		path := peer.TlsCaCerts.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(p.dir, path)
+		}
+
+		var err error
+		pem, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no TLS certificate for peer '%s'", name)
+	}
+
+	hostOverride, _ := peer.GrpcOptions["ssl-target-name-override"].(string)
+	return grpc.NewClient(target, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, hostOverride)))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/status"
+)
+
+/*
+ * Error envelope of the chaincode, see 'errors.go'
+ * of the chaincode for the codes
+ */
+type ChaincodeError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// HTTP status by chaincode error code, unknown codes map to 500
+var errorStatus = map[string]int{
+	"INVALID_ARGUMENT":   http.StatusBadRequest,
+	"UNKNOWN_FUNCTION":   http.StatusBadRequest,
+	"VIN_LENGTH":         http.StatusBadRequest,
+	"VIN_CHARACTER":      http.StatusBadRequest,
+	"VIN_CHECK_DIGIT":    http.StatusBadRequest,
+	"FORBIDDEN_ROLE":     http.StatusForbidden,
+	"NOT_OWNER":          http.StatusForbidden,
+	"FORBIDDEN":          http.StatusForbidden,
+	"IDENTITY_MISMATCH":  http.StatusForbidden,
+	"CAR_EXISTS":         http.StatusConflict,
+	"USER_EXISTS":        http.StatusConflict,
+	"ALREADY_EXISTS":     http.StatusConflict,
+	"NUMBERPLATE_IN_USE": http.StatusConflict,
+	"CAR_NOT_FOUND":      http.StatusNotFound,
+	"USER_NOT_FOUND":     http.StatusNotFound,
+	"NOT_FOUND":          http.StatusNotFound,
+	"NOT_REGISTERED":     http.StatusConflict,
+	"NOT_INSURED":        http.StatusConflict,
+	"NOT_CONFIRMED":      http.StatusConflict,
+	"NOT_ACTIVE":         http.StatusConflict,
+	"INVALID_STATE":      http.StatusConflict,
+	"INSUFFICIENT_FUNDS": http.StatusPaymentRequired,
+	"LEDGER_ERROR":       http.StatusServiceUnavailable,
+	"INTERNAL":           http.StatusInternalServerError,
+}
+
+// codes of errors raised by the gateway itself
+const (
+	ErrInvalidRequest  string = "INVALID_REQUEST"
+	ErrUnknownIdentity string = "UNKNOWN_IDENTITY"
+	ErrUnavailable     string = "UNAVAILABLE"
+)
+
+/*
+ * Extracts the chaincode error envelope from a Fabric
+ * Gateway error.
+ *
+ * The envelope is the message of the chaincode response,
+ * which the peers report in the error details. Errors
+ * without an envelope, e.g. a peer that cannot be
+ * reached, are reported as 'UNAVAILABLE'.
+ */
+func chaincodeError(err error) *ChaincodeError {
+	messages := []string{err.Error()}
+	for _, detail := range status.Convert(err).Details() {
+		if message, ok := detail.(interface{ GetMessage() string }); ok {
+			messages = append(messages, message.GetMessage())
+		}
+	}
+
+	for _, message := range messages {
+		start := strings.Index(message, "{")
+		end := strings.LastIndex(message, "}")
+		if start < 0 || end < start {
+			continue
+		}
+
+		ccErr := &ChaincodeError{}
+		if json.Unmarshal([]byte(message[start:end+1]), ccErr) == nil && ccErr.Code != "" {
+			return ccErr
+		}
+	}
+
+	return &ChaincodeError{Code: ErrUnavailable, Message: err.Error()}
+}
+
+/*
+ * Writes an error envelope with the HTTP status of its code
+ */
+func writeError(w http.ResponseWriter, ccErr *ChaincodeError) {
+	code, ok := errorStatus[ccErr.Code]
+	switch {
+	case ok:
+	case ccErr.Code == ErrInvalidRequest:
+		code = http.StatusBadRequest
+	case ccErr.Code == ErrUnknownIdentity:
+		code = http.StatusUnauthorized
+	case ccErr.Code == ErrUnavailable:
+		code = http.StatusBadGateway
+	default:
+		code = http.StatusInternalServerError
+	}
+
+	envelopeAsBytes, _ := json.Marshal(ccErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(envelopeAsBytes)
+}
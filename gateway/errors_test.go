@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChaincodeError(t *testing.T) {
+	err := errors.New(`rpc error: code = Aborted desc = failed to endorse transaction, chaincode response 500, {"code":"NOT_OWNER","message":"Sorry, you are not the owner"}`)
+
+	ccErr := chaincodeError(err)
+	if ccErr.Code != "NOT_OWNER" {
+		t.Errorf("Expected code 'NOT_OWNER', got '%s'", ccErr.Code)
+	}
+
+	ccErr = chaincodeError(errors.New("connection refused"))
+	if ccErr.Code != ErrUnavailable {
+		t.Errorf("Expected code '%s', got '%s'", ErrUnavailable, ccErr.Code)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := map[string]int{
+		"CAR_NOT_FOUND":      404,
+		"NOT_OWNER":          403,
+		"INSUFFICIENT_FUNDS": 402,
+		ErrUnknownIdentity:   401,
+		"SOMETHING_NEW":      500,
+	}
+
+	for code, status := range tests {
+		recorder := httptest.NewRecorder()
+		writeError(recorder, &ChaincodeError{Code: code})
+		if recorder.Code != status {
+			t.Errorf("Expected status %d for code '%s', got %d", status, code, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	cartrade "github.com/bertkash/Car-Trading-Blockchain/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+/*
+ * REST API gateway.
+ *
+ * Exposes the car chaincode as REST endpoints and maps
+ * them to invokes and queries with the Fabric Gateway SDK.
+ * Failed calls return the error envelope of the chaincode
+ * with a matching HTTP status.
+ */
+func main() {
+	listen := flag.String("listen", ":8080", "HTTP listen address")
+	peer := flag.String("peer", "localhost:7051", "gateway peer endpoint")
+	peerHost := flag.String("peer-host", "", "TLS host name of the peer, if it differs from the endpoint")
+	tlsCert := flag.String("tls-cert", "", "TLS CA certificate of the peer")
+	walletDir := flag.String("wallet", "wallet", "identity wallet directory")
+	channel := flag.String("channel", "mychannel", "channel of the car chaincode")
+	chaincode := flag.String("chaincode", "car_cc", "name of the car chaincode")
+	flag.Parse()
+
+	pem, err := os.ReadFile(*tlsCert)
+	if err != nil {
+		log.Fatalf("Error reading TLS certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatalf("No certificate found in '%s'", *tlsCert)
+	}
+
+	connection, err := grpc.NewClient(*peer, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, *peerHost)))
+	if err != nil {
+		log.Fatalf("Error connecting to peer: %v", err)
+	}
+	defer connection.Close()
+
+	server := NewServer(connection, cartrade.NewWallet(*walletDir), *channel, *chaincode,
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(time.Minute))
+	defer server.Close()
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, server.Handler()))
+}
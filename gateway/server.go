@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	cartrade "github.com/bertkash/Car-Trading-Blockchain/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+)
+
+// header selecting the wallet identity of a request
+const identityHeader string = "X-Identity"
+
+/*
+ * REST server mapping HTTP calls to chaincode
+ * invokes and queries.
+ *
+ * Every request acts with the wallet identity named in
+ * the 'X-Identity' header. The gateway does not
+ * authenticate HTTP clients, run it behind a proxy
+ * which does and sets the header.
+ */
+type Server struct {
+	connection *grpc.ClientConn
+	wallet     *cartrade.Wallet
+	channel    string
+	chaincode  string
+	options    []client.ConnectOption
+
+	lock     sync.Mutex
+	gateways map[string]*contractIdentity
+}
+
+/*
+ * Contract connected with one wallet identity
+ */
+type contractIdentity struct {
+	identity *cartrade.WalletIdentity
+	gateway  *client.Gateway
+	contract *client.Contract
+}
+
+func NewServer(connection *grpc.ClientConn, wallet *cartrade.Wallet, channel string, chaincode string, options ...client.ConnectOption) *Server {
+	return &Server{
+		connection: connection,
+		wallet:     wallet,
+		channel:    channel,
+		chaincode:  chaincode,
+		options:    options,
+		gateways:   make(map[string]*contractIdentity)}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /cars", s.createCar)
+	mux.HandleFunc("GET /cars/{vin}", s.readCar)
+	mux.HandleFunc("POST /cars/{vin}/transfer", s.transferCar)
+	mux.HandleFunc("POST /cars/{vin}/sell", s.sellCar)
+	mux.HandleFunc("POST /cars/{vin}/confirm", s.confirmCar)
+	mux.HandleFunc("GET /users/me", s.readUser)
+	return mux
+}
+
+/*
+ * Closes the gateways of all identities
+ */
+func (s *Server) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, gateway := range s.gateways {
+		gateway.gateway.Close()
+	}
+	s.gateways = make(map[string]*contractIdentity)
+}
+
+/*
+ * Returns the contract for the identity of the request,
+ * connecting a new gateway on first use
+ */
+func (s *Server) contract(r *http.Request) (*contractIdentity, *ChaincodeError) {
+	label := r.Header.Get(identityHeader)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if gateway, ok := s.gateways[label]; ok {
+		return gateway, nil
+	}
+
+	identity, err := s.wallet.Get(label)
+	if err != nil {
+		return nil, &ChaincodeError{Code: ErrUnknownIdentity, Message: "Unknown identity '" + label + "' in header '" + identityHeader + "'"}
+	}
+
+	options := append([]client.ConnectOption{client.WithSign(identity.Sign), client.WithClientConnection(s.connection)}, s.options...)
+	gateway, err := client.Connect(identity.Id, options...)
+	if err != nil {
+		return nil, &ChaincodeError{Code: ErrUnavailable, Message: err.Error()}
+	}
+
+	contract := &contractIdentity{
+		identity: identity,
+		gateway:  gateway,
+		contract: gateway.GetNetwork(s.channel).GetContract(s.chaincode)}
+	s.gateways[label] = contract
+	return contract, nil
+}
+
+/*
+ * Submits a chaincode transaction with the username and
+ * role of the request identity and writes the result
+ */
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, status int, function string, args ...string) {
+	s.call(w, r, status, true, function, args)
+}
+
+/*
+ * Evaluates a chaincode query with the username and
+ * role of the request identity and writes the result
+ */
+func (s *Server) evaluate(w http.ResponseWriter, r *http.Request, function string, args ...string) {
+	s.call(w, r, http.StatusOK, false, function, args)
+}
+
+func (s *Server) call(w http.ResponseWriter, r *http.Request, status int, submit bool, function string, args []string) {
+	contract, ccErr := s.contract(r)
+	if ccErr != nil {
+		writeError(w, ccErr)
+		return
+	}
+
+	args = append([]string{contract.identity.Username, contract.identity.Role}, args...)
+
+	options := []client.ProposalOption{client.WithArguments(args...)}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		// retried requests are only done once by the chaincode
+		options = append(options, client.WithTransient(map[string][]byte{"idempotencyKey": []byte(key)}))
+	}
+
+	var result []byte
+	var err error
+	if submit {
+		result, err = contract.contract.SubmitWithContext(r.Context(), function, options...)
+	} else {
+		result, err = contract.contract.EvaluateWithContext(r.Context(), function, options...)
+	}
+	if err != nil {
+		writeError(w, chaincodeError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+/*
+ * Decodes the JSON request body into 'request'
+ */
+func decode(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		writeError(w, &ChaincodeError{Code: ErrInvalidRequest, Message: "Malformed request body: " + err.Error()})
+		return false
+	}
+
+	return true
+}
+
+/*
+ * POST /cars
+ *
+ * Body: {"car": <car>, "proposal": <registration proposal>},
+ * the proposal is optional
+ */
+func (s *Server) createCar(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Car      json.RawMessage `json:"car"`
+		Proposal json.RawMessage `json:"proposal"`
+	}{}
+	if !decode(w, r, &request) {
+		return
+	} else if len(request.Car) == 0 {
+		writeError(w, &ChaincodeError{Code: ErrInvalidRequest, Message: "Expecting the car as 'car'"})
+		return
+	}
+
+	args := []string{string(request.Car)}
+	if len(request.Proposal) > 0 {
+		args = append(args, string(request.Proposal))
+	}
+	s.submit(w, r, http.StatusCreated, "create", args...)
+}
+
+/*
+ * GET /cars/{vin}
+ *
+ * Query: fields=<field>,<field> to return only those fields
+ */
+func (s *Server) readCar(w http.ResponseWriter, r *http.Request) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		s.evaluate(w, r, "readCar", r.PathValue("vin"))
+		return
+	}
+
+	fieldsAsBytes, _ := json.Marshal(strings.Split(fields, ","))
+	s.evaluate(w, r, "readCar", r.PathValue("vin"), string(fieldsAsBytes))
+}
+
+/*
+ * POST /cars/{vin}/transfer
+ *
+ * Body: {"receiver": <username>}
+ */
+func (s *Server) transferCar(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Receiver string `json:"receiver"`
+	}{}
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.submit(w, r, http.StatusOK, "transfer", r.PathValue("vin"), request.Receiver)
+}
+
+/*
+ * POST /cars/{vin}/sell
+ *
+ * Body: {"price": <int>, "buyer": <username>}
+ */
+func (s *Server) sellCar(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Price int    `json:"price"`
+		Buyer string `json:"buyer"`
+	}{}
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.submit(w, r, http.StatusOK, "sell", strconv.Itoa(request.Price), r.PathValue("vin"), request.Buyer)
+}
+
+/*
+ * POST /cars/{vin}/confirm
+ *
+ * Body: {"numberplate": <numberplate>}
+ */
+func (s *Server) confirmCar(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Numberplate string `json:"numberplate"`
+	}{}
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.submit(w, r, http.StatusOK, "confirm", r.PathValue("vin"), request.Numberplate)
+}
+
+/*
+ * GET /users/me
+ */
+func (s *Server) readUser(w http.ResponseWriter, r *http.Request) {
+	s.evaluate(w, r, "readUser")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/events/deliverclient/seek"
+)
+
+// username and role the listener exports the ledger with
+const listenerUsername string = "listener"
+const listenerRole string = "admin"
+
+// entries per 'exportState' page
+const exportPageSize int = 500
+
+/*
+ * Follows the blocks of the channel and keeps
+ * the projection up to date
+ */
+type Listener struct {
+	context   context.ChannelProvider
+	chaincode string
+	store     *Store
+	channel   *channel.Client
+	webhooks  *Dispatcher // nil without webhooks
+}
+
+func NewListener(ctx context.ChannelProvider, chaincode string, store *Store, webhooks *Dispatcher) (*Listener, error) {
+	client, err := channel.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{context: ctx, chaincode: chaincode, store: store, channel: client, webhooks: webhooks}, nil
+}
+
+/*
+ * Pulls all pages of an export and applies them.
+ * Without a position, the full ledger is exported.
+ */
+func (l *Listener) sync(position int64, full bool, block int64) error {
+	bookmark := ""
+	for {
+		args := [][]byte{[]byte(listenerUsername), []byte(listenerRole), []byte(""), []byte(bookmark), []byte(strconv.Itoa(exportPageSize))}
+		if !full {
+			args = append(args, []byte(strconv.FormatInt(position, 10)))
+		}
+
+		response, err := l.channel.Query(channel.Request{ChaincodeID: l.chaincode, Fcn: "exportState", Args: args})
+		if err != nil {
+			return err
+		}
+
+		page := SnapshotPage{}
+		err = json.Unmarshal(response.Payload, &page)
+		if err != nil {
+			return fmt.Errorf("malformed snapshot page: %v", err)
+		}
+
+		err = l.store.ApplyPage(page, block)
+		if err != nil {
+			return err
+		}
+
+		if page.Bookmark == "" {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+/*
+ * Runs until 'done' receives a signal.
+ *
+ * Without a stored position, the projection starts with
+ * a full export and follows the blocks from the genesis
+ * block, so a replay sees all chaincode events again.
+ * Otherwise it continues after the last processed block.
+ */
+func (l *Listener) Run(done chan os.Signal) error {
+	position, block, err := l.store.Position()
+	if err != nil {
+		return err
+	}
+
+	if position == 0 {
+		log.Printf("No projection yet, running a full export")
+		err = l.sync(0, true, block)
+		if err != nil {
+			return err
+		}
+		block = -1
+	}
+
+	events, err := event.New(l.context, event.WithBlockEvents(), event.WithSeekType(seek.FromBlock), event.WithBlockNum(uint64(block+1)))
+	if err != nil {
+		return err
+	}
+
+	blockRegistration, blocks, err := events.RegisterBlockEvent()
+	if err != nil {
+		return err
+	}
+	defer events.Unregister(blockRegistration)
+
+	ccRegistration, ccEvents, err := events.RegisterChaincodeEvent(l.chaincode, ".*")
+	if err != nil {
+		return err
+	}
+	defer events.Unregister(ccRegistration)
+
+	log.Printf("Listening from block %d", block+1)
+
+	for {
+		select {
+		case <-done:
+			return nil
+
+		case ccEvent, ok := <-ccEvents:
+			if !ok {
+				return fmt.Errorf("chaincode event stream closed")
+			}
+			err = l.store.AddEvent(ccEvent.TxID, ccEvent.EventName, ccEvent.BlockNumber, ccEvent.Payload)
+			if err != nil {
+				return err
+			}
+
+			if l.webhooks != nil {
+				err = l.webhooks.Enqueue(ccEvent.TxID, ccEvent.EventName, ccEvent.BlockNumber, ccEvent.Payload)
+				if err != nil {
+					return err
+				}
+			}
+
+		case blockEvent, ok := <-blocks:
+			if !ok {
+				return fmt.Errorf("block event stream closed")
+			}
+
+			number := int64(blockEvent.Block.Header.Number)
+			position, _, err := l.store.Position()
+			if err != nil {
+				return err
+			}
+
+			err = l.sync(position, false, number)
+			if err != nil {
+				return fmt.Errorf("block %d: %v", number, err)
+			}
+		}
+	}
+}
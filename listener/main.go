@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+ * Block listener and projection service.
+ *
+ * Follows the blocks of the car channel and keeps a local
+ * database projection of cars, owners and rental offers
+ * for fast search. After every block, the changes of the
+ * car chaincode are pulled with 'exportState' in
+ * incremental mode. Chaincode events are stored as well.
+ *
+ * Start with '-replay' to rebuild the projection from
+ * a full export and all blocks from the genesis block.
+ *
+ * With '-webhooks', subscribers are notified about
+ * sales, recalls and thefts, see 'webhook.go'.
+ */
+func main() {
+	sdkConfig := flag.String("config", "config.yaml", "Fabric SDK connection profile")
+	channel := flag.String("channel", "mychannel", "channel of the car chaincode")
+	chaincode := flag.String("chaincode", "car_cc", "name of the car chaincode")
+	org := flag.String("org", "Org1", "organization of the listener identity")
+	user := flag.String("user", "Admin", "listener identity, needs the 'admin' role of the chaincode")
+	driver := flag.String("db-driver", "sqlite3", "database driver, 'sqlite3' or 'postgres'")
+	dsn := flag.String("db", "cars.db", "database connection string")
+	replay := flag.Bool("replay", false, "rebuild the projection from scratch")
+	webhookFile := flag.String("webhooks", "", "webhook file, none to disable webhooks")
+	flag.Parse()
+
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewStore(db)
+	err = store.Migrate()
+	if err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
+
+	if *replay {
+		err = store.Reset()
+		if err != nil {
+			log.Fatalf("Error resetting projection: %v", err)
+		}
+	}
+
+	sdk, err := fabsdk.New(config.FromFile(*sdkConfig))
+	if err != nil {
+		log.Fatalf("Error creating Fabric SDK: %v", err)
+	}
+	defer sdk.Close()
+
+	var dispatcher *Dispatcher
+	if *webhookFile != "" {
+		webhooks, err := loadWebhooks(*webhookFile)
+		if err != nil {
+			log.Fatalf("Error loading webhooks: %v", err)
+		}
+
+		dispatcher = NewDispatcher(store, webhooks)
+		stop := make(chan struct{})
+		defer close(stop)
+		go dispatcher.Run(5*time.Second, stop)
+	}
+
+	context := sdk.ChannelContext(*channel, fabsdk.WithUser(*user), fabsdk.WithOrg(*org))
+	listener, err := NewListener(context, *chaincode, store, dispatcher)
+	if err != nil {
+		log.Fatalf("Error creating listener: %v", err)
+	}
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
+
+	err = listener.Run(done)
+	if err != nil {
+		log.Fatalf("Listener stopped: %v", err)
+	}
+}
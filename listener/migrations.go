@@ -0,0 +1,74 @@
+package main
+
+/*
+ * Schema migrations of the projection database.
+ *
+ * Migrations run in order and are recorded in
+ * 'schema_migrations', so every migration runs once.
+ * Append new migrations, never change applied ones.
+ * The SQL has to work with SQLite and PostgreSQL.
+ */
+var migrations = []string{
+	// 1: projection of cars, owners and rental offers
+	`CREATE TABLE cars (
+		vin          TEXT PRIMARY KEY,
+		brand        TEXT NOT NULL DEFAULT '',
+		model        TEXT NOT NULL DEFAULT '',
+		type         TEXT NOT NULL DEFAULT '',
+		color        TEXT NOT NULL DEFAULT '',
+		numberplate  TEXT NOT NULL DEFAULT '',
+		insurer      TEXT NOT NULL DEFAULT '',
+		registered   BOOLEAN NOT NULL DEFAULT FALSE,
+		stolen       BOOLEAN NOT NULL DEFAULT FALSE,
+		mile_age     INTEGER NOT NULL DEFAULT 0,
+		created_ts   BIGINT NOT NULL DEFAULT 0
+	);
+	CREATE TABLE owners (
+		name         TEXT PRIMARY KEY,
+		balance      INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE ownership (
+		vin          TEXT PRIMARY KEY,
+		owner        TEXT NOT NULL
+	);
+	CREATE INDEX ownership_owner ON ownership (owner);
+	CREATE TABLE offers (
+		vin          TEXT PRIMARY KEY,
+		renter       TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		start_ts     BIGINT NOT NULL,
+		end_ts       BIGINT NOT NULL,
+		deposit      INTEGER NOT NULL
+	);`,
+
+	// 2: chaincode events and the position of the listener
+	`CREATE TABLE events (
+		tx_id        TEXT NOT NULL,
+		name         TEXT NOT NULL,
+		block        BIGINT NOT NULL,
+		payload      TEXT NOT NULL,
+		PRIMARY KEY (tx_id, name)
+	);
+	CREATE TABLE listener_state (
+		id           INTEGER PRIMARY KEY,
+		position     BIGINT NOT NULL,
+		block        BIGINT NOT NULL
+	);
+	INSERT INTO listener_state (id, position, block) VALUES (1, 0, -1);`,
+
+	// 3: webhook deliveries and their status
+	`CREATE TABLE webhook_deliveries (
+		id           TEXT PRIMARY KEY,
+		webhook      TEXT NOT NULL,
+		tx_id        TEXT NOT NULL,
+		event        TEXT NOT NULL,
+		body         TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		last_error   TEXT NOT NULL DEFAULT '',
+		next_ts      BIGINT NOT NULL,
+		delivered_ts BIGINT NOT NULL DEFAULT 0,
+		UNIQUE (webhook, tx_id, event)
+	);
+	CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_ts);`,
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// VIN length according to ISO 3779, car keys are plain VINs
+const vinLength int = 17
+
+// key prefix of users
+const userKeyPrefix string = "usr_"
+
+// rental status of a car offered for rent
+const rentalOffered string = "offered"
+
+/*
+ * Subset of the chaincode models the projection needs.
+ * Unknown fields are ignored, so the chaincode can grow
+ * without breaking the listener.
+ */
+type Car struct {
+	Vin         string      `json:"vin"`
+	CreatedTs   int64       `json:"created_ts"`
+	Certificate Certificate `json:"certificate"`
+	UsageData   struct {
+		MileAge int `json:"mile_age"`
+	} `json:"usage_data"`
+	Stolen   bool      `json:"stolen"`
+	Rental   Rental    `json:"rental"`
+	Archived *struct{} `json:"archived"` // set on the tombstone of a scrapped or exported car
+}
+
+type Certificate struct {
+	Username    string `json:"username"` // owner of the car
+	Vin         string `json:"vin"`      // set when the car is registered
+	Numberplate string `json:"numberplate"`
+	Insurer     string `json:"insurer"`
+	Color       string `json:"color"`
+	Type        string `json:"type"`
+	Brand       string `json:"brand"`
+	Model       string `json:"model"`
+}
+
+type Rental struct {
+	Renter  string `json:"renter"`
+	Status  string `json:"status"`
+	StartTs int64  `json:"start_ts"`
+	EndTs   int64  `json:"end_ts"`
+	Deposit int    `json:"deposit"`
+}
+
+type User struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+/*
+ * Page of 'exportState', see the chaincode for the format
+ */
+type SnapshotPage struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Mode     string          `json:"mode"`
+	Entries  []SnapshotEntry `json:"entries"`
+	Bookmark string          `json:"bookmark"`
+	Position int64           `json:"position"`
+}
+
+type SnapshotEntry struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Deleted bool            `json:"deleted"`
+	TxId    string          `json:"tx_id"`
+}
+
+/*
+ * Change of the projection derived from one snapshot entry.
+ * Exactly one of the fields is set, or none for entries
+ * the projection does not keep.
+ */
+type Projection struct {
+	Car         *Car
+	DeletedCar  string
+	User        *User
+	DeletedUser string
+}
+
+/*
+ * Checks if a car is registered, like 'IsRegistered'
+ * of the chaincode
+ */
+func isRegistered(car *Car) bool {
+	return car.Certificate.Vin != "" && car.Certificate.Vin == car.Vin
+}
+
+/*
+ * Checks if 'key' is the key of a car
+ */
+func isCarKey(key string) bool {
+	return len(key) == vinLength && !strings.HasPrefix(key, "\x00") && !strings.Contains(key, "_")
+}
+
+/*
+ * Maps a snapshot entry to a projection change.
+ *
+ * Cars are stored under their VIN and users under
+ * 'usr_<name>'. All other keys are skipped. Archived
+ * cars leave a tombstone at their VIN and are dropped
+ * like deleted cars.
+ */
+func project(entry SnapshotEntry) (Projection, error) {
+	switch {
+	case strings.HasPrefix(entry.Key, userKeyPrefix):
+		name := entry.Key[len(userKeyPrefix):]
+		if entry.Deleted {
+			return Projection{DeletedUser: name}, nil
+		}
+
+		user := User{}
+		err := json.Unmarshal(entry.Value, &user)
+		if err != nil {
+			return Projection{}, err
+		}
+		if user.Name == "" {
+			user.Name = name
+		}
+		return Projection{User: &user}, nil
+
+	case isCarKey(entry.Key):
+		if entry.Deleted {
+			return Projection{DeletedCar: entry.Key}, nil
+		}
+
+		car := Car{}
+		err := json.Unmarshal(entry.Value, &car)
+		if err != nil {
+			return Projection{}, err
+		}
+		if car.Archived != nil {
+			return Projection{DeletedCar: entry.Key}, nil
+		}
+		if car.Vin == "" {
+			car.Vin = entry.Key
+		}
+		return Projection{Car: &car}, nil
+	}
+
+	return Projection{}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProjectCar(t *testing.T) {
+	entry := SnapshotEntry{
+		Key:   "WVWZZZ6R6HY260780",
+		Value: []byte(`{"vin":"WVWZZZ6R6HY260780","certificate":{"vin":"WVWZZZ6R6HY260780","brand":"VW"},"rental":{"renter":"bob","status":"offered"}}`)}
+
+	projection, err := project(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projection.Car == nil || projection.Car.Certificate.Brand != "VW" || !isRegistered(projection.Car) {
+		t.Errorf("Expected registered car of brand 'VW', got %+v", projection.Car)
+	}
+	if projection.Car.Rental.Status != rentalOffered {
+		t.Errorf("Expected the car to be offered for rent, got '%s'", projection.Car.Rental.Status)
+	}
+
+	entry = SnapshotEntry{Key: "WVWZZZ6R6HY260780", Deleted: true}
+	projection, err = project(entry)
+	if err != nil || projection.DeletedCar != "WVWZZZ6R6HY260780" {
+		t.Errorf("Expected deleted car, got %+v", projection)
+	}
+
+	entry = SnapshotEntry{
+		Key:   "WVWZZZ6R6HY260780",
+		Value: []byte(`{"vin":"WVWZZZ6R6HY260780","archived":{"reason":"scrapped","ts":1500000000,"tx_id":"1"}}`)}
+	projection, err = project(entry)
+	if err != nil || projection.DeletedCar != "WVWZZZ6R6HY260780" {
+		t.Errorf("Expected archived car to be dropped, got %+v", projection)
+	}
+}
+
+func TestProjectUser(t *testing.T) {
+	entry := SnapshotEntry{Key: "usr_amag", Value: []byte(`{"name":"amag","balance":90}`)}
+
+	projection, err := project(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projection.User == nil || projection.User.Name != "amag" || projection.User.Balance != 90 {
+		t.Errorf("Expected user 'amag' with 90 credits, got %+v", projection.User)
+	}
+
+	entry = SnapshotEntry{Key: "usr_amag", Deleted: true}
+	projection, err = project(entry)
+	if err != nil || projection.DeletedUser != "amag" {
+		t.Errorf("Expected deleted user, got %+v", projection)
+	}
+}
+
+func TestProjectSkipsOtherKeys(t *testing.T) {
+	for _, key := range []string{"config", "_cars", "\x00proposal\x00WVWZZZ6R6HY260780\x00"} {
+		projection, err := project(SnapshotEntry{Key: key, Value: []byte(`{}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if projection != (Projection{}) {
+			t.Errorf("Expected key '%q' to be skipped, got %+v", key, projection)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+/*
+ * Projection database.
+ *
+ * Every snapshot page is applied in one database
+ * transaction together with the new listener position,
+ * so a crash never leaves a half applied page behind.
+ */
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+/*
+ * Applies all migrations not applied yet
+ */
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	for version := applied + 1; version <= len(migrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[version-1])
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Clears the projection for a replay
+ */
+func (s *Store) Reset() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"cars", "owners", "ownership", "offers", "events"} {
+		_, err = tx.Exec(`DELETE FROM ` + table)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE listener_state SET position = 0, block = -1 WHERE id = 1`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Returns the journal position and the last processed block.
+ * A position of 0 means nothing was exported yet.
+ */
+func (s *Store) Position() (int64, int64, error) {
+	var position, block int64
+	err := s.db.QueryRow(`SELECT position, block FROM listener_state WHERE id = 1`).Scan(&position, &block)
+	return position, block, err
+}
+
+/*
+ * Applies a snapshot page and moves the listener to
+ * 'position' and 'block'
+ */
+func (s *Store) ApplyPage(page SnapshotPage, block int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range page.Entries {
+		projection, err := project(entry)
+		if err == nil {
+			err = applyProjection(tx, projection)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("key '%s': %v", entry.Key, err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE listener_state SET position = $1, block = $2 WHERE id = 1`, page.Position, block)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+ * Writes one projection change
+ */
+func applyProjection(tx *sql.Tx, projection Projection) error {
+	switch {
+	case projection.Car != nil:
+		return upsertCar(tx, projection.Car)
+
+	case projection.DeletedCar != "":
+		for _, table := range []string{"cars", "ownership", "offers"} {
+			_, err := tx.Exec(`DELETE FROM `+table+` WHERE vin = $1`, projection.DeletedCar)
+			if err != nil {
+				return err
+			}
+		}
+
+	case projection.User != nil:
+		return upsertUser(tx, projection.User)
+
+	case projection.DeletedUser != "":
+		_, err := tx.Exec(`DELETE FROM owners WHERE name = $1`, projection.DeletedUser)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM ownership WHERE owner = $1`, projection.DeletedUser)
+		return err
+	}
+
+	return nil
+}
+
+func upsertCar(tx *sql.Tx, car *Car) error {
+	_, err := tx.Exec(`INSERT INTO cars (vin, brand, model, type, color, numberplate, insurer, registered, stolen, mile_age, created_ts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (vin) DO UPDATE SET brand = excluded.brand, model = excluded.model, type = excluded.type,
+			color = excluded.color, numberplate = excluded.numberplate, insurer = excluded.insurer,
+			registered = excluded.registered, stolen = excluded.stolen, mile_age = excluded.mile_age,
+			created_ts = excluded.created_ts`,
+		car.Vin, car.Certificate.Brand, car.Certificate.Model, car.Certificate.Type, car.Certificate.Color,
+		car.Certificate.Numberplate, car.Certificate.Insurer, isRegistered(car), car.Stolen,
+		car.UsageData.MileAge, car.CreatedTs)
+	if err != nil {
+		return err
+	}
+
+	// the owner is in the certificate, users do not list their cars
+	_, err = tx.Exec(`INSERT INTO ownership (vin, owner) VALUES ($1, $2)
+		ON CONFLICT (vin) DO UPDATE SET owner = excluded.owner`, car.Vin, car.Certificate.Username)
+	if err != nil {
+		return err
+	}
+
+	if car.Rental.Status != rentalOffered {
+		_, err = tx.Exec(`DELETE FROM offers WHERE vin = $1`, car.Vin)
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO offers (vin, renter, status, start_ts, end_ts, deposit)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (vin) DO UPDATE SET renter = excluded.renter, status = excluded.status,
+			start_ts = excluded.start_ts, end_ts = excluded.end_ts, deposit = excluded.deposit`,
+		car.Vin, car.Rental.Renter, car.Rental.Status, car.Rental.StartTs, car.Rental.EndTs, car.Rental.Deposit)
+	return err
+}
+
+/*
+ * Writes an owner, its cars are written with the cars
+ */
+func upsertUser(tx *sql.Tx, user *User) error {
+	_, err := tx.Exec(`INSERT INTO owners (name, balance) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET balance = excluded.balance`,
+		user.Name, user.Balance)
+	return err
+}
+
+/*
+ * Stores a chaincode event, events seen before are skipped
+ */
+func (s *Store) AddEvent(txId string, name string, block uint64, payload []byte) error {
+	_, err := s.db.Exec(`INSERT INTO events (tx_id, name, block, payload) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tx_id, name) DO NOTHING`, txId, name, int64(block), string(payload))
+	return err
+}
+
+/*
+ * Webhook delivery, see 'Dispatcher'
+ */
+type Delivery struct {
+	Id       string
+	Webhook  string
+	Event    string
+	Body     []byte
+	Attempts int
+}
+
+/*
+ * Returns the owner of car 'vin', or "" if unknown
+ */
+func (s *Store) Owner(vin string) (string, error) {
+	owner := ""
+	err := s.db.QueryRow(`SELECT owner FROM ownership WHERE vin = $1`, vin).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return owner, err
+}
+
+/*
+ * Queues a delivery, a delivery of the same event to
+ * the same webhook, e.g. during a replay, is skipped
+ */
+func (s *Store) AddDelivery(id string, webhook string, txId string, event string, body []byte) error {
+	_, err := s.db.Exec(`INSERT INTO webhook_deliveries (id, webhook, tx_id, event, body, status, next_ts)
+		VALUES ($1, $2, $3, $4, $5, $6, 0)
+		ON CONFLICT (webhook, tx_id, event) DO NOTHING`, id, webhook, txId, event, string(body), deliveryPending)
+	return err
+}
+
+/*
+ * Returns the pending deliveries due at 'now'
+ */
+func (s *Store) DueDeliveries(now int64) ([]Delivery, error) {
+	rows, err := s.db.Query(`SELECT id, webhook, event, body, attempts FROM webhook_deliveries
+		WHERE status = $1 AND next_ts <= $2 ORDER BY next_ts, id`, deliveryPending, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		delivery := Delivery{}
+		body := ""
+		err = rows.Scan(&delivery.Id, &delivery.Webhook, &delivery.Event, &body, &delivery.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Body = []byte(body)
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+/*
+ * Records the outcome of a delivery attempt
+ */
+func (s *Store) FinishDelivery(id string, status string, attempts int, lastError string, nextTs int64, deliveredTs int64) error {
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status = $1, attempts = $2, last_error = $3, next_ts = $4, delivered_ts = $5
+		WHERE id = $6`, status, attempts, lastError, nextTs, deliveredTs, id)
+	return err
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+/*
+ * Webhook notifications.
+ *
+ * Subscribers register a URL for the events 'carSold',
+ * 'recallFiled' and 'stolenReported' in the webhook file.
+ * Organizations receive the events of all cars, users
+ * only those of cars they own, sold or bought.
+ *
+ * Deliveries are stored before they are sent, so they
+ * survive restarts, and are retried with a growing
+ * backoff. Every body is signed with the secret of the
+ * webhook, 'X-Cartrade-Signature' carries the HMAC-SHA256
+ * of the body as 'sha256=<hex>'.
+ */
+
+// events subscribers can register for
+var webhookEvents = map[string]bool{"carSold": true, "recallFiled": true, "stolenReported": true}
+
+// subscriber kinds
+const subscriberUser string = "user"
+const subscriberOrg string = "org"
+
+// delivery states
+const deliveryPending string = "pending"
+const deliveryDelivered string = "delivered"
+const deliveryFailed string = "failed"
+
+// attempts before a delivery is given up
+const maxDeliveryAttempts int = 8
+
+// wait before the first retry, doubles with every attempt
+const deliveryBackoff = 30 * time.Second
+
+/*
+ * Webhook of a subscriber, see the webhook file
+ */
+type Webhook struct {
+	Id         string   `json:"id"`
+	Kind       string   `json:"kind"`       // 'user' or 'org'
+	Subscriber string   `json:"subscriber"` // username or organization
+	Url        string   `json:"url"`
+	Secret     string   `json:"secret"` // key of the body signature
+	Events     []string `json:"events"`
+}
+
+/*
+ * Body POSTed to the subscriber
+ */
+type WebhookBody struct {
+	Delivery string          `json:"delivery"`
+	Event    string          `json:"event"`
+	TxId     string          `json:"tx_id"`
+	Block    uint64          `json:"block"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+/*
+ * Fields of the event payloads that select the subscribers
+ */
+type eventParties struct {
+	Vin    string `json:"vin"`
+	Seller string `json:"seller"` // 'carSold' only
+	Buyer  string `json:"buyer"`  // 'carSold' only
+}
+
+/*
+ * Reads and checks the webhook file
+ */
+func loadWebhooks(path string) ([]Webhook, error) {
+	webhooksAsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []Webhook{}
+	err = json.Unmarshal(webhooksAsBytes, &webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("malformed webhook file '%s': %v", path, err)
+	}
+
+	ids := make(map[string]bool)
+	for _, webhook := range webhooks {
+		if webhook.Id == "" || ids[webhook.Id] {
+			return nil, fmt.Errorf("webhook ids must be unique and non-empty, got '%s'", webhook.Id)
+		} else if webhook.Kind != subscriberUser && webhook.Kind != subscriberOrg {
+			return nil, fmt.Errorf("webhook '%s' has unknown kind '%s'", webhook.Id, webhook.Kind)
+		} else if webhook.Url == "" || webhook.Secret == "" {
+			return nil, fmt.Errorf("webhook '%s' needs a url and a secret", webhook.Id)
+		}
+
+		for _, event := range webhook.Events {
+			if !webhookEvents[event] {
+				return nil, fmt.Errorf("webhook '%s' subscribes to unknown event '%s'", webhook.Id, event)
+			}
+		}
+		ids[webhook.Id] = true
+	}
+
+	return webhooks, nil
+}
+
+/*
+ * Checks if 'webhook' receives 'event' for a car owned by 'owner'
+ */
+func (webhook *Webhook) matches(event string, parties eventParties, owner string) bool {
+	subscribed := false
+	for _, name := range webhook.Events {
+		subscribed = subscribed || name == event
+	}
+
+	if !subscribed {
+		return false
+	} else if webhook.Kind == subscriberOrg {
+		return true
+	}
+
+	return webhook.Subscriber == owner || webhook.Subscriber == parties.Seller || webhook.Subscriber == parties.Buyer
+}
+
+/*
+ * Returns the signature header value of 'body'
+ */
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+ * Sends webhook deliveries
+ */
+type Dispatcher struct {
+	store    *Store
+	webhooks map[string]Webhook
+	client   *http.Client
+}
+
+func NewDispatcher(store *Store, webhooks []Webhook) *Dispatcher {
+	byId := make(map[string]Webhook)
+	for _, webhook := range webhooks {
+		byId[webhook.Id] = webhook
+	}
+
+	return &Dispatcher{store: store, webhooks: byId, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+/*
+ * Queues the deliveries of a chaincode event
+ */
+func (d *Dispatcher) Enqueue(txId string, event string, block uint64, payload []byte) error {
+	if !webhookEvents[event] || len(d.webhooks) == 0 {
+		return nil
+	}
+
+	parties := eventParties{}
+	err := json.Unmarshal(payload, &parties)
+	if err != nil {
+		return fmt.Errorf("malformed '%s' payload: %v", event, err)
+	}
+
+	owner, err := d.store.Owner(parties.Vin)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range d.webhooks {
+		if !webhook.matches(event, parties, owner) {
+			continue
+		}
+
+		id := make([]byte, 16)
+		rand.Read(id)
+		body := WebhookBody{Delivery: hex.EncodeToString(id), Event: event, TxId: txId, Block: block, Payload: payload}
+		bodyAsBytes, _ := json.Marshal(body)
+
+		err = d.store.AddDelivery(body.Delivery, webhook.Id, txId, event, bodyAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Sends one delivery, returns the error to retry on
+ */
+func (d *Dispatcher) send(webhook Webhook, delivery Delivery) error {
+	request, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Cartrade-Event", delivery.Event)
+	request.Header.Set("X-Cartrade-Delivery", delivery.Id)
+	request.Header.Set("X-Cartrade-Signature", sign(webhook.Secret, delivery.Body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("subscriber answered %s", response.Status)
+	}
+
+	return nil
+}
+
+/*
+ * Sends all due deliveries and schedules
+ * the retries of failed ones
+ */
+func (d *Dispatcher) dispatch(now time.Time) error {
+	deliveries, err := d.store.DueDeliveries(now.Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		webhook, ok := d.webhooks[delivery.Webhook]
+		if !ok {
+			// removed from the webhook file
+			err = d.store.FinishDelivery(delivery.Id, deliveryFailed, delivery.Attempts, "webhook removed", 0, 0)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		attempts := delivery.Attempts + 1
+		sendErr := d.send(webhook, delivery)
+		switch {
+		case sendErr == nil:
+			err = d.store.FinishDelivery(delivery.Id, deliveryDelivered, attempts, "", 0, now.Unix())
+		case attempts >= maxDeliveryAttempts:
+			log.Printf("Giving up delivery %s to webhook %s: %v", delivery.Id, webhook.Id, sendErr)
+			err = d.store.FinishDelivery(delivery.Id, deliveryFailed, attempts, sendErr.Error(), 0, 0)
+		default:
+			next := now.Add(deliveryBackoff << uint(attempts-1)).Unix()
+			err = d.store.FinishDelivery(delivery.Id, deliveryPending, attempts, sendErr.Error(), next, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Sends due deliveries every 'interval' until 'stop' is closed
+ */
+func (d *Dispatcher) Run(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := d.dispatch(now)
+			if err != nil {
+				log.Printf("Error dispatching webhooks: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	org := Webhook{Kind: subscriberOrg, Subscriber: "Org1", Events: []string{"recallFiled"}}
+	user := Webhook{Kind: subscriberUser, Subscriber: "bobby", Events: []string{"carSold", "stolenReported"}}
+
+	if !org.matches("recallFiled", eventParties{Vin: "WVWZZZ6R6HY260780"}, "amag") {
+		t.Error("Organizations should receive events of all cars")
+	}
+	if org.matches("carSold", eventParties{Vin: "WVWZZZ6R6HY260780"}, "amag") {
+		t.Error("Webhooks should only receive subscribed events")
+	}
+
+	if user.matches("stolenReported", eventParties{Vin: "WVWZZZ6R6HY260780"}, "amag") {
+		t.Error("Users should not receive events of other cars")
+	}
+	if !user.matches("stolenReported", eventParties{Vin: "WVWZZZ6R6HY260780"}, "bobby") {
+		t.Error("Users should receive events of their cars")
+	}
+	if !user.matches("carSold", eventParties{Vin: "WVWZZZ6R6HY260780", Seller: "amag", Buyer: "bobby"}, "amag") {
+		t.Error("Buyers should receive the sale, even before the projection moved the car")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13"
+	signature := sign("secret", []byte("{}"))
+	if signature != expected {
+		t.Errorf("Expected signature '%s', got '%s'", expected, signature)
+	}
+	if sign("other", []byte("{}")) == signature {
+		t.Error("Signatures with different secrets should differ")
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	os.WriteFile(path, []byte(`[{"id":"a","kind":"org","subscriber":"Org1","url":"https://dot.example","secret":"s","events":["recallFiled"]}]`), 0600)
+	webhooks, err := loadWebhooks(path)
+	if err != nil || len(webhooks) != 1 {
+		t.Fatalf("Expected one webhook, got %v, %v", webhooks, err)
+	}
+
+	os.WriteFile(path, []byte(`[{"id":"a","kind":"org","subscriber":"Org1","url":"https://dot.example","secret":"s","events":["carCreated"]}]`), 0600)
+	_, err = loadWebhooks(path)
+	if err == nil {
+		t.Error("Expected unknown events to be rejected")
+	}
+
+	os.WriteFile(path, []byte(`[{"id":"a","kind":"user","subscriber":"bobby","url":"https://bobby.example","events":["carSold"]}]`), 0600)
+	_, err = loadWebhooks(path)
+	if err == nil {
+		t.Error("Expected webhooks without secret to be rejected")
+	}
+}